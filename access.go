@@ -0,0 +1,93 @@
+package goproxy
+
+import (
+	"net/http"
+	"strings"
+)
+
+// authorizePrivateModule reports whether the req for the name is authorized if
+// it targets any of the [Goproxy.PrivateModules]. It responses to the client if
+// not. The returned [http.ResponseWriter] should be used in place of the rw to
+// keep responses for private modules out of shared caches.
+func (g *Goproxy) authorizePrivateModule(
+	rw http.ResponseWriter,
+	req *http.Request,
+	name string,
+) (http.ResponseWriter, bool) {
+	modulePath, ok := requestModulePath(name)
+	if !ok || !g.isPrivateModule(modulePath) {
+		return rw, true
+	}
+
+	if !g.privateModulesAuthorized(req) {
+		g.updateStats(func(s *Stats) { s.PrivateModuleDenials++ })
+		if req.Header.Get("Authorization") == "" {
+			responseUnauthorized(rw, req)
+		} else {
+			responseForbidden(rw, req, -1)
+		}
+
+		return rw, false
+	}
+
+	return &privateResponseWriter{ResponseWriter: rw}, true
+}
+
+// isPrivateModule reports whether the modulePath matches any of the
+// [Goproxy.PrivateModules].
+func (g *Goproxy) isPrivateModule(modulePath string) bool {
+	privateModules := g.settings().PrivateModules
+	return privateModules != "" &&
+		globsMatchPath(privateModules, modulePath)
+}
+
+// privateModulesAuthorized reports whether the req is authorized to access the
+// [Goproxy.PrivateModules].
+func (g *Goproxy) privateModulesAuthorized(req *http.Request) bool {
+	return g.PrivateModulesAuthorizer != nil &&
+		g.PrivateModulesAuthorizer(req)
+}
+
+// privateResponseWriter is an [http.ResponseWriter] that turns the public
+// Cache-Control of responses into a private one, so that shared caches do not
+// serve them to other clients.
+type privateResponseWriter struct {
+	http.ResponseWriter
+
+	wroteHeader bool
+}
+
+// WriteHeader implements the [http.ResponseWriter].
+func (prw *privateResponseWriter) WriteHeader(statusCode int) {
+	if !prw.wroteHeader {
+		prw.wroteHeader = true
+		header := prw.Header()
+		cacheControl := header.Get("Cache-Control")
+		if cc := strings.TrimPrefix(
+			cacheControl,
+			"public",
+		); cc != cacheControl {
+			header.Set("Cache-Control", "private"+cc)
+		}
+
+		header.Add("Vary", "Authorization")
+	}
+
+	prw.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write implements the [http.ResponseWriter].
+func (prw *privateResponseWriter) Write(b []byte) (int, error) {
+	if !prw.wroteHeader {
+		prw.WriteHeader(http.StatusOK)
+	}
+
+	return prw.ResponseWriter.Write(b)
+}
+
+// Flush implements the [http.Flusher].
+func (prw *privateResponseWriter) Flush() {
+	if f, ok := prw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
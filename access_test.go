@@ -0,0 +1,104 @@
+package goproxy
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGoproxyPrivateModules(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestGoproxyPrivateModules")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	g := &Goproxy{
+		GoBinEnv:       []string{"GOPROXY=off", "GOSUMDB=off"},
+		Cacher:         DirCacher(tempDir),
+		TempDir:        tempDir,
+		PrivateModules: "example.com/private",
+		PrivateModulesAuthorizer: func(req *http.Request) bool {
+			_, password, _ := req.BasicAuth()
+			return password == "secret"
+		},
+		ErrorLogger: log.New(&discardWriter{}, "", 0),
+	}
+
+	for _, modulePath := range []string{
+		"example.com/public",
+		"example.com/private",
+	} {
+		if err := g.Cacher.Put(
+			context.Background(),
+			modulePath+"/@v/v1.0.0.mod",
+			strings.NewReader("module "+modulePath),
+			time.Hour,
+		); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	for _, tt := range []struct {
+		name             string
+		password         string
+		wantCode         int
+		wantCacheControl string
+	}{
+		{
+			"example.com/public/@v/v1.0.0.mod",
+			"",
+			http.StatusOK,
+			"public, max-age=604800",
+		},
+		{
+			"example.com/private/@v/v1.0.0.mod",
+			"",
+			http.StatusUnauthorized,
+			"must-revalidate, no-cache, no-store",
+		},
+		{
+			"example.com/private/@v/v1.0.0.mod",
+			"wrong",
+			http.StatusForbidden,
+			"must-revalidate, no-cache, no-store",
+		},
+		{
+			"example.com/private/@v/v1.0.0.mod",
+			"secret",
+			http.StatusOK,
+			"private, max-age=604800",
+		},
+	} {
+		req := httptest.NewRequest("", "/"+tt.name, nil)
+		if tt.password != "" {
+			req.SetBasicAuth("user", tt.password)
+		}
+
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, req)
+		if got := rec.Code; got != tt.wantCode {
+			t.Errorf("got %d, want %d", got, tt.wantCode)
+		}
+
+		got := rec.Header().Get("Cache-Control")
+		if got != tt.wantCacheControl {
+			t.Errorf("got %q, want %q", got, tt.wantCacheControl)
+		}
+
+		if tt.wantCode == http.StatusUnauthorized &&
+			rec.Header().Get("WWW-Authenticate") == "" {
+			t.Error("expected WWW-Authenticate header")
+		}
+	}
+
+	if got, want := g.Stats().PrivateModuleDenials, int64(2); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
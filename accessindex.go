@@ -0,0 +1,269 @@
+package goproxy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// minAccessIndexCompactionRecords is the minimum number of records in the file
+// of an [AccessIndex] before it is compacted.
+const minAccessIndexCompactionRecords = 1024
+
+// AccessIndex is a persistent index of the last access times of caches, kept in
+// a local file, for eviction decisions (see the [QuotaCacher.AccessIndex]) that
+// do not rely on the access times maintained by file systems, which are not
+// maintained at all on file systems mounted with "noatime".
+//
+// Accesses are recorded in memory and appended to the file in batches by the
+// [AccessIndex.Flush], so that serving caches never waits for the file. Each
+// record is a line of the form "<unix-seconds> <name>", where a zero time
+// removes the name. Once most of the records are outdated, the file is
+// rewritten with only the current ones.
+//
+// Accesses recorded after the last flush are lost if the process exits
+// abruptly.
+type AccessIndex struct {
+	// Path is the path of the file.
+	Path string
+
+	// FlushInterval is the interval between two flushes made by the
+	// [AccessIndex.Run].
+	//
+	// If the FlushInterval is zero, one minute is used.
+	FlushInterval time.Duration
+
+	loadOnce sync.Once
+	loadErr  error
+	mutex    sync.Mutex
+	times    map[string]int64
+	pending  map[string]int64
+	records  int
+	torn     bool
+}
+
+// LastAccess returns the last recorded access time of the cache for the name.
+// It returns false if there is none.
+func (ai *AccessIndex) LastAccess(name string) (time.Time, bool) {
+	ai.loadOnce.Do(ai.load)
+
+	ai.mutex.Lock()
+	defer ai.mutex.Unlock()
+	t, ok := ai.pending[name]
+	if !ok {
+		t, ok = ai.times[name]
+	}
+
+	if !ok || t == 0 {
+		return time.Time{}, false
+	}
+
+	return time.Unix(t, 0), true
+}
+
+// Err returns the error, if any, that occurred while loading the file on first
+// use, in which case the index starts empty.
+func (ai *AccessIndex) Err() error {
+	ai.loadOnce.Do(ai.load)
+	return ai.loadErr
+}
+
+// Run flushes the ai periodically until the ctx is done, after which it is
+// flushed one last time. Flush errors are reported to the onError, if not nil.
+func (ai *AccessIndex) Run(ctx context.Context, onError func(err error)) error {
+	interval := ai.FlushInterval
+	if interval == 0 {
+		interval = time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+		}
+
+		if err := ai.Flush(); err != nil && onError != nil {
+			onError(err)
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// Flush appends the accesses recorded since the last flush to the file, or
+// rewrites the file if most of its records have become outdated.
+func (ai *AccessIndex) Flush() error {
+	ai.loadOnce.Do(ai.load)
+
+	ai.mutex.Lock()
+	defer ai.mutex.Unlock()
+	if len(ai.pending) == 0 {
+		return nil
+	}
+
+	for name, t := range ai.pending {
+		if t == 0 {
+			delete(ai.times, name)
+		} else {
+			ai.times[name] = t
+		}
+	}
+
+	records := ai.records + len(ai.pending)
+	if records >= minAccessIndexCompactionRecords &&
+		records > 2*len(ai.times) {
+		if err := ai.rewrite(); err != nil {
+			return err
+		}
+	} else if err := ai.append(); err != nil {
+		return err
+	}
+
+	ai.pending = map[string]int64{}
+
+	return nil
+}
+
+// touch records an access to the cache for the name.
+func (ai *AccessIndex) touch(name string) {
+	ai.loadOnce.Do(ai.load)
+
+	ai.mutex.Lock()
+	ai.pending[name] = time.Now().Unix()
+	ai.mutex.Unlock()
+}
+
+// forget removes the cache for the name from the ai.
+func (ai *AccessIndex) forget(name string) {
+	ai.loadOnce.Do(ai.load)
+
+	ai.mutex.Lock()
+	defer ai.mutex.Unlock()
+	if _, ok := ai.times[name]; ok {
+		ai.pending[name] = 0
+	} else {
+		delete(ai.pending, name)
+	}
+}
+
+// load loads the file of the ai.
+func (ai *AccessIndex) load() {
+	ai.times = map[string]int64{}
+	ai.pending = map[string]int64{}
+
+	b, err := ioutil.ReadFile(ai.Path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			ai.loadErr = err
+		}
+
+		return
+	}
+
+	// A record torn by a crash is skipped, and terminated so that the
+	// next appended record is not merged with it.
+	ai.torn = len(b) > 0 && b[len(b)-1] != '\n'
+
+	for _, line := range strings.Split(string(b), "\n") {
+		i := strings.IndexByte(line, ' ')
+		if i < 0 {
+			continue
+		}
+
+		ai.records++
+		t, err := strconv.ParseInt(line[:i], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		if name := line[i+1:]; t == 0 {
+			delete(ai.times, name)
+		} else {
+			ai.times[name] = t
+		}
+	}
+}
+
+// append appends the ai.pending to the file. It must be called with the
+// ai.mutex held.
+func (ai *AccessIndex) append() error {
+	f, err := os.OpenFile(
+		ai.Path,
+		os.O_WRONLY|os.O_CREATE|os.O_APPEND,
+		0640,
+	)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	if ai.torn {
+		w.WriteByte('\n')
+	}
+
+	for name, t := range ai.pending {
+		fmt.Fprintf(w, "%d %s\n", t, name)
+	}
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	ai.records += len(ai.pending)
+	ai.torn = false
+
+	return nil
+}
+
+// rewrite atomically replaces the file with the ai.times. It must be called
+// with the ai.mutex held.
+func (ai *AccessIndex) rewrite() error {
+	f, err := ioutil.TempFile(filepath.Dir(ai.Path), ".access-index")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	w := bufio.NewWriter(f)
+	for name, t := range ai.times {
+		fmt.Fprintf(w, "%d %s\n", t, name)
+	}
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(f.Name(), 0640); err != nil {
+		return err
+	}
+
+	if err := os.Rename(f.Name(), ai.Path); err != nil {
+		return err
+	}
+
+	ai.records = len(ai.times)
+	ai.torn = false
+
+	return nil
+}
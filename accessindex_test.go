@@ -0,0 +1,109 @@
+package goproxy
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccessIndex(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestAccessIndex")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	indexFile := filepath.Join(tempDir, "access-index")
+
+	// A record torn by a crash is skipped.
+	if err := ioutil.WriteFile(
+		indexFile,
+		[]byte("100 a\n200 b\n0 a\n300 c\n4"),
+		0640,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	ai := &AccessIndex{Path: indexFile}
+	if err := ai.Err(); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	for name, want := range map[string]int64{"a": 0, "b": 200, "c": 300} {
+		var got int64
+		if lastAccess, ok := ai.LastAccess(name); ok {
+			got = lastAccess.Unix()
+		}
+
+		if got != want {
+			t.Errorf("%s: got %d, want %d", name, got, want)
+		}
+	}
+
+	before := time.Now().Add(-time.Second)
+	ai.touch("a")
+	ai.forget("b")
+	ai.touch("d")
+	ai.forget("d")
+	if err := ai.Flush(); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	ai = &AccessIndex{Path: indexFile}
+	if lastAccess, ok := ai.LastAccess("a"); !ok {
+		t.Error("expected access time of a")
+	} else if lastAccess.Before(before) {
+		t.Errorf("got %s, want after %s", lastAccess, before)
+	}
+
+	for _, name := range []string{"b", "d"} {
+		if _, ok := ai.LastAccess(name); ok {
+			t.Errorf("%s: unexpected access time", name)
+		}
+	}
+
+	// Outdated records are compacted away.
+	for _, record := range []func(name string){ai.touch, ai.forget} {
+		for i := 0; i < minAccessIndexCompactionRecords; i++ {
+			record(fmt.Sprint("e", i))
+		}
+
+		if err := ai.Flush(); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	b, err := ioutil.ReadFile(indexFile)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := strings.Count(string(b), "\n"),
+		minAccessIndexCompactionRecords; got >= want {
+		t.Errorf("got %d records, want < %d", got, want)
+	}
+
+	ai = &AccessIndex{Path: indexFile}
+	for _, name := range []string{"a", "c"} {
+		if _, ok := ai.LastAccess(name); !ok {
+			t.Errorf("%s: expected access time", name)
+		}
+	}
+
+	ai.touch("f")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := ai.Run(ctx, func(err error) {
+		t.Errorf("unexpected error %q", err)
+	}); err != context.Canceled {
+		t.Errorf("got error %q, want error %q", err, context.Canceled)
+	}
+
+	ai = &AccessIndex{Path: indexFile}
+	if _, ok := ai.LastAccess("f"); !ok {
+		t.Error("expected access time of f")
+	}
+}
@@ -0,0 +1,222 @@
+package goproxy
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// apiPathPrefix is the prefix of all request paths (after being trimmed by the
+// [Goproxy.PathPrefix]) that are served by the Goproxy itself instead of being
+// treated as module proxy requests. It is safe to use since a module path can
+// never start with a dash.
+const apiPathPrefix = "-/"
+
+// serveAPI serves API requests.
+func (g *Goproxy) serveAPI(rw http.ResponseWriter, req *http.Request, name string) {
+	switch strings.TrimPrefix(name, apiPathPrefix) {
+	case "explain":
+		if checkAPIMethod(rw, req, http.MethodGet, http.MethodHead) &&
+			g.authorizeAdmin(rw, req) {
+			g.serveExplain(rw, req)
+		}
+	case "purge":
+		if checkAPIMethod(rw, req, http.MethodPost, http.MethodDelete) &&
+			g.authorizeAdmin(rw, req) {
+			g.servePurge(rw, req)
+		}
+	case "restore":
+		if checkAPIMethod(rw, req, http.MethodPost) &&
+			g.authorizeAdmin(rw, req) {
+			g.serveRestore(rw, req)
+		}
+	case "caches":
+		if checkAPIMethod(rw, req, http.MethodGet, http.MethodHead) &&
+			g.authorizeAdmin(rw, req) {
+			g.serveCaches(rw, req)
+		}
+	case "settings":
+		if checkAPIMethod(
+			rw,
+			req,
+			http.MethodGet,
+			http.MethodHead,
+			http.MethodPatch,
+		) && g.authorizeAdmin(rw, req) {
+			g.serveSettings(rw, req)
+		}
+	case "stats":
+		if checkAPIMethod(rw, req, http.MethodGet, http.MethodHead) &&
+			g.authorizeAdmin(rw, req) {
+			g.serveStats(rw, req)
+		}
+	case "metrics":
+		if checkAPIMethod(rw, req, http.MethodGet, http.MethodHead) &&
+			g.authorizeAdmin(rw, req) {
+			g.serveMetrics(rw, req)
+		}
+	case "version":
+		if checkAPIMethod(rw, req, http.MethodGet, http.MethodHead) &&
+			g.authorizeAdmin(rw, req) {
+			g.serveVersion(rw, req)
+		}
+	case "list-provenance":
+		if checkAPIMethod(rw, req, http.MethodGet, http.MethodHead) &&
+			g.authorizeAdmin(rw, req) {
+			g.serveListProvenance(rw, req)
+		}
+	case "gone":
+		if checkAPIMethod(rw, req, http.MethodGet, http.MethodHead) &&
+			g.authorizeAdmin(rw, req) {
+			g.serveGoneFlags(rw, req)
+		}
+	case "go-commands":
+		if checkAPIMethod(rw, req, http.MethodGet, http.MethodHead) &&
+			g.authorizeAdmin(rw, req) {
+			g.serveGoCommands(rw, req)
+		}
+	case "freshness":
+		if checkAPIMethod(rw, req, http.MethodGet, http.MethodHead) &&
+			g.authorizeAdmin(rw, req) {
+			g.serveFreshness(rw, req)
+		}
+	case "quota":
+		if checkAPIMethod(rw, req, http.MethodGet, http.MethodHead) &&
+			g.authorizeAdmin(rw, req) {
+			g.serveQuota(rw, req)
+		}
+	case "upstream-usage":
+		if checkAPIMethod(rw, req, http.MethodGet, http.MethodHead) &&
+			g.authorizeAdmin(rw, req) {
+			g.serveUpstreamUsage(rw, req)
+		}
+	case "module-downloads":
+		if checkAPIMethod(rw, req, http.MethodGet, http.MethodHead) &&
+			g.authorizeAdmin(rw, req) {
+			g.serveModuleDownloads(rw, req)
+		}
+	case "warm":
+		if checkAPIMethod(
+			rw,
+			req,
+			http.MethodGet,
+			http.MethodHead,
+			http.MethodPost,
+		) && g.authorizeAdmin(rw, req) {
+			g.serveWarm(rw, req)
+		}
+	case "watch":
+		if checkAPIMethod(rw, req, http.MethodGet) &&
+			g.authorizeAdmin(rw, req) {
+			g.serveWatch(rw, req)
+		}
+	case "prefetches":
+		if checkAPIMethod(
+			rw,
+			req,
+			http.MethodGet,
+			http.MethodHead,
+			http.MethodPost,
+			http.MethodDelete,
+		) && g.authorizeAdmin(rw, req) {
+			g.servePrefetches(rw, req)
+		}
+	case "pins":
+		if checkAPIMethod(
+			rw,
+			req,
+			http.MethodGet,
+			http.MethodHead,
+			http.MethodPost,
+			http.MethodDelete,
+		) && g.authorizeAdmin(rw, req) {
+			g.servePins(rw, req)
+		}
+	case "config":
+		// The config is sanitized for clients, so it is not
+		// restricted to administrators.
+		if checkAPIMethod(rw, req, http.MethodGet, http.MethodHead) {
+			g.serveConfig(rw, req)
+		}
+	case "zip-delta":
+		// Zip deltas only serve what any client could download as
+		// whole ".zip" files, so they are not restricted to
+		// administrators.
+		if checkAPIMethod(rw, req, http.MethodGet, http.MethodHead) {
+			g.serveZipDelta(rw, req)
+		}
+	case "go-mod":
+		// Go mod summaries only report what any client could download
+		// as whole ".mod" files, so they are not restricted to
+		// administrators.
+		if checkAPIMethod(rw, req, http.MethodGet, http.MethodHead) {
+			g.serveGoMod(rw, req)
+		}
+	case "lookup":
+		// Batch lookups only report what any client could request
+		// one by one, so they are not restricted to administrators.
+		if checkAPIMethod(rw, req, http.MethodPost) {
+			g.serveLookup(rw, req)
+		}
+	default:
+		responseNotFound(rw, req, 86400)
+	}
+}
+
+// checkAPIMethod reports whether the method of the req is one of the methods.
+// It responses to the client if not.
+func checkAPIMethod(
+	rw http.ResponseWriter,
+	req *http.Request,
+	methods ...string,
+) bool {
+	if !stringSliceContains(methods, req.Method) {
+		rw.Header().Set("Allow", strings.Join(methods, ", "))
+		responseMethodNotAllowed(rw, req, -2)
+		return false
+	}
+
+	return true
+}
+
+// authorizeAdmin reports whether the req is authorized to access the
+// administrative endpoints. It responses to the client if not.
+func (g *Goproxy) authorizeAdmin(rw http.ResponseWriter, req *http.Request) bool {
+	if g.AdminAuthorizer == nil {
+		responseNotFound(rw, req, -2)
+		return false
+	}
+
+	if !g.AdminAuthorizer(req) {
+		responseForbidden(rw, req, -2)
+		return false
+	}
+
+	return true
+}
+
+// serveAdminError serves the err that occurred while trying to perform the
+// action for an administrative request.
+func (g *Goproxy) serveAdminError(
+	rw http.ResponseWriter,
+	req *http.Request,
+	action string,
+	err error,
+) {
+	switch {
+	case errors.Is(err, errNotFound):
+		responseNotFound(rw, req, -2, err)
+	case errors.Is(err, errDeleteNotSupported),
+		errors.Is(err, errWalkNotSupported):
+		responseString(
+			rw,
+			req,
+			http.StatusNotImplemented,
+			-2,
+			err.Error(),
+		)
+	default:
+		g.logRequestErrorf(req, "failed to %s: %v", action, err)
+		responseInternalServerError(rw, req)
+	}
+}
@@ -0,0 +1,45 @@
+package goproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGoproxyServeAPI(t *testing.T) {
+	g := &Goproxy{GoBinEnv: []string{}}
+	g.init()
+
+	req := httptest.NewRequest("", "/-/explain?module=example.com", nil)
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, req)
+	if got, want := rec.Code, http.StatusNotFound; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	g.AdminAuthorizer = func(req *http.Request) bool {
+		return req.Header.Get("Authorization") == "Bearer secret"
+	}
+
+	req = httptest.NewRequest("", "/-/explain?module=example.com", nil)
+	rec = httptest.NewRecorder()
+	g.ServeHTTP(rec, req)
+	if got, want := rec.Code, http.StatusForbidden; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	req = httptest.NewRequest("", "/-/explain?module=example.com", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	g.ServeHTTP(rec, req)
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	req = httptest.NewRequest("", "/-/unknown", nil)
+	rec = httptest.NewRecorder()
+	g.ServeHTTP(rec, req)
+	if got, want := rec.Code, http.StatusNotFound; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
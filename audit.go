@@ -0,0 +1,136 @@
+package goproxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// defaultBuildIDHeader is the default name of the request header that
+// identifies the build that a request is made for.
+const defaultBuildIDHeader = "X-Build-ID"
+
+// maxBuildIDBytes is the maximum number of bytes of a build identifier recorded
+// in an [ArtifactDownload]. Longer ones are truncated.
+const maxBuildIDBytes = 256
+
+// ArtifactDownload is a recorded request for a module file (".info", ".mod" or
+// ".zip") served by a [Goproxy], for linking builds to the exact artifacts that
+// they downloaded (see the [Goproxy.OnArtifactDownload]).
+type ArtifactDownload struct {
+	// Time is when the request was received.
+	Time time.Time
+
+	// Name is the name of the module file, e.g.
+	// "example.com/foo/@v/v1.0.0.zip".
+	Name string
+
+	// ModulePath and ModuleVersion are the module path and the module
+	// version of the module file.
+	ModulePath    string
+	ModuleVersion string
+
+	// BuildID is the build identifier supplied by the client via the
+	// [Goproxy.BuildIDHeader], if any.
+	BuildID string `json:",omitempty"`
+
+	// Client is the IP address of the client.
+	Client string
+
+	// StatusCode is the status code of the response.
+	StatusCode int
+
+	// ETag is the ETag of the response, if any, which identifies the
+	// content served.
+	ETag string `json:",omitempty"`
+
+	// CacheHit indicates whether the module file was served from the
+	// cache.
+	CacheHit bool
+}
+
+// JSONArtifactDownloadLogger returns a function suitable for the
+// [Goproxy.OnArtifactDownload] that writes each [ArtifactDownload] to the w as
+// a line of JSON. Errors writing to the w are ignored.
+func JSONArtifactDownloadLogger(w io.Writer) func(ad *ArtifactDownload) {
+	var mutex sync.Mutex
+	return func(ad *ArtifactDownload) {
+		b, err := json.Marshal(ad)
+		if err != nil {
+			return
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		w.Write(append(b, '\n'))
+	}
+}
+
+// buildID returns the build identifier supplied by the client of the req via
+// the [Goproxy.BuildIDHeader].
+func (g *Goproxy) buildID(req *http.Request) string {
+	header := g.BuildIDHeader
+	if header == "" {
+		header = defaultBuildIDHeader
+	}
+
+	buildID := req.Header.Get(header)
+	if len(buildID) > maxBuildIDBytes {
+		buildID = buildID[:maxBuildIDBytes]
+	}
+
+	return buildID
+}
+
+// recordArtifactDownload reports the request for the module file of the f to
+// the [Goproxy.OnArtifactDownload] once it has been served via the arw.
+func (g *Goproxy) recordArtifactDownload(
+	req *http.Request,
+	f *fetch,
+	start time.Time,
+	arw *artifactResponseWriter,
+	hit bool,
+) {
+	statusCode := arw.statusCode
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+
+	g.OnArtifactDownload(&ArtifactDownload{
+		Time:          start,
+		Name:          f.name,
+		ModulePath:    f.modulePath,
+		ModuleVersion: f.moduleVersion,
+		BuildID:       g.buildID(req),
+		Client:        remoteIP(req),
+		StatusCode:    statusCode,
+		ETag:          arw.Header().Get("ETag"),
+		CacheHit:      hit,
+	})
+}
+
+// artifactResponseWriter is an [http.ResponseWriter] that records the status
+// code of the response for an [ArtifactDownload].
+type artifactResponseWriter struct {
+	http.ResponseWriter
+
+	statusCode int
+}
+
+// WriteHeader implements the [http.ResponseWriter].
+func (arw *artifactResponseWriter) WriteHeader(statusCode int) {
+	if arw.statusCode == 0 {
+		arw.statusCode = statusCode
+	}
+
+	arw.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Flush implements the [http.Flusher].
+func (arw *artifactResponseWriter) Flush() {
+	if f, ok := arw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,156 @@
+package goproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestGoproxyOnArtifactDownload(t *testing.T) {
+	tempDir, err := ioutil.TempDir(
+		"",
+		"goproxy.TestGoproxyOnArtifactDownload",
+	)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	server := httptest.NewServer(http.HandlerFunc(func(
+		rw http.ResponseWriter,
+		req *http.Request,
+	) {
+		if req.URL.Path == "/example.com/@v/v1.0.0.mod" {
+			responseString(
+				rw,
+				req,
+				http.StatusOK,
+				-2,
+				"module example.com",
+			)
+			return
+		}
+
+		responseNotFound(rw, req, -2)
+	}))
+	defer server.Close()
+
+	var (
+		buf   bytes.Buffer
+		mutex sync.Mutex
+	)
+	logger := JSONArtifactDownloadLogger(&buf)
+	g := &Goproxy{
+		GoBinEnv: []string{
+			"GOPROXY=" + server.URL,
+			"GOSUMDB=off",
+		},
+		Cacher:        DirCacher(tempDir),
+		TempDir:       tempDir,
+		BuildIDHeader: "X-CI-Job",
+		OnArtifactDownload: func(ad *ArtifactDownload) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			logger(ad)
+		},
+		ErrorLogger: log.New(&discardWriter{}, "", 0),
+	}
+
+	for _, tt := range []struct {
+		name    string
+		buildID string
+		header  http.Header
+	}{
+		{"example.com/@v/v1.0.0.mod", "job-1", nil},
+		{"example.com/@v/v1.0.0.mod", strings.Repeat("x", 1000), nil},
+		{"example.com/@v/v1.1.0.mod", "", nil},
+		{
+			"example.com/@v/v1.2.0.mod",
+			"job-2",
+			http.Header{"Disable-Module-Fetch": {"true"}},
+		},
+		{"example.com/@latest", "job-3", nil},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/"+tt.name, nil)
+		for k, v := range tt.header {
+			req.Header[k] = v
+		}
+
+		if tt.buildID != "" {
+			req.Header.Set("X-CI-Job", tt.buildID)
+		}
+
+		g.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	var ads []ArtifactDownload
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	for _, line := range lines {
+		var ad ArtifactDownload
+		if err := json.Unmarshal([]byte(line), &ad); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		ads = append(ads, ad)
+	}
+
+	if got, want := len(ads), 4; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	for n, want := range []ArtifactDownload{
+		{
+			Name:          "example.com/@v/v1.0.0.mod",
+			ModulePath:    "example.com",
+			ModuleVersion: "v1.0.0",
+			BuildID:       "job-1",
+			Client:        "192.0.2.1",
+			StatusCode:    http.StatusOK,
+		},
+		{
+			Name:          "example.com/@v/v1.0.0.mod",
+			ModulePath:    "example.com",
+			ModuleVersion: "v1.0.0",
+			BuildID:       strings.Repeat("x", maxBuildIDBytes),
+			Client:        "192.0.2.1",
+			StatusCode:    http.StatusOK,
+			CacheHit:      true,
+		},
+		{
+			Name:          "example.com/@v/v1.1.0.mod",
+			ModulePath:    "example.com",
+			ModuleVersion: "v1.1.0",
+			Client:        "192.0.2.1",
+			StatusCode:    http.StatusNotFound,
+		},
+		{
+			Name:          "example.com/@v/v1.2.0.mod",
+			ModulePath:    "example.com",
+			ModuleVersion: "v1.2.0",
+			BuildID:       "job-2",
+			Client:        "192.0.2.1",
+			StatusCode:    http.StatusNotFound,
+		},
+	} {
+		got := ads[n]
+		if got.Time.IsZero() {
+			t.Errorf("test(%d): unexpected zero Time", n)
+		}
+
+		if want.CacheHit && got.ETag == "" {
+			t.Errorf("test(%d): unexpected empty ETag", n)
+		}
+
+		got.Time, got.ETag = want.Time, want.ETag
+		if got != want {
+			t.Errorf("test(%d): got %+v, want %+v", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,618 @@
+package goproxy
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// azureBlobAPIVersion is the version of the Azure Blob Storage REST API
+	// used by the [AzureBlobCacher].
+	azureBlobAPIVersion = "2020-10-02"
+
+	// azureBlobExpiresHeader is the header of the metadata of the blobs
+	// stored by the [AzureBlobCacher] that holds their expiration times in
+	// Unix seconds.
+	azureBlobExpiresHeader = "X-Ms-Meta-Goproxyexpires"
+
+	// defaultAzureIdentityEndpoint is the default endpoint of the Azure
+	// Instance Metadata Service that issues tokens for managed identities.
+	defaultAzureIdentityEndpoint = "http://169.254.169.254" +
+		"/metadata/identity/oauth2/token"
+)
+
+// AzureBlobCacher implements the [Cacher] using a container of Azure Blob
+// Storage. Requests are authorized with either a shared access signature (see
+// the [AzureBlobCacher.SASToken]) or a managed identity (see the
+// [AzureBlobCacher.ManagedIdentity]).
+//
+// The expiration of each cache is stored in the metadata of its blob, and
+// expired blobs are treated as not found. They are removed by the
+// [AzureBlobCacher.Cleanup]. Lifecycle management policies of the storage
+// account may be used as well to delete blobs server-side.
+//
+// The contents returned by the [AzureBlobCacher.Get] implement the [io.Seeker]
+// via ranged GETs issued lazily on reads, so that Range requests are served
+// without downloading whole blobs. They also implement the ETag() and the
+// LastModified() (see the [Cacher.Get]).
+//
+// Make sure that all fields of the AzureBlobCacher have been finalized before
+// calling any of its methods.
+type AzureBlobCacher struct {
+	// AccountURL is the URL of the Blob service of the storage account,
+	// such as "https://myaccount.blob.core.windows.net".
+	AccountURL string
+
+	// Container is the name of the container.
+	Container string
+
+	// Prefix is the prefix of the blob names, such as "goproxy/".
+	Prefix string
+
+	// SASToken is the shared access signature token (without the leading
+	// "?") appended to all request URLs.
+	SASToken string
+
+	// ManagedIdentity indicates whether to authorize requests with tokens
+	// of the managed identity of the host. It has no effect if the
+	// [AzureBlobCacher.SASToken] is not empty.
+	ManagedIdentity bool
+
+	// ManagedIdentityClientID is the client ID of the user-assigned
+	// managed identity to use.
+	//
+	// If the ManagedIdentityClientID is empty, the system-assigned managed
+	// identity is used.
+	ManagedIdentityClientID string
+
+	// IdentityEndpoint is the endpoint that issues tokens for the managed
+	// identity.
+	//
+	// If the IdentityEndpoint is empty, the Azure Instance Metadata Service
+	// is used.
+	IdentityEndpoint string
+
+	// Transport is used to send requests to the Blob service and the
+	// [AzureBlobCacher.IdentityEndpoint].
+	//
+	// If the Transport is nil, the [http.DefaultTransport] is used.
+	Transport http.RoundTripper
+
+	tokenMutex     sync.Mutex
+	token          string
+	tokenExpiresAt time.Time
+}
+
+// Get implements the [Cacher].
+func (abc *AzureBlobCacher) Get(
+	ctx context.Context,
+	name string,
+) (io.ReadCloser, error) {
+	blobName := abc.blobName(name)
+	res, err := abc.do(ctx, http.MethodHead, blobName, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+
+	if azureBlobExpired(res.Header, expiryNow(ctx)) {
+		return nil, os.ErrNotExist
+	}
+
+	content := &azureBlobContent{
+		ctx:      ctx,
+		abc:      abc,
+		blobName: blobName,
+		size:     res.ContentLength,
+		etag:     res.Header.Get("ETag"),
+	}
+	lastModified := res.Header.Get("Last-Modified")
+	content.lastModified, _ = http.ParseTime(lastModified)
+	if expires, err := strconv.ParseInt(
+		res.Header.Get(azureBlobExpiresHeader),
+		10,
+		64,
+	); err == nil {
+		content.expires = time.Unix(expires, 0)
+	}
+
+	return content, nil
+}
+
+// Put implements the [Cacher].
+func (abc *AzureBlobCacher) Put(
+	ctx context.Context,
+	name string,
+	content io.ReadSeeker,
+	expiration time.Duration,
+) error {
+	size, err := content.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	} else if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	header.Set("X-Ms-Blob-Type", "BlockBlob")
+	header.Set(azureBlobExpiresHeader, strconv.FormatInt(
+		time.Now().Add(expiration).Unix(),
+		10,
+	))
+
+	res, err := abc.do(
+		ctx,
+		http.MethodPut,
+		abc.blobName(name),
+		nil,
+		header,
+		&azureBlobBody{ReadSeeker: content, size: size},
+	)
+	if err != nil {
+		return err
+	}
+
+	return res.Body.Close()
+}
+
+// Delete implements the [Deleter].
+func (abc *AzureBlobCacher) Delete(ctx context.Context, name string) error {
+	res, err := abc.do(
+		ctx,
+		http.MethodDelete,
+		abc.blobName(name),
+		nil,
+		nil,
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+
+	return res.Body.Close()
+}
+
+// Cleanup implements the [Cacher].
+func (abc *AzureBlobCacher) Cleanup() error {
+	return abc.CleanupReclaimed(nil)
+}
+
+// CleanupReclaimed implements the [Reclaimer].
+func (abc *AzureBlobCacher) CleanupReclaimed(
+	reclaimed func(name string, size int64),
+) error {
+	ctx := context.Background()
+	now := time.Now()
+	return abc.listBlobs(func(blob azureBlob) error {
+		if !blob.expired(now) {
+			return nil
+		}
+
+		name := strings.TrimPrefix(blob.Name, abc.blobName(""))
+		if err := abc.Delete(ctx, name); err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+
+			return err
+		}
+
+		if reclaimed != nil {
+			reclaimed(name, blob.Properties.ContentLength)
+		}
+
+		return nil
+	})
+}
+
+// List implements the [Lister].
+func (abc *AzureBlobCacher) List(
+	ctx context.Context,
+	prefix string,
+) CacheIterator {
+	return listCaches(ctx, abc.walkCaches, prefix)
+}
+
+// walkCaches implements the [cacheWalker].
+func (abc *AzureBlobCacher) walkCaches(
+	fn func(name string, size int64) error,
+) error {
+	prefix := abc.blobName("")
+	return abc.listBlobs(func(blob azureBlob) error {
+		return fn(
+			strings.TrimPrefix(blob.Name, prefix),
+			blob.Properties.ContentLength,
+		)
+	})
+}
+
+// azureBlob is a blob listed by the [AzureBlobCacher].
+type azureBlob struct {
+	Name       string
+	Properties struct {
+		ContentLength int64 `xml:"Content-Length"`
+	}
+	Metadata struct {
+		Expires string `xml:"goproxyexpires"`
+	}
+}
+
+// expired reports whether the ab has expired at the now.
+func (ab azureBlob) expired(now time.Time) bool {
+	expires, err := strconv.ParseInt(ab.Metadata.Expires, 10, 64)
+	return err == nil && now.Unix() >= expires
+}
+
+// listBlobs calls the fn for each blob under the [AzureBlobCacher.Prefix].
+func (abc *AzureBlobCacher) listBlobs(fn func(blob azureBlob) error) error {
+	query := url.Values{}
+	query.Set("restype", "container")
+	query.Set("comp", "list")
+	query.Set("include", "metadata")
+	if prefix := abc.blobName(""); prefix != "" {
+		query.Set("prefix", prefix)
+	}
+
+	for {
+		res, err := abc.do(
+			context.Background(),
+			http.MethodGet,
+			"",
+			query,
+			nil,
+			nil,
+		)
+		if err != nil {
+			return err
+		}
+
+		var result struct {
+			Blobs struct {
+				Blob []azureBlob
+			}
+			NextMarker string
+		}
+		err = xml.NewDecoder(res.Body).Decode(&result)
+		res.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		for _, blob := range result.Blobs.Blob {
+			if err := fn(blob); err != nil {
+				return err
+			}
+		}
+
+		if result.NextMarker == "" {
+			return nil
+		}
+
+		query.Set("marker", result.NextMarker)
+	}
+}
+
+// blobName returns the blob name of the cache for the name.
+func (abc *AzureBlobCacher) blobName(name string) string {
+	if abc.Prefix == "" {
+		return name
+	}
+
+	return strings.TrimSuffix(abc.Prefix, "/") + "/" + name
+}
+
+// blobURL returns the URL of the blob targeted by the blobName, or of the
+// container if the blobName is empty.
+func (abc *AzureBlobCacher) blobURL(
+	blobName string,
+	query url.Values,
+) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSuffix(abc.AccountURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+
+	u.Path += "/" + abc.Container
+	if blobName != "" {
+		u.Path += "/" + blobName
+	}
+
+	u.RawPath = ""
+	u.RawQuery = query.Encode()
+	if sasToken := strings.TrimPrefix(abc.SASToken, "?"); sasToken != "" {
+		if u.RawQuery != "" {
+			u.RawQuery += "&"
+		}
+
+		u.RawQuery += sasToken
+	}
+
+	return u, nil
+}
+
+// client returns the [http.Client] of the abc.
+func (abc *AzureBlobCacher) client() *http.Client {
+	transport := abc.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	return &http.Client{Transport: transport}
+}
+
+// do sends a request with the method for the blob targeted by the blobName (or
+// for the container if the blobName is empty), with the query, the header and
+// the body. It returns the [os.ErrNotExist] if the blob is not found, and an
+// error for any other non-2xx response.
+func (abc *AzureBlobCacher) do(
+	ctx context.Context,
+	method string,
+	blobName string,
+	query url.Values,
+	header http.Header,
+	body *azureBlobBody,
+) (*http.Response, error) {
+	u, err := abc.blobURL(blobName, query)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+
+	req.Header.Set("X-Ms-Version", azureBlobAPIVersion)
+	if body != nil {
+		req.Body = ioutil.NopCloser(body)
+		req.ContentLength = body.size
+		if body.size == 0 {
+			// A zero ContentLength with a non-nil body means
+			// unknown, which the Blob service does not accept.
+			req.Body = http.NoBody
+		}
+	}
+
+	if abc.SASToken == "" && abc.ManagedIdentity {
+		token, err := abc.managedIdentityToken(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := abc.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return res, nil
+	}
+
+	b, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+	res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, os.ErrNotExist
+	}
+
+	return nil, fmt.Errorf(
+		"azure blob: %s %s: %s: %s",
+		method,
+		redactedURL(u),
+		res.Status,
+		b,
+	)
+}
+
+// managedIdentityToken returns an access token of the managed identity for the
+// Azure Storage, reusing the last one until shortly before it expires.
+func (abc *AzureBlobCacher) managedIdentityToken(
+	ctx context.Context,
+) (string, error) {
+	abc.tokenMutex.Lock()
+	defer abc.tokenMutex.Unlock()
+	if abc.token != "" && time.Now().Before(abc.tokenExpiresAt) {
+		return abc.token, nil
+	}
+
+	endpoint := abc.IdentityEndpoint
+	if endpoint == "" {
+		endpoint = defaultAzureIdentityEndpoint
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+
+	query := u.Query()
+	query.Set("api-version", "2018-02-01")
+	query.Set("resource", "https://storage.azure.com/")
+	if abc.ManagedIdentityClientID != "" {
+		query.Set("client_id", abc.ManagedIdentityClientID)
+	}
+
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Metadata", "true")
+
+	res, err := abc.client().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		return "", fmt.Errorf(
+			"azure blob: managed identity token: %s: %s",
+			res.Status,
+			b,
+		)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresOn   string `json:"expires_on"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return "", err
+	} else if token.AccessToken == "" {
+		return "", errors.New("azure blob: missing access token")
+	}
+
+	// Tokens without a valid expiration are used for a minute only.
+	expiresAt := time.Now().Add(time.Minute)
+	if expiresOn, err := strconv.ParseInt(
+		token.ExpiresOn,
+		10,
+		64,
+	); err == nil {
+		expiresAt = time.Unix(expiresOn, 0).Add(-5 * time.Minute)
+	}
+
+	abc.token = token.AccessToken
+	abc.tokenExpiresAt = expiresAt
+
+	return abc.token, nil
+}
+
+// azureBlobBody is the body of a request sent by the [AzureBlobCacher].
+type azureBlobBody struct {
+	io.ReadSeeker
+
+	size int64
+}
+
+// azureBlobContent is the content of a cache got by the [AzureBlobCacher]. It
+// reads the blob via ranged GETs starting from its current offset.
+type azureBlobContent struct {
+	ctx          context.Context
+	abc          *AzureBlobCacher
+	blobName     string
+	size         int64
+	etag         string
+	lastModified time.Time
+	expires      time.Time
+	offset       int64
+	body         io.ReadCloser
+}
+
+// Read implements the [io.Reader].
+func (bc *azureBlobContent) Read(b []byte) (int, error) {
+	if bc.body == nil {
+		if bc.offset >= bc.size {
+			return 0, io.EOF
+		}
+
+		header := http.Header{}
+		header.Set("Range", fmt.Sprintf("bytes=%d-", bc.offset))
+		if bc.etag != "" {
+			// Make sure that the blob has not been replaced since
+			// being got.
+			header.Set("If-Match", bc.etag)
+		}
+
+		res, err := bc.abc.do(
+			bc.ctx,
+			http.MethodGet,
+			bc.blobName,
+			nil,
+			header,
+			nil,
+		)
+		if err != nil {
+			return 0, err
+		}
+
+		bc.body = res.Body
+	}
+
+	n, err := bc.body.Read(b)
+	bc.offset += int64(n)
+	return n, err
+}
+
+// Seek implements the [io.Seeker].
+func (bc *azureBlobContent) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += bc.offset
+	case io.SeekEnd:
+		offset += bc.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	if offset != bc.offset && bc.body != nil {
+		bc.body.Close()
+		bc.body = nil
+	}
+
+	bc.offset = offset
+
+	return offset, nil
+}
+
+// Close implements the [io.Closer].
+func (bc *azureBlobContent) Close() error {
+	if bc.body != nil {
+		return bc.body.Close()
+	}
+
+	return nil
+}
+
+// ETag returns the ETag of the blob of the bc.
+func (bc *azureBlobContent) ETag() string {
+	return bc.etag
+}
+
+// LastModified returns the last modification time of the blob of the bc.
+func (bc *azureBlobContent) LastModified() time.Time {
+	return bc.lastModified
+}
+
+// Expires returns the expiration time of the blob of the bc.
+func (bc *azureBlobContent) Expires() time.Time {
+	return bc.expires
+}
+
+// azureBlobExpired reports whether the blob whose response header is the
+// header has expired at the now.
+func azureBlobExpired(header http.Header, now time.Time) bool {
+	expires, err := strconv.ParseInt(
+		header.Get(azureBlobExpiresHeader),
+		10,
+		64,
+	)
+	return err == nil && now.Unix() >= expires
+}
@@ -0,0 +1,315 @@
+package goproxy
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAzureBlob is a blob stored by a [fakeAzureBlobService].
+type fakeAzureBlob struct {
+	content []byte
+	expires string
+	etag    string
+}
+
+// fakeAzureBlobService is a minimal Azure Blob Storage service for testing.
+type fakeAzureBlobService struct {
+	mutex      sync.Mutex
+	authorized func(req *http.Request) bool
+	blobs      map[string]fakeAzureBlob
+	rangedGets int
+}
+
+func (fabs *fakeAzureBlobService) ServeHTTP(
+	rw http.ResponseWriter,
+	req *http.Request,
+) {
+	fabs.mutex.Lock()
+	defer fabs.mutex.Unlock()
+
+	if !fabs.authorized(req) {
+		rw.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	if req.URL.Path == "/container" {
+		fabs.serveList(rw, req)
+		return
+	}
+
+	name := strings.TrimPrefix(req.URL.Path, "/container/")
+	switch req.Method {
+	case http.MethodHead, http.MethodGet:
+		blob, ok := fabs.blobs[name]
+		if !ok {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		rw.Header().Set("ETag", blob.etag)
+		rw.Header().Set(azureBlobExpiresHeader, blob.expires)
+		rw.Header().Set(
+			"Last-Modified",
+			time.Unix(1e9, 0).UTC().Format(http.TimeFormat),
+		)
+		if req.Method == http.MethodHead {
+			rw.Header().Set(
+				"Content-Length",
+				strconv.Itoa(len(blob.content)),
+			)
+			return
+		}
+
+		if ifMatch := req.Header.Get("If-Match"); ifMatch != "" &&
+			ifMatch != blob.etag {
+			rw.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+
+		var start int
+		fmt.Sscanf(req.Header.Get("Range"), "bytes=%d-", &start)
+		if start > 0 {
+			fabs.rangedGets++
+		}
+
+		rw.Write(blob.content[start:])
+	case http.MethodPut:
+		if req.Header.Get("X-Ms-Blob-Type") != "BlockBlob" {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		b, _ := ioutil.ReadAll(req.Body)
+		fabs.blobs[name] = fakeAzureBlob{
+			content: b,
+			expires: req.Header.Get(azureBlobExpiresHeader),
+			etag:    fmt.Sprintf(`"%d"`, len(fabs.blobs)),
+		}
+		rw.WriteHeader(http.StatusCreated)
+	case http.MethodDelete:
+		if _, ok := fabs.blobs[name]; !ok {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		delete(fabs.blobs, name)
+		rw.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func (fabs *fakeAzureBlobService) serveList(
+	rw http.ResponseWriter,
+	req *http.Request,
+) {
+	prefix := req.URL.Query().Get("prefix")
+	var names []string
+	for name := range fabs.blobs {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	type blob struct {
+		Name       string
+		Properties struct {
+			ContentLength int `xml:"Content-Length"`
+		}
+		Metadata struct {
+			Expires string `xml:"goproxyexpires"`
+		}
+	}
+	var result struct {
+		XMLName xml.Name `xml:"EnumerationResults"`
+		Blobs   struct {
+			Blob []blob
+		}
+		NextMarker string
+	}
+
+	// Return one blob per page to exercise the pagination.
+	marker := req.URL.Query().Get("marker")
+	for i, name := range names {
+		if name <= marker {
+			continue
+		}
+
+		b := blob{Name: name}
+		b.Properties.ContentLength = len(fabs.blobs[name].content)
+		b.Metadata.Expires = fabs.blobs[name].expires
+		result.Blobs.Blob = []blob{b}
+		if i < len(names)-1 {
+			result.NextMarker = name
+		}
+
+		break
+	}
+
+	xml.NewEncoder(rw).Encode(result)
+}
+
+func TestAzureBlobCacher(t *testing.T) {
+	fabs := &fakeAzureBlobService{
+		authorized: func(req *http.Request) bool {
+			return req.URL.Query().Get("sig") == "signature"
+		},
+		blobs: map[string]fakeAzureBlob{},
+	}
+	server := httptest.NewServer(fabs)
+	defer server.Close()
+
+	abc := &AzureBlobCacher{
+		AccountURL: server.URL,
+		Container:  "container",
+		Prefix:     "goproxy",
+		SASToken:   "?sv=2020-10-02&sig=signature",
+	}
+
+	ctx := context.Background()
+	if _, err := abc.Get(ctx, "a"); !os.IsNotExist(err) {
+		t.Fatalf("got %v, want %v", err, os.ErrNotExist)
+	}
+
+	for _, name := range []string{"a", "b", "c/d"} {
+		expiration := time.Hour
+		if name == "b" {
+			expiration = -time.Hour
+		}
+
+		err := abc.Put(
+			ctx,
+			name,
+			strings.NewReader("content of "+name),
+			expiration,
+		)
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	rc, err := abc.Get(ctx, "c/d")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer rc.Close()
+
+	if got, want := rc.(interface{ ETag() string }).ETag(),
+		`"2"`; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	rs := rc.(io.ReadSeeker)
+	if size, err := rs.Seek(0, io.SeekEnd); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := size, int64(len("content of c/d")); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	if _, err := rs.Seek(11, io.SeekStart); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	b, err := ioutil.ReadAll(rs)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := string(b), "c/d"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if got, want := fabs.rangedGets, 1; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	if _, err := abc.Get(ctx, "b"); !os.IsNotExist(err) {
+		t.Fatalf("got %v, want %v", err, os.ErrNotExist)
+	}
+
+	var reclaimed []string
+	err = abc.CleanupReclaimed(func(name string, size int64) {
+		reclaimed = append(reclaimed, name)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := strings.Join(reclaimed, ","), "b"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if err := abc.Delete(ctx, "a"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if err := abc.Delete(ctx, "a"); !os.IsNotExist(err) {
+		t.Fatalf("got %v, want %v", err, os.ErrNotExist)
+	}
+
+	abc.SASToken = ""
+	if _, err := abc.Get(ctx, "c/d"); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestAzureBlobCacherManagedIdentity(t *testing.T) {
+	var tokenRequests int
+	identityServer := httptest.NewServer(http.HandlerFunc(func(
+		rw http.ResponseWriter,
+		req *http.Request,
+	) {
+		if req.Header.Get("Metadata") != "true" ||
+			req.URL.Query().Get("client_id") != "client" {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		tokenRequests++
+		fmt.Fprintf(
+			rw,
+			`{"access_token":"token","expires_on":"%d"}`,
+			time.Now().Add(time.Hour).Unix(),
+		)
+	}))
+	defer identityServer.Close()
+
+	fabs := &fakeAzureBlobService{
+		authorized: func(req *http.Request) bool {
+			return req.Header.Get("Authorization") == "Bearer token"
+		},
+		blobs: map[string]fakeAzureBlob{},
+	}
+	server := httptest.NewServer(fabs)
+	defer server.Close()
+
+	abc := &AzureBlobCacher{
+		AccountURL:              server.URL,
+		Container:               "container",
+		ManagedIdentity:         true,
+		ManagedIdentityClientID: "client",
+		IdentityEndpoint:        identityServer.URL,
+	}
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := abc.Put(
+			ctx,
+			"a",
+			strings.NewReader("a"),
+			time.Hour,
+		); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	if got, want := tokenRequests, 1; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,272 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/module"
+)
+
+// BackfillFormat is the on-disk layout of an existing module cache that the
+// [Goproxy.Backfill] understands.
+type BackfillFormat int
+
+const (
+	// BackfillFormatAthens is the layout of the disk storage of Athens,
+	// where the files of each module version are stored as
+	// "<module path>/<version>/{<version>.info,go.mod,source.zip}".
+	BackfillFormatAthens BackfillFormat = iota + 1
+
+	// BackfillFormatModuleCache is the layout of the module download cache
+	// of the go command ("$GOMODCACHE/cache/download"), which is also used
+	// by many other module proxies (including the [DirCacher]), where the
+	// files of each module version are stored as
+	// "<escaped module path>/@v/<escaped version>.{info,mod,zip}".
+	BackfillFormatModuleCache
+)
+
+// String implements the [fmt.Stringer].
+func (bf BackfillFormat) String() string {
+	switch bf {
+	case BackfillFormatAthens:
+		return "athens"
+	case BackfillFormatModuleCache:
+		return "modcache"
+	}
+
+	return fmt.Sprintf("unknown format %d", int(bf))
+}
+
+// BackfillReport is the report of a [Goproxy.Backfill].
+type BackfillReport struct {
+	// Imported is the number of module files imported.
+	Imported int
+
+	// ImportedBytes is the number of bytes of the module files imported.
+	ImportedBytes int64
+
+	// Skipped is the number of module files skipped since they have
+	// already been cached.
+	Skipped int
+
+	// Failed is the number of module files failed to be imported. The
+	// failures are logged via the [Goproxy.ErrorLogger].
+	Failed int
+}
+
+// Backfill imports the module files from the existing module cache in the dir,
+// which is in the format, into the [Goproxy.Cacher]. Each module file goes
+// through the same checks (including the checksum database verification) as
+// if it had been fetched from an upstream proxy. Module files that have
+// already been cached are skipped, so an interrupted backfill can simply be
+// run again.
+func (g *Goproxy) Backfill(
+	ctx context.Context,
+	format BackfillFormat,
+	dir string,
+) (*BackfillReport, error) {
+	g.initOnce.Do(g.init)
+
+	var backfillName func(rel string) (string, bool)
+	switch format {
+	case BackfillFormatAthens:
+		backfillName = athensBackfillName
+	case BackfillFormatModuleCache:
+		backfillName = moduleCacheBackfillName
+	default:
+		return nil, fmt.Errorf("invalid backfill format: %v", format)
+	}
+
+	r := &BackfillReport{}
+	if err := filepath.Walk(dir, func(
+		file string,
+		fi os.FileInfo,
+		err error,
+	) error {
+		if err != nil {
+			return err
+		} else if err := ctx.Err(); err != nil {
+			return err
+		} else if !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+
+		name, ok := backfillName(filepath.ToSlash(rel))
+		if !ok {
+			return nil
+		}
+
+		imported, err := g.backfill(ctx, name, file)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+
+			r.Failed++
+			g.logErrorf("failed to backfill: %s: %v", file, err)
+		} else if imported {
+			r.Imported++
+			r.ImportedBytes += fi.Size()
+		} else {
+			r.Skipped++
+		}
+
+		return nil
+	}); err != nil {
+		return r, err
+	}
+
+	return r, nil
+}
+
+// backfill imports the module file targeted by the name from the file into the
+// [Goproxy.Cacher]. It returns false if the name has already been cached.
+func (g *Goproxy) backfill(
+	ctx context.Context,
+	name string,
+	file string,
+) (bool, error) {
+	if g.Cacher == nil {
+		return false, errors.New("no cacher")
+	}
+
+	if content, err := g.cache(ctx, name); err == nil {
+		content.Close()
+		return false, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+
+	tempDir, err := ioutil.TempDir(g.TempDir, "goproxy")
+	if err != nil {
+		return false, err
+	}
+	defer os.RemoveAll(tempDir)
+
+	f, err := newFetch(g, name, tempDir)
+	if err != nil {
+		return false, err
+	}
+
+	switch f.ops {
+	case fetchOpsDownloadInfo, fetchOpsDownloadMod, fetchOpsDownloadZip:
+	default:
+		return false, errors.New("not a module file")
+	}
+
+	tempFile := filepath.Join(tempDir, path.Base(name))
+	if err := copyFile(tempFile, file); err != nil {
+		return false, err
+	}
+
+	if err := f.checkDownloadFile(tempFile); err != nil {
+		return false, err
+	}
+
+	if err := g.putCacheFile(
+		ctx,
+		name,
+		tempFile,
+		g.versionCacheExpiration(defaultCacheExpiration, f.moduleVersion),
+	); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// athensBackfillName returns the cache name of the module file at the rel (a
+// slash-separated path relative to the root) of an Athens disk storage. It
+// returns false if the rel is not a module file.
+func athensBackfillName(rel string) (string, bool) {
+	dir, fileName := path.Split(rel)
+	dir = strings.TrimSuffix(dir, "/")
+	modulePath, version := path.Split(dir)
+	modulePath = strings.TrimSuffix(modulePath, "/")
+	if modulePath == "" || version == "" {
+		return "", false
+	}
+
+	var ext string
+	switch fileName {
+	case version + ".info":
+		ext = ".info"
+	case "go.mod":
+		ext = ".mod"
+	case "source.zip":
+		ext = ".zip"
+	default:
+		return "", false
+	}
+
+	// Module paths never contain "!", so a module path containing it must
+	// have been stored escaped.
+	if strings.Contains(modulePath, "!") {
+		var err error
+		if modulePath, err = module.UnescapePath(modulePath); err != nil {
+			return "", false
+		}
+	}
+
+	escapedModulePath, err := module.EscapePath(modulePath)
+	if err != nil {
+		return "", false
+	}
+
+	escapedVersion, err := module.EscapeVersion(version)
+	if err != nil {
+		return "", false
+	}
+
+	return fmt.Sprint(escapedModulePath, "/@v/", escapedVersion, ext), true
+}
+
+// moduleCacheBackfillName returns the cache name of the module file at the rel
+// (a slash-separated path relative to the root) of a module download cache. It
+// returns false if the rel is not a module file.
+func moduleCacheBackfillName(rel string) (string, bool) {
+	dir, fileName := path.Split(rel)
+	if !strings.HasSuffix(dir, "/@v/") {
+		return "", false
+	}
+
+	switch path.Ext(fileName) {
+	case ".info", ".mod", ".zip":
+		return rel, true
+	}
+
+	return "", false
+}
+
+// copyFile copies the content of the src file to the dst file.
+func copyFile(dst, src string) error {
+	sf, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer sf.Close()
+
+	df, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(df, sf); err != nil {
+		df.Close()
+		return err
+	}
+
+	return df.Close()
+}
@@ -0,0 +1,216 @@
+package goproxy
+
+import (
+	"archive/zip"
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGoproxyBackfill(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestGoproxyBackfill")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	writeFile := func(name, content string) {
+		file := filepath.Join(tempDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	writeZip := func(name, modAtVer string) {
+		file := filepath.Join(tempDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		f, err := os.Create(file)
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		defer f.Close()
+
+		zw := zip.NewWriter(f)
+		w, err := zw.Create(modAtVer + "/go.mod")
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		if _, err := w.Write([]byte("module example.com")); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		if err := zw.Close(); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	infoTime := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	info := marshalInfo("v1.0.0", infoTime)
+
+	writeFile("athens/example.com/v1.0.0/v1.0.0.info", info)
+	writeFile("athens/example.com/v1.0.0/go.mod", "module example.com")
+	writeZip("athens/example.com/v1.0.0/source.zip", "example.com@v1.0.0")
+	writeFile("athens/example.com/v1.0.0/other", "foobar")
+	writeFile("athens/example.com/v1.1.0/go.mod", "invalid")
+
+	writeFile("modcache/example.com/@v/list", "v1.0.0")
+	writeFile("modcache/example.com/@v/v1.0.0.info", info)
+	writeFile("modcache/example.com/@v/v1.0.0.mod", "module example.com")
+	writeFile("modcache/example.com/@v/v1.0.0.lock", "")
+	writeZip("modcache/example.com/@v/v1.0.0.zip", "example.com@v1.0.0")
+
+	g := &Goproxy{
+		Cacher:      DirCacher(filepath.Join(tempDir, "caches")),
+		GoBinEnv:    []string{"GOSUMDB=off"},
+		TempDir:     tempDir,
+		ErrorLogger: log.New(&discardWriter{}, "", 0),
+	}
+
+	r, err := g.Backfill(
+		context.Background(),
+		BackfillFormatAthens,
+		filepath.Join(tempDir, "athens"),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if got, want := r.Imported, 3; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	if got, want := r.Failed, 1; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	if r.ImportedBytes == 0 {
+		t.Error("expected non-zero imported bytes")
+	}
+
+	for _, name := range []string{
+		"example.com/@v/v1.0.0.info",
+		"example.com/@v/v1.0.0.mod",
+		"example.com/@v/v1.0.0.zip",
+	} {
+		rc, err := g.Cacher.Get(context.Background(), name)
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		rc.Close()
+	}
+
+	r, err = g.Backfill(
+		context.Background(),
+		BackfillFormatModuleCache,
+		filepath.Join(tempDir, "modcache"),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if got, want := *r, (BackfillReport{Skipped: 3}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	if _, err := g.Backfill(
+		context.Background(),
+		0,
+		filepath.Join(tempDir, "modcache"),
+	); err == nil {
+		t.Fatal("expected error")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := g.Backfill(
+		ctx,
+		BackfillFormatModuleCache,
+		filepath.Join(tempDir, "modcache"),
+	); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestAthensBackfillName(t *testing.T) {
+	for _, tt := range []struct {
+		rel      string
+		wantName string
+		wantOK   bool
+	}{
+		{
+			"example.com/v1.0.0/v1.0.0.info",
+			"example.com/@v/v1.0.0.info",
+			true,
+		},
+		{"example.com/v1.0.0/go.mod", "example.com/@v/v1.0.0.mod", true},
+		{
+			"example.com/Foo/v1.0.0/source.zip",
+			"example.com/!foo/@v/v1.0.0.zip",
+			true,
+		},
+		{
+			"example.com/!foo/v1.0.0/source.zip",
+			"example.com/!foo/@v/v1.0.0.zip",
+			true,
+		},
+		{"example.com/v1.0.0/v1.1.0.info", "", false},
+		{"example.com/v1.0.0/other", "", false},
+		{"v1.0.0/go.mod", "", false},
+		{"go.mod", "", false},
+	} {
+		name, ok := athensBackfillName(tt.rel)
+		if name != tt.wantName || ok != tt.wantOK {
+			t.Errorf(
+				"%s: got %q %t, want %q %t",
+				tt.rel,
+				name,
+				ok,
+				tt.wantName,
+				tt.wantOK,
+			)
+		}
+	}
+}
+
+func TestModuleCacheBackfillName(t *testing.T) {
+	for _, tt := range []struct {
+		rel      string
+		wantName string
+		wantOK   bool
+	}{
+		{
+			"example.com/@v/v1.0.0.info",
+			"example.com/@v/v1.0.0.info",
+			true,
+		},
+		{"example.com/@v/v1.0.0.mod", "example.com/@v/v1.0.0.mod", true},
+		{"example.com/@v/v1.0.0.zip", "example.com/@v/v1.0.0.zip", true},
+		{"example.com/@v/v1.0.0.lock", "", false},
+		{"example.com/@v/list", "", false},
+		{"example.com/v1.0.0.zip", "", false},
+	} {
+		name, ok := moduleCacheBackfillName(tt.rel)
+		if name != tt.wantName || ok != tt.wantOK {
+			t.Errorf(
+				"%s: got %q %t, want %q %t",
+				tt.rel,
+				name,
+				ok,
+				tt.wantName,
+				tt.wantOK,
+			)
+		}
+	}
+}
@@ -0,0 +1,353 @@
+package goproxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// blobNamePrefix is the prefix of the names of the blobs stored by the
+	// [ContentAddressedCacher].
+	blobNamePrefix = apiPathPrefix + "blobs/sha256/"
+
+	// blobPointerPrefix is the prefix of the content of the caches that
+	// point to blobs stored by the [ContentAddressedCacher]. Zip files
+	// always start with a "PK" signature, so a pointer can never be
+	// mistaken for one.
+	blobPointerPrefix = "goproxy-blob sha256:"
+
+	// blobPointerSize is the size of the content of a blob pointer.
+	blobPointerSize = len(blobPointerPrefix) + sha256.Size*2
+
+	// blobExpiration is the expiration of the blobs stored by the
+	// [ContentAddressedCacher]. Blobs are meant to live as long as they are
+	// pointed to, so they are only removed by the
+	// [ContentAddressedCacher.Cleanup] once no longer referenced.
+	blobExpiration = 100 * 365 * 24 * time.Hour
+)
+
+// ContentAddressedCacher implements the [Cacher] by wrapping another [Cacher]
+// and storing the zip files as blobs addressed by their SHA-256 hashes, with
+// the caches for their names being small pointers to the blobs. Identical zip
+// files reachable under multiple names (e.g. forks and vanity import paths of
+// the same module) are therefore stored only once.
+//
+// Blobs no longer pointed to are removed by the
+// [ContentAddressedCacher.Cleanup], which requires the wrapped [Cacher] to be
+// a [DirCacher] or a [ShardedDirCacher] (so that the pointers can be
+// enumerated) and to implement the [Deleter]. The pointers are only tracked
+// within the current process, so the wrapped [Cacher] must not be shared by
+// multiple processes that clean it up.
+//
+// Caches put before the ContentAddressedCacher was used are still served as
+// they are.
+//
+// Make sure that all fields of the ContentAddressedCacher have been finalized
+// before calling any of its methods.
+type ContentAddressedCacher struct {
+	// Cacher is the wrapped [Cacher].
+	Cacher Cacher
+
+	mutex      sync.Mutex
+	collecting bool
+	inFlight   map[string]int
+	recent     map[string]bool
+}
+
+// Get implements the [Cacher].
+func (cac *ContentAddressedCacher) Get(
+	ctx context.Context,
+	name string,
+) (io.ReadCloser, error) {
+	content, err := cac.Cacher.Get(ctx, name)
+	if err != nil || path.Ext(name) != ".zip" {
+		return content, err
+	}
+
+	b := make([]byte, blobPointerSize)
+	n, err := io.ReadFull(content, b)
+	if err != nil &&
+		!errors.Is(err, io.EOF) &&
+		!errors.Is(err, io.ErrUnexpectedEOF) {
+		content.Close()
+		return nil, err
+	}
+
+	b = b[:n]
+	if blobName, ok := parseBlobPointer(b); ok {
+		content.Close()
+		return cac.Cacher.Get(ctx, blobName)
+	}
+
+	if s, ok := content.(io.Seeker); ok {
+		if _, err := s.Seek(0, io.SeekStart); err != nil {
+			content.Close()
+			return nil, err
+		}
+
+		return content, nil
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(b), content), content}, nil
+}
+
+// Put implements the [Cacher].
+func (cac *ContentAddressedCacher) Put(
+	ctx context.Context,
+	name string,
+	content io.ReadSeeker,
+	expiration time.Duration,
+) error {
+	if path.Ext(name) != ".zip" {
+		return cac.Cacher.Put(ctx, name, content, expiration)
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, content); err != nil {
+		return err
+	} else if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	hexHash := hex.EncodeToString(hash.Sum(nil))
+	blobName := fmt.Sprint(blobNamePrefix, hexHash[:2], "/", hexHash)
+
+	cac.acquire(blobName)
+	defer cac.release(blobName)
+
+	if blob, err := cac.Cacher.Get(ctx, blobName); err == nil {
+		blob.Close()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	} else if err := cac.Cacher.Put(
+		ctx,
+		blobName,
+		content,
+		blobExpiration,
+	); err != nil {
+		return err
+	}
+
+	return cac.Cacher.Put(
+		ctx,
+		name,
+		strings.NewReader(blobPointerPrefix+hexHash),
+		expiration,
+	)
+}
+
+// Delete implements the [Deleter]. The blob pointed to by the cache for the
+// name, if any, is left to be removed by the [ContentAddressedCacher.Cleanup].
+func (cac *ContentAddressedCacher) Delete(
+	ctx context.Context,
+	name string,
+) error {
+	d, ok := cac.Cacher.(Deleter)
+	if !ok {
+		return errDeleteNotSupported
+	}
+
+	return d.Delete(ctx, name)
+}
+
+// Cleanup implements the [Cacher].
+func (cac *ContentAddressedCacher) Cleanup() error {
+	return cac.CleanupReclaimed(nil)
+}
+
+// CleanupReclaimed implements the [Reclaimer]. It also removes the blobs no
+// longer pointed to.
+func (cac *ContentAddressedCacher) CleanupReclaimed(
+	reclaimed func(name string, size int64),
+) error {
+	if r, ok := cac.Cacher.(Reclaimer); ok {
+		if err := r.CleanupReclaimed(reclaimed); err != nil {
+			return err
+		}
+	} else if err := cac.Cacher.Cleanup(); err != nil {
+		return err
+	}
+
+	return cac.removeUnreferencedBlobs(reclaimed)
+}
+
+// List implements the [Lister].
+func (cac *ContentAddressedCacher) List(
+	ctx context.Context,
+	prefix string,
+) CacheIterator {
+	return listCaches(ctx, cac.walkCaches, prefix)
+}
+
+// walkCaches implements the [cacheWalker]. The blobs are not walked, since
+// they are reachable via the caches pointing to them.
+func (cac *ContentAddressedCacher) walkCaches(
+	fn func(name string, size int64) error,
+) error {
+	cw, ok := cacheWalkerOf(cac.Cacher)
+	if !ok {
+		return errWalkNotSupported
+	}
+
+	return cw.walkCaches(func(name string, size int64) error {
+		if strings.HasPrefix(name, blobNamePrefix) {
+			return nil
+		}
+
+		return fn(name, size)
+	})
+}
+
+// removeUnreferencedBlobs removes the blobs no longer pointed to by any cache
+// and calls the reclaimed, if not nil, for each removed blob. It does nothing
+// if the cac.Cacher cannot enumerate its caches.
+func (cac *ContentAddressedCacher) removeUnreferencedBlobs(
+	reclaimed func(name string, size int64),
+) error {
+	cw, ok := cacheWalkerOf(cac.Cacher)
+	if !ok {
+		return nil
+	}
+
+	d, ok := cac.Cacher.(Deleter)
+	if !ok {
+		return nil
+	}
+
+	cac.mutex.Lock()
+	cac.collecting = true
+	cac.recent = map[string]bool{}
+	cac.mutex.Unlock()
+	defer func() {
+		cac.mutex.Lock()
+		cac.collecting = false
+		cac.recent = nil
+		cac.mutex.Unlock()
+	}()
+
+	type blob struct {
+		name string
+		size int64
+	}
+
+	var (
+		ctx        = context.Background()
+		blobs      []blob
+		referenced = map[string]bool{}
+	)
+	if err := cw.walkCaches(func(name string, size int64) error {
+		if strings.HasPrefix(name, blobNamePrefix) {
+			blobs = append(blobs, blob{name, size})
+			return nil
+		} else if path.Ext(name) != ".zip" ||
+			size != int64(blobPointerSize) {
+			return nil
+		}
+
+		content, err := cac.Cacher.Get(ctx, name)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+
+			return err
+		}
+
+		b, err := ioutil.ReadAll(content)
+		content.Close()
+		if err != nil {
+			return err
+		}
+
+		if blobName, ok := parseBlobPointer(b); ok {
+			referenced[blobName] = true
+		}
+
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for _, blob := range blobs {
+		if referenced[blob.name] || cac.inUse(blob.name) {
+			continue
+		}
+
+		if err := d.Delete(ctx, blob.name); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+
+			return err
+		}
+
+		if reclaimed != nil {
+			reclaimed(blob.name, blob.size)
+		}
+	}
+
+	return nil
+}
+
+// acquire marks the blob targeted by the blobName as being put, so that it
+// will not be removed as unreferenced before its pointer is put.
+func (cac *ContentAddressedCacher) acquire(blobName string) {
+	cac.mutex.Lock()
+	defer cac.mutex.Unlock()
+	if cac.inFlight == nil {
+		cac.inFlight = map[string]int{}
+	}
+
+	cac.inFlight[blobName]++
+}
+
+// release undoes the [ContentAddressedCacher.acquire].
+func (cac *ContentAddressedCacher) release(blobName string) {
+	cac.mutex.Lock()
+	defer cac.mutex.Unlock()
+	if cac.inFlight[blobName]--; cac.inFlight[blobName] == 0 {
+		delete(cac.inFlight, blobName)
+	}
+
+	if cac.collecting {
+		cac.recent[blobName] = true
+	}
+}
+
+// inUse reports whether the blob targeted by the blobName is being put or has
+// been put since the current removal of unreferenced blobs started.
+func (cac *ContentAddressedCacher) inUse(blobName string) bool {
+	cac.mutex.Lock()
+	defer cac.mutex.Unlock()
+	return cac.inFlight[blobName] > 0 || cac.recent[blobName]
+}
+
+// parseBlobPointer parses the b as a blob pointer and returns the name of the
+// blob it points to.
+func parseBlobPointer(b []byte) (string, bool) {
+	if len(b) != blobPointerSize ||
+		!bytes.HasPrefix(b, []byte(blobPointerPrefix)) {
+		return "", false
+	}
+
+	hexHash := string(b[len(blobPointerPrefix):])
+	if _, err := hex.DecodeString(hexHash); err != nil {
+		return "", false
+	}
+
+	return fmt.Sprint(blobNamePrefix, hexHash[:2], "/", hexHash), true
+}
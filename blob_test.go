@@ -0,0 +1,206 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContentAddressedCacher(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestContentAddressedCacher")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cac := &ContentAddressedCacher{Cacher: DirCacher(tempDir)}
+	put := func(name, content string) {
+		if err := cac.Put(
+			context.Background(),
+			name,
+			strings.NewReader(content),
+			time.Hour,
+		); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	get := func(name string) (string, error) {
+		content, err := cac.Get(context.Background(), name)
+		if err != nil {
+			return "", err
+		}
+		defer content.Close()
+
+		b, err := ioutil.ReadAll(content)
+		return string(b), err
+	}
+
+	countBlobs := func() int {
+		var blobs int
+		if err := filepath.Walk(
+			filepath.Join(tempDir, filepath.FromSlash(blobNamePrefix)),
+			func(_ string, fi os.FileInfo, err error) error {
+				if err == nil && fi.Mode().IsRegular() &&
+					!strings.HasPrefix(fi.Name(), ".") {
+					blobs++
+				}
+
+				return err
+			},
+		); err != nil && !os.IsNotExist(err) {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		return blobs
+	}
+
+	put("example.com/a/@v/v1.0.0.zip", "PKfoobar")
+	put("example.com/b/@v/v1.0.0.zip", "PKfoobar")
+	put("example.com/c/@v/v1.0.0.zip", "PKbarfoo")
+	put("example.com/a/@v/v1.0.0.mod", "module example.com/a")
+
+	if got, want := countBlobs(), 2; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	for name, want := range map[string]string{
+		"example.com/a/@v/v1.0.0.zip": "PKfoobar",
+		"example.com/b/@v/v1.0.0.zip": "PKfoobar",
+		"example.com/c/@v/v1.0.0.zip": "PKbarfoo",
+		"example.com/a/@v/v1.0.0.mod": "module example.com/a",
+	} {
+		if got, err := get(name); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		} else if got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(
+		tempDir,
+		"example.com/a/@v/v1.0.0.mod",
+	))
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := string(b), "module example.com/a"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Caches put before the blobs were used are served as they are.
+	legacy := filepath.Join(tempDir, "example.com/d/@v/v1.0.0.zip")
+	if err := os.MkdirAll(filepath.Dir(legacy), 0750); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if err := ioutil.WriteFile(
+		legacy,
+		[]byte("PKlegacy"),
+		0640,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if err := setCacheExpiration(legacy, time.Hour); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if got, err := get("example.com/d/@v/v1.0.0.zip"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got != "PKlegacy" {
+		t.Errorf("got %q, want %q", got, "PKlegacy")
+	}
+
+	if err := cac.Delete(
+		context.Background(),
+		"example.com/c/@v/v1.0.0.zip",
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if err := setCacheExpiration(
+		filepath.Join(tempDir, "example.com/a/@v/v1.0.0.zip"),
+		-time.Hour,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	var reclaimed []string
+	if err := cac.CleanupReclaimed(func(name string, size int64) {
+		reclaimed = append(reclaimed, name)
+	}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if got, want := len(reclaimed), 2; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	} else if got, want := reclaimed[0],
+		"example.com/a/@v/v1.0.0.zip"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	} else if !strings.HasPrefix(reclaimed[1], blobNamePrefix) {
+		t.Errorf("got %q, want prefix %q", reclaimed[1], blobNamePrefix)
+	}
+
+	if got, want := countBlobs(), 1; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	if got, err := get("example.com/b/@v/v1.0.0.zip"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got != "PKfoobar" {
+		t.Errorf("got %q, want %q", got, "PKfoobar")
+	}
+
+	if _, err := get(
+		"example.com/a/@v/v1.0.0.zip",
+	); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got error %q, want error %q", err, os.ErrNotExist)
+	}
+
+	var names []string
+	if err := cac.walkCaches(func(name string, size int64) error {
+		names = append(names, name)
+		return nil
+	}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if got, want := strings.Join(names, ","),
+		"example.com/a/@v/v1.0.0.mod,"+
+			"example.com/b/@v/v1.0.0.zip,"+
+			"example.com/d/@v/v1.0.0.zip"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestParseBlobPointer(t *testing.T) {
+	hexHash := strings.Repeat("ab", 32)
+	for _, tt := range []struct {
+		b        string
+		wantName string
+		wantOK   bool
+	}{
+		{
+			blobPointerPrefix + hexHash,
+			blobNamePrefix + "ab/" + hexHash,
+			true,
+		},
+		{blobPointerPrefix + hexHash[1:], "", false},
+		{blobPointerPrefix + strings.Repeat("zz", 32), "", false},
+		{"PK" + hexHash, "", false},
+		{"", "", false},
+	} {
+		name, ok := parseBlobPointer([]byte(tt.b))
+		if name != tt.wantName || ok != tt.wantOK {
+			t.Errorf(
+				"%q: got %q %t, want %q %t",
+				tt.b,
+				name,
+				ok,
+				tt.wantName,
+				tt.wantOK,
+			)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package goproxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// moduleHost returns the host (the first path element) of the modulePath.
+func moduleHost(modulePath string) string {
+	if i := strings.IndexByte(modulePath, '/'); i >= 0 {
+		return modulePath[:i]
+	}
+
+	return modulePath
+}
+
+// hostMatchesPattern reports whether the host matches the pattern. A pattern
+// of the form "*.suffix" matches the suffix itself and all its subdomains,
+// while any other pattern matches exactly.
+func hostMatchesPattern(pattern, host string) bool {
+	pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if strings.HasPrefix(pattern, "*.") {
+		suffix := pattern[2:]
+		return host == suffix || strings.HasSuffix(host, "."+suffix)
+	}
+
+	return host == pattern
+}
+
+// checkModuleHost checks whether the host of the modulePath matches any of the
+// blockedHosts (see [Goproxy.BlockedModuleHosts]).
+func checkModuleHost(blockedHosts []string, modulePath string) error {
+	host := moduleHost(modulePath)
+	for _, pattern := range blockedHosts {
+		if hostMatchesPattern(pattern, host) {
+			return forbiddenError(fmt.Sprintf(
+				"%s: blocked module host %q (rule %q)",
+				modulePath,
+				host,
+				pattern,
+			))
+		}
+	}
+
+	return nil
+}
+
+// checkModuleIPs checks whether any of the IP addresses that the host of the
+// modulePath resolves to is in the blockedIPNets (see
+// [Goproxy.BlockedModuleIPNets]).
+func checkModuleIPs(
+	ctx context.Context,
+	blockedIPNets []*net.IPNet,
+	modulePath string,
+) error {
+	if len(blockedIPNets) == 0 {
+		return nil
+	}
+
+	host := moduleHost(modulePath)
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		return notFoundError(fmt.Sprintf(
+			"%s: failed to resolve module host: %v",
+			modulePath,
+			err,
+		))
+	}
+
+	for _, addr := range addrs {
+		for _, ipNet := range blockedIPNets {
+			if ipNet.Contains(addr.IP) {
+				return forbiddenError(fmt.Sprintf(
+					"%s: module host %q resolves to "+
+						"blocked IP address %s (rule %s)",
+					modulePath,
+					host,
+					addr.IP,
+					ipNet,
+				))
+			}
+		}
+	}
+
+	return nil
+}
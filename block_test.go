@@ -0,0 +1,116 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHostMatchesPattern(t *testing.T) {
+	for _, tt := range []struct {
+		pattern string
+		host    string
+		want    bool
+	}{
+		{"*.ru", "ru", true},
+		{"*.ru", "example.ru", true},
+		{"*.ru", "foo.example.RU", true},
+		{"*.ru", "example.run", false},
+		{"*.ru", "ru.example.com", false},
+		{"example.com", "example.com", true},
+		{"example.com", "foo.example.com", false},
+		{"example.com.", "Example.com", true},
+	} {
+		if got := hostMatchesPattern(tt.pattern, tt.host); got != tt.want {
+			t.Errorf("%s %s: got %t, want %t", tt.pattern, tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestCheckModuleHost(t *testing.T) {
+	if err := checkModuleHost(nil, "example.ru/foo"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	blockedHosts := []string{"*.ru", "example.org"}
+	if err := checkModuleHost(blockedHosts, "example.com/foo"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	err := checkModuleHost(blockedHosts, "example.ru/foo")
+	if !errors.Is(err, errForbidden) {
+		t.Fatalf("got error %q, want error %q", err, errForbidden)
+	} else if got, want := err.Error(), `example.ru/foo: blocked module host "example.ru" (rule "*.ru")`; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if err := checkModuleHost(
+		blockedHosts,
+		"example.org",
+	); !errors.Is(err, errForbidden) {
+		t.Fatalf("got error %q, want error %q", err, errForbidden)
+	}
+
+	g := &Goproxy{BlockedModuleHosts: blockedHosts}
+	g.init()
+	if _, err := newFetch(
+		g,
+		"example.ru/foo/@v/list",
+		"",
+	); !errors.Is(err, errForbidden) {
+		t.Fatalf("got error %q, want error %q", err, errForbidden)
+	}
+
+	g = &Goproxy{
+		BlockedModuleHosts: blockedHosts,
+		ErrorLogger:        log.New(&discardWriter{}, "", 0),
+	}
+	for _, name := range []string{
+		"/example.ru/foo/@v/list",
+		"/example.ru/foo/@v/v1.0.0.ziphash",
+	} {
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest("", name, nil))
+		if got, want := rec.Code, http.StatusForbidden; got != want {
+			t.Errorf("%s: got %d, want %d", name, got, want)
+		}
+	}
+}
+
+func TestCheckModuleIPs(t *testing.T) {
+	_, ipNet, err := net.ParseCIDR("127.0.0.0/8")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if err := checkModuleIPs(
+		context.Background(),
+		nil,
+		"127.0.0.1/foo",
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	err = checkModuleIPs(
+		context.Background(),
+		[]*net.IPNet{ipNet},
+		"127.0.0.1/foo",
+	)
+	if !errors.Is(err, errForbidden) {
+		t.Fatalf("got error %q, want error %q", err, errForbidden)
+	} else if got, want := err.Error(), `127.0.0.1/foo: module host "127.0.0.1" resolves to blocked IP address 127.0.0.1 (rule 127.0.0.0/8)`; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if err := checkModuleIPs(
+		context.Background(),
+		[]*net.IPNet{ipNet},
+		"10.0.0.1/foo",
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+}
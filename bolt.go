@@ -0,0 +1,356 @@
+package goproxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// boltOpenTimeout is the maximum duration that a [BoltCacher] waits for the
+// lock of its database file, which is held by at most one process at a time.
+const boltOpenTimeout = 10 * time.Second
+
+// boltBucket is the name of the bucket holding the caches of a [BoltCacher].
+var boltBucket = []byte("caches")
+
+// boltValueHeaderSize is the size of the header prepended to each value stored
+// by a [BoltCacher], which holds the expiration time and the modification time
+// of the cache as big-endian Unix nanoseconds.
+const boltValueHeaderSize = 16
+
+// BoltCacher implements the [Cacher] using a single bbolt database file, which
+// packs millions of small caches (".info", ".mod", "@latest" and "@v/list")
+// into one file instead of one file each, sparing inode-limited filesystems.
+//
+// Large caches can be delegated to another [Cacher] (see the
+// [BoltCacher.ZipCacher]) to keep the database file compact.
+//
+// The database file is opened on first use and stays open (and locked against
+// other processes) until the [BoltCacher.Close] is called.
+//
+// Make sure that all fields of the BoltCacher have been finalized before
+// calling any of its methods.
+type BoltCacher struct {
+	// Path is the path of the database file. It is created, along with its
+	// parent directories, if it does not exist.
+	Path string
+
+	// ZipCacher is the [Cacher] that the ".zip" caches are delegated to.
+	//
+	// If the ZipCacher is nil, the ".zip" caches are stored in the
+	// database file as well.
+	ZipCacher Cacher
+
+	openOnce sync.Once
+	db       *bbolt.DB
+	openErr  error
+}
+
+// Get implements the [Cacher].
+func (bc *BoltCacher) Get(
+	ctx context.Context,
+	name string,
+) (io.ReadCloser, error) {
+	if c := bc.zipCacher(name); c != nil {
+		return c.Get(ctx, name)
+	}
+
+	db, err := bc.open()
+	if err != nil {
+		return nil, err
+	}
+
+	var content *memoryContent
+	if err := db.View(func(tx *bbolt.Tx) error {
+		v := tx.Bucket(boltBucket).Get([]byte(name))
+		if len(v) < boltValueHeaderSize {
+			return os.ErrNotExist
+		}
+
+		expires, modTime := parseBoltValueHeader(v)
+		if time.Now().After(expires) {
+			return os.ErrNotExist
+		}
+
+		// The v is only valid during the transaction.
+		b := append([]byte(nil), v[boltValueHeaderSize:]...)
+
+		content = &memoryContent{
+			Reader:  bytes.NewReader(b),
+			modTime: modTime,
+			expires: expires,
+		}
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return content, nil
+}
+
+// Put implements the [Cacher].
+func (bc *BoltCacher) Put(
+	ctx context.Context,
+	name string,
+	content io.ReadSeeker,
+	expiration time.Duration,
+) error {
+	if c := bc.zipCacher(name); c != nil {
+		return c.Put(ctx, name, content, expiration)
+	}
+
+	db, err := bc.open()
+	if err != nil {
+		return err
+	}
+
+	if expiration <= 0 {
+		// The cache has already expired.
+		return db.Update(func(tx *bbolt.Tx) error {
+			return tx.Bucket(boltBucket).Delete([]byte(name))
+		})
+	}
+
+	b, err := ioutil.ReadAll(content)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	v := make([]byte, boltValueHeaderSize+len(b))
+	binary.BigEndian.PutUint64(v, uint64(now.Add(expiration).UnixNano()))
+	binary.BigEndian.PutUint64(v[8:], uint64(now.UnixNano()))
+	copy(v[boltValueHeaderSize:], b)
+
+	return db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltBucket).Put([]byte(name), v)
+	})
+}
+
+// Delete implements the [Deleter].
+func (bc *BoltCacher) Delete(ctx context.Context, name string) error {
+	if c := bc.zipCacher(name); c != nil {
+		d, ok := c.(Deleter)
+		if !ok {
+			return errDeleteNotSupported
+		}
+
+		return d.Delete(ctx, name)
+	}
+
+	db, err := bc.open()
+	if err != nil {
+		return err
+	}
+
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		if b.Get([]byte(name)) == nil {
+			return os.ErrNotExist
+		}
+
+		return b.Delete([]byte(name))
+	})
+}
+
+// Cleanup implements the [Cacher].
+func (bc *BoltCacher) Cleanup() error {
+	return bc.CleanupReclaimed(nil)
+}
+
+// CleanupReclaimed implements the [Reclaimer]. The caches delegated to the
+// [BoltCacher.ZipCacher] are reported only if it implements the [Reclaimer].
+//
+// Note that the database file does not shrink, the freed pages are reused by
+// later caches instead.
+func (bc *BoltCacher) CleanupReclaimed(
+	reclaimed func(name string, size int64),
+) error {
+	db, err := bc.open()
+	if err != nil {
+		return err
+	}
+
+	var (
+		names []string
+		sizes []int64
+	)
+	if err := db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		now := time.Now()
+		if err := b.ForEach(func(k, v []byte) error {
+			if len(v) >= boltValueHeaderSize {
+				expires, _ := parseBoltValueHeader(v)
+				if !now.After(expires) {
+					return nil
+				}
+			}
+
+			size := int64(len(v) - boltValueHeaderSize)
+			if size < 0 {
+				size = 0 // Corrupted
+			}
+
+			names = append(names, string(k))
+			sizes = append(sizes, size)
+
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		// Keys must not be deleted while iterating over the bucket.
+		for _, name := range names {
+			if err := b.Delete([]byte(name)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if reclaimed != nil {
+		for i, name := range names {
+			reclaimed(name, sizes[i])
+		}
+	}
+
+	if bc.ZipCacher == nil {
+		return nil
+	} else if r, ok := bc.ZipCacher.(Reclaimer); ok {
+		return r.CleanupReclaimed(reclaimed)
+	}
+
+	return bc.ZipCacher.Cleanup()
+}
+
+// List implements the [Lister].
+func (bc *BoltCacher) List(ctx context.Context, prefix string) CacheIterator {
+	return listCaches(ctx, bc.walkCaches, prefix)
+}
+
+// walkCaches implements the [cacheWalker]. The caches delegated to the
+// [BoltCacher.ZipCacher] are walked only if it implements the [cacheWalker].
+func (bc *BoltCacher) walkCaches(
+	fn func(name string, size int64) error,
+) error {
+	db, err := bc.open()
+	if err != nil {
+		return err
+	}
+
+	// The fn is called outside of the transaction, since it may write to
+	// the database itself.
+	var (
+		names []string
+		sizes []int64
+	)
+	if err := db.View(func(tx *bbolt.Tx) error {
+		now := time.Now()
+		return tx.Bucket(boltBucket).ForEach(func(k, v []byte) error {
+			if len(v) < boltValueHeaderSize {
+				return nil
+			}
+
+			expires, _ := parseBoltValueHeader(v)
+			if now.After(expires) {
+				return nil
+			}
+
+			names = append(names, string(k))
+			sizes = append(sizes, int64(len(v)-boltValueHeaderSize))
+
+			return nil
+		})
+	}); err != nil {
+		return err
+	}
+
+	for i, name := range names {
+		if err := fn(name, sizes[i]); err != nil {
+			return err
+		}
+	}
+
+	if cw, ok := cacheWalkerOf(bc.ZipCacher); ok {
+		return cw.walkCaches(fn)
+	}
+
+	return nil
+}
+
+// Close closes the database file of the bc, if it has been opened. The bc must
+// not be used after calling this.
+func (bc *BoltCacher) Close() error {
+	bc.openOnce.Do(func() {
+		bc.openErr = errors.New("bolt cacher closed")
+	})
+
+	if bc.db == nil {
+		return nil
+	}
+
+	return bc.db.Close()
+}
+
+// open returns the database of the bc, opening it on first call.
+func (bc *BoltCacher) open() (*bbolt.DB, error) {
+	bc.openOnce.Do(func() {
+		if err := os.MkdirAll(filepath.Dir(bc.Path), 0750); err != nil {
+			bc.openErr = err
+			return
+		}
+
+		db, err := bbolt.Open(bc.Path, 0600, &bbolt.Options{
+			Timeout: boltOpenTimeout,
+		})
+		if err != nil {
+			bc.openErr = err
+			return
+		}
+
+		if err := db.Update(func(tx *bbolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists(boltBucket)
+			return err
+		}); err != nil {
+			db.Close()
+			bc.openErr = err
+			return
+		}
+
+		bc.db = db
+	})
+
+	return bc.db, bc.openErr
+}
+
+// zipCacher returns the [BoltCacher.ZipCacher] if the cache for the name is
+// delegated to it. Otherwise, it returns nil.
+func (bc *BoltCacher) zipCacher(name string) Cacher {
+	if bc.ZipCacher != nil && strings.HasSuffix(name, ".zip") {
+		return bc.ZipCacher
+	}
+
+	return nil
+}
+
+// parseBoltValueHeader parses the expiration time and the modification time
+// from the header of the v, which must be at least [boltValueHeaderSize] long.
+func parseBoltValueHeader(v []byte) (expires, modTime time.Time) {
+	expires = time.Unix(0, int64(binary.BigEndian.Uint64(v)))
+	modTime = time.Unix(0, int64(binary.BigEndian.Uint64(v[8:])))
+	return
+}
@@ -0,0 +1,139 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBoltCacher(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestBoltCacher")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	bc := &BoltCacher{
+		Path:      filepath.Join(tempDir, "bolt", "caches.db"),
+		ZipCacher: DirCacher(filepath.Join(tempDir, "zips")),
+	}
+	defer bc.Close()
+
+	ctx := context.Background()
+	put := func(name, content string, expiration time.Duration) {
+		if err := bc.Put(
+			ctx,
+			name,
+			strings.NewReader(content),
+			expiration,
+		); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	get := func(name string) (string, error) {
+		content, err := bc.Get(ctx, name)
+		if err != nil {
+			return "", err
+		}
+		defer content.Close()
+
+		b, err := ioutil.ReadAll(content)
+		return string(b), err
+	}
+
+	put("example.com/@v/v1.0.0.info", "foo", time.Hour)
+	put("example.com/@v/v1.0.0.mod", "bar", -time.Hour)
+	put("example.com/@v/v1.0.0.zip", "baz", time.Hour)
+
+	if got, err := get("example.com/@v/v1.0.0.info"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if want := "foo"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if _, err := get(
+		"example.com/@v/v1.0.0.mod",
+	); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got error %q, want error %q", err, os.ErrNotExist)
+	}
+
+	if _, err := os.Stat(filepath.Join(
+		tempDir,
+		"zips",
+		"example.com",
+		"@v",
+		"v1.0.0.zip",
+	)); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	content, err := bc.Get(ctx, "example.com/@v/v1.0.0.info")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	expires := content.(interface{ Expires() time.Time }).Expires()
+	content.Close()
+	if got := time.Until(expires); got > time.Hour ||
+		got < 59*time.Minute {
+		t.Errorf("got %s, want about %s", got, time.Hour)
+	}
+
+	put("example.com/@latest", "qux", time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+
+	var reclaimed []string
+	if err := bc.CleanupReclaimed(func(name string, size int64) {
+		reclaimed = append(reclaimed, name)
+	}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := strings.Join(reclaimed, ","),
+		"example.com/@latest"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	var names []string
+	if err := bc.walkCaches(func(name string, size int64) error {
+		names = append(names, name)
+		return nil
+	}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	sort.Strings(names)
+	if got, want := strings.Join(names, " "), "example.com/@v/v1.0.0.info "+
+		"example.com/@v/v1.0.0.zip"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if err := bc.Delete(ctx, "example.com/@v/v1.0.0.info"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if err := bc.Delete(
+		ctx,
+		"example.com/@v/v1.0.0.info",
+	); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got error %q, want error %q", err, os.ErrNotExist)
+	}
+
+	// The caches survive reopening the database file.
+	put("example.com/@v/list", "v1.0.0", time.Hour)
+	if err := bc.Close(); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	bc = &BoltCacher{Path: bc.Path}
+	defer bc.Close()
+
+	if got, err := get("example.com/@v/list"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if want := "v1.0.0"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,242 @@
+package goproxy
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultBulkPause is the default amount of time bulk work is paused for after
+// an upstream responded with a 429 Too Many Requests.
+const defaultBulkPause = time.Minute
+
+// withBulk returns a copy of the ctx marked as being of bulk work (e.g.
+// read-aheads), which yields to interactive work when talking to upstreams. It
+// carries the [RequestPriorityBackground].
+func withBulk(ctx context.Context) context.Context {
+	return withPriority(ctx, RequestPriorityBackground)
+}
+
+// isBulk reports whether the ctx is marked as being of bulk work, which is the
+// case for all priorities below the [RequestPriorityInteractive].
+func isBulk(ctx context.Context) bool {
+	return priorityOf(ctx) != RequestPriorityInteractive
+}
+
+// upstreamLimiter is a token bucket of the requests sent to an upstream host,
+// shared by interactive and bulk work. Interactive requests always take a
+// token, even if there is none left, while bulk requests wait until a token is
+// available beyond the headroom kept for interactive requests.
+type upstreamLimiter struct {
+	mutex       sync.Mutex
+	rate        float64
+	burst       float64
+	headroom    float64
+	tokens      float64
+	updatedAt   time.Time
+	pausedUntil time.Time
+}
+
+// newUpstreamLimiter returns a new instance of the [upstreamLimiter] with the
+// rate (in requests per second) and the burst at the now.
+func newUpstreamLimiter(
+	rate float64,
+	burst int,
+	now time.Time,
+) *upstreamLimiter {
+	return &upstreamLimiter{
+		rate:      rate,
+		burst:     float64(burst),
+		headroom:  float64(burst / 2),
+		tokens:    float64(burst),
+		updatedAt: now,
+	}
+}
+
+// refill refills the tokens of the ul at the now. The ul.mutex must be held.
+func (ul *upstreamLimiter) refill(now time.Time) {
+	if elapsed := now.Sub(ul.updatedAt); elapsed > 0 {
+		ul.tokens += elapsed.Seconds() * ul.rate
+		if ul.tokens > ul.burst {
+			ul.tokens = ul.burst
+		}
+
+		ul.updatedAt = now
+	}
+}
+
+// take takes a token for an interactive request at the now. The tokens may go
+// into debt, which bulk work has to pay off, down to a full burst.
+func (ul *upstreamLimiter) take(now time.Time) {
+	ul.mutex.Lock()
+	defer ul.mutex.Unlock()
+	ul.refill(now)
+	ul.tokens = math.Max(ul.tokens-1, -ul.burst)
+}
+
+// takeBulk takes a token for a bulk request at the now if one is available
+// beyond the headroom. Otherwise, it returns how long to wait before trying
+// again.
+func (ul *upstreamLimiter) takeBulk(now time.Time) time.Duration {
+	ul.mutex.Lock()
+	defer ul.mutex.Unlock()
+	if now.Before(ul.pausedUntil) {
+		return ul.pausedUntil.Sub(now)
+	}
+
+	ul.refill(now)
+	if missing := ul.headroom + 1 - ul.tokens; missing > 0 {
+		return time.Duration(missing / ul.rate * float64(time.Second))
+	}
+
+	ul.tokens--
+	return 0
+}
+
+// pause pauses the bulk requests until the t.
+func (ul *upstreamLimiter) pause(t time.Time) {
+	ul.mutex.Lock()
+	defer ul.mutex.Unlock()
+	if t.After(ul.pausedUntil) {
+		ul.pausedUntil = t
+	}
+}
+
+// bulkScheduler schedules the requests sent to upstreams so that bulk work
+// stays within their rate limits, using the headroom left by interactive work.
+// It is safe for concurrent use.
+type bulkScheduler struct {
+	mutex    sync.Mutex
+	rate     float64
+	burst    int
+	limiters map[string]*upstreamLimiter
+}
+
+// newBulkScheduler returns a new instance of the [bulkScheduler] with the rate
+// (in requests per second) and the burst of each upstream host.
+func newBulkScheduler(rate float64, burst int) *bulkScheduler {
+	if burst <= 0 {
+		burst = int(math.Ceil(rate))
+		if burst < 1 {
+			burst = 1
+		}
+	}
+
+	return &bulkScheduler{
+		rate:     rate,
+		burst:    burst,
+		limiters: map[string]*upstreamLimiter{},
+	}
+}
+
+// limiter returns the [upstreamLimiter] of the host.
+func (bs *bulkScheduler) limiter(host string) *upstreamLimiter {
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	ul, ok := bs.limiters[host]
+	if !ok {
+		ul = newUpstreamLimiter(bs.rate, bs.burst, time.Now())
+		bs.limiters[host] = ul
+	}
+
+	return ul
+}
+
+// wait waits until a request may be sent to the host. Interactive requests
+// never wait. It reports whether a bulk request has been delayed.
+func (bs *bulkScheduler) wait(ctx context.Context, host string) (bool, error) {
+	ul := bs.limiter(host)
+	if !isBulk(ctx) {
+		ul.take(time.Now())
+		return false, nil
+	}
+
+	var delayed bool
+	for {
+		delay := ul.takeBulk(time.Now())
+		if delay <= 0 {
+			return delayed, nil
+		}
+
+		delayed = true
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return delayed, ctx.Err()
+		}
+	}
+}
+
+// bulkTransport is an [http.RoundTripper] that schedules the requests via the
+// [bulkScheduler] of a [Goproxy].
+type bulkTransport struct {
+	g         *Goproxy
+	transport http.RoundTripper
+}
+
+// RoundTrip implements the [http.RoundTripper].
+func (bt *bulkTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := bt.transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	delayed, err := bt.g.bulkScheduler.wait(req.Context(), req.URL.Host)
+	if delayed {
+		bt.g.updateStats(func(s *Stats) { s.BulkRequestsDelayed++ })
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if pause, ok := parseRetryAfter(res, time.Now()); ok {
+		ul := bt.g.bulkScheduler.limiter(req.URL.Host)
+		ul.pause(time.Now().Add(pause))
+		bt.g.updateStats(func(s *Stats) { s.BulkPauses++ })
+	}
+
+	return res, nil
+}
+
+// parseRetryAfter returns how long the res asks to wait before retrying at the
+// now, if it is a 429 Too Many Requests, or a 503 Service Unavailable with a
+// Retry-After. A 429 without a valid Retry-After asks for the
+// [defaultBulkPause].
+func parseRetryAfter(
+	res *http.Response,
+	now time.Time,
+) (time.Duration, bool) {
+	if res.StatusCode != http.StatusTooManyRequests &&
+		res.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+
+	retryAfter := res.Header.Get("Retry-After")
+	seconds, err := strconv.Atoi(retryAfter)
+	if err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	} else if t, err := http.ParseTime(retryAfter); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d, true
+		}
+
+		return 0, true
+	}
+
+	if res.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+
+	return defaultBulkPause, true
+}
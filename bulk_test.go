@@ -0,0 +1,186 @@
+package goproxy
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestUpstreamLimiter(t *testing.T) {
+	now := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	ul := newUpstreamLimiter(2, 4, now)
+	for i := 0; i < 2; i++ {
+		if got := ul.takeBulk(now); got != 0 {
+			t.Errorf("#%d: got %s, want 0s", i, got)
+		}
+	}
+
+	if got, want := ul.takeBulk(now), 500*time.Millisecond; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	ul.take(now)
+	ul.take(now)
+	if got, want := ul.takeBulk(now), 1500*time.Millisecond; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	now = now.Add(2 * time.Second)
+	if got := ul.takeBulk(now); got != 0 {
+		t.Errorf("got %s, want 0s", got)
+	}
+
+	for i := 0; i < 10; i++ {
+		ul.take(now)
+	}
+
+	if got, want := ul.tokens, -4.0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	now = now.Add(time.Hour)
+	ul.pause(now.Add(time.Minute))
+	ul.pause(now.Add(time.Second))
+	if got, want := ul.takeBulk(now), time.Minute; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	if got := ul.takeBulk(now.Add(time.Minute)); got != 0 {
+		t.Errorf("got %s, want 0s", got)
+	}
+}
+
+func TestNewBulkScheduler(t *testing.T) {
+	for _, tt := range []struct {
+		rate  float64
+		burst int
+		want  int
+	}{
+		{0.5, 0, 1},
+		{2.5, 0, 3},
+		{2.5, 10, 10},
+	} {
+		bs := newBulkScheduler(tt.rate, tt.burst)
+		if got := bs.burst; got != tt.want {
+			t.Errorf("got %d, want %d", got, tt.want)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		statusCode int
+		retryAfter string
+		wantPause  time.Duration
+		wantOK     bool
+	}{
+		{http.StatusOK, "10", 0, false},
+		{http.StatusTooManyRequests, "10", 10 * time.Second, true},
+		{http.StatusTooManyRequests, "", defaultBulkPause, true},
+		{
+			http.StatusTooManyRequests,
+			now.Add(time.Hour).Format(http.TimeFormat),
+			time.Hour,
+			true,
+		},
+		{
+			http.StatusServiceUnavailable,
+			now.Add(-time.Hour).Format(http.TimeFormat),
+			0,
+			true,
+		},
+		{http.StatusServiceUnavailable, "", 0, false},
+	} {
+		res := &http.Response{
+			StatusCode: tt.statusCode,
+			Header:     http.Header{},
+		}
+		if tt.retryAfter != "" {
+			res.Header.Set("Retry-After", tt.retryAfter)
+		}
+
+		pause, ok := parseRetryAfter(res, now)
+		if pause != tt.wantPause || ok != tt.wantOK {
+			t.Errorf(
+				"got %s, %t, want %s, %t",
+				pause,
+				ok,
+				tt.wantPause,
+				tt.wantOK,
+			)
+		}
+	}
+}
+
+func TestGoproxyBulkScheduler(t *testing.T) {
+	var tooManyRequests bool
+	server := httptest.NewServer(http.HandlerFunc(func(
+		rw http.ResponseWriter,
+		req *http.Request,
+	) {
+		if tooManyRequests {
+			rw.Header().Set("Retry-After", "3600")
+			rw.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		responseString(rw, req, http.StatusOK, -2, "v1.0.0")
+	}))
+	defer server.Close()
+
+	g := &Goproxy{
+		UpstreamRateLimit: 1000,
+		UpstreamRateBurst: 2,
+		ErrorLogger:       log.New(&discardWriter{}, "", 0),
+	}
+	g.initOnce.Do(g.init)
+
+	get := func(ctx context.Context) error {
+		_, err := httpGetWithHeader(
+			ctx,
+			g.httpClient,
+			server.URL+"/example.com/@v/list",
+			nil,
+			nil,
+		)
+		return err
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := get(context.Background()); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	if err := get(withBulk(context.Background())); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if got, want := g.Stats().BulkRequestsDelayed, int64(1); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	tooManyRequests = true
+	res, err := g.httpClient.Get(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	res.Body.Close()
+
+	if got := g.Stats().BulkPauses; got == 0 {
+		t.Error("expected non-zero bulk pauses")
+	}
+
+	ctx, cancel := context.WithTimeout(
+		withBulk(context.Background()),
+		10*time.Millisecond,
+	)
+	defer cancel()
+	if err := get(ctx); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,159 @@
+package goproxy
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// upstreamHeadersNamePrefix is the prefix of the names of the caches holding
+// the [upstreamHeaders] of the caches fetched from upstream module proxies.
+const upstreamHeadersNamePrefix = apiPathPrefix + "upstream-headers/"
+
+// upstreamHeaders is the caching-related headers of a response from an
+// upstream module proxy.
+type upstreamHeaders struct {
+	// ETag and LastModified are the validators of the response, used to
+	// revalidate it conditionally.
+	ETag         string `json:",omitempty"`
+	LastModified string `json:",omitempty"`
+
+	// FreshUntil is the time until which the response is fresh as
+	// allowed by the upstream. It is zero if the response must always be
+	// revalidated.
+	FreshUntil time.Time `json:",omitempty"`
+}
+
+// parseUpstreamHeaders parses the header of a response received at the now
+// into an [upstreamHeaders]. It also returns the freshness lifetime of the
+// response, which is negative if the header does not specify it.
+func parseUpstreamHeaders(
+	header http.Header,
+	now time.Time,
+) (*upstreamHeaders, time.Duration) {
+	uh := &upstreamHeaders{
+		ETag:         header.Get("ETag"),
+		LastModified: header.Get("Last-Modified"),
+	}
+
+	lifetime := time.Duration(-1)
+	var maxAge, sMaxAge string
+	for _, directive := range strings.Split(
+		header.Get("Cache-Control"),
+		",",
+	) {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		name, value := directive, ""
+		if i := strings.IndexByte(directive, '='); i >= 0 {
+			name = directive[:i]
+			value = strings.Trim(directive[i+1:], `"`)
+		}
+
+		switch name {
+		case "no-store", "no-cache":
+			return uh, 0
+		case "max-age":
+			maxAge = value
+		case "s-maxage":
+			sMaxAge = value
+		}
+	}
+
+	if sMaxAge != "" {
+		maxAge = sMaxAge
+	}
+
+	if maxAge != "" {
+		seconds, err := strconv.ParseInt(maxAge, 10, 64)
+		if err == nil && seconds >= 0 {
+			lifetime = time.Duration(seconds) * time.Second
+		}
+	} else if expires := header.Get("Expires"); expires != "" {
+		date := now
+		if t, err := http.ParseTime(header.Get("Date")); err == nil {
+			date = t
+		}
+
+		lifetime = 0
+		t, err := http.ParseTime(expires)
+		if err == nil && t.After(date) {
+			lifetime = t.Sub(date)
+		}
+	}
+
+	if lifetime > 0 {
+		uh.FreshUntil = now.Add(lifetime)
+	}
+
+	return uh, lifetime
+}
+
+// conditionalHeader returns the request header that revalidates the response
+// described by the uh conditionally. It returns nil if the uh has no
+// validators.
+func (uh *upstreamHeaders) conditionalHeader() http.Header {
+	if uh == nil || (uh.ETag == "" && uh.LastModified == "") {
+		return nil
+	}
+
+	header := http.Header{}
+	if uh.ETag != "" {
+		header.Set("If-None-Match", uh.ETag)
+	}
+
+	if uh.LastModified != "" {
+		header.Set("If-Modified-Since", uh.LastModified)
+	}
+
+	return header
+}
+
+// upstreamHeaders returns the [upstreamHeaders] of the cache for the name. It
+// returns nil if there is none.
+func (g *Goproxy) upstreamHeaders(
+	ctx context.Context,
+	name string,
+) *upstreamHeaders {
+	content, err := g.cache(ctx, upstreamHeadersNamePrefix+name)
+	if err != nil {
+		return nil
+	}
+	defer content.Close()
+
+	b, err := ioutil.ReadAll(content)
+	if err != nil {
+		return nil
+	}
+
+	uh := &upstreamHeaders{}
+	if json.Unmarshal(b, uh) != nil {
+		return nil
+	}
+
+	return uh
+}
+
+// putUpstreamHeaders puts the uh of the cache for the name to the g.Cacher
+// with the expiration.
+func (g *Goproxy) putUpstreamHeaders(
+	ctx context.Context,
+	name string,
+	uh *upstreamHeaders,
+	expiration time.Duration,
+) error {
+	b, err := json.Marshal(uh)
+	if err != nil {
+		return err
+	}
+
+	return g.putCache(
+		ctx,
+		upstreamHeadersNamePrefix+name,
+		strings.NewReader(string(b)),
+		expiration,
+	)
+}
@@ -0,0 +1,219 @@
+package goproxy
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseUpstreamHeaders(t *testing.T) {
+	now := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		header       http.Header
+		wantLifetime time.Duration
+	}{
+		{http.Header{}, -1},
+		{http.Header{"Cache-Control": {"max-age=60"}}, time.Minute},
+		{
+			http.Header{"Cache-Control": {"public, max-age=60, s-maxage=120"}},
+			2 * time.Minute,
+		},
+		{http.Header{"Cache-Control": {`max-age="60"`}}, time.Minute},
+		{http.Header{"Cache-Control": {"max-age=60, no-cache"}}, 0},
+		{http.Header{"Cache-Control": {"no-store"}}, 0},
+		{http.Header{"Cache-Control": {"max-age=invalid"}}, -1},
+		{
+			http.Header{
+				"Date":    {"Sat, 01 Jan 2000 00:00:00 GMT"},
+				"Expires": {"Sat, 01 Jan 2000 01:00:00 GMT"},
+			},
+			time.Hour,
+		},
+		{
+			http.Header{"Expires": {"Sat, 01 Jan 2000 00:01:00 GMT"}},
+			time.Minute,
+		},
+		{http.Header{"Expires": {"0"}}, 0},
+		{
+			http.Header{
+				"Cache-Control": {"max-age=60"},
+				"Expires":       {"Sat, 01 Jan 2000 01:00:00 GMT"},
+			},
+			time.Minute,
+		},
+	} {
+		uh, lifetime := parseUpstreamHeaders(tt.header, now)
+		if lifetime != tt.wantLifetime {
+			t.Errorf(
+				"%v: got %s, want %s",
+				tt.header,
+				lifetime,
+				tt.wantLifetime,
+			)
+		}
+
+		var wantFreshUntil time.Time
+		if tt.wantLifetime > 0 {
+			wantFreshUntil = now.Add(tt.wantLifetime)
+		}
+
+		if !uh.FreshUntil.Equal(wantFreshUntil) {
+			t.Errorf(
+				"%v: got %s, want %s",
+				tt.header,
+				uh.FreshUntil,
+				wantFreshUntil,
+			)
+		}
+	}
+
+	uh, _ := parseUpstreamHeaders(http.Header{
+		"Etag":          {`"foo"`},
+		"Last-Modified": {"Sat, 01 Jan 2000 00:00:00 GMT"},
+	}, now)
+	header := uh.conditionalHeader()
+	if got, want := header.Get("If-None-Match"), `"foo"`; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if got, want := header.Get("If-Modified-Since"),
+		"Sat, 01 Jan 2000 00:00:00 GMT"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if header := (&upstreamHeaders{}).conditionalHeader(); header != nil {
+		t.Errorf("got %v, want nil", header)
+	}
+
+	if header := (*upstreamHeaders)(nil).conditionalHeader(); header != nil {
+		t.Errorf("got %v, want nil", header)
+	}
+}
+
+func TestGoproxyUpstreamCacheHeaders(t *testing.T) {
+	tempDir, err := ioutil.TempDir(
+		"",
+		"goproxy.TestGoproxyUpstreamCacheHeaders",
+	)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	var (
+		requests    int
+		notModified int
+		maxAge      = "3600"
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(
+		rw http.ResponseWriter,
+		req *http.Request,
+	) {
+		requests++
+		switch req.URL.Path {
+		case "/example.com/@v/list":
+			if req.Header.Get("If-None-Match") == `"v1"` {
+				notModified++
+				rw.Header().Set("Cache-Control", "max-age=0")
+				rw.WriteHeader(http.StatusNotModified)
+				return
+			}
+
+			rw.Header().Set("ETag", `"v1"`)
+			rw.Header().Set("Cache-Control", "max-age="+maxAge)
+			responseString(rw, req, http.StatusOK, -2, "v1.0.0\n")
+		case "/example.com/@v/v1.0.0.mod":
+			rw.Header().Set("Cache-Control", "max-age=7200")
+			responseString(
+				rw,
+				req,
+				http.StatusOK,
+				-2,
+				"module example.com",
+			)
+		default:
+			responseNotFound(rw, req, -2)
+		}
+	}))
+	defer server.Close()
+
+	g := &Goproxy{
+		Cacher:               DirCacher(tempDir),
+		GoBinEnv:             []string{"GOPROXY=" + server.URL, "GOSUMDB=off"},
+		UpstreamCacheHeaders: true,
+		ErrorLogger:          log.New(&discardWriter{}, "", 0),
+	}
+
+	get := func(path string) string {
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest("", path, nil))
+		if got, want := rec.Code, http.StatusOK; got != want {
+			t.Fatalf("got %d, want %d", got, want)
+		}
+
+		return rec.Body.String()
+	}
+
+	for i := 0; i < 3; i++ {
+		if got, want := get("/example.com/@v/list"),
+			"v1.0.0"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+
+	if got, want := requests, 1; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	// A stale cache is revalidated conditionally.
+	maxAge = "0"
+	if err := g.Cacher.(DirCacher).Delete(
+		context.Background(),
+		upstreamHeadersNamePrefix+"example.com/@v/list",
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if got, want := get("/example.com/@v/list"),
+			"v1.0.0"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+
+	if got, want := notModified, 1; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	uh := g.upstreamHeaders(context.Background(), "example.com/@v/list")
+	if uh == nil {
+		t.Fatal("expected upstream headers")
+	} else if got, want := uh.ETag, `"v1"`; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	} else if !uh.FreshUntil.IsZero() {
+		t.Errorf("got %s, want zero time", uh.FreshUntil)
+	}
+
+	if got, want := get("/example.com/@v/v1.0.0.mod"),
+		"module example.com"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	meta, err := readDirCacheMetadata(filepath.Join(
+		tempDir,
+		"example.com/@v/v1.0.0.mod",
+	))
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if got := time.Until(meta.Expires); got < time.Hour {
+		t.Errorf("got %s, want more than %s", got, time.Hour)
+	}
+}
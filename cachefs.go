@@ -0,0 +1,327 @@
+//go:build go1.16
+
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheFS returns a read-only [fs.FS] view of the module files cached in the
+// [Goproxy.Cacher], in the layout of the module download cache (see
+// https://go.dev/ref/mod#module-cache), e.g. "example.com/foo/@v/v1.0.0.zip".
+// It lets other Go programs in the same process consume cached modules
+// directly, without HTTP round trips. Nothing is ever fetched through it.
+//
+// Only the ".info", ".mod" and ".zip" files and the "list" files are exposed.
+// Directories can only be read if the Goproxy.Cacher implements the [Lister].
+// The files opened from it implement the [io.Seeker] and the [io.ReaderAt] if
+// the underlying caches implement the io.Seeker.
+func (g *Goproxy) CacheFS() fs.FS {
+	g.initOnce.Do(g.init)
+	return cacheFS{g: g}
+}
+
+// cacheFS is the [fs.FS] returned by the [Goproxy.CacheFS].
+type cacheFS struct {
+	g *Goproxy
+}
+
+// Open implements the [fs.FS].
+func (cfs cacheFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{
+			Op:   "open",
+			Path: name,
+			Err:  fs.ErrInvalid,
+		}
+	}
+
+	if isCacheFSName(name) {
+		content, err := cfs.g.cache(context.Background(), name)
+		if err == nil {
+			return newCacheFSFile(name, content)
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return nil, &fs.PathError{
+				Op:   "open",
+				Path: name,
+				Err:  err,
+			}
+		}
+	}
+
+	entries, err := cfs.readDir(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	return &cacheFSDir{name: name, entries: entries}, nil
+}
+
+// readDir returns the entries of the directory targeted by the name, sorted by
+// their names.
+func (cfs cacheFS) readDir(name string) ([]fs.DirEntry, error) {
+	var prefix string
+	if name != "." {
+		prefix = name + "/"
+	}
+
+	entries := map[string]fs.DirEntry{}
+	if cfs.g.Cacher != nil {
+		it := listCacher(context.Background(), cfs.g.Cacher, prefix)
+		defer it.Close()
+		for it.Next() {
+			ci := it.Cache()
+			if !isCacheFSName(ci.Name) {
+				continue
+			}
+
+			rest := strings.TrimPrefix(ci.Name, prefix)
+			if i := strings.Index(rest, "/"); i >= 0 {
+				entries[rest[:i]] = cacheFSFileInfo{
+					name: rest[:i],
+					dir:  true,
+				}
+			} else {
+				entries[rest] = cacheFSFileEntry{
+					cfs:  cfs,
+					name: ci.Name,
+				}
+			}
+		}
+
+		if err := it.Err(); err != nil &&
+			!errors.Is(err, errWalkNotSupported) {
+			return nil, err
+		}
+	}
+
+	if len(entries) == 0 && name != "." {
+		return nil, fs.ErrNotExist
+	}
+
+	sortedEntries := make([]fs.DirEntry, 0, len(entries))
+	for _, entry := range entries {
+		sortedEntries = append(sortedEntries, entry)
+	}
+
+	sort.Slice(sortedEntries, func(i, j int) bool {
+		return sortedEntries[i].Name() < sortedEntries[j].Name()
+	})
+
+	return sortedEntries, nil
+}
+
+// isCacheFSName reports whether the name targets a cache exposed by the
+// [Goproxy.CacheFS].
+func isCacheFSName(name string) bool {
+	if _, _, ok := parseModuleVersionName(name); ok {
+		return true
+	}
+
+	return strings.HasSuffix(name, "/@v/list") &&
+		!strings.HasPrefix(name, "sumdb/") &&
+		!strings.HasPrefix(name, apiPathPrefix)
+}
+
+// cacheFSFileInfo is the [fs.FileInfo] of the files and directories of the
+// [Goproxy.CacheFS], and the [fs.DirEntry] of the directories.
+type cacheFSFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+// Name implements the [fs.FileInfo].
+func (cfi cacheFSFileInfo) Name() string { return cfi.name }
+
+// Size implements the [fs.FileInfo].
+func (cfi cacheFSFileInfo) Size() int64 { return cfi.size }
+
+// Mode implements the [fs.FileInfo].
+func (cfi cacheFSFileInfo) Mode() fs.FileMode {
+	if cfi.dir {
+		return fs.ModeDir | 0555
+	}
+
+	return 0444
+}
+
+// ModTime implements the [fs.FileInfo].
+func (cfi cacheFSFileInfo) ModTime() time.Time { return cfi.modTime }
+
+// IsDir implements the [fs.FileInfo].
+func (cfi cacheFSFileInfo) IsDir() bool { return cfi.dir }
+
+// Sys implements the [fs.FileInfo].
+func (cfi cacheFSFileInfo) Sys() interface{} { return nil }
+
+// Type implements the [fs.DirEntry].
+func (cfi cacheFSFileInfo) Type() fs.FileMode { return cfi.Mode().Type() }
+
+// Info implements the [fs.DirEntry].
+func (cfi cacheFSFileInfo) Info() (fs.FileInfo, error) { return cfi, nil }
+
+// cacheFSFileEntry is the [fs.DirEntry] of a file of the [Goproxy.CacheFS],
+// whose [fs.FileInfo] is read from the cache only when requested.
+type cacheFSFileEntry struct {
+	cfs  cacheFS
+	name string
+}
+
+// Name implements the [fs.DirEntry].
+func (cfe cacheFSFileEntry) Name() string { return path.Base(cfe.name) }
+
+// IsDir implements the [fs.DirEntry].
+func (cacheFSFileEntry) IsDir() bool { return false }
+
+// Type implements the [fs.DirEntry].
+func (cacheFSFileEntry) Type() fs.FileMode { return 0 }
+
+// Info implements the [fs.DirEntry].
+func (cfe cacheFSFileEntry) Info() (fs.FileInfo, error) {
+	f, err := cfe.cfs.Open(cfe.name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return f.Stat()
+}
+
+// cacheFSFile is a file of the [Goproxy.CacheFS].
+type cacheFSFile struct {
+	io.ReadCloser
+	info        cacheFSFileInfo
+	readAtMutex sync.Mutex
+}
+
+// newCacheFSFile returns a new [cacheFSFile] for the content of the cache for
+// the name.
+func newCacheFSFile(name string, content io.ReadCloser) (fs.File, error) {
+	info := cacheFSFileInfo{name: path.Base(name), size: -1}
+	if s, ok := content.(interface{ Size() int64 }); ok {
+		info.size = s.Size()
+	} else if s, ok := content.(io.Seeker); ok {
+		size, err := s.Seek(0, io.SeekEnd)
+		if err == nil {
+			_, err = s.Seek(0, io.SeekStart)
+		}
+
+		if err != nil {
+			content.Close()
+			return nil, &fs.PathError{
+				Op:   "open",
+				Path: name,
+				Err:  err,
+			}
+		}
+
+		info.size = size
+	}
+
+	if lm, ok := content.(interface{ LastModified() time.Time }); ok {
+		info.modTime = lm.LastModified()
+	} else if mt, ok := content.(interface{ ModTime() time.Time }); ok {
+		info.modTime = mt.ModTime()
+	}
+
+	return &cacheFSFile{ReadCloser: content, info: info}, nil
+}
+
+// Stat implements the [fs.File].
+func (cff *cacheFSFile) Stat() (fs.FileInfo, error) {
+	return cff.info, nil
+}
+
+// Seek implements the [io.Seeker].
+func (cff *cacheFSFile) Seek(offset int64, whence int) (int64, error) {
+	s, ok := cff.ReadCloser.(io.Seeker)
+	if !ok {
+		return 0, errors.New("seek not supported")
+	}
+
+	return s.Seek(offset, whence)
+}
+
+// ReadAt implements the [io.ReaderAt].
+func (cff *cacheFSFile) ReadAt(b []byte, off int64) (int, error) {
+	if ra, ok := cff.ReadCloser.(io.ReaderAt); ok {
+		return ra.ReadAt(b, off)
+	}
+
+	s, ok := cff.ReadCloser.(io.Seeker)
+	if !ok {
+		return 0, errors.New("read at not supported")
+	}
+
+	cff.readAtMutex.Lock()
+	defer cff.readAtMutex.Unlock()
+
+	offset, err := s.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	defer s.Seek(offset, io.SeekStart)
+
+	if _, err := s.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	n, err := io.ReadFull(cff.ReadCloser, b)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+// cacheFSDir is a directory of the [Goproxy.CacheFS].
+type cacheFSDir struct {
+	name    string
+	entries []fs.DirEntry
+	offset  int
+}
+
+// Stat implements the [fs.File].
+func (cfd *cacheFSDir) Stat() (fs.FileInfo, error) {
+	return cacheFSFileInfo{name: path.Base(cfd.name), dir: true}, nil
+}
+
+// Read implements the [fs.File].
+func (cfd *cacheFSDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{
+		Op:   "read",
+		Path: cfd.name,
+		Err:  errors.New("is a directory"),
+	}
+}
+
+// Close implements the [fs.File].
+func (cfd *cacheFSDir) Close() error { return nil }
+
+// ReadDir implements the [fs.ReadDirFile].
+func (cfd *cacheFSDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	entries := cfd.entries[cfd.offset:]
+	if n > 0 {
+		if len(entries) == 0 {
+			return nil, io.EOF
+		}
+
+		if n < len(entries) {
+			entries = entries[:n]
+		}
+	}
+
+	cfd.offset += len(entries)
+
+	return entries, nil
+}
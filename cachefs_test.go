@@ -0,0 +1,110 @@
+//go:build go1.16
+
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestGoproxyCacheFS(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestGoproxyCacheFS")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	g := &Goproxy{Cacher: DirCacher(tempDir)}
+	for name, content := range map[string]string{
+		"example.com/foo/@v/list":        "v1.0.0",
+		"example.com/foo/@v/v1.0.0.info": `{"Version":"v1.0.0"}`,
+		"example.com/foo/@v/v1.0.0.mod":  "module example.com/foo",
+		"example.com/foo/@v/v1.0.0.zip":  "zip",
+		"example.com/foo/@latest":        `{"Version":"v1.0.0"}`,
+		"sumdb/sum.golang.org/latest":    "latest",
+		apiPathPrefix + "sumdb-keys/foo": "key",
+	} {
+		if err := g.Cacher.Put(
+			context.Background(),
+			name,
+			strings.NewReader(content),
+			time.Hour,
+		); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	cfs := g.CacheFS()
+	if err := fstest.TestFS(
+		cfs,
+		"example.com/foo/@v/list",
+		"example.com/foo/@v/v1.0.0.info",
+		"example.com/foo/@v/v1.0.0.mod",
+		"example.com/foo/@v/v1.0.0.zip",
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	b, err := fs.ReadFile(cfs, "example.com/foo/@v/v1.0.0.mod")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if got, want := string(b), "module example.com/foo"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	f, err := cfs.Open("example.com/foo/@v/v1.0.0.zip")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	b = make([]byte, 2)
+	if _, err := f.(io.ReaderAt).ReadAt(b, 1); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := string(b), "ip"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	f.Close()
+
+	entries, err := fs.ReadDir(cfs, ".")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if got, want := len(entries), 1; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	} else if got, want := entries[0].Name(), "example.com"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	for _, name := range []string{
+		"example.com/foo/@latest",
+		"example.com/foo/@v/v1.1.0.mod",
+		"sumdb/sum.golang.org/latest",
+		apiPathPrefix + "sumdb-keys/foo",
+		"sumdb",
+	} {
+		_, err := cfs.Open(name)
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf(
+				"got error %q, want error %q",
+				err,
+				fs.ErrNotExist,
+			)
+		}
+	}
+
+	if _, err := cfs.Open("/example.com"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("got error %q, want error %q", err, fs.ErrInvalid)
+	}
+}
@@ -1,12 +1,21 @@
 package goproxy
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -30,6 +39,8 @@ type Cacher interface {
 	//     when 1 is implemented. Note that the return value will be assumed
 	//     to have complied with RFC 7232, section 2.3, so it will be used
 	//     directly without further processing.
+	//  5. interface{ Expires() time.Time }, for the [TieredCacher] to
+	//     keep the caches it promotes from outliving the originals.
 	Get(ctx context.Context, name string) (io.ReadCloser, error)
 
 	// Put puts a cache for the name with the content and sets it to expire after the given duration.
@@ -39,8 +50,97 @@ type Cacher interface {
 	Cleanup() error
 }
 
+// Deleter is the interface that a [Cacher] can optionally implement to support
+// deleting caches.
+type Deleter interface {
+	// Delete deletes the cache for the name. It returns the
+	// [os.ErrNotExist] if not found.
+	Delete(ctx context.Context, name string) error
+}
+
+// PrefixDeleter is the interface that a [Cacher] can optionally implement to
+// support deleting all caches whose names have a prefix more efficiently than
+// enumerating all of its caches.
+type PrefixDeleter interface {
+	// DeletePrefix deletes all caches whose names have the prefix and
+	// returns their names.
+	DeletePrefix(ctx context.Context, prefix string) ([]string, error)
+}
+
+// Reclaimer is the interface that a [Cacher] can optionally implement to report
+// the caches removed by its cleanup.
+type Reclaimer interface {
+	// CleanupReclaimed is like the [Cacher.Cleanup], but also calls the
+	// reclaimed with the name and size of each removed cache.
+	CleanupReclaimed(reclaimed func(name string, size int64)) error
+}
+
+// StreamPutter is the interface that a [Cacher] can optionally implement to
+// put caches from contents that cannot be seeked, such as responses still being
+// received, without buffering them as a whole first.
+type StreamPutter interface {
+	// PutStream is like the [Cacher.Put], but reads the content only once,
+	// from start to end.
+	PutStream(
+		ctx context.Context,
+		name string,
+		content io.Reader,
+		expiration time.Duration,
+	) error
+}
+
+// putStream puts a cache for the name with the content to the cacher. If the
+// content cannot be seeked and the cacher does not implement the
+// [StreamPutter], the content is buffered in a temporary file in the tempDir
+// first.
+func putStream(
+	ctx context.Context,
+	cacher Cacher,
+	name string,
+	content io.Reader,
+	expiration time.Duration,
+	tempDir string,
+) error {
+	if rs, ok := content.(io.ReadSeeker); ok {
+		return cacher.Put(ctx, name, rs, expiration)
+	}
+
+	if sp, ok := cacher.(StreamPutter); ok {
+		return sp.PutStream(ctx, name, content, expiration)
+	}
+
+	tempFile, err := ioutil.TempFile(tempDir, "goproxy")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+
+	if _, err := io.Copy(tempFile, content); err != nil {
+		return err
+	} else if _, err := tempFile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	return cacher.Put(ctx, name, tempFile, expiration)
+}
+
 // DirCacher implements the [Cacher] using a directory on the local disk. If the
 // directory does not exist, it will be created with 0750 permissions.
+//
+// Module files put by a [Goproxy] from its [Goproxy.TempDir] on the same file
+// system as the directory are hard-linked into place instead of being copied.
+// Contents put by anyone else are always copied, so that later modifications
+// of their sources never leak into the caches. Files and directories in the
+// directory whose names start with "." are never treated as caches, so the
+// Goproxy.TempDir can be placed inside it (e.g. "<dir>/.tmp") to benefit from
+// that.
+//
+// The expiration time, the modification time, the ETag and the checksum of
+// each cache are kept in a sidecar file next to it (".<name>.meta"), so that
+// the modification time of the cache file is left untouched. Caches put before
+// sidecar files were introduced keep their expiration times as the
+// modification times of their files.
 type DirCacher string
 
 // Get implements the [Cacher].
@@ -50,29 +150,67 @@ func (dc DirCacher) Get(
 ) (io.ReadCloser, error) {
 	filePath := filepath.Join(string(dc), filepath.FromSlash(name))
 
-	// Check if the file has expired
-	expired, err := isCacheExpired(filePath)
+	f, err := os.Open(filePath)
 	if err != nil {
 		return nil, err
 	}
-	if expired {
-		return nil, os.ErrNotExist
-	}
 
-	f, err := os.Open(filePath)
+	fi, err := f.Stat()
 	if err != nil {
+		f.Close()
 		return nil, err
 	}
 
-	fi, err := f.Stat()
+	meta, err := readDirCacheMetadata(filePath)
 	if err != nil {
+		f.Close()
 		return nil, err
 	}
 
-	return &struct {
-		*os.File
-		os.FileInfo
-	}{f, fi}, nil
+	dcc := &dirCacheContent{f, fi, meta}
+	if !expiryNow(ctx).Before(dcc.Expires()) {
+		f.Close()
+		return nil, os.ErrNotExist
+	}
+
+	return dcc, nil
+}
+
+// dirCacheContent is the content of a cache got by the [DirCacher].
+type dirCacheContent struct {
+	*os.File
+	os.FileInfo
+
+	meta *dirCacheMetadata
+}
+
+// Expires returns the expiration time of the dcc. Caches put before the
+// [dirCacheMetadata] was introduced keep their expiration times as the
+// modification times of their files.
+func (dcc *dirCacheContent) Expires() time.Time {
+	if dcc.meta == nil {
+		return dcc.FileInfo.ModTime()
+	}
+
+	return dcc.meta.Expires
+}
+
+// ModTime returns the time when the dcc was put.
+func (dcc *dirCacheContent) ModTime() time.Time {
+	if dcc.meta == nil {
+		return dcc.FileInfo.ModTime()
+	}
+
+	return dcc.meta.ModTime
+}
+
+// ETag returns the ETag of the dcc, if known.
+func (dcc *dirCacheContent) ETag() string {
+	if dcc.meta == nil {
+		return ""
+	}
+
+	return dcc.meta.ETag
 }
 
 // Put implements the [Cacher].
@@ -81,15 +219,212 @@ func (dc DirCacher) Put(
 	name string,
 	content io.ReadSeeker,
 	expiration time.Duration,
+) error {
+	checksum, err := contentChecksum(content)
+	if err != nil {
+		return err
+	}
+
+	return dc.put(name, content, checksum, expiration)
+}
+
+// put is like the [DirCacher.Put], but with the known hex-encoded SHA-256
+// checksum of the content.
+func (dc DirCacher) put(
+	name string,
+	content io.ReadSeeker,
+	checksum string,
+	expiration time.Duration,
 ) error {
 	file := filepath.Join(string(dc), filepath.FromSlash(name))
 
-	dir := filepath.Dir(file)
-	if err := os.MkdirAll(dir, 0750); err != nil {
+	now := time.Now()
+	meta := &dirCacheMetadata{
+		Expires:  now.Add(expiration),
+		ModTime:  now,
+		ETag:     strconv.Quote(checksum),
+		Checksum: checksum,
+	}
+
+	err := putCacheFile(file, content, meta)
+	if os.IsNotExist(err) {
+		// The directory has most likely been removed by a concurrent
+		// cleanup after being found empty.
+		if _, err := content.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		err = putCacheFile(file, content, meta)
+	}
+
+	return err
+}
+
+// contentChecksum returns the hex-encoded SHA-256 checksum of the content, and
+// seeks it back to the start.
+func contentChecksum(content io.ReadSeeker) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, content); err != nil {
+		return "", err
+	} else if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// putCacheFile puts the content into the file along with its meta, creating its
+// directory first if needed. The meta is put first, so that the file is never
+// seen without it.
+func putCacheFile(
+	file string,
+	content io.ReadSeeker,
+	meta *dirCacheMetadata,
+) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0750); err != nil {
+		return err
+	} else if err := writeDirCacheMetadata(file, meta); err != nil {
+		return err
+	}
+
+	if linked, err := linkCacheFile(file, content); err != nil {
 		return err
+	} else if !linked {
+		return writeCacheFile(file, content)
+	}
+
+	return nil
+}
+
+// dirCacheMetadata is the metadata of a cache put by a [DirCacher], kept in a
+// sidecar file next to the cache file (see the [dirCacheMetadataPath]) rather
+// than being encoded in the attributes of the cache file, so that the
+// modification time of the cache file stays accurate.
+type dirCacheMetadata struct {
+	// Expires is the expiration time of the cache.
+	Expires time.Time
+
+	// ModTime is the time when the cache was put.
+	ModTime time.Time
+
+	// ETag is the ETag of the cache.
+	ETag string
+
+	// Checksum is the hex-encoded SHA-256 checksum of the cache.
+	Checksum string
+}
+
+// errInvalidDirCacheMetadata is returned when the sidecar file of a
+// [dirCacheMetadata] cannot be parsed.
+var errInvalidDirCacheMetadata = errors.New("invalid cache metadata")
+
+// dirCacheMetadataSuffix is the suffix of the names of the sidecar files of
+// the [dirCacheMetadata].
+const dirCacheMetadataSuffix = ".meta"
+
+// dirCacheMetadataPath returns the path of the sidecar file of the
+// [dirCacheMetadata] of the cache file targeted by the filePath. Its name
+// starts with "." so that it is never treated as a cache.
+func dirCacheMetadataPath(filePath string) string {
+	return filepath.Join(
+		filepath.Dir(filePath),
+		"."+filepath.Base(filePath)+dirCacheMetadataSuffix,
+	)
+}
+
+// readDirCacheMetadata reads the [dirCacheMetadata] of the cache file targeted
+// by the filePath. It returns nil if the cache file has no sidecar file (i.e.
+// it was put before the [dirCacheMetadata] was introduced).
+func readDirCacheMetadata(filePath string) (*dirCacheMetadata, error) {
+	b, err := ioutil.ReadFile(dirCacheMetadataPath(filePath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	meta := &dirCacheMetadata{}
+	if err := json.Unmarshal(b, meta); err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidDirCacheMetadata, err)
+	}
+
+	return meta, nil
+}
+
+// writeDirCacheMetadata writes the meta into the sidecar file of the cache
+// file targeted by the filePath.
+func writeDirCacheMetadata(filePath string, meta *dirCacheMetadata) error {
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	return writeCacheFile(
+		dirCacheMetadataPath(filePath),
+		bytes.NewReader(b),
+	)
+}
+
+// linkableFile is the content of a cache that is safe to be hard-linked by the
+// [linkCacheFile] since nothing writes to it afterwards, such as a file in the
+// [Goproxy.TempDir] or a blob of a [DedupDirCacher].
+type linkableFile struct {
+	*os.File
+}
+
+// linkCacheFile hard-links the content into the file if the content is a
+// [linkableFile] on the same file system, which avoids copying large zip
+// files. It reports whether the content has been linked.
+func linkCacheFile(file string, content io.ReadSeeker) (bool, error) {
+	lf, ok := content.(linkableFile)
+	if !ok {
+		return false, nil
 	}
 
-	f, err := ioutil.TempFile(dir, fmt.Sprintf(
+	src := lf.File
+
+	srcInfo, err := src.Stat()
+	if err != nil || !srcInfo.Mode().IsRegular() {
+		return false, nil
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(file), fmt.Sprintf(
+		".%s.link*",
+		filepath.Base(file),
+	))
+	if err != nil {
+		return false, err
+	}
+
+	f.Close()
+	if err := os.Remove(f.Name()); err != nil {
+		return false, err
+	}
+
+	if err := os.Link(src.Name(), f.Name()); err != nil {
+		return false, nil // Most likely on different file systems
+	}
+	defer os.Remove(f.Name())
+
+	// Make sure that the name of the src still targets the src.
+	if fi, err := os.Stat(f.Name()); err != nil ||
+		!os.SameFile(fi, srcInfo) {
+		return false, nil
+	}
+
+	if err := os.Rename(f.Name(), file); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// writeCacheFile writes the content into the file by copying it into a
+// temporary file first and renaming that to the file.
+func writeCacheFile(file string, content io.Reader) error {
+	f, err := ioutil.TempFile(filepath.Dir(file), fmt.Sprintf(
 		".%s.tmp*",
 		filepath.Base(file),
 	))
@@ -99,6 +434,7 @@ func (dc DirCacher) Put(
 	defer os.Remove(f.Name())
 
 	if _, err := io.Copy(f, content); err != nil {
+		f.Close()
 		return err
 	}
 
@@ -106,66 +442,544 @@ func (dc DirCacher) Put(
 		return err
 	}
 
-	if err := os.Rename(f.Name(), file); err != nil {
+	return os.Rename(f.Name(), file)
+}
+
+// Delete implements the [Deleter].
+func (dc DirCacher) Delete(ctx context.Context, name string) error {
+	filePath := filepath.Join(string(dc), filepath.FromSlash(name))
+	if err := os.Remove(filePath); err != nil {
 		return err
 	}
 
-	// Set the expiration time
-	if err := setCacheExpiration(file, expiration); err != nil {
+	err := os.Remove(dirCacheMetadataPath(filePath))
+	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
 
 	return nil
 }
 
+// DeletePrefix implements the [PrefixDeleter]. Only the subdirectory that
+// contains all caches whose names have the prefix is walked.
+func (dc DirCacher) DeletePrefix(
+	ctx context.Context,
+	prefix string,
+) ([]string, error) {
+	var names []string
+	if err := dc.walkCachesIn(cachePrefixDir(prefix), func(name string, size int64) error {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+
+		return ctx.Err()
+	}); err != nil {
+		return nil, err
+	}
+
+	return deleteCaches(ctx, dc, names)
+}
+
+// List implements the [Lister]. Only the subdirectory that contains all caches
+// whose names have the prefix is walked.
+func (dc DirCacher) List(ctx context.Context, prefix string) CacheIterator {
+	return listCaches(
+		ctx,
+		func(fn func(name string, size int64) error) error {
+			return dc.walkCachesIn(cachePrefixDir(prefix), fn)
+		},
+		prefix,
+	)
+}
+
+// cachePrefixDir returns the deepest slash-separated directory that contains
+// all caches whose names have the prefix.
+func cachePrefixDir(prefix string) string {
+	// The "_" makes the path.Dir keep a trailing directory of the
+	// prefix (e.g. "a/b/") while dropping a partial one (e.g. "a/b").
+	return path.Dir(prefix + "_")
+}
+
 // Cleanup implements the [Cacher].
 func (dc DirCacher) Cleanup() error {
-	files, err := ioutil.ReadDir(string(dc))
+	return dc.CleanupReclaimed(nil)
+}
+
+// CleanupReclaimed implements the [Reclaimer].
+func (dc DirCacher) CleanupReclaimed(
+	reclaimed func(name string, size int64),
+) error {
+	return dc.CleanupWithOptions(DirCacherCleanupOptions{}, reclaimed)
+}
+
+// DirCacherCleanupOptions are the options of the cleanups of the [DirCacher]
+// and the [ShardedDirCacher].
+type DirCacherCleanupOptions struct {
+	// Workers is the maximum number of directories scanned concurrently.
+	//
+	// If the Workers is zero, 4 is used.
+	Workers int
+
+	// MaxOpsPerSecond is the maximum number of file system operations
+	// (directory reads and removals) per second, to keep the cleanups of
+	// large caches from starving the disk.
+	//
+	// If the MaxOpsPerSecond is zero, there is no limit.
+	MaxOpsPerSecond int
+
+	// ClockSkew is how far the clocks of the machines that put caches may
+	// run behind the local one (see the [Goproxy.CacherClockSkew]). Caches
+	// are only removed once their expiration times have passed by more
+	// than it.
+	ClockSkew time.Duration
+}
+
+// CleanupWithOptions is like the [DirCacher.CleanupReclaimed], but with the
+// opts.
+//
+// The whole tree of the dc is walked: expired caches are removed wherever they
+// are, and so are the directories left empty afterwards (except the dc
+// itself). The reclaimed, if not nil, is never called concurrently.
+func (dc DirCacher) CleanupWithOptions(
+	opts DirCacherCleanupOptions,
+	reclaimed func(name string, size int64),
+) error {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = 4
+	}
+
+	c := &dirCleanup{
+		dc:        dc,
+		reclaimed: reclaimed,
+		clockSkew: opts.ClockSkew,
+		queue:     []*dirCleanupDir{{}},
+	}
+	c.queueCond = sync.NewCond(&c.queueMutex)
+
+	if opts.MaxOpsPerSecond > 0 {
+		interval := time.Second / time.Duration(opts.MaxOpsPerSecond)
+		if interval > 0 {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			c.ticks = ticker.C
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.work()
+		}()
+	}
+
+	wg.Wait()
+
+	return c.err
+}
+
+// dirCacherStaleTempFileAge is the age beyond which the temporary files left
+// in a [DirCacher] (e.g. by crashes) are removed by its cleanups.
+const dirCacherStaleTempFileAge = 24 * time.Hour
+
+// dirCleanup is a cleanup of a [DirCacher]. Its subdirectories are queued as
+// they are found and drained by a fixed number of workers.
+type dirCleanup struct {
+	dc        DirCacher
+	reclaimed func(name string, size int64)
+	ticks     <-chan time.Time
+	clockSkew time.Duration
+
+	queueMutex sync.Mutex
+	queueCond  *sync.Cond
+	queue      []*dirCleanupDir
+	done       bool
+
+	mutex sync.Mutex
+	err   error
+}
+
+// dirCleanupDir is a subdirectory of a [dirCleanup].
+type dirCleanupDir struct {
+	// dir is the slash-separated path of the subdirectory relative to the
+	// [DirCacher].
+	dir string
+
+	// parent is the parent subdirectory, or nil for the [DirCacher]
+	// itself.
+	parent *dirCleanupDir
+
+	// pending is the number of subdirectories not yet cleaned up.
+	pending int
+
+	// remaining is the number of files and subdirectories left behind.
+	remaining int
+}
+
+// work cleans up the subdirectories queued in the c until the whole tree has
+// been cleaned up.
+func (c *dirCleanup) work() {
+	for {
+		c.queueMutex.Lock()
+		for len(c.queue) == 0 && !c.done {
+			c.queueCond.Wait()
+		}
+
+		if c.done {
+			c.queueMutex.Unlock()
+			return
+		}
+
+		// Depth first, to keep the queue short.
+		d := c.queue[len(c.queue)-1]
+		c.queue = c.queue[:len(c.queue)-1]
+		c.queueMutex.Unlock()
+
+		c.cleanup(d)
+	}
+}
+
+// cleanup removes all expired cache files directly in the d and queues its
+// subdirectories.
+func (c *dirCleanup) cleanup(d *dirCleanupDir) {
+	subdirs, remaining, ok := c.cleanupFiles(d.dir, c.dirPath(d))
+	if !ok {
+		c.finish(d, false)
+		return
+	}
+
+	c.queueMutex.Lock()
+	d.remaining, d.pending = remaining, len(subdirs)
+	for _, subdir := range subdirs {
+		c.queue = append(c.queue, &dirCleanupDir{
+			dir:    subdir,
+			parent: d,
+		})
+	}
+
+	c.queueCond.Broadcast()
+	c.queueMutex.Unlock()
+
+	if len(subdirs) == 0 {
+		c.finish(d, true)
+	}
+}
+
+// finish is called once the d and all its subdirectories have been cleaned up.
+// It removes the d if it has been left empty, and then finishes its parent if
+// the d was the last pending subdirectory of it. The ok reports whether the
+// cleanup of the d went on.
+func (c *dirCleanup) finish(d *dirCleanupDir, ok bool) {
+	for {
+		// Fails if caches have been put into the d meanwhile.
+		removed := ok && d.dir != "" && d.remaining == 0 && c.wait() &&
+			os.Remove(c.dirPath(d)) == nil
+
+		c.queueMutex.Lock()
+		parent := d.parent
+		if parent == nil {
+			c.done = true
+			c.queueCond.Broadcast()
+			c.queueMutex.Unlock()
+			return
+		}
+
+		if !removed {
+			parent.remaining++
+		}
+
+		parent.pending--
+		finished := parent.pending == 0
+		c.queueMutex.Unlock()
+		if !finished {
+			return
+		}
+
+		d, ok = parent, true
+	}
+}
+
+// dirPath returns the path of the d.
+func (c *dirCleanup) dirPath(d *dirCleanupDir) string {
+	return filepath.Join(string(c.dc), filepath.FromSlash(d.dir))
+}
+
+// cleanupFiles removes all expired cache files directly in the subdirectory
+// targeted by the dir and the dirPath. It returns the subdirectories to clean
+// up and the number of remaining files, and reports whether the cleanup should
+// go on.
+func (c *dirCleanup) cleanupFiles(
+	dir string,
+	dirPath string,
+) ([]string, int, bool) {
+	if !c.wait() {
+		return nil, 0, false
+	}
+
+	files, err := ioutil.ReadDir(dirPath)
 	if err != nil {
-		return err
+		if dir != "" && os.IsNotExist(err) {
+			return nil, 0, false // Removed meanwhile
+		}
+
+		c.fail(err)
+		return nil, 0, false
 	}
 
+	fileNames := make(map[string]bool, len(files))
 	for _, file := range files {
-		filePath := filepath.Join(string(dc), file.Name())
-		expired, err := isCacheExpired(filePath)
-		if err != nil {
-			return err
+		fileNames[file.Name()] = true
+	}
+
+	var (
+		subdirs   []string
+		remaining int
+	)
+
+	now := time.Now()
+	for _, file := range files {
+		name := path.Join(dir, file.Name())
+		if dir == "" && file.Name() == dirCacherLayoutFileName {
+			remaining++
+			continue
+		} else if strings.HasPrefix(file.Name(), ".") {
+			// Sidecar file of a cache file, removed along with it.
+			if strings.HasSuffix(
+				file.Name(),
+				dirCacheMetadataSuffix,
+			) && fileNames[strings.TrimSuffix(
+				file.Name()[1:],
+				dirCacheMetadataSuffix,
+			)] {
+				continue
+			}
+
+			// Temporary file or directory, or orphaned sidecar
+			// file.
+			if file.Mode().IsRegular() && now.Sub(
+				file.ModTime(),
+			) > dirCacherStaleTempFileAge && c.remove(name) {
+				continue
+			}
+
+			remaining++
+			continue
+		} else if file.IsDir() {
+			subdirs = append(subdirs, name)
+			continue
+		} else if !file.Mode().IsRegular() {
+			remaining++
+			continue
 		}
-		if expired {
-			if file.IsDir() {
-				// If the file is a directory, call the Cleanup() method recursively to clean the directory.
-				subDir := filepath.Join(string(dc), file.Name())
-				subDirCacher := DirCacher(subDir)
-				if err := subDirCacher.Cleanup(); err != nil {
-					return err
-				}
-			} else {
-				// If the file is an ordinary file, delete it directly
-				if err := os.Remove(filePath); err != nil {
-					return err
+
+		sidecarName := path.Join(
+			dir,
+			"."+file.Name()+dirCacheMetadataSuffix,
+		)
+		hasSidecar := fileNames[path.Base(sidecarName)]
+
+		expires := file.ModTime()
+		if hasSidecar {
+			var ok bool
+			if expires, ok = c.expires(name); !ok {
+				if c.failed() {
+					return nil, 0, false
 				}
+
+				remaining++
+				continue
 			}
 		}
+
+		if now.Add(-c.clockSkew).Before(expires) {
+			remaining++
+			continue
+		}
+
+		if !c.remove(name) {
+			if c.failed() {
+				return nil, 0, false
+			}
+
+			remaining++
+			continue
+		}
+
+		if c.reclaimed != nil {
+			c.mutex.Lock()
+			c.reclaimed(name, file.Size())
+			c.mutex.Unlock()
+		}
+
+		if hasSidecar && !c.remove(sidecarName) {
+			if c.failed() {
+				return nil, 0, false
+			}
+
+			remaining++
+		}
 	}
 
-	return nil
+	return subdirs, remaining, true
 }
 
-// isCacheExpired checks if the cache file at the specified path has expired.
-func isCacheExpired(filePath string) (bool, error) {
-	info, err := os.Stat(filePath)
+// expires returns the expiration time kept in the sidecar file of the cache
+// file targeted by the name, and reports whether it is known. Caches whose
+// sidecar files are invalid are treated as expired, since they cannot be got
+// anyway.
+func (c *dirCleanup) expires(name string) (time.Time, bool) {
+	if !c.wait() {
+		return time.Time{}, false
+	}
+
+	meta, err := readDirCacheMetadata(filepath.Join(
+		string(c.dc),
+		filepath.FromSlash(name),
+	))
 	if err != nil {
-		return false, err
+		if errors.Is(err, errInvalidDirCacheMetadata) {
+			return time.Time{}, true
+		}
+
+		c.fail(err)
+		return time.Time{}, false
+	} else if meta == nil {
+		return time.Time{}, false // Removed meanwhile
+	}
+
+	return meta.Expires, true
+}
+
+// remove removes the file targeted by the name and reports whether it has
+// been removed by the c.
+func (c *dirCleanup) remove(name string) bool {
+	if !c.wait() {
+		return false
+	}
+
+	err := os.Remove(filepath.Join(string(c.dc), filepath.FromSlash(name)))
+	if err != nil && !os.IsNotExist(err) {
+		c.fail(err)
+	}
+
+	return err == nil
+}
+
+// wait waits for the next file system operation to be allowed by the c, and
+// reports whether the c should go on.
+func (c *dirCleanup) wait() bool {
+	if c.ticks != nil {
+		<-c.ticks
+	}
+
+	return !c.failed()
+}
+
+// fail records the err as the error of the c, unless one has already been
+// recorded.
+func (c *dirCleanup) fail(err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.err == nil {
+		c.err = err
 	}
+}
+
+// failed reports whether the c has failed.
+func (c *dirCleanup) failed() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.err != nil
+}
+
+// cacheWalker is the interface that a [Cacher] can implement to enumerate its
+// caches.
+type cacheWalker interface {
+	// walkCaches calls the fn with the name and size of each cache. It
+	// stops at the first error returned by the fn and returns it.
+	walkCaches(fn func(name string, size int64) error) error
+}
 
-	expirationTime := info.ModTime()
-	return time.Now().After(expirationTime), nil
+// walkCaches implements the [cacheWalker].
+func (dc DirCacher) walkCaches(fn func(name string, size int64) error) error {
+	return dc.walkCachesIn("", fn)
 }
 
-// setCacheExpiration sets the expiration time for the cache file at the specified path.
-func setCacheExpiration(filePath string, expiration time.Duration) error {
-	expirationTime := time.Now().Add(expiration)
-	return os.Chtimes(filePath, time.Now(), expirationTime)
+// walkCachesIn is like the walkCaches, but only walks the subdirectory
+// targeted by the dir (a slash-separated path relative to the dc).
+func (dc DirCacher) walkCachesIn(
+	dir string,
+	fn func(name string, size int64) error,
+) error {
+	root := filepath.Join(string(dc), filepath.FromSlash(dir))
+	return filepath.Walk(root, func(
+		filePath string,
+		fi os.FileInfo,
+		err error,
+	) error {
+		if err != nil {
+			if os.IsNotExist(err) && filePath == root {
+				return nil
+			}
+
+			return err
+		} else if fi.IsDir() && filePath != root &&
+			strings.HasPrefix(fi.Name(), ".") {
+			return filepath.SkipDir // Temporary directory
+		} else if !fi.Mode().IsRegular() ||
+			strings.HasPrefix(fi.Name(), ".") {
+			return nil // Layout file or temporary file
+		}
+
+		rel, err := filepath.Rel(string(dc), filePath)
+		if err != nil {
+			return err
+		}
+
+		return fn(filepath.ToSlash(rel), fi.Size())
+	})
+}
+
+// cacheNamesWithPrefix returns the names of the caches enumerated by the cw
+// that have the prefix.
+func cacheNamesWithPrefix(cw cacheWalker, prefix string) ([]string, error) {
+	var names []string
+	if err := cw.walkCaches(func(name string, size int64) error {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return names, nil
+}
+
+// deleteCaches deletes the caches for the names via the d and returns the names
+// of the deleted ones. The caches that no longer exist are skipped.
+func deleteCaches(
+	ctx context.Context,
+	d Deleter,
+	names []string,
+) ([]string, error) {
+	var deleted []string
+	for _, name := range names {
+		if err := d.Delete(ctx, name); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+
+			return deleted, err
+		}
+
+		deleted = append(deleted, name)
+	}
+
+	return deleted, nil
 }
 
 // StartCleanupTask starts a periodic cleanup task for the cache directory.
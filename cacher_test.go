@@ -1,13 +1,18 @@
 package goproxy
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 )
 
 type errorReadSeeker struct{}
@@ -20,6 +25,25 @@ func (errorReadSeeker) Seek(int64, int) (int64, error) {
 	return 0, errors.New("cannot seek")
 }
 
+// setCacheExpiration sets the expiration of the cache file targeted by the
+// filePath of a [DirCacher].
+func setCacheExpiration(filePath string, expiration time.Duration) error {
+	meta, err := readDirCacheMetadata(filePath)
+	if err != nil {
+		return err
+	} else if meta == nil {
+		fi, err := os.Stat(filePath)
+		if err != nil {
+			return err
+		}
+
+		meta = &dirCacheMetadata{ModTime: fi.ModTime()}
+	}
+
+	meta.Expires = time.Now().Add(expiration)
+	return writeDirCacheMetadata(filePath, meta)
+}
+
 func TestDirCacher(t *testing.T) {
 	tempDir, err := ioutil.TempDir("", "goproxy.TestDirCacher")
 	if err != nil {
@@ -42,6 +66,7 @@ func TestDirCacher(t *testing.T) {
 		context.Background(),
 		"a/b/c",
 		strings.NewReader("foobar"),
+		time.Hour,
 	); err != nil {
 		t.Fatalf("unexpected error %q", err)
 	}
@@ -67,6 +92,7 @@ func TestDirCacher(t *testing.T) {
 		context.Background(),
 		"d/e/f",
 		&errorReadSeeker{},
+		time.Hour,
 	); err == nil {
 		t.Fatal("expected error")
 	} else if got, want := err.Error(), "cannot read"; got != want {
@@ -81,6 +107,615 @@ func TestDirCacher(t *testing.T) {
 		context.Background(),
 		"d/e/f",
 		strings.NewReader("foobar"),
+		time.Hour,
+	); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestDirCacherMetadata(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestDirCacherMetadata")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	dirCacher := DirCacher(tempDir)
+	startTime := time.Now()
+	if err := dirCacher.Put(
+		context.Background(),
+		"a/b/c",
+		strings.NewReader("foobar"),
+		time.Hour,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	filePath := filepath.Join(tempDir, filepath.FromSlash("a/b/c"))
+	if fi, err := os.Stat(filePath); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if fi.ModTime().After(time.Now()) {
+		t.Errorf("got %s, want not after now", fi.ModTime())
+	}
+
+	rc, err := dirCacher.Get(context.Background(), "a/b/c")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	rc.Close()
+
+	const checksum = "c3ab8ff13720e8ad9047dd39466b3c89" +
+		"74e592c2fa383d4a3960714caef0c4f2"
+	dcc := rc.(*dirCacheContent)
+	if got, want := dcc.ETag(), `"`+checksum+`"`; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	} else if got, want := dcc.meta.Checksum, checksum; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	} else if got := dcc.ModTime(); got.Before(startTime.Add(
+		-time.Second,
+	)) || got.After(time.Now()) {
+		t.Errorf("got %s, want about %s", got, startTime)
+	} else if got := time.Until(dcc.Expires()); got > time.Hour ||
+		got < 59*time.Minute {
+		t.Errorf("got %s, want about %s", got, time.Hour)
+	}
+
+	// Caches without sidecar files keep their expirations as the
+	// modification times of their files.
+	legacy := filepath.Join(tempDir, "legacy")
+	if err := ioutil.WriteFile(legacy, []byte("foobar"), 0600); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if err := os.Chtimes(
+		legacy,
+		time.Now(),
+		time.Now().Add(time.Hour),
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if rc, err := dirCacher.Get(
+		context.Background(),
+		"legacy",
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got := rc.(*dirCacheContent).ETag(); got != "" {
+		t.Errorf("got %q, want empty", got)
+	} else {
+		rc.Close()
+	}
+
+	if err := setCacheExpiration(filePath, -time.Hour); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if _, err := dirCacher.Get(
+		context.Background(),
+		"a/b/c",
+	); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got error %q, want error %q", err, os.ErrNotExist)
+	}
+
+	if err := dirCacher.Cleanup(); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if _, err := os.Stat(
+		dirCacheMetadataPath(filePath),
+	); !os.IsNotExist(err) {
+		t.Fatalf("got error %q, want error %q", err, os.ErrNotExist)
+	}
+
+	// Caches with invalid sidecar files cannot be got, and are removed
+	// by cleanups.
+	if err := dirCacher.Put(
+		context.Background(),
+		"d",
+		strings.NewReader("foobar"),
+		time.Hour,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if err := ioutil.WriteFile(
+		dirCacheMetadataPath(filepath.Join(tempDir, "d")),
+		[]byte("{"),
+		0600,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if _, err := dirCacher.Get(
+		context.Background(),
+		"d",
+	); !errors.Is(err, errInvalidDirCacheMetadata) {
+		t.Fatalf(
+			"got error %q, want error %q",
+			err,
+			errInvalidDirCacheMetadata,
+		)
+	}
+
+	if err := dirCacher.Cleanup(); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	names, err := ioutil.ReadDir(tempDir)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := len(names), 1; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	if err := dirCacher.Delete(
+		context.Background(),
+		"legacy",
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+}
+
+func TestDirCacherPutFile(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestDirCacherPutFile")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	dirCacher := DirCacher(tempDir)
+	fetchDir := filepath.Join(tempDir, ".tmp")
+	if err := os.Mkdir(fetchDir, 0750); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	file := filepath.Join(fetchDir, "v1.0.0.zip")
+	if err := ioutil.WriteFile(file, []byte("foobar"), 0600); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer f.Close()
+
+	fi, err := os.Stat(file)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	// Files not put from the temporary directory of a Goproxy are copied.
+	if err := dirCacher.Put(
+		context.Background(),
+		"b/@v/v1.0.0.zip",
+		f,
+		time.Hour,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	cachedFI, err := os.Stat(filepath.Join(
+		tempDir,
+		filepath.FromSlash("b/@v/v1.0.0.zip"),
+	))
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if os.SameFile(fi, cachedFI) {
+		t.Error("unexpected hard-linked cache file")
+	}
+
+	if err := dirCacher.Delete(
+		context.Background(),
+		"b/@v/v1.0.0.zip",
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if err := dirCacher.Put(
+		context.Background(),
+		"a/@v/v1.0.0.zip",
+		linkableFile{f},
+		time.Hour,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	cachedFI, err = os.Stat(filepath.Join(
+		tempDir,
+		filepath.FromSlash("a/@v/v1.0.0.zip"),
+	))
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if !os.SameFile(fi, cachedFI) {
+		t.Error("expected cache file to be hard-linked")
+	}
+
+	var names []string
+	if err := dirCacher.walkCaches(func(name string, size int64) error {
+		names = append(names, name)
+		return nil
+	}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := strings.Join(names, ","),
+		"a/@v/v1.0.0.zip"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if err := os.Chtimes(
+		fetchDir,
+		time.Now(),
+		time.Now().Add(-time.Hour),
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if err := dirCacher.Cleanup(); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if _, err := os.Stat(file); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+}
+
+func TestDirCacherDelete(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestDirCacherDelete")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	dirCacher := DirCacher(tempDir)
+	if err := dirCacher.Put(
+		context.Background(),
+		"a/b/c",
+		strings.NewReader("foobar"),
+		time.Hour,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if err := dirCacher.Delete(context.Background(), "a/b/c"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if _, err := dirCacher.Get(
+		context.Background(),
+		"a/b/c",
+	); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got error %q, want error %q", err, os.ErrNotExist)
+	}
+
+	if err := dirCacher.Delete(
+		context.Background(),
+		"a/b/c",
+	); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got error %q, want error %q", err, os.ErrNotExist)
+	}
+}
+
+func TestDirCacherDeletePrefix(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestDirCacherDeletePrefix")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	dirCacher := DirCacher(tempDir)
+	for _, name := range []string{"a/b/c", "a/b/d", "a/bc", "e"} {
+		if err := dirCacher.Put(
+			context.Background(),
+			name,
+			strings.NewReader("foobar"),
+			time.Hour,
+		); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	for _, tt := range []struct {
+		n      int
+		prefix string
+		want   string
+	}{
+		{1, "a/b/", "a/b/c a/b/d"},
+		{2, "a/b/", ""},
+		{3, "a/b", "a/bc"},
+		{4, "x/", ""},
+		{5, "", "e"},
+	} {
+		names, err := dirCacher.DeletePrefix(
+			context.Background(),
+			tt.prefix,
+		)
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		}
+
+		sort.Strings(names)
+		if got := strings.Join(names, " "); got != tt.want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestDirCacherCleanupReclaimed(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestDirCacherCleanupReclaimed")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	dirCacher := DirCacher(tempDir)
+	for _, cache := range []struct {
+		name       string
+		expiration time.Duration
+	}{
+		{"a/b/c", -time.Hour},
+		{"a/b/d", time.Hour},
+		{"e", -time.Hour},
+	} {
+		if err := dirCacher.Put(
+			context.Background(),
+			cache.name,
+			strings.NewReader("foobar"),
+			cache.expiration,
+		); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	var reclaimed []string
+	if err := dirCacher.CleanupReclaimed(func(name string, size int64) {
+		if got, want := size, int64(len("foobar")); got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+
+		reclaimed = append(reclaimed, name)
+	}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	sort.Strings(reclaimed)
+	if got, want := strings.Join(reclaimed, " "), "a/b/c e"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if _, err := dirCacher.Get(context.Background(), "a/b/d"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if err := dirCacher.Cleanup(); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+}
+
+func TestDirCacherCleanupWithOptions(t *testing.T) {
+	tempDir, err := ioutil.TempDir(
+		"",
+		"goproxy.TestDirCacherCleanupWithOptions",
+	)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	dirCacher := DirCacher(tempDir)
+	for _, cache := range []struct {
+		name       string
+		expiration time.Duration
+	}{
+		{"example.com/a/b/c/@v/v1.0.0.info", -time.Hour},
+		{"example.com/a/b/c/@v/v1.0.0.mod", -time.Hour},
+		{"example.com/a/b/d/@v/v1.0.0.info", -time.Hour},
+		{"example.com/a/b/d/@v/v1.0.0.mod", time.Hour},
+		{"e", -time.Hour},
+	} {
+		if err := dirCacher.Put(
+			context.Background(),
+			cache.name,
+			strings.NewReader("foobar"),
+			cache.expiration,
+		); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	staleTempFile := filepath.Join(tempDir, "example.com", ".stale.tmp")
+	freshTempFile := filepath.Join(tempDir, "example.com", ".fresh.tmp")
+	for _, file := range []string{staleTempFile, freshTempFile} {
+		if err := ioutil.WriteFile(file, nil, 0600); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	if err := os.Chtimes(
+		staleTempFile,
+		time.Now(),
+		time.Now().Add(-2*dirCacherStaleTempFileAge),
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	var reclaimed []string
+	if err := dirCacher.CleanupWithOptions(
+		DirCacherCleanupOptions{Workers: 2, MaxOpsPerSecond: 1000},
+		func(name string, size int64) {
+			reclaimed = append(reclaimed, name)
+		},
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	sort.Strings(reclaimed)
+	if got, want := strings.Join(reclaimed, " "), strings.Join([]string{
+		"e",
+		"example.com/a/b/c/@v/v1.0.0.info",
+		"example.com/a/b/c/@v/v1.0.0.mod",
+		"example.com/a/b/d/@v/v1.0.0.info",
+	}, " "); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	for file, want := range map[string]bool{
+		"example.com/a/b/c":               false,
+		"example.com/a/b/d/@v/v1.0.0.mod": true,
+		"example.com/.stale.tmp":          false,
+		"example.com/.fresh.tmp":          true,
+	} {
+		_, err := os.Stat(filepath.Join(
+			tempDir,
+			filepath.FromSlash(file),
+		))
+		if got := err == nil; got != want {
+			t.Errorf("%s: got %t, want %t", file, got, want)
+		}
+	}
+
+	if err := os.Remove(freshTempFile); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if err := dirCacher.Delete(
+		context.Background(),
+		"example.com/a/b/d/@v/v1.0.0.mod",
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	// Directories left empty are removed, but never the root.
+	if err := dirCacher.Cleanup(); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if _, err := os.Stat(filepath.Join(
+		tempDir,
+		"example.com",
+	)); !os.IsNotExist(err) {
+		t.Fatalf("got error %q, want error %q", err, os.ErrNotExist)
+	} else if _, err := os.Stat(tempDir); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	// Caches can still be put after their directories have been removed.
+	if err := dirCacher.Put(
+		context.Background(),
+		"example.com/a/b/c/@v/v1.0.0.info",
+		strings.NewReader("foobar"),
+		time.Hour,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	// A single worker drains the whole tree.
+	if err := dirCacher.Put(
+		context.Background(),
+		"example.com/x/y/z/@v/v1.0.0.mod",
+		strings.NewReader("foobar"),
+		-time.Hour,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if err := dirCacher.CleanupWithOptions(
+		DirCacherCleanupOptions{Workers: 1},
+		nil,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(
+		tempDir,
+		"example.com",
+		"x",
+	)); !os.IsNotExist(err) {
+		t.Errorf("got error %q, want error %q", err, os.ErrNotExist)
+	}
+
+	dirCacher = DirCacher(filepath.Join(tempDir, "nonexistent"))
+	if err := dirCacher.Cleanup(); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+// streamPutterCacher is a [Cacher] that implements the [StreamPutter] and
+// records the names of the caches put via it.
+type streamPutterCacher struct {
+	Cacher
+
+	streamed []string
+}
+
+// PutStream implements the [StreamPutter].
+func (spc *streamPutterCacher) PutStream(
+	ctx context.Context,
+	name string,
+	content io.Reader,
+	expiration time.Duration,
+) error {
+	b, err := ioutil.ReadAll(content)
+	if err != nil {
+		return err
+	}
+
+	spc.streamed = append(spc.streamed, name)
+
+	return spc.Cacher.Put(ctx, name, bytes.NewReader(b), expiration)
+}
+
+func TestPutStream(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestPutStream")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	spc := &streamPutterCacher{Cacher: DirCacher(tempDir)}
+	for n, tt := range []struct {
+		cacher       Cacher
+		content      io.Reader
+		wantStreamed bool
+	}{
+		{spc, ioutil.NopCloser(strings.NewReader("foobar")), true},
+		{spc, strings.NewReader("foobar"), false},
+		{
+			DirCacher(tempDir),
+			ioutil.NopCloser(strings.NewReader("foobar")),
+			false,
+		},
+	} {
+		spc.streamed = nil
+		name := fmt.Sprint("example.com/@v/v1.", n, ".0.info")
+		if err := putStream(
+			context.Background(),
+			tt.cacher,
+			name,
+			tt.content,
+			time.Hour,
+			tempDir,
+		); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", n, err)
+		}
+
+		if got, want := len(spc.streamed) != 0,
+			tt.wantStreamed; got != want {
+			t.Errorf("test(%d): got %t, want %t", n, got, want)
+		}
+
+		content, err := tt.cacher.Get(context.Background(), name)
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", n, err)
+		}
+
+		b, err := ioutil.ReadAll(content)
+		content.Close()
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", n, err)
+		} else if got, want := string(b), "foobar"; got != want {
+			t.Errorf("test(%d): got %q, want %q", n, got, want)
+		}
+	}
+
+	if err := putStream(
+		context.Background(),
+		DirCacher(tempDir),
+		"example.com/@v/v1.3.0.info",
+		ioutil.NopCloser(errorReadSeeker{}),
+		time.Hour,
+		tempDir,
 	); err == nil {
 		t.Fatal("expected error")
 	}
@@ -0,0 +1,1147 @@
+package goproxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultCassandraMaxIdleConns is the default maximum number of idle
+// connections kept by a [CassandraCacher].
+const defaultCassandraMaxIdleConns = 8
+
+// defaultCassandraChunkBytes is the default maximum number of bytes of each
+// chunk of the caches stored by a [CassandraCacher].
+const defaultCassandraChunkBytes = 1 << 20
+
+// maxCassandraTTL is the maximum TTL, in seconds, allowed by Cassandra. Caches
+// expiring later than it are stored without a TTL.
+const maxCassandraTTL = 20 * 365 * 24 * 60 * 60
+
+// cassandraPageSize is the number of rows fetched per page when walking the
+// caches of a [CassandraCacher].
+const cassandraPageSize = 1000
+
+// The opcodes of the CQL native protocol (v4).
+const (
+	cqlOpError        = 0x00
+	cqlOpStartup      = 0x01
+	cqlOpReady        = 0x02
+	cqlOpAuthenticate = 0x03
+	cqlOpQuery        = 0x07
+	cqlOpResult       = 0x08
+	cqlOpAuthResponse = 0x0f
+	cqlOpAuthSuccess  = 0x10
+)
+
+// cassandraConsistencies are the consistency levels of the CQL native protocol.
+var cassandraConsistencies = map[string]uint16{
+	"ANY":          0x0000,
+	"ONE":          0x0001,
+	"TWO":          0x0002,
+	"THREE":        0x0003,
+	"QUORUM":       0x0004,
+	"ALL":          0x0005,
+	"LOCAL_QUORUM": 0x0006,
+	"EACH_QUORUM":  0x0007,
+	"LOCAL_ONE":    0x000a,
+}
+
+// CassandraCacher implements the [Cacher] using a Cassandra (or a compatible
+// database such as ScyllaDB) cluster, which suits the fleets of Goproxy
+// instances that share multi-terabyte caches without a shared file system. It
+// speaks the CQL native protocol (v4) with one node of the cluster, which
+// coordinates the queries.
+//
+// Each cache is split into chunks of at most the
+// [CassandraCacher.ChunkBytes], so that even large module zip files are
+// stored in bounded partitions and streamed chunk by chunk. The chunks of a
+// cache are written before its metadata row, which is what makes the new
+// content visible, so readers never see partially written caches. The chunks
+// of the replaced content are removed right after, so reads of them still in
+// progress fail. The expiration of each cache is mapped to the native TTL of
+// its rows, so expired caches are removed by the cluster itself.
+//
+// The tables must be created beforehand in the [CassandraCacher.Keyspace]:
+//
+//	CREATE TABLE caches (
+//		name text PRIMARY KEY,
+//		generation bigint,
+//		size bigint,
+//		chunk_size int,
+//		chunks int,
+//		modtime timestamp,
+//		checksum blob
+//	);
+//
+//	CREATE TABLE caches_chunks (
+//		name text,
+//		generation bigint,
+//		chunk int,
+//		data blob,
+//		PRIMARY KEY ((name, generation, chunk))
+//	);
+//
+// Make sure that all fields of the CassandraCacher have been finalized before
+// calling any of its methods.
+type CassandraCacher struct {
+	// Addr is the address of a node of the Cassandra cluster, such as
+	// "localhost:9042".
+	Addr string
+
+	// Username is the username used to authenticate with the Cassandra
+	// cluster (PasswordAuthenticator).
+	//
+	// If the Username is empty, no authentication is performed.
+	Username string
+
+	// Password is the password of the [CassandraCacher.Username].
+	Password string
+
+	// Keyspace is the keyspace of the tables.
+	Keyspace string
+
+	// Table is the name of the table of the metadata of the caches. The
+	// chunks of the caches are stored in the table with the same name
+	// suffixed with "_chunks".
+	//
+	// If the Table is empty, "caches" is used.
+	Table string
+
+	// Prefix is the prefix of the names of the caches in the tables, such
+	// as "tenant/".
+	Prefix string
+
+	// Consistency is the consistency level of the queries, such as
+	// "LOCAL_QUORUM" or "ONE".
+	//
+	// If the Consistency is empty, "LOCAL_QUORUM" is used.
+	Consistency string
+
+	// ChunkBytes is the maximum number of bytes of each chunk of the
+	// caches. It only applies to the caches put afterwards.
+	//
+	// If the ChunkBytes is zero, 1 MiB is used.
+	ChunkBytes int
+
+	// TLSConfig is the TLS configuration used to connect to the Cassandra
+	// cluster.
+	//
+	// If the TLSConfig is nil, connections are not encrypted.
+	TLSConfig *tls.Config
+
+	// DialTimeout is the maximum amount of time a dial waits for a
+	// connection to the Cassandra cluster to complete.
+	//
+	// If the DialTimeout is zero, there is no timeout.
+	DialTimeout time.Duration
+
+	// MaxIdleConns is the maximum number of idle connections kept for
+	// reuse.
+	//
+	// If the MaxIdleConns is zero, 8 is used.
+	MaxIdleConns int
+
+	idleConnsOnce sync.Once
+	idleConns     chan *cassandraConn
+	dialContext   func(context.Context, string, string) (net.Conn, error)
+}
+
+// Get implements the [Cacher].
+func (cc *CassandraCacher) Get(
+	ctx context.Context,
+	name string,
+) (io.ReadCloser, error) {
+	rows, err := cc.query(
+		ctx,
+		fmt.Sprint(
+			"SELECT generation, size, chunk_size, chunks, ",
+			"modtime, checksum, TTL(size) FROM ", cc.table(),
+			" WHERE name = ?",
+		),
+		cc.Prefix+name,
+	)
+	if err != nil {
+		return nil, err
+	} else if len(rows.rows) == 0 {
+		return nil, os.ErrNotExist
+	}
+
+	row := rows.rows[0]
+	if len(row) != 7 {
+		return nil, fmt.Errorf(
+			"cassandra: got %d columns, want 7",
+			len(row),
+		)
+	}
+
+	c := &cassandraCache{
+		ctx:        ctx,
+		cc:         cc,
+		name:       cc.Prefix + name,
+		generation: cqlBigint(row[0]),
+		size:       cqlBigint(row[1]),
+		chunkSize:  int64(cqlInt(row[2])),
+		chunks:     cqlInt(row[3]),
+		modTime:    cqlTimestamp(row[4]),
+		checksum:   row[5],
+		chunk:      -1,
+	}
+	if c.size > 0 && c.chunkSize <= 0 {
+		return nil, fmt.Errorf(
+			"cassandra: invalid chunk size %d of %s",
+			c.chunkSize,
+			c.name,
+		)
+	}
+
+	if row[6] != nil {
+		c.expires = time.Now().Add(
+			time.Duration(cqlInt(row[6])) * time.Second,
+		)
+	}
+
+	return c, nil
+}
+
+// Put implements the [Cacher].
+func (cc *CassandraCacher) Put(
+	ctx context.Context,
+	name string,
+	content io.ReadSeeker,
+	expiration time.Duration,
+) error {
+	return cc.PutStream(ctx, name, content, expiration)
+}
+
+// PutStream implements the [StreamPutter].
+func (cc *CassandraCacher) PutStream(
+	ctx context.Context,
+	name string,
+	content io.Reader,
+	expiration time.Duration,
+) error {
+	if expiration < time.Second {
+		// The cache has already expired.
+		err := cc.Delete(ctx, name)
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+
+		return err
+	}
+
+	var ttl int32
+	if seconds := expiration / time.Second; seconds <= maxCassandraTTL {
+		ttl = int32(seconds)
+	}
+
+	old, err := cc.query(
+		ctx,
+		fmt.Sprint(
+			"SELECT generation, chunks FROM ", cc.table(),
+			" WHERE name = ?",
+		),
+		cc.Prefix+name,
+	)
+	if err != nil {
+		return err
+	}
+
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+
+	generation := int64(binary.BigEndian.Uint64(b) & math.MaxInt64)
+
+	chunkSize := cc.ChunkBytes
+	if chunkSize <= 0 {
+		chunkSize = defaultCassandraChunkBytes
+	}
+
+	var (
+		buf    = make([]byte, chunkSize)
+		hash   = sha256.New()
+		size   int64
+		chunks int32
+	)
+	for {
+		n, err := io.ReadFull(content, buf)
+		if n > 0 {
+			if _, err := cc.query(
+				ctx,
+				fmt.Sprint(
+					"INSERT INTO ", cc.chunksTable(),
+					" (name, generation, chunk, data) ",
+					"VALUES (?, ?, ?, ?) USING TTL ?",
+				),
+				cc.Prefix+name,
+				generation,
+				chunks,
+				buf[:n],
+				ttl,
+			); err != nil {
+				return err
+			}
+
+			hash.Write(buf[:n])
+			size += int64(n)
+			chunks++
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if _, err := cc.query(
+		ctx,
+		fmt.Sprint(
+			"INSERT INTO ", cc.table(),
+			" (name, generation, size, chunk_size, chunks, ",
+			"modtime, checksum) VALUES (?, ?, ?, ?, ?, ?, ?) ",
+			"USING TTL ?",
+		),
+		cc.Prefix+name,
+		generation,
+		size,
+		int32(chunkSize),
+		chunks,
+		time.Now().UnixNano()/int64(time.Millisecond),
+		hash.Sum(nil),
+		ttl,
+	); err != nil {
+		return err
+	}
+
+	if len(old.rows) > 0 {
+		// Failures leave the chunks to their TTLs.
+		cc.deleteChunks(
+			ctx,
+			cc.Prefix+name,
+			cqlBigint(old.rows[0][0]),
+			cqlInt(old.rows[0][1]),
+		)
+	}
+
+	return nil
+}
+
+// Delete implements the [Deleter].
+func (cc *CassandraCacher) Delete(ctx context.Context, name string) error {
+	rows, err := cc.query(
+		ctx,
+		fmt.Sprint(
+			"SELECT generation, chunks FROM ", cc.table(),
+			" WHERE name = ?",
+		),
+		cc.Prefix+name,
+	)
+	if err != nil {
+		return err
+	} else if len(rows.rows) == 0 {
+		return os.ErrNotExist
+	}
+
+	if _, err := cc.query(
+		ctx,
+		fmt.Sprint("DELETE FROM ", cc.table(), " WHERE name = ?"),
+		cc.Prefix+name,
+	); err != nil {
+		return err
+	}
+
+	return cc.deleteChunks(
+		ctx,
+		cc.Prefix+name,
+		cqlBigint(rows.rows[0][0]),
+		cqlInt(rows.rows[0][1]),
+	)
+}
+
+// Cleanup implements the [Cacher]. The Cassandra cluster removes expired caches
+// by itself, so there is nothing to do.
+func (cc *CassandraCacher) Cleanup() error {
+	return nil
+}
+
+// List implements the [Lister].
+func (cc *CassandraCacher) List(
+	ctx context.Context,
+	prefix string,
+) CacheIterator {
+	return listCaches(ctx, cc.walkCaches, prefix)
+}
+
+// walkCaches implements the [cacheWalker].
+func (cc *CassandraCacher) walkCaches(
+	fn func(name string, size int64) error,
+) error {
+	ctx := context.Background()
+	var pagingState []byte
+	for {
+		rows, err := cc.queryPage(
+			ctx,
+			fmt.Sprint("SELECT name, size FROM ", cc.table()),
+			pagingState,
+		)
+		if err != nil {
+			return err
+		}
+
+		for _, row := range rows.rows {
+			name := string(row[0])
+			if !strings.HasPrefix(name, cc.Prefix) ||
+				row[1] == nil {
+				continue
+			}
+
+			if err := fn(
+				strings.TrimPrefix(name, cc.Prefix),
+				cqlBigint(row[1]),
+			); err != nil {
+				return err
+			}
+		}
+
+		if rows.pagingState == nil {
+			return nil
+		}
+
+		pagingState = rows.pagingState
+	}
+}
+
+// deleteChunks deletes the chunks of the generation of the cache for the name,
+// which is already prefixed with the [CassandraCacher.Prefix].
+func (cc *CassandraCacher) deleteChunks(
+	ctx context.Context,
+	name string,
+	generation int64,
+	chunks int32,
+) error {
+	for chunk := int32(0); chunk < chunks; chunk++ {
+		if _, err := cc.query(
+			ctx,
+			fmt.Sprint(
+				"DELETE FROM ", cc.chunksTable(),
+				" WHERE name = ? AND generation = ? ",
+				"AND chunk = ?",
+			),
+			name,
+			generation,
+			chunk,
+		); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// table returns the qualified name of the table of the metadata of the caches.
+func (cc *CassandraCacher) table() string {
+	table := cc.Table
+	if table == "" {
+		table = "caches"
+	}
+
+	if cc.Keyspace != "" {
+		table = cc.Keyspace + "." + table
+	}
+
+	return table
+}
+
+// chunksTable returns the qualified name of the table of the chunks of the
+// caches.
+func (cc *CassandraCacher) chunksTable() string {
+	return cc.table() + "_chunks"
+}
+
+// query runs the cql with the values, each of which is a string, a []byte, an
+// int32 or an int64, and returns its rows, if any.
+func (cc *CassandraCacher) query(
+	ctx context.Context,
+	cql string,
+	values ...interface{},
+) (*cqlRows, error) {
+	return cc.queryPage(ctx, cql, nil, values...)
+}
+
+// queryPage is like the query, but only returns the page of the rows starting
+// at the pagingState.
+func (cc *CassandraCacher) queryPage(
+	ctx context.Context,
+	cql string,
+	pagingState []byte,
+	values ...interface{},
+) (*cqlRows, error) {
+	consistencyName := cc.Consistency
+	if consistencyName == "" {
+		consistencyName = "LOCAL_QUORUM"
+	}
+
+	consistency, ok := cassandraConsistencies[consistencyName]
+	if !ok {
+		return nil, fmt.Errorf(
+			"unknown cassandra consistency %q",
+			consistencyName,
+		)
+	}
+
+	conn, err := cc.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn.query(ctx, consistency, cql, pagingState, values)
+	if err != nil {
+		var ce *cassandraError
+		if !errors.As(err, &ce) {
+			// The state of the conn is unknown.
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	cc.putConn(conn)
+
+	return rows, err
+}
+
+// conn returns an idle connection to the Cassandra cluster, or a new one if
+// there is none.
+func (cc *CassandraCacher) conn(ctx context.Context) (*cassandraConn, error) {
+	cc.idleConnsOnce.Do(cc.initIdleConns)
+	select {
+	case conn := <-cc.idleConns:
+		return conn, nil
+	default:
+	}
+
+	dialContext := cc.dialContext
+	if dialContext == nil {
+		dialer := &net.Dialer{Timeout: cc.DialTimeout}
+		dialContext = dialer.DialContext
+	}
+
+	netConn, err := dialContext(ctx, "tcp", cc.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if cc.TLSConfig != nil {
+		tlsConfig := cc.TLSConfig.Clone()
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName, _, _ = net.SplitHostPort(cc.Addr)
+		}
+
+		netConn = tls.Client(netConn, tlsConfig)
+	}
+
+	conn := &cassandraConn{
+		Conn: netConn,
+		r:    bufio.NewReader(netConn),
+		w:    bufio.NewWriter(netConn),
+	}
+	if err := conn.startup(ctx, cc.Username, cc.Password); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// initIdleConns initializes the cc.idleConns.
+func (cc *CassandraCacher) initIdleConns() {
+	maxIdleConns := cc.MaxIdleConns
+	if maxIdleConns <= 0 {
+		maxIdleConns = defaultCassandraMaxIdleConns
+	}
+
+	cc.idleConns = make(chan *cassandraConn, maxIdleConns)
+}
+
+// putConn puts the conn back to the idle connections, or closes it if there
+// are too many.
+func (cc *CassandraCacher) putConn(conn *cassandraConn) {
+	select {
+	case cc.idleConns <- conn:
+	default:
+		conn.Close()
+	}
+}
+
+// cassandraCache is a cache got from a [CassandraCacher], whose chunks are
+// fetched as it is read.
+type cassandraCache struct {
+	ctx        context.Context
+	cc         *CassandraCacher
+	name       string
+	generation int64
+	size       int64
+	chunkSize  int64
+	chunks     int32
+	modTime    time.Time
+	checksum   []byte
+	expires    time.Time
+
+	offset    int64
+	chunk     int64
+	chunkData []byte
+}
+
+// Read implements the [io.Reader].
+func (c *cassandraCache) Read(b []byte) (int, error) {
+	if c.offset >= c.size {
+		return 0, io.EOF
+	}
+
+	if chunk := c.offset / c.chunkSize; chunk != c.chunk {
+		rows, err := c.cc.query(
+			c.ctx,
+			fmt.Sprint(
+				"SELECT data FROM ", c.cc.chunksTable(),
+				" WHERE name = ? AND generation = ? ",
+				"AND chunk = ?",
+			),
+			c.name,
+			c.generation,
+			int32(chunk),
+		)
+		if err != nil {
+			return 0, err
+		} else if len(rows.rows) == 0 {
+			return 0, fmt.Errorf(
+				"cassandra: missing chunk %d of %s: %w",
+				chunk,
+				c.name,
+				io.ErrUnexpectedEOF,
+			)
+		}
+
+		c.chunk = chunk
+		c.chunkData = rows.rows[0][0]
+	}
+
+	start := c.offset - c.chunk*c.chunkSize
+	if start >= int64(len(c.chunkData)) {
+		return 0, fmt.Errorf(
+			"cassandra: short chunk %d of %s: %w",
+			c.chunk,
+			c.name,
+			io.ErrUnexpectedEOF,
+		)
+	}
+
+	n := copy(b, c.chunkData[start:])
+	c.offset += int64(n)
+
+	return n, nil
+}
+
+// Seek implements the [io.Seeker].
+func (c *cassandraCache) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += c.offset
+	case io.SeekEnd:
+		offset += c.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	c.offset = offset
+
+	return offset, nil
+}
+
+// Close implements the [io.Closer].
+func (c *cassandraCache) Close() error {
+	c.chunkData = nil
+	return nil
+}
+
+// ModTime returns the modification time of the c.
+func (c *cassandraCache) ModTime() time.Time {
+	return c.modTime
+}
+
+// ETag returns the ETag of the c.
+func (c *cassandraCache) ETag() string {
+	return fmt.Sprintf("%q", hex.EncodeToString(c.checksum))
+}
+
+// Expires returns the expiration time of the c. It is zero if the c never
+// expires.
+func (c *cassandraCache) Expires() time.Time {
+	return c.expires
+}
+
+// cassandraError is an ERROR response of a Cassandra node.
+type cassandraError struct {
+	code    int32
+	message string
+}
+
+// Error implements the error.
+func (ce *cassandraError) Error() string {
+	return fmt.Sprintf("cassandra: %s (code 0x%04x)", ce.message, ce.code)
+}
+
+// cqlRows is the result of a CQL query. It is empty for the queries that do
+// not return rows.
+type cqlRows struct {
+	rows        [][][]byte
+	pagingState []byte
+}
+
+// cassandraConn is a connection to a Cassandra node speaking the CQL native
+// protocol (v4). Requests are sent one at a time, so all of them use the same
+// stream ID.
+type cassandraConn struct {
+	net.Conn
+
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// startup initializes the cc, authenticating with the username and password
+// if the node asks for it.
+func (cc *cassandraConn) startup(
+	ctx context.Context,
+	username string,
+	password string,
+) error {
+	var body cqlBuffer
+	body.writeShort(1)
+	body.writeString("CQL_VERSION")
+	body.writeString("3.0.0")
+	opcode, res, err := cc.roundTrip(ctx, cqlOpStartup, body)
+	if err != nil {
+		return err
+	}
+
+	switch opcode {
+	case cqlOpReady:
+		return nil
+	case cqlOpAuthenticate:
+	default:
+		return fmt.Errorf("unexpected cassandra opcode 0x%02x", opcode)
+	}
+
+	if username == "" {
+		authenticator, _ := res.readString()
+		return fmt.Errorf(
+			"cassandra: authentication required by %s",
+			authenticator,
+		)
+	}
+
+	body = nil
+	body.writeBytes([]byte("\x00" + username + "\x00" + password))
+	opcode, _, err = cc.roundTrip(ctx, cqlOpAuthResponse, body)
+	if err != nil {
+		return err
+	} else if opcode != cqlOpAuthSuccess {
+		return fmt.Errorf("unexpected cassandra opcode 0x%02x", opcode)
+	}
+
+	return nil
+}
+
+// query sends the QUERY request of the cql with the values and returns the
+// rows of the page starting at the pagingState.
+func (cc *cassandraConn) query(
+	ctx context.Context,
+	consistency uint16,
+	cql string,
+	pagingState []byte,
+	values []interface{},
+) (*cqlRows, error) {
+	var body cqlBuffer
+	body.writeLongString(cql)
+	body.writeShort(consistency)
+
+	flags := byte(0x04) // Page size.
+	if len(values) > 0 {
+		flags |= 0x01
+	}
+
+	if pagingState != nil {
+		flags |= 0x08
+	}
+
+	body = append(body, flags)
+	if len(values) > 0 {
+		body.writeShort(uint16(len(values)))
+		for _, value := range values {
+			switch value := value.(type) {
+			case string:
+				body.writeBytes([]byte(value))
+			case []byte:
+				body.writeBytes(value)
+			case int32:
+				body.writeInt(4)
+				body.writeInt(value)
+			case int64:
+				body.writeInt(8)
+				body = append(body, make([]byte, 8)...)
+				binary.BigEndian.PutUint64(
+					body[len(body)-8:],
+					uint64(value),
+				)
+			default:
+				return nil, fmt.Errorf(
+					"unsupported cassandra value type %T",
+					value,
+				)
+			}
+		}
+	}
+
+	body.writeInt(cassandraPageSize)
+	if pagingState != nil {
+		body.writeBytes(pagingState)
+	}
+
+	opcode, res, err := cc.roundTrip(ctx, cqlOpQuery, body)
+	if err != nil {
+		return nil, err
+	} else if opcode != cqlOpResult {
+		return nil, fmt.Errorf(
+			"unexpected cassandra opcode 0x%02x",
+			opcode,
+		)
+	}
+
+	return res.readRows()
+}
+
+// roundTrip sends the request of the opcode with the body and returns the
+// opcode and the body of the response. ERROR responses are returned as
+// [cassandraError]s.
+func (cc *cassandraConn) roundTrip(
+	ctx context.Context,
+	opcode byte,
+	body cqlBuffer,
+) (byte, cqlBuffer, error) {
+	deadline, _ := ctx.Deadline()
+	if err := cc.SetDeadline(deadline); err != nil {
+		return 0, nil, err
+	}
+
+	header := []byte{0x04, 0, 0, 0, opcode, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(header[5:], uint32(len(body)))
+	cc.w.Write(header)
+	cc.w.Write(body)
+	if err := cc.w.Flush(); err != nil {
+		return 0, nil, err
+	}
+
+	if _, err := io.ReadFull(cc.r, header); err != nil {
+		return 0, nil, err
+	} else if header[0] != 0x84 {
+		return 0, nil, fmt.Errorf(
+			"unsupported cassandra protocol version 0x%02x",
+			header[0],
+		)
+	}
+
+	res := make(cqlBuffer, binary.BigEndian.Uint32(header[5:]))
+	if _, err := io.ReadFull(cc.r, res); err != nil {
+		return 0, nil, err
+	}
+
+	if header[1]&0x08 != 0 { // Warnings.
+		n, err := res.readShort()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		for i := 0; i < int(n); i++ {
+			if _, err := res.readString(); err != nil {
+				return 0, nil, err
+			}
+		}
+	}
+
+	if header[4] == cqlOpError {
+		code, err := res.readInt()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		message, err := res.readString()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		return 0, nil, &cassandraError{code: code, message: message}
+	}
+
+	return header[4], res, nil
+}
+
+// cqlBuffer is a buffer of the notations of the CQL native protocol.
+type cqlBuffer []byte
+
+// errShortCQLBuffer is the error returned when reading past the end of a
+// [cqlBuffer].
+var errShortCQLBuffer = errors.New("cassandra: short message")
+
+// writeShort writes the v as a [short].
+func (b *cqlBuffer) writeShort(v uint16) {
+	*b = append(*b, byte(v>>8), byte(v))
+}
+
+// writeInt writes the v as an [int].
+func (b *cqlBuffer) writeInt(v int32) {
+	*b = append(*b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// writeString writes the s as a [string].
+func (b *cqlBuffer) writeString(s string) {
+	b.writeShort(uint16(len(s)))
+	*b = append(*b, s...)
+}
+
+// writeLongString writes the s as a [long string].
+func (b *cqlBuffer) writeLongString(s string) {
+	b.writeInt(int32(len(s)))
+	*b = append(*b, s...)
+}
+
+// writeBytes writes the v as [bytes].
+func (b *cqlBuffer) writeBytes(v []byte) {
+	b.writeInt(int32(len(v)))
+	*b = append(*b, v...)
+}
+
+// read reads the next n bytes.
+func (b *cqlBuffer) read(n int) ([]byte, error) {
+	if n < 0 || n > len(*b) {
+		return nil, errShortCQLBuffer
+	}
+
+	v := (*b)[:n:n]
+	*b = (*b)[n:]
+
+	return v, nil
+}
+
+// readShort reads a [short].
+func (b *cqlBuffer) readShort() (uint16, error) {
+	v, err := b.read(2)
+	if err != nil {
+		return 0, err
+	}
+
+	return binary.BigEndian.Uint16(v), nil
+}
+
+// readInt reads an [int].
+func (b *cqlBuffer) readInt() (int32, error) {
+	v, err := b.read(4)
+	if err != nil {
+		return 0, err
+	}
+
+	return int32(binary.BigEndian.Uint32(v)), nil
+}
+
+// readString reads a [string].
+func (b *cqlBuffer) readString() (string, error) {
+	n, err := b.readShort()
+	if err != nil {
+		return "", err
+	}
+
+	v, err := b.read(int(n))
+	if err != nil {
+		return "", err
+	}
+
+	return string(v), nil
+}
+
+// readBytes reads [bytes]. It returns nil for a null value.
+func (b *cqlBuffer) readBytes() ([]byte, error) {
+	n, err := b.readInt()
+	if err != nil {
+		return nil, err
+	} else if n < 0 {
+		return nil, nil
+	}
+
+	return b.read(int(n))
+}
+
+// readOption skips an [option] describing a type.
+func (b *cqlBuffer) readOption() error {
+	id, err := b.readShort()
+	if err != nil {
+		return err
+	}
+
+	switch id {
+	case 0x0000: // Custom.
+		_, err = b.readString()
+	case 0x0020, 0x0022: // List and set.
+		err = b.readOption()
+	case 0x0021: // Map.
+		if err = b.readOption(); err == nil {
+			err = b.readOption()
+		}
+	case 0x0030: // UDT.
+		if _, err = b.readString(); err != nil {
+			return err
+		} else if _, err = b.readString(); err != nil {
+			return err
+		}
+
+		var n uint16
+		if n, err = b.readShort(); err != nil {
+			return err
+		}
+
+		for i := 0; i < int(n) && err == nil; i++ {
+			if _, err = b.readString(); err == nil {
+				err = b.readOption()
+			}
+		}
+	case 0x0031: // Tuple.
+		var n uint16
+		if n, err = b.readShort(); err != nil {
+			return err
+		}
+
+		for i := 0; i < int(n) && err == nil; i++ {
+			err = b.readOption()
+		}
+	}
+
+	return err
+}
+
+// readRows reads the body of a RESULT response.
+func (b *cqlBuffer) readRows() (*cqlRows, error) {
+	kind, err := b.readInt()
+	if err != nil {
+		return nil, err
+	} else if kind != 0x0002 { // Not rows.
+		return &cqlRows{}, nil
+	}
+
+	flags, err := b.readInt()
+	if err != nil {
+		return nil, err
+	}
+
+	columns, err := b.readInt()
+	if err != nil {
+		return nil, err
+	}
+
+	rows := &cqlRows{}
+	if flags&0x0002 != 0 { // Has more pages.
+		if rows.pagingState, err = b.readBytes(); err != nil {
+			return nil, err
+		}
+	}
+
+	if flags&0x0004 == 0 { // Has metadata.
+		if flags&0x0001 != 0 { // Global table spec.
+			if _, err := b.readString(); err != nil {
+				return nil, err
+			} else if _, err := b.readString(); err != nil {
+				return nil, err
+			}
+		}
+
+		for i := 0; i < int(columns); i++ {
+			if flags&0x0001 == 0 {
+				if _, err := b.readString(); err != nil {
+					return nil, err
+				} else if _, err := b.readString(); err != nil {
+					return nil, err
+				}
+			}
+
+			if _, err := b.readString(); err != nil {
+				return nil, err
+			} else if err := b.readOption(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	n, err := b.readInt()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < int(n); i++ {
+		row := make([][]byte, columns)
+		for j := range row {
+			if row[j], err = b.readBytes(); err != nil {
+				return nil, err
+			}
+		}
+
+		rows.rows = append(rows.rows, row)
+	}
+
+	return rows, nil
+}
+
+// cqlInt decodes the b as a CQL int. It returns zero for a null value.
+func cqlInt(b []byte) int32 {
+	if len(b) != 4 {
+		return 0
+	}
+
+	return int32(binary.BigEndian.Uint32(b))
+}
+
+// cqlBigint decodes the b as a CQL bigint. It returns zero for a null value.
+func cqlBigint(b []byte) int64 {
+	if len(b) != 8 {
+		return 0
+	}
+
+	return int64(binary.BigEndian.Uint64(b))
+}
+
+// cqlTimestamp decodes the b as a CQL timestamp. It returns the zero time for a
+// null value.
+func cqlTimestamp(b []byte) time.Time {
+	if len(b) != 8 {
+		return time.Time{}
+	}
+
+	ms := cqlBigint(b)
+	return time.Unix(ms/1000, ms%1000*int64(time.Millisecond))
+}
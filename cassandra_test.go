@@ -0,0 +1,418 @@
+package goproxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeCassandraServer is a minimal Cassandra node for testing, which only
+// understands the queries sent by the [CassandraCacher].
+type fakeCassandraServer struct {
+	listener net.Listener
+	password string
+	pageSize int
+
+	mutex  sync.Mutex
+	meta   map[string][][]byte
+	ttls   map[string]int32
+	chunks map[string][]byte
+}
+
+// newFakeCassandraServer returns a new [fakeCassandraServer] listening on a
+// random local port.
+func newFakeCassandraServer(
+	t *testing.T,
+	password string,
+) *fakeCassandraServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	fcs := &fakeCassandraServer{
+		listener: l,
+		password: password,
+		pageSize: 2,
+		meta:     map[string][][]byte{},
+		ttls:     map[string]int32{},
+		chunks:   map[string][]byte{},
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go fcs.serve(conn)
+		}
+	}()
+
+	return fcs
+}
+
+// serve serves the conn.
+func (fcs *fakeCassandraServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		header := make([]byte, 9)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return
+		}
+
+		req := make(cqlBuffer, binary.BigEndian.Uint32(header[5:]))
+		if _, err := io.ReadFull(r, req); err != nil {
+			return
+		}
+
+		var (
+			opcode byte
+			flags  byte
+			res    cqlBuffer
+		)
+		switch header[4] {
+		case cqlOpStartup:
+			if fcs.password == "" {
+				opcode = cqlOpReady
+			} else {
+				opcode = cqlOpAuthenticate
+				res.writeString("PasswordAuthenticator")
+			}
+		case cqlOpAuthResponse:
+			token, _ := req.readBytes()
+			if string(token) == "\x00goproxy\x00"+fcs.password {
+				opcode = cqlOpAuthSuccess
+				res.writeInt(-1)
+			} else {
+				opcode = cqlOpError
+				res.writeInt(0x0100)
+				res.writeString("bad credentials")
+			}
+		case cqlOpQuery:
+			opcode = cqlOpResult
+
+			// Every response carries a warning.
+			flags = 0x08
+			res.writeShort(1)
+			res.writeString("warning")
+
+			res = append(res, fcs.query(req)...)
+		}
+
+		header = []byte{0x84, flags, 0, 0, opcode, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(header[5:], uint32(len(res)))
+		conn.Write(append(header, res...))
+	}
+}
+
+// query returns the body of the RESULT response to the QUERY request body.
+func (fcs *fakeCassandraServer) query(req cqlBuffer) cqlBuffer {
+	n, _ := req.readInt()
+	cqlBytes, _ := req.read(int(n))
+	cql := string(cqlBytes)
+	req.readShort()
+	flags, _ := req.read(1)
+	var values [][]byte
+	if flags[0]&0x01 != 0 {
+		n, _ := req.readShort()
+		for i := 0; i < int(n); i++ {
+			value, _ := req.readBytes()
+			values = append(values, value)
+		}
+	}
+
+	req.readInt()
+	var offset int
+	if flags[0]&0x08 != 0 {
+		pagingState, _ := req.readBytes()
+		offset = int(cqlInt(pagingState))
+	}
+
+	fcs.mutex.Lock()
+	defer fcs.mutex.Unlock()
+
+	var (
+		rows        [][][]byte
+		columns     int
+		pagingState []byte
+	)
+	switch {
+	case strings.HasPrefix(cql, "SELECT generation, size, "):
+		columns = 7
+		if row, ok := fcs.meta[string(values[0])]; ok {
+			ttl := make(cqlBuffer, 0, 4)
+			ttl.writeInt(fcs.ttls[string(values[0])])
+			rows = append(rows, append(row[:6:6], ttl))
+		}
+	case strings.HasPrefix(cql, "SELECT generation, chunks "):
+		columns = 2
+		if row, ok := fcs.meta[string(values[0])]; ok {
+			rows = append(rows, [][]byte{row[0], row[3]})
+		}
+	case strings.HasPrefix(cql, "SELECT data "):
+		columns = 1
+		if data, ok := fcs.chunks[string(
+			joinCQLValues(values),
+		)]; ok {
+			rows = append(rows, [][]byte{data})
+		}
+	case strings.HasPrefix(cql, "SELECT name, size "):
+		columns = 2
+		var names []string
+		for name := range fcs.meta {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+		for _, name := range names[offset:] {
+			if len(rows) == fcs.pageSize {
+				pagingState = make(cqlBuffer, 0, 4)
+				(*cqlBuffer)(&pagingState).writeInt(
+					int32(offset + len(rows)),
+				)
+				break
+			}
+
+			rows = append(rows, [][]byte{
+				[]byte(name),
+				fcs.meta[name][1],
+			})
+		}
+	case strings.HasPrefix(cql, "INSERT INTO ks.caches_chunks "):
+		fcs.chunks[string(joinCQLValues(values[:3]))] = values[3]
+	case strings.HasPrefix(cql, "INSERT INTO ks.caches "):
+		fcs.meta[string(values[0])] = values[1:7]
+		fcs.ttls[string(values[0])] = cqlInt(values[7])
+	case strings.HasPrefix(cql, "DELETE FROM ks.caches_chunks "):
+		delete(fcs.chunks, string(joinCQLValues(values)))
+	case strings.HasPrefix(cql, "DELETE FROM ks.caches "):
+		delete(fcs.meta, string(values[0]))
+	}
+
+	var res cqlBuffer
+	if columns == 0 {
+		res.writeInt(0x0001) // Void.
+		return res
+	}
+
+	res.writeInt(0x0002) // Rows.
+	if pagingState != nil {
+		res.writeInt(0x0001 | 0x0002)
+		res.writeInt(int32(columns))
+		res.writeBytes(pagingState)
+	} else {
+		res.writeInt(0x0001)
+		res.writeInt(int32(columns))
+	}
+
+	res.writeString("ks")
+	res.writeString("caches")
+	for i := 0; i < columns; i++ {
+		res.writeString("column")
+		res.writeShort(0x0020) // list<blob>
+		res.writeShort(0x0003)
+	}
+
+	res.writeInt(int32(len(rows)))
+	for _, row := range rows {
+		for _, value := range row {
+			if value == nil {
+				res.writeInt(-1)
+			} else {
+				res.writeBytes(value)
+			}
+		}
+	}
+
+	return res
+}
+
+// joinCQLValues joins the values into a single key.
+func joinCQLValues(values [][]byte) []byte {
+	var key []byte
+	for _, value := range values {
+		key = append(append(key, value...), 0)
+	}
+
+	return key
+}
+
+func TestCassandraCacher(t *testing.T) {
+	fcs := newFakeCassandraServer(t, "secret")
+	defer fcs.listener.Close()
+
+	cc := &CassandraCacher{
+		Addr:       fcs.listener.Addr().String(),
+		Username:   "goproxy",
+		Password:   "secret",
+		Keyspace:   "ks",
+		Prefix:     "tenant/",
+		ChunkBytes: 4,
+	}
+	ctx := context.Background()
+
+	if _, err := cc.Get(ctx, "a"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got error %q, want error %q", err, os.ErrNotExist)
+	}
+
+	if err := cc.Put(
+		ctx,
+		"a",
+		strings.NewReader("foobarbaz"),
+		time.Hour,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if got, want := len(fcs.chunks), 3; got != want {
+		t.Errorf("got %d chunks, want %d", got, want)
+	}
+
+	if got, want := fcs.ttls["tenant/a"], int32(3600); got != want {
+		t.Errorf("got TTL %d, want %d", got, want)
+	}
+
+	rc, err := cc.Get(ctx, "a")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if b, err := ioutil.ReadAll(rc); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := string(b), "foobarbaz"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if _, err := rc.(io.Seeker).Seek(5, io.SeekStart); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if b, err := ioutil.ReadAll(rc); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := string(b), "rbaz"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	modTime := rc.(interface{ ModTime() time.Time }).ModTime()
+	if modTime.IsZero() {
+		t.Error("expected modification time")
+	}
+
+	if got := rc.(interface{ Expires() time.Time }).Expires(); got.Before(
+		time.Now().Add(59 * time.Minute),
+	) {
+		t.Errorf("got expiration time %s", got)
+	}
+
+	if got := rc.(interface{ ETag() string }).ETag(); got == "" {
+		t.Error("expected ETag")
+	}
+
+	rc.Close()
+
+	// The chunks of the replaced content are removed.
+	if err := cc.Put(
+		ctx,
+		"a",
+		strings.NewReader("qux"),
+		foreverCacheExpiration,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if got, want := len(fcs.chunks), 1; got != want {
+		t.Errorf("got %d chunks, want %d", got, want)
+	}
+
+	if got, want := fcs.ttls["tenant/a"], int32(0); got != want {
+		t.Errorf("got TTL %d, want %d", got, want)
+	}
+
+	for _, name := range []string{"b", "c", "d"} {
+		if err := cc.Put(
+			ctx,
+			name,
+			strings.NewReader(""),
+			time.Hour,
+		); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	fcs.meta["other/e"] = fcs.meta["tenant/b"]
+
+	var names []string
+	if err := cc.walkCaches(func(name string, size int64) error {
+		names = append(names, name)
+		return nil
+	}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if got, want := strings.Join(names, ","), "a,b,c,d"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	rc, err = cc.Get(ctx, "b")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if b, err := ioutil.ReadAll(rc); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if len(b) != 0 {
+		t.Errorf("got %q, want empty", b)
+	}
+
+	if err := cc.Delete(ctx, "a"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if got, want := len(fcs.chunks), 0; got != want {
+		t.Errorf("got %d chunks, want %d", got, want)
+	}
+
+	if err := cc.Delete(ctx, "a"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got error %q, want error %q", err, os.ErrNotExist)
+	}
+
+	// Already expired caches are deleted.
+	if err := cc.Put(ctx, "b", strings.NewReader(""), 0); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if _, err := cc.Get(ctx, "b"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got error %q, want error %q", err, os.ErrNotExist)
+	}
+
+	cc = &CassandraCacher{
+		Addr:     fcs.listener.Addr().String(),
+		Username: "goproxy",
+		Password: "wrong",
+		Keyspace: "ks",
+	}
+	if _, err := cc.Get(ctx, "c"); err == nil {
+		t.Fatal("expected error")
+	}
+
+	cc = &CassandraCacher{
+		Addr:        fcs.listener.Addr().String(),
+		Keyspace:    "ks",
+		Consistency: "SOME",
+	}
+	if _, err := cc.Get(ctx, "c"); err == nil {
+		t.Fatal("expected error")
+	}
+}
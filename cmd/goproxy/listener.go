@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyHeaderTimeout is the maximum amount of time allowed for a client to
+// send its PROXY protocol header.
+const proxyHeaderTimeout = 10 * time.Second
+
+// proxyHeaderV2Signature is the signature of the PROXY protocol version 2
+// headers.
+var proxyHeaderV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// listen listens on each of the comma-separated addresses. IP literals are
+// listened on exclusively for their own families, so that an IPv4 and an
+// IPv6 wildcard address (e.g. "0.0.0.0:8080,[::]:8080") can be listened on
+// at the same time.
+func listen(addresses string) ([]net.Listener, error) {
+	var listeners []net.Listener
+	for _, address := range strings.Split(addresses, ",") {
+		address = strings.TrimSpace(address)
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			closeListeners(listeners)
+			return nil, err
+		}
+
+		network := "tcp"
+		if ip := net.ParseIP(host); ip != nil {
+			if ip.To4() != nil {
+				network = "tcp4"
+			} else {
+				network = "tcp6"
+			}
+		}
+
+		l, err := net.Listen(network, address)
+		if err != nil {
+			closeListeners(listeners)
+			return nil, err
+		}
+
+		listeners = append(listeners, l)
+	}
+
+	return listeners, nil
+}
+
+// closeListeners closes the listeners.
+func closeListeners(listeners []net.Listener) {
+	for _, l := range listeners {
+		l.Close()
+	}
+}
+
+// proxyProtocolListener is a [net.Listener] that accepts connections prefixed
+// with PROXY protocol (version 1 or 2) headers, as sent by L4 load balancers
+// (e.g. HAProxy), and reports the client addresses carried by the headers as
+// the remote addresses of the connections.
+type proxyProtocolListener struct {
+	net.Listener
+
+	// trustedIPNets are the IP networks of the peers allowed to send PROXY
+	// protocol headers, which they must then always send. Connections
+	// from other peers are accepted as they are. If empty, all peers are
+	// trusted.
+	trustedIPNets []*net.IPNet
+}
+
+// Accept implements the [net.Listener].
+func (l *proxyProtocolListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if !l.trusts(c.RemoteAddr()) {
+		return c, nil
+	}
+
+	return &proxyProtocolConn{Conn: c, r: bufio.NewReader(c)}, nil
+}
+
+// trusts reports whether the peer at the addr is allowed to send PROXY
+// protocol headers.
+func (l *proxyProtocolListener) trusts(addr net.Addr) bool {
+	if len(l.trustedIPNets) == 0 {
+		return true
+	}
+
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+
+	for _, ipNet := range l.trustedIPNets {
+		if ipNet.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// proxyProtocolConn is a [net.Conn] prefixed with a PROXY protocol header. The
+// header is read on the first call of its Read or RemoteAddr, so that a slow
+// client cannot block the [proxyProtocolListener.Accept].
+type proxyProtocolConn struct {
+	net.Conn
+
+	r          *bufio.Reader
+	once       sync.Once
+	remoteAddr net.Addr
+	err        error
+}
+
+// Read implements the [net.Conn].
+func (c *proxyProtocolConn) Read(b []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	return c.r.Read(b)
+}
+
+// RemoteAddr implements the [net.Conn].
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	c.readHeader()
+	return c.remoteAddr
+}
+
+// readHeader reads the PROXY protocol header of the c once.
+func (c *proxyProtocolConn) readHeader() {
+	c.once.Do(func() {
+		c.remoteAddr = c.Conn.RemoteAddr()
+		if err := c.SetReadDeadline(
+			time.Now().Add(proxyHeaderTimeout),
+		); err != nil {
+			c.err = err
+			return
+		}
+
+		remoteAddr, err := readProxyHeader(c.r)
+		if err != nil {
+			c.err = fmt.Errorf(
+				"invalid PROXY protocol header: %w",
+				err,
+			)
+			return
+		} else if remoteAddr != nil {
+			c.remoteAddr = remoteAddr
+		}
+
+		c.err = c.SetReadDeadline(time.Time{})
+	})
+}
+
+// readProxyHeader reads a PROXY protocol header (version 1 or 2) from the r
+// and returns the client address it carries. The returned address is nil if
+// the header does not carry any (e.g. health checks of load balancers).
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	b, err := r.Peek(len("PROXY "))
+	if err != nil {
+		return nil, err
+	} else if string(b) == "PROXY " {
+		return readProxyHeaderV1(r)
+	}
+
+	b, err = r.Peek(len(proxyHeaderV2Signature))
+	if err != nil {
+		return nil, err
+	} else if !bytes.Equal(b, proxyHeaderV2Signature) {
+		return nil, errors.New("missing signature")
+	}
+
+	return readProxyHeaderV2(r)
+}
+
+// readProxyHeaderV1 reads a PROXY protocol version 1 header from the r.
+func readProxyHeaderV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < 107 { // Maximum length of a version 1 header
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("malformed version 1 header")
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	} else if len(fields) != 6 ||
+		(fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errors.New("malformed version 1 header")
+	}
+
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, errors.New("malformed version 1 header")
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyHeaderV2 reads a PROXY protocol version 2 header from the r.
+func readProxyHeaderV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, len(proxyHeaderV2Signature)+4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	verCmd := header[len(proxyHeaderV2Signature)]
+	family := header[len(proxyHeaderV2Signature)+1]
+	body := make([]byte, binary.BigEndian.Uint16(header[len(header)-2:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	if verCmd>>4 != 2 {
+		return nil, errors.New("unsupported version")
+	}
+
+	switch verCmd & 0xf {
+	case 0: // LOCAL
+		return nil, nil
+	case 1: // PROXY
+	default:
+		return nil, errors.New("unsupported command")
+	}
+
+	switch family >> 4 {
+	case 1: // AF_INET
+		if len(body) < 12 {
+			return nil, errors.New("malformed version 2 header")
+		}
+
+		return &net.TCPAddr{
+			IP:   net.IP(body[:4]),
+			Port: int(binary.BigEndian.Uint16(body[8:10])),
+		}, nil
+	case 2: // AF_INET6
+		if len(body) < 36 {
+			return nil, errors.New("malformed version 2 header")
+		}
+
+		return &net.TCPAddr{
+			IP:   net.IP(body[:16]),
+			Port: int(binary.BigEndian.Uint16(body[32:34])),
+		}, nil
+	}
+
+	return nil, nil // AF_UNSPEC or AF_UNIX
+}
@@ -2,14 +2,20 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
 	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"flag"
+	"fmt"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -17,19 +23,145 @@ import (
 )
 
 var (
-	address             = flag.String("address", "localhost:8080", "TCP address that the HTTP server listens on")
+	address             = flag.String("address", "localhost:8080", "comma-separated list of TCP addresses that the HTTP server listens on (e.g. \"0.0.0.0:8080,[::]:8080\" for both IPv4 and IPv6)")
+	proxyProtocol       = flag.Bool("proxy-protocol", false, "accept PROXY protocol headers (version 1 or 2) sent by L4 load balancers to recover the real client addresses")
+	proxyProtocolNets   = flag.String("proxy-protocol-trusted-nets", "", "comma-separated list of CIDR IP networks of the load balancers required to send PROXY protocol headers (empty means all peers)")
 	tlsCertFile         = flag.String("tls-cert-file", "", "path to the TLS certificate file")
 	tlsKeyFile          = flag.String("tls-key-file", "", "path to the TLS key file")
+	tlsMinVersion       = flag.String("tls-min-version", "", "minimum TLS version (\"1.0\", \"1.1\", \"1.2\" or \"1.3\", empty means Go default) accepted by the HTTP server")
+	tlsCipherSuites     = flag.String("tls-cipher-suites", "", "comma-separated list of TLS cipher suites (empty means Go default) accepted by the HTTP server for TLS 1.2 and below")
+	tlsOCSPFile         = flag.String("tls-ocsp-staple-file", "", "path to the DER-encoded OCSP response file stapled to the TLS certificate, reloaded once changed (empty means disabled)")
 	goBinName           = flag.String("go-bin-name", "go", "name of the Go binary")
 	goBinMaxWorkers     = flag.Int("go-bin-max-workers", 0, "maximum number (0 means no limit) of commands allowed for the Go binary to execute at the same time")
+	goBinMaxModWorkers  = flag.Int("go-bin-max-workers-per-module", 0, "maximum number (0 means no limit) of commands allowed for the Go binary to execute at the same time for the same module path")
+	maxOpenCaches       = flag.Int("max-open-caches", 0, "maximum number (0 means no limit) of caches allowed to be open at the same time for serving clients")
+	resourceGuardrails  = flag.Bool("resource-guardrails", false, "derive the limits left zero, as well as buffer sizes, from the memory and open file limits detected at startup (e.g. of the container)")
+	goBinAllowedVCS     = flag.String("go-bin-allowed-vcs", "", "comma-separated list of version control systems allowed for the Go binary to use (empty means all)")
+	goBinVCS            = flag.String("go-bin-vcs", "", "GOVCS policy of the version control systems allowed for the Go binary to use per module path pattern, enforced with 403 Forbidden responses (empty means the GOVCS environment variable)")
+	goBinSandboxUID     = flag.Int("go-bin-sandbox-uid", 0, "user ID (0 means current user) that the Go binary runs as")
+	goBinSandboxGID     = flag.Int("go-bin-sandbox-gid", 0, "group ID (0 means current group) that the Go binary runs as")
+	goBinSandboxCgroup  = flag.String("go-bin-sandbox-cgroup-dir", "", "directory of an existing cgroup that the Go binary runs inside")
+	goBinSandboxPrefix  = flag.String("go-bin-sandbox-command-prefix", "", "space-separated command used to launch the Go binary (e.g. \"unshare --net\")")
+	requestTimeout      = flag.Duration("request-timeout", 0, "maximum amount of time (0 means no limit) allowed to serve a module proxy or checksum database proxy request before responding with a 504 Gateway Timeout")
+	zipRequestTimeout   = flag.Duration("zip-request-timeout", 0, "like the -request-timeout, but for module zip files (0 means the -request-timeout)")
+	noFetchHeader       = flag.String("no-fetch-header", "", "name of the request header that asks to be served only from the cache (empty means \"GONOFETCH\")")
+	proxiedOnly         = flag.Bool("proxied-only", false, "never fetch modules directly from their version control systems")
+	deterministicZips   = flag.Bool("deterministic-zips", false, "repack module zip files fetched directly into a deterministic form")
+	nativeDirectFetches = flag.Bool("native-direct-fetches", false, "fetch modules directly from their Git repositories in-process, using the git binary only for the repository operations, and leave only the unsupported fetches to the Go binary")
+	streamZips          = flag.Bool("stream-zip-downloads", false, "stream module zip files fetched from upstream proxies to clients while they are being downloaded")
+	correctInfoTimes    = flag.Bool("correct-info-times", false, "correct bogus times of info files fetched from upstream proxies using version control systems")
+	doubleFetchModules  = flag.String("double-fetch-modules", "", "comma-separated list of module path patterns whose module files are fetched from two independent upstreams and only cached if they match")
+	doubleFetchProxy    = flag.String("double-fetch-proxy", "", "independent upstream (a module proxy URL or \"direct\") that the -double-fetch-modules are fetched from again")
+	blockedModuleHosts  = flag.String("blocked-module-hosts", "", "comma-separated list of hostname patterns (e.g. \"*.example\") of blocked modules")
+	blockedModuleIPNets = flag.String("blocked-module-ip-nets", "", "comma-separated list of CIDR IP networks that modules are not allowed to be fetched directly from")
 	pathPrefix          = flag.String("path-prefix", "", "prefix of all request paths")
 	cacherDir           = flag.String("cacher-dir", "caches", "directory that used to cache module files")
+	cacherDirLayout     = flag.String("cacher-dir-layout", "flat", "layout (\"flat\" or \"sharded\") of the cache files in the cacher directory")
+	cacherDirMigrate    = flag.Bool("cacher-dir-migrate", false, "migrate the cacher directory in place to the -cacher-dir-layout at startup if needed")
+	cacherDirDedupe     = flag.Bool("cacher-dir-dedupe", false, "store identical cache files only once in the cacher directory (across tenants too) by hard-linking them to blobs addressed by their hashes")
+	cacherS3Bucket      = flag.String("cacher-s3-bucket", "", "name of the S3 bucket used to cache module files instead of the -cacher-dir, with credentials read from the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables (empty means disabled)")
+	cacherS3Endpoint    = flag.String("cacher-s3-endpoint", "", "base URL of the S3-compatible endpoint of the -cacher-s3-bucket (empty means Amazon S3)")
+	cacherS3Region      = flag.String("cacher-s3-region", "", "region of the -cacher-s3-bucket (empty means \"us-east-1\")")
+	cacherS3Prefix      = flag.String("cacher-s3-prefix", "", "prefix of the object keys in the -cacher-s3-bucket")
+	cacherS3PathStyle   = flag.Bool("cacher-s3-path-style", false, "address the -cacher-s3-bucket in request paths instead of hostnames")
+	cacherAzureAccount  = flag.String("cacher-azure-account-url", "", "URL of the Blob service of the Azure storage account used to cache module files instead of the -cacher-dir, with the SAS token read from the AZURE_STORAGE_SAS_TOKEN environment variable (empty means disabled)")
+	cacherAzContainer   = flag.String("cacher-azure-container", "", "name of the container in the -cacher-azure-account-url")
+	cacherAzurePrefix   = flag.String("cacher-azure-prefix", "", "prefix of the blob names in the -cacher-azure-container")
+	cacherAzureMSI      = flag.Bool("cacher-azure-managed-identity", false, "authorize requests to the -cacher-azure-account-url with the managed identity of the host if no SAS token is given")
+	cacherAzureMSIID    = flag.String("cacher-azure-managed-identity-client-id", "", "client ID of the user-assigned managed identity (empty means the system-assigned one)")
+	cacherCassandraAddr = flag.String("cacher-cassandra-addr", "", "address of the Cassandra node used to cache module files instead of the -cacher-dir, with credentials read from the CASSANDRA_USERNAME and CASSANDRA_PASSWORD environment variables (empty means disabled)")
+	cacherCasKeyspace   = flag.String("cacher-cassandra-keyspace", "goproxy", "keyspace of the tables in the -cacher-cassandra-addr")
+	cacherCasTable      = flag.String("cacher-cassandra-table", "", "name of the table in the -cacher-cassandra-keyspace, with the chunks of the caches stored in the table of the same name suffixed with \"_chunks\" (empty means \"caches\")")
+	cacherCasPrefix     = flag.String("cacher-cassandra-prefix", "", "prefix of the cache names in the -cacher-cassandra-table")
+	cacherCasConsist    = flag.String("cacher-cassandra-consistency", "", "consistency level of the queries to the -cacher-cassandra-addr (empty means \"LOCAL_QUORUM\")")
+	cacherCasChunkBytes = flag.Int("cacher-cassandra-chunk-bytes", 0, "maximum number of bytes of each chunk of the caches stored in the -cacher-cassandra-addr (0 means 1 MiB)")
+	cacherCasTLS        = flag.Bool("cacher-cassandra-tls", false, "connect to the -cacher-cassandra-addr using TLS")
+	cacherMemoryBytes   = flag.Int64("cacher-memory-max-bytes", 0, "maximum total number of bytes of the caches kept in memory instead of the -cacher-dir, beyond which the least recently used ones are evicted (0 means disabled)")
+	cacherRedisAddr     = flag.String("cacher-redis-addr", "", "address of the Redis server used to cache module files other than zip files, with credentials read from the REDIS_USERNAME and REDIS_PASSWORD environment variables (empty means disabled)")
+	cacherRedisDB       = flag.Int("cacher-redis-db", 0, "index of the database selected in the -cacher-redis-addr")
+	cacherRedisPrefix   = flag.String("cacher-redis-prefix", "", "prefix of the keys in the -cacher-redis-addr")
+	cacherRedisTLS      = flag.Bool("cacher-redis-tls", false, "connect to the -cacher-redis-addr using TLS")
+	cacherRedisZips     = flag.Bool("cacher-redis-zips", false, "also cache module zip files in the -cacher-redis-addr instead of the other cacher")
+	cacherRedisLocks    = flag.Bool("cacher-redis-fetch-locks", false, "coordinate the downloads of module versions with the other instances sharing the -cacher-redis-addr via locks in it, so that only one of them downloads each module version")
+	cacherBolt          = flag.Bool("cacher-bolt", false, "cache module files other than zip files in a single bbolt database file in the -cacher-dir instead of one file each")
 	cacherMaxCacheBytes = flag.Int("cacher-max-cache-bytes", 0, "maximum number (0 means no limit) of bytes allowed for the cacher to store a cache")
-	proxiedSUMDBs       = flag.String("proxied-sumdbs", "", "comma-separated list of proxied checksum databases")
-	tempDir             = flag.String("temp-dir", os.TempDir(), "directory for storing temporary files")
+	cacherMaxBytes      = flag.Int64("cacher-max-bytes", 0, "maximum total number (0 means no limit) of bytes of the caches stored by the cacher since startup, beyond which the least valuable ones are evicted")
+	cacherQuotaBytes    = flag.Int64("cacher-quota-bytes", 0, "high watermark of the total number (0 means no limit) of bytes of all the caches in the cacher, including the ones stored before startup, beyond which the least recently accessed ones are evicted down to 90% of it")
+	cacherQuotaIndex    = flag.Bool("cacher-quota-access-index", false, "keep the last access times of the caches for the -cacher-quota-bytes in an index file in the -cacher-dir instead of relying on the file system, which may be mounted with \"noatime\"")
+	cacherDedupeZips    = flag.Bool("cacher-dedupe-zips", false, "store identical module zip files only once in the cacher directory, addressed by their hashes")
+	cacherEncKeyFile    = flag.String("cacher-encryption-key-file", "", "file of the base64-encoded AES key (defaults to the CACHER_ENCRYPTION_KEY environment variable) for encrypting the caches at rest")
+	cacherCompressText  = flag.Bool("cacher-compress-text", false, "compress the cached \".info\" and \".mod\" files, version lists and resolved versions with gzip")
+	cacherVerifySizes   = flag.Bool("cacher-verify-sizes", false, "verify the size of each cache right after being stored")
+	cacherClockSkew     = flag.Duration("cacher-clock-skew", 0, "how far the clocks of other instances putting caches into the shared cacher may run behind, tolerated by expiration checks")
+	sumdbKeys           = flag.String("sumdb-keys", "", "comma-separated list of verifier keys pinned for the checksum database of the GOSUMDB, any of which is accepted when it rotates its key (empty means the key of the GOSUMDB is trusted on first use)")
+	proxiedSUMDBs       = flag.String("proxied-sumdbs", "", "comma-separated list of proxied checksum databases, each of the form \"<sumdb-name>\" or \"<sumdb-name> <sumdb-URL>\"")
+	tempDir             = flag.String("temp-dir", "", "directory for storing temporary files (empty means a \".tmp\" directory inside the -cacher-dir when it is used, so that fetched module files can be hard-linked into it rather than copied, or the system temporary directory otherwise)")
+	userAgent           = flag.String("user-agent", "", "User-Agent of the requests sent to upstreams (empty means \"goproxy/<version> (instance <id>)\")")
+	upstreamAuth        = flag.String("upstream-auth", "", "comma-separated list of credentials attached to the requests sent to upstreams, each of the form \"<prefix> bearer <token>\", \"<prefix> basic <username>:<password>\" or \"<prefix> header <name>:<value>\", where the <prefix> is a host optionally followed by a path prefix (defaults to the UPSTREAM_AUTH environment variable)")
+	upstreamNetrc       = flag.String("upstream-netrc", "", "path of a .netrc file whose credentials are attached to the requests sent to upstreams not matching any of the -upstream-auth, also used by the Go binary")
+	instanceID          = flag.String("instance-id", "", "ID of this instance within a fleet, reported by the \"/-/version\" administrative endpoint (empty means the hostname)")
 	insecure            = flag.Bool("insecure", false, "allow insecure TLS connections")
+	upstreamTLSMinVer   = flag.String("upstream-tls-min-version", "", "minimum TLS version (\"1.0\", \"1.1\", \"1.2\" or \"1.3\", empty means Go default) used to connect to upstreams")
+	upstreamTLSCiphers  = flag.String("upstream-tls-cipher-suites", "", "comma-separated list of TLS cipher suites (empty means Go default) used to connect to upstreams for TLS 1.2 and below")
 	connectTimeout      = flag.Duration("connect-timeout", 30*time.Second, "maximum amount of time (0 means no limit) will wait for an outgoing connection to establish")
-	fetchTimeout        = flag.Duration("fetch-timeout", 0, "maximum amount of time (0 means no limit) will wait for a fetch to complete")
+	fetchTimeout        = flag.Duration("fetch-timeout", 0, "maximum amount of time (0 means no limit) will wait for a fetch to resolve, list or download the info or mod file of a module version to complete")
+	zipFetchTimeout     = flag.Duration("zip-fetch-timeout", 0, "like the -fetch-timeout, but for the fetches to download module zip files (0 means the -fetch-timeout)")
+	consistencyUpstream = flag.String("consistency-check-upstream", "", "second upstream module proxy to cross-check cached module files against")
+	consistencyInterval = flag.Duration("consistency-check-interval", 0, "interval (0 means disabled) between two rounds of cross-checking cached module files")
+	zipRecompInterval   = flag.Duration("zip-recompression-interval", 0, "interval (0 means disabled) between two rounds of recompressing cached module zip files with a stronger compression level in the background")
+	zipRecompLevel      = flag.Int("zip-recompression-level", 0, "Deflate compression level (0 means best compression) of the -zip-recompression-interval")
+	listExpiry          = flag.Duration("list-cache-expiration", 0, "expiration (0 means one minute) of cached version lists")
+	latestExpiry        = flag.Duration("latest-cache-expiration", 0, "expiration (0 means one minute) of cached resolved versions (@latest and version queries)")
+	immutableExpiry     = flag.Duration("immutable-cache-expiration", 0, "expiration (0 means one minute, negative means never) of cached module files of module versions")
+	pseudoVersionExpiry = flag.Duration("pseudo-version-cache-expiration", 0, "expiration (0 means same as release versions) of cached module files of pseudo-versions")
+	notFoundExpiry      = flag.Duration("not-found-cache-expiration", 0, "expiration (0 means disabled) of cached not-found results of fetching module files")
+	staleIfErrorExpiry  = flag.Duration("stale-if-error-expiration", 0, "how long (0 means disabled) copies of version lists and resolved versions are kept for serving stale when upstream fails")
+	staleWhileReval     = flag.Bool("stale-while-revalidate", false, "serve expired version lists and resolved versions stale while refreshing them in the background (requires -stale-if-error-expiration)")
+	emergencyStale      = flag.Bool("emergency-stale", false, "start in the emergency stale mode, serving expired version lists and resolved versions stale without waiting for upstream (requires -stale-if-error-expiration)")
+	emergencyStaleAfter = flag.Int("emergency-stale-threshold", 0, "number (0 means never) of consecutive upstream failures after which the emergency stale mode is entered automatically, until upstream recovers")
+	cleanupInterval     = flag.Duration("cleanup-interval", 0, "interval (0 means disabled) between two removals of expired caches")
+	mergeLists          = flag.Bool("merge-lists", false, "merge the version lists of all upstream module proxies, along with the ones merged before, into a single superset list")
+	upstreamCacheHdrs   = flag.Bool("upstream-cache-headers", false, "honor the Cache-Control, ETag and Last-Modified of upstream module proxy responses for cache expirations and revalidations")
+	readAheadExts       = flag.String("read-ahead-exts", "", "comma-separated list of extensions (\".mod\" and \".zip\") of module files to prefetch when the \".info\" is requested")
+	peers               = flag.String("peers", "", "comma-separated list of base URLs of peer instances (with a \"dns+\" scheme prefix for discovery via DNS) asked for missing module files before fetching them from upstream")
+	hotCacheMaxBytes    = flag.Int64("hot-cache-max-bytes", 0, "memory budget (0 means disabled) in bytes for serving the hottest cached files from memory-mapped files")
+	warmInterval        = flag.Duration("warm-interval", 0, "interval (0 means disabled) between two rounds of keeping the latest patch releases of the modules observed via the \"/-/warm\" endpoint warm")
+	warmRetention       = flag.Duration("warm-retention", 7*24*time.Hour, "duration for which a module observed via the \"/-/warm\" endpoint is kept warm")
+	upstreamRateLimit   = flag.Float64("upstream-rate-limit", 0, "rate limit in requests per second (0 means no limit) of each upstream host that bulk work is spread over time to respect")
+	upstreamMaxRetries  = flag.Int("upstream-max-retries", 0, "maximum number of times (0 means 9, negative means never) that a failed request sent to an upstream module proxy or checksum database is retried with exponential backoff")
+	upstreamBackoffBase = flag.Duration("upstream-retry-backoff-base", 0, "base (0 means 100ms) of the exponential backoff between two attempts of a request under the -upstream-max-retries")
+	upstreamBackoffCap  = flag.Duration("upstream-retry-backoff-cap", 0, "cap (0 means 1s) of the exponential backoff between two attempts of a request under the -upstream-max-retries")
+	upstreamRetryCodes  = flag.String("upstream-retryable-status-codes", "", "comma-separated list of HTTP status codes of upstream responses that are retried under the -upstream-max-retries (empty means \"429,500,502,503,504\")")
+	upstreamRateBurst   = flag.Int("upstream-rate-burst", 0, "maximum number of requests sent to each upstream host in a burst under the -upstream-rate-limit (0 means the rate limit rounded up)")
+	metricsPrometheus   = flag.Bool("metrics-prometheus", false, "expose the metrics in the Prometheus text exposition format at the \"/-/metrics\" administrative endpoint")
+	metricsOTLPEndpoint = flag.String("metrics-otlp-endpoint", "", "URL of the OTLP/HTTP endpoint (e.g. \"http://localhost:4318/v1/metrics\") that the metrics are pushed to (empty means disabled)")
+	metricsOTLPInterval = flag.Duration("metrics-otlp-interval", time.Minute, "interval between two pushes of the metrics to the -metrics-otlp-endpoint")
+	statsSaveInterval   = flag.Duration("stats-save-interval", 0, "interval (0 means disabled) between two saves of the stats into the cacher, which are restored at startup")
+	shards              = flag.String("shards", "", "comma-separated list of base URLs of all instances of a fleet that module paths are routed to by consistent hashing (empty means no sharding)")
+	shardSelf           = flag.String("shard-self", "", "base URL of this instance in the -shards (empty means a pure router)")
+	prefetchMaxAttempts = flag.Int("prefetch-max-attempts", 0, "maximum number (0 means a single attempt without a persistent queue) of attempts of a read-ahead before it is kept as a dead letter")
+	upstreamGonePolicy  = flag.String("upstream-gone-policy", "ignore", "policy (\"ignore\", \"flag\" or \"evict\") for the cached module versions that upstream starts reporting as gone")
+	trashRetention      = flag.Duration("trash-retention", 0, "duration (0 means purged caches are removed permanently) for which purged caches can be restored")
+	clientQuotaMaxBytes = flag.Int64("client-quota-max-bytes", 0, "maximum number (0 means no limit) of bytes allowed to be served to a client IP address within a client quota window")
+	clientQuotaWindow   = flag.Duration("client-quota-window", 24*time.Hour, "time window of the client quota")
+	vanityImports       = flag.String("vanity-imports", "", "comma-separated list of vanity imports served as \"?go-get=1\" meta pages, each in the form \"prefix vcs repo-root\"")
+	pathRewrites        = flag.String("path-rewrites", "", "comma-separated list of module path rewrites for vanity import domain migrations, each in the form \"old-prefix new-prefix [until]\" with an optional RFC 3339 end of the compatibility window")
+	pinnedModules       = flag.String("pinned-modules", "", "comma-separated list of module patterns (e.g. \"example.com/lib\" or \"example.com/lib@v1.2.3\") whose module files are never expired nor evicted")
+	freshnessModules    = flag.String("freshness-modules", "", "comma-separated list of module paths whose freshness is reported by the \"/-/freshness\" administrative endpoint")
+	backfillDir         = flag.String("backfill-dir", "", "directory of an existing module cache to import into the cacher directory before exiting (empty means disabled)")
+	backfillFormat      = flag.String("backfill-format", "modcache", "format (\"modcache\" or \"athens\") of the -backfill-dir")
+	startupScan         = flag.Bool("startup-scan", false, "scan the caches for integrity in the background at startup, removing the invalid ones and rebuilding the indexes derived from them")
+	startupScanDuration = flag.Duration("startup-scan-max-duration", 0, "maximum amount of time (0 means no limit) of the -startup-scan")
+	startupScanWorkers  = flag.Int("startup-scan-parallelism", 1, "maximum number of caches validated at the same time by the -startup-scan")
+	preflight           = flag.Bool("preflight", false, "check the Go binary, the temporary and cacher directories, the free disk space, the upstream module proxies and the checksum database key at startup, and exit if any check fails")
+	tenantsFile         = flag.String("tenants-file", "", "path to the JSON file declaring the tenants to serve (empty means single tenant)")
+	debugModules        = flag.String("debug-modules", "", "comma-separated list of module path patterns whose exchanges with upstream module proxies are logged for troubleshooting")
+	errorReferenceIDs   = flag.Bool("error-reference-ids", false, "include reference IDs in error responses for correlation with logged errors")
+	artifactAuditLog    = flag.String("artifact-audit-log", "", "file that each request for a module file is appended to as a line of JSON, along with the build identifier of the -build-id-header (empty means disabled)")
+	buildIDHeader       = flag.String("build-id-header", "X-Build-ID", "name of the request header by which clients identify their builds in the -artifact-audit-log")
+	privateModules      = flag.String("private-modules", "", "comma-separated list of module path patterns (like GOPRIVATE) of the private modules that require the -private-modules-token, while all other modules are served anonymously")
+	privateModulesToken = flag.String("private-modules-token", "", "token required to access the -private-modules, either as a bearer token or as the password of the basic authentication (e.g. in a .netrc file) with any username")
+	adminToken          = flag.String("admin-token", "", "bearer token required to access the administrative endpoints (empty means disabled)")
 )
 
 type httpDirFS struct{}
@@ -54,51 +186,848 @@ func (fs httpDirFS) Open(name string) (http.File, error) {
 func main() {
 	flag.Parse()
 
+	if *tempDir == "" {
+		*tempDir = os.TempDir()
+		if *cacherDir != "" &&
+			*cacherS3Bucket == "" &&
+			*cacherAzureAccount == "" &&
+			*cacherCassandraAddr == "" &&
+			*cacherMemoryBytes == 0 {
+			*tempDir = filepath.Join(*cacherDir, ".tmp")
+		}
+	}
+
+	if err := os.MkdirAll(*tempDir, 0750); err != nil {
+		log.Fatal(err)
+	}
+
 	transport := http.DefaultTransport.(*http.Transport).Clone()
 	transport.DialContext = (&net.Dialer{
 		Timeout:   *connectTimeout,
 		KeepAlive: 30 * time.Second,
 	}).DialContext
-	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: *insecure}
+	tlsClientConfig, err := newTLSConfig(
+		*upstreamTLSMinVer,
+		*upstreamTLSCiphers,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	tlsClientConfig.InsecureSkipVerify = *insecure
+	transport.TLSClientConfig = tlsClientConfig
 	transport.RegisterProtocol("file", http.NewFileTransport(httpDirFS{}))
 
-	g := &goproxy.Goproxy{
-		GoBinName:           *goBinName,
-		GoBinMaxWorkers:     *goBinMaxWorkers,
-		PathPrefix:          *pathPrefix,
-		Cacher:              goproxy.DirCacher(*cacherDir),
-		CacherMaxCacheBytes: *cacherMaxCacheBytes,
-		ProxiedSUMDBs:       strings.Split(*proxiedSUMDBs, ","),
-		Transport:           transport,
-		TempDir:             *tempDir,
-	}
-
-	server := &http.Server{Addr: *address}
-	if *fetchTimeout == 0 {
-		server.Handler = g
-	} else {
-		server.Handler = http.HandlerFunc(func(
-			rw http.ResponseWriter,
-			req *http.Request,
-		) {
-			ctx, cancel := context.WithTimeout(
-				req.Context(),
-				*fetchTimeout,
+	var blockedIPNets []*net.IPNet
+	if *blockedModuleIPNets != "" {
+		for _, s := range strings.Split(*blockedModuleIPNets, ",") {
+			_, ipNet, err := net.ParseCIDR(strings.TrimSpace(s))
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			blockedIPNets = append(blockedIPNets, ipNet)
+		}
+	}
+
+	var retryableStatusCodes []int
+	if *upstreamRetryCodes != "" {
+		for _, s := range strings.Split(*upstreamRetryCodes, ",") {
+			code, err := strconv.Atoi(strings.TrimSpace(s))
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			retryableStatusCodes = append(retryableStatusCodes, code)
+		}
+	}
+
+	rawUpstreamAuth := *upstreamAuth
+	if rawUpstreamAuth == "" {
+		rawUpstreamAuth = os.Getenv("UPSTREAM_AUTH")
+	}
+
+	var upstreamCredentials []goproxy.UpstreamCredential
+	if rawUpstreamAuth != "" {
+		for _, ua := range strings.Split(rawUpstreamAuth, ",") {
+			fields := strings.SplitN(strings.TrimSpace(ua), " ", 3)
+			if len(fields) != 3 {
+				log.Fatal("invalid upstream credential")
+			}
+
+			uc := goproxy.UpstreamCredential{Prefix: fields[0]}
+			credParts := strings.SplitN(fields[2], ":", 2)
+			switch {
+			case fields[1] == "bearer":
+				uc.Token = fields[2]
+			case fields[1] == "basic" && len(credParts) == 2:
+				uc.Username, uc.Password = credParts[0], credParts[1]
+			case fields[1] == "header" && len(credParts) == 2:
+				uc.Header = http.Header{}
+				uc.Header.Set(
+					strings.TrimSpace(credParts[0]),
+					strings.TrimSpace(credParts[1]),
+				)
+			default:
+				log.Fatalf("invalid upstream credential for %q", uc.Prefix)
+			}
+
+			upstreamCredentials = append(upstreamCredentials, uc)
+		}
+	}
+
+	var layout goproxy.DirCacherLayout
+	switch *cacherDirLayout {
+	case "flat":
+		layout = goproxy.DirCacherLayoutFlat
+	case "sharded":
+		layout = goproxy.DirCacherLayoutSharded
+	default:
+		log.Fatalf("invalid cacher dir layout: %s", *cacherDirLayout)
+	}
+
+	var gonePolicy goproxy.UpstreamGonePolicy
+	switch *upstreamGonePolicy {
+	case "ignore":
+		gonePolicy = goproxy.UpstreamGoneIgnore
+	case "flag":
+		gonePolicy = goproxy.UpstreamGoneFlag
+	case "evict":
+		gonePolicy = goproxy.UpstreamGoneEvict
+	default:
+		log.Fatalf("invalid upstream gone policy: %s", *upstreamGonePolicy)
+	}
+
+	// The caches of each tenant are stored under the name of the tenant
+	// within the prefix of object storages, just like within the
+	// -cacher-dir.
+	tenantPrefix := func(prefix, dir string) string {
+		rel, err := filepath.Rel(*cacherDir, dir)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if rel != "." {
+			prefix = path.Join(prefix, filepath.ToSlash(rel)) + "/"
+		}
+
+		return prefix
+	}
+
+	wrapCacher := func(cacher goproxy.Cacher, dir string) goproxy.Cacher {
+		if *cacherDedupeZips {
+			cacher = &goproxy.ContentAddressedCacher{Cacher: cacher}
+		}
+
+		if *cacherEncKeyFile != "" {
+			cacher = &goproxy.EncryptedCacher{
+				Cacher: cacher,
+				Key:    goproxy.FileEncryptionKey(*cacherEncKeyFile),
+			}
+		} else if os.Getenv("CACHER_ENCRYPTION_KEY") != "" {
+			cacher = &goproxy.EncryptedCacher{
+				Cacher: cacher,
+				Key: goproxy.EnvEncryptionKey(
+					"CACHER_ENCRYPTION_KEY",
+				),
+			}
+		}
+
+		if *cacherCompressText {
+			cacher = &goproxy.CompressedCacher{Cacher: cacher}
+		}
+
+		if *cacherRedisAddr != "" {
+			rc := &goproxy.RedisCacher{
+				Addr:     *cacherRedisAddr,
+				Username: os.Getenv("REDIS_USERNAME"),
+				Password: os.Getenv("REDIS_PASSWORD"),
+				DB:       *cacherRedisDB,
+				Prefix:   tenantPrefix(*cacherRedisPrefix, dir),
+			}
+			if *cacherRedisTLS {
+				rc.TLSConfig = &tls.Config{}
+			}
+
+			if !*cacherRedisZips {
+				rc.ZipCacher = cacher
+			}
+
+			cacher = rc
+		}
+
+		if *cacherMaxBytes != 0 {
+			cacher = &goproxy.RetentionCacher{
+				Cacher:   cacher,
+				MaxBytes: *cacherMaxBytes,
+			}
+		}
+
+		if *cacherQuotaBytes != 0 {
+			qc := &goproxy.QuotaCacher{
+				Cacher:             cacher,
+				HighWatermarkBytes: *cacherQuotaBytes,
+			}
+			if *cacherQuotaIndex {
+				qc.AccessIndex = &goproxy.AccessIndex{
+					Path: filepath.Join(dir, ".quota-access-index"),
+				}
+				go qc.AccessIndex.Run(
+					context.Background(),
+					func(err error) {
+						log.Printf("access index: %v", err)
+					},
+				)
+			}
+
+			cacher = qc
+		}
+
+		return cacher
+	}
+
+	newS3Cacher := func(dir string) goproxy.Cacher {
+		return &goproxy.S3Cacher{
+			Endpoint:        *cacherS3Endpoint,
+			Region:          *cacherS3Region,
+			Bucket:          *cacherS3Bucket,
+			Prefix:          tenantPrefix(*cacherS3Prefix, dir),
+			ForcePathStyle:  *cacherS3PathStyle,
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}
+	}
+
+	newAzureBlobCacher := func(dir string) goproxy.Cacher {
+		return &goproxy.AzureBlobCacher{
+			AccountURL:              *cacherAzureAccount,
+			Container:               *cacherAzContainer,
+			Prefix:                  tenantPrefix(*cacherAzurePrefix, dir),
+			SASToken:                os.Getenv("AZURE_STORAGE_SAS_TOKEN"),
+			ManagedIdentity:         *cacherAzureMSI,
+			ManagedIdentityClientID: *cacherAzureMSIID,
+		}
+	}
+
+	newCassandraCacher := func(dir string) goproxy.Cacher {
+		cc := &goproxy.CassandraCacher{
+			Addr:        *cacherCassandraAddr,
+			Username:    os.Getenv("CASSANDRA_USERNAME"),
+			Password:    os.Getenv("CASSANDRA_PASSWORD"),
+			Keyspace:    *cacherCasKeyspace,
+			Table:       *cacherCasTable,
+			Prefix:      tenantPrefix(*cacherCasPrefix, dir),
+			Consistency: *cacherCasConsist,
+			ChunkBytes:  *cacherCasChunkBytes,
+		}
+		if *cacherCasTLS {
+			cc.TLSConfig = &tls.Config{}
+		}
+
+		return cc
+	}
+
+	// The blobs of the -cacher-dir-dedupe are shared by all tenants, so
+	// that identical caches of different tenants are stored only once.
+	dedupBlobDir := filepath.Join(*cacherDir, ".blobs")
+
+	newCacher := func(cacherDir string) goproxy.Cacher {
+		if cacherDir == "" {
+			return nil
+		}
+
+		if *cacherS3Bucket != "" {
+			return wrapCacher(newS3Cacher(cacherDir), cacherDir)
+		}
+
+		if *cacherAzureAccount != "" {
+			return wrapCacher(
+				newAzureBlobCacher(cacherDir),
+				cacherDir,
+			)
+		}
+
+		if *cacherCassandraAddr != "" {
+			return wrapCacher(
+				newCassandraCacher(cacherDir),
+				cacherDir,
+			)
+		}
+
+		if *cacherMemoryBytes != 0 {
+			return wrapCacher(&goproxy.MemoryCacher{
+				MaxBytes: *cacherMemoryBytes,
+			}, cacherDir)
+		}
+
+		current, err := goproxy.ReadDirCacherLayout(cacherDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if current != 0 && current != layout && !*cacherDirMigrate {
+			log.Fatalf(
+				"cacher dir %s has %s layout, want %s "+
+					"(use -cacher-dir-migrate to migrate it)",
+				cacherDir,
+				current,
+				layout,
 			)
-			g.ServeHTTP(rw, req.WithContext(ctx))
-			cancel()
+		}
+
+		if err := goproxy.MigrateDirCacher(cacherDir, layout); err != nil {
+			log.Fatal(err)
+		}
+
+		var cacher goproxy.Cacher = goproxy.DirCacher(cacherDir)
+		if layout == goproxy.DirCacherLayoutSharded {
+			cacher = goproxy.ShardedDirCacher(cacherDir)
+		}
+
+		if *cacherDirDedupe {
+			cacher = goproxy.DedupDirCacher{
+				Dir:     cacherDir,
+				Layout:  layout,
+				BlobDir: dedupBlobDir,
+			}
+		}
+
+		if *cacherBolt {
+			cacher = &goproxy.BoltCacher{
+				Path: filepath.Join(
+					cacherDir,
+					".bolt",
+					"caches.db",
+				),
+				ZipCacher: cacher,
+			}
+		}
+
+		return wrapCacher(cacher, cacherDir)
+	}
+
+	var onArtifactDownload func(ad *goproxy.ArtifactDownload)
+	if *artifactAuditLog != "" {
+		f, err := os.OpenFile(
+			*artifactAuditLog,
+			os.O_WRONLY|os.O_CREATE|os.O_APPEND,
+			0640,
+		)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		onArtifactDownload = goproxy.JSONArtifactDownloadLogger(f)
+	}
+
+	var metrics goproxy.MetricsSink
+	if *metricsPrometheus {
+		if *metricsOTLPEndpoint != "" {
+			log.Fatal("cannot use both -metrics-prometheus and " +
+				"-metrics-otlp-endpoint")
+		}
+
+		metrics = &goproxy.PrometheusMetrics{}
+	} else if *metricsOTLPEndpoint != "" {
+		om := &goproxy.OTLPMetrics{
+			Endpoint: *metricsOTLPEndpoint,
+			Interval: *metricsOTLPInterval,
+		}
+		go om.Run(context.Background(), func(err error) {
+			log.Printf("otlp metrics: %v", err)
 		})
+		metrics = om
 	}
 
-	var err error
-	if *tlsCertFile != "" && *tlsKeyFile != "" {
-		err = server.ListenAndServeTLS(*tlsCertFile, *tlsKeyFile)
-	} else {
-		err = server.ListenAndServe()
+	var vanityImportList []goproxy.VanityImport
+	if *vanityImports != "" {
+		for _, vi := range strings.Split(*vanityImports, ",") {
+			fields := strings.Fields(vi)
+			if len(fields) != 3 {
+				log.Fatalf("invalid vanity import %q", vi)
+			}
+
+			vanityImportList = append(vanityImportList, goproxy.VanityImport{
+				Prefix:   fields[0],
+				VCS:      fields[1],
+				RepoRoot: fields[2],
+			})
+		}
 	}
 
-	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+	var pathRewriteList []goproxy.PathRewrite
+	if *pathRewrites != "" {
+		for _, pr := range strings.Split(*pathRewrites, ",") {
+			fields := strings.Fields(pr)
+			if len(fields) != 2 && len(fields) != 3 {
+				log.Fatalf("invalid path rewrite %q", pr)
+			}
+
+			rewrite := goproxy.PathRewrite{
+				From: fields[0],
+				To:   fields[1],
+			}
+			if len(fields) == 3 {
+				until, err := time.Parse(time.RFC3339, fields[2])
+				if err != nil {
+					log.Fatalf("invalid path rewrite %q: %v", pr, err)
+				}
+
+				rewrite.Until = until
+			}
+
+			pathRewriteList = append(pathRewriteList, rewrite)
+		}
+	}
+
+	newGoproxy := func(cacherDir string, goBinEnv []string) *goproxy.Goproxy {
+		g := &goproxy.Goproxy{
+			GoBinName:           *goBinName,
+			GoBinMaxWorkers:     *goBinMaxWorkers,
+			GoBinEnv:            goBinEnv,
+			Cacher:              newCacher(cacherDir),
+			CacherMaxCacheBytes: *cacherMaxCacheBytes,
+			ProxiedSUMDBs:       strings.Split(*proxiedSUMDBs, ","),
+			Transport:           transport,
+			TempDir:             *tempDir,
+			TrashRetention:      *trashRetention,
+			UpstreamGonePolicy:  gonePolicy,
+			ErrorReferenceIDs:   *errorReferenceIDs,
+		}
+		g.GoBinMaxWorkersPerModule = *goBinMaxModWorkers
+		if *sumdbKeys != "" {
+			g.SUMDBKeys = strings.Split(*sumdbKeys, ",")
+		}
+		g.UserAgent = *userAgent
+		g.UpstreamCredentials = upstreamCredentials
+		g.UpstreamNetrc = *upstreamNetrc
+		g.InstanceID = *instanceID
+		g.MaxOpenCaches = *maxOpenCaches
+		g.ResourceGuardrails = *resourceGuardrails
+		g.ListCacheExpiration = *listExpiry
+		g.LatestCacheExpiration = *latestExpiry
+		g.ImmutableCacheExpiration = *immutableExpiry
+		g.PseudoVersionCacheExpiration = *pseudoVersionExpiry
+		g.NotFoundCacheExpiration = *notFoundExpiry
+		g.StaleIfErrorExpiration = *staleIfErrorExpiry
+		g.StaleWhileRevalidate = *staleWhileReval
+		g.EmergencyStale = *emergencyStale
+		g.EmergencyStaleThreshold = *emergencyStaleAfter
+		g.ProxiedOnly = *proxiedOnly
+		g.NoFetchHeader = *noFetchHeader
+		g.RequestTimeout = *requestTimeout
+		g.ZipRequestTimeout = *zipRequestTimeout
+		g.FetchTimeout = *fetchTimeout
+		g.ZipFetchTimeout = *zipFetchTimeout
+		g.DeterministicZips = *deterministicZips
+		g.NativeDirectFetches = *nativeDirectFetches
+		g.StreamZipDownloads = *streamZips
+		g.CorrectInfoTimes = *correctInfoTimes
+		g.UpstreamRateLimit = *upstreamRateLimit
+		g.UpstreamRateBurst = *upstreamRateBurst
+		g.UpstreamMaxRetries = *upstreamMaxRetries
+		g.UpstreamRetryBackoffBase = *upstreamBackoffBase
+		g.UpstreamRetryBackoffCap = *upstreamBackoffCap
+		g.UpstreamRetryableStatusCodes = retryableStatusCodes
+		g.DoubleFetchModules = *doubleFetchModules
+		g.DoubleFetchProxy = *doubleFetchProxy
+		g.CacherVerifySizes = *cacherVerifySizes
+		g.CacherClockSkew = *cacherClockSkew
+		if *cacherRedisAddr != "" && *cacherRedisLocks {
+			rc := &goproxy.RedisCacher{
+				Addr:     *cacherRedisAddr,
+				Username: os.Getenv("REDIS_USERNAME"),
+				Password: os.Getenv("REDIS_PASSWORD"),
+				DB:       *cacherRedisDB,
+				Prefix:   tenantPrefix(*cacherRedisPrefix, cacherDir),
+			}
+			if *cacherRedisTLS {
+				rc.TLSConfig = &tls.Config{}
+			}
+
+			g.FetchLocker = rc
+		}
+		g.UpstreamCacheHeaders = *upstreamCacheHdrs
+		g.MergeLists = *mergeLists
+		g.DebugModules = *debugModules
+		g.BuildIDHeader = *buildIDHeader
+		g.OnArtifactDownload = onArtifactDownload
+		g.Metrics = metrics
+		if *readAheadExts != "" {
+			g.ReadAheadExts = strings.Split(*readAheadExts, ",")
+		}
+		g.PrefetchMaxAttempts = *prefetchMaxAttempts
+		g.HotCacheMaxBytes = *hotCacheMaxBytes
+		if *peers != "" {
+			g.Peers = strings.Split(*peers, ",")
+		}
+		g.GoBinVCS = *goBinVCS
+		if *goBinAllowedVCS != "" {
+			g.GoBinAllowedVCS = strings.Split(*goBinAllowedVCS, ",")
+		}
+		if *pinnedModules != "" {
+			g.PinnedModules = strings.Split(*pinnedModules, ",")
+		}
+		cacher := g.Cacher
+		if qc, ok := cacher.(*goproxy.QuotaCacher); ok {
+			qc.Pinned = g.Pinned
+			cacher = qc.Cacher
+		}
+		if rc, ok := cacher.(*goproxy.RetentionCacher); ok {
+			rc.Pinned = g.Pinned
+		}
+		if *freshnessModules != "" {
+			g.FreshnessModules = strings.Split(*freshnessModules, ",")
+		}
+		if *blockedModuleHosts != "" {
+			g.BlockedModuleHosts = strings.Split(*blockedModuleHosts, ",")
+		}
+		g.BlockedModuleIPNets = blockedIPNets
+		if *goBinSandboxUID != 0 ||
+			*goBinSandboxGID != 0 ||
+			*goBinSandboxCgroup != "" ||
+			*goBinSandboxPrefix != "" {
+			g.GoBinSandbox = &goproxy.GoBinSandbox{
+				UID:           *goBinSandboxUID,
+				GID:           *goBinSandboxGID,
+				CgroupDir:     *goBinSandboxCgroup,
+				CommandPrefix: strings.Fields(*goBinSandboxPrefix),
+			}
+		}
+		if *clientQuotaMaxBytes != 0 {
+			g.ClientQuota = &goproxy.ClientQuota{
+				MaxBytes: *clientQuotaMaxBytes,
+				Window:   *clientQuotaWindow,
+			}
+		}
+		g.VanityImports = vanityImportList
+		g.PathRewrites = pathRewriteList
+		g.PrivateModules = *privateModules
+		if *privateModulesToken != "" {
+			g.PrivateModulesAuthorizer = func(req *http.Request) bool {
+				auth := req.Header.Get("Authorization")
+				token := strings.TrimPrefix(auth, "Bearer ")
+				if token == auth {
+					_, token, _ = req.BasicAuth()
+				}
+
+				return subtle.ConstantTimeCompare(
+					[]byte(token),
+					[]byte(*privateModulesToken),
+				) == 1
+			}
+		}
+
+		if *adminToken != "" {
+			g.AdminAuthorizer = func(req *http.Request) bool {
+				return subtle.ConstantTimeCompare(
+					[]byte(req.Header.Get("Authorization")),
+					[]byte("Bearer "+*adminToken),
+				) == 1
+			}
+		}
+
+		return g
+	}
+
+	// With tenants, the cacher dir is shared by their caches, so it must
+	// not be used (and possibly migrated) as a cache of its own.
+	mainCacherDir := *cacherDir
+	if *tenantsFile != "" {
+		mainCacherDir = ""
+	}
+
+	g := newGoproxy(mainCacherDir, nil)
+	g.PathPrefix = *pathPrefix
+	if *backfillDir != "" {
+		if *tenantsFile != "" {
+			log.Fatal("cannot backfill with -tenants-file")
+		}
+
+		var format goproxy.BackfillFormat
+		switch *backfillFormat {
+		case "modcache":
+			format = goproxy.BackfillFormatModuleCache
+		case "athens":
+			format = goproxy.BackfillFormatAthens
+		default:
+			log.Fatalf("invalid backfill format: %s", *backfillFormat)
+		}
+
+		r, err := g.Backfill(context.Background(), format, *backfillDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		log.Printf(
+			"backfilled %d module files (%d bytes), "+
+				"skipped %d and failed %d",
+			r.Imported,
+			r.ImportedBytes,
+			r.Skipped,
+			r.Failed,
+		)
+		return
+	}
+
+	var handler http.Handler = g
+	goproxies := []*goproxy.Goproxy{g}
+	if *tenantsFile != "" {
+		tr, err := loadTenantRouter(*tenantsFile, newGoproxy)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		handler = tr
+		goproxies = goproxies[:0]
+		for _, t := range tr.Tenants {
+			goproxies = append(goproxies, t.Goproxy)
+		}
+	}
+
+	if *shards != "" {
+		if *tenantsFile != "" {
+			log.Fatal("cannot shard with -tenants-file")
+		}
+
+		handler = &goproxy.ShardRouter{
+			Goproxy: g,
+			Shards:  strings.Split(*shards, ","),
+			Self:    *shardSelf,
+		}
+	}
+
+	if *prefetchMaxAttempts > 0 {
+		for _, g := range goproxies {
+			err := g.ResumePrefetches(context.Background())
+			if err != nil {
+				log.Printf("failed to resume prefetches: %v", err)
+			}
+		}
+	}
+
+	if *consistencyInterval != 0 {
+		for _, g := range goproxies {
+			go (&goproxy.ConsistencyChecker{
+				Goproxy:  g,
+				Upstream: *consistencyUpstream,
+				Interval: *consistencyInterval,
+			}).Run(context.Background())
+		}
+	}
+
+	if *zipRecompInterval != 0 {
+		for _, g := range goproxies {
+			go (&goproxy.ZipRecompressor{
+				Goproxy:  g,
+				Level:    *zipRecompLevel,
+				Interval: *zipRecompInterval,
+			}).Run(context.Background())
+		}
+	}
+
+	if *warmInterval != 0 {
+		for _, g := range goproxies {
+			go (&goproxy.Warmer{
+				Goproxy:   g,
+				Interval:  *warmInterval,
+				Retention: *warmRetention,
+			}).Run(context.Background())
+		}
+	}
+
+	if *statsSaveInterval != 0 {
+		for _, g := range goproxies {
+			go (&goproxy.StatsPersister{
+				Goproxy:  g,
+				Interval: *statsSaveInterval,
+			}).Run(context.Background())
+		}
+	}
+
+	if *preflight {
+		for _, g := range goproxies {
+			if err := g.Preflight(
+				context.Background(),
+			).Err(); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	if *startupScan {
+		for _, g := range goproxies {
+			go func(g *goproxy.Goproxy) {
+				p, err := (&goproxy.CacheScanner{
+					Goproxy:     g,
+					MaxDuration: *startupScanDuration,
+					Parallelism: *startupScanWorkers,
+					OnProgress: func(p goproxy.CacheScanProgress) {
+						log.Printf(
+							"scanned %d caches (%d bytes) "+
+								"in %s, removed %d invalid",
+							p.Scanned,
+							p.ScannedBytes,
+							p.Elapsed,
+							p.Invalid,
+						)
+					},
+				}).Scan(context.Background())
+				if err != nil {
+					log.Printf("failed to scan caches: %v", err)
+				} else if !p.Complete {
+					log.Print("cache scan stopped incomplete")
+				}
+			}(g)
+		}
+	}
+
+	if *cleanupInterval != 0 {
+		go func() {
+			for range time.Tick(*cleanupInterval) {
+				for _, g := range goproxies {
+					if err := g.Cleanup(); err != nil {
+						log.Printf("failed to clean up caches: %v", err)
+					}
+				}
+			}
+		}()
+	}
+
+	var trustedIPNets []*net.IPNet
+	if *proxyProtocolNets != "" {
+		for _, s := range strings.Split(*proxyProtocolNets, ",") {
+			_, ipNet, err := net.ParseCIDR(strings.TrimSpace(s))
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			trustedIPNets = append(trustedIPNets, ipNet)
+		}
+	}
+
+	listeners, err := listen(*address)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	server := &http.Server{}
+	certFile, keyFile := *tlsCertFile, *tlsKeyFile
+	if certFile != "" && keyFile != "" {
+		server.TLSConfig, err = newTLSConfig(
+			*tlsMinVersion,
+			*tlsCipherSuites,
+		)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if *tlsOCSPFile != "" {
+			sc, err := newStapledCertificate(
+				certFile,
+				keyFile,
+				*tlsOCSPFile,
+			)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			server.TLSConfig.GetCertificate = sc.GetCertificate
+			certFile, keyFile = "", ""
+		}
+	}
+
+	server.Handler = handler
+
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		if *proxyProtocol {
+			l = &proxyProtocolListener{
+				Listener:      l,
+				trustedIPNets: trustedIPNets,
+			}
+		}
+
+		go func(l net.Listener) {
+			if server.TLSConfig != nil {
+				errs <- server.ServeTLS(l, certFile, keyFile)
+			} else {
+				errs <- server.Serve(l)
+			}
+		}(l)
+	}
+
+	if err := <-errs; err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Printf("http server error: %v\n", err)
 		return
 	}
 }
+
+// tenantConfig is the declarative configuration of a tenant.
+type tenantConfig struct {
+	Name           string
+	Host           string
+	PathPrefix     string
+	GoBinEnv       []string
+	AllowedModules string
+	Tokens         []string
+}
+
+// loadTenantRouter loads a tenant router from the JSON file targeted by the
+// name, which contains a list of tenant configurations. Each tenant has its
+// own Goproxy created by the newGoproxy, caching into a subdirectory of the
+// cacher directory named after the tenant.
+func loadTenantRouter(
+	name string,
+	newGoproxy func(cacherDir string, goBinEnv []string) *goproxy.Goproxy,
+) (*goproxy.TenantRouter, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var tcs []tenantConfig
+	if err := json.Unmarshal(b, &tcs); err != nil {
+		return nil, fmt.Errorf("invalid tenants file: %w", err)
+	}
+
+	tr := &goproxy.TenantRouter{}
+	for _, tc := range tcs {
+		if tc.Name == "" ||
+			tc.Name != filepath.Base(tc.Name) ||
+			tc.Name == "." ||
+			tc.Name == ".." {
+			return nil, fmt.Errorf("invalid tenant name %q", tc.Name)
+		}
+
+		t := &goproxy.Tenant{
+			Name:       tc.Name,
+			Host:       tc.Host,
+			PathPrefix: tc.PathPrefix,
+			Goproxy: newGoproxy(
+				filepath.Join(*cacherDir, tc.Name),
+				append(os.Environ(), tc.GoBinEnv...),
+			),
+			AllowedModules: tc.AllowedModules,
+		}
+		if tokens := tc.Tokens; len(tokens) > 0 {
+			t.Authorizer = func(req *http.Request) bool {
+				for _, token := range tokens {
+					if subtle.ConstantTimeCompare(
+						[]byte(req.Header.Get("Authorization")),
+						[]byte("Bearer "+token),
+					) == 1 {
+						return true
+					}
+				}
+
+				return false
+			}
+		}
+
+		tr.Tenants = append(tr.Tenants, t)
+	}
+
+	return tr, nil
+}
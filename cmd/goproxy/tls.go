@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tlsVersions are the TLS versions allowed to be the minimum ones, keyed by
+// their names.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// newTLSConfig returns a new instance of the [tls.Config] with the minVersion
+// (e.g. "1.2") and the comma-separated cipherSuites (e.g.
+// "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"). Empty values leave the Go
+// defaults in effect. Note that the cipher suites of TLS 1.3 are not
+// configurable.
+func newTLSConfig(minVersion, cipherSuites string) (*tls.Config, error) {
+	config := &tls.Config{}
+	if minVersion != "" {
+		v, ok := tlsVersions[minVersion]
+		if !ok {
+			return nil, fmt.Errorf(
+				"invalid TLS version: %s",
+				minVersion,
+			)
+		}
+
+		config.MinVersion = v
+	}
+
+	if cipherSuites != "" {
+		ids := map[string]uint16{}
+		for _, cs := range append(
+			tls.CipherSuites(),
+			tls.InsecureCipherSuites()...,
+		) {
+			ids[cs.Name] = cs.ID
+		}
+
+		for _, name := range strings.Split(cipherSuites, ",") {
+			name = strings.TrimSpace(name)
+			id, ok := ids[name]
+			if !ok {
+				return nil, fmt.Errorf(
+					"invalid cipher suite: %s",
+					name,
+				)
+			}
+
+			config.CipherSuites = append(config.CipherSuites, id)
+		}
+	}
+
+	return config, nil
+}
+
+// stapledCertificate is a TLS certificate served with an OCSP response stapled
+// to it. The OCSP response is read from a file that is expected to be renewed
+// periodically by an external tool, and is reloaded (along with the
+// certificate) once changed.
+type stapledCertificate struct {
+	certFile string
+	keyFile  string
+	ocspFile string
+
+	mutex       sync.Mutex
+	cert        *tls.Certificate
+	ocspModTime time.Time
+	checkTime   time.Time
+}
+
+// newStapledCertificate returns a new instance of the [stapledCertificate].
+func newStapledCertificate(
+	certFile string,
+	keyFile string,
+	ocspFile string,
+) (*stapledCertificate, error) {
+	sc := &stapledCertificate{
+		certFile: certFile,
+		keyFile:  keyFile,
+		ocspFile: ocspFile,
+	}
+	if _, err := sc.GetCertificate(nil); err != nil {
+		return nil, err
+	}
+
+	return sc, nil
+}
+
+// GetCertificate is for the [tls.Config.GetCertificate]. The OCSP response
+// file is checked for changes at most once a minute.
+func (sc *stapledCertificate) GetCertificate(
+	*tls.ClientHelloInfo,
+) (*tls.Certificate, error) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	if sc.cert != nil && time.Since(sc.checkTime) < time.Minute {
+		return sc.cert, nil
+	}
+
+	sc.checkTime = time.Now()
+
+	fi, err := os.Stat(sc.ocspFile)
+	if err != nil {
+		if sc.cert != nil {
+			return sc.cert, nil // Keep the last OCSP response
+		}
+
+		return nil, err
+	} else if sc.cert != nil && fi.ModTime().Equal(sc.ocspModTime) {
+		return sc.cert, nil
+	}
+
+	ocspResponse, err := ioutil.ReadFile(sc.ocspFile)
+	if err != nil {
+		if sc.cert != nil {
+			return sc.cert, nil
+		}
+
+		return nil, err
+	}
+
+	cert, err := tls.LoadX509KeyPair(sc.certFile, sc.keyFile)
+	if err != nil {
+		if sc.cert != nil {
+			return sc.cert, nil
+		}
+
+		return nil, err
+	}
+
+	cert.OCSPStaple = ocspResponse
+	sc.cert = &cert
+	sc.ocspModTime = fi.ModTime()
+	return sc.cert, nil
+}
@@ -0,0 +1,276 @@
+package goproxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// CompressedCacher implements the [Cacher] by wrapping another [Cacher] and
+// compressing the contents of its text caches (the ".info" and ".mod" files,
+// the version lists and the resolved versions) with gzip, since large go.mod
+// files often compress by an order of magnitude. Other caches, notably the
+// ".zip" files, which are already compressed, are passed through as they are.
+//
+// Each compressed content starts with a small header recording how it has been
+// compressed, so that the text caches that were not put via the
+// CompressedCacher (e.g. the ones put before the compression was enabled) are
+// still got as they are. Text caches are got entirely in memory.
+//
+// Note that the sizes of the caches enumerated via the [CompressedCacher.List]
+// are the compressed ones.
+//
+// Make sure that all fields of the CompressedCacher have been finalized before
+// calling any of its methods.
+type CompressedCacher struct {
+	// Cacher is the wrapped [Cacher].
+	Cacher Cacher
+
+	// Level is the gzip compression level (see the [compress/gzip]).
+	//
+	// If the Level is zero, the [gzip.DefaultCompression] is used.
+	Level int
+
+	// MinBytes is the minimum number of bytes of a text cache to be
+	// compressed. Smaller ones are stored as they are, since they hardly
+	// shrink.
+	//
+	// If the MinBytes is zero, 512 is used.
+	MinBytes int
+}
+
+const (
+	// compressedMagic is the magic number of the contents compressed by
+	// the [CompressedCacher]. It is followed by a byte identifying the
+	// compression algorithm.
+	compressedMagic = "GPC1"
+
+	// compressedGzip identifies the gzip compression algorithm in the
+	// header of the contents compressed by the [CompressedCacher].
+	compressedGzip byte = 1
+)
+
+// isTextCacheName reports whether the name targets a cache that the
+// [CompressedCacher] compresses.
+func isTextCacheName(name string) bool {
+	return strings.HasSuffix(name, ".info") ||
+		strings.HasSuffix(name, ".mod") ||
+		strings.HasSuffix(name, "/@v/list") ||
+		strings.HasSuffix(name, "/@latest")
+}
+
+// Get implements the [Cacher].
+func (cc *CompressedCacher) Get(
+	ctx context.Context,
+	name string,
+) (io.ReadCloser, error) {
+	content, err := cc.Cacher.Get(ctx, name)
+	if err != nil || !isTextCacheName(name) {
+		return content, err
+	}
+	defer content.Close()
+
+	b, err := ioutil.ReadAll(content)
+	if err != nil {
+		return nil, err
+	}
+
+	if b, err = decompressContent(b); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	dc := &decompressedContent{Reader: bytes.NewReader(b)}
+	if lm, ok := content.(interface{ LastModified() time.Time }); ok {
+		dc.lastModified = lm.LastModified()
+	} else if mt, ok := content.(interface{ ModTime() time.Time }); ok {
+		dc.lastModified = mt.ModTime()
+	}
+
+	if et, ok := content.(interface{ ETag() string }); ok {
+		dc.etag = et.ETag()
+	}
+
+	if ec, ok := content.(interface{ Expires() time.Time }); ok {
+		return &expiringDecompressedContent{
+			decompressedContent: dc,
+			expires:             ec.Expires(),
+		}, nil
+	}
+
+	return dc, nil
+}
+
+// decompressContent returns the decompressed b if the b has been compressed by
+// the [CompressedCacher], or the b as it is otherwise.
+func decompressContent(b []byte) ([]byte, error) {
+	if len(b) <= len(compressedMagic) ||
+		string(b[:len(compressedMagic)]) != compressedMagic {
+		return b, nil
+	}
+
+	switch algorithm := b[len(compressedMagic)]; algorithm {
+	case compressedGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(
+			b[len(compressedMagic)+1:],
+		))
+		if err != nil {
+			return nil, err
+		}
+
+		return ioutil.ReadAll(zr)
+	default:
+		return nil, fmt.Errorf(
+			"unknown compression algorithm %d",
+			algorithm,
+		)
+	}
+}
+
+// Put implements the [Cacher].
+func (cc *CompressedCacher) Put(
+	ctx context.Context,
+	name string,
+	content io.ReadSeeker,
+	expiration time.Duration,
+) error {
+	if !isTextCacheName(name) {
+		return cc.Cacher.Put(ctx, name, content, expiration)
+	}
+
+	b, err := ioutil.ReadAll(content)
+	if err != nil {
+		return err
+	}
+
+	minBytes := cc.MinBytes
+	if minBytes == 0 {
+		minBytes = 512
+	}
+
+	if len(b) >= minBytes {
+		compressed, err := cc.compress(b)
+		if err != nil {
+			return err
+		}
+
+		if len(compressed) < len(b) {
+			b = compressed
+		}
+	}
+
+	return cc.Cacher.Put(ctx, name, bytes.NewReader(b), expiration)
+}
+
+// compress returns the b compressed with the header.
+func (cc *CompressedCacher) compress(b []byte) ([]byte, error) {
+	level := cc.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(compressedMagic)
+	buf.WriteByte(compressedGzip)
+
+	zw, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := zw.Write(b); err != nil {
+		return nil, err
+	} else if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Delete implements the [Deleter].
+func (cc *CompressedCacher) Delete(ctx context.Context, name string) error {
+	d, ok := cc.Cacher.(Deleter)
+	if !ok {
+		return errDeleteNotSupported
+	}
+
+	return d.Delete(ctx, name)
+}
+
+// Cleanup implements the [Cacher].
+func (cc *CompressedCacher) Cleanup() error {
+	return cc.Cacher.Cleanup()
+}
+
+// CleanupReclaimed implements the [Reclaimer].
+func (cc *CompressedCacher) CleanupReclaimed(
+	reclaimed func(name string, size int64),
+) error {
+	r, ok := cc.Cacher.(Reclaimer)
+	if !ok {
+		return cc.Cacher.Cleanup()
+	}
+
+	return r.CleanupReclaimed(reclaimed)
+}
+
+// List implements the [Lister].
+func (cc *CompressedCacher) List(
+	ctx context.Context,
+	prefix string,
+) CacheIterator {
+	return listCaches(ctx, cc.walkCaches, prefix)
+}
+
+// walkCaches implements the [cacheWalker].
+func (cc *CompressedCacher) walkCaches(
+	fn func(name string, size int64) error,
+) error {
+	cw, ok := cacheWalkerOf(cc.Cacher)
+	if !ok {
+		return errWalkNotSupported
+	}
+
+	return cw.walkCaches(fn)
+}
+
+// decompressedContent is a text cache got via a [CompressedCacher].
+type decompressedContent struct {
+	*bytes.Reader
+
+	lastModified time.Time
+	etag         string
+}
+
+// LastModified returns the last modification time of the cache, if known.
+func (dc *decompressedContent) LastModified() time.Time {
+	return dc.lastModified
+}
+
+// ETag returns the ETag of the cache, if known. Since the compression is
+// deterministic, it changes whenever the decompressed content does.
+func (dc *decompressedContent) ETag() string {
+	return dc.etag
+}
+
+// Close implements the [io.Closer].
+func (dc *decompressedContent) Close() error {
+	return nil
+}
+
+// expiringDecompressedContent is a [decompressedContent] whose expiration time
+// is known.
+type expiringDecompressedContent struct {
+	*decompressedContent
+
+	expires time.Time
+}
+
+// Expires returns the expiration time of the cache.
+func (edc *expiringDecompressedContent) Expires() time.Time {
+	return edc.expires
+}
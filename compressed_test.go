@@ -0,0 +1,167 @@
+package goproxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsTextCacheName(t *testing.T) {
+	for n, tt := range []struct {
+		name string
+		want bool
+	}{
+		{"example.com/@v/v1.0.0.info", true},
+		{"example.com/@v/v1.0.0.mod", true},
+		{"example.com/@v/list", true},
+		{"example.com/@latest", true},
+		{"example.com/@v/master.info", true},
+		{"-/stale/example.com/@v/list", true},
+		{"example.com/@v/v1.0.0.zip", false},
+		{"sumdb/sum.golang.org/supported", false},
+	} {
+		if got, want := isTextCacheName(tt.name), tt.want; got != want {
+			t.Errorf("test(%d): got %t, want %t", n, got, want)
+		}
+	}
+}
+
+func TestCompressedCacher(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestCompressedCacher")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	dc := DirCacher(tempDir)
+	cc := &CompressedCacher{Cacher: dc}
+
+	goMod := "module example.com\n\n" + strings.Repeat(
+		"require example.com/foo v1.0.0\n",
+		1000,
+	)
+	for n, tt := range []struct {
+		name           string
+		content        string
+		wantCompressed bool
+	}{
+		{"example.com/@v/v1.0.0.mod", goMod, true},
+		{"example.com/@v/v1.0.0.info", `{"Version":"v1.0.0"}`, false},
+		{"example.com/@v/v1.0.0.zip", goMod, false},
+		{"example.com/@v/list", "", false},
+	} {
+		if err := cc.Put(
+			context.Background(),
+			tt.name,
+			strings.NewReader(tt.content),
+			time.Hour,
+		); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", n, err)
+		}
+
+		raw, err := ioutil.ReadFile(filepath.Join(
+			tempDir,
+			filepath.FromSlash(tt.name),
+		))
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", n, err)
+		}
+
+		if tt.wantCompressed {
+			if !bytes.HasPrefix(raw, []byte(compressedMagic)) {
+				t.Errorf("test(%d): expected compressed", n)
+			} else if len(raw) >= len(tt.content)/10 {
+				t.Errorf("test(%d): got %d bytes, want < %d",
+					n, len(raw), len(tt.content)/10)
+			}
+		} else if got, want := string(raw), tt.content; got != want {
+			t.Errorf("test(%d): got %q, want %q", n, got, want)
+		}
+
+		content, err := cc.Get(context.Background(), tt.name)
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", n, err)
+		}
+
+		if _, ok := content.(io.Seeker); !ok {
+			t.Errorf("test(%d): expected io.Seeker", n)
+		} else if _, ok := content.(interface {
+			Expires() time.Time
+		}); !ok {
+			t.Errorf("test(%d): expected Expires", n)
+		} else if lm, ok := content.(interface {
+			LastModified() time.Time
+		}); ok && lm.LastModified().IsZero() {
+			t.Errorf("test(%d): unexpected zero LastModified", n)
+		}
+
+		b, err := ioutil.ReadAll(content)
+		content.Close()
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", n, err)
+		} else if got, want := string(b), tt.content; got != want {
+			t.Errorf("test(%d): got %d bytes, want %d bytes",
+				n, len(got), len(want))
+		}
+	}
+
+	// Text caches put before the compression was enabled are got as they
+	// are.
+	if err := dc.Put(
+		context.Background(),
+		"example.com/@latest",
+		strings.NewReader(`{"Version":"v1.0.0"}`),
+		time.Hour,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	content, err := cc.Get(context.Background(), "example.com/@latest")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	b, err := ioutil.ReadAll(content)
+	content.Close()
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := string(b), `{"Version":"v1.0.0"}`; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if err := dc.Put(
+		context.Background(),
+		"example.com/@v/v1.1.0.mod",
+		strings.NewReader(compressedMagic+"\xffmodule example.com"),
+		time.Hour,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if _, err := cc.Get(
+		context.Background(),
+		"example.com/@v/v1.1.0.mod",
+	); err == nil {
+		t.Fatal("expected error")
+	}
+
+	var names []string
+	it := cc.List(context.Background(), "example.com/@v/v1.0.0")
+	for it.Next() {
+		names = append(names, it.Cache().Name)
+	}
+
+	if err := it.Close(); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := strings.Join(names, " "),
+		"example.com/@v/v1.0.0.info example.com/@v/v1.0.0.mod "+
+			"example.com/@v/v1.0.0.zip"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
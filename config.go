@@ -0,0 +1,90 @@
+package goproxy
+
+import (
+	"net/http"
+	"sort"
+
+	modzip "golang.org/x/mod/zip"
+)
+
+// proxyConfig describes the capabilities of a [Goproxy] to clients, so that
+// client-side tooling and other module proxies can adapt to it automatically.
+// It is sanitized: it never includes upstream URLs, module patterns, or
+// anything else about how the Goproxy is set up behind the scenes.
+type proxyConfig struct {
+	// Endpoints are the patterns of the request paths, relative to the
+	// base URL of the Goproxy, that it serves.
+	Endpoints []string
+
+	// ProxiedSUMDBs are the names of the checksum databases proxied by the
+	// Goproxy.
+	ProxiedSUMDBs []string
+
+	// Offline indicates whether the Goproxy only serves what it has
+	// already cached.
+	Offline bool
+
+	// NoFetchHeader is the name of the request header that lets a client
+	// opt in to being served only from the cache (see the
+	// [Goproxy.NoFetchHeader]).
+	NoFetchHeader string
+
+	// MaxZipBytes is the maximum number of bytes of a module zip file
+	// served by the Goproxy.
+	MaxZipBytes int64
+
+	// MaxCacheBytes is the maximum number of bytes of a module file cached
+	// by the Goproxy. Larger ones are fetched again on every request.
+	MaxCacheBytes int64 `json:",omitempty"`
+
+	// DeterministicZips indicates whether the module zip files served by
+	// the Goproxy are repacked deterministically (see the
+	// [Goproxy.DeterministicZips]).
+	DeterministicZips bool
+}
+
+// config returns the [proxyConfig] of the g.
+func (g *Goproxy) config() *proxyConfig {
+	pc := &proxyConfig{
+		Endpoints: []string{
+			"<module>/@v/list",
+			"<module>/@v/<version>.info",
+			"<module>/@v/<version>.mod",
+			"<module>/@v/<version>.zip",
+			"<module>/@v/<version>.ziphash",
+			"<module>/@latest",
+			"-/config",
+			"-/go-mod",
+			"-/lookup",
+			"-/zip-delta",
+		},
+		ProxiedSUMDBs:     []string{},
+		Offline:           g.goBinEnvGOPROXY == "off",
+		NoFetchHeader:     g.noFetchHeader(),
+		MaxZipBytes:       modzip.MaxZipFile,
+		MaxCacheBytes:     int64(g.settings().CacherMaxCacheBytes),
+		DeterministicZips: g.DeterministicZips,
+	}
+
+	if len(g.proxiedSUMDBs) > 0 {
+		pc.Endpoints = append(
+			pc.Endpoints,
+			"sumdb/<sumdb-name>/supported",
+			"sumdb/<sumdb-name>/lookup/<module>@<version>",
+			"sumdb/<sumdb-name>/tile/<path>",
+		)
+	}
+
+	for name := range g.proxiedSUMDBs {
+		pc.ProxiedSUMDBs = append(pc.ProxiedSUMDBs, name)
+	}
+
+	sort.Strings(pc.ProxiedSUMDBs)
+
+	return pc
+}
+
+// serveConfig serves config requests.
+func (g *Goproxy) serveConfig(rw http.ResponseWriter, req *http.Request) {
+	responseJSON(rw, req, 60, g.config())
+}
@@ -0,0 +1,82 @@
+package goproxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	modzip "golang.org/x/mod/zip"
+)
+
+func TestGoproxyServeConfig(t *testing.T) {
+	g := &Goproxy{
+		GoBinEnv: []string{
+			"GOPROXY=https://secret.example.com",
+			"GOSUMDB=off",
+		},
+		ProxiedSUMDBs:       []string{"sum.golang.org"},
+		CacherMaxCacheBytes: 1 << 20,
+		NoFetchHeader:       "X-No-Fetch",
+	}
+
+	get := func(path string) (*httptest.ResponseRecorder, *proxyConfig) {
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			return rec, nil
+		}
+
+		pc := &proxyConfig{}
+		if err := json.Unmarshal(rec.Body.Bytes(), pc); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		return rec, pc
+	}
+
+	rec, pc := get("/-/config")
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	} else if got, want := rec.Header().Get("Cache-Control"),
+		"public, max-age=60"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	} else if strings.Contains(rec.Body.String(), "secret") {
+		t.Errorf("got %q, want no upstream URLs", rec.Body.String())
+	}
+
+	if got, want := strings.Join(pc.ProxiedSUMDBs, ","),
+		"sum.golang.org"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	} else if pc.Offline {
+		t.Error("unexpected offline")
+	} else if got, want := pc.NoFetchHeader, "X-No-Fetch"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	} else if got, want := pc.MaxZipBytes,
+		int64(modzip.MaxZipFile); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	} else if got, want := pc.MaxCacheBytes, int64(1<<20); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	} else if got, want := pc.Endpoints[len(pc.Endpoints)-1],
+		"sumdb/<sumdb-name>/tile/<path>"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	g = &Goproxy{GoBinEnv: []string{"GOPROXY=off", "GOSUMDB=off"}}
+	if _, pc := get("/-/config"); pc == nil {
+		t.Fatal("expected config")
+	} else if !pc.Offline {
+		t.Error("expected offline")
+	} else if got, want := pc.NoFetchHeader, "GONOFETCH"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	} else if len(pc.ProxiedSUMDBs) != 0 {
+		t.Errorf("got %v, want none", pc.ProxiedSUMDBs)
+	}
+
+	rec = httptest.NewRecorder()
+	g.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/-/config", nil))
+	if got, want := rec.Code, http.StatusMethodNotAllowed; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,311 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/mod/sumdb/dirhash"
+)
+
+// ConsistencyChecker checks the consistency of the module files cached by a
+// [Goproxy] by periodically cross-checking the hashes of randomly sampled
+// cached module files against a second upstream or the checksum database. It
+// is for detecting silent upstream corruption.
+//
+// Only the ".mod" and ".zip" files cached since the Goproxy started are
+// sampled.
+type ConsistencyChecker struct {
+	// Goproxy is the [Goproxy] whose cached module files are checked.
+	Goproxy *Goproxy
+
+	// Upstream is the second upstream module proxy (e.g.
+	// "https://proxy.golang.org") to check against.
+	//
+	// If the Upstream is empty, the checksum database of the
+	// [ConsistencyChecker.Goproxy] is used, in which case the modules that
+	// are not required to be verified (see GONOSUMDB) are skipped.
+	Upstream string
+
+	// SampleSize is the number of cached module files checked per round.
+	//
+	// If the SampleSize is zero, 10 is used.
+	SampleSize int
+
+	// Interval is the interval between two rounds.
+	//
+	// If the Interval is zero, one hour is used.
+	Interval time.Duration
+
+	// OnDivergence is called when a cached module file targeted by the name
+	// diverges from the reference described by the err.
+	//
+	// If the OnDivergence is nil, divergences are logged as errors by the
+	// [ConsistencyChecker.Goproxy].
+	OnDivergence func(name string, err error)
+}
+
+// Run runs the cc periodically until the ctx is done.
+func (cc *ConsistencyChecker) Run(ctx context.Context) error {
+	interval := cc.Interval
+	if interval == 0 {
+		interval = time.Hour
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := cc.Check(ctx); err != nil &&
+			!errors.Is(err, ctx.Err()) {
+			cc.Goproxy.logErrorf("failed to check consistency: %v", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Check runs a single round of the cc. Divergences are reported via the
+// [ConsistencyChecker.OnDivergence] instead of being returned.
+func (cc *ConsistencyChecker) Check(ctx context.Context) error {
+	g := cc.Goproxy
+	g.initOnce.Do(g.init)
+	ctx = withBulk(ctx)
+
+	sampleSize := cc.SampleSize
+	if sampleSize == 0 {
+		sampleSize = 10
+	}
+
+	tempDir, err := ioutil.TempDir(g.TempDir, "goproxy")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	for _, name := range g.cachedNames.sample(sampleSize) {
+		if err := cc.checkName(ctx, name, tempDir); err != nil {
+			if errors.Is(err, errNotFound) {
+				if cc.OnDivergence != nil {
+					cc.OnDivergence(name, err)
+				} else {
+					g.logErrorf(
+						"inconsistent module file: "+
+							"%s: %v",
+						name,
+						err,
+					)
+				}
+
+				continue
+			}
+
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkName checks the cached module file targeted by the name. It returns an
+// error that satisfies errors.Is(err, errNotFound) on divergence.
+func (cc *ConsistencyChecker) checkName(
+	ctx context.Context,
+	name string,
+	tempDir string,
+) error {
+	g := cc.Goproxy
+	f, err := newFetch(g, name, tempDir)
+	if err != nil {
+		return err
+	}
+
+	if cc.Upstream == "" && !f.requiredToVerify {
+		return nil
+	}
+
+	cachedFile, err := ioutil.TempFile(tempDir, "")
+	if err != nil {
+		return err
+	}
+	defer cachedFile.Close()
+
+	content, err := g.cache(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(cachedFile, content)
+	content.Close()
+	if err != nil {
+		return err
+	}
+
+	if err := cachedFile.Close(); err != nil {
+		return err
+	}
+
+	if cc.Upstream == "" {
+		switch f.ops {
+		case fetchOpsDownloadMod:
+			return verifyModFile(
+				g.sumdbClient,
+				cachedFile.Name(),
+				f.modulePath,
+				f.moduleVersion,
+			)
+		case fetchOpsDownloadZip:
+			return verifyZipFile(
+				g.sumdbClient,
+				cachedFile.Name(),
+				f.modulePath,
+				f.moduleVersion,
+			)
+		}
+
+		return nil
+	}
+
+	upstreamURL, err := parseRawURL(cc.Upstream)
+	if err != nil {
+		return err
+	}
+
+	upstreamFile, err := ioutil.TempFile(tempDir, "")
+	if err != nil {
+		return err
+	}
+	defer upstreamFile.Close()
+
+	if err := httpGet(
+		withUpstreamRetry(ctx, g.upstreamRetry),
+		g.httpClient,
+		appendURL(upstreamURL, name).String(),
+		upstreamFile,
+	); err != nil {
+		g.handleUpstreamGone(ctx, f, err)
+		if errors.Is(err, errNotFound) {
+			return notFoundError(fmt.Sprintf(
+				"missing from upstream: %v",
+				err,
+			))
+		}
+
+		return err
+	}
+
+	if err := upstreamFile.Close(); err != nil {
+		return err
+	}
+
+	cachedHash, err := moduleFileHash(cachedFile.Name(), f.ops)
+	if err != nil {
+		return notFoundError(fmt.Sprintf("invalid cached file: %v", err))
+	}
+
+	upstreamHash, err := moduleFileHash(upstreamFile.Name(), f.ops)
+	if err != nil {
+		return notFoundError(fmt.Sprintf(
+			"invalid upstream file: %v",
+			err,
+		))
+	}
+
+	if cachedHash != upstreamHash {
+		return notFoundError(fmt.Sprintf(
+			"hash mismatch: cached %s, upstream %s",
+			cachedHash,
+			upstreamHash,
+		))
+	}
+
+	return nil
+}
+
+// moduleFileHash returns the hash (as used in go.sum files) of the module file
+// targeted by the name for the ops.
+func moduleFileHash(name string, ops fetchOps) (string, error) {
+	if ops == fetchOpsDownloadZip {
+		return dirhash.HashZip(name, dirhash.DefaultHash)
+	}
+
+	return dirhash.DefaultHash(
+		[]string{"go.mod"},
+		func(string) (io.ReadCloser, error) {
+			return os.Open(name)
+		},
+	)
+}
+
+// nameSampler keeps a uniform random sample of a bounded number of names
+// by using reservoir sampling. It is safe for concurrent use.
+type nameSampler struct {
+	mutex sync.Mutex
+	rand  *rand.Rand
+	max   int
+	names []string
+	seen  int64
+}
+
+// newNameSampler returns a new instance of the [nameSampler] that keeps at
+// most max names.
+func newNameSampler(max int) *nameSampler {
+	return &nameSampler{
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+		max:  max,
+	}
+}
+
+// add adds the name to the ns.
+func (ns *nameSampler) add(name string) {
+	ns.mutex.Lock()
+	defer ns.mutex.Unlock()
+	ns.seen++
+	if len(ns.names) < ns.max {
+		ns.names = append(ns.names, name)
+	} else if i := ns.rand.Int63n(ns.seen); i < int64(ns.max) {
+		ns.names[i] = name
+	}
+}
+
+// sample returns at most n distinct names randomly chosen from the ns.
+func (ns *nameSampler) sample(n int) []string {
+	ns.mutex.Lock()
+	defer ns.mutex.Unlock()
+	names := make([]string, 0, n)
+	seen := make(map[string]bool, n)
+	for _, i := range ns.rand.Perm(len(ns.names)) {
+		if len(names) == n {
+			break
+		}
+
+		if name := ns.names[i]; !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// isModuleFileName reports whether the name targets a ".mod" or ".zip" file.
+func isModuleFileName(name string) bool {
+	return !strings.HasPrefix(name, "sumdb/") &&
+		strings.Contains(name, "/@v/") &&
+		(strings.HasSuffix(name, ".mod") ||
+			strings.HasSuffix(name, ".zip"))
+}
@@ -0,0 +1,140 @@
+package goproxy
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConsistencyChecker(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestConsistencyChecker")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	upstreamMod := "module example.com"
+	server := httptest.NewServer(http.HandlerFunc(func(
+		rw http.ResponseWriter,
+		req *http.Request,
+	) {
+		switch req.URL.Path {
+		case "/example.com/@v/v1.0.0.mod":
+			responseString(rw, req, http.StatusOK, -2, upstreamMod)
+		default:
+			responseNotFound(rw, req, -2)
+		}
+	}))
+	defer server.Close()
+
+	g := &Goproxy{
+		Cacher:      DirCacher(tempDir),
+		GoBinEnv:    []string{"GOSUMDB=off"},
+		ErrorLogger: log.New(&discardWriter{}, "", 0),
+	}
+	g.initOnce.Do(g.init)
+	for _, name := range []string{
+		"example.com/@v/v1.0.0.mod",
+		"example.com/@v/v1.1.0.mod",
+		"example.com/@v/v1.0.0.info",
+	} {
+		if err := g.putCache(
+			context.Background(),
+			name,
+			strings.NewReader("module example.com"),
+			time.Minute,
+		); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	var divergences []string
+	cc := &ConsistencyChecker{
+		Goproxy:  g,
+		Upstream: server.URL,
+		OnDivergence: func(name string, err error) {
+			divergences = append(divergences, name+": "+err.Error())
+		},
+	}
+	if err := cc.Check(context.Background()); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := strings.Join(divergences, "\n"),
+		"example.com/@v/v1.1.0.mod: missing from upstream: "+
+			"not found"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	divergences = nil
+	upstreamMod = "module example.com/evil"
+	if err := cc.Check(context.Background()); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := len(divergences), 2; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+	for _, divergence := range divergences {
+		if strings.HasPrefix(divergence, "example.com/@v/v1.0.0.mod: ") &&
+			!strings.Contains(divergence, "hash mismatch") {
+			t.Errorf("got %q, want to contain %q",
+				divergence, "hash mismatch")
+		}
+	}
+
+	divergences = nil
+	cc.Upstream = ""
+	if err := cc.Check(context.Background()); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := len(divergences), 0; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := cc.Run(ctx); err != context.Canceled {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestNameSampler(t *testing.T) {
+	ns := newNameSampler(2)
+	if got, want := len(ns.sample(10)), 0; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	ns.add("foo")
+	ns.add("foo")
+	if got, want := strings.Join(ns.sample(10), ","), "foo"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	for i := 0; i < 100; i++ {
+		ns.add("bar")
+	}
+	if got, want := len(ns.names), 2; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	} else if got, want := len(ns.sample(1)), 1; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
+
+func TestIsModuleFileName(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		want bool
+	}{
+		{"example.com/@v/v1.0.0.mod", true},
+		{"example.com/@v/v1.0.0.zip", true},
+		{"example.com/@v/v1.0.0.info", false},
+		{"example.com/@v/list", false},
+		{"sumdb/sum.golang.org/lookup/example.com@v1.0.0.zip", false},
+	} {
+		if got := isModuleFileName(tt.name); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
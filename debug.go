@@ -0,0 +1,233 @@
+package goproxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultUpstreamExchangeMaxBodyBytes is the default maximum number of bytes
+// of the response body recorded in an [UpstreamExchange].
+const defaultUpstreamExchangeMaxBodyBytes = 4096
+
+// UpstreamExchange is a recorded exchange with an upstream module proxy made
+// for a module matching the [Goproxy.DebugModules].
+type UpstreamExchange struct {
+	// Module is the path of the module that the exchange was made for.
+	Module string
+
+	// Method and URL are the method and the URL (with the password, if
+	// any, redacted) of the request.
+	Method string
+	URL    string
+
+	// RequestHeader is the header of the request, with the values of the
+	// sensitive fields (e.g. the Authorization) redacted.
+	RequestHeader http.Header
+
+	// StatusCode and ResponseHeader are the status code and the header
+	// (redacted like the RequestHeader) of the response. They are zero if
+	// no response was received.
+	StatusCode     int
+	ResponseHeader http.Header
+
+	// ResponseBody is the leading part of the response body read by the
+	// [Goproxy], up to the [Goproxy.DebugMaxBodyBytes].
+	ResponseBody []byte
+
+	// ResponseBodyTruncated indicates whether the ResponseBody is only
+	// the leading part of what has been read.
+	ResponseBodyTruncated bool
+
+	// Duration is the time elapsed from sending the request until the
+	// response body was closed.
+	Duration time.Duration
+
+	// Err is the error that occurred while sending the request, if any.
+	Err error
+}
+
+// String implements the [fmt.Stringer].
+func (ue *UpstreamExchange) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(
+		&sb,
+		"%s %s (module %s, %s)\n",
+		ue.Method,
+		ue.URL,
+		ue.Module,
+		ue.Duration,
+	)
+	ue.RequestHeader.Write(&sb)
+	if ue.Err != nil {
+		fmt.Fprintf(&sb, "\nerror: %v\n", ue.Err)
+		return sb.String()
+	}
+
+	fmt.Fprintf(
+		&sb,
+		"\n%d %s\n",
+		ue.StatusCode,
+		http.StatusText(ue.StatusCode),
+	)
+	ue.ResponseHeader.Write(&sb)
+	sb.WriteString("\n")
+	sb.Write(ue.ResponseBody)
+	if ue.ResponseBodyTruncated {
+		sb.WriteString("\n[truncated]")
+	}
+
+	return sb.String()
+}
+
+// sensitiveHeaderKeys is the list of the header keys whose values are redacted
+// in the [UpstreamExchange]s.
+var sensitiveHeaderKeys = []string{
+	"Authorization",
+	"Cookie",
+	"Proxy-Authorization",
+	"Set-Cookie",
+}
+
+// redactedHeader returns a copy of the header with the values of the
+// [sensitiveHeaderKeys] redacted.
+func redactedHeader(header http.Header) http.Header {
+	rh := make(http.Header, len(header))
+	for k, vs := range header {
+		if stringSliceContains(sensitiveHeaderKeys, k) {
+			vs = []string{"xxxxx"}
+		}
+
+		rh[k] = append([]string(nil), vs...)
+	}
+
+	return rh
+}
+
+// debugModuleContextKey is the context key of the path of the module whose
+// upstream exchanges are debugged.
+type debugModuleContextKey struct{}
+
+// withDebugModule returns a copy of the ctx that makes the upstream exchanges
+// made with it for the modulePath be recorded, if the modulePath matches the
+// [Goproxy.DebugModules].
+func (g *Goproxy) withDebugModule(
+	ctx context.Context,
+	modulePath string,
+) context.Context {
+	if !globsMatchPath(g.DebugModules, modulePath) {
+		return ctx
+	}
+
+	return context.WithValue(ctx, debugModuleContextKey{}, modulePath)
+}
+
+// debugTransport is an [http.RoundTripper] that records the exchanges made with
+// the contexts returned by the [Goproxy.withDebugModule].
+type debugTransport struct {
+	g         *Goproxy
+	transport http.RoundTripper
+}
+
+// RoundTrip implements the [http.RoundTripper].
+func (dt *debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := dt.transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	modulePath, ok := req.Context().Value(debugModuleContextKey{}).(string)
+	if !ok {
+		return transport.RoundTrip(req)
+	}
+
+	ue := &UpstreamExchange{
+		Module:        modulePath,
+		Method:        req.Method,
+		URL:           redactedURL(req.URL),
+		RequestHeader: redactedHeader(req.Header),
+	}
+
+	startTime := time.Now()
+	res, err := transport.RoundTrip(req)
+	if err != nil {
+		ue.Duration = time.Since(startTime)
+		ue.Err = err
+		dt.g.reportUpstreamExchange(ue)
+		return nil, err
+	}
+
+	ue.StatusCode = res.StatusCode
+	ue.ResponseHeader = redactedHeader(res.Header)
+
+	maxBodyBytes := dt.g.DebugMaxBodyBytes
+	if maxBodyBytes == 0 {
+		maxBodyBytes = defaultUpstreamExchangeMaxBodyBytes
+	}
+
+	res.Body = &debugBody{
+		ReadCloser:   res.Body,
+		maxBodyBytes: maxBodyBytes,
+		onClose: func(body []byte, truncated bool) {
+			ue.ResponseBody = body
+			ue.ResponseBodyTruncated = truncated
+			ue.Duration = time.Since(startTime)
+			dt.g.reportUpstreamExchange(ue)
+		},
+	}
+
+	return res, nil
+}
+
+// debugBody is a response body that records its leading part while being read.
+type debugBody struct {
+	io.ReadCloser
+
+	maxBodyBytes int
+	onClose      func(body []byte, truncated bool)
+
+	once      sync.Once
+	body      []byte
+	truncated bool
+}
+
+// Read implements the [io.Reader].
+func (db *debugBody) Read(b []byte) (int, error) {
+	n, err := db.ReadCloser.Read(b)
+	if room := db.maxBodyBytes - len(db.body); room < n {
+		if room > 0 {
+			db.body = append(db.body, b[:room]...)
+		}
+
+		db.truncated = db.truncated || n > 0
+	} else {
+		db.body = append(db.body, b[:n]...)
+	}
+
+	return n, err
+}
+
+// Close implements the [io.Closer].
+func (db *debugBody) Close() error {
+	err := db.ReadCloser.Close()
+	db.once.Do(func() {
+		db.onClose(db.body, db.truncated)
+	})
+
+	return err
+}
+
+// reportUpstreamExchange reports the ue via the [Goproxy.OnUpstreamExchange],
+// or logs it via the [Goproxy.ErrorLogger] if there is none.
+func (g *Goproxy) reportUpstreamExchange(ue *UpstreamExchange) {
+	if g.OnUpstreamExchange != nil {
+		g.OnUpstreamExchange(ue)
+		return
+	}
+
+	g.logErrorf("upstream exchange: %s", ue)
+}
@@ -0,0 +1,50 @@
+//go:build go1.21
+
+package goproxy
+
+import (
+	"context"
+	"log/slog"
+)
+
+// SlogUpstreamExchangeHandler returns a function suitable for the
+// [Goproxy.OnUpstreamExchange] that logs each [UpstreamExchange] via the logger
+// at the [slog.LevelDebug], with its fields as attributes.
+func SlogUpstreamExchangeHandler(logger *slog.Logger) func(*UpstreamExchange) {
+	return func(ue *UpstreamExchange) {
+		attrs := []slog.Attr{
+			slog.String("module", ue.Module),
+			slog.String("method", ue.Method),
+			slog.String("url", ue.URL),
+			slog.Any("request_header", ue.RequestHeader),
+			slog.Duration("duration", ue.Duration),
+		}
+		if ue.Err != nil {
+			attrs = append(
+				attrs,
+				slog.String("error", ue.Err.Error()),
+			)
+		} else {
+			attrs = append(
+				attrs,
+				slog.Int("status_code", ue.StatusCode),
+				slog.Any("response_header", ue.ResponseHeader),
+				slog.String(
+					"response_body",
+					string(ue.ResponseBody),
+				),
+				slog.Bool(
+					"response_body_truncated",
+					ue.ResponseBodyTruncated,
+				),
+			)
+		}
+
+		logger.LogAttrs(
+			context.Background(),
+			slog.LevelDebug,
+			"upstream exchange",
+			attrs...,
+		)
+	}
+}
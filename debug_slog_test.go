@@ -0,0 +1,55 @@
+//go:build go1.21
+
+package goproxy
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSlogUpstreamExchangeHandler(t *testing.T) {
+	var buf bytes.Buffer
+	handle := SlogUpstreamExchangeHandler(slog.New(slog.NewTextHandler(
+		&buf,
+		&slog.HandlerOptions{Level: slog.LevelDebug},
+	)))
+
+	handle(&UpstreamExchange{
+		Module:         "example.com",
+		Method:         http.MethodGet,
+		URL:            "https://example.com/example.com/@v/list",
+		StatusCode:     http.StatusOK,
+		ResponseHeader: http.Header{"Etag": {`"foo"`}},
+		ResponseBody:   []byte("v1.0.0"),
+	})
+	if got := buf.String(); !strings.Contains(got, "level=DEBUG") ||
+		!strings.Contains(got, "status_code=200") ||
+		!strings.Contains(got, "response_body=v1.0.0") {
+		t.Errorf("unexpected log %q", got)
+	}
+
+	buf.Reset()
+	handle(&UpstreamExchange{
+		Module: "example.com",
+		Method: http.MethodGet,
+		Err:    errors.New("foobar"),
+	})
+	if got := buf.String(); !strings.Contains(got, "error=foobar") ||
+		strings.Contains(got, "status_code") {
+		t.Errorf("unexpected log %q", got)
+	}
+
+	// Debug logs are discarded at the default level.
+	buf.Reset()
+	SlogUpstreamExchangeHandler(slog.New(slog.NewTextHandler(
+		&buf,
+		nil,
+	)))(&UpstreamExchange{Module: "example.com"})
+	if got := buf.String(); got != "" {
+		t.Errorf("got %q, want %q", got, "")
+	}
+}
@@ -0,0 +1,138 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestGoproxyDebugModules(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestGoproxyDebugModules")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	server := httptest.NewServer(http.HandlerFunc(func(
+		rw http.ResponseWriter,
+		req *http.Request,
+	) {
+		rw.Header().Set("Set-Cookie", "secret")
+		responseString(rw, req, http.StatusOK, -2, "v1.0.0\nv1.1.0\n")
+	}))
+	defer server.Close()
+
+	var exchanges []*UpstreamExchange
+	g := &Goproxy{
+		GoBinEnv:          []string{"GOPROXY=" + server.URL, "GOSUMDB=off"},
+		DebugModules:      "example.com/debug",
+		DebugMaxBodyBytes: 8,
+		OnUpstreamExchange: func(ue *UpstreamExchange) {
+			exchanges = append(exchanges, ue)
+		},
+	}
+	g.init()
+
+	for _, name := range []string{
+		"example.com/debug/@v/list",
+		"example.com/debug/foo/@v/list",
+		"example.com/other/@v/list",
+	} {
+		f, err := newFetch(g, name, tempDir)
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		if _, err := f.do(context.Background()); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	if got, want := len(exchanges), 2; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	ue := exchanges[0]
+	if got, want := ue.Module, "example.com/debug"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if got, want := ue.URL,
+		server.URL+"/example.com/debug/@v/list"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if got, want := ue.StatusCode, http.StatusOK; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	if got, want := ue.ResponseHeader.Get("Set-Cookie"),
+		"xxxxx"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if got, want := string(ue.ResponseBody), "v1.0.0\nv"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if !ue.ResponseBodyTruncated {
+		t.Error("expected truncated response body")
+	}
+
+	if got, want := exchanges[1].Module,
+		"example.com/debug/foo"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if s := ue.String(); !strings.Contains(s, "[truncated]") {
+		t.Errorf("got %q, want it to contain %q", s, "[truncated]")
+	}
+
+	exchanges = nil
+	server.Close()
+	f, err := newFetch(g, "example.com/debug/@latest", tempDir)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := f.do(ctx); err == nil {
+		t.Fatal("expected error")
+	}
+
+	if got, want := len(exchanges), 1; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	} else if !errors.Is(exchanges[0].Err, context.Canceled) {
+		t.Errorf(
+			"got error %q, want error %q",
+			exchanges[0].Err,
+			context.Canceled,
+		)
+	}
+}
+
+func TestRedactedHeader(t *testing.T) {
+	header := http.Header{
+		"Authorization": {"Bearer secret"},
+		"Accept":        {"*/*"},
+	}
+	rh := redactedHeader(header)
+	if got, want := rh.Get("Authorization"), "xxxxx"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if got, want := rh.Get("Accept"), "*/*"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if got, want := header.Get("Authorization"),
+		"Bearer secret"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,214 @@
+package goproxy
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// dedupBlobDirName is the name of the directory in the root of a cache
+// directory that holds the blobs of a [DedupDirCacher] by default. Its name
+// starts with "." so that it is never treated as a cache directory.
+const dedupBlobDirName = ".blobs"
+
+// DedupDirCacher implements the [Cacher] using a directory on the local disk
+// just like the [DirCacher] (or the [ShardedDirCacher]), except that identical
+// caches are stored only once. Each cache file is a hard link to a blob named
+// after the SHA-256 of its content, so that the same zip file cached under
+// several names (e.g. case-encoded and non-encoded ones, or by multiple tenants
+// sharing the BlobDir) takes up the disk space of a single one.
+//
+// Each cache keeps its own expiration. Blobs no longer linked to any cache file
+// are removed by the cleanups once they are a day old. On platforms that do not
+// report the link counts of files, all blobs are removed once they are a day
+// old, which only keeps later caches from being deduplicated against them.
+//
+// Hard links only work within a single file system, so caches whose blobs are
+// on another file system are stored as copies.
+//
+// Make sure that all fields of the DedupDirCacher have been finalized before
+// calling any of its methods.
+type DedupDirCacher struct {
+	// Dir is the directory of the cache files. If it does not exist, it
+	// will be created with 0750 permissions.
+	Dir string
+
+	// Layout is the layout of the cache files in the Dir.
+	//
+	// If the Layout is zero, the [DirCacherLayoutFlat] is used.
+	Layout DirCacherLayout
+
+	// BlobDir is the directory of the blobs. It may be shared by multiple
+	// DedupDirCachers on the same file system. If it does not exist, it
+	// will be created with 0750 permissions.
+	//
+	// If the BlobDir is empty, a ".blobs" directory inside the Dir is
+	// used.
+	BlobDir string
+}
+
+// Get implements the [Cacher].
+func (ddc DedupDirCacher) Get(
+	ctx context.Context,
+	name string,
+) (io.ReadCloser, error) {
+	return DirCacher(ddc.Dir).Get(ctx, ddc.Layout.cachePath(name))
+}
+
+// Put implements the [Cacher].
+func (ddc DedupDirCacher) Put(
+	ctx context.Context,
+	name string,
+	content io.ReadSeeker,
+	expiration time.Duration,
+) error {
+	checksum, err := contentChecksum(content)
+	if err != nil {
+		return err
+	}
+
+	blob, err := ddc.blob(checksum, content)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	return DirCacher(ddc.Dir).put(
+		ddc.Layout.cachePath(name),
+		linkableFile{blob},
+		checksum,
+		expiration,
+	)
+}
+
+// blobDir returns the directory of the blobs of the ddc.
+func (ddc DedupDirCacher) blobDir() string {
+	if ddc.BlobDir != "" {
+		return ddc.BlobDir
+	}
+
+	return filepath.Join(ddc.Dir, dedupBlobDirName)
+}
+
+// blobPath returns the path of the blob for the hex-encoded SHA-256 checksum.
+func (ddc DedupDirCacher) blobPath(checksum string) string {
+	return filepath.Join(ddc.blobDir(), checksum[:2], checksum)
+}
+
+// blob opens the blob for the hex-encoded SHA-256 checksum of the content,
+// creating it from the content first if it does not exist.
+func (ddc DedupDirCacher) blob(
+	checksum string,
+	content io.ReadSeeker,
+) (*os.File, error) {
+	blobPath := ddc.blobPath(checksum)
+	if f, err := os.Open(blobPath); err == nil {
+		return f, nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(blobPath), 0750); err != nil {
+		return nil, err
+	}
+
+	if linked, err := linkCacheFile(blobPath, content); err != nil {
+		return nil, err
+	} else if !linked {
+		if err := writeCacheFile(blobPath, content); err != nil {
+			return nil, err
+		}
+	}
+
+	// Keep the blob from being removed by a concurrent cleanup before
+	// being linked to the cache file.
+	now := time.Now()
+	if err := os.Chtimes(blobPath, now, now); err != nil {
+		return nil, err
+	}
+
+	return os.Open(blobPath)
+}
+
+// Delete implements the [Deleter]. The blob of the cache is left to the
+// cleanups.
+func (ddc DedupDirCacher) Delete(ctx context.Context, name string) error {
+	return DirCacher(ddc.Dir).Delete(ctx, ddc.Layout.cachePath(name))
+}
+
+// Cleanup implements the [Cacher].
+func (ddc DedupDirCacher) Cleanup() error {
+	return ddc.CleanupReclaimed(nil)
+}
+
+// CleanupReclaimed implements the [Reclaimer]. Note that the sizes passed to
+// the reclaimed are the sizes of the removed caches, which are not all
+// reclaimed from the disk while other caches still share their blobs.
+func (ddc DedupDirCacher) CleanupReclaimed(
+	reclaimed func(name string, size int64),
+) error {
+	var err error
+	if ddc.Layout == DirCacherLayoutSharded {
+		err = ShardedDirCacher(ddc.Dir).CleanupReclaimed(reclaimed)
+	} else {
+		err = DirCacher(ddc.Dir).CleanupReclaimed(reclaimed)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return ddc.cleanupBlobs()
+}
+
+// cleanupBlobs removes the blobs that are at least a day old and no longer
+// linked to any cache file.
+func (ddc DedupDirCacher) cleanupBlobs() error {
+	return filepath.Walk(ddc.blobDir(), func(
+		filePath string,
+		fi os.FileInfo,
+		err error,
+	) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil // Removed meanwhile
+			}
+
+			return err
+		} else if !fi.Mode().IsRegular() || time.Since(
+			fi.ModTime(),
+		) < dirCacherStaleTempFileAge {
+			return nil
+		} else if links, ok := fileLinks(fi); ok && links > 1 {
+			return nil
+		}
+
+		err = os.Remove(filePath)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		return nil
+	})
+}
+
+// List implements the [Lister].
+func (ddc DedupDirCacher) List(
+	ctx context.Context,
+	prefix string,
+) CacheIterator {
+	return listCaches(ctx, ddc.walkCaches, prefix)
+}
+
+// walkCaches implements the [cacheWalker].
+func (ddc DedupDirCacher) walkCaches(
+	fn func(name string, size int64) error,
+) error {
+	if ddc.Layout == DirCacherLayoutSharded {
+		return ShardedDirCacher(ddc.Dir).walkCaches(fn)
+	}
+
+	return DirCacher(ddc.Dir).walkCaches(fn)
+}
@@ -0,0 +1,12 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package goproxy
+
+import "os"
+
+// fileLinks always reports that the number of hard links to the file described
+// by the fi is unknown, since it is not reported on the current platform.
+func fileLinks(fi os.FileInfo) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,190 @@
+package goproxy
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDedupDirCacher(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestDedupDirCacher")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	fooChecksum := "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0" +
+		"f98a5e886266e7ae"
+	barChecksum := "fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04f" +
+		"ae5511b68fbf8fb9"
+
+	blobDir := filepath.Join(tempDir, "blobs")
+	ddcs := []DedupDirCacher{
+		{Dir: filepath.Join(tempDir, "a"), BlobDir: blobDir},
+		{
+			Dir:     filepath.Join(tempDir, "b"),
+			Layout:  DirCacherLayoutSharded,
+			BlobDir: blobDir,
+		},
+	}
+
+	for n, tt := range []struct {
+		ddc        DedupDirCacher
+		name       string
+		content    string
+		expiration time.Duration
+	}{
+		{ddcs[0], "example.com/!foo/@v/v1.0.0.zip", "foo", time.Hour},
+		{ddcs[0], "example.com/foo/@v/v1.0.0.zip", "foo", -time.Hour},
+		{ddcs[1], "example.com/!foo/@v/v1.0.0.zip", "foo", time.Hour},
+		{ddcs[1], "example.com/@v/list", "bar", -time.Hour},
+	} {
+		if err := tt.ddc.Put(
+			context.Background(),
+			tt.name,
+			strings.NewReader(tt.content),
+			tt.expiration,
+		); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", n, err)
+		} else if tt.expiration < 0 {
+			continue
+		}
+
+		content, err := tt.ddc.Get(context.Background(), tt.name)
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", n, err)
+		}
+
+		b, err := ioutil.ReadAll(content)
+		content.Close()
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", n, err)
+		} else if got, want := string(b), tt.content; got != want {
+			t.Errorf("test(%d): got %q, want %q", n, got, want)
+		}
+	}
+
+	var fis []os.FileInfo
+	for _, cachePath := range []string{
+		filepath.Join(ddcs[0].Dir, "example.com/!foo/@v/v1.0.0.zip"),
+		filepath.Join(ddcs[0].Dir, "example.com/foo/@v/v1.0.0.zip"),
+		filepath.Join(ddcs[1].Dir, filepath.FromSlash(
+			DirCacherLayoutSharded.cachePath(
+				"example.com/!foo/@v/v1.0.0.zip",
+			),
+		)),
+	} {
+		fi, err := os.Stat(cachePath)
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		fis = append(fis, fi)
+	}
+
+	for i := 1; i < len(fis); i++ {
+		if !os.SameFile(fis[0], fis[i]) {
+			t.Errorf("cache file %d is not linked", i)
+		}
+	}
+
+	var names []string
+	it := ddcs[1].List(context.Background(), "")
+	for it.Next() {
+		names = append(names, it.Cache().Name)
+	}
+
+	if err := it.Close(); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := strings.Join(names, " "),
+		"example.com/!foo/@v/v1.0.0.zip "+
+			"example.com/@v/list"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Age all blobs so that the cleanups may remove them.
+	old := time.Now().Add(-2 * dirCacherStaleTempFileAge)
+	for _, checksum := range []string{fooChecksum, barChecksum} {
+		if err := os.Chtimes(
+			ddcs[0].blobPath(checksum),
+			old,
+			old,
+		); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	var reclaimed []string
+	for _, ddc := range ddcs {
+		if err := ddc.CleanupReclaimed(func(name string, size int64) {
+			reclaimed = append(reclaimed, name)
+		}); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	if got, want := strings.Join(reclaimed, " "),
+		"example.com/foo/@v/v1.0.0.zip "+
+			"example.com/@v/list"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	for _, tt := range []struct {
+		checksum string
+		want     bool
+	}{
+		{fooChecksum, true},
+		{barChecksum, false},
+	} {
+		_, err := os.Stat(ddcs[0].blobPath(tt.checksum))
+		if _, ok := fileLinks(fis[0]); !ok {
+			continue // Blobs are removed regardless
+		} else if got := err == nil; got != tt.want {
+			t.Errorf("%s: got %t, want %t",
+				tt.checksum, got, tt.want)
+		}
+	}
+
+	// Caches put later are still deduplicated against remaining blobs.
+	if err := ddcs[0].Put(
+		context.Background(),
+		"example.org/@v/v1.0.0.zip",
+		strings.NewReader("foo"),
+		time.Hour,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	fi, err := os.Stat(filepath.Join(
+		ddcs[0].Dir,
+		"example.org/@v/v1.0.0.zip",
+	))
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if _, ok := fileLinks(fi); ok && !os.SameFile(fi, fis[0]) {
+		t.Error("cache file is not linked")
+	}
+
+	if err := (DedupDirCacher{Dir: filepath.Join(tempDir, "c")}).Put(
+		context.Background(),
+		"example.com/@v/list",
+		strings.NewReader("foo"),
+		time.Hour,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(
+		tempDir,
+		"c",
+		dedupBlobDirName,
+		fooChecksum[:2],
+		fooChecksum,
+	)); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+}
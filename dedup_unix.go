@@ -0,0 +1,20 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package goproxy
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileLinks returns the number of hard links to the file described by the fi,
+// and reports whether it is known.
+func fileLinks(fi os.FileInfo) (uint64, bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+
+	return uint64(st.Nlink), true
+}
@@ -0,0 +1,162 @@
+package goproxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/mod/sumdb/dirhash"
+)
+
+// DoubleFetchMismatch is a divergence between the module files fetched for the
+// [Goproxy.DoubleFetchModules] from two independent upstreams.
+type DoubleFetchMismatch struct {
+	// ModulePath is the path of the module.
+	ModulePath string
+
+	// ModuleVersion is the version of the module.
+	ModuleVersion string
+
+	// Ext is the extension (".mod" or ".zip") of the module file.
+	Ext string
+
+	// Upstream is the upstream (a module proxy URL with any password
+	// redacted, or "direct") the module file was fetched from first.
+	Upstream string
+
+	// Hash is the hash (as in go.sum files) of the module file fetched
+	// from the Upstream.
+	Hash string
+
+	// DoubleFetchUpstream is the [Goproxy.DoubleFetchProxy], with any
+	// password redacted.
+	DoubleFetchUpstream string
+
+	// DoubleFetchHash is the hash of the module file fetched from the
+	// DoubleFetchUpstream.
+	DoubleFetchHash string
+}
+
+// String implements the [fmt.Stringer].
+func (dfm *DoubleFetchMismatch) String() string {
+	return fmt.Sprintf(
+		"double fetch mismatch of %s@%s%s: %s from %s, %s from %s",
+		dfm.ModulePath,
+		dfm.ModuleVersion,
+		dfm.Ext,
+		dfm.Hash,
+		dfm.Upstream,
+		dfm.DoubleFetchHash,
+		dfm.DoubleFetchUpstream,
+	)
+}
+
+// redactedUpstream returns a redacted string form of the upstream, which is
+// either a module proxy URL or "direct", suitable for printing.
+func redactedUpstream(upstream string) string {
+	if upstream == "direct" {
+		return upstream
+	}
+
+	u, err := parseRawURL(upstream)
+	if err != nil {
+		return "invalid URL"
+	}
+
+	return redactedURL(u)
+}
+
+// doubleFetchRequired reports whether the module file fetched for the f from
+// the upstream must be fetched again from the [Goproxy.DoubleFetchProxy] to be
+// compared.
+func (f *fetch) doubleFetchRequired(upstream string) bool {
+	return (f.ops == fetchOpsDownloadMod || f.ops == fetchOpsDownloadZip) &&
+		f.g.DoubleFetchProxy != "" &&
+		f.g.DoubleFetchProxy != upstream &&
+		globsMatchPath(f.g.DoubleFetchModules, f.modulePath)
+}
+
+// doubleFetch fetches the module file of the r fetched for the f from the
+// upstream again from the [Goproxy.DoubleFetchProxy], and reports an error
+// unless both hash the same. Mismatches are counted in the
+// [Stats.DoubleFetchMismatches] and reported via the
+// [Goproxy.OnDoubleFetchMismatch].
+func (f *fetch) doubleFetch(
+	ctx context.Context,
+	upstream string,
+	r *fetchResult,
+) error {
+	var (
+		r2  *fetchResult
+		err error
+	)
+	if f.g.DoubleFetchProxy == "direct" {
+		r2, err = f.doDirect(ctx)
+	} else {
+		r2, err = f.doProxy(ctx, f.g.DoubleFetchProxy)
+	}
+
+	f.g.updateStats(func(s *Stats) { s.DoubleFetches++ })
+	if err != nil {
+		return fmt.Errorf("double fetch: %w", err)
+	}
+
+	dfm := &DoubleFetchMismatch{
+		ModulePath:    f.modulePath,
+		ModuleVersion: f.moduleVersion,
+		Upstream:      redactedUpstream(upstream),
+		DoubleFetchUpstream: redactedUpstream(
+			f.g.DoubleFetchProxy,
+		),
+	}
+	switch f.ops {
+	case fetchOpsDownloadMod:
+		dfm.Ext = ".mod"
+		dfm.Hash, err = hashModFile(r.GoMod)
+		if err == nil {
+			dfm.DoubleFetchHash, err = hashModFile(r2.GoMod)
+		}
+	case fetchOpsDownloadZip:
+		dfm.Ext = ".zip"
+		dfm.Hash, err = dirhash.HashZip(r.Zip, dirhash.DefaultHash)
+		if err == nil {
+			dfm.DoubleFetchHash, err = dirhash.HashZip(
+				r2.Zip,
+				dirhash.DefaultHash,
+			)
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if dfm.Hash == dfm.DoubleFetchHash {
+		return nil
+	}
+
+	f.g.updateStats(func(s *Stats) { s.DoubleFetchMismatches++ })
+	if f.g.OnDoubleFetchMismatch != nil {
+		f.g.OnDoubleFetchMismatch(dfm)
+	} else {
+		f.g.logError(dfm.String())
+	}
+
+	return fmt.Errorf(
+		"%s: double fetch mismatch: %w",
+		f.modAtVer,
+		errBadUpstream,
+	)
+}
+
+// hashModFile returns the hash (as in go.sum files) of the mod file targeted by
+// the name.
+func hashModFile(name string) (string, error) {
+	return dirhash.DefaultHash(
+		[]string{"go.mod"},
+		func(string) (io.ReadCloser, error) {
+			return os.Open(name)
+		},
+	)
+}
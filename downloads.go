@@ -0,0 +1,104 @@
+package goproxy
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// maxModuleDownloadCounters is the maximum number of modules whose downloads
+// are counted by a [Goproxy]. Downloads of further modules are not counted.
+const maxModuleDownloadCounters = 10000
+
+// ModuleDownloads is the number of downloads of a module served by a
+// [Goproxy].
+type ModuleDownloads struct {
+	// Path is the module path.
+	Path string
+
+	// Downloads is the number of requests for the ".zip" files of any
+	// version of the module, whether served from the cache or fetched.
+	Downloads int64
+}
+
+// moduleDownloadRecorder records the downloads of modules. It is safe for
+// concurrent use.
+type moduleDownloadRecorder struct {
+	mutex     sync.Mutex
+	downloads map[string]int64
+}
+
+// newModuleDownloadRecorder returns a new instance of the
+// [moduleDownloadRecorder].
+func newModuleDownloadRecorder() *moduleDownloadRecorder {
+	return &moduleDownloadRecorder{downloads: map[string]int64{}}
+}
+
+// add adds the n to the downloads of the module targeted by the modulePath.
+func (mdr *moduleDownloadRecorder) add(modulePath string, n int64) {
+	mdr.mutex.Lock()
+	defer mdr.mutex.Unlock()
+	if _, ok := mdr.downloads[modulePath]; ok ||
+		len(mdr.downloads) < maxModuleDownloadCounters {
+		mdr.downloads[modulePath] += n
+	}
+}
+
+// list returns the recorded downloads, the most downloaded modules first.
+// Modules downloaded equally are sorted by their paths.
+func (mdr *moduleDownloadRecorder) list() []ModuleDownloads {
+	mdr.mutex.Lock()
+	defer mdr.mutex.Unlock()
+	mds := make([]ModuleDownloads, 0, len(mdr.downloads))
+	for modulePath, n := range mdr.downloads {
+		mds = append(mds, ModuleDownloads{
+			Path:      modulePath,
+			Downloads: n,
+		})
+	}
+
+	sort.Slice(mds, func(i, j int) bool {
+		if mds[i].Downloads != mds[j].Downloads {
+			return mds[i].Downloads > mds[j].Downloads
+		}
+
+		return mds[i].Path < mds[j].Path
+	})
+
+	return mds
+}
+
+// ModuleDownloads returns the numbers of downloads of the modules served by
+// the g, the most downloaded first. They are also reported by the
+// "/-/module-downloads" administrative endpoint.
+//
+// At most 10000 modules are counted.
+func (g *Goproxy) ModuleDownloads() []ModuleDownloads {
+	g.initOnce.Do(g.init)
+	return g.moduleDownloads.list()
+}
+
+// recordDownload records a request for the module file of the f, which has been
+// served from the cache if the hit is true.
+func (g *Goproxy) recordDownload(f *fetch, hit bool) {
+	if f.ops == fetchOpsDownloadZip {
+		g.moduleDownloads.add(f.modulePath, 1)
+	}
+
+	g.metrics.observeDownload(hit)
+	g.updateStats(func(s *Stats) {
+		if hit {
+			s.DownloadCacheHits++
+		} else {
+			s.DownloadCacheMisses++
+		}
+	})
+}
+
+// serveModuleDownloads serves module downloads requests.
+func (g *Goproxy) serveModuleDownloads(
+	rw http.ResponseWriter,
+	req *http.Request,
+) {
+	responseJSON(rw, req, -2, g.ModuleDownloads())
+}
@@ -0,0 +1,112 @@
+package goproxy
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestModuleDownloadRecorder(t *testing.T) {
+	mdr := newModuleDownloadRecorder()
+	mdr.add("example.com/a", 1)
+	mdr.add("example.com/b", 2)
+	mdr.add("example.com/c", 1)
+
+	want := []ModuleDownloads{
+		{"example.com/b", 2},
+		{"example.com/a", 1},
+		{"example.com/c", 1},
+	}
+	got := mdr.list()
+	if len(got) != len(want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got %+v, want %+v", got[i], want[i])
+		}
+	}
+}
+
+func TestGoproxyModuleDownloads(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestGoproxyModuleDownloads")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	server := httptest.NewServer(http.HandlerFunc(func(
+		rw http.ResponseWriter,
+		req *http.Request,
+	) {
+		if req.URL.Path == "/example.com/@v/v1.0.0.mod" {
+			responseString(
+				rw,
+				req,
+				http.StatusOK,
+				-2,
+				"module example.com",
+			)
+			return
+		}
+
+		responseNotFound(rw, req, -2)
+	}))
+	defer server.Close()
+
+	g := &Goproxy{
+		GoBinEnv: []string{
+			"GOPROXY=" + server.URL,
+			"GOSUMDB=off",
+		},
+		Cacher:  DirCacher(tempDir),
+		TempDir: tempDir,
+		AdminAuthorizer: func(*http.Request) bool {
+			return true
+		},
+		ErrorLogger: log.New(&discardWriter{}, "", 0),
+	}
+
+	for _, name := range []string{
+		"example.com/@v/v1.0.0.mod",
+		"example.com/@v/v1.0.0.mod",
+		"example.com/@v/v1.0.0.zip",
+		"example.com/@latest",
+	} {
+		req := httptest.NewRequest("", "/"+name, nil)
+		g.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	stats := g.Stats()
+	if got, want := stats.DownloadCacheHits, int64(1); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	if got, want := stats.DownloadCacheMisses, int64(2); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	req := httptest.NewRequest("", "/-/module-downloads", nil)
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, req)
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	var mds []ModuleDownloads
+	if err := json.NewDecoder(rec.Body).Decode(&mds); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := len(mds), 1; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	want := ModuleDownloads{Path: "example.com", Downloads: 1}
+	if got := mds[0]; got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,66 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// emergencyStale is the state of the automatic emergency stale mode of a
+// [Goproxy] (see the [Goproxy.EmergencyStaleThreshold]).
+type emergencyStale struct {
+	mutex    sync.Mutex
+	failures int
+	active   bool
+}
+
+// inEmergencyStale reports whether the g is in the emergency stale mode, either
+// enabled via the [Goproxy.EmergencyStale] or entered automatically.
+func (g *Goproxy) inEmergencyStale() bool {
+	if g.settings().EmergencyStale {
+		return true
+	}
+
+	g.emergency.mutex.Lock()
+	defer g.emergency.mutex.Unlock()
+	return g.emergency.active
+}
+
+// recordUpstreamResult records the err of fetching a module file from the
+// upstreams, entering the automatic emergency stale mode after too many
+// consecutive upstream failures and leaving it after a success. Not-found
+// results count as successes, since the upstreams did answer.
+func (g *Goproxy) recordUpstreamResult(err error) {
+	if errors.Is(err, context.Canceled) {
+		return // Abandoned by the client
+	}
+
+	threshold := g.settings().EmergencyStaleThreshold
+
+	es := &g.emergency
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+	if err == nil || !isUpstreamFailure(err) {
+		es.failures = 0
+		if es.active {
+			es.active = false
+			g.logErrorf(
+				"left emergency stale mode: upstream recovered",
+			)
+		}
+
+		return
+	}
+
+	es.failures++
+	if threshold > 0 && es.failures >= threshold && !es.active {
+		es.active = true
+		g.updateStats(func(s *Stats) { s.EmergencyStaleActivations++ })
+		g.logErrorf(
+			"entered emergency stale mode after %d consecutive "+
+				"upstream failures: %v",
+			es.failures,
+			err,
+		)
+	}
+}
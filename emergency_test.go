@@ -0,0 +1,185 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRecordUpstreamResult(t *testing.T) {
+	g := &Goproxy{
+		EmergencyStaleThreshold: 2,
+		ErrorLogger:             log.New(&discardWriter{}, "", 0),
+	}
+
+	for n, tt := range []struct {
+		err  error
+		want bool
+	}{
+		{errBadUpstream, false},
+		{context.Canceled, false},
+		{errBadUpstream, true},
+		{errors.New("connection refused"), true},
+		{notFoundError("not found"), false},
+		{errBadUpstream, false},
+		{nil, false},
+		{errBadUpstream, false},
+	} {
+		g.recordUpstreamResult(tt.err)
+		if got, want := g.inEmergencyStale(), tt.want; got != want {
+			t.Errorf("test(%d): got %t, want %t", n, got, want)
+		}
+	}
+
+	if got, want := g.Stats().EmergencyStaleActivations,
+		int64(1); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	g = &Goproxy{ErrorLogger: log.New(&discardWriter{}, "", 0)}
+	for i := 0; i < 10; i++ {
+		g.recordUpstreamResult(errBadUpstream)
+	}
+
+	if g.inEmergencyStale() {
+		t.Error("unexpected emergency stale mode")
+	}
+
+	g.EmergencyStale = true
+	if !g.inEmergencyStale() {
+		t.Error("expected emergency stale mode")
+	}
+}
+
+func TestGoproxyEmergencyStale(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestGoproxyEmergencyStale")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	var (
+		statusMutex sync.Mutex
+		status      = http.StatusOK
+		list        = "v1.0.0"
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(
+		rw http.ResponseWriter,
+		req *http.Request,
+	) {
+		statusMutex.Lock()
+		code := status
+		body := list
+		statusMutex.Unlock()
+		if code != http.StatusOK {
+			rw.WriteHeader(code)
+			return
+		}
+
+		responseString(rw, req, http.StatusOK, 60, body)
+	}))
+	defer server.Close()
+
+	g := &Goproxy{
+		Cacher: DirCacher(tempDir),
+		GoBinEnv: []string{
+			"GOPROXY=" + server.URL,
+			"GOSUMDB=off",
+		},
+		ListCacheExpiration:     time.Millisecond,
+		StaleIfErrorExpiration:  time.Hour,
+		EmergencyStaleThreshold: 2,
+		ErrorLogger:             log.New(&discardWriter{}, "", 0),
+	}
+
+	serve := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest(
+			http.MethodGet,
+			"/example.com/@v/list",
+			nil,
+		))
+		return rec
+	}
+
+	if got, want := serve().Code, http.StatusOK; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	statusMutex.Lock()
+	status = http.StatusNotImplemented
+	list = "v1.0.0\nv1.1.0"
+	statusMutex.Unlock()
+
+	for i := 0; i < 3; i++ {
+		rec := serve()
+		if got, want := rec.Body.String(), "v1.0.0"; got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		} else if got, want := rec.Header().Get("Warning"),
+			staleWarning; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+
+	if !g.inEmergencyStale() {
+		t.Fatal("expected emergency stale mode")
+	} else if got, want := g.Stats().EmergencyStaleActivations,
+		int64(1); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	} else if g.Stats().StaleRevalidations == 0 {
+		t.Error("expected stale revalidations")
+	}
+
+	// The emergency stale mode is left once the upstream recovers, as
+	// noticed by the background refreshes.
+	statusMutex.Lock()
+	status = http.StatusOK
+	statusMutex.Unlock()
+
+	for i := 0; i < 100 && g.inEmergencyStale(); i++ {
+		serve()
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if g.inEmergencyStale() {
+		t.Fatal("unexpected emergency stale mode")
+	}
+
+	if rec := serve(); rec.Header().Get("Warning") != "" {
+		t.Errorf("got %q, want %q", rec.Header().Get("Warning"), "")
+	} else if got, want := rec.Body.String(),
+		"v1.0.0\nv1.1.0"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// The emergency stale mode can also be enabled manually.
+	if err := g.UpdateSettings(func(s *Settings) error {
+		s.EmergencyStale = true
+		return nil
+	}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	if rec := serve(); rec.Header().Get("Warning") != staleWarning {
+		t.Errorf("got %q, want %q",
+			rec.Header().Get("Warning"), staleWarning)
+	}
+
+	if err := g.UpdateSettings(func(s *Settings) error {
+		s.EmergencyStaleThreshold = -1
+		return nil
+	}); err == nil {
+		t.Fatal("expected error")
+	}
+}
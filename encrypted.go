@@ -0,0 +1,631 @@
+package goproxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EncryptedCacher implements the [Cacher] by wrapping another [Cacher] and
+// encrypting the contents of all its caches at rest with AES-GCM.
+//
+// Contents are split into chunks of 64 KiB that are sealed separately and bound
+// to the names of their caches, so that the caches can be served (including
+// Range requests, when the wrapped [Cacher] supports seeking) without being
+// decrypted entirely in memory, and cannot be swapped with each other without
+// being noticed. Caches that were not put via the EncryptedCacher with the same
+// key (e.g. the ones put before the encryption was enabled or before the key
+// was rotated) are treated as not found, so they are simply fetched again.
+//
+// Note that the sizes of the caches enumerated via the [EncryptedCacher.List]
+// are the encrypted ones.
+//
+// Make sure that all fields of the EncryptedCacher have been finalized before
+// calling any of its methods.
+type EncryptedCacher struct {
+	// Cacher is the wrapped [Cacher].
+	Cacher Cacher
+
+	// Key returns the AES key (16, 24 or 32 bytes for AES-128, AES-192 or
+	// AES-256). It is typically the [EnvEncryptionKey], the
+	// [FileEncryptionKey], or a function unwrapping a data key via a key
+	// management service. It is called on first use, and again after it
+	// has failed.
+	Key func(ctx context.Context) ([]byte, error)
+
+	aeadMutex sync.Mutex
+	aead      cipher.AEAD
+}
+
+const (
+	// encryptedMagic is the magic number of the contents encrypted by the
+	// [EncryptedCacher].
+	encryptedMagic = "GPE1"
+
+	// encryptedHeaderSize is the size of the header of the contents
+	// encrypted by the [EncryptedCacher], which consists of the
+	// encryptedMagic and the random prefix of the nonces of the chunks.
+	encryptedHeaderSize = 4 + 8
+
+	// encryptedChunkSize is the size of the plaintext of each chunk of the
+	// contents encrypted by the [EncryptedCacher], except the last one.
+	encryptedChunkSize = 64 << 10
+
+	// encryptedTagSize is the size of the authentication tag of each chunk
+	// of the contents encrypted by the [EncryptedCacher].
+	encryptedTagSize = 16
+
+	// encryptedSealedChunkSize is the size of each sealed chunk of the
+	// contents encrypted by the [EncryptedCacher], except the last one.
+	encryptedSealedChunkSize = encryptedChunkSize + encryptedTagSize
+)
+
+// errEncryptedCacheCorrupted is returned when a content encrypted by the
+// [EncryptedCacher] fails to be authenticated after its first chunk.
+var errEncryptedCacheCorrupted = errors.New("encrypted cache corrupted")
+
+// EnvEncryptionKey returns an [EncryptedCacher.Key] that reads the
+// base64-encoded key from the environment variable named name.
+func EnvEncryptionKey(name string) func(ctx context.Context) ([]byte, error) {
+	return func(context.Context) ([]byte, error) {
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return nil, fmt.Errorf(
+				"missing environment variable %s",
+				name,
+			)
+		}
+
+		return decodeEncryptionKey(v)
+	}
+}
+
+// FileEncryptionKey returns an [EncryptedCacher.Key] that reads the
+// base64-encoded key from the file targeted by the filename. Leading and
+// trailing white space in the file is ignored.
+func FileEncryptionKey(
+	filename string,
+) func(ctx context.Context) ([]byte, error) {
+	return func(context.Context) ([]byte, error) {
+		b, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		return decodeEncryptionKey(string(b))
+	}
+}
+
+// decodeEncryptionKey decodes the base64-encoded key s.
+func decodeEncryptionKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %v", err)
+	}
+
+	return key, nil
+}
+
+// Get implements the [Cacher].
+func (ec *EncryptedCacher) Get(
+	ctx context.Context,
+	name string,
+) (io.ReadCloser, error) {
+	aead, err := ec.gcm(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := ec.Cacher.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, encryptedHeaderSize)
+	if _, err := io.ReadFull(content, header); err != nil ||
+		string(header[:len(encryptedMagic)]) != encryptedMagic {
+		content.Close()
+		return nil, os.ErrNotExist
+	}
+
+	dc := &decryptedContent{
+		aead:        aead,
+		name:        name,
+		noncePrefix: header[len(encryptedMagic):],
+		content:     content,
+		br:          bufio.NewReader(content),
+		sealed:      make([]byte, encryptedSealedChunkSize),
+		size:        -1,
+	}
+
+	// A content that fails to be authenticated right away has been put
+	// with another key, so it is treated as not found.
+	if err := dc.load(0); err != nil {
+		content.Close()
+		if errors.Is(err, errEncryptedCacheCorrupted) {
+			return nil, os.ErrNotExist
+		}
+
+		return nil, err
+	}
+
+	return dc.wrap(), nil
+}
+
+// Put implements the [Cacher].
+func (ec *EncryptedCacher) Put(
+	ctx context.Context,
+	name string,
+	content io.ReadSeeker,
+	expiration time.Duration,
+) error {
+	aead, err := ec.gcm(ctx)
+	if err != nil {
+		return err
+	}
+
+	size, err := content.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, encryptedHeaderSize)
+	copy(header, encryptedMagic)
+	if _, err := rand.Read(header[len(encryptedMagic):]); err != nil {
+		return err
+	}
+
+	return ec.Cacher.Put(ctx, name, &encryptingReader{
+		aead:    aead,
+		name:    name,
+		header:  header,
+		content: content,
+		size:    size,
+		chunk:   -1,
+	}, expiration)
+}
+
+// Delete implements the [Deleter].
+func (ec *EncryptedCacher) Delete(ctx context.Context, name string) error {
+	d, ok := ec.Cacher.(Deleter)
+	if !ok {
+		return errDeleteNotSupported
+	}
+
+	return d.Delete(ctx, name)
+}
+
+// Cleanup implements the [Cacher].
+func (ec *EncryptedCacher) Cleanup() error {
+	return ec.Cacher.Cleanup()
+}
+
+// CleanupReclaimed implements the [Reclaimer].
+func (ec *EncryptedCacher) CleanupReclaimed(
+	reclaimed func(name string, size int64),
+) error {
+	r, ok := ec.Cacher.(Reclaimer)
+	if !ok {
+		return ec.Cacher.Cleanup()
+	}
+
+	return r.CleanupReclaimed(reclaimed)
+}
+
+// List implements the [Lister].
+func (ec *EncryptedCacher) List(
+	ctx context.Context,
+	prefix string,
+) CacheIterator {
+	return listCaches(ctx, ec.walkCaches, prefix)
+}
+
+// walkCaches implements the [cacheWalker].
+func (ec *EncryptedCacher) walkCaches(
+	fn func(name string, size int64) error,
+) error {
+	cw, ok := cacheWalkerOf(ec.Cacher)
+	if !ok {
+		return errWalkNotSupported
+	}
+
+	return cw.walkCaches(fn)
+}
+
+// gcm returns the AES-GCM cipher of the ec.
+func (ec *EncryptedCacher) gcm(ctx context.Context) (cipher.AEAD, error) {
+	ec.aeadMutex.Lock()
+	defer ec.aeadMutex.Unlock()
+	if ec.aead != nil {
+		return ec.aead, nil
+	}
+
+	if ec.Key == nil {
+		return nil, errors.New("missing encryption key")
+	}
+
+	key, err := ec.Key(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	ec.aead = aead
+
+	return aead, nil
+}
+
+// encryptedChunkNonce returns the nonce of the chunk at the index of a content
+// encrypted by the [EncryptedCacher] with the noncePrefix.
+func encryptedChunkNonce(noncePrefix []byte, index int64) []byte {
+	nonce := make([]byte, len(noncePrefix)+4)
+	copy(nonce, noncePrefix)
+	binary.BigEndian.PutUint32(nonce[len(noncePrefix):], uint32(index))
+	return nonce
+}
+
+// encryptedChunkAdditionalData returns the additional data of a chunk of the
+// content of the cache for the name encrypted by the [EncryptedCacher]. The
+// final indicates whether the chunk is the last one, so that truncations are
+// detected.
+func encryptedChunkAdditionalData(name string, final bool) []byte {
+	ad := make([]byte, 1, 1+len(name))
+	if final {
+		ad[0] = 1
+	}
+
+	return append(ad, name...)
+}
+
+// encryptingReader is the [io.ReadSeeker] of the encrypted content of a
+// plaintext content, which is encrypted chunk by chunk as it is read.
+type encryptingReader struct {
+	aead    cipher.AEAD
+	name    string
+	header  []byte
+	content io.ReadSeeker
+	size    int64
+	offset  int64
+	chunk   int64
+	sealed  []byte
+	plain   []byte
+}
+
+// chunks returns the number of the chunks of the er.
+func (er *encryptingReader) chunks() int64 {
+	if er.size == 0 {
+		return 1
+	}
+
+	return (er.size + encryptedChunkSize - 1) / encryptedChunkSize
+}
+
+// encryptedSize returns the size of the encrypted content of the er.
+func (er *encryptingReader) encryptedSize() int64 {
+	return int64(encryptedHeaderSize) +
+		er.size +
+		er.chunks()*encryptedTagSize
+}
+
+// Read implements the [io.Reader].
+func (er *encryptingReader) Read(p []byte) (int, error) {
+	if er.offset >= er.encryptedSize() {
+		return 0, io.EOF
+	}
+
+	if er.offset < encryptedHeaderSize {
+		n := copy(p, er.header[er.offset:])
+		er.offset += int64(n)
+		return n, nil
+	}
+
+	offset := er.offset - encryptedHeaderSize
+	chunk := offset / encryptedSealedChunkSize
+	if chunk != er.chunk {
+		if err := er.seal(chunk); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, er.sealed[offset-chunk*encryptedSealedChunkSize:])
+	er.offset += int64(n)
+
+	return n, nil
+}
+
+// seal seals the chunk at the index into the er.sealed.
+func (er *encryptingReader) seal(index int64) error {
+	start := index * encryptedChunkSize
+	size := er.size - start
+	if size > encryptedChunkSize {
+		size = encryptedChunkSize
+	}
+
+	if er.plain == nil {
+		er.plain = make([]byte, encryptedChunkSize)
+	}
+
+	if _, err := er.content.Seek(start, io.SeekStart); err != nil {
+		return err
+	} else if _, err := io.ReadFull(
+		er.content,
+		er.plain[:size],
+	); err != nil {
+		return err
+	}
+
+	er.sealed = er.aead.Seal(
+		er.sealed[:0],
+		encryptedChunkNonce(er.header[len(encryptedMagic):], index),
+		er.plain[:size],
+		encryptedChunkAdditionalData(er.name, index == er.chunks()-1),
+	)
+	er.chunk = index
+
+	return nil
+}
+
+// Seek implements the [io.Seeker].
+func (er *encryptingReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += er.offset
+	case io.SeekEnd:
+		offset += er.encryptedSize()
+	default:
+		return 0, errors.New("invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	er.offset = offset
+
+	return offset, nil
+}
+
+// decryptedContent is the content of a cache got by the [EncryptedCacher],
+// which is decrypted chunk by chunk as it is read.
+type decryptedContent struct {
+	aead        cipher.AEAD
+	name        string
+	noncePrefix []byte
+	content     io.ReadCloser
+	br          *bufio.Reader
+	sealed      []byte
+	plain       []byte
+	final       bool
+	start       int64
+	next        int64
+	offset      int64
+	size        int64
+}
+
+// load reads the chunk at the index from the dc.content and decrypts it into
+// the dc.plain.
+func (dc *decryptedContent) load(index int64) error {
+	if index != dc.next {
+		s, ok := dc.content.(io.Seeker)
+		if !ok {
+			return errors.New("encrypted cache is not seekable")
+		}
+
+		if _, err := s.Seek(
+			encryptedHeaderSize+index*encryptedSealedChunkSize,
+			io.SeekStart,
+		); err != nil {
+			return err
+		}
+
+		dc.br.Reset(dc.content)
+	}
+
+	dc.next = -1
+
+	n, err := io.ReadFull(dc.br, dc.sealed)
+	final := true
+	if err == nil {
+		if _, err := dc.br.Peek(1); err == nil {
+			final = false
+		} else if err != io.EOF {
+			return err
+		}
+	} else if err == io.EOF && index > 0 {
+		// There is no such chunk, since the last chunk always has
+		// its authentication tag at least.
+		return io.EOF
+	} else if err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+
+	plain, err := dc.aead.Open(
+		dc.plain[:0],
+		encryptedChunkNonce(dc.noncePrefix, index),
+		dc.sealed[:n],
+		encryptedChunkAdditionalData(dc.name, final),
+	)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errEncryptedCacheCorrupted, dc.name)
+	}
+
+	dc.plain = plain
+	dc.final = final
+	dc.start = index * encryptedChunkSize
+	dc.next = index + 1
+
+	return nil
+}
+
+// Read implements the [io.Reader].
+func (dc *decryptedContent) Read(p []byte) (int, error) {
+	for {
+		end := dc.start + int64(len(dc.plain))
+		if dc.offset >= dc.start && dc.offset < end {
+			n := copy(p, dc.plain[dc.offset-dc.start:])
+			dc.offset += int64(n)
+			return n, nil
+		} else if dc.final && dc.offset >= end {
+			return 0, io.EOF
+		}
+
+		if err := dc.load(dc.offset / encryptedChunkSize); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Seek implements the [io.Seeker]. It is only exposed by the
+// [decryptedContent.wrap] when the dc.content implements the [io.Seeker].
+func (dc *decryptedContent) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += dc.offset
+	case io.SeekEnd:
+		size, err := dc.decryptedSize()
+		if err != nil {
+			return 0, err
+		}
+
+		offset += size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	dc.offset = offset
+
+	return offset, nil
+}
+
+// decryptedSize returns the size of the decrypted content of the dc.
+func (dc *decryptedContent) decryptedSize() (int64, error) {
+	if dc.size >= 0 {
+		return dc.size, nil
+	}
+
+	s, ok := dc.content.(io.Seeker)
+	if !ok {
+		return 0, errors.New("encrypted cache is not seekable")
+	}
+
+	size, err := s.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+
+	// The position of the dc.content is no longer known.
+	dc.next = -1
+
+	size -= encryptedHeaderSize
+	chunks := (size + encryptedSealedChunkSize - 1) /
+		encryptedSealedChunkSize
+	if size-(chunks-1)*encryptedSealedChunkSize < encryptedTagSize {
+		return 0, fmt.Errorf(
+			"%w: %s",
+			errEncryptedCacheCorrupted,
+			dc.name,
+		)
+	}
+
+	dc.size = size - chunks*encryptedTagSize
+
+	return dc.size, nil
+}
+
+// LastModified returns the last modification time of the dc.content, if
+// known.
+func (dc *decryptedContent) LastModified() time.Time {
+	if lm, ok := dc.content.(interface{ LastModified() time.Time }); ok {
+		return lm.LastModified()
+	} else if mt, ok := dc.content.(interface{ ModTime() time.Time }); ok {
+		return mt.ModTime()
+	}
+
+	return time.Time{}
+}
+
+// ETag returns the ETag of the dc.content, if known. Since each put encrypts
+// with a new random nonce prefix, the ETag changes whenever the plaintext
+// does.
+func (dc *decryptedContent) ETag() string {
+	if et, ok := dc.content.(interface{ ETag() string }); ok {
+		return et.ETag()
+	}
+
+	return ""
+}
+
+// Expires returns the expiration time of the dc.content. It is only exposed by
+// the [decryptedContent.wrap] when the dc.content implements it.
+func (dc *decryptedContent) Expires() time.Time {
+	return dc.content.(interface{ Expires() time.Time }).Expires()
+}
+
+// Close implements the [io.Closer].
+func (dc *decryptedContent) Close() error {
+	return dc.content.Close()
+}
+
+// wrap returns the dc as an [io.ReadCloser] that only implements the
+// [io.Seeker] and the interface{ Expires() time.Time } if the dc.content does.
+func (dc *decryptedContent) wrap() io.ReadCloser {
+	type metadata interface {
+		LastModified() time.Time
+		ETag() string
+	}
+
+	type expirer interface {
+		Expires() time.Time
+	}
+
+	_, seekable := dc.content.(io.Seeker)
+	_, expiring := dc.content.(expirer)
+	switch {
+	case seekable && expiring:
+		return dc
+	case seekable:
+		return struct {
+			io.ReadSeeker
+			io.Closer
+			metadata
+		}{dc, dc, dc}
+	case expiring:
+		return struct {
+			io.ReadCloser
+			metadata
+			expirer
+		}{dc, dc, dc}
+	}
+
+	return struct {
+		io.ReadCloser
+		metadata
+	}{dc, dc}
+}
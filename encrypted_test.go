@@ -0,0 +1,340 @@
+package goproxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// nonSeekableCacher is a [Cacher] whose caches got are neither seekable nor
+// aware of their expiration times.
+type nonSeekableCacher struct {
+	Cacher
+}
+
+// Get implements the [Cacher].
+func (nsc nonSeekableCacher) Get(
+	ctx context.Context,
+	name string,
+) (io.ReadCloser, error) {
+	content, err := nsc.Cacher.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.NopCloser(content), nil
+}
+
+func TestEncryptedCacher(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestEncryptedCacher")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	key := bytes.Repeat([]byte{1}, 32)
+	keyFunc := func(context.Context) ([]byte, error) { return key, nil }
+	dc := DirCacher(tempDir)
+	ec := &EncryptedCacher{Cacher: dc, Key: keyFunc}
+
+	for n, size := range []int{
+		0,
+		1,
+		encryptedChunkSize,
+		encryptedChunkSize + 1,
+		3*encryptedChunkSize + 5,
+	} {
+		want := make([]byte, size)
+		for i := range want {
+			want[i] = byte(i % 251)
+		}
+
+		name := "example.com/@v/v1.0.0.zip"
+		if err := ec.Put(
+			context.Background(),
+			name,
+			bytes.NewReader(want),
+			time.Hour,
+		); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", n, err)
+		}
+
+		raw, err := dc.Get(context.Background(), name)
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", n, err)
+		}
+
+		rawSize, err := raw.(io.Seeker).Seek(0, io.SeekEnd)
+		raw.Close()
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", n, err)
+		}
+
+		er := &encryptingReader{size: int64(size)}
+		if got, want := rawSize, er.encryptedSize(); got != want {
+			t.Errorf("test(%d): got %d, want %d", n, got, want)
+		}
+
+		for _, c := range []Cacher{
+			ec,
+			&EncryptedCacher{
+				Cacher: nonSeekableCacher{dc},
+				Key:    keyFunc,
+			},
+		} {
+			content, err := c.Get(context.Background(), name)
+			if err != nil {
+				t.Fatalf("test(%d): unexpected error %q",
+					n, err)
+			}
+
+			got, err := ioutil.ReadAll(content)
+			content.Close()
+			if err != nil {
+				t.Fatalf("test(%d): unexpected error %q",
+					n, err)
+			} else if !bytes.Equal(got, want) {
+				t.Errorf("test(%d): got %d bytes, "+
+					"want %d bytes", n, len(got), len(want))
+			}
+		}
+
+		if size < 2 {
+			continue
+		}
+
+		content, err := ec.Get(context.Background(), name)
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", n, err)
+		}
+
+		rs, ok := content.(io.ReadSeeker)
+		if !ok {
+			t.Fatalf("test(%d): expected io.ReadSeeker", n)
+		} else if _, ok := content.(interface {
+			Expires() time.Time
+		}); !ok {
+			t.Fatalf("test(%d): expected Expires", n)
+		}
+
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Range", "bytes=1-")
+		http.ServeContent(rec, req, "", time.Time{}, rs)
+		content.Close()
+		if got, wantCode := rec.Code,
+			http.StatusPartialContent; got != wantCode {
+			t.Fatalf("test(%d): got %d, want %d", n, got, wantCode)
+		} else if !bytes.Equal(rec.Body.Bytes(), want[1:]) {
+			t.Errorf("test(%d): got %d bytes, want %d bytes",
+				n, rec.Body.Len(), len(want)-1)
+		}
+	}
+
+	content, err := (&EncryptedCacher{
+		Cacher: nonSeekableCacher{dc},
+		Key:    keyFunc,
+	}).Get(context.Background(), "example.com/@v/v1.0.0.zip")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	content.Close()
+	if _, ok := content.(io.Seeker); ok {
+		t.Error("unexpected io.Seeker")
+	} else if _, ok := content.(interface{ Expires() time.Time }); ok {
+		t.Error("unexpected Expires")
+	}
+
+	// The plaintext is never stored.
+	if err := ec.Put(
+		context.Background(),
+		"example.com/@v/list",
+		strings.NewReader("v1.0.0\nv1.1.0"),
+		time.Hour,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(tempDir, "example.com/@v/list"))
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if bytes.Contains(b, []byte("v1.0.0")) {
+		t.Errorf("got %q, want encrypted", b)
+	}
+
+	// Caches put with other keys, unencrypted or under other names are
+	// not found.
+	if _, err := (&EncryptedCacher{
+		Cacher: dc,
+		Key: func(context.Context) ([]byte, error) {
+			return bytes.Repeat([]byte{2}, 32), nil
+		},
+	}).Get(context.Background(), "example.com/@v/list"); !errors.Is(
+		err,
+		os.ErrNotExist,
+	) {
+		t.Fatalf("got error %q, want error %q", err, os.ErrNotExist)
+	}
+
+	for name, content := range map[string][]byte{
+		"example.com/@latest":       []byte(`{"Version":"v1.1.0"}`),
+		"example.org/@v/list":       b,
+		"example.com/@v/v1.1.0.mod": []byte(encryptedMagic + "\x00"),
+	} {
+		if err := dc.Put(
+			context.Background(),
+			name,
+			bytes.NewReader(content),
+			time.Hour,
+		); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		if _, err := ec.Get(
+			context.Background(),
+			name,
+		); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("%s: got error %q, want error %q",
+				name, err, os.ErrNotExist)
+		}
+	}
+
+	// Tampering after the first chunk and truncations are detected.
+	want := bytes.Repeat([]byte("x"), 3*encryptedChunkSize)
+	if err := ec.Put(
+		context.Background(),
+		"example.com/@v/v1.1.0.zip",
+		bytes.NewReader(want),
+		time.Hour,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	zipFile := filepath.Join(tempDir, "example.com/@v/v1.1.0.zip")
+	b, err = ioutil.ReadFile(zipFile)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	for n, tampered := range [][]byte{
+		append(append([]byte{}, b[:len(b)-1]...), b[len(b)-1]^1),
+		b[:encryptedHeaderSize+2*encryptedSealedChunkSize],
+	} {
+		if err := ioutil.WriteFile(
+			zipFile,
+			tampered,
+			0640,
+		); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", n, err)
+		}
+
+		content, err := ec.Get(
+			context.Background(),
+			"example.com/@v/v1.1.0.zip",
+		)
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", n, err)
+		}
+
+		_, err = ioutil.ReadAll(content)
+		content.Close()
+		if !errors.Is(err, errEncryptedCacheCorrupted) {
+			t.Errorf("test(%d): got error %q, want error %q",
+				n, err, errEncryptedCacheCorrupted)
+		}
+	}
+
+	var names []string
+	it := ec.List(context.Background(), "example.com/@v/v1.")
+	for it.Next() {
+		names = append(names, it.Cache().Name)
+	}
+
+	if err := it.Close(); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := strings.Join(names, " "),
+		"example.com/@v/v1.0.0.zip example.com/@v/v1.1.0.mod "+
+			"example.com/@v/v1.1.0.zip"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if err := (&EncryptedCacher{Cacher: dc}).Put(
+		context.Background(),
+		"example.com/@v/list",
+		strings.NewReader(""),
+		time.Hour,
+	); err == nil {
+		t.Fatal("expected error")
+	}
+
+	if err := (&EncryptedCacher{
+		Cacher: dc,
+		Key: func(context.Context) ([]byte, error) {
+			return []byte("short"), nil
+		},
+	}).Put(
+		context.Background(),
+		"example.com/@v/list",
+		strings.NewReader(""),
+		time.Hour,
+	); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestEncryptionKeys(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestEncryptionKeys")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	want := bytes.Repeat([]byte{1}, 32)
+	encoded := base64.StdEncoding.EncodeToString(want)
+
+	keyFile := filepath.Join(tempDir, "key")
+	if err := ioutil.WriteFile(
+		keyFile,
+		[]byte(encoded+"\n"),
+		0600,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	os.Setenv("GOPROXY_TEST_ENCRYPTION_KEY", encoded)
+	defer os.Unsetenv("GOPROXY_TEST_ENCRYPTION_KEY")
+
+	for n, key := range []func(context.Context) ([]byte, error){
+		FileEncryptionKey(keyFile),
+		EnvEncryptionKey("GOPROXY_TEST_ENCRYPTION_KEY"),
+	} {
+		if got, err := key(context.Background()); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", n, err)
+		} else if !bytes.Equal(got, want) {
+			t.Errorf("test(%d): got %x, want %x", n, got, want)
+		}
+	}
+
+	for n, key := range []func(context.Context) ([]byte, error){
+		FileEncryptionKey(filepath.Join(tempDir, "missing")),
+		EnvEncryptionKey("GOPROXY_TEST_MISSING_ENCRYPTION_KEY"),
+	} {
+		if _, err := key(context.Background()); err == nil {
+			t.Fatalf("test(%d): expected error", n)
+		}
+	}
+
+	if _, err := decodeEncryptionKey("not base64!"); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,93 @@
+package goproxy
+
+import (
+	"fmt"
+	"net/http"
+
+	"golang.org/x/mod/module"
+)
+
+// explanation describes how the [Goproxy] handles a module.
+type explanation struct {
+	Module  string
+	Version string `json:",omitempty"`
+
+	// GOPROXY is the effective proxy list used to fetch the module. It is
+	// "direct" when the module matches the GONOPROXY.
+	GOPROXY string
+
+	// GONOPROXYPattern is the GONOPROXY (or GOPRIVATE) pattern that
+	// matches the module.
+	GONOPROXYPattern string `json:",omitempty"`
+
+	// GOSUMDB is the checksum database used to verify the module. It is
+	// "off" when the module is not required to be verified.
+	GOSUMDB string
+
+	// GONOSUMDBPattern is the GONOSUMDB (or GOPRIVATE) pattern that
+	// matches the module.
+	GONOSUMDBPattern string `json:",omitempty"`
+
+	// CacheNames are the names of the caches involved for the module.
+	CacheNames []string
+}
+
+// explain explains how the g handles the modulePath and the optional
+// moduleVersion.
+func (g *Goproxy) explain(modulePath, moduleVersion string) (*explanation, error) {
+	escapedModulePath, err := module.EscapePath(modulePath)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &explanation{
+		Module:  modulePath,
+		Version: moduleVersion,
+		GOPROXY: g.goBinEnvGOPROXY,
+		GOSUMDB: g.goBinEnvGOSUMDB,
+		CacheNames: []string{
+			fmt.Sprint(escapedModulePath, "/@latest"),
+			fmt.Sprint(escapedModulePath, "/@v/list"),
+		},
+	}
+
+	e.GONOPROXYPattern = globsMatchedGlob(g.goBinEnvGONOPROXY, modulePath)
+	if e.GONOPROXYPattern != "" {
+		e.GOPROXY = "direct"
+	}
+
+	e.GONOSUMDBPattern = globsMatchedGlob(g.goBinEnvGONOSUMDB, modulePath)
+	if e.GONOSUMDBPattern != "" {
+		e.GOSUMDB = "off"
+	}
+
+	if moduleVersion != "" {
+		escapedModuleVersion, err := module.EscapeVersion(moduleVersion)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, ext := range []string{".info", ".mod", ".zip"} {
+			e.CacheNames = append(e.CacheNames, fmt.Sprint(
+				escapedModulePath,
+				"/@v/",
+				escapedModuleVersion,
+				ext,
+			))
+		}
+	}
+
+	return e, nil
+}
+
+// serveExplain serves explain requests.
+func (g *Goproxy) serveExplain(rw http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	e, err := g.explain(query.Get("module"), query.Get("version"))
+	if err != nil {
+		responseNotFound(rw, req, -2, err)
+		return
+	}
+
+	responseJSON(rw, req, -2, e)
+}
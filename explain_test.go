@@ -0,0 +1,98 @@
+package goproxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGoproxyExplain(t *testing.T) {
+	g := &Goproxy{GoBinEnv: []string{
+		"GOPROXY=https://example.com,direct",
+		"GOPRIVATE=*.corp.example.com",
+		"GONOSUMDB=example.com/private",
+	}}
+	g.init()
+
+	e, err := g.explain("example.com/foo", "")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := e.GOPROXY,
+		"https://example.com,direct"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	} else if got, want := e.GONOPROXYPattern, ""; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	} else if got, want := e.GOSUMDB, "sum.golang.org"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	} else if got, want := strings.Join(e.CacheNames, " "),
+		"example.com/foo/@latest example.com/foo/@v/list"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	e, err = g.explain("git.corp.example.com/Foo", "v1.0.0")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := e.GOPROXY, "direct"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	} else if got, want := e.GONOPROXYPattern,
+		"*.corp.example.com"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	} else if got, want := e.GOSUMDB, "sum.golang.org"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	} else if got, want := strings.Join(e.CacheNames, " "),
+		"git.corp.example.com/!foo/@latest "+
+			"git.corp.example.com/!foo/@v/list "+
+			"git.corp.example.com/!foo/@v/v1.0.0.info "+
+			"git.corp.example.com/!foo/@v/v1.0.0.mod "+
+			"git.corp.example.com/!foo/@v/v1.0.0.zip"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	e, err = g.explain("example.com/private/foo", "")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := e.GOSUMDB, "off"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	} else if got, want := e.GONOSUMDBPattern,
+		"example.com/private"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if _, err := g.explain("", ""); err == nil {
+		t.Fatal("expected error")
+	}
+
+	if _, err := g.explain("example.com", "v1.0.0\n"); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestGoproxyServeExplain(t *testing.T) {
+	g := &Goproxy{GoBinEnv: []string{"GOPROXY=off", "GOSUMDB=off"}}
+	g.init()
+
+	req := httptest.NewRequest("", "/-/explain?module=example.com", nil)
+	rec := httptest.NewRecorder()
+	g.serveExplain(rec, req)
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	var e explanation
+	if err := json.Unmarshal(rec.Body.Bytes(), &e); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := e.Module, "example.com"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	} else if got, want := e.GOPROXY, "off"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	req = httptest.NewRequest("", "/-/explain", nil)
+	rec = httptest.NewRecorder()
+	g.serveExplain(rec, req)
+	if got, want := rec.Code, http.StatusNotFound; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
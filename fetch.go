@@ -2,6 +2,7 @@ package goproxy
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -11,6 +12,7 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"path/filepath"
 	"sort"
 	"strings"
 	"time"
@@ -33,6 +35,14 @@ type fetch struct {
 	modAtVer         string
 	requiredToVerify bool
 	contentType      string
+
+	// rewrittenModulePath is the module path that the modulePath is
+	// rewritten to by the [Goproxy.PathRewrites], if any.
+	rewrittenModulePath string
+
+	// tee is the [downloadTee] that the module zip file is streamed to the
+	// client through while being downloaded, if any.
+	tee *downloadTee
 }
 
 // newFetch returns a new instance of the [fetch].
@@ -114,6 +124,21 @@ func newFetch(g *Goproxy, name, tempDir string) (*fetch, error) {
 		return nil, err
 	}
 
+	if err := checkModuleHost(
+		g.settings().BlockedModuleHosts,
+		f.modulePath,
+	); err != nil {
+		return nil, err
+	}
+
+	if pr := g.pathRewrite(f.modulePath); pr != nil {
+		now := time.Now()
+		f.rewrittenModulePath, err = pr.rewrite(f.modulePath, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	f.modAtVer = fmt.Sprint(f.modulePath, "@", f.moduleVersion)
 	f.requiredToVerify = g.goBinEnvGOSUMDB != "off" &&
 		!globsMatchPath(g.goBinEnvGONOSUMDB, f.modulePath)
@@ -122,24 +147,81 @@ func newFetch(g *Goproxy, name, tempDir string) (*fetch, error) {
 }
 
 // do executes the f.
-func (f *fetch) do(ctx context.Context) (*fetchResult, error) {
+func (f *fetch) do(ctx context.Context) (_ *fetchResult, err error) {
+	endFetch := f.g.metrics.startFetch(f.ops)
+	defer func() { endFetch(err) }()
+
+	if timeout := f.g.fetchTimeout(f.ops); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	if f.rewrittenModulePath != "" {
+		return f.doRewrite(ctx)
+	}
+
+	if f.g.Fetcher != nil {
+		return f.doFetcher(ctx, f.g.Fetcher)
+	}
+
+	return f.doUpstream(ctx)
+}
+
+// doUpstream executes the f via the GOPROXY or directly, which is what the
+// [Goproxy.UpstreamFetcher] does.
+func (f *fetch) doUpstream(ctx context.Context) (*fetchResult, error) {
 	if globsMatchPath(f.g.goBinEnvGONOPROXY, f.modulePath) {
-		return f.doDirect(ctx)
+		r, err := f.doDirect(ctx)
+		if err == nil && f.doubleFetchRequired("direct") {
+			err = f.doubleFetch(ctx, "direct", r)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		return r, nil
+	}
+
+	if f.ops == fetchOpsList && f.g.settings().MergeLists {
+		return f.doMergeList(ctx)
 	}
 
-	var r *fetchResult
+	var (
+		r       *fetchResult
+		goneErr error
+	)
 	if err := walkGOPROXY(f.g.goBinEnvGOPROXY, func(proxy string) error {
 		var err error
 		r, err = f.doProxy(ctx, proxy)
-		return err
+		if err == nil && f.doubleFetchRequired(proxy) {
+			err = f.doubleFetch(ctx, proxy, r)
+		}
+
+		if errors.Is(err, errUpstreamGone) && goneErr == nil {
+			goneErr = err
+		}
+
+		return f.tee.checkErr(err)
 	}, func() error {
 		var err error
 		r, err = f.doDirect(ctx)
+		if err == nil && f.doubleFetchRequired("direct") {
+			err = f.doubleFetch(ctx, "direct", r)
+		}
+
 		return err
 	}, func() error {
 		// go/src/cmd/go/internal/modfetch.errProxyOff
 		return notFoundError("module lookup disabled by GOPROXY=off")
 	}); err != nil {
+		// A module version is only taken as gone once the whole GOPROXY
+		// has been tried, since a later entry may still serve it.
+		if goneErr != nil {
+			f.g.handleUpstreamGone(ctx, f, goneErr)
+		}
+
 		return nil, err
 	}
 
@@ -156,17 +238,53 @@ func (f *fetch) doProxy(
 		return nil, err
 	}
 
+	ctx = f.g.withDebugModule(ctx, f.modulePath)
+	ctx = withUpstreamRetry(ctx, f.g.upstreamRetry)
+
 	tempFile, err := ioutil.TempFile(f.tempDir, "")
 	if err != nil {
 		return nil, err
 	}
 
-	if err := httpGet(
+	// Downloads are immutable, so only the caches of resolves and lists
+	// are worth revalidating.
+	var cachedHeaders *upstreamHeaders
+	revalidatable := f.g.settings().UpstreamCacheHeaders &&
+		(f.ops == fetchOpsResolve || f.ops == fetchOpsList)
+	if revalidatable {
+		cachedHeaders = f.g.upstreamHeaders(ctx, f.name)
+	}
+
+	var dst io.Writer = tempFile
+	if f.tee != nil {
+		dst = f.tee.writer(tempFile)
+	}
+
+	resHeader, err := httpGetWithHeader(
 		ctx,
 		f.g.httpClient,
 		appendURL(proxyURL, f.name).String(),
-		tempFile,
-	); err != nil {
+		cachedHeaders.conditionalHeader(),
+		dst,
+	)
+	if errors.Is(err, errNotModified) {
+		if err = f.copyCache(ctx, tempFile); err != nil {
+			// The cache has gone since being revalidated, so the
+			// request is made again unconditionally.
+			if err = resetWriter(tempFile); err == nil {
+				cachedHeaders = nil
+				resHeader, err = httpGetWithHeader(
+					ctx,
+					f.g.httpClient,
+					appendURL(proxyURL, f.name).String(),
+					nil,
+					tempFile,
+				)
+			}
+		}
+	}
+
+	if err != nil {
 		return nil, err
 	}
 
@@ -175,6 +293,35 @@ func (f *fetch) doProxy(
 	}
 
 	r := &fetchResult{f: f}
+	if f.g.settings().UpstreamCacheHeaders {
+		uh, lifetime := parseUpstreamHeaders(resHeader, time.Now())
+		if lifetime > 0 {
+			r.Expiration = lifetime
+		}
+
+		if revalidatable {
+			if cachedHeaders != nil {
+				// A 304 Not Modified may omit the validators.
+				if uh.ETag == "" {
+					uh.ETag = cachedHeaders.ETag
+				}
+
+				if uh.LastModified == "" {
+					uh.LastModified =
+						cachedHeaders.LastModified
+				}
+			}
+
+			r.upstreamHeaders = uh
+		}
+	}
+
+	if f.ops == fetchOpsResolve || f.ops == fetchOpsDownloadInfo {
+		if err := f.verifyInfoTime(ctx, tempFile.Name()); err != nil {
+			return nil, err
+		}
+	}
+
 	switch f.ops {
 	case fetchOpsResolve:
 		b, err := ioutil.ReadFile(tempFile.Name())
@@ -211,61 +358,178 @@ func (f *fetch) doProxy(
 			return semver.Compare(r.Versions[i], r.Versions[j]) < 0
 		})
 	case fetchOpsDownloadInfo:
-		if err := checkAndFormatInfoFile(tempFile.Name()); err != nil {
+		if err := f.checkDownloadFile(tempFile.Name()); err != nil {
 			return nil, err
 		}
 
 		r.Info = tempFile.Name()
 	case fetchOpsDownloadMod:
-		if err := checkModFile(tempFile.Name()); err != nil {
+		if err := f.checkDownloadFile(tempFile.Name()); err != nil {
 			return nil, err
 		}
 
-		if f.requiredToVerify {
-			if err := verifyModFile(
-				f.g.sumdbClient,
-				tempFile.Name(),
-				f.modulePath,
-				f.moduleVersion,
-			); err != nil {
-				return nil, err
-			}
+		r.GoMod = tempFile.Name()
+	case fetchOpsDownloadZip:
+		if err := f.checkDownloadFile(tempFile.Name()); err != nil {
+			return nil, err
 		}
 
-		r.GoMod = tempFile.Name()
+		r.Zip = tempFile.Name()
+	}
+
+	return r, nil
+}
+
+// copyCache copies the cache for the f into the dst.
+func (f *fetch) copyCache(ctx context.Context, dst io.Writer) error {
+	content, err := f.g.cache(ctx, f.name)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	_, err = io.Copy(dst, content)
+	return err
+}
+
+// checkDownloadFile checks the module file targeted by the name that has been
+// downloaded for the f without the local go command, and verifies it against
+// the checksum database if required. Info files are also formatted in place.
+func (f *fetch) checkDownloadFile(name string) error {
+	if err := f.checkFile(name); err != nil {
+		return err
+	}
+
+	if !f.requiredToVerify {
+		return nil
+	}
+
+	switch f.ops {
+	case fetchOpsDownloadMod:
+		return verifyModFile(
+			f.g.sumdbClient,
+			name,
+			f.modulePath,
+			f.moduleVersion,
+		)
 	case fetchOpsDownloadZip:
-		if err := checkZipFile(
-			tempFile.Name(),
+		return verifyZipFile(
+			f.g.sumdbClient,
+			name,
 			f.modulePath,
 			f.moduleVersion,
-		); err != nil {
+		)
+	}
+
+	return nil
+}
+
+// checkFile checks the module file targeted by the name for the f, without
+// verifying it against the checksum database. Info files are also formatted in
+// place.
+func (f *fetch) checkFile(name string) error {
+	switch f.ops {
+	case fetchOpsDownloadInfo:
+		return checkAndFormatInfoFile(name)
+	case fetchOpsDownloadMod:
+		return checkModFile(name)
+	case fetchOpsDownloadZip:
+		return checkZipFile(name, f.modulePath, f.moduleVersion)
+	}
+
+	return errors.New("invalid fetch operation")
+}
+
+// doDirect executes the f directly, either in-process (see the
+// [Goproxy.NativeDirectFetches]) or using the local go command.
+func (f *fetch) doDirect(ctx context.Context) (*fetchResult, error) {
+	if f.g.settings().ProxiedOnly {
+		return nil, forbiddenError(fmt.Sprintf(
+			"direct fetching is disabled by the proxy: %s",
+			f.modAtVer,
+		))
+	}
+
+	if err := f.checkGoBinVCS(); err != nil {
+		return nil, err
+	}
+
+	if err := checkModuleIPs(
+		ctx,
+		f.g.BlockedModuleIPNets,
+		f.modulePath,
+	); err != nil {
+		return nil, err
+	}
+
+	if f.g.goBinWorkers != nil {
+		if err := f.g.goBinWorkers.acquire(ctx, f.modulePath); err != nil {
 			return nil, err
 		}
+		defer f.g.goBinWorkers.release(f.modulePath)
+	}
+
+	var (
+		r   *fetchResult
+		err error
+	)
+	if f.g.NativeDirectFetches {
+		r, err = f.doNative(ctx)
+	}
+
+	if !f.g.NativeDirectFetches || err == errNativeFetchUnsupported {
+		r, err = f.doGoBin(ctx)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch f.ops {
+	case fetchOpsList:
+		sort.Slice(r.Versions, func(i, j int) bool {
+			return semver.Compare(r.Versions[i], r.Versions[j]) < 0
+		})
+	case fetchOpsDownloadInfo, fetchOpsDownloadMod, fetchOpsDownloadZip:
+		if err := checkAndFormatInfoFile(r.Info); err != nil {
+			return nil, err
+		}
+
+		if f.g.DeterministicZips && r.Zip != "" {
+			repackedZip := filepath.Join(f.tempDir, "repacked.zip")
+			if err := repackZip(r.Zip, repackedZip); err != nil {
+				return nil, err
+			}
+
+			r.Zip = repackedZip
+		}
 
 		if f.requiredToVerify {
+			if err := verifyModFile(
+				f.g.sumdbClient,
+				r.GoMod,
+				f.modulePath,
+				f.moduleVersion,
+			); err != nil {
+				return nil, err
+			}
+
 			if err := verifyZipFile(
 				f.g.sumdbClient,
-				tempFile.Name(),
+				r.Zip,
 				f.modulePath,
 				f.moduleVersion,
 			); err != nil {
 				return nil, err
 			}
 		}
-
-		r.Zip = tempFile.Name()
 	}
 
 	return r, nil
 }
 
-// doDirect executes the f directly using the local go command.
-func (f *fetch) doDirect(ctx context.Context) (*fetchResult, error) {
-	if f.g.goBinWorkerChan != nil {
-		f.g.goBinWorkerChan <- struct{}{}
-		defer func() { <-f.g.goBinWorkerChan }()
-	}
-
+// doGoBin executes the f directly by running the Go binary.
+func (f *fetch) doGoBin(ctx context.Context) (*fetchResult, error) {
 	var args []string
 	switch f.ops {
 	case fetchOpsResolve:
@@ -276,12 +540,18 @@ func (f *fetch) doDirect(ctx context.Context) (*fetchResult, error) {
 		args = []string{"mod", "download", "-json", f.modAtVer}
 	}
 
-	cmd := exec.CommandContext(ctx, f.g.goBinName, args...)
+	cmd, err := f.g.GoBinSandbox.command(f.g.goBinName, args...)
+	if err != nil {
+		return nil, err
+	}
+
 	cmd.Env = f.g.goBinEnv
 	cmd.Dir = f.tempDir
-	stdout, err := cmd.Output()
+	startTime := time.Now()
+	stdout, err := commandOutput(ctx, cmd, f.g.GoBinSandbox.started)
+	f.g.recordGoCommand(cmd, startTime, err)
 	if err != nil {
-		if err := ctx.Err(); errors.Is(err, context.DeadlineExceeded) {
+		if err := ctx.Err(); err != nil {
 			return nil, fmt.Errorf("command %v: %w", cmd.Args, err)
 		}
 
@@ -312,6 +582,13 @@ func (f *fetch) doDirect(ctx context.Context) (*fetchResult, error) {
 		msg = strings.TrimPrefix(msg, "go list -m: ")
 		msg = strings.TrimRight(msg, "\n")
 
+		// The GOVCS policy is also enforced by the Go binary for the
+		// modules whose version control systems are only known once
+		// their import paths have been resolved.
+		if strings.Contains(msg, "GOVCS disallows") {
+			return nil, forbiddenError(msg)
+		}
+
 		return nil, notFoundError(msg)
 	}
 
@@ -320,38 +597,80 @@ func (f *fetch) doDirect(ctx context.Context) (*fetchResult, error) {
 		return nil, err
 	}
 
-	switch f.ops {
-	case fetchOpsList:
-		sort.Slice(r.Versions, func(i, j int) bool {
-			return semver.Compare(r.Versions[i], r.Versions[j]) < 0
-		})
-	case fetchOpsDownloadInfo, fetchOpsDownloadMod, fetchOpsDownloadZip:
-		if err := checkAndFormatInfoFile(r.Info); err != nil {
+	return r, nil
+}
+
+// checkGoBinVCS checks whether the version control system used to fetch the
+// f.modulePath directly is allowed by the [Goproxy.GoBinVCS] and the
+// [Goproxy.GoBinAllowedVCS], which are merged into one policy. Modules whose
+// version control systems cannot be determined in advance are left to the Go
+// binary.
+func (f *fetch) checkGoBinVCS() error {
+	if f.g.goBinVCSErr != nil {
+		return f.g.goBinVCSErr
+	} else if f.g.goBinVCSRules == nil {
+		return nil
+	}
+
+	vcs := modulePathVCS(f.modulePath)
+	if vcs == "" {
+		return nil
+	}
+
+	return checkGOVCS(
+		f.g.goBinVCSRules,
+		f.modulePath,
+		vcs,
+		globsMatchPath(f.g.goBinEnvGOPRIVATE, f.modulePath),
+	)
+}
+
+// commandOutput runs the cmd and returns its standard output. Unlike the
+// [exec.Cmd.Output], it kills the cmd and all of its child processes as soon as
+// the ctx is done, so that abandoned fetches stop consuming upstream bandwidth.
+//
+// The optional onStarted is called right after the cmd has been started. If it
+// returns an error, the cmd is killed and the error is returned.
+func commandOutput(
+	ctx context.Context,
+	cmd *exec.Cmd,
+	onStarted func(cmd *exec.Cmd) error,
+) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	prepareCommand(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	if onStarted != nil {
+		if err := onStarted(cmd); err != nil {
+			killCommand(cmd)
+			cmd.Wait()
 			return nil, err
 		}
+	}
 
-		if f.requiredToVerify {
-			if err := verifyModFile(
-				f.g.sumdbClient,
-				r.GoMod,
-				f.modulePath,
-				f.moduleVersion,
-			); err != nil {
-				return nil, err
-			}
+	waitDone := make(chan struct{})
+	defer close(waitDone)
+	go func() {
+		select {
+		case <-ctx.Done():
+			killCommand(cmd)
+		case <-waitDone:
+		}
+	}()
 
-			if err := verifyZipFile(
-				f.g.sumdbClient,
-				r.Zip,
-				f.modulePath,
-				f.moduleVersion,
-			); err != nil {
-				return nil, err
-			}
+	if err := cmd.Wait(); err != nil {
+		if ee, ok := err.(*exec.ExitError); ok {
+			ee.Stderr = stderr.Bytes()
 		}
+
+		return stdout.Bytes(), err
 	}
 
-	return r, nil
+	return stdout.Bytes(), nil
 }
 
 // fetchOps is the operation of the [fetch].
@@ -395,6 +714,13 @@ type fetchResult struct {
 	Info     string
 	GoMod    string
 	Zip      string
+
+	// Expiration is the expiration of the caches of the fr as allowed by
+	// the upstream. It is zero if the upstream does not specify it (see
+	// the [Goproxy.UpstreamCacheHeaders]).
+	Expiration time.Duration
+
+	upstreamHeaders *upstreamHeaders
 }
 
 // Open opens the content of the fr.
@@ -407,11 +733,7 @@ func (fr *fetchResult) Open() (readSeekCloser, error) {
 			io.Seeker
 		}{nopCloser{content}, content}, nil
 	case fetchOpsList:
-		content := strings.NewReader(strings.Join(fr.Versions, "\n"))
-		return struct {
-			io.ReadCloser
-			io.Seeker
-		}{nopCloser{content}, content}, nil
+		return newListReader(fr.Versions), nil
 	case fetchOpsDownloadInfo:
 		return os.Open(fr.Info)
 	case fetchOpsDownloadMod:
@@ -423,6 +745,103 @@ func (fr *fetchResult) Open() (readSeekCloser, error) {
 	return nil, errors.New("invalid fetch operation")
 }
 
+// streamedListMinVersions is the minimum number of versions that a list
+// response must have to be streamed to the client (see [responseStream])
+// rather than being served as a whole.
+const streamedListMinVersions = 1024
+
+// listReader is a [readSeekCloser] that reads the versions of a list response
+// separated by newlines without building the whole content in memory.
+type listReader struct {
+	versions []string
+	size     int64
+	offset   int64
+	i        int // index of the version that the offset is in
+	j        int // offset within the versions[i] and its trailing newline
+}
+
+// newListReader returns a new instance of the [listReader] for the versions.
+func newListReader(versions []string) *listReader {
+	lr := &listReader{versions: versions}
+	for i, v := range versions {
+		lr.size += int64(len(v))
+		if i > 0 {
+			lr.size++
+		}
+	}
+
+	return lr
+}
+
+// Read implements the [io.Reader].
+func (lr *listReader) Read(b []byte) (int, error) {
+	if lr.offset >= lr.size {
+		return 0, io.EOF
+	}
+
+	n := 0
+	for n < len(b) && lr.i < len(lr.versions) {
+		v := lr.versions[lr.i]
+		if lr.j < len(v) {
+			c := copy(b[n:], v[lr.j:])
+			n += c
+			lr.j += c
+			continue
+		}
+
+		if lr.i < len(lr.versions)-1 {
+			b[n] = '\n'
+			n++
+		}
+
+		lr.i++
+		lr.j = 0
+	}
+
+	lr.offset += int64(n)
+	return n, nil
+}
+
+// Seek implements the [io.Seeker].
+func (lr *listReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += lr.offset
+	case io.SeekEnd:
+		offset += lr.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	lr.offset = offset
+	lr.i, lr.j = len(lr.versions), 0
+	for i, v := range lr.versions {
+		l := int64(len(v))
+		if i < len(lr.versions)-1 {
+			l++
+		}
+
+		if offset < l {
+			lr.i, lr.j = i, int(offset)
+			break
+		}
+
+		offset -= l
+	}
+
+	return lr.offset, nil
+}
+
+// Close implements the [io.Closer].
+func (*listReader) Close() error {
+	return nil
+}
+
 // marshalInfo marshals the version and t as info.
 func marshalInfo(version string, t time.Time) string {
 	return fmt.Sprintf(
@@ -510,12 +929,7 @@ func verifyModFile(
 		return err
 	}
 
-	modHash, err := dirhash.DefaultHash(
-		[]string{"go.mod"},
-		func(string) (io.ReadCloser, error) {
-			return os.Open(name)
-		},
-	)
+	modHash, err := hashModFile(name)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,14 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package goproxy
+
+import "os/exec"
+
+// prepareCommand prepares the cmd to be started.
+func prepareCommand(cmd *exec.Cmd) {}
+
+// killCommand kills the process of the started cmd.
+func killCommand(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
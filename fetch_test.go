@@ -1219,6 +1219,52 @@ func TestFetchDoDirect(t *testing.T) {
 	}
 }
 
+func TestFetchDoDirectCanceled(t *testing.T) {
+	g := &Goproxy{GoBinMaxWorkers: 1}
+	g.init()
+	if err := g.goBinWorkers.acquire(
+		context.Background(),
+		"example.com",
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	f, err := newFetch(g, "example.com/@latest", "")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := f.doDirect(ctx); err == nil {
+		t.Fatal("expected error")
+	} else if got, want := err, context.Canceled; !errors.Is(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFetchDoDirectProxiedOnly(t *testing.T) {
+	g := &Goproxy{
+		GoBinEnv:    []string{"GOPROXY=off", "GONOPROXY=example.com"},
+		ProxiedOnly: true,
+	}
+	g.init()
+
+	f, err := newFetch(g, "example.com/@latest", "")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if _, err := f.do(context.Background()); err == nil {
+		t.Fatal("expected error")
+	} else if got, want := err, errForbidden; !errors.Is(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	} else if got, want := err.Error(), "direct fetching is disabled by "+
+		"the proxy: example.com@latest"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
 func TestFetchOpsString(t *testing.T) {
 	fo := fetchOpsResolve
 	if got, want := fo.String(), "resolve"; got != want {
@@ -1364,6 +1410,71 @@ func TestFetchResultOpen(t *testing.T) {
 	}
 }
 
+func TestListReader(t *testing.T) {
+	for _, versions := range [][]string{
+		nil,
+		{"v1.0.0"},
+		{"v1.0.0", "v1.1.0", "v1.10.0"},
+	} {
+		want := strings.Join(versions, "\n")
+
+		lr := newListReader(versions)
+		if b, err := ioutil.ReadAll(lr); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		} else if got := string(b); got != want {
+			t.Errorf("%q: got %q, want %q", versions, got, want)
+		}
+
+		if got, err := lr.Seek(0, io.SeekEnd); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		} else if want := int64(len(want)); got != want {
+			t.Errorf("%q: got %d, want %d", versions, got, want)
+		}
+
+		for offset := range want {
+			if _, err := lr.Seek(
+				int64(offset),
+				io.SeekStart,
+			); err != nil {
+				t.Fatalf("unexpected error %q", err)
+			}
+
+			b := make([]byte, 2)
+			n, err := io.ReadFull(lr, b)
+			if err != nil && err != io.ErrUnexpectedEOF {
+				t.Fatalf("unexpected error %q", err)
+			}
+
+			got, want := string(b[:n]), want[offset:offset+n]
+			if got != want {
+				t.Errorf("%q: got %q, want %q", versions, got, want)
+			}
+		}
+
+		if _, err := lr.Seek(-1, io.SeekStart); err == nil {
+			t.Fatal("expected error")
+		}
+	}
+
+	lr := newListReader([]string{"v1.0.0", "v1.1.0"})
+	b := make([]byte, 3)
+	if _, err := io.ReadFull(lr, b); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if got, err := lr.Seek(2, io.SeekCurrent); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if want := int64(5); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	if b, err := ioutil.ReadAll(lr); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := string(b), "0\nv1.1.0"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
 func TestMarshalInfo(t *testing.T) {
 	info := struct {
 		Version string
@@ -0,0 +1,29 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package goproxy
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// prepareCommand prepares the cmd to be started in its own process group so
+// that the [killCommand] can also kill all of its child processes (e.g. the
+// VCS tools invoked by the Go binary).
+func prepareCommand(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// killCommand kills the process group of the started cmd.
+func killCommand(cmd *exec.Cmd) error {
+	if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err != nil {
+		return cmd.Process.Kill()
+	}
+
+	return nil
+}
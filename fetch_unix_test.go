@@ -0,0 +1,61 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestCommandOutput(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+
+	stdout, err := commandOutput(
+		context.Background(),
+		exec.Command("sh", "-c", "echo foo; echo bar >&2; exit 1"),
+		nil,
+	)
+	if err == nil {
+		t.Fatal("expected error")
+	} else if got, want := string(stdout), "foo\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	var ee *exec.ExitError
+	if !errors.As(err, &ee) {
+		t.Fatalf("got %T, want *exec.ExitError", err)
+	} else if got, want := string(ee.Stderr), "bar\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	ctx, cancel := context.WithTimeout(
+		context.Background(),
+		100*time.Millisecond,
+	)
+	defer cancel()
+	startTime := time.Now()
+	if _, err := commandOutput(
+		ctx,
+		exec.Command("sh", "-c", "sleep 30 & sleep 30"),
+		nil,
+	); err == nil {
+		t.Fatal("expected error")
+	} else if d := time.Since(startTime); d > 10*time.Second {
+		t.Errorf("got %s, want less than 10s", d)
+	}
+
+	if _, err := commandOutput(
+		context.Background(),
+		exec.Command("sh", "-c", "sleep 30"),
+		func(*exec.Cmd) error { return errors.New("cannot start") },
+	); err == nil {
+		t.Fatal("expected error")
+	} else if got, want := err.Error(), "cannot start"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,295 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// Fetcher fetches module files from the sources of a [Goproxy], see the
+// [Goproxy.Fetcher]. The errors meaning that something does not exist should
+// wrap the [os.ErrNotExist], so that they are responded as such.
+type Fetcher interface {
+	// Resolve resolves the query (e.g. "latest", a branch name or a
+	// non-canonical version) of the modulePath into a canonical version
+	// and its time.
+	Resolve(
+		ctx context.Context,
+		modulePath string,
+		query string,
+	) (version string, t time.Time, err error)
+
+	// List returns the versions of the modulePath, in any order.
+	// Pseudo-versions are ignored.
+	List(ctx context.Context, modulePath string) ([]string, error)
+
+	// Download writes the module file with the ext (".info", ".mod" or
+	// ".zip") of the canonical version of the modulePath to the dst.
+	Download(
+		ctx context.Context,
+		modulePath string,
+		version string,
+		ext string,
+		dst io.Writer,
+	) error
+}
+
+// UpstreamFetcher returns the built-in [Fetcher] of the g, which fetches module
+// files via the GOPROXY or directly as instructed by the [Goproxy.GoBinEnv]. It
+// is what the g uses if the [Goproxy.Fetcher] is nil, and what custom ones can
+// fall back to.
+func (g *Goproxy) UpstreamFetcher() Fetcher {
+	g.initOnce.Do(g.init)
+	return &upstreamFetcher{g: g}
+}
+
+// upstreamFetcher is the [Fetcher] returned by the [Goproxy.UpstreamFetcher].
+type upstreamFetcher struct {
+	g *Goproxy
+}
+
+// Resolve implements the [Fetcher].
+func (uf *upstreamFetcher) Resolve(
+	ctx context.Context,
+	modulePath string,
+	query string,
+) (string, time.Time, error) {
+	escapedModulePath, err := module.EscapePath(modulePath)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	name := escapedModulePath + "/@latest"
+	if query != "latest" {
+		escapedQuery, err := module.EscapeVersion(query)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+
+		name = escapedModulePath + "/@v/" + escapedQuery + ".info"
+	}
+
+	var (
+		version string
+		t       time.Time
+	)
+	if err := uf.do(ctx, name, func(r *fetchResult) error {
+		if r.f.ops == fetchOpsResolve {
+			version, t = r.Version, r.Time
+			return nil
+		}
+
+		b, err := ioutil.ReadFile(r.Info)
+		if err != nil {
+			return err
+		}
+
+		version, t, err = unmarshalInfo(string(b))
+
+		return err
+	}); err != nil {
+		return "", time.Time{}, err
+	}
+
+	return version, t, nil
+}
+
+// List implements the [Fetcher].
+func (uf *upstreamFetcher) List(
+	ctx context.Context,
+	modulePath string,
+) ([]string, error) {
+	escapedModulePath, err := module.EscapePath(modulePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []string
+	if err := uf.do(
+		ctx,
+		escapedModulePath+"/@v/list",
+		func(r *fetchResult) error {
+			versions = r.Versions
+			return nil
+		},
+	); err != nil {
+		return nil, err
+	}
+
+	return versions, nil
+}
+
+// Download implements the [Fetcher].
+func (uf *upstreamFetcher) Download(
+	ctx context.Context,
+	modulePath string,
+	version string,
+	ext string,
+	dst io.Writer,
+) error {
+	escapedModulePath, err := module.EscapePath(modulePath)
+	if err != nil {
+		return err
+	}
+
+	escapedVersion, err := module.EscapeVersion(version)
+	if err != nil {
+		return err
+	}
+
+	return uf.do(
+		ctx,
+		escapedModulePath+"/@v/"+escapedVersion+ext,
+		func(r *fetchResult) error {
+			content, err := r.Open()
+			if err != nil {
+				return err
+			}
+			defer content.Close()
+
+			_, err = io.Copy(dst, content)
+
+			return err
+		},
+	)
+}
+
+// do fetches the module file targeted by the name via the
+// [fetch.doUpstream] and calls the fn with the result, before the temporary
+// files of the result are removed.
+func (uf *upstreamFetcher) do(
+	ctx context.Context,
+	name string,
+	fn func(r *fetchResult) error,
+) error {
+	tempDir, err := ioutil.TempDir(uf.g.TempDir, "goproxy")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	f, err := newFetch(uf.g, name, tempDir)
+	if err != nil {
+		return notFoundError(err.Error())
+	}
+
+	r, err := f.doUpstream(ctx)
+	if err != nil {
+		return err
+	}
+
+	return fn(r)
+}
+
+// doFetcher executes the f via the fetcher.
+func (f *fetch) doFetcher(
+	ctx context.Context,
+	fetcher Fetcher,
+) (*fetchResult, error) {
+	r := &fetchResult{f: f}
+	switch f.ops {
+	case fetchOpsResolve:
+		version, t, err := fetcher.Resolve(
+			ctx,
+			f.modulePath,
+			f.moduleVersion,
+		)
+		if err != nil {
+			return nil, fetcherError(err)
+		} else if !semver.IsValid(version) ||
+			semver.Canonical(version) != version {
+			return nil, notFoundError(fmt.Sprintf(
+				"invalid resolved version %q",
+				version,
+			))
+		}
+
+		r.Version, r.Time = version, t.UTC()
+	case fetchOpsList:
+		versions, err := fetcher.List(ctx, f.modulePath)
+		if err != nil {
+			return nil, fetcherError(err)
+		}
+
+		r.Versions = make([]string, 0, len(versions))
+		for _, v := range versions {
+			if semver.IsValid(v) && !module.IsPseudoVersion(v) {
+				r.Versions = append(r.Versions, v)
+			}
+		}
+
+		sort.Slice(r.Versions, func(i, j int) bool {
+			return semver.Compare(r.Versions[i], r.Versions[j]) < 0
+		})
+	case fetchOpsDownloadInfo, fetchOpsDownloadMod, fetchOpsDownloadZip:
+		var ext string
+		switch f.ops {
+		case fetchOpsDownloadInfo:
+			ext = ".info"
+		case fetchOpsDownloadMod:
+			ext = ".mod"
+		case fetchOpsDownloadZip:
+			ext = ".zip"
+		}
+
+		tempFile, err := ioutil.TempFile(f.tempDir, "")
+		if err != nil {
+			return nil, err
+		}
+
+		var dst io.Writer = tempFile
+		if f.tee != nil {
+			dst = f.tee.writer(tempFile)
+		}
+
+		if err := fetcher.Download(
+			ctx,
+			f.modulePath,
+			f.moduleVersion,
+			ext,
+			dst,
+		); err != nil {
+			tempFile.Close()
+			return nil, fetcherError(err)
+		}
+
+		if err := tempFile.Close(); err != nil {
+			return nil, err
+		}
+
+		if err := f.checkDownloadFile(tempFile.Name()); err != nil {
+			return nil, err
+		}
+
+		switch f.ops {
+		case fetchOpsDownloadInfo:
+			r.Info = tempFile.Name()
+		case fetchOpsDownloadMod:
+			r.GoMod = tempFile.Name()
+		case fetchOpsDownloadZip:
+			r.Zip = tempFile.Name()
+		}
+	default:
+		return nil, errors.New("invalid fetch operation")
+	}
+
+	return r, nil
+}
+
+// fetcherError returns the err returned by a [Fetcher] as a not found error if
+// it wraps the [os.ErrNotExist].
+func fetcherError(err error) error {
+	if errors.Is(err, os.ErrNotExist) && !errors.Is(err, errNotFound) {
+		return notFoundError(err.Error())
+	}
+
+	return err
+}
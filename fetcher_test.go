@@ -0,0 +1,276 @@
+package goproxy
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeFetcher is a [Fetcher] serving the module files of its files, keyed by
+// "<module-path>@<version><ext>".
+type fakeFetcher struct {
+	versions []string
+	files    map[string][]byte
+}
+
+// Resolve implements the [Fetcher].
+func (ff *fakeFetcher) Resolve(
+	ctx context.Context,
+	modulePath string,
+	query string,
+) (string, time.Time, error) {
+	if query == "latest" {
+		query = ff.versions[0]
+	}
+
+	if _, ok := ff.files[modulePath+"@"+query+".info"]; !ok {
+		err := fmt.Errorf("%s: %w", query, os.ErrNotExist)
+		return "", time.Time{}, err
+	}
+
+	return query, time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), nil
+}
+
+// List implements the [Fetcher].
+func (ff *fakeFetcher) List(
+	ctx context.Context,
+	modulePath string,
+) ([]string, error) {
+	return ff.versions, nil
+}
+
+// Download implements the [Fetcher].
+func (ff *fakeFetcher) Download(
+	ctx context.Context,
+	modulePath string,
+	version string,
+	ext string,
+	dst io.Writer,
+) error {
+	b, ok := ff.files[modulePath+"@"+version+ext]
+	if !ok {
+		return os.ErrNotExist
+	}
+
+	_, err := dst.Write(b)
+
+	return err
+}
+
+func TestGoproxyFetcher(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestGoproxyFetcher")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	var zipBuf bytes.Buffer
+	zw := zip.NewWriter(&zipBuf)
+	w, err := zw.Create("example.com/foo@v1.1.0/go.mod")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	w.Write([]byte("module example.com/foo"))
+	if err := zw.Close(); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	info := `{"Version":"v1.1.0","Time":"2000-01-01T00:00:00Z"}`
+	g := &Goproxy{
+		GoBinEnv: []string{"GOPROXY=off", "GOSUMDB=off"},
+		Cacher:   DirCacher(tempDir),
+		TempDir:  tempDir,
+		Fetcher: &fakeFetcher{
+			versions: []string{
+				"v1.1.0",
+				"v1.0.0",
+				"v1.2.0-0.20000101000000-000000000000",
+				"foo",
+			},
+			files: map[string][]byte{
+				"example.com/foo@v1.1.0.info": []byte(info),
+				"example.com/foo@v1.1.0.mod": []byte(
+					"module example.com/foo",
+				),
+				"example.com/foo@v1.1.0.zip": zipBuf.Bytes(),
+			},
+		},
+		ErrorLogger: log.New(&discardWriter{}, "", 0),
+	}
+
+	for n, tt := range []struct {
+		name     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			"example.com/foo/@latest",
+			http.StatusOK,
+			info,
+		},
+		{
+			"example.com/foo/@v/list",
+			http.StatusOK,
+			"v1.0.0\nv1.1.0",
+		},
+		{
+			"example.com/foo/@v/v1.1.0.info",
+			http.StatusOK,
+			info,
+		},
+		{
+			"example.com/foo/@v/v1.1.0.mod",
+			http.StatusOK,
+			"module example.com/foo",
+		},
+		{
+			"example.com/foo/@v/v1.1.0.zip",
+			http.StatusOK,
+			zipBuf.String(),
+		},
+		{
+			"example.com/foo/@v/v1.0.0.mod",
+			http.StatusNotFound,
+			"not found: file does not exist",
+		},
+		{
+			"example.com/foo/@v/master.info",
+			http.StatusNotFound,
+			"not found: master: file does not exist",
+		},
+	} {
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest("", "/"+tt.name, nil))
+		if got, want := rec.Code, tt.wantCode; got != want {
+			t.Errorf("test(%d): got %d, want %d", n, got, want)
+		}
+
+		if got, want := strings.TrimSpace(
+			rec.Body.String(),
+		), tt.wantBody; got != want {
+			t.Errorf("test(%d): got %q, want %q", n, got, want)
+		}
+	}
+}
+
+func TestGoproxyUpstreamFetcher(t *testing.T) {
+	tempDir, err := ioutil.TempDir(
+		"",
+		"goproxy.TestGoproxyUpstreamFetcher",
+	)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	info := `{"Version":"v1.0.0","Time":"2000-01-01T00:00:00Z"}`
+	proxyServer := httptest.NewServer(http.HandlerFunc(func(
+		rw http.ResponseWriter,
+		req *http.Request,
+	) {
+		switch req.URL.Path {
+		case "/example.com/foo/@latest",
+			"/example.com/foo/@v/v1.0.0.info":
+			fmt.Fprint(rw, info)
+		case "/example.com/foo/@v/list":
+			fmt.Fprint(rw, "v1.0.0\nv1.1.0")
+		case "/example.com/foo/@v/v1.0.0.mod":
+			fmt.Fprint(rw, "module example.com/foo")
+		default:
+			responseNotFound(rw, req, -2)
+		}
+	}))
+	defer proxyServer.Close()
+
+	g := &Goproxy{
+		GoBinEnv: []string{
+			"GOPROXY=" + proxyServer.URL,
+			"GOSUMDB=off",
+		},
+		TempDir:     tempDir,
+		ErrorLogger: log.New(&discardWriter{}, "", 0),
+	}
+	uf := g.UpstreamFetcher()
+	ctx := context.Background()
+
+	for _, query := range []string{"latest", "v1.0.0"} {
+		version, tm, err := uf.Resolve(ctx, "example.com/foo", query)
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		if got, want := version, "v1.0.0"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+
+		if got, want := tm, time.Date(
+			2000, 1, 1, 0, 0, 0, 0, time.UTC,
+		); !got.Equal(want) {
+			t.Errorf("got %s, want %s", got, want)
+		}
+	}
+
+	versions, err := uf.List(ctx, "example.com/foo")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	got, want := strings.Join(versions, ","), "v1.0.0,v1.1.0"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	var buf bytes.Buffer
+	if err := uf.Download(
+		ctx,
+		"example.com/foo",
+		"v1.0.0",
+		".mod",
+		&buf,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if got, want := buf.String(), "module example.com/foo"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if err := uf.Download(
+		ctx,
+		"example.com/foo",
+		"v1.1.0",
+		".mod",
+		&buf,
+	); !errors.Is(err, errNotFound) {
+		t.Fatalf("got error %q, want error %q", err, errNotFound)
+	}
+
+	// A custom Fetcher falling back to the upstream one.
+	g.Fetcher = uf
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, httptest.NewRequest(
+		"",
+		"/example.com/foo/@v/v1.0.0.mod",
+		nil,
+	))
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	got, want = rec.Body.String(), "module example.com/foo"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,74 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// errFetchedByOtherInstance is returned by a fetch that found the module file
+// cached by another Goproxy instance once it acquired the lock of the fetch.
+var errFetchedByOtherInstance = errors.New(
+	"module file fetched by another instance",
+)
+
+// FetchLocker is the interface used by the [Goproxy] to coordinate the fetches
+// of module versions across multiple instances sharing the same caches.
+type FetchLocker interface {
+	// Lock acquires the lock for the name, which is shared by all
+	// instances using the same locking backend. It blocks until the lock
+	// is acquired or the ctx is done, and returns the function that
+	// releases the lock.
+	//
+	// Locks should expire by themselves after a while, so that an
+	// instance that crashed while holding one does not block the others
+	// forever.
+	Lock(ctx context.Context, name string) (unlock func(), err error)
+}
+
+// lockedFetchDownload is like the [Goproxy.fetchDownload], but holds the lock
+// of the module version of the f from the [Goproxy.FetchLocker] while fetching.
+// It returns the errFetchedByOtherInstance if the module file has been cached
+// by another instance by the time the lock is acquired.
+//
+// The fetch goes ahead without the lock if the FetchLocker fails, so that an
+// unavailable locking backend only costs duplicate downloads.
+func (g *Goproxy) lockedFetchDownload(
+	req *http.Request,
+	f *fetch,
+	expiration time.Duration,
+) (*fetchResult, error) {
+	if g.FetchLocker == nil {
+		return g.fetchDownload(req, f, expiration)
+	}
+
+	unlock, err := g.FetchLocker.Lock(
+		req.Context(),
+		strings.TrimSuffix(f.name, path.Ext(f.name)),
+	)
+	if err != nil {
+		if req.Context().Err() != nil {
+			return nil, err
+		}
+
+		g.logRequestErrorf(
+			req,
+			"failed to lock fetch: %s: %v",
+			f.name,
+			err,
+		)
+
+		return g.fetchDownload(req, f, expiration)
+	}
+	defer unlock()
+
+	if content, err := g.cache(req.Context(), f.name); err == nil {
+		content.Close()
+		return nil, errFetchedByOtherInstance
+	}
+
+	return g.fetchDownload(req, f, expiration)
+}
@@ -0,0 +1,170 @@
+package goproxy
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// memoryFetchLocker is a [FetchLocker] shared by [Goproxy]s in the same
+// process.
+type memoryFetchLocker struct {
+	mutex sync.Mutex
+	locks map[string]chan struct{}
+	names []string
+	err   error
+}
+
+// Lock implements the [FetchLocker].
+func (mfl *memoryFetchLocker) Lock(
+	ctx context.Context,
+	name string,
+) (func(), error) {
+	mfl.mutex.Lock()
+	if mfl.err != nil {
+		mfl.mutex.Unlock()
+		return nil, mfl.err
+	}
+
+	if mfl.locks == nil {
+		mfl.locks = map[string]chan struct{}{}
+	}
+
+	lock, ok := mfl.locks[name]
+	if !ok {
+		lock = make(chan struct{}, 1)
+		mfl.locks[name] = lock
+	}
+
+	mfl.names = append(mfl.names, name)
+	mfl.mutex.Unlock()
+
+	select {
+	case lock <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	return func() { <-lock }, nil
+}
+
+func TestGoproxyFetchLocker(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestGoproxyFetchLocker")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	var zipBuf bytes.Buffer
+	zw := zip.NewWriter(&zipBuf)
+	w, err := zw.Create("example.com@v1.0.0/go.mod")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if _, err := w.Write([]byte("module example.com")); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if err := zw.Close(); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	var hits int64
+	server := httptest.NewServer(http.HandlerFunc(func(
+		rw http.ResponseWriter,
+		req *http.Request,
+	) {
+		if req.URL.Path == "/example.com/@v/v1.0.0.zip" {
+			atomic.AddInt64(&hits, 1)
+			time.Sleep(100 * time.Millisecond)
+			rw.Write(zipBuf.Bytes())
+			return
+		}
+
+		responseNotFound(rw, req, -2)
+	}))
+	defer server.Close()
+
+	mfl := &memoryFetchLocker{}
+	newGoproxy := func() *Goproxy {
+		return &Goproxy{
+			GoBinEnv: []string{
+				"GOPROXY=" + server.URL,
+				"GOSUMDB=off",
+			},
+			Cacher:      DirCacher(tempDir),
+			TempDir:     tempDir,
+			FetchLocker: mfl,
+			ErrorLogger: log.New(&discardWriter{}, "", 0),
+		}
+	}
+
+	// Each Goproxy stands for an instance sharing the caches.
+	var wg sync.WaitGroup
+	for _, g := range []*Goproxy{newGoproxy(), newGoproxy()} {
+		g := g
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			g.ServeHTTP(rec, httptest.NewRequest(
+				http.MethodGet,
+				"/example.com/@v/v1.0.0.zip",
+				nil,
+			))
+			b := rec.Body.Bytes()
+			if got, want := rec.Code, http.StatusOK; got != want {
+				t.Errorf("got %d, want %d", got, want)
+			} else if !bytes.Equal(b, zipBuf.Bytes()) {
+				t.Errorf("got %q, want %q", b, zipBuf.Bytes())
+			}
+		}()
+	}
+
+	wg.Wait()
+	if got, want := atomic.LoadInt64(&hits), int64(1); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	mfl.mutex.Lock()
+	names := mfl.names
+	mfl.mutex.Unlock()
+	for _, name := range names {
+		if got, want := name, "example.com/@v/v1.0.0"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+
+	// Fetches go ahead without locks if the FetchLocker fails.
+	if err := os.RemoveAll(tempDir); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if err := os.MkdirAll(tempDir, 0755); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	mfl.err = errors.New("lock failed")
+	rec := httptest.NewRecorder()
+	newGoproxy().ServeHTTP(rec, httptest.NewRequest(
+		http.MethodGet,
+		"/example.com/@v/v1.0.0.zip",
+		nil,
+	))
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	if got, want := atomic.LoadInt64(&hits), int64(2); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
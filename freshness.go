@@ -0,0 +1,173 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// moduleFreshness describes how fresh the caches of a module are compared to
+// its upstream.
+type moduleFreshness struct {
+	Module string
+
+	// CachedLatest is the latest version of the module known to the
+	// caches. It is empty if the module has not been cached.
+	CachedLatest string `json:",omitempty"`
+
+	// UpstreamLatest is the latest version of the module currently
+	// resolved from upstream. It is empty if the resolution failed.
+	UpstreamLatest string `json:",omitempty"`
+
+	// Lagging indicates whether the CachedLatest is behind the
+	// UpstreamLatest.
+	Lagging bool
+
+	// Error is the error that occurred while resolving the
+	// UpstreamLatest.
+	Error string `json:",omitempty"`
+}
+
+// freshness reports the freshness of the caches of each of the modulePaths.
+// The upstream latest versions are resolved concurrently and never cached,
+// so that the report does not affect what clients are served.
+func (g *Goproxy) freshness(
+	ctx context.Context,
+	modulePaths []string,
+) ([]*moduleFreshness, error) {
+	tempDir, err := ioutil.TempDir(g.TempDir, "goproxy")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tempDir)
+
+	mfs := make([]*moduleFreshness, len(modulePaths))
+	var wg sync.WaitGroup
+	for i, modulePath := range modulePaths {
+		mfs[i] = &moduleFreshness{Module: modulePath}
+		wg.Add(1)
+		go func(mf *moduleFreshness) {
+			defer wg.Done()
+			if err := g.checkFreshness(ctx, mf, tempDir); err != nil {
+				mf.Error = err.Error()
+			}
+		}(mfs[i])
+	}
+
+	wg.Wait()
+
+	return mfs, nil
+}
+
+// checkFreshness fills the mf by resolving the latest versions of its module
+// from both the caches and upstream.
+func (g *Goproxy) checkFreshness(
+	ctx context.Context,
+	mf *moduleFreshness,
+	tempDir string,
+) error {
+	escapedModulePath, err := module.EscapePath(mf.Module)
+	if err != nil {
+		return err
+	}
+
+	mf.CachedLatest, err = g.cachedLatest(ctx, escapedModulePath)
+	if err != nil {
+		return err
+	}
+
+	f, err := newFetch(g, fmt.Sprint(escapedModulePath, "/@latest"), tempDir)
+	if err != nil {
+		return err
+	}
+
+	r, err := f.do(ctx)
+	if err != nil {
+		return err
+	}
+
+	mf.UpstreamLatest = r.Version
+	mf.Lagging = mf.CachedLatest != mf.UpstreamLatest &&
+		semver.Compare(mf.CachedLatest, mf.UpstreamLatest) < 0
+
+	return nil
+}
+
+// cachedLatest returns the latest version of the module targeted by the
+// escapedModulePath known to the caches, preferring the cached "@latest" and
+// falling back to the greatest version in the cached "@v/list". It returns an
+// empty string if neither has been cached.
+func (g *Goproxy) cachedLatest(
+	ctx context.Context,
+	escapedModulePath string,
+) (string, error) {
+	b, err := g.cacheBytes(ctx, fmt.Sprint(escapedModulePath, "/@latest"))
+	if err == nil {
+		version, _, err := unmarshalInfo(string(b))
+		return version, err
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+
+	b, err = g.cacheBytes(ctx, fmt.Sprint(escapedModulePath, "/@v/list"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	var latest string
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if version := fields[0]; semver.IsValid(version) &&
+			semver.Compare(version, latest) > 0 {
+			latest = version
+		}
+	}
+
+	return latest, nil
+}
+
+// cacheBytes returns the content of the matched cache for the name.
+func (g *Goproxy) cacheBytes(ctx context.Context, name string) ([]byte, error) {
+	content, err := g.cache(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+
+	return ioutil.ReadAll(content)
+}
+
+// serveFreshness serves freshness requests. The modules are taken from the
+// "module" query parameters, or the [Goproxy.FreshnessModules] if there are
+// none.
+func (g *Goproxy) serveFreshness(rw http.ResponseWriter, req *http.Request) {
+	modulePaths := req.URL.Query()["module"]
+	if len(modulePaths) == 0 {
+		modulePaths = g.FreshnessModules
+	}
+
+	mfs, err := g.freshness(req.Context(), modulePaths)
+	if err != nil {
+		g.logRequestErrorf(req, "failed to check freshness: %v", err)
+		responseInternalServerError(rw, req)
+		return
+	}
+
+	responseJSON(rw, req, -2, mfs)
+}
@@ -0,0 +1,146 @@
+package goproxy
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGoproxyFreshness(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestGoproxyFreshness")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	infoTime := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	server := httptest.NewServer(http.HandlerFunc(func(
+		rw http.ResponseWriter,
+		req *http.Request,
+	) {
+		switch req.URL.Path {
+		case "/example.com/a/@latest", "/example.com/b/@latest":
+			responseString(
+				rw,
+				req,
+				http.StatusOK,
+				-2,
+				marshalInfo("v1.1.0", infoTime),
+			)
+		default:
+			responseNotFound(rw, req, -2)
+		}
+	}))
+	defer server.Close()
+
+	g := &Goproxy{
+		Cacher: DirCacher(tempDir),
+		GoBinEnv: []string{
+			"GOPROXY=" + server.URL,
+			"GOSUMDB=off",
+		},
+		TempDir:          tempDir,
+		FreshnessModules: []string{"example.com/a", "example.com/b"},
+		ErrorLogger:      log.New(&discardWriter{}, "", 0),
+	}
+	g.initOnce.Do(g.init)
+	for name, content := range map[string]string{
+		"example.com/a/@latest": marshalInfo("v1.0.0", infoTime),
+		"example.com/b/@v/list": "v1.0.0\nv1.1.0\nv1.0.1\n",
+		"example.com/c/@v/list": "",
+	} {
+		if err := g.putCache(
+			context.Background(),
+			name,
+			strings.NewReader(content),
+			time.Minute,
+		); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	mfs, err := g.freshness(context.Background(), []string{
+		"example.com/a",
+		"example.com/b",
+		"example.com/c",
+		"example.com/d",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	for i, want := range []moduleFreshness{
+		{
+			Module:         "example.com/a",
+			CachedLatest:   "v1.0.0",
+			UpstreamLatest: "v1.1.0",
+			Lagging:        true,
+		},
+		{
+			Module:         "example.com/b",
+			CachedLatest:   "v1.1.0",
+			UpstreamLatest: "v1.1.0",
+		},
+		{
+			Module: "example.com/c",
+			Error:  "not found",
+		},
+		{
+			Module: "example.com/d",
+			Error:  "not found",
+		},
+	} {
+		if got := *mfs[i]; got != want {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	}
+
+	for _, cacheName := range []string{
+		"example.com/b/@latest",
+		"example.com/c/@latest",
+	} {
+		if _, err := g.cache(
+			context.Background(),
+			cacheName,
+		); !os.IsNotExist(err) {
+			t.Fatalf("got error %q, want error %q", err, os.ErrNotExist)
+		}
+	}
+
+	req := httptest.NewRequest("", "/-/freshness", nil)
+	rec := httptest.NewRecorder()
+	g.serveFreshness(rec, req)
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	if err := json.Unmarshal(rec.Body.Bytes(), &mfs); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := len(mfs), 2; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	} else if got, want := mfs[0].Module, "example.com/a"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	req = httptest.NewRequest(
+		"",
+		"/-/freshness?module=example.com/b",
+		nil,
+	)
+	rec = httptest.NewRecorder()
+	g.serveFreshness(rec, req)
+	if err := json.Unmarshal(rec.Body.Bytes(), &mfs); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := len(mfs), 1; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	} else if mfs[0].Lagging {
+		t.Error("expected not lagging")
+	}
+}
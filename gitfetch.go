@@ -0,0 +1,1029 @@
+package goproxy
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+	modzip "golang.org/x/mod/zip"
+)
+
+// errNativeFetchUnsupported is the error returned by the [fetch.doNative] when
+// the fetch is not supported in-process and must be left to the Go binary.
+var errNativeFetchUnsupported = errors.New("unsupported by native fetches")
+
+// knownGitRepoHosts are the well-known code hosting sites whose repository
+// roots are the first three elements of module paths.
+//
+// See go/src/cmd/go/internal/vcs.vcsPaths.
+var knownGitRepoHosts = []string{"github.com", "bitbucket.org"}
+
+// doNative executes the f directly in-process (see the
+// [Goproxy.NativeDirectFetches]). It returns [errNativeFetchUnsupported] if the
+// f must be left to the Go binary.
+func (f *fetch) doNative(ctx context.Context) (*fetchResult, error) {
+	repoRoot, repoURL, err := f.resolveGitRepo(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.g.goBinVCSRules != nil {
+		if err := checkGOVCS(
+			f.g.goBinVCSRules,
+			f.modulePath,
+			"git",
+			globsMatchPath(f.g.goBinEnvGOPRIVATE, f.modulePath),
+		); err != nil {
+			return nil, err
+		}
+	}
+
+	return f.doGit(ctx, repoRoot, repoURL)
+}
+
+// resolveGitRepo resolves the root path and the URL of the Git repository of
+// the f.modulePath, either statically or via the "?go-get=1" discovery (see
+// https://go.dev/ref/mod#vcs-find). It returns [errNativeFetchUnsupported] if
+// the repository is not a Git one.
+func (f *fetch) resolveGitRepo(
+	ctx context.Context,
+) (repoRoot, repoURL string, err error) {
+	elems := strings.Split(f.modulePath, "/")
+	for i, elem := range elems[1:] {
+		for _, vcs := range vcsQualifiers {
+			if !strings.HasSuffix(elem, "."+vcs) {
+				continue
+			} else if vcs != "git" {
+				return "", "", errNativeFetchUnsupported
+			}
+
+			repoRoot = strings.Join(elems[:i+2], "/")
+			return repoRoot, "https://" + repoRoot, nil
+		}
+	}
+
+	if stringSliceContains(knownGitRepoHosts, elems[0]) {
+		if len(elems) < 3 {
+			return "", "", notFoundError(fmt.Sprintf(
+				"unrecognized import path %q",
+				f.modulePath,
+			))
+		}
+
+		repoRoot = strings.Join(elems[:3], "/")
+		return repoRoot, "https://" + repoRoot, nil
+	} else if knownVCSHosts[elems[0]] != "" {
+		return "", "", errNativeFetchUnsupported
+	}
+
+	var buf bytes.Buffer
+	if err := httpGet(
+		ctx,
+		f.g.httpClient,
+		fmt.Sprint("https://", f.modulePath, "?go-get=1"),
+		&buf,
+	); err != nil {
+		return "", "", notFoundError(fmt.Sprintf(
+			"unrecognized import path %q: %v",
+			f.modulePath,
+			err,
+		))
+	}
+
+	imports, err := parseGoImports(&buf)
+	if err != nil {
+		return "", "", notFoundError(fmt.Sprintf(
+			"unrecognized import path %q: parsing: %v",
+			f.modulePath,
+			err,
+		))
+	}
+
+	var matched *goImport
+	for i, gi := range imports {
+		if gi.vcs == "mod" || (gi.prefix != f.modulePath &&
+			!strings.HasPrefix(f.modulePath, gi.prefix+"/")) {
+			continue
+		} else if matched != nil {
+			return "", "", notFoundError(fmt.Sprintf(
+				"unrecognized import path %q: multiple "+
+					"meta tags match",
+				f.modulePath,
+			))
+		}
+
+		matched = &imports[i]
+	}
+
+	if matched == nil {
+		return "", "", notFoundError(fmt.Sprintf(
+			"unrecognized import path %q: no go-import meta tags",
+			f.modulePath,
+		))
+	} else if matched.vcs != "git" {
+		return "", "", errNativeFetchUnsupported
+	} else if err := f.checkGitRepoURL(matched.repoURL); err != nil {
+		return "", "", notFoundError(fmt.Sprintf(
+			"unrecognized import path %q: invalid repo URL %q: %v",
+			f.modulePath,
+			matched.repoURL,
+			err,
+		))
+	}
+
+	return matched.prefix, matched.repoURL, nil
+}
+
+// checkGitRepoURL checks whether the repoURL, which comes from an untrusted
+// "go-import" meta tag, is safe to be passed to Git. Like the go command, it
+// only allows the "https", "ssh" and "git+ssh" schemes, plus the "http" if the
+// GOINSECURE of the [Goproxy.GoBinEnv] matches the f.modulePath.
+//
+// See go/src/cmd/go/internal/vcs.repoRootForImportDynamic.
+func (f *fetch) checkGitRepoURL(repoURL string) error {
+	if strings.HasPrefix(repoURL, "-") {
+		return errors.New("leading dash")
+	}
+
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return err
+	} else if strings.HasPrefix(u.Host, "-") {
+		return errors.New("leading dash in host")
+	}
+
+	switch u.Scheme {
+	case "https", "ssh", "git+ssh":
+		return nil
+	case "http":
+		if globsMatchPath(f.g.goBinEnvGOINSECURE, f.modulePath) {
+			return nil
+		}
+
+		return errors.New("insecure protocol")
+	case "":
+		return errors.New("no scheme")
+	}
+
+	return fmt.Errorf("disallowed scheme %q", u.Scheme)
+}
+
+// goImport is a "go-import" meta tag.
+type goImport struct {
+	prefix  string
+	vcs     string
+	repoURL string
+}
+
+// parseGoImports parses the "go-import" meta tags from the HTML head of the r.
+//
+// See go/src/cmd/go/internal/vcs.parseMetaGoImports.
+func parseGoImports(r io.Reader) ([]goImport, error) {
+	d := xml.NewDecoder(r)
+	d.CharsetReader = func(
+		charset string,
+		input io.Reader,
+	) (io.Reader, error) {
+		switch strings.ToLower(charset) {
+		case "utf-8", "ascii":
+			return input, nil
+		}
+
+		return nil, fmt.Errorf("can't decode XML document using "+
+			"charset %q", charset)
+	}
+	d.Strict = false
+	d.AutoClose = xml.HTMLAutoClose
+	d.Entity = xml.HTMLEntity
+
+	var imports []goImport
+	for {
+		t, err := d.RawToken()
+		if err != nil {
+			if err == io.EOF || len(imports) > 0 {
+				return imports, nil
+			}
+
+			return nil, err
+		}
+
+		if e, ok := t.(xml.StartElement); ok &&
+			strings.EqualFold(e.Name.Local, "body") {
+			return imports, nil
+		} else if e, ok := t.(xml.EndElement); ok &&
+			strings.EqualFold(e.Name.Local, "head") {
+			return imports, nil
+		}
+
+		e, ok := t.(xml.StartElement)
+		if !ok || !strings.EqualFold(e.Name.Local, "meta") {
+			continue
+		}
+
+		var name, content string
+		for _, attr := range e.Attr {
+			switch strings.ToLower(attr.Name.Local) {
+			case "name":
+				name = attr.Value
+			case "content":
+				content = attr.Value
+			}
+		}
+
+		if name != "go-import" {
+			continue
+		}
+
+		if fields := strings.Fields(content); len(fields) == 3 {
+			imports = append(imports, goImport{
+				prefix:  fields[0],
+				vcs:     fields[1],
+				repoURL: fields[2],
+			})
+		}
+	}
+}
+
+// doGit executes the f against the Git repository targeted by the repoURL,
+// whose root path is the repoRoot.
+func (f *fetch) doGit(
+	ctx context.Context,
+	repoRoot string,
+	repoURL string,
+) (*fetchResult, error) {
+	pathPrefix, pathMajor, ok := module.SplitPathVersion(f.modulePath)
+	if !ok {
+		return nil, notFoundError(fmt.Sprintf(
+			"malformed module path %q",
+			f.modulePath,
+		))
+	}
+
+	// The gopkg.in paths and the repositories whose roots end with the
+	// major version suffix are left to the Go binary.
+	if strings.HasPrefix(pathMajor, ".") ||
+		(pathPrefix != repoRoot &&
+			!strings.HasPrefix(pathPrefix, repoRoot+"/")) {
+		return nil, errNativeFetchUnsupported
+	}
+
+	gr := &gitRepo{
+		f:         f,
+		url:       repoURL,
+		dir:       filepath.Join(f.tempDir, "repo"),
+		codeDir:   strings.TrimPrefix(pathPrefix[len(repoRoot):], "/"),
+		pathMajor: pathMajor,
+	}
+	if gr.codeDir != "" {
+		gr.tagPrefix = gr.codeDir + "/"
+	}
+
+	if err := os.Mkdir(gr.dir, 0755); err != nil {
+		return nil, err
+	}
+
+	if _, err := gr.git(ctx, "init", "-q", "--bare"); err != nil {
+		return nil, err
+	}
+
+	refs, err := gr.lsRemote(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// The "+incompatible" versions are left to the Go binary, which
+	// checks the go.mod file of each of them.
+	tags := gr.tagVersions(refs)
+	if gr.hasIncompatibleTags(refs) {
+		return nil, errNativeFetchUnsupported
+	}
+
+	switch f.ops {
+	case fetchOpsList:
+		r := &fetchResult{f: f, Versions: []string{}}
+		for version := range tags {
+			r.Versions = append(r.Versions, version)
+		}
+
+		sort.Slice(r.Versions, func(i, j int) bool {
+			return semver.Compare(r.Versions[i], r.Versions[j]) < 0
+		})
+
+		return r, nil
+	case fetchOpsResolve:
+		version, t, err := gr.resolve(ctx, refs, tags)
+		if err != nil {
+			return nil, err
+		}
+
+		return &fetchResult{f: f, Version: version, Time: t}, nil
+	}
+
+	version := f.moduleVersion
+	if semver.Canonical(version) != version ||
+		strings.HasSuffix(version, "+incompatible") {
+		return nil, errNativeFetchUnsupported
+	} else if err := module.CheckPathMajor(version, pathMajor); err != nil {
+		return nil, notFoundError(err.Error())
+	}
+
+	var hash string
+	if module.IsPseudoVersion(version) {
+		if err := gr.fetchAll(ctx); err != nil {
+			return nil, err
+		}
+
+		rev, err := module.PseudoVersionRev(version)
+		if err != nil {
+			return nil, notFoundError(err.Error())
+		}
+
+		hash, err = gr.revParse(ctx, rev)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := gr.checkPseudoVersion(
+			ctx,
+			version,
+			hash,
+			tags,
+		); err != nil {
+			return nil, err
+		}
+	} else if hash = tags[version]; hash == "" {
+		return nil, notFoundError(fmt.Sprintf(
+			"%s: unknown revision %s%s",
+			f.modAtVer,
+			gr.tagPrefix,
+			version,
+		))
+	} else if err := gr.fetchTag(ctx, version); err != nil {
+		return nil, err
+	}
+
+	return gr.download(ctx, version, hash)
+}
+
+// gitRepo is a local Git repository that objects are fetched into from a
+// remote one for a [fetch].
+type gitRepo struct {
+	f   *fetch
+	url string
+	dir string
+
+	// codeDir is the directory of the module in the repository, without
+	// the major version subdirectory, if any.
+	codeDir string
+
+	// tagPrefix is the prefix of the tags of the module.
+	tagPrefix string
+
+	// pathMajor is the major version suffix of the module path.
+	pathMajor string
+}
+
+// git runs the git command with the args in the gr.dir and returns its
+// standard output.
+func (gr *gitRepo) git(ctx context.Context, args ...string) ([]byte, error) {
+	cmd, err := gr.f.g.GoBinSandbox.command("git", args...)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd.Env = append(
+		append([]string{}, gr.f.g.goBinEnv...),
+		"GIT_TERMINAL_PROMPT=0",
+	)
+	cmd.Dir = gr.dir
+
+	stdout, err := commandOutput(ctx, cmd, gr.f.g.GoBinSandbox.started)
+	if err != nil {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("command %v: %w", cmd.Args, err)
+		}
+
+		if ee, ok := err.(*exec.ExitError); ok {
+			return nil, notFoundError(fmt.Sprintf(
+				"%s: git %s: %s",
+				gr.f.modAtVer,
+				args[0],
+				strings.TrimSpace(string(ee.Stderr)),
+			))
+		}
+
+		return nil, err
+	}
+
+	return stdout, nil
+}
+
+// lsRemote returns the commit hashes of the refs of the remote repository.
+func (gr *gitRepo) lsRemote(ctx context.Context) (map[string]string, error) {
+	stdout, err := gr.git(ctx, "ls-remote", "-q", "--", gr.url)
+	if err != nil {
+		return nil, err
+	}
+
+	refs := map[string]string{}
+	for _, line := range strings.Split(string(stdout), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+
+		// The peeled refs of annotated tags target the commits.
+		hash, ref := fields[0], fields[1]
+		if strings.HasSuffix(ref, "^{}") {
+			refs[strings.TrimSuffix(ref, "^{}")] = hash
+		} else if _, ok := refs[ref]; !ok {
+			refs[ref] = hash
+		}
+	}
+
+	return refs, nil
+}
+
+// tagVersion returns the version of the module tagged by the tag, or "" if
+// the tag is not one of its versions.
+func (gr *gitRepo) tagVersion(tag string) string {
+	version := strings.TrimPrefix(tag, gr.tagPrefix)
+	if (version == tag && gr.tagPrefix != "") ||
+		semver.Canonical(version) != version ||
+		module.IsPseudoVersion(version) ||
+		module.CheckPathMajor(version, gr.pathMajor) != nil {
+		return ""
+	}
+
+	return version
+}
+
+// tagVersions returns the commit hashes of the versions of the module tagged
+// in the refs.
+func (gr *gitRepo) tagVersions(refs map[string]string) map[string]string {
+	versions := map[string]string{}
+	for ref, hash := range refs {
+		if !strings.HasPrefix(ref, "refs/tags/") {
+			continue
+		}
+
+		version := gr.tagVersion(strings.TrimPrefix(ref, "refs/tags/"))
+		if version != "" {
+			versions[version] = hash
+		}
+	}
+
+	return versions
+}
+
+// hasIncompatibleTags reports whether there are tags in the refs for major
+// versions v2 and above of a module path without a major version suffix.
+func (gr *gitRepo) hasIncompatibleTags(refs map[string]string) bool {
+	if gr.pathMajor != "" {
+		return false
+	}
+
+	for ref := range refs {
+		tag := strings.TrimPrefix(ref, "refs/tags/")
+		version := strings.TrimPrefix(tag, gr.tagPrefix)
+		if tag == ref || (version == tag && gr.tagPrefix != "") ||
+			semver.Canonical(version) != version {
+			continue
+		}
+
+		switch semver.Major(version) {
+		case "v0", "v1":
+		default:
+			return true
+		}
+	}
+
+	return false
+}
+
+// resolve resolves the version and the time of the f.moduleVersion, which is
+// "latest" or a revision.
+func (gr *gitRepo) resolve(
+	ctx context.Context,
+	refs map[string]string,
+	tags map[string]string,
+) (string, time.Time, error) {
+	query := gr.f.moduleVersion
+	if query == "latest" {
+		var latest, latestPre string
+		for version := range tags {
+			if semver.Prerelease(version) == "" {
+				if semver.Compare(version, latest) > 0 {
+					latest = version
+				}
+			} else if semver.Compare(version, latestPre) > 0 {
+				latestPre = version
+			}
+		}
+
+		if latest == "" {
+			latest = latestPre
+		}
+
+		if latest != "" {
+			if err := gr.fetchTag(ctx, latest); err != nil {
+				return "", time.Time{}, err
+			}
+
+			t, err := gr.commitTime(ctx, tags[latest])
+			if err != nil {
+				return "", time.Time{}, err
+			}
+
+			return latest, t, nil
+		}
+
+		query = "HEAD"
+	} else if semver.IsValid(query) ||
+		strings.HasPrefix(query, "<") ||
+		strings.HasPrefix(query, ">") ||
+		query == "upgrade" ||
+		query == "patch" {
+		// Version queries are left to the Go binary.
+		return "", time.Time{}, errNativeFetchUnsupported
+	}
+
+	if err := gr.fetchAll(ctx); err != nil {
+		return "", time.Time{}, err
+	}
+
+	hash, ok := refs[query]
+	for _, prefix := range []string{"refs/heads/", "refs/tags/"} {
+		if !ok {
+			hash, ok = refs[prefix+query]
+		}
+	}
+
+	if !ok {
+		var err error
+		if hash, err = gr.revParse(ctx, query); err != nil {
+			return "", time.Time{}, err
+		}
+	}
+
+	return gr.revVersion(ctx, hash)
+}
+
+// fetchTag fetches the commit tagged with the version from the remote
+// repository.
+func (gr *gitRepo) fetchTag(ctx context.Context, version string) error {
+	ref := fmt.Sprint("refs/tags/", gr.tagPrefix, version)
+	_, err := gr.git(
+		ctx,
+		"fetch",
+		"-q",
+		"-f",
+		"--depth=1",
+		"--",
+		gr.url,
+		fmt.Sprint(ref, ":", ref),
+	)
+	return err
+}
+
+// fetchAll fetches all the branches and tags, along with their histories,
+// from the remote repository.
+func (gr *gitRepo) fetchAll(ctx context.Context) error {
+	_, err := gr.git(
+		ctx,
+		"fetch",
+		"-q",
+		"-f",
+		"--tags",
+		"--",
+		gr.url,
+		"+refs/heads/*:refs/heads/*",
+	)
+	return err
+}
+
+// revParse returns the commit hash of the rev, which is a commit hash or a
+// prefix of it.
+func (gr *gitRepo) revParse(ctx context.Context, rev string) (string, error) {
+	for _, r := range rev {
+		if !strings.ContainsRune("0123456789abcdef", r) {
+			return "", notFoundError(fmt.Sprintf(
+				"%s: unknown revision %s",
+				gr.f.modAtVer,
+				rev,
+			))
+		}
+	}
+
+	stdout, err := gr.git(
+		ctx,
+		"rev-parse",
+		"--verify",
+		"-q",
+		rev+"^{commit}",
+	)
+	if err != nil {
+		// The commit may still be reachable from refs other than the
+		// fetched branches and tags, which the Go binary looks into.
+		return "", errNativeFetchUnsupported
+	}
+
+	return strings.TrimSpace(string(stdout)), nil
+}
+
+// checkPseudoVersion checks whether the pseudo-version is valid for the commit
+// targeted by the hash, in the same way as the go command does: its revision
+// must be the short hash of the commit, its time must be the commit time, and
+// its base version, if any, must be tagged on an ancestor of the commit. All
+// the tags must have been fetched along with their histories.
+//
+// See go/src/cmd/go/internal/modfetch.codeRepo.validatePseudoVersion.
+func (gr *gitRepo) checkPseudoVersion(
+	ctx context.Context,
+	version string,
+	hash string,
+	tags map[string]string,
+) error {
+	invalid := func(format string, v ...interface{}) error {
+		return notFoundError(fmt.Sprintf(
+			"%s: invalid pseudo-version: %s",
+			gr.f.modAtVer,
+			fmt.Sprintf(format, v...),
+		))
+	}
+
+	rev, err := module.PseudoVersionRev(version)
+	if err != nil {
+		return invalid("%v", err)
+	} else if shortHash := hash[:12]; rev != shortHash {
+		switch {
+		case strings.HasPrefix(rev, shortHash):
+			return invalid(
+				"revision is longer than canonical "+
+					"(expected %s)",
+				shortHash,
+			)
+		case strings.HasPrefix(shortHash, rev):
+			return invalid(
+				"revision is shorter than canonical "+
+					"(expected %s)",
+				shortHash,
+			)
+		}
+
+		return invalid(
+			"does not match short name of revision (%s)",
+			shortHash,
+		)
+	}
+
+	pt, err := module.PseudoVersionTime(version)
+	if err != nil {
+		return invalid("%v", err)
+	}
+
+	t, err := gr.commitTime(ctx, hash)
+	if err != nil {
+		return err
+	} else if !pt.Equal(t) {
+		return invalid(
+			"does not match version-control timestamp "+
+				"(expected %s)",
+			t.Format("20060102150405"),
+		)
+	}
+
+	base, err := module.PseudoVersionBase(version)
+	if err != nil {
+		return invalid("%v", err)
+	} else if base == "" {
+		if gr.pathMajor == "" && semver.Major(version) != "v0" {
+			return invalid(
+				"major version without preceding tag must be "+
+					"v0, not %s",
+				semver.Major(version),
+			)
+		}
+
+		return nil
+	}
+
+	baseHash := tags[base]
+	if baseHash == "" {
+		return invalid(
+			"preceding tag (%s%s) not found",
+			gr.tagPrefix,
+			base,
+		)
+	} else if baseHash == hash {
+		return invalid(
+			"tag (%s%s) found on revision %s is already canonical",
+			gr.tagPrefix,
+			base,
+			rev,
+		)
+	}
+
+	if _, err := gr.git(
+		ctx,
+		"merge-base",
+		"--is-ancestor",
+		baseHash,
+		hash,
+	); err != nil {
+		return invalid(
+			"revision %s is not a descendant of preceding tag "+
+				"(%s%s)",
+			rev,
+			gr.tagPrefix,
+			base,
+		)
+	}
+
+	return nil
+}
+
+// commitTime returns the commit time of the commit targeted by the hash.
+func (gr *gitRepo) commitTime(
+	ctx context.Context,
+	hash string,
+) (time.Time, error) {
+	stdout, err := gr.git(ctx, "log", "-1", "--format=%ct", hash)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	sec, err := strconv.ParseInt(strings.TrimSpace(string(stdout)), 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(sec, 0).UTC(), nil
+}
+
+// revVersion returns the version and the time of the commit targeted by the
+// hash, which is the highest version tagged on it or a pseudo-version based on
+// the highest version tagged on its ancestors. All the tags must have been
+// fetched along with their histories.
+func (gr *gitRepo) revVersion(
+	ctx context.Context,
+	hash string,
+) (string, time.Time, error) {
+	t, err := gr.commitTime(ctx, hash)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	highestVersion := func(args ...string) (string, error) {
+		stdout, err := gr.git(ctx, append(
+			[]string{"tag", "-l", gr.tagPrefix + "v*"},
+			args...,
+		)...)
+		if err != nil {
+			return "", err
+		}
+
+		var highest string
+		for _, tag := range strings.Fields(string(stdout)) {
+			version := gr.tagVersion(tag)
+			if version != "" &&
+				semver.Compare(version, highest) > 0 {
+				highest = version
+			}
+		}
+
+		return highest, nil
+	}
+
+	if version, err := highestVersion(
+		"--points-at",
+		hash,
+	); err != nil {
+		return "", time.Time{}, err
+	} else if version != "" {
+		return version, t, nil
+	}
+
+	older, err := highestVersion("--merged", hash)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	rev := hash
+	if len(rev) > 12 {
+		rev = rev[:12]
+	}
+
+	return module.PseudoVersion(
+		strings.TrimPrefix(gr.pathMajor, "/"),
+		older,
+		t,
+		rev,
+	), t, nil
+}
+
+// download creates the info, mod and zip files of the version of the module
+// from the commit targeted by the hash, which must have been fetched.
+func (gr *gitRepo) download(
+	ctx context.Context,
+	version string,
+	hash string,
+) (*fetchResult, error) {
+	t, err := gr.commitTime(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, goMod, err := gr.moduleDir(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &fetchResult{
+		f:       gr.f,
+		Version: version,
+		Time:    t,
+		Info:    filepath.Join(gr.f.tempDir, "module.info"),
+		GoMod:   filepath.Join(gr.f.tempDir, "module.mod"),
+		Zip:     filepath.Join(gr.f.tempDir, "module.zip"),
+	}
+
+	if err := ioutil.WriteFile(
+		r.Info,
+		[]byte(marshalInfo(version, t)),
+		0644,
+	); err != nil {
+		return nil, err
+	}
+
+	if err := ioutil.WriteFile(r.GoMod, goMod, 0644); err != nil {
+		return nil, err
+	}
+
+	archive := filepath.Join(gr.f.tempDir, "archive.zip")
+	args := []string{
+		"-c", "core.autocrlf=input",
+		"-c", "core.eol=lf",
+		"archive",
+		"--format=zip",
+		"--output=" + archive,
+		hash,
+	}
+	if dir != "" {
+		args = append(args, dir)
+	}
+
+	if _, err := gr.git(ctx, args...); err != nil {
+		return nil, err
+	}
+
+	if err := createModuleZip(
+		r.Zip,
+		archive,
+		dir,
+		module.Version{Path: gr.f.modulePath, Version: version},
+	); err != nil {
+		return nil, notFoundError(fmt.Sprintf(
+			"%s: create zip: %v",
+			gr.f.modAtVer,
+			err,
+		))
+	}
+
+	return r, nil
+}
+
+// moduleDir returns the directory of the module in the commit targeted by the
+// hash, along with the content of its go.mod file, which is synthesized if
+// missing.
+func (gr *gitRepo) moduleDir(
+	ctx context.Context,
+	hash string,
+) (string, []byte, error) {
+	dirs := []string{gr.codeDir}
+	if gr.pathMajor != "" {
+		dirs = append(
+			[]string{path.Join(gr.codeDir, gr.pathMajor[1:])},
+			dirs...,
+		)
+	}
+
+	for _, dir := range dirs {
+		goMod, err := gr.git(
+			ctx,
+			"cat-file",
+			"blob",
+			fmt.Sprint(hash, ":", path.Join(dir, "go.mod")),
+		)
+		if err != nil {
+			if ctx.Err() != nil {
+				return "", nil, err
+			}
+
+			continue
+		}
+
+		modulePath := modfile.ModulePath(goMod)
+		if modulePath == gr.f.modulePath {
+			return dir, goMod, nil
+		} else if dir == gr.codeDir {
+			return "", nil, notFoundError(fmt.Sprintf(
+				"%s: invalid version: go.mod has module path "+
+					"%q at revision %s",
+				gr.f.modAtVer,
+				modulePath,
+				hash,
+			))
+		}
+	}
+
+	if gr.pathMajor != "" {
+		return "", nil, notFoundError(fmt.Sprintf(
+			"%s: invalid version: missing go.mod at revision %s",
+			gr.f.modAtVer,
+			hash,
+		))
+	}
+
+	return gr.codeDir, []byte(fmt.Sprintf(
+		"module %s\n",
+		modfile.AutoQuote(gr.f.modulePath),
+	)), nil
+}
+
+// createModuleZip creates the module zip file targeted by the name for the
+// mv from the files in the dir of the zip archive targeted by the archive.
+func createModuleZip(name, archive, dir string, mv module.Version) error {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	var files []modzip.File
+	for _, zf := range zr.File {
+		if strings.HasSuffix(zf.Name, "/") {
+			continue
+		}
+
+		filename := zf.Name
+		if dir != "" {
+			filename = strings.TrimPrefix(filename, dir+"/")
+			if filename == zf.Name {
+				continue
+			}
+		}
+
+		files = append(files, archiveFile{name: filename, zf: zf})
+	}
+
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+
+	if err := modzip.Create(f, mv, files); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// archiveFile is a [modzip.File] backed by a file in a zip archive.
+type archiveFile struct {
+	name string
+	zf   *zip.File
+}
+
+// Path implements the [modzip.File].
+func (af archiveFile) Path() string {
+	return af.name
+}
+
+// Lstat implements the [modzip.File].
+func (af archiveFile) Lstat() (os.FileInfo, error) {
+	return af.zf.FileInfo(), nil
+}
+
+// Open implements the [modzip.File].
+func (af archiveFile) Open() (io.ReadCloser, error) {
+	return af.zf.Open()
+}
@@ -0,0 +1,307 @@
+package goproxy
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseGoImports(t *testing.T) {
+	imports, err := parseGoImports(strings.NewReader(`<!DOCTYPE html>
+<html>
+<head>
+<meta name="go-import" content="example.com/foo git https://git.example.com/foo">
+<meta name="go-import" content="example.com/bar mod https://proxy.example.com">
+<meta name="go-import" content="example.com/baz">
+<meta name="go-source" content="example.com/foo _ _ _">
+</head>
+<body>
+<meta name="go-import" content="example.com/qux git https://git.example.com/qux">
+</body>
+</html>`))
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	want := []goImport{
+		{"example.com/foo", "git", "https://git.example.com/foo"},
+		{"example.com/bar", "mod", "https://proxy.example.com"},
+	}
+	if !reflect.DeepEqual(imports, want) {
+		t.Errorf("got %+v, want %+v", imports, want)
+	}
+}
+
+func TestFetchDoGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+
+	tempDir, err := ioutil.TempDir("", "goproxy.TestFetchDoGit")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	repoDir := filepath.Join(tempDir, "repo")
+	git := func(date string, args ...string) {
+		cmd := exec.Command("git", append([]string{
+			"-c", "user.name=goproxy",
+			"-c", "user.email=goproxy@example.com",
+		}, args...)...)
+		cmd.Dir = repoDir
+		cmd.Env = append(
+			os.Environ(),
+			"GIT_AUTHOR_DATE="+date,
+			"GIT_COMMITTER_DATE="+date,
+		)
+		if b, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("unexpected error %q: %s", err, b)
+		}
+	}
+
+	writeFiles := func(files map[string]string) {
+		for name, content := range files {
+			name = filepath.Join(repoDir, name)
+			dir := filepath.Dir(name)
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				t.Fatalf("unexpected error %q", err)
+			}
+
+			if err := ioutil.WriteFile(
+				name,
+				[]byte(content),
+				0644,
+			); err != nil {
+				t.Fatalf("unexpected error %q", err)
+			}
+		}
+	}
+
+	if err := os.Mkdir(repoDir, 0755); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	const date1, date2 = "2020-01-01T00:00:00Z", "2020-01-02T03:04:05Z"
+	git(date1, "init", "-q")
+	git(date1, "symbolic-ref", "HEAD", "refs/heads/main")
+	writeFiles(map[string]string{
+		"go.mod":     "module example.com/foo\n",
+		"foo.go":     "package foo\n",
+		"bar/go.mod": "module example.com/foo/bar\n",
+		"bar/bar.go": "package bar\n",
+	})
+	git(date1, "add", "-A")
+	git(date1, "commit", "-q", "-m", "first")
+	git(date1, "tag", "-a", "-m", "v1.0.0", "v1.0.0")
+	git(date1, "tag", "bar/v0.1.0")
+	writeFiles(map[string]string{"foo.go": "package foo // second\n"})
+	git(date2, "commit", "-q", "-a", "-m", "second")
+
+	g := &Goproxy{GoBinEnv: []string{
+		"PATH=" + os.Getenv("PATH"),
+		"GOSUMDB=off",
+	}}
+	g.init()
+
+	doGit := func(name string) (*fetchResult, error) {
+		fetchTempDir, err := ioutil.TempDir(tempDir, "fetch")
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		f, err := newFetch(g, name, fetchTempDir)
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		return f.doGit(
+			context.Background(),
+			"example.com/foo",
+			"file://"+repoDir,
+		)
+	}
+
+	for n, tt := range []struct {
+		name string
+		want []string
+	}{
+		{"example.com/foo/@v/list", []string{"v1.0.0"}},
+		{"example.com/foo/bar/@v/list", []string{"v0.1.0"}},
+		{"example.com/foo/baz/@v/list", []string{}},
+	} {
+		r, err := doGit(tt.name)
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", n, err)
+		}
+
+		if got, want := r.Versions, tt.want; !reflect.DeepEqual(
+			got,
+			want,
+		) {
+			t.Errorf("test(%d): got %q, want %q", n, got, want)
+		}
+	}
+
+	r, err := doGit("example.com/foo/@latest")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if got, want := r.Version, "v1.0.0"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if got, want := r.Time.Format(time.RFC3339), date1; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	r, err = doGit("example.com/foo/@v/main.info")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	pseudoVersion := r.Version
+	if got, want := pseudoVersion, "v1.0.1-0.20200102030405-"; !strings.
+		HasPrefix(got, want) {
+		t.Errorf("got %q, want prefix %q", got, want)
+	}
+
+	for n, tt := range []struct {
+		name    string
+		wantMod string
+	}{
+		{"example.com/foo/@v/v1.0.0.zip", "module example.com/foo\n"},
+		{
+			"example.com/foo/@v/" + pseudoVersion + ".zip",
+			"module example.com/foo\n",
+		},
+		{
+			"example.com/foo/@v/v0.0.0-" + strings.TrimPrefix(
+				pseudoVersion,
+				"v1.0.1-0.",
+			) + ".zip",
+			"module example.com/foo\n",
+		},
+		{
+			"example.com/foo/@v/v1.0.1-0.20200102030406-" +
+				pseudoVersion[len(pseudoVersion)-12:] + ".zip",
+			"",
+		},
+		{
+			"example.com/foo/bar/@v/v0.1.0.zip",
+			"module example.com/foo/bar\n",
+		},
+		{"example.com/foo/baz/@v/v0.0.0-20200101000000-" +
+			strings.Repeat("0", 12) + ".zip", ""},
+		{"example.com/foo/@v/v1.0.5.zip", ""},
+	} {
+		r, err := doGit(tt.name)
+		if tt.wantMod == "" {
+			if err == nil {
+				t.Errorf("test(%d): expected error", n)
+			}
+
+			continue
+		} else if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", n, err)
+		}
+
+		b, err := ioutil.ReadFile(r.GoMod)
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", n, err)
+		}
+
+		if got, want := string(b), tt.wantMod; got != want {
+			t.Errorf("test(%d): got %q, want %q", n, got, want)
+		}
+
+		if err := checkAndFormatInfoFile(r.Info); err != nil {
+			t.Errorf("test(%d): unexpected error %q", n, err)
+		}
+
+		if err := checkZipFile(
+			r.Zip,
+			r.f.modulePath,
+			r.Version,
+		); err != nil {
+			t.Errorf("test(%d): unexpected error %q", n, err)
+		}
+	}
+
+	// Commits not found in the fetched branches and tags are left to the
+	// Go binary.
+	if _, err := doGit("example.com/foo/@v/v0.0.0-20200101000000-" +
+		strings.Repeat("1", 12) + ".info"); err !=
+		errNativeFetchUnsupported {
+		t.Errorf(
+			"got error %q, want error %q",
+			err,
+			errNativeFetchUnsupported,
+		)
+	}
+
+	writeFiles(map[string]string{
+		"v2/go.mod": "module example.com/foo/v2\n",
+		"v2/foo.go": "package foo\n",
+	})
+	git(date2, "add", "-A")
+	git(date2, "commit", "-q", "-m", "third")
+	git(date2, "tag", "v2.0.0")
+
+	if _, err := doGit("example.com/foo/@v/list"); err !=
+		errNativeFetchUnsupported {
+		t.Errorf(
+			"got error %q, want error %q",
+			err,
+			errNativeFetchUnsupported,
+		)
+	}
+
+	r, err = doGit("example.com/foo/v2/@v/v2.0.0.mod")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if b, err := ioutil.ReadFile(r.GoMod); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := string(b),
+		"module example.com/foo/v2\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFetchCheckGitRepoURL(t *testing.T) {
+	g := &Goproxy{GoBinEnv: []string{"GOINSECURE=corp.example.com"}}
+	g.initOnce.Do(g.init)
+	for n, tt := range []struct {
+		modulePath string
+		repoURL    string
+		wantErr    bool
+	}{
+		{"example.com/foo", "https://git.example.com/foo", false},
+		{"example.com/foo", "ssh://git@git.example.com/foo", false},
+		{"example.com/foo", "git+ssh://git@git.example.com/foo", false},
+		{"example.com/foo", "http://git.example.com/foo", true},
+		{"corp.example.com/foo", "http://git.example.com/foo", false},
+		{"example.com/foo", "git://git.example.com/foo", true},
+		{"example.com/foo", "file:///etc", true},
+		{"example.com/foo", "/tmp/foo", true},
+		{"example.com/foo", "--upload-pack=touch /tmp/foo", true},
+		{"example.com/foo", "ssh://-oProxyCommand=foo/bar", true},
+	} {
+		f := &fetch{g: g, modulePath: tt.modulePath}
+		err := f.checkGitRepoURL(tt.repoURL)
+		if tt.wantErr && err == nil {
+			t.Errorf("test(%d): expected error", n)
+		} else if !tt.wantErr && err != nil {
+			t.Errorf("test(%d): unexpected error %q", n, err)
+		}
+	}
+}
@@ -2,4 +2,8 @@ module github.com/Coopermasaaki/goproxy
 
 go 1.13
 
-require golang.org/x/mod v0.7.0
+require (
+	go.etcd.io/bbolt v1.3.6
+	golang.org/x/mod v0.7.0
+	golang.org/x/sys v0.7.0 // indirect
+)
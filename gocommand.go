@@ -0,0 +1,144 @@
+package goproxy
+
+import (
+	"bytes"
+	"net/http"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// maxGoCommandStderrSummaryBytes is the maximum number of bytes of the
+// [GoCommandRecord.StderrSummary].
+const maxGoCommandStderrSummaryBytes = 256
+
+// GoCommandRecord is the record of an invocation of the Go binary targeted by
+// the [Goproxy.GoBinName].
+type GoCommandRecord struct {
+	// Args are the command-line arguments of the invocation, including the
+	// command name.
+	Args []string
+
+	// StartTime is the time when the invocation started.
+	StartTime time.Time
+
+	// Duration is the wall time of the invocation.
+	Duration time.Duration
+
+	// ExitCode is the exit code of the invocation. It is -1 if the
+	// invocation did not exit normally (e.g. killed or failed to start).
+	ExitCode int
+
+	// StderrSummary is the first line of the standard error of the failed
+	// invocation, truncated if too long.
+	StderrSummary string `json:",omitempty"`
+}
+
+// goCommandRecorder keeps the records of the most recent invocations of the Go
+// binary. It is safe for concurrent use.
+type goCommandRecorder struct {
+	mutex   sync.Mutex
+	records []GoCommandRecord
+	next    int
+}
+
+// newGoCommandRecorder returns a new instance of the [goCommandRecorder] that
+// keeps at most max records.
+func newGoCommandRecorder(max int) *goCommandRecorder {
+	return &goCommandRecorder{records: make([]GoCommandRecord, 0, max)}
+}
+
+// add adds the r to the gcr, replacing the oldest one if full.
+func (gcr *goCommandRecorder) add(r GoCommandRecord) {
+	gcr.mutex.Lock()
+	defer gcr.mutex.Unlock()
+	if len(gcr.records) < cap(gcr.records) {
+		gcr.records = append(gcr.records, r)
+		return
+	}
+
+	gcr.records[gcr.next] = r
+	gcr.next = (gcr.next + 1) % len(gcr.records)
+}
+
+// recent returns the records in the gcr, from the oldest to the newest.
+func (gcr *goCommandRecorder) recent() []GoCommandRecord {
+	gcr.mutex.Lock()
+	defer gcr.mutex.Unlock()
+	records := make([]GoCommandRecord, 0, len(gcr.records))
+	records = append(records, gcr.records[gcr.next:]...)
+	return append(records, gcr.records[:gcr.next]...)
+}
+
+// RecentGoCommands returns the records of the most recent invocations of the
+// Go binary targeted by the [Goproxy.GoBinName], from the oldest to the newest.
+// The aggregates of all invocations are reported by the [Goproxy.Stats].
+func (g *Goproxy) RecentGoCommands() []GoCommandRecord {
+	g.initOnce.Do(g.init)
+	return g.goCommands.recent()
+}
+
+// recordGoCommand records the invocation of the cmd that started at the
+// startTime and ended with the err.
+func (g *Goproxy) recordGoCommand(
+	cmd *exec.Cmd,
+	startTime time.Time,
+	err error,
+) {
+	r := GoCommandRecord{
+		Args:      cmd.Args,
+		StartTime: startTime,
+		Duration:  time.Since(startTime),
+		ExitCode:  -1,
+	}
+	if cmd.ProcessState != nil {
+		r.ExitCode = cmd.ProcessState.ExitCode()
+	}
+
+	if err != nil {
+		var stderr []byte
+		if ee, ok := err.(*exec.ExitError); ok {
+			stderr = ee.Stderr
+		} else {
+			stderr = []byte(err.Error())
+		}
+
+		r.StderrSummary = summarizeStderr(stderr)
+	}
+
+	g.goCommands.add(r)
+	g.updateStats(func(s *Stats) {
+		s.GoCommands++
+		s.GoCommandsDuration += r.Duration
+		if r.Duration > s.GoCommandsMaxDuration {
+			s.GoCommandsMaxDuration = r.Duration
+		}
+
+		if err != nil {
+			s.GoCommandsFailed++
+		}
+	})
+}
+
+// summarizeStderr returns the first non-empty line of the stderr, truncated to
+// the [maxGoCommandStderrSummaryBytes].
+func summarizeStderr(stderr []byte) string {
+	stderr = bytes.TrimSpace(stderr)
+	if i := bytes.IndexByte(stderr, '\n'); i >= 0 {
+		stderr = bytes.TrimSpace(stderr[:i])
+	}
+
+	if len(stderr) > maxGoCommandStderrSummaryBytes {
+		stderr = append(
+			stderr[:maxGoCommandStderrSummaryBytes:maxGoCommandStderrSummaryBytes],
+			"..."...,
+		)
+	}
+
+	return string(stderr)
+}
+
+// serveGoCommands serves Go command records requests.
+func (g *Goproxy) serveGoCommands(rw http.ResponseWriter, req *http.Request) {
+	responseJSON(rw, req, -2, g.RecentGoCommands())
+}
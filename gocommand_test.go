@@ -0,0 +1,135 @@
+package goproxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGoCommandRecorder(t *testing.T) {
+	gcr := newGoCommandRecorder(2)
+	if got := gcr.recent(); len(got) != 0 {
+		t.Errorf("got %v, want empty", got)
+	}
+
+	for _, code := range []int{1, 2, 3} {
+		gcr.add(GoCommandRecord{ExitCode: code})
+	}
+
+	var got []int
+	for _, r := range gcr.recent() {
+		got = append(got, r.ExitCode)
+	}
+
+	if want := []int{2, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestGoproxyRecordGoCommand(t *testing.T) {
+	goBinName := "go"
+	if _, err := exec.LookPath(goBinName); err != nil {
+		t.Skip("go binary not found")
+	}
+
+	g := &Goproxy{
+		AdminAuthorizer: func(*http.Request) bool {
+			return true
+		},
+	}
+	g.initOnce.Do(g.init)
+
+	cmd := exec.Command(goBinName, "version")
+	startTime := time.Now()
+	_, err := cmd.Output()
+	g.recordGoCommand(cmd, startTime, err)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	cmd = exec.Command(goBinName, "nonexistent-subcommand")
+	startTime = time.Now()
+	_, err = cmd.Output()
+	g.recordGoCommand(cmd, startTime, err)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	records := g.RecentGoCommands()
+	if got, want := len(records), 2; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	if got, want := records[0].ExitCode, 0; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	if got, want := records[0].StderrSummary, ""; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if got, want := records[1].ExitCode, 2; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	summary := records[1].StderrSummary
+	if want := "nonexistent-subcommand"; !strings.Contains(summary, want) {
+		t.Errorf("got %q, want containing %q", summary, want)
+	}
+
+	stats := g.Stats()
+	if got, want := stats.GoCommands, int64(2); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	if got, want := stats.GoCommandsFailed, int64(1); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	if stats.GoCommandsMaxDuration <= 0 ||
+		stats.GoCommandsDuration < stats.GoCommandsMaxDuration {
+		t.Errorf("unexpected durations %+v", stats)
+	}
+
+	req := httptest.NewRequest("", "/-/go-commands", nil)
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, req)
+	recr := rec.Result()
+	if got, want := recr.StatusCode, http.StatusOK; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	var got []GoCommandRecord
+	if err := json.NewDecoder(recr.Body).Decode(&got); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if got, want := len(got), 2; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
+
+func TestSummarizeStderr(t *testing.T) {
+	for _, tt := range []struct {
+		stderr string
+		want   string
+	}{
+		{"", ""},
+		{"foo", "foo"},
+		{"\nfoo\nbar\n", "foo"},
+		{
+			strings.Repeat("a", maxGoCommandStderrSummaryBytes+1),
+			strings.Repeat("a", maxGoCommandStderrSummaryBytes) +
+				"...",
+		},
+	} {
+		if got := summarizeStderr([]byte(tt.stderr)); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.stderr, got, tt.want)
+		}
+	}
+}
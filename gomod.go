@@ -0,0 +1,264 @@
+package goproxy
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+)
+
+// GoModSummary is the summary of a cached go.mod file served as JSON by the
+// "/-/go-mod?module=<path>&version=<version>" endpoint, so that tools such as
+// dependency browsers do not have to parse go.mod files themselves.
+type GoModSummary struct {
+	// Module is the module path declared by the go.mod file.
+	Module string
+
+	// Version is the module version that the go.mod file belongs to.
+	Version string
+
+	// Go is the Go version declared by the go.mod file, if any.
+	Go string `json:",omitempty"`
+
+	// Require is the list of the required modules, each resolved against
+	// the replacements.
+	Require []GoModRequire
+
+	// Replace is the list of the replacements.
+	Replace []GoModReplace
+
+	// Exclude is the list of the excluded module versions, each of the
+	// form "<path>@<version>".
+	Exclude []string
+
+	// Retract is the list of the retracted version intervals.
+	Retract []GoModRetract
+}
+
+// GoModRequire is a required module in a [GoModSummary].
+type GoModRequire struct {
+	// Path and Version are the required module path and version.
+	Path    string
+	Version string
+
+	// Indirect indicates whether the requirement is marked "// indirect".
+	Indirect bool `json:",omitempty"`
+
+	// Replacement is what the requirement is replaced with, if any, of the
+	// form "<path>@<version>", or a local directory.
+	Replacement string `json:",omitempty"`
+}
+
+// GoModReplace is a replacement in a [GoModSummary].
+type GoModReplace struct {
+	// OldPath and OldVersion are the replaced module path and version. The
+	// OldVersion is empty if all versions are replaced.
+	OldPath    string
+	OldVersion string `json:",omitempty"`
+
+	// NewPath and NewVersion are the replacement module path and version.
+	// The NewVersion is empty if the NewPath is a local directory.
+	NewPath    string
+	NewVersion string `json:",omitempty"`
+}
+
+// GoModRetract is a retracted version interval in a [GoModSummary].
+type GoModRetract struct {
+	// Low and High are the bounds of the interval, which are the same for
+	// a single retracted version.
+	Low  string
+	High string
+
+	// Rationale is the rationale of the retraction, if any.
+	Rationale string `json:",omitempty"`
+}
+
+// serveGoMod serves the [GoModSummary] of a cached go.mod file.
+func (g *Goproxy) serveGoMod(rw http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	modulePath := query.Get("module")
+	escapedModulePath, err := module.EscapePath(modulePath)
+	if err != nil {
+		responseString(
+			rw,
+			req,
+			http.StatusBadRequest,
+			-2,
+			fmt.Sprint("invalid module path: ", err),
+		)
+		return
+	}
+
+	if err := checkModuleHost(
+		g.settings().BlockedModuleHosts,
+		modulePath,
+	); err != nil {
+		responseForbidden(rw, req, -1, err)
+		return
+	}
+
+	moduleVersion := query.Get("version")
+	escapedModuleVersion, err := module.EscapeVersion(moduleVersion)
+	if err != nil {
+		responseString(
+			rw,
+			req,
+			http.StatusBadRequest,
+			-2,
+			fmt.Sprint("invalid module version: ", err),
+		)
+		return
+	}
+
+	name := fmt.Sprint(
+		escapedModulePath,
+		"/@v/",
+		escapedModuleVersion,
+		".mod",
+	)
+	rw, authorized := g.authorizePrivateModule(rw, req, name)
+	if !authorized {
+		return
+	}
+
+	content, err := g.cache(req.Context(), name)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			responseNotFound(rw, req, 60, "go.mod not cached")
+			return
+		}
+
+		g.logRequestErrorf(
+			req,
+			"failed to get cache: %s: %v",
+			name,
+			err,
+		)
+		responseInternalServerError(rw, req)
+		return
+	}
+
+	b, err := ioutil.ReadAll(content)
+	content.Close()
+	if err != nil {
+		g.logRequestErrorf(
+			req,
+			"failed to read cache: %s: %v",
+			name,
+			err,
+		)
+		responseInternalServerError(rw, req)
+		return
+	}
+
+	summary, err := summarizeGoMod(name, moduleVersion, b)
+	if err != nil {
+		responseString(
+			rw,
+			req,
+			http.StatusUnprocessableEntity,
+			60,
+			fmt.Sprint("invalid go.mod: ", err),
+		)
+		return
+	}
+
+	responseJSON(rw, req, 60, summary)
+}
+
+// summarizeGoMod returns the [GoModSummary] of the go.mod file b of the module
+// version, where the file is only used in error messages.
+//
+// Go mod files using directives unknown to the [modfile] package are parsed
+// laxly, which leaves out their replacements and exclusions.
+func summarizeGoMod(
+	file string,
+	version string,
+	b []byte,
+) (*GoModSummary, error) {
+	mf, err := modfile.Parse(file, b, nil)
+	if err != nil {
+		mf, err = modfile.ParseLax(file, b, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	summary := &GoModSummary{
+		Version: version,
+		Require: []GoModRequire{},
+		Replace: []GoModReplace{},
+		Exclude: []string{},
+		Retract: []GoModRetract{},
+	}
+	if mf.Module != nil {
+		summary.Module = mf.Module.Mod.Path
+	}
+
+	if mf.Go != nil {
+		summary.Go = mf.Go.Version
+	}
+
+	for _, r := range mf.Replace {
+		summary.Replace = append(summary.Replace, GoModReplace{
+			OldPath:    r.Old.Path,
+			OldVersion: r.Old.Version,
+			NewPath:    r.New.Path,
+			NewVersion: r.New.Version,
+		})
+	}
+
+	for _, r := range mf.Require {
+		summary.Require = append(summary.Require, GoModRequire{
+			Path:        r.Mod.Path,
+			Version:     r.Mod.Version,
+			Indirect:    r.Indirect,
+			Replacement: goModReplacement(mf.Replace, r.Mod),
+		})
+	}
+
+	for _, e := range mf.Exclude {
+		summary.Exclude = append(summary.Exclude, e.Mod.String())
+	}
+
+	for _, r := range mf.Retract {
+		summary.Retract = append(summary.Retract, GoModRetract{
+			Low:       r.Low,
+			High:      r.High,
+			Rationale: r.Rationale,
+		})
+	}
+
+	return summary, nil
+}
+
+// goModReplacement returns what the mv is replaced with by the replaces, or an
+// empty string if it is not replaced. Like the Go command, a replacement of the
+// exact version takes precedence over one of all versions.
+func goModReplacement(replaces []*modfile.Replace, mv module.Version) string {
+	var replacement *modfile.Replace
+	for _, r := range replaces {
+		if r.Old.Path != mv.Path {
+			continue
+		}
+
+		if r.Old.Version == mv.Version {
+			replacement = r
+			break
+		} else if r.Old.Version == "" {
+			replacement = r
+		}
+	}
+
+	if replacement == nil {
+		return ""
+	} else if replacement.New.Version == "" {
+		return replacement.New.Path
+	}
+
+	return replacement.New.String()
+}
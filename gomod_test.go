@@ -0,0 +1,176 @@
+package goproxy
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGoproxyServeGoMod(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestGoproxyServeGoMod")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	g := &Goproxy{Cacher: DirCacher(tempDir)}
+	for name, content := range map[string]string{
+		"example.com/!foo/@v/v1.0.0.mod": `module example.com/Foo
+
+go 1.16
+
+require (
+	example.com/bar v1.0.0
+	example.com/baz v1.2.0 // indirect
+	example.com/qux v0.1.0
+)
+
+replace example.com/bar => example.com/bar v1.0.1
+
+replace example.com/baz v1.2.0 => ../baz
+
+exclude example.com/bar v0.9.0
+
+// Broken.
+retract v0.9.0
+`,
+		"example.com/@v/v1.0.0.mod": "module example.com\nrequire (\n",
+	} {
+		if err := g.Cacher.Put(
+			context.Background(),
+			name,
+			strings.NewReader(content),
+			time.Hour,
+		); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, httptest.NewRequest(
+		http.MethodGet,
+		"/-/go-mod?module=example.com/Foo&version=v1.0.0",
+		nil,
+	))
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	} else if got, want := rec.Header().Get("Content-Type"),
+		"application/json; charset=utf-8"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	var got GoModSummary
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	want := GoModSummary{
+		Module:  "example.com/Foo",
+		Version: "v1.0.0",
+		Go:      "1.16",
+		Require: []GoModRequire{
+			{
+				Path:        "example.com/bar",
+				Version:     "v1.0.0",
+				Replacement: "example.com/bar@v1.0.1",
+			},
+			{
+				Path:        "example.com/baz",
+				Version:     "v1.2.0",
+				Indirect:    true,
+				Replacement: "../baz",
+			},
+			{Path: "example.com/qux", Version: "v0.1.0"},
+		},
+		Replace: []GoModReplace{
+			{
+				OldPath:    "example.com/bar",
+				NewPath:    "example.com/bar",
+				NewVersion: "v1.0.1",
+			},
+			{
+				OldPath:    "example.com/baz",
+				OldVersion: "v1.2.0",
+				NewPath:    "../baz",
+			},
+		},
+		Exclude: []string{"example.com/bar@v0.9.0"},
+		Retract: []GoModRetract{
+			{Low: "v0.9.0", High: "v0.9.0", Rationale: "Broken."},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	for n, tt := range []struct {
+		query string
+		want  int
+	}{
+		{"module=example.com&version=v1.1.0", http.StatusNotFound},
+		{
+			"module=example.com&version=v1.0.0",
+			http.StatusUnprocessableEntity,
+		},
+		{"module=-&version=v1.0.0", http.StatusBadRequest},
+		{"module=example.com&version=%00", http.StatusBadRequest},
+	} {
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest(
+			http.MethodGet,
+			"/-/go-mod?"+tt.query,
+			nil,
+		))
+		if got, want := rec.Code, tt.want; got != want {
+			t.Errorf("test(%d): got %d, want %d", n, got, want)
+		}
+	}
+
+	// Modules of blocked hosts are never served.
+	g = &Goproxy{
+		Cacher:             g.Cacher,
+		BlockedModuleHosts: []string{"example.com"},
+	}
+	rec = httptest.NewRecorder()
+	g.ServeHTTP(rec, httptest.NewRequest(
+		http.MethodGet,
+		"/-/go-mod?module=example.com/Foo&version=v1.0.0",
+		nil,
+	))
+	if got, want := rec.Code, http.StatusForbidden; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
+
+func TestGoModReplacement(t *testing.T) {
+	summary, err := summarizeGoMod("go.mod", "v1.0.0", []byte(`module m
+
+require (
+	example.com/a v1.0.0
+	example.com/b v1.0.0
+)
+
+replace example.com/a => example.com/a2 v2.0.0
+
+replace example.com/a v1.0.0 => example.com/a3 v3.0.0
+
+replace example.com/b v0.1.0 => example.com/b2 v2.0.0
+`))
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	for n, want := range []string{"example.com/a3@v3.0.0", ""} {
+		got := summary.Require[n].Replacement
+		if got != want {
+			t.Errorf("test(%d): got %q, want %q", n, got, want)
+		}
+	}
+}
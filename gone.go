@@ -0,0 +1,201 @@
+package goproxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// UpstreamGonePolicy is the policy of a [Goproxy] for the cached module
+// versions that upstream starts reporting as gone (410 Gone), which usually
+// means that they have been removed for legal or security reasons.
+type UpstreamGonePolicy int
+
+const (
+	// UpstreamGoneIgnore keeps serving the cached copies as if nothing
+	// happened.
+	UpstreamGoneIgnore UpstreamGonePolicy = iota
+
+	// UpstreamGoneFlag logs the event and flags the cached copies for
+	// review, but keeps serving them.
+	UpstreamGoneFlag
+
+	// UpstreamGoneEvict logs the event, flags the cached copies and purges
+	// them (see the [Goproxy.Purge]), so that they are no longer served.
+	UpstreamGoneEvict
+)
+
+const (
+	// goneFlagsNamePrefix is the prefix of the names of the caches holding
+	// the [goneFlags] of modules.
+	goneFlagsNamePrefix = apiPathPrefix + "gone/"
+
+	// goneFlagsCacheExpiration is the expiration of the caches holding the
+	// [goneFlags]. It outlives the purged caches, since the flags are what
+	// tells why they are gone.
+	goneFlagsCacheExpiration = 365 * 24 * time.Hour
+)
+
+// goneFlag describes a cached module version that upstream has reported as
+// gone.
+type goneFlag struct {
+	// Reason is the error returned by upstream.
+	Reason string
+
+	// Time is when upstream was found reporting the module version as
+	// gone.
+	Time time.Time
+
+	// Evicted indicates whether the cached copies have been purged.
+	Evicted bool
+}
+
+// goneFlags maps module versions to their [goneFlag]s.
+type goneFlags map[string]*goneFlag
+
+// handleUpstreamGone applies the [Goproxy.UpstreamGonePolicy] to the module
+// version targeted by the f if the err, which has been returned by an upstream
+// module proxy while executing the f, is a 410 Gone of it and the module
+// version has been cached. Errors of local policies (e.g. blocked modules and
+// negative caches), which may also satisfy errors.Is(err, errGone), are
+// ignored.
+func (g *Goproxy) handleUpstreamGone(ctx context.Context, f *fetch, err error) {
+	if g.UpstreamGonePolicy == UpstreamGoneIgnore ||
+		!errors.Is(err, errUpstreamGone) ||
+		f.rewrittenModulePath != "" ||
+		!semver.IsValid(f.moduleVersion) {
+		return
+	}
+
+	e, explainErr := g.explain(f.modulePath, f.moduleVersion)
+	if explainErr != nil {
+		return
+	}
+
+	// Only the module files of the version are checked, the version list
+	// and the latest version belong to the whole module.
+	cached := false
+	for _, name := range e.CacheNames[2:] {
+		if content, err := g.cache(ctx, name); err == nil {
+			content.Close()
+			cached = true
+			break
+		}
+	}
+
+	if !cached {
+		return
+	}
+
+	g.logErrorf(
+		"upstream reports cached module version gone: %s: %v",
+		f.modAtVer,
+		err,
+	)
+
+	gf := &goneFlag{Reason: err.Error(), Time: time.Now()}
+	if g.UpstreamGonePolicy == UpstreamGoneEvict {
+		if _, err := g.Purge(
+			ctx,
+			f.modulePath,
+			f.moduleVersion,
+		); err != nil {
+			g.logErrorf(
+				"failed to evict gone module version: %s: %v",
+				f.modAtVer,
+				err,
+			)
+		} else {
+			gf.Evicted = true
+		}
+	}
+
+	if err := g.putGoneFlag(
+		ctx,
+		f.modulePath,
+		f.moduleVersion,
+		gf,
+	); err != nil {
+		g.logErrorf(
+			"failed to flag gone module version: %s: %v",
+			f.modAtVer,
+			err,
+		)
+	}
+}
+
+// goneFlags returns the [goneFlags] of the modulePath. It returns nil if there
+// is none.
+func (g *Goproxy) goneFlags(ctx context.Context, modulePath string) goneFlags {
+	escapedModulePath, err := module.EscapePath(modulePath)
+	if err != nil {
+		return nil
+	}
+
+	b, err := g.cacheBytes(ctx, goneFlagsNamePrefix+escapedModulePath)
+	if err != nil {
+		return nil
+	}
+
+	var gfs goneFlags
+	if json.Unmarshal(b, &gfs) != nil {
+		return nil
+	}
+
+	return gfs
+}
+
+// putGoneFlag adds the gf of the moduleVersion to the [goneFlags] of the
+// modulePath.
+func (g *Goproxy) putGoneFlag(
+	ctx context.Context,
+	modulePath string,
+	moduleVersion string,
+	gf *goneFlag,
+) error {
+	escapedModulePath, err := module.EscapePath(modulePath)
+	if err != nil {
+		return err
+	}
+
+	g.goneFlagsMutex.Lock()
+	defer g.goneFlagsMutex.Unlock()
+
+	gfs := g.goneFlags(ctx, modulePath)
+	if gfs == nil {
+		gfs = goneFlags{}
+	}
+
+	gfs[moduleVersion] = gf
+
+	b, err := json.Marshal(gfs)
+	if err != nil {
+		return err
+	}
+
+	return g.putCache(
+		ctx,
+		goneFlagsNamePrefix+escapedModulePath,
+		bytes.NewReader(b),
+		goneFlagsCacheExpiration,
+	)
+}
+
+// serveGoneFlags serves gone flags requests, which report the versions of a
+// module that upstream has reported as gone while cached. The module is taken
+// from the "module" query parameter.
+func (g *Goproxy) serveGoneFlags(rw http.ResponseWriter, req *http.Request) {
+	gfs := g.goneFlags(req.Context(), req.URL.Query().Get("module"))
+	if len(gfs) == 0 {
+		responseNotFound(rw, req, -2, errors.New("no gone versions"))
+		return
+	}
+
+	responseJSON(rw, req, -2, gfs)
+}
@@ -0,0 +1,250 @@
+package goproxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGoproxyUpstreamGone(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestGoproxyUpstreamGone")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	server := httptest.NewServer(http.HandlerFunc(func(
+		rw http.ResponseWriter,
+		req *http.Request,
+	) {
+		responseString(rw, req, http.StatusGone, -2, "removed")
+	}))
+	defer server.Close()
+
+	admin := func(*http.Request) bool { return true }
+	for _, policy := range []UpstreamGonePolicy{
+		UpstreamGoneIgnore,
+		UpstreamGoneFlag,
+		UpstreamGoneEvict,
+	} {
+		g := &Goproxy{
+			GoBinEnv: []string{
+				"GOPROXY=" + server.URL,
+				"GOSUMDB=off",
+			},
+			Cacher: DirCacher(filepath.Join(
+				tempDir,
+				fmt.Sprint(policy),
+			)),
+			TempDir:            tempDir,
+			UpstreamGonePolicy: policy,
+			AdminAuthorizer:    admin,
+			ErrorLogger:        log.New(&discardWriter{}, "", 0),
+		}
+
+		if err := g.Cacher.Put(
+			context.Background(),
+			"example.com/@v/v1.0.0.info",
+			strings.NewReader(`{"Version":"v1.0.0"}`),
+			time.Hour,
+		); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		get := func(path string) (int, string) {
+			rec := httptest.NewRecorder()
+			g.ServeHTTP(rec, httptest.NewRequest("", path, nil))
+			return rec.Code, rec.Body.String()
+		}
+
+		if code, _ := get("/example.com/@v/v1.0.0.mod"); code !=
+			http.StatusGone {
+			t.Fatalf("got %d, want %d", code, http.StatusGone)
+		}
+
+		_, err := g.Cacher.Get(
+			context.Background(),
+			"example.com/@v/v1.0.0.info",
+		)
+		if policy == UpstreamGoneEvict {
+			if !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf(
+					"got error %q, want error %q",
+					err,
+					os.ErrNotExist,
+				)
+			}
+		} else if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		code, body := get("/-/gone?module=example.com")
+		if policy == UpstreamGoneIgnore {
+			if want := http.StatusNotFound; code != want {
+				t.Errorf("got %d, want %d", code, want)
+			}
+
+			continue
+		} else if code != http.StatusOK {
+			t.Fatalf("got %d, want %d", code, http.StatusOK)
+		}
+
+		var gfs goneFlags
+		if err := json.Unmarshal([]byte(body), &gfs); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		gf := gfs["v1.0.0"]
+		if gf == nil {
+			t.Fatal("expected gone flag")
+		} else if got, want := gf.Reason, "removed"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		} else if got, want := gf.Evicted,
+			policy == UpstreamGoneEvict; got != want {
+			t.Errorf("got %t, want %t", got, want)
+		}
+	}
+
+	// Versions served by a later GOPROXY entry are not flagged.
+	fallbackServer := httptest.NewServer(http.HandlerFunc(func(
+		rw http.ResponseWriter,
+		req *http.Request,
+	) {
+		if req.URL.Path != "/example.com/@v/v1.0.0.mod" {
+			responseNotFound(rw, req, -2)
+			return
+		}
+
+		responseString(rw, req, http.StatusOK, -2, "module example.com")
+	}))
+	defer fallbackServer.Close()
+
+	g := &Goproxy{
+		GoBinEnv: []string{
+			"GOPROXY=" + server.URL + "," + fallbackServer.URL,
+			"GOSUMDB=off",
+		},
+		Cacher: DirCacher(filepath.Join(
+			tempDir,
+			"fallback",
+		)),
+		TempDir:            tempDir,
+		UpstreamGonePolicy: UpstreamGoneEvict,
+		ErrorLogger:        log.New(&discardWriter{}, "", 0),
+	}
+
+	if err := g.Cacher.Put(
+		context.Background(),
+		"example.com/@v/v1.0.0.info",
+		strings.NewReader(`{"Version":"v1.0.0"}`),
+		time.Hour,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, httptest.NewRequest(
+		"",
+		"/example.com/@v/v1.0.0.mod",
+		nil,
+	))
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	if _, err := g.Cacher.Get(
+		context.Background(),
+		"example.com/@v/v1.0.0.info",
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if gfs := g.goneFlags(
+		context.Background(),
+		"example.com",
+	); len(gfs) != 0 {
+		t.Errorf("got %v, want none", gfs)
+	}
+
+	// Versions that have never been cached are not flagged.
+	g = &Goproxy{
+		GoBinEnv: []string{
+			"GOPROXY=" + server.URL,
+			"GOSUMDB=off",
+		},
+		Cacher:             DirCacher(filepath.Join(tempDir, "none")),
+		TempDir:            tempDir,
+		UpstreamGonePolicy: UpstreamGoneFlag,
+		ErrorLogger:        log.New(&discardWriter{}, "", 0),
+	}
+
+	rec = httptest.NewRecorder()
+	g.ServeHTTP(rec, httptest.NewRequest(
+		"",
+		"/example.com/@v/v1.0.0.mod",
+		nil,
+	))
+	if got, want := rec.Code, http.StatusGone; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	if gfs := g.goneFlags(
+		context.Background(),
+		"example.com",
+	); len(gfs) != 0 {
+		t.Errorf("got %v, want none", gfs)
+	}
+
+	// Errors of local policies are not taken as upstream reports.
+	g = &Goproxy{
+		GoBinEnv:           []string{"GOPROXY=off", "GOSUMDB=off"},
+		Cacher:             DirCacher(filepath.Join(tempDir, "local")),
+		TempDir:            tempDir,
+		UpstreamGonePolicy: UpstreamGoneEvict,
+		ErrorLogger:        log.New(&discardWriter{}, "", 0),
+	}
+	g.initOnce.Do(g.init)
+
+	if err := g.Cacher.Put(
+		context.Background(),
+		"example.com/@v/v1.0.0.info",
+		strings.NewReader(`{"Version":"v1.0.0"}`),
+		time.Hour,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	f, err := newFetch(g, "example.com/@v/v1.0.0.mod", tempDir)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	g.handleUpstreamGone(
+		context.Background(),
+		f,
+		goneError("negative cache hit"),
+	)
+	if _, err := g.Cacher.Get(
+		context.Background(),
+		"example.com/@v/v1.0.0.info",
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if gfs := g.goneFlags(
+		context.Background(),
+		"example.com",
+	); len(gfs) != 0 {
+		t.Errorf("got %v, want none", gfs)
+	}
+}
@@ -12,18 +12,31 @@ import (
 	"log"
 	"math"
 	"math/rand"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"golang.org/x/mod/module"
 	"golang.org/x/mod/sumdb"
 )
 
+const (
+	// defaultCacheExpiration is the default expiration of caches.
+	defaultCacheExpiration = time.Minute
+
+	// foreverCacheExpiration is the expiration of the caches that are
+	// meant to never expire.
+	foreverCacheExpiration = 100 * 365 * 24 * time.Hour
+)
+
 // Goproxy is the top-level struct of this project.
 //
 // Note that the Goproxy will still follow your environment variables. Which
@@ -40,7 +53,8 @@ import (
 // true" that instructs it to return only cached content.
 //
 // Make sure that all fields of the Goproxy have been finalized before calling
-// any of its methods.
+// any of its methods. The [Settings] can then be changed via the
+// [Goproxy.UpdateSettings].
 type Goproxy struct {
 	// GoBinName is the name of the Go binary.
 	//
@@ -69,6 +83,162 @@ type Goproxy struct {
 	// If the GoBinMaxWorkers is zero, there is no limit.
 	GoBinMaxWorkers int
 
+	// GoBinMaxWorkersPerModule is the maximum number of commands allowed
+	// for the Go binary to execute at the same time for the same module
+	// path. The slots freed by the commands are granted to the waiting
+	// module paths in turn, so that a single module path (e.g. a giant
+	// monorepo module being listed) cannot occupy all slots of the
+	// [Goproxy.GoBinMaxWorkers] while other modules starve.
+	//
+	// If the GoBinMaxWorkersPerModule is zero, there is no limit per
+	// module path, but the slots are still granted in turn.
+	GoBinMaxWorkersPerModule int
+
+	// GoBinAllowedVCS is the list of version control systems (any of
+	// "bzr", "fossil", "git", "hg" and "svn") that the Go binary targeted
+	// by the [Goproxy.GoBinName] is allowed to use when fetching modules
+	// directly. Modules known to use other version control systems are
+	// rejected before the Go binary is called, and the list is also passed
+	// to the Go binary as the GOVCS (at least Go 1.16 is required for it to
+	// take effect).
+	//
+	// If the GoBinAllowedVCS is nil, all version control systems are
+	// allowed.
+	GoBinAllowedVCS []string
+
+	// GoBinVCS is the GOVCS policy (see https://go.dev/ref/mod#vcs-govcs)
+	// of the version control systems that the Go binary targeted by the
+	// [Goproxy.GoBinName] is allowed to use per module path pattern when
+	// fetching modules directly, such as "github.com:git,private:all". The
+	// "public" and "private" patterns are resolved against the GOPRIVATE
+	// of the [Goproxy.GoBinEnv]. Modules known to violate the policy are
+	// rejected with a policy violation error before the Go binary is
+	// called, and the policy is also passed to the Go binary as the GOVCS.
+	// Either way, clients get a 403 Forbidden instead of silently falling
+	// back to their next module proxy.
+	//
+	// If the GoBinVCS is empty, the GOVCS of the [Goproxy.GoBinEnv], if
+	// any, is enforced the same way. The [Goproxy.GoBinAllowedVCS] is
+	// enforced on top of it by restricting every rule of the policy to it,
+	// and the result is passed to the Go binary as a single GOVCS.
+	GoBinVCS string
+
+	// GoBinSandbox is the [GoBinSandbox] that the Go binary targeted by the
+	// [Goproxy.GoBinName] runs inside.
+	//
+	// If the GoBinSandbox is nil, the Go binary runs without a sandbox.
+	GoBinSandbox *GoBinSandbox
+
+	// ProxiedOnly indicates whether to never fetch module files directly
+	// from their version control systems, even if the [Goproxy.GoBinEnv]
+	// says so (e.g. "direct" in GOPROXY or a module matched by GONOPROXY).
+	// Such fetches fail with a 403 Forbidden instead. It is useful for
+	// locked-down environments with no outbound version control access.
+	ProxiedOnly bool
+
+	// DeterministicZips indicates whether to repack the module zip files
+	// fetched directly into a deterministic form (entries sorted by name,
+	// zeroed timestamps and no extra fields) before verifying and caching
+	// them, so that they are byte-for-byte reproducible across proxy
+	// instances built with the same Go version. The hashes recorded in
+	// go.sum files are not affected.
+	DeterministicZips bool
+
+	// NativeDirectFetches indicates whether to fetch modules directly from
+	// their Git repositories in-process, using the git binary only for the
+	// repository operations, instead of running the Go binary targeted by
+	// the [Goproxy.GoBinName]. Versions are resolved, pseudo-versions are
+	// built and module zip files are created by the [Goproxy] itself,
+	// which saves a Go binary process per fetch.
+	//
+	// This is only a partial alternative to the Go binary: it does not use
+	// a pure-Go Git implementation, so the git binary is required, and
+	// the Go binary is still required as well. Fetches that are not
+	// supported in-process, such as the ones of modules in non-Git
+	// repositories, gopkg.in modules, "+incompatible" versions, version
+	// queries or commits not reachable from any branch or tag, are left
+	// to it.
+	NativeDirectFetches bool
+
+	// StreamZipDownloads indicates whether to stream the module zip files
+	// fetched from upstream module proxies to the clients while they are
+	// being downloaded, instead of only once they have been downloaded,
+	// verified and cached. The zip files are still only cached once
+	// verified. If the verification fails, the responses are aborted so
+	// that the clients do not take them for complete ones.
+	//
+	// Module zip files fetched directly from their version control
+	// systems, those of rewritten modules (see the [Goproxy.PathRewrites])
+	// and those that are double fetched (see the
+	// [Goproxy.DoubleFetchModules]) are never streamed.
+	StreamZipDownloads bool
+
+	// CorrectInfoTimes indicates whether to correct the bogus times (zero,
+	// not after the Unix epoch, or far in the future) of the info files
+	// and resolves fetched from upstream module proxies, which otherwise
+	// break the tools that sort module versions by time. The time encoded
+	// in a pseudo-version is used as is, while the commit time of any
+	// other version is fetched directly from its version control system.
+	//
+	// Bogus times are counted in the [Stats.BogusInfoTimes] and logged
+	// either way. Those that cannot be corrected are kept, except for zero
+	// times, which are rejected as usual.
+	CorrectInfoTimes bool
+
+	// DoubleFetchModules is a comma-separated list of glob patterns (in the
+	// syntax of the [path.Match], matching module path prefixes like the
+	// GOPRIVATE) of the high-risk modules whose ".mod" and ".zip" files
+	// are fetched from two independent upstreams to protect the caches
+	// from poisoning: the one given by the [Goproxy.GoBinEnv] as usual,
+	// and the [Goproxy.DoubleFetchProxy]. The files are only cached and
+	// served if both hash the same, otherwise the fetch fails and the
+	// divergence is reported as a [DoubleFetchMismatch].
+	//
+	// If the DoubleFetchModules or the DoubleFetchProxy is empty, no module
+	// is double fetched.
+	DoubleFetchModules string
+
+	// DoubleFetchProxy is the independent upstream that the files of the
+	// [Goproxy.DoubleFetchModules] are fetched from again: either a module
+	// proxy URL, or "direct" to fetch them directly from their version
+	// control systems. Files fetched from the DoubleFetchProxy in the
+	// first place are not fetched again.
+	DoubleFetchProxy string
+
+	// OnDoubleFetchMismatch is called with each [DoubleFetchMismatch]
+	// detected for the [Goproxy.DoubleFetchModules], e.g. to alert on
+	// suspected cache poisoning attempts.
+	//
+	// If the OnDoubleFetchMismatch is nil, the mismatches are logged via
+	// the [Goproxy.ErrorLogger].
+	OnDoubleFetchMismatch func(dfm *DoubleFetchMismatch)
+
+	// BlockedModuleHosts is the list of hostname patterns of the modules
+	// that are blocked. A pattern of the form "*.suffix" (e.g. "*.ru")
+	// matches the suffix itself and all its subdomains, while any other
+	// pattern matches exactly. The patterns are matched case-insensitively
+	// against the first path element of module paths before any network
+	// access, so blocked modules are never fetched or served from the
+	// [Goproxy.Cacher].
+	//
+	// Blocked modules are responded with a 403 Forbidden.
+	//
+	// If the BlockedModuleHosts is empty, no module is blocked by its host.
+	BlockedModuleHosts []string
+
+	// BlockedModuleIPNets is the list of IP networks that modules are not
+	// allowed to be fetched directly from. Before a module is fetched
+	// directly, the first path element of its module path is resolved,
+	// and the module is blocked if any of the resolved IP addresses is in
+	// the BlockedModuleIPNets, or if the resolution fails.
+	//
+	// Note that only the host of the module path is checked. The hosts
+	// that vanity import paths redirect to are not.
+	//
+	// If the BlockedModuleIPNets is empty, no module is blocked by its IP
+	// addresses.
+	BlockedModuleIPNets []*net.IPNet
+
 	// PathPrefix is the prefix of all request paths. It will be used to
 	// trim the request paths via the [strings.TrimPrefix].
 	//
@@ -88,6 +258,40 @@ type Goproxy struct {
 	// If the CacherMaxCacheBytes is zero, there is no limit.
 	CacherMaxCacheBytes int
 
+	// CacherVerifySizes indicates whether to verify that the size of each
+	// cache read back from the [Goproxy.Cacher] right after being put
+	// matches the size of the content put. Mismatched caches are deleted
+	// if the Cacher implements the [Deleter]. It is for catching caches
+	// truncated by unreliable storage backends.
+	CacherVerifySizes bool
+
+	// CacherClockSkew is how far the clocks of the machines that put caches
+	// into the [Goproxy.Cacher] may run behind the local one, e.g. when
+	// multiple instances share a network file system. Caches are only
+	// treated as expired once their expiration times have passed by more
+	// than it, so that caches put with short expirations by instances with
+	// slow clocks are not missed right after being put. It applies to the
+	// cachers that store expiration times computed by the putting machines,
+	// such as the [DirCacher], the [S3Cacher] and the [AzureBlobCacher].
+	CacherClockSkew time.Duration
+
+	// FetchLocker is used to coordinate the downloads of module versions
+	// across multiple instances sharing the same [Goproxy.Cacher], so that
+	// only one of them downloads each module version while the others
+	// wait for it and then read the module files from the cache.
+	//
+	// If the FetchLocker is nil, downloads are only deduplicated within
+	// the Goproxy.
+	FetchLocker FetchLocker
+
+	// Fetcher is used to fetch module files that are missing from the
+	// [Goproxy.Cacher], e.g. from an artifact repository, an OCI registry
+	// or a test fake. The module files it returns are still checked, and
+	// verified against the checksum database if required.
+	//
+	// If the Fetcher is nil, the [Goproxy.UpstreamFetcher] is used.
+	Fetcher Fetcher
+
 	// ProxiedSUMDBs is the list of proxied checksum databases (see
 	// https://go.dev/design/25530-sumdb#proxying-a-checksum-database). Each
 	// entry is of the form "<sumdb-name>" or "<sumdb-name> <sumdb-URL>".
@@ -95,19 +299,109 @@ type Goproxy struct {
 	// corresponding <sumdb-URL> will be the <sumdb-name> itself as a host
 	// with an "https" scheme.
 	//
+	// Several checksum databases, e.g. the "sum.golang.org" and a private
+	// one, can be proxied at the same time. Requests are routed by the
+	// checksum database name in their paths, which may contain slashes
+	// (e.g. "sumdb.example.com/private"), and their caches are kept apart.
+	//
 	// If the ProxiedSUMDBs contains duplicate checksum database names, only
 	// the last value in the slice for each duplicate checksum database name
 	// is used.
 	ProxiedSUMDBs []string
 
+	// SUMDBKeys is the list of verifier keys (e.g. "sum.golang.org+033de0ae+
+	// Ac4zctda0e5eza+HJyk9SxEdh+s3Ux18htTTAD8OuAn8") pinned for the
+	// checksum database of the GOSUMDB, instead of relying on the ones
+	// built into the Go toolchain. If the GOSUMDB only names a checksum
+	// database with pinned keys, the first of them is used. If it gives a
+	// key of its own, that key must be pinned. Pinning more than one key for
+	// the same checksum database accepts the rotation to any of them.
+	//
+	// The key used for each checksum database is stored in the
+	// [Goproxy.Cacher] on first use, and using a different one later fails
+	// with an error unless the new key is pinned, so that a changed key
+	// never goes unnoticed.
+	//
+	// If the SUMDBKeys is empty, the key of the GOSUMDB is trusted on first
+	// use.
+	SUMDBKeys []string
+
 	// Transport is used to perform all requests except those started by
 	// calling the Go binary targeted by the [Goproxy.GoBinName].
 	//
 	// If the Transport is nil, the [http.DefaultTransport] is used.
 	Transport http.RoundTripper
 
+	// UserAgent is the User-Agent of the requests sent to upstream module
+	// proxies and checksum databases, which helps their operators to
+	// identify the Goproxy. It does not apply to the requests sent by the
+	// Go binary targeted by the [Goproxy.GoBinName].
+	//
+	// If the UserAgent is empty, "goproxy/<version> (instance <id>)" is
+	// used, where the <id> is the [Goproxy.InstanceID].
+	UserAgent string
+
+	// UpstreamCredentials is the list of credentials attached to the
+	// requests sent to upstream module proxies and checksum databases,
+	// so that the Goproxy can sit in front of authenticated ones. For each
+	// request, the one with the longest matching prefix is used. Requests
+	// that already carry an Authorization (e.g. from the user information
+	// in the GOPROXY) are sent as they are.
+	//
+	// If the UpstreamCredentials is empty, only the
+	// [Goproxy.UpstreamNetrc] is used.
+	UpstreamCredentials []UpstreamCredential
+
+	// UpstreamNetrc is the path of a .netrc file whose credentials are
+	// attached, as the basic authentication, to the requests sent to the
+	// upstreams not matching any of the [Goproxy.UpstreamCredentials]. The
+	// file is reread whenever it changes. It is also passed to the Go
+	// binary targeted by the [Goproxy.GoBinName] as the NETRC.
+	//
+	// If the UpstreamNetrc is empty, no .netrc file is used by the Goproxy
+	// itself.
+	UpstreamNetrc string
+
+	// InstanceID identifies the Goproxy within a fleet of instances. It is
+	// part of the default [Goproxy.UserAgent] and reported by the
+	// [Goproxy.BuildInfo].
+	//
+	// If the InstanceID is empty, the hostname is used.
+	InstanceID string
+
+	// HotCacheMaxBytes is the memory budget, in bytes, for serving the
+	// hottest cached files (those hit at least 3 times within a minute)
+	// from memory-mapped files instead of reading them from disk again
+	// and again. Files without hits for a minute, or colder than newly hot
+	// ones when the budget is exhausted, are demoted automatically. It
+	// only applies to the caches opened as local files (e.g. by the
+	// [DirCacher]) on platforms supporting memory-mapped files.
+	//
+	// If the HotCacheMaxBytes is zero, no files are memory-mapped.
+	HotCacheMaxBytes int64
+
+	// Peers is a list of base URLs (e.g. "http://10.0.0.2:8080") of peer
+	// instances of the Goproxy that are asked for the module files
+	// (".info", ".mod" and ".zip") missing from the [Goproxy.Cacher]
+	// before fetching them from upstream, so that a fleet of instances
+	// with separate caches downloads each of them only once. A base URL
+	// whose scheme is prefixed with "dns+" (e.g.
+	// "dns+http://goproxy.internal:8080") is expanded into one peer per
+	// address its host resolves to, refreshed every 30 seconds.
+	//
+	// The peers are asked with the "Cache-Control: only-if-cached", so
+	// that they answer from their own caches only. Note that the peers are
+	// trusted as they are, and that listing the Goproxy itself as a peer
+	// is harmless but wasteful.
+	Peers []string
+
 	// TempDir is the directory for storing temporary files.
 	//
+	// When the Cacher is a [DirCacher] (or a [ShardedDirCacher]), placing
+	// the TempDir on the same file system as its directory lets fetched
+	// module files be hard-linked into place rather than copied. Only
+	// files inside the TempDir are ever hard-linked.
+	//
 	// If the TempDir is empty, the [os.TempDir] is used.
 	TempDir string
 
@@ -118,17 +412,485 @@ type Goproxy struct {
 	// standard logger.
 	ErrorLogger *log.Logger
 
-	initOnce          sync.Once
-	goBinName         string
-	goBinEnv          []string
-	goBinEnvGOPROXY   string
-	goBinEnvGONOPROXY string
-	goBinEnvGOSUMDB   string
-	goBinEnvGONOSUMDB string
-	goBinWorkerChan   chan struct{}
-	proxiedSUMDBs     map[string]*url.URL
-	httpClient        *http.Client
-	sumdbClient       *sumdb.Client
+	// ClientQuota is the [ClientQuota] that limits the bytes served per
+	// client per time window. The usage can be inspected via the
+	// "/-/quota" administrative endpoint.
+	//
+	// If the ClientQuota is nil, there is no limit.
+	ClientQuota *ClientQuota
+
+	// ListCacheExpiration is the expiration of the caches of the version
+	// lists ("@v/list"), which change whenever new versions are
+	// published.
+	//
+	// If the ListCacheExpiration is zero, one minute is used.
+	ListCacheExpiration time.Duration
+
+	// LatestCacheExpiration is the expiration of the caches of the
+	// resolved versions ("@latest" and version queries), which change
+	// whenever new versions are published.
+	//
+	// If the LatestCacheExpiration is zero, one minute is used.
+	LatestCacheExpiration time.Duration
+
+	// ImmutableCacheExpiration is the expiration of the caches of the
+	// module files (".info", ".mod" and ".zip") of module versions, which
+	// never change once published. The
+	// [Goproxy.PseudoVersionCacheExpiration] takes precedence for the
+	// pseudo-versions.
+	//
+	// If the ImmutableCacheExpiration is zero, one minute is used. If it is
+	// negative, the caches never expire.
+	ImmutableCacheExpiration time.Duration
+
+	// PseudoVersionCacheExpiration is the expiration of the caches of the
+	// module files of pseudo-versions, which are rarely requested again
+	// once newer commits have been made. It is for letting the
+	// pseudo-versions be reclaimed by the [Goproxy.Cleanup] earlier (or
+	// later) than the release versions. The reclaimed space is reported
+	// by the [Goproxy.Stats].
+	//
+	// If the PseudoVersionCacheExpiration is zero, the caches of the
+	// pseudo-versions expire like any other caches.
+	PseudoVersionCacheExpiration time.Duration
+
+	// NotFoundCacheExpiration is the expiration of the caches of the
+	// not-found (404 Not Found and 410 Gone) results of fetching module
+	// files, so that repeated requests for nonexistent module versions
+	// (e.g. from CI farms) are answered without asking upstream or running
+	// the Go binary again. Timeouts and bad upstream responses are never
+	// cached.
+	//
+	// If the NotFoundCacheExpiration is zero, not-found results are not
+	// cached.
+	NotFoundCacheExpiration time.Duration
+
+	// StaleIfErrorExpiration is how long a copy of each version list and
+	// resolved version ("@v/list", "@latest" and version queries) is kept,
+	// since it was fetched, for serving it stale when fetching it again
+	// fails for an upstream failure (e.g. an outage of the module proxy or
+	// the VCS), instead of failing the request. Stale responses carry the
+	// `Warning: 110 - "Response is Stale"` response header and are never
+	// cached by clients. Not-found results from upstream are never
+	// answered with stale copies.
+	//
+	// If the StaleIfErrorExpiration is zero, no stale copies are kept.
+	StaleIfErrorExpiration time.Duration
+
+	// StaleWhileRevalidate indicates whether version lists and resolved
+	// versions are served from the cache while their caches have not
+	// expired (see the ListCacheExpiration and the LatestCacheExpiration),
+	// instead of being fetched for every request. Once expired, they are
+	// served immediately with their stale copies (see the
+	// StaleIfErrorExpiration) while being refreshed in the background, so
+	// that many clients hitting a just-expired cache at once (e.g. CI
+	// jobs) do not all wait for upstream. Concurrent refreshes of the same
+	// cache are merged into one, and at most the MaxReadAheads refreshes
+	// run at the same time.
+	//
+	// The StaleWhileRevalidate has no effect if the StaleIfErrorExpiration
+	// is zero.
+	StaleWhileRevalidate bool
+
+	// EmergencyStale indicates whether the emergency stale mode is
+	// enabled, in which version lists and resolved versions are served as
+	// with the StaleWhileRevalidate, so that expired ones are served
+	// immediately with their stale copies (see the StaleIfErrorExpiration)
+	// instead of waiting for upstreams that are down. It is meant to be
+	// switched on via the [Goproxy.UpdateSettings] during upstream
+	// outages to keep builds running. Module files that have never been
+	// cached are still fetched.
+	//
+	// The EmergencyStale has no effect if the StaleIfErrorExpiration is
+	// zero.
+	EmergencyStale bool
+
+	// EmergencyStaleThreshold is the number of consecutive upstream
+	// failures (i.e. fetches that failed for all upstreams, see the
+	// StaleIfErrorExpiration) after which the emergency stale mode (see
+	// the EmergencyStale) is entered automatically. It is left again once
+	// a fetch succeeds, which is noticed by the background refreshes of
+	// the stale copies served meanwhile.
+	//
+	// If the EmergencyStaleThreshold is zero, the emergency stale mode is
+	// never entered automatically.
+	EmergencyStaleThreshold int
+
+	// PinnedModules is the list of the modules pinned at startup, whose
+	// module files are never expired nor evicted, e.g. toolchain modules
+	// and heavily used internal libraries. Each is in the form "pattern"
+	// or "pattern@version", where the pattern is a glob (as in the
+	// [path.Match]) matched against module path prefixes, as in the
+	// GONOPROXY, and the version is an exact canonical version. Only the
+	// ".info", ".mod" and ".zip" files are pinned, since version lists
+	// and latest versions must be kept fresh.
+	//
+	// Pinned caches are put without expiration. They are only protected
+	// from evictions by a [RetentionCacher] or a [QuotaCacher] whose Pinned
+	// is the [Goproxy.Pinned]. Pins can be changed at runtime via the
+	// [Goproxy.Pin], the [Goproxy.Unpin] and the "/-/pins" administrative
+	// endpoint.
+	PinnedModules []string
+
+	// UpstreamCacheHeaders indicates whether to honor the caching headers
+	// of the responses from upstream module proxies instead of applying
+	// the same expiration to all caches. The freshness lifetime given by
+	// the Cache-Control (or the Expires) of a response becomes the
+	// expiration of its cache, during which resolves and lists are served
+	// from the cache without asking the upstream. Once stale, they are
+	// revalidated conditionally with the ETag and the Last-Modified of the
+	// response, so that a 304 Not Modified saves downloading them again.
+	//
+	// Responses without a positive freshness lifetime still expire as
+	// usual.
+	UpstreamCacheHeaders bool
+
+	// MergeLists indicates whether to merge the version lists of all the
+	// module proxies in the GOPROXY of the GoBinEnv, along with the ones
+	// merged before, into a single superset list instead of taking the
+	// first successful one. It is for upstreams that paginate or truncate
+	// their version lists. The "direct" is only consulted if none of the
+	// module proxies succeeded. The upstreams that have listed each version
+	// are tracked and served by the "/-/list-provenance?module=<path>"
+	// administrative endpoint.
+	//
+	// Note that the caching headers of the merged version lists are never
+	// honored (see the UpstreamCacheHeaders).
+	MergeLists bool
+
+	// ReadAheadExts is the list of extensions (".mod" and ".zip") of the
+	// module files to prefetch into the [Goproxy.Cacher] in the background
+	// when the ".info" of a module version is requested or resolved, since
+	// the go command usually requests them right after.
+	//
+	// Note that the read-ahead is mostly useful for the modules fetched
+	// from upstream module proxies, since fetching a module version
+	// directly always downloads all its module files at once.
+	//
+	// If the ReadAheadExts is empty, there is no read-ahead.
+	ReadAheadExts []string
+
+	// MaxReadAheads is the maximum number of read-aheads allowed to run at
+	// the same time. Read-aheads beyond it are dropped.
+	//
+	// If the MaxReadAheads is zero, 8 is used.
+	MaxReadAheads int
+
+	// UpstreamRateLimit is the rate limit, in requests per second, of each
+	// upstream host (e.g. as published by the proxy.golang.org) that bulk
+	// work, namely read-aheads, [Warmer]s and [ConsistencyChecker]s, is
+	// spread over time to respect. Requests made on behalf of clients are
+	// never delayed, but they count toward the rate limit, so that bulk
+	// work only uses the headroom they leave and pauses while less than
+	// half of the [Goproxy.UpstreamRateBurst] remains. Bulk work sent to
+	// an upstream host is also paused after a 429 Too Many Requests (or a
+	// 503 Service Unavailable with a Retry-After) for as long as asked, or
+	// for one minute.
+	//
+	// Note that the fetches made by the Go binary are not scheduled.
+	//
+	// If the UpstreamRateLimit is zero, bulk work is not rate limited.
+	UpstreamRateLimit float64
+
+	// UpstreamRateBurst is the maximum number of requests sent to each
+	// upstream host in a burst under the [Goproxy.UpstreamRateLimit].
+	//
+	// If the UpstreamRateBurst is zero, the UpstreamRateLimit rounded up
+	// (at least one) is used.
+	UpstreamRateBurst int
+
+	// UpstreamMaxRetries is the maximum number of times that a failed
+	// request sent to an upstream module proxy or checksum database is
+	// retried, with exponential backoff, before the failure surfaces.
+	// Only network errors, truncated transfers and responses with the
+	// [Goproxy.UpstreamRetryableStatusCodes] are retried.
+	//
+	// Note that the fetches made by the Go binary are not retried.
+	//
+	// If the UpstreamMaxRetries is zero, 9 is used. If it is negative,
+	// failed requests are not retried.
+	UpstreamMaxRetries int
+
+	// UpstreamRetryBackoffBase is the base of the exponential backoff
+	// between two attempts of a request under the
+	// [Goproxy.UpstreamMaxRetries]. The backoff of the nth retry is a
+	// random duration up to the UpstreamRetryBackoffBase times 2^n, capped
+	// by the [Goproxy.UpstreamRetryBackoffCap].
+	//
+	// If the UpstreamRetryBackoffBase is zero, 100 milliseconds is used.
+	UpstreamRetryBackoffBase time.Duration
+
+	// UpstreamRetryBackoffCap is the cap of the exponential backoff between
+	// two attempts of a request under the [Goproxy.UpstreamMaxRetries].
+	//
+	// If the UpstreamRetryBackoffCap is zero, one second is used.
+	UpstreamRetryBackoffCap time.Duration
+
+	// UpstreamRetryableStatusCodes is the list of the HTTP status codes of
+	// the upstream responses that are retried under the
+	// [Goproxy.UpstreamMaxRetries]. The 400 Bad Request, 404 Not Found and
+	// 410 Gone are never retried since they are definitive answers.
+	//
+	// If the UpstreamRetryableStatusCodes is nil, the 429 Too Many
+	// Requests, 500 Internal Server Error, 502 Bad Gateway, 503 Service
+	// Unavailable and 504 Gateway Timeout are used.
+	UpstreamRetryableStatusCodes []int
+
+	// PrefetchMaxAttempts is the maximum number of attempts of a
+	// read-ahead (see the [Goproxy.ReadAheadExts]). Failed read-aheads
+	// are retried with exponential backoff, and those still failing after
+	// the PrefetchMaxAttempts are kept as dead letters, which can be
+	// inspected, requeued and discarded via the "/-/prefetches"
+	// administrative endpoint. The queue of the pending read-aheads and
+	// the dead letters is persisted in the [Goproxy.Cacher], so that it
+	// survives restarts once resumed via the [Goproxy.ResumePrefetches].
+	//
+	// If the PrefetchMaxAttempts is zero, read-aheads are attempted once
+	// and never queued.
+	PrefetchMaxAttempts int
+
+	// MaxOpenCaches is the maximum number of caches allowed to be open at
+	// the same time for serving clients. Requests beyond it wait for a
+	// cache to be closed.
+	//
+	// If the MaxOpenCaches is zero, there is no limit.
+	MaxOpenCaches int
+
+	// ResourceGuardrails indicates whether to derive the limits left zero
+	// (the [Goproxy.GoBinMaxWorkers], the [Goproxy.MaxReadAheads] and the
+	// [Goproxy.MaxOpenCaches]), as well as the buffer sizes, from the
+	// [ResourceLimits] detected at startup (e.g. the memory and the number
+	// of open files allowed for the container), so that the Goproxy does
+	// not run out of them under load. The limits in effect are reported by
+	// the [Goproxy.EffectiveLimits] and the "/-/stats" administrative
+	// endpoint.
+	ResourceGuardrails bool
+
+	// TrashRetention is the duration for which the caches purged via the
+	// [Goproxy.Purge] are kept in the trash, during which they can be
+	// restored via the [Goproxy.Restore].
+	//
+	// If the TrashRetention is zero, purged caches are removed permanently.
+	TrashRetention time.Duration
+
+	// UpstreamGonePolicy is the policy for the cached module versions that
+	// upstream starts reporting as gone (410 Gone). Upstream is found
+	// doing so when fetching the module files of a version that are not
+	// cached yet, or by a [ConsistencyChecker]. The flagged versions of a
+	// module are served by the "/-/gone?module=<path>" administrative
+	// endpoint.
+	//
+	// If the UpstreamGonePolicy is zero, the [UpstreamGoneIgnore] is used.
+	UpstreamGonePolicy UpstreamGonePolicy
+
+	// VanityImports is the list of [VanityImport]s. The Goproxy serves the
+	// "?go-get=1" meta pages for them, so that the vanity import paths can
+	// be served from the same host as the Goproxy without a separate
+	// service. All requests with the "go-get=1" query parameter are
+	// treated as vanity import requests if the VanityImports is not empty.
+	//
+	// If the VanityImports is empty, no vanity import path is served.
+	VanityImports []VanityImport
+
+	// PathRewrites is the list of [PathRewrite]s of module paths, for
+	// migrations of vanity import domains.
+	//
+	// If the PathRewrites is empty, no module path is rewritten.
+	PathRewrites []PathRewrite
+
+	// PrivateModules is a comma-separated list of glob patterns (in the
+	// syntax of the [path.Match], matching module path prefixes like the
+	// GOPRIVATE) of the private modules, which are only served to the
+	// requests authorized by the [Goproxy.PrivateModulesAuthorizer]. All
+	// other modules are served anonymously. Responses for the private
+	// modules are marked as private in their Cache-Control, so that shared
+	// caches do not serve them to other clients.
+	//
+	// If the PrivateModules is empty, all modules are served anonymously.
+	PrivateModules string
+
+	// PrivateModulesAuthorizer reports whether the req is authorized to
+	// access the [Goproxy.PrivateModules]. Unauthorized requests without
+	// an Authorization header are responded with a 401 Unauthorized
+	// asking for the basic authentication, which the go command answers
+	// with the credentials in the .netrc of the user. Other unauthorized
+	// requests are responded with a 403 Forbidden.
+	//
+	// If the PrivateModulesAuthorizer is nil, the private modules are not
+	// served to anyone.
+	PrivateModulesAuthorizer func(req *http.Request) bool
+
+	// AdminAuthorizer reports whether the req is authorized to access the
+	// administrative endpoints served under the "/-/" path (after being
+	// trimmed by the [Goproxy.PathPrefix]), such as the "/-/explain" that
+	// explains how a module will be fetched, verified and cached.
+	//
+	// If the AdminAuthorizer is nil, all administrative endpoints are
+	// disabled.
+	AdminAuthorizer func(req *http.Request) bool
+
+	// FreshnessModules is the list of module paths whose freshness is
+	// reported by the "/-/freshness" administrative endpoint, which compares
+	// the latest version of each module known to the caches with the one
+	// currently resolved from upstream, so that mirrors lagging behind can
+	// be spotted (e.g. on a dashboard).
+	//
+	// If the FreshnessModules is empty, the endpoint only reports the
+	// modules given by its "module" query parameters.
+	FreshnessModules []string
+
+	// NoFetchHeader is the name of the request header that lets a client
+	// opt in to being served only from the cache. When a module proxy
+	// request carries the header with a true value (as in the
+	// [strconv.ParseBool]), or the "Cache-Control: only-if-cached", the
+	// Goproxy never fetches anything from upstream for it and responds with
+	// a 504 Gateway Timeout if not cached. The distinct status lets build
+	// systems tell a cache miss apart from a module that does not exist.
+	//
+	// If the NoFetchHeader is empty, "GONOFETCH" is used.
+	NoFetchHeader string
+
+	// PriorityClassifier returns the [RequestPriority] of the req, e.g.
+	// based on the identity of the client, so that the capacity for
+	// fetching from upstream goes to interactive requests first.
+	//
+	// If the PriorityClassifier is nil, the "Goproxy-Priority" request
+	// header ("interactive", "batch" or "background") is used, and requests
+	// without it are interactive.
+	PriorityClassifier func(req *http.Request) RequestPriority
+
+	// RequestTimeout is the maximum amount of time allowed to serve a
+	// module proxy or checksum database proxy request. Once it has passed,
+	// everything done for the request (e.g. fetching from upstream) is
+	// canceled and, unless the response has already started, a 504 Gateway
+	// Timeout is responded, so that hung upstreams cannot hold requests
+	// forever. It does not apply to the administrative endpoints.
+	//
+	// If the RequestTimeout is zero, there is no timeout.
+	RequestTimeout time.Duration
+
+	// ZipRequestTimeout is like the [Goproxy.RequestTimeout], but for the
+	// requests for ".zip" (and ".ziphash") files, which usually take much
+	// longer than the others.
+	//
+	// If the ZipRequestTimeout is zero, the RequestTimeout is used.
+	ZipRequestTimeout time.Duration
+
+	// FetchTimeout is the maximum amount of time allowed for a fetch from
+	// upstream to resolve, list or download the info or mod file of a
+	// module version, no matter whether it is made for a request or in the
+	// background. Once it has passed, the fetch is canceled and treated as
+	// a fetch timeout, so that slow VCS servers cannot hang metadata
+	// requests for minutes.
+	//
+	// If the FetchTimeout is zero, there is no timeout.
+	FetchTimeout time.Duration
+
+	// ZipFetchTimeout is like the [Goproxy.FetchTimeout], but for the
+	// fetches to download module zip files, which usually take much longer
+	// than the others.
+	//
+	// If the ZipFetchTimeout is zero, the FetchTimeout is used.
+	ZipFetchTimeout time.Duration
+
+	// DebugModules is a comma-separated list of glob patterns (in the
+	// syntax of the [path.Match], matching module path prefixes like the
+	// GOPRIVATE) of the modules whose exchanges with upstream module proxies
+	// are recorded as [UpstreamExchange]s and reported to the
+	// [Goproxy.OnUpstreamExchange], for troubleshooting misbehaving
+	// upstreams. Note that the exchanges made by the Go binary when
+	// fetching modules directly are not recorded.
+	//
+	// If the DebugModules is empty, no exchange is recorded.
+	DebugModules string
+
+	// DebugMaxBodyBytes is the maximum number of bytes of the response body
+	// recorded in an [UpstreamExchange].
+	//
+	// If the DebugMaxBodyBytes is zero, 4096 is used.
+	DebugMaxBodyBytes int
+
+	// OnUpstreamExchange is called with each [UpstreamExchange] recorded
+	// for the [Goproxy.DebugModules], once the response body has been
+	// closed. See the [SlogUpstreamExchangeHandler] for logging them via
+	// the [log/slog] (Go 1.21 or later).
+	//
+	// If the OnUpstreamExchange is nil, the exchanges are logged via the
+	// [Goproxy.ErrorLogger].
+	OnUpstreamExchange func(ue *UpstreamExchange)
+
+	// BuildIDHeader is the name of the request header by which clients may
+	// identify the build that a request is made for (e.g. a CI job ID),
+	// which is recorded in the [ArtifactDownload]s.
+	//
+	// If the BuildIDHeader is empty, "X-Build-ID" is used.
+	BuildIDHeader string
+
+	// OnArtifactDownload is called with an [ArtifactDownload] for each
+	// request for a module file (".info", ".mod" or ".zip") once it has
+	// been served, e.g. to keep an audit log that SLSA-style provenance
+	// systems use to link builds to the exact artifacts they downloaded.
+	// See the [JSONArtifactDownloadLogger] for writing them as JSON lines.
+	//
+	// If the OnArtifactDownload is nil, no downloads are recorded.
+	OnArtifactDownload func(ad *ArtifactDownload)
+
+	// Metrics is the [MetricsSink] that the metrics of requests, fetches,
+	// cache lookups and upstream usages are reported to, so that any
+	// metrics system can be used. See the [PrometheusMetrics] and the
+	// [OTLPMetrics] for the provided ones.
+	//
+	// If the Metrics is nil, no metrics are reported.
+	Metrics MetricsSink
+
+	// ErrorReferenceIDs indicates whether to include a randomly generated
+	// reference ID in the error responses whose errors are logged via the
+	// [Goproxy.ErrorLogger]. The same reference ID prefixes the logged
+	// errors, so that the errors reported by clients can be correlated
+	// with server logs.
+	ErrorReferenceIDs bool
+
+	initOnce           sync.Once
+	statsMutex         sync.Mutex
+	stats              Stats
+	goBinName          string
+	goBinEnv           []string
+	goBinEnvGOPROXY    string
+	goBinEnvGONOPROXY  string
+	goBinEnvGOSUMDB    string
+	goBinEnvGONOSUMDB  string
+	goBinEnvGOPRIVATE  string
+	goBinEnvGOINSECURE string
+	goBinVCSRules      []govcsRule
+	goBinVCSErr        error
+	goBinWorkers       *fairScheduler
+	limits             EffectiveLimits
+	openCaches         chan struct{}
+	proxiedSUMDBs      map[string]*url.URL
+	httpClient         *http.Client
+	upstreamRetry      *upstreamRetry
+	metrics            *proxyMetrics
+	sumdbClient        *sumdb.Client
+	cachedNames        *nameSampler
+	prefetches         *prefetchSet
+	revalidations      *prefetchSet
+	emergency          emergencyStale
+	fetches            fetchGroup
+	prefetchQueue      *prefetchQueue
+	goCommands         *goCommandRecorder
+	pins               *pinSet
+	peers              *peerSet
+	hotCache           *hotCache
+	versionWatchers    *versionWatchers
+	warmModules        *warmModuleSet
+	upstreamUsages     *upstreamUsageRecorder
+	moduleDownloads    *moduleDownloadRecorder
+	bulkScheduler      *bulkScheduler
+	instanceID         string
+	userAgent          string
+	goneFlagsMutex     sync.Mutex
+	settingsMutex      sync.Mutex
+	settingsValue      atomic.Value
 }
 
 // init initializes the g.
@@ -143,7 +905,7 @@ func (g *Goproxy) init() {
 		goBinEnv = os.Environ()
 	}
 
-	var goBinEnvGOPRIVATE string
+	var goBinEnvGOPRIVATE, goBinEnvGOVCS string
 	for _, env := range goBinEnv {
 		if envParts := strings.SplitN(env, "=", 2); len(envParts) == 2 {
 			switch strings.TrimSpace(envParts[0]) {
@@ -158,7 +920,18 @@ func (g *Goproxy) init() {
 				g.goBinEnvGONOSUMDB = envParts[1]
 			case "GOPRIVATE":
 				goBinEnvGOPRIVATE = envParts[1]
+			case "GOVCS":
+				goBinEnvGOVCS = envParts[1]
+			case "GOINSECURE":
+				g.goBinEnvGOINSECURE = envParts[1]
+				fallthrough
 			default:
+				if !g.GoBinSandbox.allowsEnv(
+					strings.TrimSpace(envParts[0]),
+				) {
+					continue
+				}
+
 				g.goBinEnv = append(g.goBinEnv, fmt.Sprintf(
 					"%s=%s",
 					envParts[0],
@@ -168,6 +941,8 @@ func (g *Goproxy) init() {
 		}
 	}
 
+	// The GOPRIVATE is kept, since with the GOPROXY and the GOSUMDB fixed
+	// it only decides which GOVCS rules apply, as in fetch.checkGoBinVCS.
 	g.goBinEnv = append(
 		g.goBinEnv,
 		"GO111MODULE=on",
@@ -175,8 +950,36 @@ func (g *Goproxy) init() {
 		"GONOPROXY=",
 		"GOSUMDB=off",
 		"GONOSUMDB=",
-		"GOPRIVATE=",
+		"GOPRIVATE="+goBinEnvGOPRIVATE,
 	)
+	g.goBinEnvGOPRIVATE = goBinEnvGOPRIVATE
+	if g.GoBinVCS != "" {
+		goBinEnvGOVCS = g.GoBinVCS
+	}
+
+	if goBinEnvGOVCS != "" {
+		rules, err := parseGOVCS(goBinEnvGOVCS)
+		if err == nil && g.GoBinAllowedVCS != nil {
+			// Merge both policies into a single GOVCS, which is
+			// resolved against the same GOPRIVATE by the Go binary
+			// as by the fetch.checkGoBinVCS.
+			rules = restrictGOVCSRules(rules, g.GoBinAllowedVCS)
+			goBinEnvGOVCS = formatGOVCS(rules)
+		}
+
+		g.goBinVCSRules, g.goBinVCSErr = rules, err
+	} else if g.GoBinAllowedVCS != nil {
+		goBinEnvGOVCS = allowedVCSToGOVCS(g.GoBinAllowedVCS)
+		g.goBinVCSRules, g.goBinVCSErr = parseGOVCS(goBinEnvGOVCS)
+	}
+
+	if goBinEnvGOVCS != "" {
+		g.goBinEnv = append(g.goBinEnv, "GOVCS="+goBinEnvGOVCS)
+	}
+
+	if g.UpstreamNetrc != "" {
+		g.goBinEnv = append(g.goBinEnv, "NETRC="+g.UpstreamNetrc)
+	}
 
 	var goBinEnvGOPROXY string
 	for goproxy := g.goBinEnvGOPROXY; goproxy != ""; {
@@ -254,8 +1057,22 @@ func (g *Goproxy) init() {
 		g.goBinEnvGONOSUMDB = strings.Join(goBinEnvGONOSUMDBParts, ",")
 	}
 
-	if g.GoBinMaxWorkers != 0 {
-		g.goBinWorkerChan = make(chan struct{}, g.GoBinMaxWorkers)
+	var rl *ResourceLimits
+	if g.ResourceGuardrails {
+		detected := DetectResourceLimits()
+		rl = &detected
+	}
+
+	g.limits = g.deriveEffectiveLimits(rl)
+	if g.limits.GoBinMaxWorkers != 0 || g.GoBinMaxWorkersPerModule != 0 {
+		g.goBinWorkers = newFairScheduler(
+			g.limits.GoBinMaxWorkers,
+			g.GoBinMaxWorkersPerModule,
+		)
+	}
+
+	if g.limits.MaxOpenCaches > 0 {
+		g.openCaches = make(chan struct{}, g.limits.MaxOpenCaches)
 	}
 
 	g.proxiedSUMDBs = map[string]*url.URL{}
@@ -280,22 +1097,111 @@ func (g *Goproxy) init() {
 		g.proxiedSUMDBs[sumdbName] = sumdbURL
 	}
 
+	g.cachedNames = newNameSampler(1024)
+	g.goCommands = newGoCommandRecorder(100)
+	g.prefetches = newPrefetchSet(g.limits.MaxReadAheads)
+	g.revalidations = newPrefetchSet(g.limits.MaxReadAheads)
+	g.prefetchQueue = newPrefetchQueue()
+	g.pins = newPinSet(g.PinnedModules)
+	g.versionWatchers = newVersionWatchers()
+	g.warmModules = newWarmModuleSet()
+
 	g.httpClient = &http.Client{Transport: g.Transport}
+	if len(g.UpstreamCredentials) > 0 || g.UpstreamNetrc != "" {
+		uat := &upstreamAuthTransport{
+			credentials: g.UpstreamCredentials,
+			transport:   g.Transport,
+		}
+		if g.UpstreamNetrc != "" {
+			uat.netrc = &netrcFile{name: g.UpstreamNetrc}
+		}
+
+		g.httpClient.Transport = uat
+	}
+
+	if g.DebugModules != "" {
+		g.httpClient.Transport = &debugTransport{
+			g:         g,
+			transport: g.httpClient.Transport,
+		}
+	}
+
+	if g.UpstreamRateLimit > 0 {
+		g.bulkScheduler = newBulkScheduler(
+			g.UpstreamRateLimit,
+			g.UpstreamRateBurst,
+		)
+		g.httpClient.Transport = &bulkTransport{
+			g:         g,
+			transport: g.httpClient.Transport,
+		}
+	}
+
+	g.instanceID = g.InstanceID
+	if g.instanceID == "" {
+		g.instanceID = defaultInstanceID()
+	}
+
+	g.userAgent = g.UserAgent
+	if g.userAgent == "" {
+		version, _ := buildVersion()
+		g.userAgent = defaultUserAgent(version, g.instanceID)
+	}
+
+	g.httpClient.Transport = &userAgentTransport{
+		userAgent: g.userAgent,
+		transport: g.httpClient.Transport,
+	}
+
+	g.upstreamUsages = newUpstreamUsageRecorder()
+	g.moduleDownloads = newModuleDownloadRecorder()
+	g.httpClient.Transport = &usageTransport{
+		g:         g,
+		transport: g.httpClient.Transport,
+	}
+
+	if g.HotCacheMaxBytes > 0 {
+		g.hotCache = newHotCache(g.HotCacheMaxBytes, g.updateStats)
+	}
+
+	if len(g.Peers) > 0 {
+		g.peers = newPeerSet(g.Peers, g.Transport)
+	}
+
+	g.upstreamRetry = newUpstreamRetry(g)
+	g.metrics = newProxyMetrics(g.Metrics)
+
 	g.sumdbClient = sumdb.NewClient(&sumdbClientOps{
-		envGOPROXY: g.goBinEnvGOPROXY,
-		envGOSUMDB: g.goBinEnvGOSUMDB,
-		httpClient: g.httpClient,
+		envGOPROXY:    g.goBinEnvGOPROXY,
+		envGOSUMDB:    g.goBinEnvGOSUMDB,
+		httpClient:    g.httpClient,
+		upstreamRetry: g.upstreamRetry,
+		trustKey:      g.trustSUMDBKey,
 	})
+
+	g.settingsValue.Store(g.settings())
 }
 
 // ServeHTTP implements the [http.Handler].
 func (g *Goproxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	g.initOnce.Do(g.init)
 
-	switch req.Method {
-	case http.MethodGet, http.MethodHead:
-	default:
-		responseMethodNotAllowed(rw, req, 86400)
+	if g.ErrorReferenceIDs {
+		req = withErrorReferenceIDContext(req)
+	}
+
+	if rp := g.requestPriority(req); rp != RequestPriorityInteractive {
+		req = req.WithContext(withPriority(req.Context(), rp))
+	}
+
+	if len(g.VanityImports) > 0 && req.URL.Query().Get("go-get") == "1" {
+		switch req.Method {
+		case http.MethodGet, http.MethodHead:
+			g.serveVanityImport(rw, req)
+		default:
+			responseMethodNotAllowed(rw, req, 86400)
+		}
+
 		return
 	}
 
@@ -315,23 +1221,78 @@ func (g *Goproxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		name = strings.TrimPrefix(name, "/")
 	}
 
+	if strings.HasPrefix(name, apiPathPrefix) {
+		g.serveAPI(rw, req, name)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+	default:
+		responseMethodNotAllowed(rw, req, 86400)
+		return
+	}
+
+	if g.metrics != nil {
+		start := time.Now()
+		mrw := &metricsResponseWriter{ResponseWriter: rw}
+		rw = mrw
+		defer func() {
+			statusCode := mrw.statusCode
+			if statusCode == 0 {
+				statusCode = http.StatusOK
+			}
+
+			g.metrics.observeRequest(
+				name,
+				statusCode,
+				time.Since(start),
+			)
+		}()
+	}
+
+	req, cancel := withRequestTimeout(req, g.requestTimeout(name))
+	defer cancel()
+
+	if g.settings().PrivateModules != "" {
+		var authorized bool
+		rw, authorized = g.authorizePrivateModule(rw, req, name)
+		if !authorized {
+			return
+		}
+	}
+
+	if g.ClientQuota != nil {
+		var admitted bool
+		if rw, admitted = g.ClientQuota.admit(rw, req); !admitted {
+			return
+		}
+	}
+
 	tempDir, err := ioutil.TempDir(g.TempDir, "goproxy")
 	if err != nil {
-		g.logErrorf("failed to create temporary directory: %v", err)
+		g.logRequestErrorf(
+			req,
+			"failed to create temporary directory: %v",
+			err,
+		)
 		responseInternalServerError(rw, req)
 		return
 	}
 	defer os.RemoveAll(tempDir)
 
-	var expiration = 1 * time.Minute
+	expiration := defaultCacheExpiration
 	if strings.HasPrefix(name, "sumdb/") {
 		g.serveSUMDB(rw, req, name, tempDir, expiration)
 		return
 	}
 
-	g.serveFetch(rw, req, name, tempDir, expiration)
+	if strings.HasSuffix(name, ".ziphash") {
+		g.serveZipHash(rw, req, name, tempDir, expiration)
+		return
+	}
 
-	StartCleanupTask("C:\\goworkspace\\pkg\\mod\\cache\\download", 2*time.Minute)
+	g.serveFetch(rw, req, name, tempDir, expiration)
 }
 
 // serveFetch serves fetch requests.
@@ -344,18 +1305,38 @@ func (g *Goproxy) serveFetch(
 ) {
 	f, err := newFetch(g, name, tempDir)
 	if err != nil {
-		responseNotFound(rw, req, 86400, err)
+		if errors.Is(err, errForbidden) {
+			responseForbidden(rw, req, -1, err)
+		} else if errors.Is(err, errGone) {
+			responseGone(rw, req, 86400, err)
+		} else {
+			responseNotFound(rw, req, 86400, err)
+		}
+
 		return
 	}
 
+	expiration = g.fetchCacheExpiration(expiration, f)
+
 	var isDownload bool
 	switch f.ops {
 	case fetchOpsDownloadInfo, fetchOpsDownloadMod, fetchOpsDownloadZip:
 		isDownload = true
 	}
 
+	hit := true
+	if isDownload && g.OnArtifactDownload != nil {
+		start := time.Now()
+		arw := &artifactResponseWriter{ResponseWriter: rw}
+		rw = arw
+		defer func() {
+			g.recordArtifactDownload(req, f, start, arw, hit)
+		}()
+	}
+
+	onlyIfCached := g.onlyIfCached(req)
 	noFetch, _ := strconv.ParseBool(req.Header.Get("Disable-Module-Fetch"))
-	if noFetch {
+	if noFetch || onlyIfCached {
 		var cacheControlMaxAge int
 		if isDownload {
 			cacheControlMaxAge = 604800
@@ -370,6 +1351,18 @@ func (g *Goproxy) serveFetch(
 			f.contentType,
 			cacheControlMaxAge,
 			func() {
+				hit = false
+				if onlyIfCached {
+					responseString(
+						rw,
+						req,
+						http.StatusGatewayTimeout,
+						-1,
+						"not cached",
+					)
+					return
+				}
+
 				responseNotFound(
 					rw,
 					req,
@@ -382,17 +1375,87 @@ func (g *Goproxy) serveFetch(
 		return
 	}
 
+	if f.ops == fetchOpsDownloadInfo {
+		g.readAhead(f.modulePath, f.moduleVersion, expiration)
+	}
+
+	if !isDownload && g.settings().UpstreamCacheHeaders {
+		uh := g.upstreamHeaders(req.Context(), f.name)
+		if uh != nil && time.Now().Before(uh.FreshUntil) {
+			if content, err := g.cache(
+				req.Context(),
+				f.name,
+			); err == nil {
+				defer content.Close()
+				responseSuccess(
+					rw,
+					req,
+					content,
+					f.contentType,
+					60,
+				)
+				return
+			}
+		}
+	}
+
+	if !isDownload && g.serveStaleWhileRevalidate(
+		rw,
+		req,
+		f,
+		expiration,
+	) {
+		return
+	}
+
 	if isDownload {
+		defer func() { g.recordDownload(f, hit) }()
 		g.serveCache(rw, req, f.name, f.contentType, 604800, func() {
-			g.serveFetchDownload(rw, req, f, expiration)
+			hit = false
+			if !g.serveFetchFromPeers(
+				rw,
+				req,
+				f,
+				tempDir,
+				expiration,
+			) {
+				g.serveFetchDownload(rw, req, f, expiration)
+			}
 		})
 		return
 	}
 
-	fr, err := f.do(req.Context())
+	var (
+		fr     *fetchResult
+		shared bool
+	)
+	if err = g.cachedNotFound(req.Context(), f.name); err == nil {
+		fr, shared, err = g.fetches.do(
+			req.Context(),
+			f.name,
+			func() (*fetchResult, error) {
+				fr, err := f.do(req.Context())
+				g.recordUpstreamResult(err)
+				if err != nil {
+					g.putNotFound(req.Context(), f.name, err)
+				}
+
+				return fr, err
+			},
+		)
+		if shared {
+			g.updateStats(func(s *Stats) { s.SharedFetches++ })
+		}
+	}
+
 	if err != nil {
 		g.serveCache(rw, req, f.name, f.contentType, 60, func() {
-			g.logErrorf(
+			if g.serveStale(rw, req, f.name, f.contentType, err) {
+				return
+			}
+
+			g.logRequestErrorf(
+				req,
 				"failed to %s module version: %s: %v",
 				f.ops,
 				f.name,
@@ -403,29 +1466,133 @@ func (g *Goproxy) serveFetch(
 		return
 	}
 
+	if f.ops == fetchOpsResolve && !shared {
+		g.readAhead(f.modulePath, fr.Version, expiration)
+	}
+
 	content, err := fr.Open()
 	if err != nil {
-		g.logErrorf("failed to open fetch result: %s: %v", f.name, err)
+		g.logRequestErrorf(
+			req,
+			"failed to open fetch result: %s: %v",
+			f.name,
+			err,
+		)
 		responseInternalServerError(rw, req)
 		return
 	}
 	defer content.Close()
 
-	if err := g.putCache(req.Context(), f.name, content, expiration); err != nil {
-		g.logErrorf("failed to cache module file: %s: %v", f.name, err)
-		responseInternalServerError(rw, req)
+	if fr.Expiration > 0 {
+		expiration = fr.Expiration
+	}
+
+	// The caches of a shared fetch are put by the request that made it.
+	if !shared && !g.putFetchResultCache(
+		rw,
+		req,
+		f,
+		fr,
+		content,
+		expiration,
+	) {
+		return
+	}
+
+	if f.ops == fetchOpsList && len(fr.Versions) >= streamedListMinVersions {
+		responseStream(
+			rw,
+			req,
+			content,
+			f.contentType,
+			60,
+			g.limits.StreamBufferBytes,
+		)
 		return
-	} else if _, err := content.Seek(0, io.SeekStart); err != nil {
-		g.logErrorf(
+	}
+
+	responseSuccess(rw, req, content, f.contentType, 60)
+}
+
+// putFetchResultCache puts the content of the fr of the f to the g.Cacher with
+// the expiration, along with its stale copy and upstream headers, and seeks the
+// content back to the start. It responses an error and returns false if it
+// fails.
+func (g *Goproxy) putFetchResultCache(
+	rw http.ResponseWriter,
+	req *http.Request,
+	f *fetch,
+	fr *fetchResult,
+	content io.ReadSeeker,
+	expiration time.Duration,
+) bool {
+	err := g.putCache(req.Context(), f.name, content, expiration)
+	if err != nil {
+		g.logRequestErrorf(
+			req,
+			"failed to cache module file: %s: %v",
+			f.name,
+			err,
+		)
+		responseInternalServerError(rw, req)
+		return false
+	}
+
+	g.putStale(req.Context(), f.name, content)
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		g.logRequestErrorf(
+			req,
 			"failed to seek fetch result content: %s: %v",
 			f.name,
 			err,
 		)
 		responseInternalServerError(rw, req)
-		return
+		return false
 	}
 
-	responseSuccess(rw, req, content, f.contentType, 60)
+	if fr.upstreamHeaders != nil {
+		if err := g.putUpstreamHeaders(
+			req.Context(),
+			f.name,
+			fr.upstreamHeaders,
+			expiration,
+		); err != nil {
+			g.logRequestErrorf(
+				req,
+				"failed to cache upstream headers: %s: %v",
+				f.name,
+				err,
+			)
+		}
+	}
+
+	return true
+}
+
+// onlyIfCached reports whether the req asks to be served only from the cache.
+func (g *Goproxy) onlyIfCached(req *http.Request) bool {
+	if v, _ := strconv.ParseBool(req.Header.Get(g.noFetchHeader())); v {
+		return true
+	}
+
+	cacheControl := req.Header.Get("Cache-Control")
+	for _, directive := range strings.Split(cacheControl, ",") {
+		if strings.EqualFold(strings.TrimSpace(directive), "only-if-cached") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// noFetchHeader returns the name of the request header described by the
+// [Goproxy.NoFetchHeader].
+func (g *Goproxy) noFetchHeader() string {
+	if g.NoFetchHeader == "" {
+		return "GONOFETCH"
+	}
+
+	return g.NoFetchHeader
 }
 
 // serveFetchDownload serves fetch download requests.
@@ -435,15 +1602,128 @@ func (g *Goproxy) serveFetchDownload(
 	f *fetch,
 	expiration time.Duration,
 ) {
+	if err := g.cachedNotFound(req.Context(), f.name); err != nil {
+		responseError(rw, req, err, false)
+		return
+	}
+
+	f.tee = g.downloadTee(rw, req, f)
+	fr, shared, err := g.fetches.do(
+		req.Context(),
+		f.name,
+		func() (*fetchResult, error) {
+			return g.lockedFetchDownload(req, f, expiration)
+		},
+	)
+	if shared {
+		g.updateStats(func(s *Stats) { s.SharedFetches++ })
+	}
+
+	if errors.Is(err, errFetchedByOtherInstance) {
+		shared, err = true, nil
+	}
+
+	if shared && err == nil {
+		// The shared fetch has cached the module file, unless it could
+		// not (e.g. for exceeding the CacherMaxCacheBytes), in which
+		// case it is fetched again.
+		content, err := g.cache(req.Context(), f.name)
+		if err == nil {
+			defer content.Close()
+			responseSuccess(rw, req, content, f.contentType, 604800)
+			return
+		}
+
+		fr, err = g.fetchDownload(req, f, expiration)
+	}
+
+	if err != nil {
+		if f.tee.hasStarted() {
+			// Abort the response so that the client does not take
+			// what it has received so far for the module file.
+			panic(http.ErrAbortHandler)
+		}
+
+		responseError(rw, req, err, false)
+		return
+	}
+
+	if f.tee.hasStarted() {
+		return // Streamed already
+	}
+
+	content, err := fr.Open()
+	if err != nil {
+		g.logRequestErrorf(
+			req,
+			"failed to open fetch result: %s: %v",
+			f.name,
+			err,
+		)
+		responseInternalServerError(rw, req)
+		return
+	}
+	defer content.Close()
+
+	responseSuccess(rw, req, content, f.contentType, 604800)
+}
+
+// fetchDownload executes the f for the req and puts the downloaded module files
+// to the g.Cacher with the expiration. Failures are logged.
+func (g *Goproxy) fetchDownload(
+	req *http.Request,
+	f *fetch,
+	expiration time.Duration,
+) (*fetchResult, error) {
 	fr, err := f.do(req.Context())
+	g.recordUpstreamResult(err)
 	if err != nil {
-		g.logErrorf(
+		g.logRequestErrorf(
+			req,
 			"failed to download module version: %s: %v",
 			f.name,
 			err,
 		)
-		responseError(rw, req, err, false)
-		return
+		if !f.tee.hasStarted() {
+			g.putNotFound(req.Context(), f.name, err)
+		}
+
+		return nil, err
+	}
+
+	if err := g.putFetchResultCaches(
+		req.Context(),
+		f,
+		fr,
+		expiration,
+	); err != nil {
+		g.logRequestErrorf(
+			req,
+			"failed to cache module file: %s: %v",
+			f.name,
+			err,
+		)
+
+		// A streamed module file has been verified and served in
+		// full, so failing to cache it is no reason to abort.
+		if !f.tee.hasStarted() {
+			return nil, err
+		}
+	}
+
+	return fr, nil
+}
+
+// putFetchResultCaches puts the module files downloaded by the fr of the f to
+// the g.Cacher with the expiration.
+func (g *Goproxy) putFetchResultCaches(
+	ctx context.Context,
+	f *fetch,
+	fr *fetchResult,
+	expiration time.Duration,
+) error {
+	if fr.Expiration > 0 {
+		expiration = fr.Expiration
 	}
 
 	nameWithoutExt := strings.TrimSuffix(f.name, path.Ext(f.name))
@@ -457,33 +1737,92 @@ func (g *Goproxy) serveFetchDownload(
 		}
 
 		if err := g.putCacheFile(
-			req.Context(),
+			ctx,
 			fmt.Sprint(nameWithoutExt, cache.nameExt),
 			cache.localFile,
 			expiration,
 		); err != nil {
-			g.logErrorf(
-				"failed to cache module file: %s: %v",
-				f.name,
-				err,
-			)
-			responseInternalServerError(rw, req)
-			return
+			return err
 		}
 	}
 
-	content, err := fr.Open()
-	if err != nil {
-		g.logErrorf("failed to open fetch result: %s: %v", f.name, err)
-		responseInternalServerError(rw, req)
-		return
+	return nil
+}
+
+// fetchCacheExpiration returns the expiration of the cache for the f, based on
+// the expiration.
+func (g *Goproxy) fetchCacheExpiration(
+	expiration time.Duration,
+	f *fetch,
+) time.Duration {
+	s := g.settings()
+	switch f.ops {
+	case fetchOpsList:
+		if s.ListCacheExpiration != 0 {
+			return s.ListCacheExpiration
+		}
+
+		return expiration
+	case fetchOpsResolve:
+		if s.LatestCacheExpiration != 0 {
+			return s.LatestCacheExpiration
+		}
+
+		return expiration
 	}
-	defer content.Close()
 
-	responseSuccess(rw, req, content, f.contentType, 604800)
+	return g.versionCacheExpiration(expiration, f.moduleVersion)
+}
+
+// versionCacheExpiration returns the expiration of the caches of the module
+// files of the moduleVersion, based on the expiration.
+func (g *Goproxy) versionCacheExpiration(
+	expiration time.Duration,
+	moduleVersion string,
+) time.Duration {
+	s := g.settings()
+	if s.PseudoVersionCacheExpiration != 0 &&
+		module.IsPseudoVersion(moduleVersion) {
+		return s.PseudoVersionCacheExpiration
+	}
+
+	if s.ImmutableCacheExpiration < 0 {
+		return foreverCacheExpiration
+	} else if s.ImmutableCacheExpiration != 0 {
+		return s.ImmutableCacheExpiration
+	}
+
+	return expiration
 }
 
-// serveSUMDB serves checksum database proxy requests.
+// routeSUMDB routes the rest of a checksum database proxy request path (the
+// part after "sumdb/") to the proxied checksum database whose name it starts
+// with. It returns the URL of the checksum database and the remaining path,
+// which starts with "/".
+//
+// Checksum database names may contain slashes, so the longest matching name
+// wins.
+func (g *Goproxy) routeSUMDB(rest string) (*url.URL, string, bool) {
+	var (
+		sumdbName string
+		sumdbURL  *url.URL
+	)
+	for name, u := range g.proxiedSUMDBs {
+		if len(name) > len(sumdbName) &&
+			strings.HasPrefix(rest, name+"/") {
+			sumdbName, sumdbURL = name, u
+		}
+	}
+
+	if sumdbURL == nil {
+		return nil, "", false
+	}
+
+	return sumdbURL, rest[len(sumdbName):], true
+}
+
+// serveSUMDB serves checksum database proxy requests for any of the
+// [Goproxy.ProxiedSUMDBs], routed by the checksum database name in the name.
 func (g *Goproxy) serveSUMDB(
 	rw http.ResponseWriter,
 	req *http.Request,
@@ -491,13 +1830,9 @@ func (g *Goproxy) serveSUMDB(
 	tempDir string,
 	expiration time.Duration,
 ) {
-	sumdbURL, err := parseRawURL(strings.TrimPrefix(name, "sumdb/"))
-	if err != nil {
-		responseNotFound(rw, req, 86400)
-		return
-	}
-
-	proxiedSUMDBURL, ok := g.proxiedSUMDBs[sumdbURL.Host]
+	proxiedSUMDBURL, sumdbPath, ok := g.routeSUMDB(
+		strings.TrimPrefix(name, "sumdb/"),
+	)
 	if !ok {
 		responseNotFound(rw, req, 86400)
 		return
@@ -508,17 +1843,17 @@ func (g *Goproxy) serveSUMDB(
 		cacheControlMaxAge int
 	)
 
-	if sumdbURL.Path == "/supported" {
+	if sumdbPath == "/supported" {
 		setResponseCacheControlHeader(rw, 86400)
 		rw.WriteHeader(http.StatusOK)
 		return
-	} else if sumdbURL.Path == "/latest" {
+	} else if sumdbPath == "/latest" {
 		contentType = "text/plain; charset=utf-8"
 		cacheControlMaxAge = 3600
-	} else if strings.HasPrefix(sumdbURL.Path, "/lookup/") {
+	} else if strings.HasPrefix(sumdbPath, "/lookup/") {
 		contentType = "text/plain; charset=utf-8"
 		cacheControlMaxAge = 86400
-	} else if strings.HasPrefix(sumdbURL.Path, "/tile/") {
+	} else if strings.HasPrefix(sumdbPath, "/tile/") {
 		contentType = "application/octet-stream"
 		cacheControlMaxAge = 86400
 	} else {
@@ -528,15 +1863,19 @@ func (g *Goproxy) serveSUMDB(
 
 	tempFile, err := ioutil.TempFile(tempDir, "")
 	if err != nil {
-		g.logErrorf("failed to create temporary file: %v", err)
+		g.logRequestErrorf(
+			req,
+			"failed to create temporary file: %v",
+			err,
+		)
 		responseInternalServerError(rw, req)
 		return
 	}
 
 	if err := httpGet(
-		req.Context(),
+		withUpstreamRetry(req.Context(), g.upstreamRetry),
 		g.httpClient,
-		appendURL(proxiedSUMDBURL, sumdbURL.Path).String(),
+		appendURL(proxiedSUMDBURL, sumdbPath).String(),
 		tempFile,
 	); err != nil {
 		g.serveCache(
@@ -546,7 +1885,8 @@ func (g *Goproxy) serveSUMDB(
 			contentType,
 			cacheControlMaxAge,
 			func() {
-				g.logErrorf(
+				g.logRequestErrorf(
+					req,
 					"failed to proxy checksum database: "+
 						"%s: %v",
 					name,
@@ -559,7 +1899,11 @@ func (g *Goproxy) serveSUMDB(
 	}
 
 	if err := tempFile.Close(); err != nil {
-		g.logErrorf("failed to close temporary file: %v", err)
+		g.logRequestErrorf(
+			req,
+			"failed to close temporary file: %v",
+			err,
+		)
 		responseInternalServerError(rw, req)
 		return
 	}
@@ -570,14 +1914,24 @@ func (g *Goproxy) serveSUMDB(
 		tempFile.Name(),
 		expiration,
 	); err != nil {
-		g.logErrorf("failed to cache module file: %s: %v", name, err)
+		g.logRequestErrorf(
+			req,
+			"failed to cache module file: %s: %v",
+			name,
+			err,
+		)
 		responseInternalServerError(rw, req)
 		return
 	}
 
 	content, err := os.Open(tempFile.Name())
 	if err != nil {
-		g.logErrorf("failed to open temporary file: %s: %v", name, err)
+		g.logRequestErrorf(
+			req,
+			"failed to open temporary file: %s: %v",
+			name,
+			err,
+		)
 		responseInternalServerError(rw, req)
 		return
 	}
@@ -595,6 +1949,13 @@ func (g *Goproxy) serveCache(
 	cacheControlMaxAge int,
 	onNotFound func(),
 ) {
+	release, err := g.acquireOpenCache(req.Context())
+	if err != nil {
+		responseError(rw, req, err, false)
+		return
+	}
+	defer release()
+
 	content, err := g.cache(req.Context(), name)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
@@ -602,7 +1963,8 @@ func (g *Goproxy) serveCache(
 			return
 		}
 
-		g.logErrorf(
+		g.logRequestErrorf(
+			req,
 			"failed to get cached module file: %s: %v",
 			name,
 			err,
@@ -611,6 +1973,10 @@ func (g *Goproxy) serveCache(
 
 		return
 	}
+
+	if g.hotCache != nil {
+		content = g.hotCache.open(name, content)
+	}
 	defer content.Close()
 
 	responseSuccess(rw, req, content, contentType, cacheControlMaxAge)
@@ -625,6 +1991,8 @@ func (g *Goproxy) cache(
 		return nil, os.ErrNotExist
 	}
 
+	ctx = withClockSkew(ctx, g.settings().CacherClockSkew)
+
 	return g.Cacher.Get(ctx, name)
 }
 
@@ -639,17 +2007,87 @@ func (g *Goproxy) putCache(
 		return nil
 	}
 
-	if g.CacherMaxCacheBytes != 0 {
-		if size, err := content.Seek(0, io.SeekEnd); err != nil {
+	if g.pinned(name) {
+		expiration = pinnedCacheExpiration
+	}
+
+	s := g.settings()
+	var size int64
+	if s.CacherMaxCacheBytes != 0 || s.CacherVerifySizes {
+		var err error
+		if size, err = content.Seek(0, io.SeekEnd); err != nil {
 			return err
-		} else if size > int64(g.CacherMaxCacheBytes) {
+		} else if s.CacherMaxCacheBytes != 0 &&
+			size > int64(s.CacherMaxCacheBytes) {
 			return nil
 		} else if _, err := content.Seek(0, io.SeekStart); err != nil {
 			return err
 		}
 	}
 
-	return g.Cacher.Put(ctx, name, content, expiration)
+	if err := g.Cacher.Put(ctx, name, content, expiration); err != nil {
+		return err
+	}
+
+	if s.CacherVerifySizes {
+		if err := g.verifyCacheSize(ctx, name, size); err != nil {
+			return err
+		}
+	}
+
+	if isModuleFileName(name) {
+		g.cachedNames.add(name)
+	}
+
+	g.notifyCachedVersion(name)
+
+	return nil
+}
+
+// verifyCacheSize verifies that the size of the cache for the name matches the
+// size. The cache is deleted on mismatch if the g.Cacher implements the
+// [Deleter].
+func (g *Goproxy) verifyCacheSize(
+	ctx context.Context,
+	name string,
+	size int64,
+) error {
+	content, err := g.Cacher.Get(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	var cachedSize int64
+	if s, ok := content.(interface{ Size() int64 }); ok {
+		cachedSize = s.Size()
+	} else {
+		cachedSize, err = io.Copy(ioutil.Discard, content)
+	}
+
+	content.Close()
+	if err != nil {
+		return err
+	}
+
+	if cachedSize == size {
+		return nil
+	}
+
+	if deleter, ok := g.Cacher.(Deleter); ok {
+		if err := deleter.Delete(
+			ctx,
+			name,
+		); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return fmt.Errorf(
+		"cache size mismatch: %s: got %d bytes, want %d bytes",
+		name,
+		cachedSize,
+		size,
+	)
 }
 
 // putCacheFile puts a cache to the g.Cacher for the name with the targeted
@@ -661,20 +2099,77 @@ func (g *Goproxy) putCacheFile(ctx context.Context, name, file string, expiratio
 	}
 	defer f.Close()
 
-	return g.putCache(ctx, name, f, expiration)
+	var content io.ReadSeeker = f
+	if g.inTempDir(file) {
+		content = linkableFile{f}
+	}
+
+	return g.putCache(ctx, name, content, expiration)
+}
+
+// inTempDir reports whether the file is inside the g.TempDir.
+func (g *Goproxy) inTempDir(file string) bool {
+	tempDir := g.TempDir
+	if tempDir == "" {
+		tempDir = os.TempDir()
+	}
+
+	tempDir, err := filepath.Abs(tempDir)
+	if err != nil {
+		return false
+	}
+
+	file, err = filepath.Abs(file)
+	if err != nil {
+		return false
+	}
+
+	rel, err := filepath.Rel(tempDir, file)
+	return err == nil && rel != "." && rel != ".." &&
+		!strings.HasPrefix(rel, ".."+string(filepath.Separator))
 }
 
 // logErrorf formats according to the format and logs the v as an error.
 func (g *Goproxy) logErrorf(format string, v ...interface{}) {
-	msg := fmt.Sprint("goproxy: ", fmt.Sprintf(format, v...))
+	g.logError(fmt.Sprintf(format, v...))
+}
+
+// logRequestErrorf is like the [Goproxy.logErrorf], but prefixes the error with
+// the error reference ID attached to the context of the req, if any.
+func (g *Goproxy) logRequestErrorf(
+	req *http.Request,
+	format string,
+	v ...interface{},
+) {
+	msg := fmt.Sprintf(format, v...)
+	if id := errorReferenceID(req); id != "" {
+		msg = fmt.Sprintf("[%s] %s", id, msg)
+	}
+
+	g.logError(msg)
+}
+
+// logError logs the msg as an error. It must be called directly by the
+// [Goproxy.logErrorf] or the [Goproxy.logRequestErrorf] so that the caller of
+// them is reported.
+func (g *Goproxy) logError(msg string) {
+	msg = fmt.Sprint("goproxy: ", msg)
 	if g.ErrorLogger != nil {
-		g.ErrorLogger.Output(2, msg)
+		g.ErrorLogger.Output(3, msg)
 	} else {
-		log.Output(2, msg)
+		log.Output(3, msg)
 	}
 }
 
-// walkGOPROXY walks the proxy list parsed from the goproxy.
+// walkGOPROXY walks the proxy list parsed from the goproxy like the Go command
+// does. Each proxy is tried in order until one succeeds. The next one is only
+// tried if the current one fails with a not found error, or with any error if
+// it is followed by a "|". The "direct" and "off" end the walk.
+//
+// Like the go/src/cmd/go/internal/modfetch.TryProxies, the most helpful error
+// is returned if all tried upstreams fail: that of the "direct", then the last
+// one other than a not found error, then the last not found error (which
+// includes that of the "off").
 func walkGOPROXY(
 	goproxy string,
 	onProxy func(proxy string) error,
@@ -685,7 +2180,16 @@ func walkGOPROXY(
 		return errors.New("missing GOPROXY")
 	}
 
-	var proxyError error
+	const (
+		notFoundErrorRank = iota
+		proxyErrorRank
+	)
+
+	var (
+		walked   bool
+		bestErr  error
+		bestRank int
+	)
 	for goproxy != "" {
 		var (
 			proxy           string
@@ -701,26 +2205,50 @@ func walkGOPROXY(
 			goproxy = ""
 		}
 
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+
+		walked = true
+
+		var err error
 		switch proxy {
 		case "direct":
 			return onDirect()
 		case "off":
-			return onOff()
+			err = onOff()
+			goproxy = ""
+		default:
+			err = onProxy(proxy)
 		}
 
-		if err := onProxy(proxy); err != nil {
-			if fallBackOnError || errors.Is(err, errNotFound) {
-				proxyError = err
-				continue
-			}
-
+		if err == nil {
+			return nil
+		} else if errors.Is(err, errDownloadTeeStarted) {
 			return err
 		}
 
-		return nil
+		isNotFound := errors.Is(err, errNotFound)
+		if !isNotFound {
+			bestErr, bestRank = err, proxyErrorRank
+		} else if bestRank == notFoundErrorRank {
+			bestErr = err
+		}
+
+		if !fallBackOnError && !isNotFound {
+			break
+		}
 	}
 
-	return proxyError
+	if !walked {
+		return errors.New(
+			"GOPROXY list is not the empty string, " +
+				"but contains no entries",
+		)
+	}
+
+	return bestErr
 }
 
 var (
@@ -769,6 +2297,12 @@ func stringSliceContains(ss []string, s string) bool {
 // glob patterns (as defined by the [path.Match]) in the comma-separated globs
 // list. It ignores any empty or malformed patterns in the list.
 func globsMatchPath(globs, target string) bool {
+	return globsMatchedGlob(globs, target) != ""
+}
+
+// globsMatchedGlob is like the [globsMatchPath], but returns the first glob
+// pattern in the globs that matches the target, or "" if none matches.
+func globsMatchedGlob(globs, target string) string {
 	for globs != "" {
 		// Extract next non-empty glob in comma-separated list.
 		var glob string
@@ -807,11 +2341,11 @@ func globsMatchPath(globs, target string) bool {
 		}
 
 		if matched, _ := path.Match(glob, prefix); matched {
-			return true
+			return glob
 		}
 	}
 
-	return false
+	return ""
 }
 
 // readSeekCloser is the interface that groups the basic Read, Seek and Close
@@ -13,6 +13,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -168,7 +169,7 @@ func TestGoproxyInit(t *testing.T) {
 	g = &Goproxy{}
 	g.GoBinMaxWorkers = 1
 	g.init()
-	if g.goBinWorkerChan == nil {
+	if g.goBinWorkers == nil {
 		t.Fatal("unexpected nil")
 	}
 
@@ -420,7 +421,7 @@ func TestGoproxyServeFetch(t *testing.T) {
 
 	req := httptest.NewRequest("", "/", nil)
 	rec := httptest.NewRecorder()
-	g.serveFetch(rec, req, "example.com/@latest", tempDir)
+	g.serveFetch(rec, req, "example.com/@latest", tempDir, time.Minute)
 	recr := rec.Result()
 	if got, want := rec.Code, http.StatusOK; got != want {
 		t.Errorf("got %d, want %d", got, want)
@@ -437,7 +438,7 @@ func TestGoproxyServeFetch(t *testing.T) {
 
 	req = httptest.NewRequest("", "/", nil)
 	rec = httptest.NewRecorder()
-	g.serveFetch(rec, req, "example.com/v2/@latest", tempDir)
+	g.serveFetch(rec, req, "example.com/v2/@latest", tempDir, time.Minute)
 	recr = rec.Result()
 	if got, want := rec.Code, http.StatusNotFound; got != want {
 		t.Errorf("got %d, want %d", got, want)
@@ -453,7 +454,7 @@ func TestGoproxyServeFetch(t *testing.T) {
 
 	req = httptest.NewRequest("", "/", nil)
 	rec = httptest.NewRecorder()
-	g.serveFetch(rec, req, "example.com/@v/v1.0.0.info", tempDir)
+	g.serveFetch(rec, req, "example.com/@v/v1.0.0.info", tempDir, time.Minute)
 	recr = rec.Result()
 	if got, want := rec.Code, http.StatusOK; got != want {
 		t.Errorf("got %d, want %d", got, want)
@@ -470,7 +471,7 @@ func TestGoproxyServeFetch(t *testing.T) {
 
 	req = httptest.NewRequest("", "/", nil)
 	rec = httptest.NewRecorder()
-	g.serveFetch(rec, req, "example.com/@v/v1.1.0.info", tempDir)
+	g.serveFetch(rec, req, "example.com/@v/v1.1.0.info", tempDir, time.Minute)
 	recr = rec.Result()
 	if got, want := rec.Code, http.StatusNotFound; got != want {
 		t.Errorf("got %d, want %d", got, want)
@@ -487,7 +488,7 @@ func TestGoproxyServeFetch(t *testing.T) {
 	req = httptest.NewRequest("", "/", nil)
 	req.Header.Set("Disable-Module-Fetch", "true")
 	rec = httptest.NewRecorder()
-	g.serveFetch(rec, req, "example.com/@latest", tempDir)
+	g.serveFetch(rec, req, "example.com/@latest", tempDir, time.Minute)
 	recr = rec.Result()
 	if got, want := rec.Code, http.StatusOK; got != want {
 		t.Errorf("got %d, want %d", got, want)
@@ -505,7 +506,7 @@ func TestGoproxyServeFetch(t *testing.T) {
 	req = httptest.NewRequest("", "/", nil)
 	req.Header.Set("Disable-Module-Fetch", "true")
 	rec = httptest.NewRecorder()
-	g.serveFetch(rec, req, "example.com/v2/@latest", tempDir)
+	g.serveFetch(rec, req, "example.com/v2/@latest", tempDir, time.Minute)
 	recr = rec.Result()
 	if got, want := rec.Code, http.StatusNotFound; got != want {
 		t.Errorf("got %d, want %d", got, want)
@@ -520,10 +521,35 @@ func TestGoproxyServeFetch(t *testing.T) {
 		t.Errorf("got %q, want %q", got, want)
 	}
 
+	req = httptest.NewRequest("", "/", nil)
+	req.Header.Set("GONOFETCH", "1")
+	rec = httptest.NewRecorder()
+	g.serveFetch(rec, req, "example.com/v2/@latest", tempDir, time.Minute)
+	recr = rec.Result()
+	if got, want := rec.Code, http.StatusGatewayTimeout; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	} else if got, want := recr.Header.Get("Cache-Control"),
+		"must-revalidate, no-cache, no-store"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	} else if got, want := rec.Body.String(), "not cached"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	req = httptest.NewRequest("", "/", nil)
+	req.Header.Set("Cache-Control", "only-if-cached")
+	rec = httptest.NewRecorder()
+	g.serveFetch(rec, req, "example.com/@latest", tempDir, time.Minute)
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	} else if got, want := rec.Body.String(),
+		marshalInfo("v1.0.0", infoTime); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
 	req = httptest.NewRequest("", "/", nil)
 	req.Header.Set("Disable-Module-Fetch", "true")
 	rec = httptest.NewRecorder()
-	g.serveFetch(rec, req, "example.com/@v/v1.0.0.info", tempDir)
+	g.serveFetch(rec, req, "example.com/@v/v1.0.0.info", tempDir, time.Minute)
 	recr = rec.Result()
 	if got, want := rec.Code, http.StatusOK; got != want {
 		t.Errorf("got %d, want %d", got, want)
@@ -540,7 +566,7 @@ func TestGoproxyServeFetch(t *testing.T) {
 
 	req = httptest.NewRequest("", "/", nil)
 	rec = httptest.NewRecorder()
-	g.serveFetch(rec, req, "invalid", tempDir)
+	g.serveFetch(rec, req, "invalid", tempDir, time.Minute)
 	recr = rec.Result()
 	if got, want := rec.Code, http.StatusNotFound; got != want {
 		t.Errorf("got %d, want %d", got, want)
@@ -564,7 +590,7 @@ func TestGoproxyServeFetch(t *testing.T) {
 
 	req = httptest.NewRequest("", "/", nil)
 	rec = httptest.NewRecorder()
-	g.serveFetch(rec, req, "example.com/@v/list", tempDir)
+	g.serveFetch(rec, req, "example.com/@v/list", tempDir, time.Minute)
 	recr = rec.Result()
 	if got, want := rec.Code, http.StatusInternalServerError; got != want {
 		t.Errorf("got %d, want %d", got, want)
@@ -580,6 +606,40 @@ func TestGoproxyServeFetch(t *testing.T) {
 	}
 }
 
+func TestGoproxyOnlyIfCached(t *testing.T) {
+	for _, tt := range []struct {
+		noFetchHeader string
+		header        http.Header
+		want          bool
+	}{
+		{"", nil, false},
+		{"", http.Header{"Gonofetch": {"true"}}, true},
+		{"", http.Header{"Gonofetch": {"false"}}, false},
+		{"", http.Header{"Gonofetch": {"foobar"}}, false},
+		{
+			"",
+			http.Header{"Cache-Control": {"max-age=0, Only-If-Cached"}},
+			true,
+		},
+		{"", http.Header{"Cache-Control": {"no-cache"}}, false},
+		{"X-No-Fetch", http.Header{"X-No-Fetch": {"1"}}, true},
+		{"X-No-Fetch", http.Header{"Gonofetch": {"1"}}, false},
+	} {
+		g := &Goproxy{NoFetchHeader: tt.noFetchHeader}
+		req := httptest.NewRequest("", "/", nil)
+		req.Header = tt.header
+		if got := g.onlyIfCached(req); got != tt.want {
+			t.Errorf(
+				"%q %v: got %t, want %t",
+				tt.noFetchHeader,
+				tt.header,
+				got,
+				tt.want,
+			)
+		}
+	}
+}
+
 func TestGoproxyServeFetchDownload(t *testing.T) {
 	tempDir, err := ioutil.TempDir(
 		"",
@@ -637,7 +697,7 @@ func TestGoproxyServeFetchDownload(t *testing.T) {
 	if err != nil {
 		t.Fatalf("unexpected error %q", err)
 	}
-	g.serveFetchDownload(rec, req, f)
+	g.serveFetchDownload(rec, req, f, time.Minute)
 	recr := rec.Result()
 	if got, want := rec.Code, http.StatusOK; got != want {
 		t.Errorf("got %d, want %d", got, want)
@@ -658,7 +718,7 @@ func TestGoproxyServeFetchDownload(t *testing.T) {
 	if err != nil {
 		t.Fatalf("unexpected error %q", err)
 	}
-	g.serveFetchDownload(rec, req, f)
+	g.serveFetchDownload(rec, req, f, time.Minute)
 	recr = rec.Result()
 	if got, want := rec.Code, http.StatusNotFound; got != want {
 		t.Errorf("got %d, want %d", got, want)
@@ -685,7 +745,7 @@ func TestGoproxyServeFetchDownload(t *testing.T) {
 	if err != nil {
 		t.Fatalf("unexpected error %q", err)
 	}
-	g.serveFetchDownload(rec, req, f)
+	g.serveFetchDownload(rec, req, f, time.Minute)
 	recr = rec.Result()
 	if got, want := rec.Code, http.StatusInternalServerError; got != want {
 		t.Errorf("got %d, want %d", got, want)
@@ -701,6 +761,53 @@ func TestGoproxyServeFetchDownload(t *testing.T) {
 	}
 }
 
+func TestGoproxyFetchCacheExpiration(t *testing.T) {
+	g := &Goproxy{
+		ListCacheExpiration:          time.Second,
+		LatestCacheExpiration:        2 * time.Second,
+		ImmutableCacheExpiration:     -1,
+		PseudoVersionCacheExpiration: 3 * time.Second,
+	}
+	g.init()
+
+	for _, tt := range []struct {
+		name string
+		want time.Duration
+	}{
+		{"example.com/@v/list", time.Second},
+		{"example.com/@latest", 2 * time.Second},
+		{"example.com/@v/master.info", 2 * time.Second},
+		{"example.com/@v/v1.0.0.info", foreverCacheExpiration},
+		{"example.com/@v/v1.0.0.zip", foreverCacheExpiration},
+		{
+			"example.com/@v/v0.0.0-20200101000000-0123456789ab.mod",
+			3 * time.Second,
+		},
+	} {
+		f, err := newFetch(g, tt.name, "")
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		got := g.fetchCacheExpiration(time.Minute, f)
+		if got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	g = &Goproxy{}
+	g.init()
+	f, err := newFetch(g, "example.com/@v/v1.0.0.mod", "")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if got, want := g.fetchCacheExpiration(time.Minute, f),
+		time.Minute; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
 func TestGoproxyServeSUMDB(t *testing.T) {
 	tempDir, err := ioutil.TempDir("", "goproxy.TestGoproxyServeSUMDB")
 	if err != nil {
@@ -728,7 +835,7 @@ func TestGoproxyServeSUMDB(t *testing.T) {
 
 	req := httptest.NewRequest("", "/", nil)
 	rec := httptest.NewRecorder()
-	g.serveSUMDB(rec, req, "sumdb/sumdb.example.com/supported", tempDir)
+	g.serveSUMDB(rec, req, "sumdb/sumdb.example.com/supported", tempDir, time.Minute)
 	recr := rec.Result()
 	if got, want := rec.Code, http.StatusOK; got != want {
 		t.Errorf("got %d, want %d", got, want)
@@ -744,7 +851,7 @@ func TestGoproxyServeSUMDB(t *testing.T) {
 
 	req = httptest.NewRequest("", "/", nil)
 	rec = httptest.NewRecorder()
-	g.serveSUMDB(rec, req, "sumdb/sumdb.example.com/latest", tempDir)
+	g.serveSUMDB(rec, req, "sumdb/sumdb.example.com/latest", tempDir, time.Minute)
 	recr = rec.Result()
 	if got, want := rec.Code, http.StatusOK; got != want {
 		t.Errorf("got %d, want %d", got, want)
@@ -765,6 +872,7 @@ func TestGoproxyServeSUMDB(t *testing.T) {
 		req,
 		"sumdb/sumdb.example.com/lookup/example.com@v1.0.0",
 		tempDir,
+		time.Minute,
 	)
 	recr = rec.Result()
 	if got, want := rec.Code, http.StatusOK; got != want {
@@ -782,7 +890,7 @@ func TestGoproxyServeSUMDB(t *testing.T) {
 
 	req = httptest.NewRequest("", "/", nil)
 	rec = httptest.NewRecorder()
-	g.serveSUMDB(rec, req, "sumdb/sumdb.example.com/tile/2/0/0", tempDir)
+	g.serveSUMDB(rec, req, "sumdb/sumdb.example.com/tile/2/0/0", tempDir, time.Minute)
 	recr = rec.Result()
 	if got, want := rec.Code, http.StatusOK; got != want {
 		t.Errorf("got %d, want %d", got, want)
@@ -798,7 +906,7 @@ func TestGoproxyServeSUMDB(t *testing.T) {
 
 	req = httptest.NewRequest("", "/", nil)
 	rec = httptest.NewRecorder()
-	g.serveSUMDB(rec, req, "sumdb/sumdb.example.com/404", tempDir)
+	g.serveSUMDB(rec, req, "sumdb/sumdb.example.com/404", tempDir, time.Minute)
 	recr = rec.Result()
 	if got, want := rec.Code, http.StatusNotFound; got != want {
 		t.Errorf("got %d, want %d", got, want)
@@ -814,7 +922,7 @@ func TestGoproxyServeSUMDB(t *testing.T) {
 
 	req = httptest.NewRequest("", "/", nil)
 	rec = httptest.NewRecorder()
-	g.serveSUMDB(rec, req, "://invalid", tempDir)
+	g.serveSUMDB(rec, req, "://invalid", tempDir, time.Minute)
 	recr = rec.Result()
 	if got, want := rec.Code, http.StatusNotFound; got != want {
 		t.Errorf("got %d, want %d", got, want)
@@ -830,7 +938,7 @@ func TestGoproxyServeSUMDB(t *testing.T) {
 
 	req = httptest.NewRequest("", "/", nil)
 	rec = httptest.NewRecorder()
-	g.serveSUMDB(rec, req, "sumdb/sumdb2.example.com/supported", tempDir)
+	g.serveSUMDB(rec, req, "sumdb/sumdb2.example.com/supported", tempDir, time.Minute)
 	recr = rec.Result()
 	if got, want := rec.Code, http.StatusNotFound; got != want {
 		t.Errorf("got %d, want %d", got, want)
@@ -851,6 +959,7 @@ func TestGoproxyServeSUMDB(t *testing.T) {
 		req,
 		"sumdb/sumdb.example.com/latest",
 		filepath.Join(tempDir, "404"),
+		time.Minute,
 	)
 	recr = rec.Result()
 	if got, want := rec.Code, http.StatusInternalServerError; got != want {
@@ -877,6 +986,7 @@ func TestGoproxyServeSUMDB(t *testing.T) {
 		req,
 		"sumdb/sumdb.example.com/lookup/example.com/v2@v2.0.0",
 		tempDir,
+		time.Minute,
 	)
 	recr = rec.Result()
 	if got, want := rec.Code, http.StatusNotFound; got != want {
@@ -904,7 +1014,7 @@ func TestGoproxyServeSUMDB(t *testing.T) {
 
 	req = httptest.NewRequest("", "/", nil)
 	rec = httptest.NewRecorder()
-	g.serveSUMDB(rec, req, "sumdb/sumdb.example.com/latest", tempDir)
+	g.serveSUMDB(rec, req, "sumdb/sumdb.example.com/latest", tempDir, time.Minute)
 	recr = rec.Result()
 	if got, want := rec.Code, http.StatusInternalServerError; got != want {
 		t.Errorf("got %d, want %d", got, want)
@@ -920,13 +1030,120 @@ func TestGoproxyServeSUMDB(t *testing.T) {
 	}
 }
 
+func TestGoproxyServeMultipleSUMDBs(t *testing.T) {
+	tempDir, err := ioutil.TempDir(
+		"",
+		"goproxy.TestGoproxyServeMultipleSUMDBs",
+	)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	newServer := func(id string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(
+			rw http.ResponseWriter,
+			req *http.Request,
+		) {
+			fmt.Fprint(rw, id, req.URL.Path)
+		}))
+	}
+
+	public := newServer("public")
+	defer public.Close()
+
+	private := newServer("private")
+	defer private.Close()
+
+	g := &Goproxy{
+		Cacher: DirCacher(tempDir),
+		ProxiedSUMDBs: []string{
+			"sum.golang.org " + public.URL,
+			"sumdb.example.com/private " + private.URL,
+		},
+		ErrorLogger: log.New(&discardWriter{}, "", 0),
+	}
+	g.init()
+
+	var wg sync.WaitGroup
+	for _, tt := range []struct {
+		name string
+		want string
+	}{
+		{"sumdb/sum.golang.org/latest", "public/latest"},
+		{"sumdb/sum.golang.org/tile/8/0/0", "public/tile/8/0/0"},
+		{"sumdb/sumdb.example.com/private/latest", "private/latest"},
+		{
+			"sumdb/sumdb.example.com/private/lookup/" +
+				"example.com@v1.0.0",
+			"private/lookup/example.com@v1.0.0",
+		},
+	} {
+		tt := tt
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			req := httptest.NewRequest("", "/", nil)
+			rec := httptest.NewRecorder()
+			g.serveSUMDB(rec, req, tt.name, tempDir, time.Minute)
+			if got, want := rec.Code, http.StatusOK; got != want {
+				t.Errorf("%s: got %d, want %d",
+					tt.name, got, want)
+			} else if got, want := rec.Body.String(),
+				tt.want; got != want {
+				t.Errorf("%s: got %q, want %q",
+					tt.name, got, want)
+			}
+
+			content, err := g.cache(context.Background(), tt.name)
+			if err != nil {
+				t.Errorf("%s: unexpected error %q", tt.name, err)
+				return
+			}
+
+			b, err := ioutil.ReadAll(content)
+			content.Close()
+			if err != nil {
+				t.Errorf("%s: unexpected error %q", tt.name, err)
+			} else if got, want := string(b), tt.want; got != want {
+				t.Errorf("%s: got %q, want %q",
+					tt.name, got, want)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, name := range []string{
+		"sumdb/sumdb.example.com/supported",
+		"sumdb/sumdb.example.com/latest",
+		"sumdb/sumdb.example.com/privatelatest",
+	} {
+		req := httptest.NewRequest("", "/", nil)
+		rec := httptest.NewRecorder()
+		g.serveSUMDB(rec, req, name, tempDir, time.Minute)
+		if got, want := rec.Code, http.StatusNotFound; got != want {
+			t.Errorf("%s: got %d, want %d", name, got, want)
+		}
+	}
+}
+
 type errorCacher struct{}
 
 func (errorCacher) Get(context.Context, string) (io.ReadCloser, error) {
 	return nil, errors.New("error cacher")
 }
 
-func (errorCacher) Put(context.Context, string, io.ReadSeeker) error {
+func (errorCacher) Put(
+	context.Context,
+	string,
+	io.ReadSeeker,
+	time.Duration,
+) error {
+	return errors.New("error cacher")
+}
+
+func (errorCacher) Cleanup() error {
 	return errors.New("error cacher")
 }
 
@@ -943,6 +1160,7 @@ func TestGoproxyServeCache(t *testing.T) {
 		context.Background(),
 		"foo",
 		strings.NewReader("bar"),
+		time.Minute,
 	); err != nil {
 		t.Fatalf("unexpected error %q", err)
 	}
@@ -996,6 +1214,11 @@ func TestGoproxyCache(t *testing.T) {
 		0600,
 	); err != nil {
 		t.Fatalf("unexpected error %q", err)
+	} else if err := setCacheExpiration(
+		filepath.Join(tempDir, "foo"),
+		time.Minute,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
 	}
 
 	g := &Goproxy{Cacher: DirCacher(tempDir)}
@@ -1063,6 +1286,7 @@ func TestGoproxyPutCache(t *testing.T) {
 		context.Background(),
 		"foo",
 		strings.NewReader("bar"),
+		time.Minute,
 	); err != nil {
 		t.Fatalf("unexpected error %q", err)
 	} else if b, err := ioutil.ReadFile(
@@ -1079,6 +1303,7 @@ func TestGoproxyPutCache(t *testing.T) {
 			ReadSeeker:      strings.NewReader("bar"),
 			cannotSeekStart: true,
 		},
+		time.Minute,
 	); err == nil {
 		t.Fatal("expected error")
 	} else if got, want := err.Error(), "cannot seek start"; got != want {
@@ -1091,6 +1316,7 @@ func TestGoproxyPutCache(t *testing.T) {
 			ReadSeeker:    strings.NewReader("bar"),
 			cannotSeekEnd: true,
 		},
+		time.Minute,
 	); err == nil {
 		t.Fatal("expected error")
 	} else if got, want := err.Error(), "cannot seek end"; got != want {
@@ -1100,6 +1326,7 @@ func TestGoproxyPutCache(t *testing.T) {
 		context.Background(),
 		"foobar",
 		strings.NewReader("foobar"),
+		time.Minute,
 	); err != nil {
 		t.Fatalf("unexpected error %q", err)
 	} else if _, err := ioutil.ReadFile(
@@ -1117,9 +1344,73 @@ func TestGoproxyPutCache(t *testing.T) {
 		context.Background(),
 		"foo",
 		strings.NewReader("bar"),
+		time.Minute,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+}
+
+type truncatingCacher struct {
+	DirCacher
+}
+
+func (tc truncatingCacher) Put(
+	ctx context.Context,
+	name string,
+	content io.ReadSeeker,
+	expiration time.Duration,
+) error {
+	return tc.DirCacher.Put(
+		ctx,
+		name,
+		io.NewSectionReader(content.(io.ReaderAt), 0, 1),
+		expiration,
+	)
+}
+
+func TestGoproxyVerifyCacheSize(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestGoproxyVerifyCacheSize")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	g := &Goproxy{
+		Cacher:            DirCacher(tempDir),
+		CacherVerifySizes: true,
+	}
+	g.init()
+	if err := g.putCache(
+		context.Background(),
+		"foo",
+		strings.NewReader("foobar"),
+		time.Hour,
 	); err != nil {
 		t.Fatalf("unexpected error %q", err)
 	}
+
+	g = &Goproxy{
+		Cacher:            truncatingCacher{DirCacher(tempDir)},
+		CacherVerifySizes: true,
+	}
+	g.init()
+	if err := g.putCache(
+		context.Background(),
+		"bar",
+		strings.NewReader("foobar"),
+		time.Hour,
+	); err == nil {
+		t.Fatal("expected error")
+	} else if got, want := err.Error(), "cache size mismatch: bar: got 1 bytes, want 6 bytes"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if _, err := g.cache(
+		context.Background(),
+		"bar",
+	); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got error %q, want error %q", err, os.ErrNotExist)
+	}
 }
 
 func TestGoproxyPutCacheFile(t *testing.T) {
@@ -1144,6 +1435,7 @@ func TestGoproxyPutCacheFile(t *testing.T) {
 		context.Background(),
 		"foo",
 		cacheFile.Name(),
+		time.Minute,
 	); err != nil {
 		t.Fatalf("unexpected error %q", err)
 	} else if b, err := ioutil.ReadFile(filepath.Join(
@@ -1158,6 +1450,7 @@ func TestGoproxyPutCacheFile(t *testing.T) {
 		context.Background(),
 		"bar",
 		filepath.Join(tempDir, "bar-sourcel"),
+		time.Minute,
 	); err == nil {
 		t.Fatal("expected error")
 	} else if got, want := errors.Is(err, os.ErrNotExist),
@@ -1166,6 +1459,25 @@ func TestGoproxyPutCacheFile(t *testing.T) {
 	}
 }
 
+func TestGoproxyInTempDir(t *testing.T) {
+	g := &Goproxy{TempDir: filepath.Join("foo", "tmp")}
+	for n, tt := range []struct {
+		file string
+		want bool
+	}{
+		{filepath.Join("foo", "tmp", "bar"), true},
+		{filepath.Join("foo", "tmp", "bar", "baz"), true},
+		{filepath.Join("foo", "tmp"), false},
+		{filepath.Join("foo", "tmpbar"), false},
+		{filepath.Join("foo", "bar"), false},
+		{filepath.Join("foo", "tmp", "..", "bar"), false},
+	} {
+		if got := g.inTempDir(tt.file); got != tt.want {
+			t.Errorf("test(%d): got %t, want %t", n, got, tt.want)
+		}
+	}
+}
+
 func TestGoproxyLogErrorf(t *testing.T) {
 	var errorLoggerBuffer bytes.Buffer
 	g := &Goproxy{
@@ -1196,6 +1508,63 @@ func TestGoproxyLogErrorf(t *testing.T) {
 	}
 }
 
+func TestGoproxyLogRequestErrorf(t *testing.T) {
+	var errorLoggerBuffer bytes.Buffer
+	g := &Goproxy{
+		ErrorLogger: log.New(&errorLoggerBuffer, "", log.Lshortfile),
+	}
+	g.init()
+	req := httptest.NewRequest("", "/", nil)
+	g.logRequestErrorf(req, "not found: %s", "invalid version")
+	if got, want := errorLoggerBuffer.String(), "goproxy: not found: "+
+		"invalid version\n"; !strings.HasPrefix(got, "goproxy_test.go:") ||
+		!strings.HasSuffix(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	errorLoggerBuffer.Reset()
+	req = withErrorReferenceIDContext(req)
+	g.logRequestErrorf(req, "not found: %s", "invalid version")
+	if got, want := errorLoggerBuffer.String(), fmt.Sprintf(
+		"goproxy: [%s] not found: invalid version\n",
+		errorReferenceID(req),
+	); !strings.HasPrefix(got, "goproxy_test.go:") ||
+		!strings.HasSuffix(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestGoproxyErrorReferenceIDs(t *testing.T) {
+	var errorLoggerBuffer bytes.Buffer
+	g := &Goproxy{
+		GoBinEnv:          []string{},
+		TempDir:           filepath.Join(os.TempDir(), "goproxy.nonexistent"),
+		ErrorLogger:       log.New(&errorLoggerBuffer, "", 0),
+		ErrorReferenceIDs: true,
+	}
+	g.init()
+
+	req := httptest.NewRequest("", "/example.com/@latest", nil)
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, req)
+	if got, want := rec.Code, http.StatusInternalServerError; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	body := rec.Body.String()
+	if !strings.HasPrefix(body, "internal server error (reference: ") {
+		t.Fatalf("unexpected body %q", body)
+	}
+
+	id := strings.TrimSuffix(
+		strings.TrimPrefix(body, "internal server error (reference: "),
+		")",
+	)
+	if got, want := errorLoggerBuffer.String(), "goproxy: ["+id+"] "; !strings.HasPrefix(got, want) {
+		t.Errorf("got %q, want prefix %q", got, want)
+	}
+}
+
 func TestWalkGOPROXY(t *testing.T) {
 	if err := walkGOPROXY("", nil, nil, nil); err == nil {
 		t.Fatal("expected error")
@@ -1420,6 +1789,93 @@ func TestWalkGOPROXY(t *testing.T) {
 	} else if got, want := onOff, false; got != want {
 		t.Errorf("got %v, want %v", got, want)
 	}
+
+	for n, tt := range []struct {
+		goproxy    string
+		errs       map[string]error
+		wantWalked string
+		wantErr    string
+	}{
+		{
+			goproxy:    " a , b ",
+			errs:       map[string]error{"a": notFoundError("a")},
+			wantWalked: "a b",
+		},
+		{
+			goproxy: ", ,",
+			wantErr: "GOPROXY list is not the empty string, " +
+				"but contains no entries",
+		},
+		{
+			goproxy: "a|b,c",
+			errs: map[string]error{
+				"a": errors.New("a"),
+				"b": notFoundError("b"),
+				"c": notFoundError("c"),
+			},
+			wantWalked: "a b c",
+			wantErr:    "a",
+		},
+		{
+			goproxy: "a|b|off",
+			errs: map[string]error{
+				"a": errors.New("a"),
+				"b": errors.New("b"),
+				"off": notFoundError(
+					"module lookup disabled by GOPROXY=off",
+				),
+			},
+			wantWalked: "a b off",
+			wantErr:    "b",
+		},
+		{
+			goproxy: "a,off,b",
+			errs: map[string]error{
+				"a": notFoundError("a"),
+				"off": notFoundError(
+					"module lookup disabled by GOPROXY=off",
+				),
+			},
+			wantWalked: "a off",
+			wantErr:    "module lookup disabled by GOPROXY=off",
+		},
+		{
+			goproxy: "a|direct,b",
+			errs: map[string]error{
+				"a":      errors.New("a"),
+				"direct": notFoundError("direct"),
+			},
+			wantWalked: "a direct",
+			wantErr:    "direct",
+		},
+	} {
+		var walked []string
+		walk := func(proxy string) error {
+			walked = append(walked, proxy)
+			return tt.errs[proxy]
+		}
+
+		err := walkGOPROXY(
+			tt.goproxy,
+			walk,
+			func() error { return walk("direct") },
+			func() error { return walk("off") },
+		)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Fatalf("test(%d): unexpected error %q", n, err)
+			}
+		} else if err == nil {
+			t.Fatalf("test(%d): expected error", n)
+		} else if got, want := err.Error(), tt.wantErr; got != want {
+			t.Errorf("test(%d): got %q, want %q", n, got, want)
+		}
+
+		if got, want := strings.Join(walked, " "),
+			tt.wantWalked; got != want {
+			t.Errorf("test(%d): got %q, want %q", n, got, want)
+		}
+	}
 }
 
 func TestExponentialBackoffSleep(t *testing.T) {
@@ -1490,3 +1946,15 @@ func TestGlobsMatchPath(t *testing.T) {
 		t.Error("want false")
 	}
 }
+
+func TestGlobsMatchedGlob(t *testing.T) {
+	if got, want := globsMatchedGlob("foo,bar/*", "bar/baz/qux"),
+		"bar/*"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if got, want := globsMatchedGlob("foo,bar/*", "baz"),
+		""; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,234 @@
+package goproxy
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// hotCachePromotionHits is the number of hits within a
+	// [hotCacheDecayInterval] that promote a cached file into a [hotCache].
+	hotCachePromotionHits = 3
+
+	// hotCacheDecayInterval is the interval at which the hits of a
+	// [hotCache] are reset. Files without hits during an interval are
+	// demoted.
+	hotCacheDecayInterval = time.Minute
+
+	// hotCacheMaxCandidates is the maximum number of names whose hits are
+	// tracked by a [hotCache] within a [hotCacheDecayInterval].
+	hotCacheMaxCandidates = 10000
+)
+
+// hotCache is a set of the hottest cached files memory-mapped within a budget.
+// It only applies to the caches opened as local files (e.g. by the
+// [DirCacher]). It is safe for concurrent use.
+type hotCache struct {
+	maxBytes    int64
+	updateStats func(update func(s *Stats))
+
+	mutex     sync.Mutex
+	entries   map[string]*hotEntry
+	bytes     int64
+	decayedAt time.Time
+}
+
+// hotEntry is an entry of a [hotCache].
+type hotEntry struct {
+	hits    int
+	fi      os.FileInfo
+	data    []byte
+	refs    int
+	demoted bool
+}
+
+// newHotCache returns a new instance of the [hotCache] with the maxBytes.
+func newHotCache(
+	maxBytes int64,
+	updateStats func(update func(s *Stats)),
+) *hotCache {
+	return &hotCache{
+		maxBytes:    maxBytes,
+		updateStats: updateStats,
+		entries:     map[string]*hotEntry{},
+		decayedAt:   time.Now(),
+	}
+}
+
+// open counts a hit of the cache for the name, and returns its content from
+// memory if it is hot. Otherwise, the content is returned as it is. The
+// returned content must be closed instead of the content.
+func (hc *hotCache) open(name string, content io.ReadCloser) io.ReadCloser {
+	f, ok := content.(interface {
+		Fd() uintptr
+		Stat() (os.FileInfo, error)
+	})
+	if !ok {
+		return content
+	}
+
+	fi, err := f.Stat()
+	if err != nil || !fi.Mode().IsRegular() {
+		return content
+	}
+
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+	hc.decayLocked()
+
+	he, ok := hc.entries[name]
+	if !ok {
+		if len(hc.entries) >= hotCacheMaxCandidates {
+			return content
+		}
+
+		he = &hotEntry{}
+		hc.entries[name] = he
+	}
+
+	he.hits++
+	if he.data != nil && !sameFileVersion(he.fi, fi) {
+		hc.demoteLocked(name, he)
+		he = &hotEntry{hits: he.hits}
+		hc.entries[name] = he
+	}
+
+	if he.data == nil && (he.hits < hotCachePromotionHits ||
+		!hc.promoteLocked(he, f.Fd(), fi)) {
+		return content
+	}
+
+	he.refs++
+	hc.updateStats(func(s *Stats) { s.HotCacheHits++ })
+	return &hotContent{
+		Reader:  bytes.NewReader(he.data),
+		content: content,
+		modTime: fi.ModTime(),
+		release: func() { hc.release(he) },
+	}
+}
+
+// promoteLocked memory-maps the file of the fd with the fi for the he, demoting
+// colder entries if the budget requires. It reports whether the he has been
+// promoted. It must be called with the hc.mutex held.
+func (hc *hotCache) promoteLocked(
+	he *hotEntry,
+	fd uintptr,
+	fi os.FileInfo,
+) bool {
+	size := fi.Size()
+	if size <= 0 || size > hc.maxBytes {
+		return false
+	}
+
+	for hc.bytes+size > hc.maxBytes {
+		var (
+			coldestName string
+			coldest     *hotEntry
+		)
+		for name, e := range hc.entries {
+			if e.data != nil &&
+				e.hits < he.hits &&
+				(coldest == nil || e.hits < coldest.hits) {
+				coldestName, coldest = name, e
+			}
+		}
+
+		if coldest == nil {
+			return false
+		}
+
+		hc.demoteLocked(coldestName, coldest)
+	}
+
+	data, err := mmapFile(fd, size)
+	if err != nil {
+		return false
+	}
+
+	he.fi = fi
+	he.data = data
+	hc.bytes += size
+	hc.updateStats(func(s *Stats) { s.HotCachePromotions++ })
+	return true
+}
+
+// demoteLocked removes the he for the name from the hc. Its memory is unmapped
+// once it is no longer in use. It must be called with the hc.mutex held.
+func (hc *hotCache) demoteLocked(name string, he *hotEntry) {
+	if hc.entries[name] == he {
+		delete(hc.entries, name)
+	}
+
+	he.demoted = true
+	hc.bytes -= int64(len(he.data))
+	hc.updateStats(func(s *Stats) { s.HotCacheDemotions++ })
+	if he.refs == 0 {
+		munmapFile(he.data)
+		he.data = nil
+	}
+}
+
+// decayLocked demotes the entries without hits since the last decay and resets
+// the hits of the others, at most once per [hotCacheDecayInterval]. It must be
+// called with the hc.mutex held.
+func (hc *hotCache) decayLocked() {
+	if time.Since(hc.decayedAt) < hotCacheDecayInterval {
+		return
+	}
+
+	for name, he := range hc.entries {
+		if he.data == nil {
+			delete(hc.entries, name)
+		} else if he.hits == 0 {
+			hc.demoteLocked(name, he)
+		} else {
+			he.hits = 0
+		}
+	}
+
+	hc.decayedAt = time.Now()
+}
+
+// release releases a use of the he.
+func (hc *hotCache) release(he *hotEntry) {
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+	he.refs--
+	if he.demoted && he.refs == 0 {
+		munmapFile(he.data)
+		he.data = nil
+	}
+}
+
+// sameFileVersion reports whether the fi1 and the fi2 describe the same version
+// of the same file.
+func sameFileVersion(fi1, fi2 os.FileInfo) bool {
+	return os.SameFile(fi1, fi2) &&
+		fi1.Size() == fi2.Size() &&
+		fi1.ModTime().Equal(fi2.ModTime())
+}
+
+// hotContent is the content of a cache served from a [hotCache].
+type hotContent struct {
+	*bytes.Reader
+
+	content io.Closer
+	modTime time.Time
+	release func()
+	once    sync.Once
+}
+
+// ModTime returns the modification time of the cached file.
+func (hc *hotContent) ModTime() time.Time {
+	return hc.modTime
+}
+
+// Close implements the [io.Closer].
+func (hc *hotContent) Close() error {
+	hc.once.Do(hc.release)
+	return hc.content.Close()
+}
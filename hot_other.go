@@ -0,0 +1,17 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package goproxy
+
+import "errors"
+
+// mmapFile always returns an error since memory-mapped files are not supported
+// on the current platform.
+func mmapFile(fd uintptr, size int64) ([]byte, error) {
+	return nil, errors.New("memory-mapped files are not supported")
+}
+
+// munmapFile does nothing.
+func munmapFile(b []byte) error {
+	return nil
+}
@@ -0,0 +1,23 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package goproxy
+
+import "syscall"
+
+// mmapFile maps the first size bytes of the file of the fd into memory for
+// reading.
+func mmapFile(fd uintptr, size int64) ([]byte, error) {
+	return syscall.Mmap(
+		int(fd),
+		0,
+		int(size),
+		syscall.PROT_READ,
+		syscall.MAP_SHARED,
+	)
+}
+
+// munmapFile unmaps the b mapped by the [mmapFile].
+func munmapFile(b []byte) error {
+	return syscall.Munmap(b)
+}
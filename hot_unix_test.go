@@ -0,0 +1,195 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package goproxy
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHotCache(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestHotCache")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	for _, name := range []string{"a", "b", "c"} {
+		if err := ioutil.WriteFile(
+			filepath.Join(tempDir, name),
+			[]byte(strings.Repeat(name, 10)),
+			0600,
+		); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	var stats Stats
+	hc := newHotCache(15, func(update func(s *Stats)) { update(&stats) })
+	open := func(name string) io.ReadCloser {
+		f, err := os.Open(filepath.Join(tempDir, name))
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		return hc.open(name, f)
+	}
+
+	read := func(name string) bool {
+		content := open(name)
+		defer content.Close()
+		b, err := ioutil.ReadAll(content)
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		want := strings.Repeat(name, 10)
+		if got := string(b); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+
+		_, hot := content.(*hotContent)
+		return hot
+	}
+
+	for i, want := range []bool{false, false, true, true} {
+		if got := read("a"); got != want {
+			t.Errorf("a#%d: got %t, want %t", i, got, want)
+		}
+	}
+
+	for i, want := range []bool{false, false, false, false, true} {
+		if got := read("b"); got != want {
+			t.Errorf("b#%d: got %t, want %t", i, got, want)
+		}
+	}
+
+	if got, want := stats.HotCachePromotions, int64(2); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	if got, want := stats.HotCacheDemotions, int64(1); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	if got, want := stats.HotCacheHits, int64(3); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	content := open("b")
+	if _, ok := content.(*hotContent); !ok {
+		t.Fatal("expected hot content")
+	}
+
+	hc.decayedAt = time.Time{}
+	read("c")
+	hc.decayedAt = time.Time{}
+	read("c")
+	if _, ok := hc.entries["b"]; ok {
+		t.Error("expected false")
+	}
+
+	if got, want := hc.bytes, int64(0); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	b, err := ioutil.ReadAll(content)
+	content.Close()
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := string(b), strings.Repeat("b", 10); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if got := read("c"); got {
+		t.Error("expected false")
+	}
+
+	hc = newHotCache(5, func(update func(s *Stats)) { update(&stats) })
+	for i := 0; i < hotCachePromotionHits; i++ {
+		if read("a") {
+			t.Error("expected false")
+		}
+	}
+
+	if got := hc.open("a", ioutil.NopCloser(nil)); got == nil {
+		t.Error("expected non-nil")
+	}
+}
+
+func TestGoproxyHotCache(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestGoproxyHotCache")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	g := &Goproxy{
+		GoBinEnv:         []string{"GOPROXY=off"},
+		Cacher:           DirCacher(tempDir),
+		TempDir:          tempDir,
+		HotCacheMaxBytes: 1 << 20,
+	}
+
+	put := func(content string) {
+		if err := g.Cacher.Put(
+			context.Background(),
+			"example.com/@v/v1.0.0.mod",
+			strings.NewReader(content),
+			time.Hour,
+		); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	get := func(want string) {
+		req := httptest.NewRequest(
+			"",
+			"/example.com/@v/v1.0.0.mod",
+			nil,
+		)
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, req)
+		if got, want := rec.Code, http.StatusOK; got != want {
+			t.Fatalf("got %d, want %d", got, want)
+		}
+
+		if got := rec.Body.String(); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+
+		if rec.Header().Get("Last-Modified") == "" {
+			t.Error("expected non-empty Last-Modified")
+		}
+	}
+
+	put("module example.com")
+	for i := 0; i < 4; i++ {
+		get("module example.com")
+	}
+
+	if got, want := g.Stats().HotCacheHits, int64(2); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	put("module example.com // changed")
+	get("module example.com // changed")
+
+	stats := g.Stats()
+	if got, want := stats.HotCachePromotions, int64(2); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	if got, want := stats.HotCacheDemotions, int64(1); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
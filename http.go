@@ -19,11 +19,26 @@ var (
 	// errNotFound means something was not found.
 	errNotFound = errors.New("not found")
 
+	// errGone means something was not found and is known to be gone for
+	// good.
+	errGone = errors.New("gone")
+
+	// errUpstreamGone means an upstream has reported something as gone
+	// (410 Gone).
+	errUpstreamGone = errors.New("upstream gone")
+
+	// errForbidden means something is not allowed.
+	errForbidden = errors.New("forbidden")
+
 	// errBadUpstream means an upstream is bad.
 	errBadUpstream = errors.New("bad upstream")
 
 	// errFetchTimedOut means a fetch operation has timed out.
 	errFetchTimedOut = errors.New("fetch timed out")
+
+	// errNotModified means something has not been modified since it was
+	// last retrieved.
+	errNotModified = errors.New("not modified")
 )
 
 // notFoundError is an error indicating that something was not found.
@@ -39,24 +54,179 @@ func (notFoundError) Is(target error) bool {
 	return target == errNotFound
 }
 
-// httpGet gets the content targeted by the url into the dst.
+// goneError is an error indicating that something is gone. It also satisfies
+// errors.Is(err, errNotFound).
+type goneError string
+
+// Error implements the error.
+func (ge goneError) Error() string {
+	return string(ge)
+}
+
+// Is reports whether the target is [errGone] or [errNotFound].
+func (goneError) Is(target error) bool {
+	return target == errGone || target == errNotFound
+}
+
+// upstreamGoneError is an error indicating that an upstream has reported
+// something as gone. It also satisfies errors.Is(err, errGone) and
+// errors.Is(err, errNotFound).
+type upstreamGoneError string
+
+// Error implements the error.
+func (uge upstreamGoneError) Error() string {
+	return string(uge)
+}
+
+// Is reports whether the target is [errUpstreamGone], [errGone] or
+// [errNotFound].
+func (upstreamGoneError) Is(target error) bool {
+	return target == errUpstreamGone ||
+		target == errGone ||
+		target == errNotFound
+}
+
+// forbiddenError is an error indicating that something is not allowed.
+type forbiddenError string
+
+// Error implements the error.
+func (fe forbiddenError) Error() string {
+	return string(fe)
+}
+
+// Is reports whether the target is [errForbidden].
+func (forbiddenError) Is(target error) bool {
+	return target == errForbidden
+}
+
+// upstreamRetryContextKey is the context key of the [upstreamRetry].
+type upstreamRetryContextKey struct{}
+
+// upstreamRetry is the policy for retrying failed requests sent to upstreams.
+type upstreamRetry struct {
+	maxRetries           int
+	backoffBase          time.Duration
+	backoffCap           time.Duration
+	retryableStatusCodes []int
+}
+
+// defaultUpstreamRetry is the default [upstreamRetry].
+var defaultUpstreamRetry = &upstreamRetry{
+	maxRetries:  9,
+	backoffBase: 100 * time.Millisecond,
+	backoffCap:  time.Second,
+	retryableStatusCodes: []int{
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+	},
+}
+
+// newUpstreamRetry returns a new instance of the [upstreamRetry] from the
+// [Goproxy.UpstreamMaxRetries], [Goproxy.UpstreamRetryBackoffBase],
+// [Goproxy.UpstreamRetryBackoffCap] and
+// [Goproxy.UpstreamRetryableStatusCodes] of the g.
+func newUpstreamRetry(g *Goproxy) *upstreamRetry {
+	ur := *defaultUpstreamRetry
+	if g.UpstreamMaxRetries > 0 {
+		ur.maxRetries = g.UpstreamMaxRetries
+	} else if g.UpstreamMaxRetries < 0 {
+		ur.maxRetries = 0
+	}
+
+	if g.UpstreamRetryBackoffBase > 0 {
+		ur.backoffBase = g.UpstreamRetryBackoffBase
+	}
+
+	if g.UpstreamRetryBackoffCap > 0 {
+		ur.backoffCap = g.UpstreamRetryBackoffCap
+	}
+
+	if ur.backoffCap < ur.backoffBase {
+		ur.backoffCap = ur.backoffBase
+	}
+
+	if g.UpstreamRetryableStatusCodes != nil {
+		ur.retryableStatusCodes = g.UpstreamRetryableStatusCodes
+	}
+
+	return &ur
+}
+
+// retryable reports whether the responses with the statusCode are retried.
+func (ur *upstreamRetry) retryable(statusCode int) bool {
+	for _, sc := range ur.retryableStatusCodes {
+		if sc == statusCode {
+			return true
+		}
+	}
+
+	return false
+}
+
+// withUpstreamRetry returns a copy of the ctx carrying the ur, which the
+// requests sent to upstreams with it are retried according to.
+func withUpstreamRetry(
+	ctx context.Context,
+	ur *upstreamRetry,
+) context.Context {
+	if ur == nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, upstreamRetryContextKey{}, ur)
+}
+
+// upstreamRetryOf returns the [upstreamRetry] carried by the ctx, which
+// defaults to the [defaultUpstreamRetry].
+func upstreamRetryOf(ctx context.Context) *upstreamRetry {
+	if ur, ok := ctx.Value(upstreamRetryContextKey{}).(*upstreamRetry); ok {
+		return ur
+	}
+
+	return defaultUpstreamRetry
+}
+
+// httpGet gets the content targeted by the url into the dst. Truncated transfers
+// (including the ones whose size does not match the Content-Length) are
+// retried if the dst can be reset (see [resetWriter]). Failed requests are
+// retried according to the [upstreamRetry] carried by the ctx (see
+// [withUpstreamRetry]).
 func httpGet(
 	ctx context.Context,
 	httpClient *http.Client,
 	url string,
 	dst io.Writer,
 ) error {
+	_, err := httpGetWithHeader(ctx, httpClient, url, nil, dst)
+	return err
+}
+
+// httpGetWithHeader is like the [httpGet], but sends the reqHeader along with
+// the request and returns the header of the successful response. It returns
+// [errNotModified] if the response is a 304 Not Modified, which is only
+// possible when the reqHeader makes the request conditional.
+func httpGetWithHeader(
+	ctx context.Context,
+	httpClient *http.Client,
+	url string,
+	reqHeader http.Header,
+	dst io.Writer,
+) (http.Header, error) {
+	ur := upstreamRetryOf(ctx)
 	var lastError error
-	for attempt := 0; attempt < 10; attempt++ {
+	for attempt := 0; attempt <= ur.maxRetries; attempt++ {
 		if attempt > 0 {
 			select {
 			case <-time.After(exponentialBackoffSleep(
-				100*time.Millisecond,
-				time.Second,
+				ur.backoffBase,
+				ur.backoffCap,
 				attempt,
 			)):
 			case <-ctx.Done():
-				return lastError
+				return nil, lastError
 			}
 		}
 
@@ -67,7 +237,11 @@ func httpGet(
 			nil,
 		)
 		if err != nil {
-			return err
+			return nil, err
+		}
+
+		for k, vs := range reqHeader {
+			req.Header[k] = vs
 		}
 
 		res, err := httpClient.Do(req)
@@ -77,48 +251,109 @@ func httpGet(
 				continue
 			}
 
-			return err
+			return nil, err
+		}
+
+		if res.StatusCode == http.StatusNotModified {
+			res.Body.Close()
+			return res.Header, errNotModified
 		}
 
 		if res.StatusCode == http.StatusOK {
-			if dst != nil {
-				_, err = io.Copy(dst, res.Body)
+			if dst == nil {
+				res.Body.Close()
+				return res.Header, nil
 			}
 
+			n, err := io.Copy(dst, res.Body)
 			res.Body.Close()
+			if err == nil &&
+				res.ContentLength >= 0 &&
+				n != res.ContentLength {
+				err = fmt.Errorf(
+					"got %d bytes, want %d bytes: %w",
+					n,
+					res.ContentLength,
+					io.ErrUnexpectedEOF,
+				)
+			}
 
-			return err
+			if err == nil {
+				return res.Header, nil
+			} else if !errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil, err
+			}
+
+			err = fmt.Errorf(
+				"%w: GET %s: truncated transfer: %v",
+				errBadUpstream,
+				redactedURL(req.URL),
+				err,
+			)
+			if resetWriter(dst) != nil {
+				return nil, err
+			}
+
+			lastError = err
+			continue
 		}
 
 		b, err := ioutil.ReadAll(res.Body)
 		res.Body.Close()
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		switch res.StatusCode {
-		case http.StatusBadRequest,
-			http.StatusNotFound,
-			http.StatusGone:
-			return notFoundError(b)
+		case http.StatusBadRequest, http.StatusNotFound:
+			return nil, notFoundError(b)
+		case http.StatusGone:
+			return nil, upstreamGoneError(b)
 		case http.StatusTooManyRequests,
 			http.StatusInternalServerError,
 			http.StatusBadGateway,
 			http.StatusServiceUnavailable:
-			lastError = errBadUpstream
+			err = errBadUpstream
 		case http.StatusGatewayTimeout:
-			lastError = errFetchTimedOut
+			err = errFetchTimedOut
 		default:
-			return fmt.Errorf(
+			err = fmt.Errorf(
 				"GET %s: %s: %s",
 				redactedURL(req.URL),
 				res.Status,
 				b,
 			)
 		}
+
+		if !ur.retryable(res.StatusCode) {
+			return nil, err
+		}
+
+		lastError = err
+	}
+
+	return nil, lastError
+}
+
+// resetWriter resets the w, which must have been empty before being written,
+// to be empty again. It returns an error if the w cannot be reset.
+func resetWriter(w io.Writer) error {
+	switch w := w.(type) {
+	case interface{ Reset() }:
+		w.Reset()
+		return nil
+	case interface {
+		io.Seeker
+		Truncate(size int64) error
+	}:
+		if _, err := w.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		return w.Truncate(0)
 	}
 
-	return lastError
+	return errors.New("writer cannot be reset")
 }
 
 // isRetryableHTTPClientDoError reports whether the err is a retryable error
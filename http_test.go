@@ -7,10 +7,13 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"reflect"
 	"strings"
 	"syscall"
 	"testing"
@@ -33,6 +36,22 @@ func TestNotFoundError(t *testing.T) {
 	}
 }
 
+func TestGoneError(t *testing.T) {
+	ges := "something gone"
+	ge := goneError(ges)
+	if got, want := ge.Error(), ges; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	} else if got, want := errors.Is(ge, errGone), true; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	} else if got, want := errors.Is(ge, errNotFound), true; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	} else if got, want := errors.Is(ge, io.EOF), false; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	} else if got, want := errors.Is(notFoundError(ges), errGone), false; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
 func TestHTTPGet(t *testing.T) {
 	savedExponentialBackoffRand := exponentialBackoffRand
 	exponentialBackoffRand = rand.New(rand.NewSource(1))
@@ -232,6 +251,207 @@ func TestHTTPGet(t *testing.T) {
 	}
 }
 
+func TestHTTPGetTruncatedTransfer(t *testing.T) {
+	savedExponentialBackoffRand := exponentialBackoffRand
+	exponentialBackoffRand = rand.New(rand.NewSource(1))
+	defer func() { exponentialBackoffRand = savedExponentialBackoffRand }()
+
+	var attempts int
+	handlerFunc := func(rw http.ResponseWriter, req *http.Request) {
+		attempts++
+		rw.Header().Set("Content-Length", "6")
+		if attempts < 3 {
+			fmt.Fprint(rw, "foo")
+		} else {
+			fmt.Fprint(rw, "foobar")
+		}
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(
+		rw http.ResponseWriter,
+		req *http.Request,
+	) {
+		handlerFunc(rw, req)
+	}))
+	defer server.Close()
+
+	var buf bytes.Buffer
+	if err := httpGet(
+		context.Background(),
+		http.DefaultClient,
+		server.URL,
+		&buf,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := buf.String(), "foobar"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	} else if got, want := attempts, 3; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	handlerFunc = func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Length", "6")
+		fmt.Fprint(rw, "foo")
+	}
+	buf.Reset()
+	ctx, cancel := context.WithTimeout(
+		context.Background(),
+		500*time.Millisecond,
+	)
+	defer cancel()
+	if err := httpGet(
+		ctx,
+		http.DefaultClient,
+		server.URL,
+		&buf,
+	); !errors.Is(err, errBadUpstream) {
+		t.Fatalf("got error %q, want error %q", err, errBadUpstream)
+	}
+
+	if err := httpGet(
+		context.Background(),
+		http.DefaultClient,
+		server.URL,
+		&struct{ io.Writer }{&buf},
+	); !errors.Is(err, errBadUpstream) {
+		t.Fatalf("got error %q, want error %q", err, errBadUpstream)
+	}
+}
+
+func TestNewUpstreamRetry(t *testing.T) {
+	for n, tt := range []struct {
+		g    *Goproxy
+		want upstreamRetry
+	}{
+		{&Goproxy{}, *defaultUpstreamRetry},
+		{
+			&Goproxy{
+				UpstreamMaxRetries:           3,
+				UpstreamRetryBackoffBase:     time.Second,
+				UpstreamRetryBackoffCap:      time.Minute,
+				UpstreamRetryableStatusCodes: []int{},
+			},
+			upstreamRetry{3, time.Second, time.Minute, []int{}},
+		},
+		{
+			&Goproxy{
+				UpstreamMaxRetries:       -1,
+				UpstreamRetryBackoffBase: 2 * time.Second,
+			},
+			upstreamRetry{
+				0,
+				2 * time.Second,
+				2 * time.Second,
+				defaultUpstreamRetry.retryableStatusCodes,
+			},
+		},
+	} {
+		got := newUpstreamRetry(tt.g)
+		if !reflect.DeepEqual(*got, tt.want) {
+			t.Errorf("test(%d): got %+v, want %+v", n, *got, tt.want)
+		}
+	}
+}
+
+func TestHTTPGetUpstreamRetry(t *testing.T) {
+	var attempts int
+	server := httptest.NewServer(http.HandlerFunc(func(
+		rw http.ResponseWriter,
+		req *http.Request,
+	) {
+		attempts++
+		if attempts%3 != 0 {
+			rw.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		fmt.Fprint(rw, "foobar")
+	}))
+	defer server.Close()
+
+	for n, tt := range []struct {
+		maxRetries   int
+		statusCodes  []int
+		wantErr      error
+		wantAttempts int
+	}{
+		{2, []int{http.StatusBadGateway}, nil, 3},
+		{1, []int{http.StatusBadGateway}, errBadUpstream, 2},
+		{9, nil, errBadUpstream, 1},
+	} {
+		attempts = 0
+		ur := &upstreamRetry{
+			maxRetries:           tt.maxRetries,
+			backoffBase:          time.Millisecond,
+			backoffCap:           time.Millisecond,
+			retryableStatusCodes: tt.statusCodes,
+		}
+		var buf bytes.Buffer
+		err := httpGet(
+			withUpstreamRetry(context.Background(), ur),
+			http.DefaultClient,
+			server.URL,
+			&buf,
+		)
+		if tt.wantErr != nil {
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf(
+					"test(%d): got error %q, want error %q",
+					n,
+					err,
+					tt.wantErr,
+				)
+			}
+		} else if err != nil {
+			t.Errorf("test(%d): unexpected error %q", n, err)
+		} else if got, want := buf.String(), "foobar"; got != want {
+			t.Errorf("test(%d): got %q, want %q", n, got, want)
+		}
+
+		if got, want := attempts, tt.wantAttempts; got != want {
+			t.Errorf("test(%d): got %d, want %d", n, got, want)
+		}
+	}
+}
+
+func TestResetWriter(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("foobar")
+	if err := resetWriter(&buf); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := buf.Len(), 0; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	f, err := ioutil.TempFile("", "goproxy.TestResetWriter")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if _, err := f.WriteString("foobar"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if err := resetWriter(f); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if _, err := f.WriteString("foo"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if b, err := ioutil.ReadFile(f.Name()); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := string(b), "foo"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if err := resetWriter(struct{ io.Writer }{&buf}); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
 func TestIsRetryableHTTPClientDoError(t *testing.T) {
 	got := isRetryableHTTPClientDoError(syscall.ECONNRESET)
 	want := true
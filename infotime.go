@@ -0,0 +1,118 @@
+package goproxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// maxInfoTimeSkew is the maximum amount of time the time of an info may be
+// ahead of the current time before it is considered bogus.
+const maxInfoTimeSkew = 24 * time.Hour
+
+// isBogusInfoTime reports whether the t is a bogus time of an info at the now:
+// zero, not after the Unix epoch, or ahead of the now by more than the
+// [maxInfoTimeSkew].
+func isBogusInfoTime(t, now time.Time) bool {
+	return t.IsZero() || t.Unix() <= 0 || t.After(now.Add(maxInfoTimeSkew))
+}
+
+// verifyInfoTime verifies the time of the info file targeted by the name that
+// has been fetched for the f from a module proxy.
+//
+// A bogus time is counted in the [Stats.BogusInfoTimes] and logged. If the
+// [Goproxy.CorrectInfoTimes] is true, it is also replaced in place with the
+// time encoded in the version if it is a pseudo-version, or with the commit
+// time reported by its version control system otherwise. Info files that
+// cannot be parsed are left for the later checks to reject.
+func (f *fetch) verifyInfoTime(ctx context.Context, name string) error {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+
+	var info struct {
+		Version string
+		Time    time.Time
+	}
+	if json.Unmarshal(b, &info) != nil ||
+		!semver.IsValid(info.Version) ||
+		!isBogusInfoTime(info.Time, time.Now()) {
+		return nil
+	}
+
+	f.g.updateStats(func(s *Stats) { s.BogusInfoTimes++ })
+	modAtVer := fmt.Sprint(f.modulePath, "@", info.Version)
+	if !f.g.settings().CorrectInfoTimes {
+		f.g.logErrorf(
+			"bogus info time of %s: %s",
+			modAtVer,
+			info.Time.Format(time.RFC3339Nano),
+		)
+		return nil
+	}
+
+	t, err := f.originInfoTime(ctx, info.Version)
+	if err != nil {
+		f.g.logErrorf(
+			"failed to correct bogus info time of %s: %v",
+			modAtVer,
+			err,
+		)
+		return nil
+	}
+
+	f.g.logErrorf(
+		"corrected bogus info time of %s: %s -> %s",
+		modAtVer,
+		info.Time.Format(time.RFC3339Nano),
+		t.Format(time.RFC3339Nano),
+	)
+
+	return ioutil.WriteFile(
+		name,
+		[]byte(marshalInfo(info.Version, t)),
+		0600,
+	)
+}
+
+// originInfoTime returns the time of the version of the module of the f as
+// known to its origin: the time encoded in the version if it is a
+// pseudo-version, or the commit time reported by its version control system
+// otherwise.
+func (f *fetch) originInfoTime(
+	ctx context.Context,
+	version string,
+) (time.Time, error) {
+	if module.IsPseudoVersion(version) {
+		return module.PseudoVersionTime(version)
+	}
+
+	df := *f
+	df.ops = fetchOpsResolve
+	df.moduleVersion = version
+	df.modAtVer = fmt.Sprint(f.modulePath, "@", version)
+	r, err := df.doDirect(ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if r.Version != version {
+		return time.Time{}, fmt.Errorf(
+			"version control system resolved %s",
+			r.Version,
+		)
+	} else if isBogusInfoTime(r.Time, time.Now()) {
+		return time.Time{}, fmt.Errorf(
+			"version control system reported bogus time %s",
+			r.Time.Format(time.RFC3339Nano),
+		)
+	}
+
+	return r.Time, nil
+}
@@ -0,0 +1,193 @@
+package goproxy
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsBogusInfoTime(t *testing.T) {
+	now := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		t    time.Time
+		want bool
+	}{
+		{time.Time{}, true},
+		{time.Unix(0, 0), true},
+		{time.Unix(1, 0), false},
+		{now, false},
+		{now.Add(maxInfoTimeSkew), false},
+		{now.Add(maxInfoTimeSkew + time.Second), true},
+	} {
+		if got := isBogusInfoTime(tt.t, now); got != tt.want {
+			t.Errorf("%s: got %t, want %t", tt.t, got, tt.want)
+		}
+	}
+}
+
+func TestFetchVerifyInfoTime(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestFetchVerifyInfoTime")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	gopathDir := filepath.Join(tempDir, "gopath")
+
+	infoTime := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	upstreamInfo := marshalInfo("v1.0.0", time.Unix(0, 0))
+	directInfo := marshalInfo("v1.0.0", infoTime)
+
+	upstreamServer := httptest.NewServer(http.HandlerFunc(func(
+		rw http.ResponseWriter,
+		req *http.Request,
+	) {
+		switch req.URL.Path {
+		case "/example.com/@v/v1.0.0.info", "/example.com/@latest":
+			responseString(rw, req, http.StatusOK, -2, upstreamInfo)
+		case "/example.com/@v/v0.0.0-20000101000000-000000000000.info":
+			responseString(rw, req, http.StatusOK, -2, marshalInfo(
+				"v0.0.0-20000101000000-000000000000",
+				time.Time{},
+			))
+		default:
+			responseNotFound(rw, req, -2)
+		}
+	}))
+	defer upstreamServer.Close()
+
+	directServer := httptest.NewServer(http.HandlerFunc(func(
+		rw http.ResponseWriter,
+		req *http.Request,
+	) {
+		switch req.URL.Path {
+		case "/example.com/@v/v1.0.0.info", "/example.com/@latest":
+			responseString(rw, req, http.StatusOK, -2, directInfo)
+		case "/example.com/@v/list":
+			responseString(rw, req, http.StatusOK, -2, "v1.0.0")
+		default:
+			responseNotFound(rw, req, -2)
+		}
+	}))
+	defer directServer.Close()
+
+	newGoproxy := func(correctInfoTimes bool) *Goproxy {
+		g := &Goproxy{
+			GoBinEnv: append(
+				os.Environ(),
+				"GOPATH="+gopathDir,
+				"GOSUMDB=off",
+			),
+			CorrectInfoTimes: correctInfoTimes,
+			ErrorLogger:      log.New(&discardWriter{}, "", 0),
+		}
+		g.initOnce.Do(g.init)
+		g.goBinEnv = append(g.goBinEnv, "GOPROXY="+directServer.URL)
+		return g
+	}
+
+	doProxy := func(g *Goproxy, name string) *fetchResult {
+		f, err := newFetch(g, name, tempDir)
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		fr, err := f.doProxy(context.Background(), upstreamServer.URL)
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		return fr
+	}
+
+	g := newGoproxy(false)
+	fr := doProxy(g, "example.com/@latest")
+	if got, want := fr.Time, time.Unix(0, 0); !got.Equal(want) {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	if got, want := g.Stats().BogusInfoTimes, int64(1); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	g = newGoproxy(true)
+	fr = doProxy(g, "example.com/@latest")
+	if got, want := fr.Time, infoTime; !got.Equal(want) {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	fr = doProxy(g, "example.com/@v/v1.0.0.info")
+	if b, err := ioutil.ReadFile(fr.Info); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := string(b), directInfo; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	fr = doProxy(
+		g,
+		"example.com/@v/v0.0.0-20000101000000-000000000000.info",
+	)
+	if b, err := ioutil.ReadFile(fr.Info); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := string(b), marshalInfo(
+		"v0.0.0-20000101000000-000000000000",
+		infoTime,
+	); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if got, want := g.Stats().BogusInfoTimes, int64(3); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	g = newGoproxy(true)
+	if err := g.UpdateSettings(func(s *Settings) error {
+		s.ProxiedOnly = true
+		return nil
+	}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	fr = doProxy(g, "example.com/@latest")
+	if got, want := fr.Time, time.Unix(0, 0); !got.Equal(want) {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	f, err := newFetch(
+		g,
+		"example.com/@v/v0.0.0-20000101000000-000000000000.info",
+		tempDir,
+	)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	upstreamInfo = marshalInfo("v1.0.0", time.Time{})
+	if _, err := f.doProxy(
+		context.Background(),
+		upstreamServer.URL,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	f, err = newFetch(g, "example.com/@latest", tempDir)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if _, err := f.doProxy(
+		context.Background(),
+		upstreamServer.URL,
+	); err == nil {
+		t.Fatal("expected error")
+	} else if !strings.Contains(err.Error(), "zero time") {
+		t.Errorf("unexpected error %q", err)
+	}
+}
@@ -0,0 +1,292 @@
+package goproxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dirCacherLayoutFileName is the name of the file in the root of a cache
+// directory that records its [DirCacherLayout].
+const dirCacherLayoutFileName = ".goproxy-layout"
+
+// DirCacherLayout is the version of the layout of the cache files in a
+// directory on the local disk.
+type DirCacherLayout int
+
+const (
+	// DirCacherLayoutFlat is the layout used by the [DirCacher], where each
+	// cache is stored at its name.
+	DirCacherLayoutFlat DirCacherLayout = 1
+
+	// DirCacherLayoutSharded is the layout used by the [ShardedDirCacher],
+	// where each cache is stored at its name under two levels of shard
+	// directories derived from the SHA-256 of the directory of its name.
+	DirCacherLayoutSharded DirCacherLayout = 2
+)
+
+// String implements the [fmt.Stringer].
+func (dcl DirCacherLayout) String() string {
+	switch dcl {
+	case DirCacherLayoutFlat:
+		return "flat"
+	case DirCacherLayoutSharded:
+		return "sharded"
+	}
+
+	return fmt.Sprintf("unknown layout %d", int(dcl))
+}
+
+// cachePath returns the slash-separated path of the cache for the name in the
+// dcl.
+func (dcl DirCacherLayout) cachePath(name string) string {
+	if dcl == DirCacherLayoutSharded {
+		sum := sha256.Sum256([]byte(path.Dir(name)))
+		shard := hex.EncodeToString(sum[:2])
+		return path.Join(shard[:2], shard[2:], name)
+	}
+
+	return name
+}
+
+// parseCachePath returns the name and layout of the cache at the
+// slash-separated cachePath.
+func parseCachePath(cachePath string) (string, DirCacherLayout) {
+	parts := strings.SplitN(cachePath, "/", 3)
+	if len(parts) == 3 &&
+		DirCacherLayoutSharded.cachePath(parts[2]) == cachePath {
+		return parts[2], DirCacherLayoutSharded
+	}
+
+	return cachePath, DirCacherLayoutFlat
+}
+
+// ShardedDirCacher implements the [Cacher] using a directory on the local disk
+// with the [DirCacherLayoutSharded], which keeps the number of entries in each
+// directory small. If the directory does not exist, it will be created with
+// 0750 permissions.
+//
+// Use the [MigrateDirCacher] to convert a directory used by the [DirCacher].
+type ShardedDirCacher string
+
+// Get implements the [Cacher].
+func (sdc ShardedDirCacher) Get(
+	ctx context.Context,
+	name string,
+) (io.ReadCloser, error) {
+	return DirCacher(sdc).Get(ctx, DirCacherLayoutSharded.cachePath(name))
+}
+
+// Put implements the [Cacher].
+func (sdc ShardedDirCacher) Put(
+	ctx context.Context,
+	name string,
+	content io.ReadSeeker,
+	expiration time.Duration,
+) error {
+	return DirCacher(sdc).Put(
+		ctx,
+		DirCacherLayoutSharded.cachePath(name),
+		content,
+		expiration,
+	)
+}
+
+// Delete implements the [Deleter].
+func (sdc ShardedDirCacher) Delete(ctx context.Context, name string) error {
+	return DirCacher(sdc).Delete(ctx, DirCacherLayoutSharded.cachePath(name))
+}
+
+// Cleanup implements the [Cacher].
+func (sdc ShardedDirCacher) Cleanup() error {
+	return sdc.CleanupReclaimed(nil)
+}
+
+// CleanupReclaimed implements the [Reclaimer].
+func (sdc ShardedDirCacher) CleanupReclaimed(
+	reclaimed func(name string, size int64),
+) error {
+	return sdc.CleanupWithOptions(DirCacherCleanupOptions{}, reclaimed)
+}
+
+// CleanupWithOptions is like the [ShardedDirCacher.CleanupReclaimed], but with
+// the opts.
+func (sdc ShardedDirCacher) CleanupWithOptions(
+	opts DirCacherCleanupOptions,
+	reclaimed func(name string, size int64),
+) error {
+	if reclaimed == nil {
+		return DirCacher(sdc).CleanupWithOptions(opts, nil)
+	}
+
+	return DirCacher(sdc).CleanupWithOptions(
+		opts,
+		func(cachePath string, size int64) {
+			name, _ := parseCachePath(cachePath)
+			reclaimed(name, size)
+		},
+	)
+}
+
+// List implements the [Lister].
+func (sdc ShardedDirCacher) List(
+	ctx context.Context,
+	prefix string,
+) CacheIterator {
+	return listCaches(ctx, sdc.walkCaches, prefix)
+}
+
+// walkCaches implements the [cacheWalker].
+func (sdc ShardedDirCacher) walkCaches(
+	fn func(name string, size int64) error,
+) error {
+	return DirCacher(sdc).walkCaches(func(cachePath string, size int64) error {
+		name, _ := parseCachePath(cachePath)
+		return fn(name, size)
+	})
+}
+
+// ReadDirCacherLayout returns the [DirCacherLayout] of the cache directory
+// targeted by the dir. It returns zero if the dir does not exist or is empty
+// (apart from entries whose names start with "."), and [DirCacherLayoutFlat] if
+// the dir was populated before its layout was recorded.
+func ReadDirCacherLayout(dir string) (DirCacherLayout, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, dirCacherLayoutFileName))
+	if err == nil {
+		layout, err := strconv.Atoi(strings.TrimSpace(string(b)))
+		if err != nil {
+			return 0, fmt.Errorf("invalid cache layout file: %w", err)
+		}
+
+		return DirCacherLayout(layout), nil
+	} else if !os.IsNotExist(err) {
+		return 0, err
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+
+		return 0, err
+	}
+	defer d.Close()
+
+	names, err := d.Readdirnames(-1)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, name := range names {
+		if !strings.HasPrefix(name, ".") {
+			return DirCacherLayoutFlat, nil
+		}
+	}
+
+	return 0, nil // Empty, apart from temporary files
+}
+
+// MigrateDirCacher upgrades the cache directory targeted by the dir in place to
+// the layout by moving each cache file to where the layout expects it, keeping
+// its expiration. The layout is recorded in the dir once done, so an
+// interrupted migration can simply be run again.
+//
+// The dir must not be in use by any [Cacher] during the migration.
+func MigrateDirCacher(dir string, layout DirCacherLayout) error {
+	switch layout {
+	case DirCacherLayoutFlat, DirCacherLayoutSharded:
+	default:
+		return fmt.Errorf("invalid cache layout: %v", layout)
+	}
+
+	if current, err := ReadDirCacherLayout(dir); err != nil {
+		return err
+	} else if current == layout {
+		return nil
+	}
+
+	var subdirs []string
+	if err := filepath.Walk(dir, func(
+		filePath string,
+		fi os.FileInfo,
+		err error,
+	) error {
+		if err != nil {
+			if os.IsNotExist(err) && filePath == dir {
+				return filepath.SkipDir
+			}
+
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+
+		if rel == "." {
+			return nil
+		} else if fi.IsDir() && strings.HasPrefix(fi.Name(), ".") {
+			return filepath.SkipDir // Temporary directory
+		} else if fi.IsDir() {
+			subdirs = append(subdirs, filePath)
+			return nil
+		} else if strings.HasPrefix(fi.Name(), ".") {
+			return nil // Layout file or temporary file
+		}
+
+		name, current := parseCachePath(filepath.ToSlash(rel))
+		if current == layout {
+			return nil
+		}
+
+		newFilePath := filepath.Join(
+			dir,
+			filepath.FromSlash(layout.cachePath(name)),
+		)
+		if err := os.MkdirAll(filepath.Dir(newFilePath), 0750); err != nil {
+			return err
+		}
+
+		if err := os.Rename(
+			dirCacheMetadataPath(filePath),
+			dirCacheMetadataPath(newFilePath),
+		); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		return os.Rename(filePath, newFilePath)
+	}); err != nil {
+		return err
+	}
+
+	// Remove the directories emptied by the migration, deepest first.
+	// Non-empty ones fail to be removed and are left as they are.
+	sort.Slice(subdirs, func(i, j int) bool {
+		return len(subdirs[i]) > len(subdirs[j])
+	})
+	for _, subdir := range subdirs {
+		os.Remove(subdir)
+	}
+
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(
+		filepath.Join(dir, dirCacherLayoutFileName),
+		[]byte(fmt.Sprintln(int(layout))),
+		0640,
+	)
+}
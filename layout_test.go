@@ -0,0 +1,342 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCachePath(t *testing.T) {
+	for _, name := range []string{
+		"example.com/@v/v1.0.0.zip",
+		"example.com/foo/bar/@v/list",
+		"sumdb/sum.golang.org/supported",
+	} {
+		for _, layout := range []DirCacherLayout{
+			DirCacherLayoutFlat,
+			DirCacherLayoutSharded,
+		} {
+			gotName, gotLayout := parseCachePath(layout.cachePath(name))
+			if gotName != name {
+				t.Errorf(
+					"%s (%s): got %q, want %q",
+					name,
+					layout,
+					gotName,
+					name,
+				)
+			}
+
+			if gotLayout != layout {
+				t.Errorf(
+					"%s (%s): got %s, want %s",
+					name,
+					layout,
+					gotLayout,
+					layout,
+				)
+			}
+		}
+	}
+
+	cachePath := DirCacherLayoutSharded.cachePath("example.com/@v/list")
+	if got, want := strings.Count(cachePath, "/"), 4; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
+
+func TestShardedDirCacher(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestShardedDirCacher")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	sdc := ShardedDirCacher(tempDir)
+	name := "example.com/@v/v1.0.0.info"
+	if err := sdc.Put(
+		context.Background(),
+		name,
+		strings.NewReader("foobar"),
+		-time.Hour,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(
+		tempDir,
+		filepath.FromSlash(DirCacherLayoutSharded.cachePath(name)),
+	)); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	var reclaimed []string
+	if err := sdc.CleanupReclaimed(func(name string, size int64) {
+		reclaimed = append(reclaimed, name)
+	}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if got, want := strings.Join(reclaimed, ","), name; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if err := sdc.Put(
+		context.Background(),
+		name,
+		strings.NewReader("foobar"),
+		time.Hour,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	rc, err := sdc.Get(context.Background(), name)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if b, err := ioutil.ReadAll(rc); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if want := "foobar"; string(b) != want {
+		t.Errorf("got %q, want %q", b, want)
+	}
+
+	if err := rc.Close(); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if err := sdc.Delete(context.Background(), name); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if _, err := sdc.Get(
+		context.Background(),
+		name,
+	); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got error %q, want error %q", err, os.ErrNotExist)
+	}
+}
+
+func TestReadDirCacherLayout(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestReadDirCacherLayout")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	if got, err := ReadDirCacherLayout(filepath.Join(
+		tempDir,
+		"nonexistent",
+	)); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if want := DirCacherLayout(0); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	if got, err := ReadDirCacherLayout(tempDir); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if want := DirCacherLayout(0); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	if err := os.Mkdir(filepath.Join(tempDir, ".tmp"), 0750); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if got, err := ReadDirCacherLayout(tempDir); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if want := DirCacherLayout(0); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	if err := DirCacher(tempDir).Put(
+		context.Background(),
+		"example.com/@v/list",
+		strings.NewReader("v1.0.0"),
+		time.Hour,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if got, err := ReadDirCacherLayout(tempDir); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if want := DirCacherLayoutFlat; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	layoutFile := filepath.Join(tempDir, dirCacherLayoutFileName)
+	if err := ioutil.WriteFile(layoutFile, []byte("2\n"), 0600); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if got, err := ReadDirCacherLayout(tempDir); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if want := DirCacherLayoutSharded; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	if err := ioutil.WriteFile(layoutFile, []byte("foo"), 0600); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if _, err := ReadDirCacherLayout(tempDir); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestMigrateDirCacher(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestMigrateDirCacher")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	names := []string{
+		"example.com/@v/list",
+		"example.com/@v/v1.0.0.info",
+		"example.com/foo/@v/v1.0.0.mod",
+		"sumdb/sum.golang.org/supported",
+	}
+	for _, name := range names {
+		if err := DirCacher(tempDir).Put(
+			context.Background(),
+			name,
+			strings.NewReader(name),
+			time.Hour,
+		); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	meta, err := readDirCacheMetadata(filepath.Join(
+		tempDir,
+		filepath.FromSlash(names[0]),
+	))
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	expiration := meta.Expires
+
+	// Simulate an interrupted migration by migrating one file beforehand.
+	filePath := filepath.Join(tempDir, filepath.FromSlash(names[1]))
+	newFilePath := filepath.Join(
+		tempDir,
+		filepath.FromSlash(DirCacherLayoutSharded.cachePath(names[1])),
+	)
+	if err := os.MkdirAll(filepath.Dir(newFilePath), 0750); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if err := os.Rename(
+		dirCacheMetadataPath(filePath),
+		dirCacheMetadataPath(newFilePath),
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if err := os.Rename(filePath, newFilePath); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	for _, layout := range []DirCacherLayout{
+		DirCacherLayoutSharded,
+		DirCacherLayoutFlat,
+		DirCacherLayoutSharded,
+	} {
+		if err := MigrateDirCacher(tempDir, layout); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		if got, err := ReadDirCacherLayout(tempDir); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		} else if got != layout {
+			t.Errorf("got %s, want %s", got, layout)
+		}
+
+		var cacher Cacher = DirCacher(tempDir)
+		if layout == DirCacherLayoutSharded {
+			cacher = ShardedDirCacher(tempDir)
+		}
+
+		for _, name := range names {
+			rc, err := cacher.Get(context.Background(), name)
+			if err != nil {
+				t.Fatalf("unexpected error %q", err)
+			}
+
+			if b, err := ioutil.ReadAll(rc); err != nil {
+				t.Fatalf("unexpected error %q", err)
+			} else if got := string(b); got != name {
+				t.Errorf(
+					"%s (%s): got %q, want %q",
+					name,
+					layout,
+					got,
+					name,
+				)
+			}
+
+			rc.Close()
+		}
+
+		var files []string
+		if err := filepath.Walk(tempDir, func(
+			filePath string,
+			fi os.FileInfo,
+			err error,
+		) error {
+			if err == nil && !fi.IsDir() {
+				files = append(files, filePath)
+			}
+
+			return err
+		}); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		// Each cache file has a sidecar file, plus the layout file.
+		if got, want := len(files), 2*len(names)+1; got != want {
+			sort.Strings(files)
+			t.Errorf(
+				"%s: got %d files %v, want %d",
+				layout,
+				got,
+				files,
+				want,
+			)
+		}
+	}
+
+	meta, err = readDirCacheMetadata(filepath.Join(
+		tempDir,
+		filepath.FromSlash(DirCacherLayoutSharded.cachePath(names[0])),
+	))
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if meta == nil {
+		t.Fatal("expected cache metadata")
+	}
+
+	if got, want := meta.Expires, expiration; !got.Equal(want) {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	if err := ShardedDirCacher(tempDir).Cleanup(); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if got, err := ReadDirCacherLayout(tempDir); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if want := DirCacherLayoutSharded; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	if err := MigrateDirCacher(tempDir, 0); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,311 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Lister is the interface that a [Cacher] can optionally implement to
+// enumerate its caches, so that features like inventories, integrity scrubbing,
+// exports, quotas, retention policies and cleanups (see the [CleanupListed])
+// work without backend-specific code.
+type Lister interface {
+	// List returns a [CacheIterator] over the caches whose names have the
+	// prefix, in no particular order. An empty prefix lists all caches.
+	List(ctx context.Context, prefix string) CacheIterator
+}
+
+// CacheIterator iterates over the caches listed by a [Lister]. It must be
+// closed after use, even if the iteration has not been finished.
+//
+// Typical usage:
+//
+//	it := l.List(ctx, prefix)
+//	defer it.Close()
+//	for it.Next() {
+//		ci := it.Cache()
+//		// ...
+//	}
+//
+//	if err := it.Err(); err != nil {
+//		// ...
+//	}
+type CacheIterator interface {
+	// Next advances to the next cache and reports whether there is one.
+	Next() bool
+
+	// Cache returns the cache that the last call to the Next advanced to.
+	Cache() CacheInfo
+
+	// Err returns the error, if any, that stopped the iteration.
+	Err() error
+
+	// Close stops the iteration and releases the resources held by it.
+	Close() error
+}
+
+// CacheInfo describes a cache listed by a [Lister].
+type CacheInfo struct {
+	// Name is the name of the cache.
+	Name string
+
+	// Size is the size of the cache in bytes.
+	Size int64
+}
+
+// walkCacheIterator implements the [CacheIterator] on top of a walk function
+// like the [cacheWalker.walkCaches], running it in a separate goroutine.
+type walkCacheIterator struct {
+	caches  chan CacheInfo
+	cancel  context.CancelFunc
+	cache   CacheInfo
+	walkErr error
+	err     error
+}
+
+// listCaches returns a [CacheIterator] over the caches enumerated by the walk
+// whose names have the prefix.
+func listCaches(
+	ctx context.Context,
+	walk func(fn func(name string, size int64) error) error,
+	prefix string,
+) CacheIterator {
+	ctx, cancel := context.WithCancel(ctx)
+	it := &walkCacheIterator{
+		caches: make(chan CacheInfo),
+		cancel: cancel,
+	}
+
+	go func() {
+		defer close(it.caches)
+		it.walkErr = walk(func(name string, size int64) error {
+			if !strings.HasPrefix(name, prefix) {
+				return ctx.Err()
+			}
+
+			select {
+			case it.caches <- CacheInfo{Name: name, Size: size}:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+
+	return it
+}
+
+// Next implements the [CacheIterator].
+func (it *walkCacheIterator) Next() bool {
+	ci, ok := <-it.caches
+	if !ok {
+		it.err = it.walkErr
+		return false
+	}
+
+	it.cache = ci
+	return true
+}
+
+// Cache implements the [CacheIterator].
+func (it *walkCacheIterator) Cache() CacheInfo {
+	return it.cache
+}
+
+// Err implements the [CacheIterator].
+func (it *walkCacheIterator) Err() error {
+	return it.err
+}
+
+// Close implements the [CacheIterator].
+func (it *walkCacheIterator) Close() error {
+	it.cancel()
+	for range it.caches {
+	}
+
+	return nil
+}
+
+// errCacheIterator is a [CacheIterator] that only fails with its error.
+type errCacheIterator struct{ err error }
+
+// Next implements the [CacheIterator].
+func (it errCacheIterator) Next() bool { return false }
+
+// Cache implements the [CacheIterator].
+func (it errCacheIterator) Cache() CacheInfo { return CacheInfo{} }
+
+// Err implements the [CacheIterator].
+func (it errCacheIterator) Err() error { return it.err }
+
+// Close implements the [CacheIterator].
+func (it errCacheIterator) Close() error { return nil }
+
+// listCacher returns a [CacheIterator] over the caches of the cacher whose
+// names have the prefix. It fails with the errWalkNotSupported if the cacher
+// does not implement the [Lister].
+func listCacher(
+	ctx context.Context,
+	cacher Cacher,
+	prefix string,
+) CacheIterator {
+	l, ok := cacher.(Lister)
+	if !ok {
+		return errCacheIterator{errWalkNotSupported}
+	}
+
+	return l.List(ctx, prefix)
+}
+
+// cacheWalkerOf returns the [cacheWalker] of the cacher. If the cacher does
+// not implement the cacheWalker but the [Lister], its caches are walked via the
+// Lister, so that cachers implemented outside this package can be enumerated by
+// every feature that needs it.
+func cacheWalkerOf(cacher Cacher) (cacheWalker, bool) {
+	if cw, ok := cacher.(cacheWalker); ok {
+		return cw, true
+	}
+
+	if l, ok := cacher.(Lister); ok {
+		return listerWalker{l}, true
+	}
+
+	return nil, false
+}
+
+// listerWalker implements the [cacheWalker] on top of a [Lister].
+type listerWalker struct{ l Lister }
+
+// walkCaches implements the [cacheWalker].
+func (lw listerWalker) walkCaches(
+	fn func(name string, size int64) error,
+) error {
+	it := lw.l.List(context.Background(), "")
+	defer it.Close()
+	for it.Next() {
+		ci := it.Cache()
+		if err := fn(ci.Name, ci.Size); err != nil {
+			return err
+		}
+	}
+
+	return it.Err()
+}
+
+// CleanupListed removes the expired caches from the cacher by enumerating them,
+// which requires the cacher to implement the [Lister] and the [Deleter]. It is
+// meant for the cachers whose own cleanup does not remove anything, e.g. those
+// implemented outside this package on top of object stores.
+//
+// A cache is considered expired if the cacher no longer gets it or if its
+// content has an Expires method reporting a time in the past. The reclaimed,
+// if not nil, is called with the name and size of each removed cache.
+//
+// A cache put again between being found expired and being removed is removed
+// as well, so the CleanupListed is best run when the cacher is idle.
+func CleanupListed(
+	ctx context.Context,
+	cacher Cacher,
+	reclaimed func(name string, size int64),
+) error {
+	d, ok := cacher.(Deleter)
+	if !ok {
+		return errDeleteNotSupported
+	}
+
+	var expired []CacheInfo
+	it := listCacher(ctx, cacher, "")
+	defer it.Close()
+	for it.Next() {
+		ci := it.Cache()
+		content, err := cacher.Get(ctx, ci.Name)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+
+			expired = append(expired, ci)
+			continue
+		}
+
+		ec, ok := content.(interface{ Expires() time.Time })
+		if ok && !ec.Expires().IsZero() &&
+			!ec.Expires().After(time.Now()) {
+			expired = append(expired, ci)
+		}
+
+		content.Close()
+	}
+
+	if err := it.Err(); err != nil {
+		return err
+	}
+
+	it.Close()
+	for _, ci := range expired {
+		if err := d.Delete(ctx, ci.Name); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+
+			return err
+		}
+
+		if reclaimed != nil {
+			reclaimed(ci.Name, ci.Size)
+		}
+	}
+
+	return nil
+}
+
+// serveCaches serves cache inventory requests. The "prefix" query parameter
+// restricts the caches to those whose names have it, and the "limit" one caps
+// the number of caches listed (1000 by default).
+func (g *Goproxy) serveCaches(rw http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	limit := 1000
+	if s := query.Get("limit"); s != "" {
+		var err error
+		if limit, err = strconv.Atoi(s); err != nil || limit <= 0 {
+			responseString(
+				rw,
+				req,
+				http.StatusBadRequest,
+				-2,
+				"invalid limit",
+			)
+			return
+		}
+	}
+
+	r := struct {
+		Caches    []CacheInfo
+		Truncated bool
+	}{
+		Caches: []CacheInfo{},
+	}
+
+	it := listCacher(req.Context(), g.Cacher, query.Get("prefix"))
+	defer it.Close()
+	for it.Next() {
+		if len(r.Caches) == limit {
+			r.Truncated = true
+			break
+		}
+
+		r.Caches = append(r.Caches, it.Cache())
+	}
+
+	if err := it.Err(); err != nil {
+		g.serveAdminError(rw, req, "list caches", err)
+		return
+	}
+
+	responseJSON(rw, req, -2, r)
+}
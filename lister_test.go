@@ -0,0 +1,426 @@
+package goproxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestListers(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestListers")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	for n, l := range []Lister{
+		DirCacher(filepath.Join(tempDir, "dir")),
+		ShardedDirCacher(filepath.Join(tempDir, "sharded")),
+		&MemoryCacher{},
+		&RetentionCacher{Cacher: &MemoryCacher{}},
+	} {
+		c := l.(Cacher)
+		for _, name := range []string{
+			"a/b/@v/list",
+			"a/b/@v/v1.0.0.info",
+			"a/bc/@v/list",
+			"d/@v/list",
+		} {
+			if err := c.Put(
+				context.Background(),
+				name,
+				strings.NewReader(name),
+				time.Minute,
+			); err != nil {
+				t.Fatalf("test(%d): unexpected error %q",
+					n, err)
+			}
+		}
+
+		for _, tt := range []struct {
+			prefix string
+			want   string
+		}{
+			{
+				"",
+				"a/b/@v/list a/b/@v/v1.0.0.info " +
+					"a/bc/@v/list d/@v/list",
+			},
+			{
+				"a/b",
+				"a/b/@v/list a/b/@v/v1.0.0.info a/bc/@v/list",
+			},
+			{"a/b/", "a/b/@v/list a/b/@v/v1.0.0.info"},
+			{"e/", ""},
+		} {
+			var names []string
+			it := l.List(context.Background(), tt.prefix)
+			for it.Next() {
+				ci := it.Cache()
+				if got, want := ci.Size,
+					int64(len(ci.Name)); got != want {
+					t.Errorf("test(%d): got %d, want %d",
+						n, got, want)
+				}
+
+				names = append(names, ci.Name)
+			}
+
+			if err := it.Err(); err != nil {
+				t.Fatalf("test(%d): unexpected error %q",
+					n, err)
+			} else if err := it.Close(); err != nil {
+				t.Fatalf("test(%d): unexpected error %q",
+					n, err)
+			}
+
+			sort.Strings(names)
+			if got := strings.Join(names, " "); got != tt.want {
+				t.Errorf("test(%d): got %q, want %q",
+					n, got, tt.want)
+			}
+		}
+
+		// Closing an unfinished iteration stops it.
+		it := l.List(context.Background(), "")
+		if !it.Next() {
+			t.Fatalf("test(%d): expected cache", n)
+		} else if err := it.Close(); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", n, err)
+		} else if it.Next() {
+			t.Errorf("test(%d): unexpected cache", n)
+		}
+	}
+
+	it := (&RetentionCacher{Cacher: &errorCacher{}}).List(
+		context.Background(),
+		"",
+	)
+	defer it.Close()
+	if it.Next() {
+		t.Error("unexpected cache")
+	} else if got, want := it.Err(),
+		errWalkNotSupported; !errors.Is(got, want) {
+		t.Errorf("got error %q, want error %q", got, want)
+	}
+}
+
+func TestGoproxyServeCaches(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestGoproxyServeCaches")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	g := &Goproxy{
+		Cacher:          DirCacher(tempDir),
+		AdminAuthorizer: func(*http.Request) bool { return true },
+	}
+	for _, name := range []string{
+		"a/@v/list",
+		"a/@v/v1.0.0.info",
+		"b/@v/list",
+	} {
+		if err := g.Cacher.Put(
+			context.Background(),
+			name,
+			strings.NewReader(name),
+			time.Minute,
+		); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	for _, tt := range []struct {
+		n             int
+		query         string
+		wantCode      int
+		wantNames     string
+		wantTruncated bool
+	}{
+		{
+			n:         1,
+			wantCode:  http.StatusOK,
+			wantNames: "a/@v/list a/@v/v1.0.0.info b/@v/list",
+		},
+		{
+			n:         2,
+			query:     "?prefix=a/",
+			wantCode:  http.StatusOK,
+			wantNames: "a/@v/list a/@v/v1.0.0.info",
+		},
+		{
+			n:             3,
+			query:         "?limit=1",
+			wantCode:      http.StatusOK,
+			wantTruncated: true,
+		},
+		{
+			n:        4,
+			query:    "?limit=0",
+			wantCode: http.StatusBadRequest,
+		},
+	} {
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest(
+			http.MethodGet,
+			"/-/caches"+tt.query,
+			nil,
+		))
+		if got, want := rec.Code, tt.wantCode; got != want {
+			t.Fatalf("test(%d): got %d, want %d", tt.n, got, want)
+		} else if rec.Code != http.StatusOK {
+			continue
+		}
+
+		var r struct {
+			Caches    []CacheInfo
+			Truncated bool
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &r); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		} else if got, want := r.Truncated,
+			tt.wantTruncated; got != want {
+			t.Errorf("test(%d): got %t, want %t", tt.n, got, want)
+		} else if r.Truncated {
+			if got, want := len(r.Caches), 1; got != want {
+				t.Errorf("test(%d): got %d, want %d",
+					tt.n, got, want)
+			}
+
+			continue
+		}
+
+		names := make([]string, 0, len(r.Caches))
+		for _, ci := range r.Caches {
+			names = append(names, ci.Name)
+		}
+
+		sort.Strings(names)
+		if got := strings.Join(names, " "); got != tt.wantNames {
+			t.Errorf("test(%d): got %q, want %q",
+				tt.n, got, tt.wantNames)
+		}
+	}
+
+	g = &Goproxy{
+		Cacher:          &errorCacher{},
+		AdminAuthorizer: g.AdminAuthorizer,
+	}
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/-/caches", nil))
+	if got, want := rec.Code, http.StatusNotImplemented; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
+
+// objectStoreCacher is a [Cacher] that only implements the exported [Lister]
+// and [Deleter], like those implemented outside this package. Like many object
+// stores, it keeps expired caches until they are deleted.
+type objectStoreCacher struct {
+	mutex   sync.Mutex
+	objects map[string]*memoryEntry
+}
+
+// Get implements the [Cacher].
+func (osc *objectStoreCacher) Get(
+	ctx context.Context,
+	name string,
+) (io.ReadCloser, error) {
+	osc.mutex.Lock()
+	defer osc.mutex.Unlock()
+	e, ok := osc.objects[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	return &memoryContent{
+		Reader:  bytes.NewReader(e.content),
+		modTime: e.modTime,
+		expires: e.expires,
+	}, nil
+}
+
+// Put implements the [Cacher].
+func (osc *objectStoreCacher) Put(
+	ctx context.Context,
+	name string,
+	content io.ReadSeeker,
+	expiration time.Duration,
+) error {
+	b, err := ioutil.ReadAll(content)
+	if err != nil {
+		return err
+	}
+
+	osc.mutex.Lock()
+	defer osc.mutex.Unlock()
+	if osc.objects == nil {
+		osc.objects = map[string]*memoryEntry{}
+	}
+
+	now := time.Now()
+	osc.objects[name] = &memoryEntry{
+		content: b,
+		modTime: now,
+		expires: now.Add(expiration),
+	}
+
+	return nil
+}
+
+// Delete implements the [Deleter].
+func (osc *objectStoreCacher) Delete(ctx context.Context, name string) error {
+	osc.mutex.Lock()
+	defer osc.mutex.Unlock()
+	if _, ok := osc.objects[name]; !ok {
+		return os.ErrNotExist
+	}
+
+	delete(osc.objects, name)
+
+	return nil
+}
+
+// Cleanup implements the [Cacher].
+func (osc *objectStoreCacher) Cleanup() error {
+	return nil
+}
+
+// List implements the [Lister].
+func (osc *objectStoreCacher) List(
+	ctx context.Context,
+	prefix string,
+) CacheIterator {
+	osc.mutex.Lock()
+	defer osc.mutex.Unlock()
+	var caches []CacheInfo
+	for name, e := range osc.objects {
+		if strings.HasPrefix(name, prefix) {
+			caches = append(caches, CacheInfo{
+				Name: name,
+				Size: int64(len(e.content)),
+			})
+		}
+	}
+
+	return listCaches(ctx, func(fn func(string, int64) error) error {
+		for _, ci := range caches {
+			if err := fn(ci.Name, ci.Size); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}, prefix)
+}
+
+func TestCacheWalkerOf(t *testing.T) {
+	osc := &objectStoreCacher{}
+	for _, name := range []string{"a/@v/list", "b/@v/list"} {
+		if err := osc.Put(
+			context.Background(),
+			name,
+			strings.NewReader(name),
+			time.Minute,
+		); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	for n, c := range []Cacher{
+		osc,
+		&RetentionCacher{Cacher: osc},
+		&QuotaCacher{Cacher: osc},
+	} {
+		cw, ok := cacheWalkerOf(c)
+		if !ok {
+			t.Fatalf("test(%d): expected cacheWalker", n)
+		}
+
+		names, err := cacheNamesWithPrefix(cw, "")
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", n, err)
+		}
+
+		sort.Strings(names)
+		if got, want := strings.Join(names, " "),
+			"a/@v/list b/@v/list"; got != want {
+			t.Errorf("test(%d): got %q, want %q", n, got, want)
+		}
+	}
+
+	if _, ok := cacheWalkerOf(&errorCacher{}); ok {
+		t.Error("unexpected cacheWalker")
+	}
+}
+
+func TestCleanupListed(t *testing.T) {
+	osc := &objectStoreCacher{}
+	for _, tt := range []struct {
+		name       string
+		expiration time.Duration
+	}{
+		{"a/@v/list", time.Minute},
+		{"b/@v/list", -time.Minute},
+		{"c/@v/v1.0.0.info", -time.Minute},
+	} {
+		if err := osc.Put(
+			context.Background(),
+			tt.name,
+			strings.NewReader(tt.name),
+			tt.expiration,
+		); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	var reclaimed []string
+	var reclaimedBytes int64
+	if err := CleanupListed(
+		context.Background(),
+		osc,
+		func(name string, size int64) {
+			reclaimed = append(reclaimed, name)
+			reclaimedBytes += size
+		},
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	sort.Strings(reclaimed)
+	if got, want := strings.Join(reclaimed, " "),
+		"b/@v/list c/@v/v1.0.0.info"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if got, want := reclaimedBytes, int64(25); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	if got, want := len(osc.objects), 1; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	} else if _, ok := osc.objects["a/@v/list"]; !ok {
+		t.Error("expected a/@v/list")
+	}
+
+	if err := CleanupListed(
+		context.Background(),
+		&errorCacher{},
+		nil,
+	); err == nil {
+		t.Fatal("expected error")
+	}
+}
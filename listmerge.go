@@ -0,0 +1,188 @@
+package goproxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+const (
+	// listProvenanceNamePrefix is the prefix of the names of the caches
+	// holding the [listProvenance] of the merged version lists.
+	listProvenanceNamePrefix = apiPathPrefix + "list-provenance/"
+
+	// listProvenanceCacheExpiration is the expiration of the caches
+	// holding the [listProvenance]. It is much longer than the one of the
+	// version lists, since it is what remembers the versions that
+	// upstreams have stopped listing.
+	listProvenanceCacheExpiration = 365 * 24 * time.Hour
+)
+
+// listProvenance maps each version in a merged version list to the upstreams
+// (proxy URLs or "direct") that have ever listed it. The versions only known
+// from the caches created before the merging was enabled have no upstreams.
+type listProvenance map[string][]string
+
+// add adds the versions listed by the source to the lp.
+func (lp listProvenance) add(source string, versions []string) {
+	for _, version := range versions {
+		if !stringSliceContains(lp[version], source) {
+			lp[version] = append(lp[version], source)
+		}
+	}
+}
+
+// versions returns the sorted versions in the lp.
+func (lp listProvenance) versions() []string {
+	versions := make([]string, 0, len(lp))
+	for version := range lp {
+		versions = append(versions, version)
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return semver.Compare(versions[i], versions[j]) < 0
+	})
+
+	return versions
+}
+
+// doMergeList executes the f, which must be a list, by merging the version
+// lists of all the proxies in the GOPROXY with the previously merged ones.
+// The "direct" is only consulted if none of the proxies succeeded. It fails
+// only if none of the upstreams succeeded.
+func (f *fetch) doMergeList(ctx context.Context) (*fetchResult, error) {
+	lp := f.g.listProvenance(ctx, f.name)
+	if b, err := f.g.cacheBytes(ctx, f.name); err == nil {
+		for _, line := range strings.Split(string(b), "\n") {
+			if _, ok := lp[line]; !ok && semver.IsValid(line) &&
+				!module.IsPseudoVersion(line) {
+				lp[line] = []string{}
+			}
+		}
+	}
+
+	var (
+		succeeded bool
+		lastErr   error
+		direct    bool
+	)
+	for _, proxy := range strings.FieldsFunc(
+		f.g.goBinEnvGOPROXY,
+		func(r rune) bool { return r == ',' || r == '|' },
+	) {
+		switch proxy {
+		case "direct":
+			direct = true
+			continue
+		case "off":
+			continue
+		}
+
+		r, err := f.doProxy(ctx, proxy)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		lp.add(redactedUpstream(proxy), r.Versions)
+		succeeded = true
+	}
+
+	if !succeeded && direct {
+		r, err := f.doDirect(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		lp.add(redactedUpstream("direct"), r.Versions)
+		succeeded = true
+	}
+
+	if !succeeded {
+		if lastErr == nil {
+			// go/src/cmd/go/internal/modfetch.errProxyOff
+			lastErr = notFoundError(
+				"module lookup disabled by GOPROXY=off",
+			)
+		}
+
+		return nil, lastErr
+	}
+
+	if err := f.g.putListProvenance(ctx, f.name, lp); err != nil {
+		return nil, err
+	}
+
+	return &fetchResult{f: f, Versions: lp.versions()}, nil
+}
+
+// listProvenance returns the [listProvenance] of the merged version list for
+// the name. It returns an empty one if there is none.
+func (g *Goproxy) listProvenance(
+	ctx context.Context,
+	name string,
+) listProvenance {
+	lp := listProvenance{}
+	if b, err := g.cacheBytes(
+		ctx,
+		listProvenanceNamePrefix+name,
+	); err == nil {
+		if json.Unmarshal(b, &lp) != nil {
+			lp = listProvenance{}
+		}
+	}
+
+	return lp
+}
+
+// putListProvenance puts the lp of the merged version list for the name to the
+// g.Cacher.
+func (g *Goproxy) putListProvenance(
+	ctx context.Context,
+	name string,
+	lp listProvenance,
+) error {
+	b, err := json.Marshal(lp)
+	if err != nil {
+		return err
+	}
+
+	return g.putCache(
+		ctx,
+		listProvenanceNamePrefix+name,
+		strings.NewReader(string(b)),
+		listProvenanceCacheExpiration,
+	)
+}
+
+// serveListProvenance serves list provenance requests. The module is taken
+// from the "module" query parameter.
+func (g *Goproxy) serveListProvenance(
+	rw http.ResponseWriter,
+	req *http.Request,
+) {
+	escapedModulePath, err := module.EscapePath(
+		req.URL.Query().Get("module"),
+	)
+	if err != nil {
+		responseNotFound(rw, req, -2, err)
+		return
+	}
+
+	name := fmt.Sprint(escapedModulePath, "/@v/list")
+	lp := g.listProvenance(req.Context(), name)
+	if len(lp) == 0 {
+		responseNotFound(rw, req, -2, errors.New("no merged list"))
+		return
+	}
+
+	responseJSON(rw, req, -2, lp)
+}
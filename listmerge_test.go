@@ -0,0 +1,108 @@
+package goproxy
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestGoproxyMergeLists(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestGoproxyMergeLists")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	newProxy := func(list *string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(
+			rw http.ResponseWriter,
+			req *http.Request,
+		) {
+			if req.URL.Path != "/example.com/@v/list" ||
+				*list == "" {
+				responseNotFound(rw, req, -2)
+				return
+			}
+
+			responseString(rw, req, http.StatusOK, -2, *list)
+		}))
+	}
+
+	list1 := "v1.0.0\nv1.1.0\n"
+	proxy1 := newProxy(&list1)
+	defer proxy1.Close()
+
+	list2 := "v1.1.0\nv1.2.0\n"
+	proxy2 := newProxy(&list2)
+	defer proxy2.Close()
+
+	g := &Goproxy{
+		Cacher: DirCacher(tempDir),
+		GoBinEnv: []string{
+			"GOPROXY=" + proxy1.URL + "," + proxy2.URL,
+			"GOSUMDB=off",
+		},
+		MergeLists:      true,
+		AdminAuthorizer: func(*http.Request) bool { return true },
+		ErrorLogger:     log.New(&discardWriter{}, "", 0),
+	}
+
+	get := func(path string) (int, string) {
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest("", path, nil))
+		return rec.Code, rec.Body.String()
+	}
+
+	if code, body := get("/example.com/@v/list"); code != http.StatusOK {
+		t.Fatalf("got %d, want %d", code, http.StatusOK)
+	} else if got, want := body, "v1.0.0\nv1.1.0\nv1.2.0"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// The versions that upstreams have stopped listing are kept.
+	list1 = "v1.3.0\n"
+	list2 = ""
+	if code, body := get("/example.com/@v/list"); code != http.StatusOK {
+		t.Fatalf("got %d, want %d", code, http.StatusOK)
+	} else if got, want := body,
+		"v1.0.0\nv1.1.0\nv1.2.0\nv1.3.0"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	code, body := get("/-/list-provenance?module=example.com")
+	if code != http.StatusOK {
+		t.Fatalf("got %d, want %d", code, http.StatusOK)
+	}
+
+	var lp listProvenance
+	if err := json.Unmarshal([]byte(body), &lp); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	for version, want := range map[string]string{
+		"v1.0.0": proxy1.URL,
+		"v1.1.0": proxy1.URL + "," + proxy2.URL,
+		"v1.2.0": proxy2.URL,
+		"v1.3.0": proxy1.URL,
+	} {
+		if got := strings.Join(lp[version], ","); got != want {
+			t.Errorf("%s: got %q, want %q", version, got, want)
+		}
+	}
+
+	if code, _ := get(
+		"/-/list-provenance?module=example.com/unknown",
+	); code != http.StatusNotFound {
+		t.Errorf("got %d, want %d", code, http.StatusNotFound)
+	}
+
+	list1 = ""
+	if code, _ := get("/example.com/@v/list"); code != http.StatusOK {
+		t.Errorf("got %d, want %d", code, http.StatusOK)
+	}
+}
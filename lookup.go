@@ -0,0 +1,279 @@
+package goproxy
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+	"golang.org/x/mod/sumdb/dirhash"
+)
+
+const (
+	// maxLookupModules is the maximum number of module versions looked up
+	// by one batch lookup request.
+	maxLookupModules = 1000
+
+	// maxLookupBytes is the maximum number of bytes of the body of a batch
+	// lookup request.
+	maxLookupBytes = 1 << 20
+
+	// lookupParallelism is the maximum number of module versions looked up
+	// at the same time by one batch lookup request.
+	lookupParallelism = 8
+)
+
+// moduleLookup is the result of looking up a module version in the caches.
+type moduleLookup struct {
+	// Module is the module version in the form "path@version".
+	Module string
+
+	// Cached indicates whether the ".info", ".mod" and ".zip" files of the
+	// Module have all been cached.
+	Cached bool
+
+	// Time is the time in the cached ".info" file. It is zero if the
+	// ".info" file has not been cached.
+	Time time.Time `json:",omitempty"`
+
+	// GoModHash is the hash (see the [dirhash.Hash1]) of the cached ".mod"
+	// file, as found in go.sum files. It is empty if the ".mod" file has
+	// not been cached.
+	GoModHash string `json:",omitempty"`
+
+	// ZipHash is the hash (see the [dirhash.Hash1]) of the cached ".zip"
+	// file, as found in go.sum files. It is empty if the ".zip" file has
+	// not been cached.
+	ZipHash string `json:",omitempty"`
+
+	// ZipSize is the number of bytes of the cached ".zip" file.
+	ZipSize int64 `json:",omitempty"`
+
+	// Error is the error that occurred while looking up the Module.
+	Error string `json:",omitempty"`
+}
+
+// lookupModules looks up each of the modAtVers (in the form "path@version") in
+// the caches. Module versions not cached are never fetched, so that one request
+// cannot trigger thousands of fetches. The private modules (see the
+// [Goproxy.PrivateModules]) are only looked up if the privateAuthorized is
+// true.
+func (g *Goproxy) lookupModules(
+	ctx context.Context,
+	modAtVers []string,
+	privateAuthorized bool,
+) []*moduleLookup {
+	mls := make([]*moduleLookup, len(modAtVers))
+	sem := make(chan struct{}, lookupParallelism)
+	var wg sync.WaitGroup
+	for i, modAtVer := range modAtVers {
+		mls[i] = &moduleLookup{Module: modAtVer}
+		wg.Add(1)
+		go func(ml *moduleLookup) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			err := g.lookupModule(ctx, ml, privateAuthorized)
+			if err != nil {
+				ml.Error = err.Error()
+			}
+		}(mls[i])
+	}
+
+	wg.Wait()
+
+	return mls
+}
+
+// lookupModule fills the ml from the caches of its module version.
+func (g *Goproxy) lookupModule(
+	ctx context.Context,
+	ml *moduleLookup,
+	privateAuthorized bool,
+) error {
+	parts := strings.SplitN(ml.Module, "@", 2)
+	if len(parts) != 2 || !semver.IsValid(parts[1]) {
+		return errors.New("invalid module version")
+	}
+
+	modulePath, moduleVersion := parts[0], parts[1]
+	if g.isPrivateModule(modulePath) && !privateAuthorized {
+		return errors.New("unauthorized")
+	} else if checkModuleHost(
+		g.settings().BlockedModuleHosts,
+		modulePath,
+	) != nil {
+		return errors.New("forbidden")
+	}
+
+	escapedModulePath, err := module.EscapePath(modulePath)
+	if err != nil {
+		return err
+	}
+
+	escapedModuleVersion, err := module.EscapeVersion(moduleVersion)
+	if err != nil {
+		return err
+	}
+
+	nameBase := fmt.Sprint(
+		escapedModulePath,
+		"/@v/",
+		escapedModuleVersion,
+	)
+
+	ml.Cached = true
+	b, err := g.cacheBytes(ctx, nameBase+".info")
+	if err == nil {
+		if _, ml.Time, err = unmarshalInfo(string(b)); err != nil {
+			return err
+		}
+	} else if errors.Is(err, os.ErrNotExist) {
+		ml.Cached = false
+	} else {
+		return err
+	}
+
+	ml.GoModHash, err = dirhash.Hash1(
+		[]string{"go.mod"},
+		func(string) (io.ReadCloser, error) {
+			return g.cache(ctx, nameBase+".mod")
+		},
+	)
+	if errors.Is(err, os.ErrNotExist) {
+		ml.Cached = false
+	} else if err != nil {
+		return err
+	}
+
+	ml.ZipHash, ml.ZipSize, err = g.hashCachedZip(ctx, nameBase+".zip")
+	if errors.Is(err, os.ErrNotExist) {
+		ml.Cached = false
+	} else if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// hashCachedZip returns the hash (see the [dirhash.Hash1]) and the size of the
+// cached ".zip" file for the name. Caches that cannot be read at random are
+// copied into a temporary file first.
+func (g *Goproxy) hashCachedZip(
+	ctx context.Context,
+	name string,
+) (string, int64, error) {
+	content, err := g.cache(ctx, name)
+	if err != nil {
+		return "", 0, err
+	}
+	defer content.Close()
+
+	ras, ok := content.(readerAtSeeker)
+	if !ok {
+		tempFile, err := ioutil.TempFile(g.TempDir, "goproxy")
+		if err != nil {
+			return "", 0, err
+		}
+		defer os.Remove(tempFile.Name())
+		defer tempFile.Close()
+
+		if _, err := io.Copy(tempFile, content); err != nil {
+			return "", 0, err
+		}
+
+		ras = tempFile
+	}
+
+	return hashZip(ras)
+}
+
+// readerAtSeeker is the interface that groups the [io.ReaderAt] and the
+// [io.Seeker].
+type readerAtSeeker interface {
+	io.ReaderAt
+	io.Seeker
+}
+
+// hashZip returns the hash (see the [dirhash.Hash1]) and the size of the ".zip"
+// file read from the ras.
+func hashZip(ras readerAtSeeker) (string, int64, error) {
+	size, err := ras.Seek(0, io.SeekEnd)
+	if err != nil {
+		return "", 0, err
+	}
+
+	zr, err := zip.NewReader(ras, size)
+	if err != nil {
+		return "", 0, err
+	}
+
+	files := make([]string, 0, len(zr.File))
+	zipFiles := make(map[string]*zip.File, len(zr.File))
+	for _, file := range zr.File {
+		files = append(files, file.Name)
+		zipFiles[file.Name] = file
+	}
+
+	hash, err := dirhash.Hash1(
+		files,
+		func(name string) (io.ReadCloser, error) {
+			return zipFiles[name].Open()
+		},
+	)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return hash, size, nil
+}
+
+// serveLookup serves batch lookup requests. The module versions are taken from
+// the body, which is a JSON array of strings in the form "path@version".
+func (g *Goproxy) serveLookup(rw http.ResponseWriter, req *http.Request) {
+	var modAtVers []string
+	if err := json.NewDecoder(http.MaxBytesReader(
+		rw,
+		req.Body,
+		maxLookupBytes,
+	)).Decode(&modAtVers); err != nil {
+		responseString(
+			rw,
+			req,
+			http.StatusBadRequest,
+			-2,
+			fmt.Sprint("invalid lookup request: ", err),
+		)
+		return
+	}
+
+	if len(modAtVers) > maxLookupModules {
+		responseString(
+			rw,
+			req,
+			http.StatusRequestEntityTooLarge,
+			-2,
+			fmt.Sprintf(
+				"too many module versions (max %d)",
+				maxLookupModules,
+			),
+		)
+		return
+	}
+
+	responseJSON(rw, req, -2, g.lookupModules(
+		req.Context(),
+		modAtVers,
+		g.privateModulesAuthorized(req),
+	))
+}
@@ -0,0 +1,156 @@
+package goproxy
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/mod/sumdb/dirhash"
+)
+
+func TestGoproxyLookup(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestGoproxyLookup")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	zipFile := filepath.Join(tempDir, "module.zip")
+	var zipBuf bytes.Buffer
+	zw := zip.NewWriter(&zipBuf)
+	w, err := zw.Create("example.com@v1.0.0/go.mod")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if _, err := w.Write([]byte("module example.com")); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if err := zw.Close(); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	err = ioutil.WriteFile(zipFile, zipBuf.Bytes(), 0600)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	wantZipHash, err := dirhash.HashZip(zipFile, dirhash.Hash1)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	cacheDir := filepath.Join(tempDir, "caches")
+	g := &Goproxy{
+		GoBinEnv:           []string{"GOPROXY=off", "GOSUMDB=off"},
+		Cacher:             DirCacher(cacheDir),
+		TempDir:            tempDir,
+		PrivateModules:     "example.com/private",
+		ErrorLogger:        log.New(&discardWriter{}, "", 0),
+		BlockedModuleHosts: []string{"example.org"},
+	}
+
+	infoTime := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	for name, content := range map[string]string{
+		"example.com/@v/v1.0.0.info": marshalInfo("v1.0.0", infoTime),
+		"example.com/@v/v1.0.0.mod":  "module example.com",
+		"example.com/@v/v1.0.0.zip":  zipBuf.String(),
+		"example.com/@v/v1.1.0.mod":  "module example.com",
+		"example.org/@v/v1.0.0.mod":  "module example.org",
+	} {
+		if err := g.Cacher.Put(
+			context.Background(),
+			name,
+			strings.NewReader(content),
+			time.Hour,
+		); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	wantGoModHash, err := hashModFile(
+		filepath.Join(cacheDir, "example.com/@v/v1.0.0.mod"),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, httptest.NewRequest(
+		http.MethodPost,
+		"/-/lookup",
+		strings.NewReader(`[
+			"example.com@v1.0.0",
+			"example.com@v1.1.0",
+			"example.com@v2.0.0",
+			"example.com/private@v1.0.0",
+			"example.org@v1.0.0",
+			"example.com"
+		]`),
+	))
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	var mls []moduleLookup
+	if err := json.NewDecoder(rec.Body).Decode(&mls); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	want := []moduleLookup{
+		{
+			Module:    "example.com@v1.0.0",
+			Cached:    true,
+			Time:      infoTime,
+			GoModHash: wantGoModHash,
+			ZipHash:   wantZipHash,
+			ZipSize:   int64(zipBuf.Len()),
+		},
+		{
+			Module:    "example.com@v1.1.0",
+			GoModHash: wantGoModHash,
+		},
+		{Module: "example.com@v2.0.0"},
+		{Module: "example.com/private@v1.0.0", Error: "unauthorized"},
+		{Module: "example.org@v1.0.0", Error: "forbidden"},
+		{Module: "example.com", Error: "invalid module version"},
+	}
+	if len(mls) != len(want) {
+		t.Fatalf("got %+v, want %+v", mls, want)
+	}
+
+	for i := range want {
+		if got := mls[i]; got.Module != want[i].Module ||
+			got.Cached != want[i].Cached ||
+			!got.Time.Equal(want[i].Time) ||
+			got.GoModHash != want[i].GoModHash ||
+			got.ZipHash != want[i].ZipHash ||
+			got.ZipSize != want[i].ZipSize ||
+			got.Error != want[i].Error {
+			t.Errorf("got %+v, want %+v", got, want[i])
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	g.ServeHTTP(rec, httptest.NewRequest(
+		http.MethodPost,
+		"/-/lookup",
+		strings.NewReader("{"),
+	))
+	if got, want := rec.Code, http.StatusBadRequest; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	rec = httptest.NewRecorder()
+	g.ServeHTTP(rec, httptest.NewRequest("", "/-/lookup", nil))
+	if got, want := rec.Code, http.StatusMethodNotAllowed; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
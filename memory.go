@@ -0,0 +1,241 @@
+package goproxy
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// MemoryCacher implements the [Cacher] using the memory of the current process,
+// keeping the total size of its caches within a budget by evicting the least
+// recently used ones. It suits hot small caches, either standalone for
+// short-lived instances (e.g. on CI runners) or as the top tier of a layered
+// cache.
+//
+// Caches larger than the [MemoryCacher.MaxCacheBytes] (or the
+// [MemoryCacher.MaxBytes]) are silently not stored, so that a single large zip
+// file never flushes all the hot small caches.
+//
+// Make sure that all fields of the MemoryCacher have been finalized before
+// calling any of its methods.
+type MemoryCacher struct {
+	// MaxBytes is the maximum total number of bytes of the caches.
+	//
+	// If the MaxBytes is zero, there is no limit.
+	MaxBytes int64
+
+	// MaxCacheBytes is the maximum number of bytes of a single cache.
+	//
+	// If the MaxCacheBytes is zero, only the [MemoryCacher.MaxBytes]
+	// applies.
+	MaxCacheBytes int64
+
+	mutex      sync.Mutex
+	entries    map[string]*list.Element
+	lru        list.List
+	totalBytes int64
+}
+
+// memoryEntry is an entry of a cache stored by a [MemoryCacher].
+type memoryEntry struct {
+	name    string
+	content []byte
+	modTime time.Time
+	expires time.Time
+}
+
+// memoryContent is the content of a cache got from a [MemoryCacher] or a
+// [BoltCacher].
+type memoryContent struct {
+	*bytes.Reader
+
+	modTime time.Time
+	expires time.Time
+}
+
+// Close implements the [io.Closer].
+func (c *memoryContent) Close() error {
+	return nil
+}
+
+// LastModified returns the last modified time of the c.
+func (c *memoryContent) LastModified() time.Time {
+	return c.modTime
+}
+
+// Expires returns the expiration time of the c.
+func (c *memoryContent) Expires() time.Time {
+	return c.expires
+}
+
+// Get implements the [Cacher].
+func (mc *MemoryCacher) Get(
+	ctx context.Context,
+	name string,
+) (io.ReadCloser, error) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	elem, ok := mc.entries[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	e := elem.Value.(*memoryEntry)
+	if time.Now().After(e.expires) {
+		mc.remove(elem)
+		return nil, os.ErrNotExist
+	}
+
+	mc.lru.MoveToFront(elem)
+
+	return &memoryContent{
+		Reader:  bytes.NewReader(e.content),
+		modTime: e.modTime,
+		expires: e.expires,
+	}, nil
+}
+
+// Put implements the [Cacher].
+func (mc *MemoryCacher) Put(
+	ctx context.Context,
+	name string,
+	content io.ReadSeeker,
+	expiration time.Duration,
+) error {
+	size, err := content.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	} else if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if (mc.MaxCacheBytes > 0 && size > mc.MaxCacheBytes) ||
+		(mc.MaxBytes > 0 && size > mc.MaxBytes) {
+		mc.forget(name)
+		return nil
+	}
+
+	b, err := ioutil.ReadAll(content)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	e := &memoryEntry{
+		name:    name,
+		content: b,
+		modTime: now,
+		expires: now.Add(expiration),
+	}
+
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	if mc.entries == nil {
+		mc.entries = map[string]*list.Element{}
+	}
+
+	if elem, ok := mc.entries[name]; ok {
+		mc.remove(elem)
+	}
+
+	mc.entries[name] = mc.lru.PushFront(e)
+	mc.totalBytes += int64(len(b))
+	for mc.MaxBytes > 0 && mc.totalBytes > mc.MaxBytes {
+		mc.remove(mc.lru.Back())
+	}
+
+	return nil
+}
+
+// Delete implements the [Deleter].
+func (mc *MemoryCacher) Delete(ctx context.Context, name string) error {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	elem, ok := mc.entries[name]
+	if !ok {
+		return os.ErrNotExist
+	}
+
+	mc.remove(elem)
+
+	return nil
+}
+
+// Cleanup implements the [Cacher].
+func (mc *MemoryCacher) Cleanup() error {
+	return mc.CleanupReclaimed(nil)
+}
+
+// CleanupReclaimed implements the [Reclaimer].
+func (mc *MemoryCacher) CleanupReclaimed(
+	reclaimed func(name string, size int64),
+) error {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	now := time.Now()
+	for elem := mc.lru.Front(); elem != nil; {
+		next := elem.Next()
+		e := elem.Value.(*memoryEntry)
+		if now.After(e.expires) {
+			mc.remove(elem)
+			if reclaimed != nil {
+				reclaimed(e.name, int64(len(e.content)))
+			}
+		}
+
+		elem = next
+	}
+
+	return nil
+}
+
+// List implements the [Lister].
+func (mc *MemoryCacher) List(ctx context.Context, prefix string) CacheIterator {
+	return listCaches(ctx, mc.walkCaches, prefix)
+}
+
+// walkCaches implements the [cacheWalker].
+func (mc *MemoryCacher) walkCaches(
+	fn func(name string, size int64) error,
+) error {
+	mc.mutex.Lock()
+	entries := make([]*memoryEntry, 0, mc.lru.Len())
+	for elem := mc.lru.Front(); elem != nil; elem = elem.Next() {
+		entries = append(entries, elem.Value.(*memoryEntry))
+	}
+
+	mc.mutex.Unlock()
+
+	for _, e := range entries {
+		if err := fn(e.name, int64(len(e.content))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// forget removes the cache for the name, if any.
+func (mc *MemoryCacher) forget(name string) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+	if elem, ok := mc.entries[name]; ok {
+		mc.remove(elem)
+	}
+}
+
+// remove removes the elem from the mc. The mc.mutex must be held.
+func (mc *MemoryCacher) remove(elem *list.Element) {
+	e := mc.lru.Remove(elem).(*memoryEntry)
+	delete(mc.entries, e.name)
+	mc.totalBytes -= int64(len(e.content))
+}
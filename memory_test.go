@@ -0,0 +1,117 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryCacher(t *testing.T) {
+	mc := &MemoryCacher{MaxBytes: 30, MaxCacheBytes: 20}
+	put := func(name string, size int, expiration time.Duration) {
+		if err := mc.Put(
+			context.Background(),
+			name,
+			strings.NewReader(strings.Repeat("a", size)),
+			expiration,
+		); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	get := func(name string) error {
+		content, err := mc.Get(context.Background(), name)
+		if err != nil {
+			return err
+		}
+
+		return content.Close()
+	}
+
+	put("a.info", 10, time.Hour)
+	put("b.info", 10, time.Hour)
+	put("c.info", 5, time.Hour)
+	if err := get("a.info"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	// The least recently used "b.info" is evicted.
+	put("d.info", 10, time.Hour)
+	if err := get("b.info"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got error %q, want error %q", err, os.ErrNotExist)
+	}
+
+	for _, name := range []string{"a.info", "c.info", "d.info"} {
+		if err := get(name); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	// Caches that are too large are not stored.
+	put("a.info", 25, time.Hour)
+	if err := get("a.info"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got error %q, want error %q", err, os.ErrNotExist)
+	}
+
+	if got, want := mc.totalBytes, int64(15); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	content, err := mc.Get(context.Background(), "c.info")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer content.Close()
+
+	if _, err := content.(io.Seeker).Seek(3, io.SeekStart); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	b, err := ioutil.ReadAll(content)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := string(b), "aa"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if content.(interface{ LastModified() time.Time }).
+		LastModified().IsZero() {
+		t.Error("expected non-zero last modified time")
+	}
+
+	put("e.info", 5, -time.Hour)
+	var reclaimed []string
+	if err := mc.CleanupReclaimed(func(name string, size int64) {
+		reclaimed = append(reclaimed, name)
+	}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := strings.Join(reclaimed, ","),
+		"e.info"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	var names []string
+	if err := mc.walkCaches(func(name string, size int64) error {
+		names = append(names, name)
+		return nil
+	}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := strings.Join(names, ","),
+		"c.info,d.info"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if err := mc.Delete(context.Background(), "d.info"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if err := mc.Delete(
+		context.Background(),
+		"d.info",
+	); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got error %q, want error %q", err, os.ErrNotExist)
+	}
+}
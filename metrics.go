@@ -0,0 +1,455 @@
+package goproxy
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetricsSink is the sink of the metrics of a [Goproxy], through which they
+// can be reported to any metrics system (e.g. an in-house one or StatsD). See
+// the [PrometheusMetrics] and the [OTLPMetrics] for the provided ones.
+//
+// The metrics are named after the Prometheus conventions (e.g.
+// "goproxy_requests_total"), and each of them is always reported with the
+// same label names.
+type MetricsSink interface {
+	// Counter returns the counter of the name, which is described by the
+	// help and labeled by the labelNames.
+	Counter(name, help string, labelNames ...string) MetricsCounter
+
+	// Gauge returns the gauge of the name, which is described by the help
+	// and labeled by the labelNames.
+	Gauge(name, help string, labelNames ...string) MetricsGauge
+
+	// Histogram returns the histogram of the name, which is described by
+	// the help, labeled by the labelNames and bucketed by the upper bounds
+	// of the buckets in increasing order.
+	Histogram(
+		name string,
+		help string,
+		buckets []float64,
+		labelNames ...string,
+	) MetricsHistogram
+}
+
+// MetricsCounter is a counter of a [MetricsSink]. Its methods are given the
+// values of its labels in the order of their names.
+type MetricsCounter interface {
+	// Add adds the delta, which is never negative, to the counter.
+	Add(delta float64, labelValues ...string)
+}
+
+// MetricsGauge is a gauge of a [MetricsSink]. Its methods are given the values
+// of its labels in the order of their names.
+type MetricsGauge interface {
+	// Set sets the gauge to the value.
+	Set(value float64, labelValues ...string)
+
+	// Add adds the delta, which may be negative, to the gauge.
+	Add(delta float64, labelValues ...string)
+}
+
+// MetricsHistogram is a histogram of a [MetricsSink]. Its methods are given the
+// values of its labels in the order of their names.
+type MetricsHistogram interface {
+	// Observe records the value in the histogram.
+	Observe(value float64, labelValues ...string)
+}
+
+// durationBuckets are the histogram buckets of the durations in seconds.
+var durationBuckets = []float64{
+	0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120,
+}
+
+// proxyMetrics are the metrics of a [Goproxy] reported to its
+// [Goproxy.Metrics]. All of its methods do nothing on a nil receiver.
+type proxyMetrics struct {
+	requests             MetricsCounter
+	requestDuration      MetricsHistogram
+	fetches              MetricsCounter
+	fetchDuration        MetricsHistogram
+	fetchesInFlight      MetricsGauge
+	downloadCacheLookups MetricsCounter
+	upstreamRequests     MetricsCounter
+	upstreamBytes        MetricsCounter
+}
+
+// newProxyMetrics returns a new instance of the [proxyMetrics] reported to the
+// ms. It returns nil if the ms is nil.
+func newProxyMetrics(ms MetricsSink) *proxyMetrics {
+	if ms == nil {
+		return nil
+	}
+
+	return &proxyMetrics{
+		requests: ms.Counter(
+			"goproxy_requests_total",
+			"Number of module proxy and checksum database proxy "+
+				"requests served.",
+			"kind",
+			"code",
+		),
+		requestDuration: ms.Histogram(
+			"goproxy_request_duration_seconds",
+			"Time taken to serve module proxy and checksum "+
+				"database proxy requests.",
+			durationBuckets,
+			"kind",
+		),
+		fetches: ms.Counter(
+			"goproxy_fetches_total",
+			"Number of module files fetched from upstream.",
+			"ops",
+			"result",
+		),
+		fetchDuration: ms.Histogram(
+			"goproxy_fetch_duration_seconds",
+			"Time taken to fetch module files from upstream.",
+			durationBuckets,
+			"ops",
+		),
+		fetchesInFlight: ms.Gauge(
+			"goproxy_fetches_in_flight",
+			"Number of module files being fetched from upstream.",
+			"ops",
+		),
+		downloadCacheLookups: ms.Counter(
+			"goproxy_download_cache_lookups_total",
+			"Number of requests for module files by whether they "+
+				"were served from the cache.",
+			"result",
+		),
+		upstreamRequests: ms.Counter(
+			"goproxy_upstream_requests_total",
+			"Number of requests sent to upstreams.",
+			"upstream",
+		),
+		upstreamBytes: ms.Counter(
+			"goproxy_upstream_bytes_total",
+			"Number of bytes of the response bodies downloaded "+
+				"from upstreams.",
+			"upstream",
+		),
+	}
+}
+
+// observeRequest records a request for the name that has been responded with
+// the statusCode after the duration.
+func (pm *proxyMetrics) observeRequest(
+	name string,
+	statusCode int,
+	duration time.Duration,
+) {
+	if pm == nil {
+		return
+	}
+
+	kind := requestKind(name)
+	pm.requests.Add(1, kind, strconv.Itoa(statusCode))
+	pm.requestDuration.Observe(duration.Seconds(), kind)
+}
+
+// startFetch records the start of a fetch for the ops and returns the function
+// that records its end with its err.
+func (pm *proxyMetrics) startFetch(ops fetchOps) func(err error) {
+	if pm == nil {
+		return func(error) {}
+	}
+
+	start := time.Now()
+	opsLabel := ops.String()
+	pm.fetchesInFlight.Add(1, opsLabel)
+	return func(err error) {
+		pm.fetchesInFlight.Add(-1, opsLabel)
+		pm.fetchDuration.Observe(time.Since(start).Seconds(), opsLabel)
+		result := "success"
+		if err != nil {
+			result = "failure"
+		}
+
+		pm.fetches.Add(1, opsLabel, result)
+	}
+}
+
+// observeDownload records a request for a module file, which has been served
+// from the cache if the hit is true.
+func (pm *proxyMetrics) observeDownload(hit bool) {
+	if pm == nil {
+		return
+	}
+
+	if hit {
+		pm.downloadCacheLookups.Add(1, "hit")
+	} else {
+		pm.downloadCacheLookups.Add(1, "miss")
+	}
+}
+
+// observeUpstreamUsage records the requests and the bytes for the upstream.
+func (pm *proxyMetrics) observeUpstreamUsage(
+	upstream string,
+	requests int64,
+	bytes int64,
+) {
+	if pm == nil {
+		return
+	}
+
+	pm.upstreamRequests.Add(float64(requests), upstream)
+	pm.upstreamBytes.Add(float64(bytes), upstream)
+}
+
+// requestKind returns the kind of the request for the name, which is used as a
+// label of the metrics to keep their cardinality low.
+func requestKind(name string) string {
+	switch {
+	case strings.HasPrefix(name, "sumdb/"):
+		return "sumdb"
+	case strings.HasSuffix(name, "/@latest"):
+		return "latest"
+	case strings.HasSuffix(name, "/@v/list"):
+		return "list"
+	}
+
+	switch ext := name[strings.LastIndex(name, ".")+1:]; ext {
+	case "info", "mod", "zip", "ziphash":
+		return ext
+	}
+
+	return "other"
+}
+
+// serveMetrics serves metrics requests with the [Goproxy.Metrics] if it is an
+// [http.Handler] (e.g. the [PrometheusMetrics]).
+func (g *Goproxy) serveMetrics(rw http.ResponseWriter, req *http.Request) {
+	h, ok := g.Metrics.(http.Handler)
+	if !ok {
+		responseNotFound(rw, req, -2)
+		return
+	}
+
+	h.ServeHTTP(rw, req)
+}
+
+// metricsResponseWriter is an [http.ResponseWriter] that records the status
+// code of the response for the [proxyMetrics].
+type metricsResponseWriter struct {
+	http.ResponseWriter
+
+	statusCode int
+}
+
+// WriteHeader implements the [http.ResponseWriter].
+func (mrw *metricsResponseWriter) WriteHeader(statusCode int) {
+	if mrw.statusCode == 0 {
+		mrw.statusCode = statusCode
+	}
+
+	mrw.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Flush implements the [http.Flusher].
+func (mrw *metricsResponseWriter) Flush() {
+	if f, ok := mrw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Metric types of the [metricFamily].
+const (
+	metricTypeCounter   = "counter"
+	metricTypeGauge     = "gauge"
+	metricTypeHistogram = "histogram"
+)
+
+// metricsRegistry is an in-memory [MetricsSink] that aggregates the metrics
+// reported to it, for the sinks exporting them.
+type metricsRegistry struct {
+	mutex     sync.Mutex
+	families  map[string]*metricFamily
+	startTime time.Time
+}
+
+// Counter implements the [MetricsSink].
+func (mr *metricsRegistry) Counter(
+	name string,
+	help string,
+	labelNames ...string,
+) MetricsCounter {
+	return mr.family(metricTypeCounter, name, help, nil, labelNames)
+}
+
+// Gauge implements the [MetricsSink].
+func (mr *metricsRegistry) Gauge(
+	name string,
+	help string,
+	labelNames ...string,
+) MetricsGauge {
+	return mr.family(metricTypeGauge, name, help, nil, labelNames)
+}
+
+// Histogram implements the [MetricsSink].
+func (mr *metricsRegistry) Histogram(
+	name string,
+	help string,
+	buckets []float64,
+	labelNames ...string,
+) MetricsHistogram {
+	return mr.family(metricTypeHistogram, name, help, buckets, labelNames)
+}
+
+// family returns the [metricFamily] of the name, registering it if it does not
+// exist yet. Families registered more than once (e.g. by multiple [Goproxy]s
+// sharing the mr) are aggregated together.
+func (mr *metricsRegistry) family(
+	typ string,
+	name string,
+	help string,
+	buckets []float64,
+	labelNames []string,
+) *metricFamily {
+	mr.mutex.Lock()
+	defer mr.mutex.Unlock()
+
+	if mf, ok := mr.families[name]; ok {
+		return mf
+	}
+
+	if mr.families == nil {
+		mr.families = map[string]*metricFamily{}
+		mr.startTime = time.Now()
+	}
+
+	mf := &metricFamily{
+		mr:         mr,
+		typ:        typ,
+		name:       name,
+		help:       help,
+		labelNames: append([]string{}, labelNames...),
+		buckets:    append([]float64{}, buckets...),
+		series:     map[string]*metricSeries{},
+	}
+	mr.families[name] = mf
+
+	return mf
+}
+
+// snapshot returns a deep copy of the families of the mr, sorted by name with
+// their series sorted by label values, along with the time since which they
+// have been aggregated.
+func (mr *metricsRegistry) snapshot() ([]metricFamily, time.Time) {
+	mr.mutex.Lock()
+	defer mr.mutex.Unlock()
+
+	families := make([]metricFamily, 0, len(mr.families))
+	for _, mf := range mr.families {
+		c := *mf
+		c.series = nil
+		c.sortedSeries = make([]metricSeries, 0, len(mf.series))
+		for _, ms := range mf.series {
+			sc := *ms
+			sc.bucketCounts = append([]uint64{}, ms.bucketCounts...)
+			c.sortedSeries = append(c.sortedSeries, sc)
+		}
+
+		sort.Slice(c.sortedSeries, func(i, j int) bool {
+			return c.sortedSeries[i].key < c.sortedSeries[j].key
+		})
+
+		families = append(families, c)
+	}
+
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].name < families[j].name
+	})
+
+	return families, mr.startTime
+}
+
+// metricFamily is a metric of a [metricsRegistry] with all its series. It
+// implements the [MetricsCounter], the [MetricsGauge] and the
+// [MetricsHistogram].
+type metricFamily struct {
+	mr         *metricsRegistry
+	typ        string
+	name       string
+	help       string
+	labelNames []string
+	buckets    []float64
+	series     map[string]*metricSeries
+
+	// sortedSeries are the series of a snapshot (see the
+	// [metricsRegistry.snapshot]).
+	sortedSeries []metricSeries
+}
+
+// metricSeries is a series of a [metricFamily] with the same label values.
+type metricSeries struct {
+	key         string
+	labelValues []string
+
+	// value is the value of a counter or a gauge.
+	value float64
+
+	// count, sum and bucketCounts are the number of observations, their
+	// sum and their numbers in each bucket (the last one being the +Inf
+	// bucket) of a histogram.
+	count        uint64
+	sum          float64
+	bucketCounts []uint64
+}
+
+// Add implements the [MetricsCounter] and the [MetricsGauge].
+func (mf *metricFamily) Add(delta float64, labelValues ...string) {
+	mf.update(labelValues, func(ms *metricSeries) { ms.value += delta })
+}
+
+// Set implements the [MetricsGauge].
+func (mf *metricFamily) Set(value float64, labelValues ...string) {
+	mf.update(labelValues, func(ms *metricSeries) { ms.value = value })
+}
+
+// Observe implements the [MetricsHistogram].
+func (mf *metricFamily) Observe(value float64, labelValues ...string) {
+	mf.update(labelValues, func(ms *metricSeries) {
+		i := sort.SearchFloat64s(mf.buckets, value)
+		ms.bucketCounts[i]++
+		ms.count++
+		ms.sum += value
+	})
+}
+
+// update calls the fn with the series of the labelValues while holding the
+// lock of the registry. The labelValues are padded or truncated to match the
+// label names.
+func (mf *metricFamily) update(labelValues []string, fn func(*metricSeries)) {
+	if len(labelValues) != len(mf.labelNames) {
+		lvs := make([]string, len(mf.labelNames))
+		copy(lvs, labelValues)
+		labelValues = lvs
+	}
+
+	key := strings.Join(labelValues, "\x00")
+
+	mf.mr.mutex.Lock()
+	defer mf.mr.mutex.Unlock()
+
+	ms, ok := mf.series[key]
+	if !ok {
+		ms = &metricSeries{
+			key:         key,
+			labelValues: append([]string{}, labelValues...),
+		}
+		if mf.typ == metricTypeHistogram {
+			ms.bucketCounts = make([]uint64, len(mf.buckets)+1)
+		}
+
+		mf.series[key] = ms
+	}
+
+	fn(ms)
+}
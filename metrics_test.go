@@ -0,0 +1,181 @@
+package goproxy
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRequestKind(t *testing.T) {
+	for n, tt := range []struct {
+		name string
+		want string
+	}{
+		{"example.com/@latest", "latest"},
+		{"example.com/@v/list", "list"},
+		{"example.com/@v/v1.0.0.info", "info"},
+		{"example.com/@v/v1.0.0.mod", "mod"},
+		{"example.com/@v/v1.0.0.zip", "zip"},
+		{"example.com/@v/v1.0.0.ziphash", "ziphash"},
+		{"sumdb/sum.golang.org/latest", "sumdb"},
+		{"example.com", "other"},
+	} {
+		if got, want := requestKind(tt.name), tt.want; got != want {
+			t.Errorf("test(%d): got %q, want %q", n, got, want)
+		}
+	}
+}
+
+func TestMetricsRegistry(t *testing.T) {
+	var mr metricsRegistry
+	c := mr.Counter("c", "", "a", "b")
+	c.Add(1, "x", "y")
+	c.Add(2, "x", "y")
+	c.Add(1, "x")
+	mr.Counter("c", "").Add(1, "x", "y")
+
+	g := mr.Gauge("g", "")
+	g.Add(1)
+	g.Add(-3)
+	mr.Gauge("g2", "").Set(4)
+
+	h := mr.Histogram("h", "", []float64{1, 2})
+	for _, v := range []float64{0.5, 1, 1.5, 3} {
+		h.Observe(v)
+	}
+
+	families, _ := mr.snapshot()
+	if got, want := len(families), 4; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	series := families[0].sortedSeries
+	if got, want := len(series), 2; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	for i, want := range []float64{1, 4} {
+		if got := series[i].value; got != want {
+			t.Errorf("series %d: got %v, want %v", i, got, want)
+		}
+	}
+
+	if got, want := series[0].labelValues[1], ""; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if got, want := families[1].sortedSeries[0].value, -2.0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if got, want := families[2].sortedSeries[0].value, 4.0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	hs := families[3].sortedSeries[0]
+	if got, want := hs.count, uint64(4); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	if got, want := hs.sum, 6.0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	for i, want := range []uint64{2, 1, 1} {
+		if got := hs.bucketCounts[i]; got != want {
+			t.Errorf("bucket %d: got %d, want %d", i, got, want)
+		}
+	}
+}
+
+func TestGoproxyMetrics(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestGoproxyMetrics")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	server := httptest.NewServer(http.HandlerFunc(func(
+		rw http.ResponseWriter,
+		req *http.Request,
+	) {
+		if req.URL.Path == "/example.com/@v/v1.0.0.mod" {
+			responseString(
+				rw,
+				req,
+				http.StatusOK,
+				-2,
+				"module example.com",
+			)
+			return
+		}
+
+		responseNotFound(rw, req, -2)
+	}))
+	defer server.Close()
+
+	g := &Goproxy{
+		GoBinEnv: []string{
+			"GOPROXY=" + server.URL,
+			"GOSUMDB=off",
+		},
+		Cacher:  DirCacher(tempDir),
+		TempDir: tempDir,
+		AdminAuthorizer: func(*http.Request) bool {
+			return true
+		},
+		Metrics:     &PrometheusMetrics{},
+		ErrorLogger: log.New(&discardWriter{}, "", 0),
+	}
+
+	for _, name := range []string{
+		"example.com/@v/v1.0.0.mod",
+		"example.com/@v/v1.0.0.mod",
+		"example.com/@v/v1.0.0.zip",
+	} {
+		req := httptest.NewRequest("", "/"+name, nil)
+		g.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	req := httptest.NewRequest("", "/-/metrics", nil)
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, req)
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	body := rec.Body.String()
+	for _, want := range []string{
+		`goproxy_requests_total{kind="mod",code="200"} 2` + "\n",
+		`goproxy_requests_total{kind="zip",code="404"} 1` + "\n",
+		`goproxy_request_duration_seconds_count{kind="mod"} 2` + "\n",
+		`goproxy_fetches_total{ops="download mod",result="success"} 1` +
+			"\n",
+		`goproxy_fetches_total{ops="download zip",result="failure"} 1` +
+			"\n",
+		`goproxy_fetches_in_flight{ops="download mod"} 0` + "\n",
+		`goproxy_download_cache_lookups_total{result="hit"} 1` + "\n",
+		`goproxy_download_cache_lookups_total{result="miss"} 2` + "\n",
+		`goproxy_upstream_requests_total{upstream="` + server.URL +
+			`"} 2` + "\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("got %q, want to contain %q", body, want)
+		}
+	}
+
+	g = &Goproxy{
+		AdminAuthorizer: func(*http.Request) bool {
+			return true
+		},
+	}
+	rec = httptest.NewRecorder()
+	g.ServeHTTP(rec, req)
+	if got, want := rec.Code, http.StatusNotFound; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,86 @@
+package goproxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+)
+
+// notFoundNamePrefix is the prefix of the names of the caches of the not-found
+// results of fetching module files (see the
+// [Goproxy.NotFoundCacheExpiration]).
+const notFoundNamePrefix = apiPathPrefix + "not-found/"
+
+// notFoundResult is a cached not-found result of fetching a module file.
+type notFoundResult struct {
+	// Error is the message of the not-found error.
+	Error string
+
+	// Gone indicates whether the error was a gone one.
+	Gone bool `json:",omitempty"`
+}
+
+// cachedNotFound returns the cached not-found result of fetching the module
+// file targeted by the name as an error, or nil if there is none.
+func (g *Goproxy) cachedNotFound(ctx context.Context, name string) error {
+	if g.settings().NotFoundCacheExpiration <= 0 {
+		return nil
+	}
+
+	b, err := g.cacheBytes(ctx, notFoundNamePrefix+name)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			g.logErrorf(
+				"failed to get cached not-found result: %s: %v",
+				name,
+				err,
+			)
+		}
+
+		return nil
+	}
+
+	var nfr notFoundResult
+	if err := json.Unmarshal(b, &nfr); err != nil || nfr.Error == "" {
+		return nil
+	}
+
+	g.updateStats(func(s *Stats) { s.NotFoundCacheHits++ })
+	if nfr.Gone {
+		return goneError(nfr.Error)
+	}
+
+	return notFoundError(nfr.Error)
+}
+
+// putNotFound caches the err of fetching the module file targeted by the name
+// if it is a not-found one, but neither a timeout nor a bad upstream one.
+func (g *Goproxy) putNotFound(ctx context.Context, name string, err error) {
+	expiration := g.settings().NotFoundCacheExpiration
+	if expiration <= 0 || isUpstreamFailure(err) {
+		return
+	}
+
+	b, marshalErr := json.Marshal(notFoundResult{
+		Error: err.Error(),
+		Gone:  errors.Is(err, errGone),
+	})
+	if marshalErr != nil {
+		return
+	}
+
+	if err := g.putCache(
+		ctx,
+		notFoundNamePrefix+name,
+		bytes.NewReader(b),
+		expiration,
+	); err != nil {
+		g.logErrorf(
+			"failed to cache not-found result: %s: %v",
+			name,
+			err,
+		)
+	}
+}
@@ -0,0 +1,127 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGoproxyNotFoundCache(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestGoproxyNotFoundCache")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	var (
+		requestsMutex sync.Mutex
+		requests      = map[string]int{}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(
+		rw http.ResponseWriter,
+		req *http.Request,
+	) {
+		requestsMutex.Lock()
+		requests[req.URL.Path]++
+		requestsMutex.Unlock()
+		switch req.URL.Path {
+		case "/gone.example.com/@v/v1.0.0.info":
+			responseGone(rw, req, 60)
+		default:
+			responseNotFound(rw, req, 60)
+		}
+	}))
+	defer server.Close()
+
+	g := &Goproxy{
+		Cacher: DirCacher(tempDir),
+		GoBinEnv: []string{
+			"GOPROXY=" + server.URL,
+			"GOSUMDB=off",
+		},
+		NotFoundCacheExpiration: time.Minute,
+		ErrorLogger:             log.New(&discardWriter{}, "", 0),
+	}
+
+	for _, tt := range []struct {
+		n            int
+		name         string
+		wantCode     int
+		wantRequests int
+	}{
+		{1, "example.com/@v/v1.0.0.info", http.StatusNotFound, 1},
+		{2, "example.com/@v/list", http.StatusNotFound, 1},
+		{3, "gone.example.com/@v/v1.0.0.info", http.StatusGone, 1},
+	} {
+		for i := 0; i < 2; i++ {
+			rec := httptest.NewRecorder()
+			g.ServeHTTP(rec, httptest.NewRequest(
+				http.MethodGet,
+				"/"+tt.name,
+				nil,
+			))
+			if got, want := rec.Code, tt.wantCode; got != want {
+				t.Fatalf("test(%d): got %d, want %d",
+					tt.n, got, want)
+			}
+		}
+
+		requestsMutex.Lock()
+		got := requests["/"+tt.name]
+		requestsMutex.Unlock()
+		if got != tt.wantRequests {
+			t.Errorf("test(%d): got %d, want %d",
+				tt.n, got, tt.wantRequests)
+		}
+	}
+
+	if got, want := g.Stats().NotFoundCacheHits, int64(3); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	// Bad upstreams are not cached.
+	g.putNotFound(
+		context.Background(),
+		"bad.example.com/@v/v1.0.0.info",
+		notFoundError(errBadUpstream.Error()),
+	)
+	if err := g.cachedNotFound(
+		context.Background(),
+		"bad.example.com/@v/v1.0.0.info",
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if err := g.UpdateSettings(func(s *Settings) error {
+		s.NotFoundCacheExpiration = 0
+		return nil
+	}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if err := g.cachedNotFound(
+		context.Background(),
+		"example.com/@v/v1.0.0.info",
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	g.putNotFound(
+		context.Background(),
+		"example.com/@v/v2.0.0.info",
+		notFoundError("not found"),
+	)
+	if _, err := g.cache(
+		context.Background(),
+		notFoundNamePrefix+"example.com/@v/v2.0.0.info",
+	); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got error %q, want error %q", err, os.ErrNotExist)
+	}
+}
@@ -0,0 +1,266 @@
+package goproxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultOTLPServiceName is the default value of the
+// [OTLPMetrics.ServiceName].
+const defaultOTLPServiceName = "goproxy"
+
+// otlpScopeName is the name of the instrumentation scope of the metrics pushed
+// by the [OTLPMetrics].
+const otlpScopeName = "github.com/Coopermasaaki/goproxy"
+
+// OTLPMetrics is a [MetricsSink] that aggregates the metrics in memory and
+// pushes them periodically to an OpenTelemetry collector (or any other
+// receiver) via the OTLP/HTTP protocol with JSON encoding, as cumulative
+// sums, gauges and explicit bucket histograms.
+type OTLPMetrics struct {
+	metricsRegistry
+
+	// Endpoint is the URL that the metrics are pushed to, e.g.
+	// "http://localhost:4318/v1/metrics".
+	Endpoint string
+
+	// Header is the extra header (e.g. the Authorization) of the requests
+	// pushing the metrics.
+	Header http.Header
+
+	// ServiceName is the "service.name" resource attribute of the metrics.
+	//
+	// If the ServiceName is empty, "goproxy" is used.
+	ServiceName string
+
+	// Interval is the interval between two pushes of the [OTLPMetrics.Run].
+	//
+	// If the Interval is zero, one minute is used.
+	Interval time.Duration
+
+	// Client is the HTTP client that pushes the metrics.
+	//
+	// If the Client is nil, the [http.DefaultClient] is used.
+	Client *http.Client
+}
+
+// Run pushes the metrics periodically until the ctx is done, after which they
+// are pushed one last time. The errors that occur while pushing are reported
+// to the onError.
+func (om *OTLPMetrics) Run(ctx context.Context, onError func(err error)) error {
+	interval := om.Interval
+	if interval == 0 {
+		interval = time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := om.Push(ctx); err != nil && ctx.Err() == nil {
+				onError(err)
+			}
+		case <-ctx.Done():
+			pushCtx, cancel := context.WithTimeout(
+				context.Background(),
+				10*time.Second,
+			)
+			if err := om.Push(pushCtx); err != nil {
+				onError(err)
+			}
+
+			cancel()
+
+			return ctx.Err()
+		}
+	}
+}
+
+// Push pushes the current values of the metrics to the
+// [OTLPMetrics.Endpoint].
+func (om *OTLPMetrics) Push(ctx context.Context) error {
+	body, err := json.Marshal(om.request(time.Now()))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(
+		http.MethodPost,
+		om.Endpoint,
+		bytes.NewReader(body),
+	)
+	if err != nil {
+		return err
+	}
+
+	for k, vs := range om.Header {
+		req.Header[k] = vs
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	client := om.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(io.LimitReader(res.Body, 4096))
+		return fmt.Errorf(
+			"failed to push metrics: %s: %s",
+			res.Status,
+			bytes.TrimSpace(b),
+		)
+	}
+
+	_, err = io.Copy(ioutil.Discard, res.Body)
+
+	return err
+}
+
+// otlpKeyValue is a key-value pair of the OTLP, whose values are always
+// strings here.
+type otlpKeyValue struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+// otlpDataPoint is a number or histogram data point of the OTLP. The 64-bit
+// integers are strings as required by the JSON encoding of the OTLP.
+type otlpDataPoint struct {
+	Attributes        []otlpKeyValue `json:"attributes"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	TimeUnixNano      string         `json:"timeUnixNano"`
+	AsDouble          *float64       `json:"asDouble,omitempty"`
+	Count             string         `json:"count,omitempty"`
+	Sum               *float64       `json:"sum,omitempty"`
+	BucketCounts      []string       `json:"bucketCounts,omitempty"`
+	ExplicitBounds    []float64      `json:"explicitBounds,omitempty"`
+}
+
+// otlpData is the data of a metric of the OTLP.
+type otlpData struct {
+	DataPoints []otlpDataPoint `json:"dataPoints"`
+
+	AggregationTemporality int `json:"aggregationTemporality,omitempty"`
+
+	IsMonotonic bool `json:"isMonotonic,omitempty"`
+}
+
+// otlpMetric is a metric of the OTLP.
+type otlpMetric struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Sum         *otlpData `json:"sum,omitempty"`
+	Gauge       *otlpData `json:"gauge,omitempty"`
+	Histogram   *otlpData `json:"histogram,omitempty"`
+}
+
+// otlpAggregationTemporalityCumulative is the cumulative aggregation
+// temporality of the OTLP.
+const otlpAggregationTemporalityCumulative = 2
+
+// request returns the body of the OTLP export request of the metrics at the
+// now.
+func (om *OTLPMetrics) request(now time.Time) interface{} {
+	families, startTime := om.snapshot()
+	startTimeUnixNano := strconv.FormatInt(startTime.UnixNano(), 10)
+	timeUnixNano := strconv.FormatInt(now.UnixNano(), 10)
+
+	metrics := make([]otlpMetric, 0, len(families))
+	for _, mf := range families {
+		data := &otlpData{DataPoints: []otlpDataPoint{}}
+		m := otlpMetric{Name: mf.name, Description: mf.help}
+		switch mf.typ {
+		case metricTypeCounter:
+			data.AggregationTemporality =
+				otlpAggregationTemporalityCumulative
+			data.IsMonotonic = true
+			m.Sum = data
+		case metricTypeGauge:
+			m.Gauge = data
+		case metricTypeHistogram:
+			data.AggregationTemporality =
+				otlpAggregationTemporalityCumulative
+			m.Histogram = data
+		}
+
+		for _, ms := range mf.sortedSeries {
+			dp := otlpDataPoint{
+				Attributes: make(
+					[]otlpKeyValue,
+					len(mf.labelNames),
+				),
+				StartTimeUnixNano: startTimeUnixNano,
+				TimeUnixNano:      timeUnixNano,
+			}
+			for i, name := range mf.labelNames {
+				dp.Attributes[i].Key = name
+				dp.Attributes[i].Value.StringValue =
+					ms.labelValues[i]
+			}
+
+			if mf.typ == metricTypeHistogram {
+				sum := ms.sum
+				dp.Count = strconv.FormatUint(ms.count, 10)
+				dp.Sum = &sum
+				dp.BucketCounts = make(
+					[]string,
+					len(ms.bucketCounts),
+				)
+				for i, c := range ms.bucketCounts {
+					dp.BucketCounts[i] =
+						strconv.FormatUint(c, 10)
+				}
+
+				dp.ExplicitBounds = mf.buckets
+			} else {
+				value := ms.value
+				dp.AsDouble = &value
+			}
+
+			data.DataPoints = append(data.DataPoints, dp)
+		}
+
+		metrics = append(metrics, m)
+	}
+
+	serviceName := om.ServiceName
+	if serviceName == "" {
+		serviceName = defaultOTLPServiceName
+	}
+
+	var serviceNameAttr otlpKeyValue
+	serviceNameAttr.Key = "service.name"
+	serviceNameAttr.Value.StringValue = serviceName
+
+	return map[string]interface{}{
+		"resourceMetrics": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": []otlpKeyValue{serviceNameAttr},
+			},
+			"scopeMetrics": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{
+					"name": otlpScopeName,
+				},
+				"metrics": metrics,
+			}},
+		}},
+	}
+}
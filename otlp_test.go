@@ -0,0 +1,142 @@
+package goproxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOTLPMetrics(t *testing.T) {
+	type dataPoint struct {
+		Attributes []struct {
+			Key   string
+			Value struct{ StringValue string }
+		}
+		AsDouble       *float64
+		Count          string
+		Sum            *float64
+		BucketCounts   []string
+		ExplicitBounds []float64
+	}
+	type data struct {
+		DataPoints             []dataPoint
+		AggregationTemporality int
+		IsMonotonic            bool
+	}
+	var exported struct {
+		ResourceMetrics []struct {
+			Resource struct {
+				Attributes []struct {
+					Key   string
+					Value struct{ StringValue string }
+				}
+			}
+			ScopeMetrics []struct {
+				Metrics []struct {
+					Name      string
+					Sum       *data
+					Gauge     *data
+					Histogram *data
+				}
+			}
+		}
+	}
+
+	pushes := make(chan struct{}, 10)
+	statusCode := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(
+		rw http.ResponseWriter,
+		req *http.Request,
+	) {
+		if got, want := req.Header.Get("Authorization"),
+			"Bearer foo"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+
+		if err := json.NewDecoder(req.Body).Decode(
+			&exported,
+		); err != nil {
+			t.Errorf("unexpected error %q", err)
+		}
+
+		rw.WriteHeader(statusCode)
+		pushes <- struct{}{}
+	}))
+	defer server.Close()
+
+	om := &OTLPMetrics{
+		Endpoint: server.URL,
+		Header:   http.Header{"Authorization": {"Bearer foo"}},
+		Interval: time.Hour,
+	}
+	om.Counter("a_total", "A.", "l").Add(2, "x")
+	om.Gauge("b", "B.").Set(3)
+	om.Histogram("c", "C.", []float64{1}).Observe(2)
+
+	if err := om.Push(context.Background()); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	<-pushes
+	if got, want := len(exported.ResourceMetrics), 1; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	rm := exported.ResourceMetrics[0]
+	if got, want := rm.Resource.Attributes[0].Value.StringValue,
+		defaultOTLPServiceName; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	metrics := rm.ScopeMetrics[0].Metrics
+	if got, want := len(metrics), 3; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	if sum := metrics[0].Sum; sum == nil {
+		t.Error("expected sum")
+	} else if dp := sum.DataPoints[0]; *dp.AsDouble != 2 ||
+		dp.Attributes[0].Key != "l" ||
+		dp.Attributes[0].Value.StringValue != "x" ||
+		!sum.IsMonotonic ||
+		sum.AggregationTemporality !=
+			otlpAggregationTemporalityCumulative {
+		t.Errorf("got %+v", sum)
+	}
+
+	if gauge := metrics[1].Gauge; gauge == nil {
+		t.Error("expected gauge")
+	} else if got, want := *gauge.DataPoints[0].AsDouble,
+		3.0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if histogram := metrics[2].Histogram; histogram == nil {
+		t.Error("expected histogram")
+	} else if dp := histogram.DataPoints[0]; dp.Count != "1" ||
+		*dp.Sum != 2 ||
+		len(dp.BucketCounts) != 2 ||
+		dp.BucketCounts[1] != "1" ||
+		len(dp.ExplicitBounds) != 1 {
+		t.Errorf("got %+v", dp)
+	}
+
+	// The metrics are pushed one last time once the ctx is done.
+	statusCode = http.StatusBadRequest
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var pushErr error
+	if err := om.Run(ctx, func(err error) {
+		pushErr = err
+	}); err != context.Canceled {
+		t.Errorf("got error %q, want error %q", err, context.Canceled)
+	}
+
+	<-pushes
+	if pushErr == nil {
+		t.Error("expected error")
+	}
+}
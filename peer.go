@@ -0,0 +1,240 @@
+package goproxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// peerDNSSchemePrefix is the scheme prefix of the [Goproxy.Peers]
+	// discovered via DNS (e.g. "dns+http://goproxy.internal:8080").
+	peerDNSSchemePrefix = "dns+"
+
+	// peerDNSRefreshInterval is the interval between two lookups of the
+	// [Goproxy.Peers] discovered via DNS.
+	peerDNSRefreshInterval = 30 * time.Second
+
+	// peerFetchTimeout is the maximum amount of time allowed for a fetch
+	// from a peer.
+	peerFetchTimeout = time.Minute
+)
+
+// peerSet is the set of the peer instances of a [Goproxy]. It is safe for
+// concurrent use.
+type peerSet struct {
+	peers      []string
+	httpClient *http.Client
+	lookupHost func(ctx context.Context, host string) ([]string, error)
+
+	mutex      sync.Mutex
+	addrs      map[string][]string
+	resolved   []string
+	resolvedAt time.Time
+}
+
+// newPeerSet returns a new instance of the [peerSet] for the peers, which are
+// requested via the transport.
+func newPeerSet(peers []string, transport http.RoundTripper) *peerSet {
+	ps := &peerSet{
+		httpClient: &http.Client{Transport: transport},
+		lookupHost: net.DefaultResolver.LookupHost,
+		addrs:      map[string][]string{},
+	}
+	for _, peer := range peers {
+		if peer = strings.TrimSuffix(peer, "/"); peer != "" {
+			ps.peers = append(ps.peers, peer)
+		}
+	}
+
+	return ps
+}
+
+// urls returns the base URLs of the peers of the ps, with those discovered via
+// DNS expanded into one base URL per address. The lookups are refreshed at
+// most once per [peerDNSRefreshInterval], and a failed lookup keeps the
+// addresses of the previous one.
+func (ps *peerSet) urls(ctx context.Context) []string {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	if ps.resolved != nil &&
+		time.Since(ps.resolvedAt) < peerDNSRefreshInterval {
+		return ps.resolved
+	}
+
+	resolved := []string{}
+	for _, peer := range ps.peers {
+		if !strings.HasPrefix(peer, peerDNSSchemePrefix) {
+			resolved = append(resolved, peer)
+			continue
+		}
+
+		rawURL := strings.TrimPrefix(peer, peerDNSSchemePrefix)
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			continue
+		}
+
+		addrs, err := ps.lookupHost(ctx, u.Hostname())
+		if err != nil {
+			addrs = ps.addrs[peer]
+		} else {
+			ps.addrs[peer] = addrs
+		}
+
+		for _, addr := range addrs {
+			host := addr
+			if port := u.Port(); port != "" {
+				host = net.JoinHostPort(addr, port)
+			} else if strings.Contains(addr, ":") {
+				host = "[" + addr + "]"
+			}
+
+			resolved = append(resolved, fmt.Sprint(
+				u.Scheme,
+				"://",
+				host,
+				u.Path,
+			))
+		}
+	}
+
+	ps.resolved = resolved
+	ps.resolvedAt = time.Now()
+	return ps.resolved
+}
+
+// fetch fetches the cached module file targeted by the name from the first
+// peer of the ps that has it, and writes it to the dst, which must be
+// resettable (see the [resetWriter]). It reports whether any peer had it.
+//
+// The peers are asked with the "Cache-Control: only-if-cached", so that they
+// never fetch anything from upstream (or from their own peers) for the g.
+func (ps *peerSet) fetch(ctx context.Context, name string, dst io.Writer) bool {
+	for _, peer := range ps.urls(ctx) {
+		if ps.fetchFrom(ctx, peer, name, dst) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fetchFrom is like the [peerSet.fetch], but only asks the peer.
+func (ps *peerSet) fetchFrom(
+	ctx context.Context,
+	peer string,
+	name string,
+	dst io.Writer,
+) bool {
+	ctx, cancel := context.WithTimeout(ctx, peerFetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodGet,
+		peer+"/"+name,
+		nil,
+	)
+	if err != nil {
+		return false
+	}
+
+	req.Header.Set("Cache-Control", "only-if-cached")
+
+	res, err := ps.httpClient.Do(req)
+	if err != nil {
+		return false
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, res.Body)
+		return false
+	}
+
+	if _, err := io.Copy(dst, res.Body); err != nil {
+		resetWriter(dst)
+		return false
+	}
+
+	return true
+}
+
+// fetchFromPeers fetches the module file targeted by the name from the
+// [Goproxy.Peers] into the g.Cacher with the expiration. It returns the local
+// file in the tempDir holding the module file, or an empty string if no peer
+// has it.
+func (g *Goproxy) fetchFromPeers(
+	ctx context.Context,
+	name string,
+	tempDir string,
+	expiration time.Duration,
+) string {
+	if g.peers == nil {
+		return ""
+	}
+
+	f, err := ioutil.TempFile(tempDir, "peer")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	if !g.peers.fetch(ctx, name, f) {
+		g.updateStats(func(s *Stats) { s.PeerFetchMisses++ })
+		return ""
+	}
+
+	if err := f.Close(); err != nil {
+		return ""
+	}
+
+	if err := g.putCacheFile(ctx, name, f.Name(), expiration); err != nil {
+		g.logErrorf("failed to cache module file: %s: %v", name, err)
+		return ""
+	}
+
+	g.updateStats(func(s *Stats) { s.PeerFetchHits++ })
+	return f.Name()
+}
+
+// serveFetchFromPeers serves the fetch download request for the f with the
+// module file fetched from the [Goproxy.Peers] into the tempDir. It reports
+// whether any peer had the module file.
+func (g *Goproxy) serveFetchFromPeers(
+	rw http.ResponseWriter,
+	req *http.Request,
+	f *fetch,
+	tempDir string,
+	expiration time.Duration,
+) bool {
+	file := g.fetchFromPeers(req.Context(), f.name, tempDir, expiration)
+	if file == "" {
+		return false
+	}
+
+	content, err := os.Open(file)
+	if err != nil {
+		g.logRequestErrorf(
+			req,
+			"failed to open peer fetch result: %s: %v",
+			f.name,
+			err,
+		)
+		responseInternalServerError(rw, req)
+		return true
+	}
+	defer content.Close()
+
+	responseSuccess(rw, req, content, f.contentType, 604800)
+	return true
+}
@@ -0,0 +1,163 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPeerSetURLs(t *testing.T) {
+	ps := newPeerSet([]string{
+		"http://10.0.0.1:8080/",
+		"dns+http://goproxy.internal:8080/prefix",
+		"dns+https://goproxy6.internal",
+		"",
+	}, nil)
+
+	var lookupErr error
+	ps.lookupHost = func(_ context.Context, host string) ([]string, error) {
+		if lookupErr != nil {
+			return nil, lookupErr
+		}
+
+		switch host {
+		case "goproxy.internal":
+			return []string{"10.0.0.2", "10.0.0.3"}, nil
+		case "goproxy6.internal":
+			return []string{"fd00::1"}, nil
+		}
+
+		return nil, errors.New("no such host")
+	}
+
+	want := strings.Join([]string{
+		"http://10.0.0.1:8080",
+		"http://10.0.0.2:8080/prefix",
+		"http://10.0.0.3:8080/prefix",
+		"https://[fd00::1]",
+	}, ",")
+	got := strings.Join(ps.urls(context.Background()), ",")
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	lookupErr = errors.New("lookup failed")
+	ps.resolvedAt = time.Time{}
+	got = strings.Join(ps.urls(context.Background()), ",")
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestGoproxyPeers(t *testing.T) {
+	peerTempDir, err := ioutil.TempDir("", "goproxy.TestGoproxyPeers")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(peerTempDir)
+
+	peer := &Goproxy{
+		GoBinEnv:    []string{"GOPROXY=off"},
+		Cacher:      DirCacher(peerTempDir),
+		TempDir:     peerTempDir,
+		ErrorLogger: log.New(&discardWriter{}, "", 0),
+	}
+	for _, name := range []string{
+		"example.com/@v/v1.0.0.mod",
+		"example.com/@v/v1.0.0.zip",
+	} {
+		if err := peer.Cacher.Put(
+			context.Background(),
+			name,
+			strings.NewReader("module example.com"),
+			time.Hour,
+		); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	peerServer := httptest.NewServer(peer)
+	defer peerServer.Close()
+
+	tempDir, err := ioutil.TempDir("", "goproxy.TestGoproxyPeers")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	g := &Goproxy{
+		GoBinEnv:    []string{"GOPROXY=off"},
+		Cacher:      DirCacher(tempDir),
+		TempDir:     tempDir,
+		Peers:       []string{peerServer.URL + "/"},
+		ErrorLogger: log.New(&discardWriter{}, "", 0),
+	}
+
+	req := httptest.NewRequest("", "/example.com/@v/v1.0.0.mod", nil)
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, req)
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	if got, want := rec.Body.String(), "module example.com"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	content, err := g.cache(
+		context.Background(),
+		"example.com/@v/v1.0.0.mod",
+	)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	content.Close()
+
+	req = httptest.NewRequest("", "/example.com/@v/v1.1.0.mod", nil)
+	rec = httptest.NewRecorder()
+	g.ServeHTTP(rec, req)
+	if got, want := rec.Code, http.StatusNotFound; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	stats := g.Stats()
+	if got, want := stats.PeerFetchHits, int64(1); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	if got, want := stats.PeerFetchMisses, int64(1); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	if got, want := stats.UpstreamRequests, int64(0); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	if err := g.prefetch(
+		context.Background(),
+		"example.com/@v/v1.0.0.zip",
+		time.Hour,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	content, err = g.cache(
+		context.Background(),
+		"example.com/@v/v1.0.0.zip",
+	)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	content.Close()
+
+	if got, want := g.Stats().PeerFetchHits, int64(2); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,159 @@
+package goproxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultStatsName is the default name of the cache that persists the
+	// statistics of a [Goproxy].
+	defaultStatsName = apiPathPrefix + "stats.json"
+
+	// statsExpiration is the expiration of the cache that persists the
+	// statistics of a [Goproxy]. It is rewritten periodically.
+	statsExpiration = 100 * 365 * 24 * time.Hour
+)
+
+// persistedStats is the statistics of a [Goproxy] persisted by a
+// [StatsPersister].
+type persistedStats struct {
+	Stats           Stats
+	ModuleDownloads []ModuleDownloads
+	UpstreamUsage   []UpstreamUsage
+}
+
+// StatsPersister periodically persists the statistics of a [Goproxy] (its
+// [Stats], [ModuleDownloads] and [UpstreamUsage]) into its [Goproxy.Cacher],
+// so that they survive restarts instead of being reset to zero on every
+// deploy.
+//
+// Instances sharing the same Goproxy.Cacher must use distinct names, since
+// each persists its own statistics.
+type StatsPersister struct {
+	// Goproxy is the [Goproxy] whose statistics are persisted.
+	Goproxy *Goproxy
+
+	// Name is the name of the cache that persists the statistics.
+	//
+	// If the Name is empty, "-/stats.json" is used.
+	Name string
+
+	// Interval is the interval between two saves.
+	//
+	// If the Interval is zero, one minute is used.
+	Interval time.Duration
+
+	restoreMutex sync.Mutex
+	restored     bool
+}
+
+// Run restores the statistics persisted for the [StatsPersister.Goproxy] and
+// saves them periodically until the ctx is done, after which they are saved
+// one last time.
+func (sp *StatsPersister) Run(ctx context.Context) error {
+	g := sp.Goproxy
+	if err := sp.Restore(ctx); err != nil {
+		g.logErrorf("failed to restore stats: %v", err)
+	}
+
+	interval := sp.Interval
+	if interval == 0 {
+		interval = time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := sp.Save(ctx); err != nil &&
+				!errors.Is(err, ctx.Err()) {
+				g.logErrorf("failed to save stats: %v", err)
+			}
+		case <-ctx.Done():
+			if err := sp.Save(context.Background()); err != nil {
+				g.logErrorf("failed to save stats: %v", err)
+			}
+
+			return ctx.Err()
+		}
+	}
+}
+
+// name returns the name of the cache that persists the statistics.
+func (sp *StatsPersister) name() string {
+	if sp.Name == "" {
+		return defaultStatsName
+	}
+
+	return sp.Name
+}
+
+// Restore adds the statistics persisted for the [StatsPersister.Goproxy] to
+// its current ones. Once it has succeeded, it does nothing, so that the same
+// statistics are never counted twice.
+func (sp *StatsPersister) Restore(ctx context.Context) error {
+	sp.restoreMutex.Lock()
+	defer sp.restoreMutex.Unlock()
+	if sp.restored {
+		return nil
+	}
+
+	g := sp.Goproxy
+	g.initOnce.Do(g.init)
+	b, err := g.cacheBytes(ctx, sp.name())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			sp.restored = true
+			return nil
+		}
+
+		return err
+	}
+
+	var ps persistedStats
+	if err := json.Unmarshal(b, &ps); err != nil {
+		return err
+	}
+
+	g.updateStats(func(s *Stats) { s.add(ps.Stats) })
+	for _, md := range ps.ModuleDownloads {
+		g.moduleDownloads.add(md.Path, md.Downloads)
+	}
+
+	g.upstreamUsages.merge(ps.UpstreamUsage, time.Now())
+	sp.restored = true
+
+	return nil
+}
+
+// Save persists the current statistics of the [StatsPersister.Goproxy] into
+// its [Goproxy.Cacher]. The persisted statistics are restored first if they
+// have not been yet, so that they are never overwritten before being counted.
+func (sp *StatsPersister) Save(ctx context.Context) error {
+	if err := sp.Restore(ctx); err != nil {
+		return err
+	}
+
+	g := sp.Goproxy
+	if g.Cacher == nil {
+		return errors.New("nil cacher")
+	}
+
+	b, err := json.Marshal(persistedStats{
+		Stats:           g.Stats(),
+		ModuleDownloads: g.moduleDownloads.list(),
+		UpstreamUsage:   g.upstreamUsages.list(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return g.Cacher.Put(ctx, sp.name(), bytes.NewReader(b), statsExpiration)
+}
@@ -0,0 +1,111 @@
+package goproxy
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsPersister(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestStatsPersister")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	newGoproxy := func() *Goproxy {
+		return &Goproxy{
+			Cacher:      DirCacher(tempDir),
+			ErrorLogger: log.New(&discardWriter{}, "", 0),
+		}
+	}
+
+	g := newGoproxy()
+	sp := &StatsPersister{Goproxy: g}
+	if err := sp.Restore(context.Background()); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	g.updateStats(func(s *Stats) { s.DownloadCacheHits = 2 })
+	g.moduleDownloads.add("example.com", 3)
+	g.recordUpstreamUsage("https://example.com", 1, 10)
+	if err := sp.Save(context.Background()); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		g = newGoproxy()
+		g.initOnce.Do(g.init)
+		g.updateStats(func(s *Stats) { s.DownloadCacheHits = 1 })
+		sp = &StatsPersister{Goproxy: g}
+		if err := sp.Save(context.Background()); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		if err := sp.Restore(context.Background()); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		want := int64(3 + i)
+		if got := g.Stats().DownloadCacheHits; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+
+		stats := g.Stats()
+		if got, want := stats.UpstreamRequests, int64(1); got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+
+		mds := g.ModuleDownloads()
+		if got, want := len(mds), 1; got != want {
+			t.Fatalf("got %d, want %d", got, want)
+		} else if got, want := mds[0].Downloads, int64(3); got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+
+		uus := g.UpstreamUsage()
+		if got, want := len(uus), 1; got != want {
+			t.Fatalf("got %d, want %d", got, want)
+		} else if got, want := uus[0].Bytes, int64(10); got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	g = newGoproxy()
+	sp = &StatsPersister{Goproxy: g, Interval: time.Millisecond}
+	done := make(chan error)
+	go func() { done <- sp.Run(ctx) }()
+	time.Sleep(10 * time.Millisecond)
+	g.updateStats(func(s *Stats) { s.DownloadCacheMisses = 5 })
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+
+	b, err := g.cacheBytes(context.Background(), defaultStatsName)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if !strings.Contains(string(b), `"DownloadCacheMisses":5`) {
+		t.Errorf("unexpected stats %s", b)
+	}
+
+	g = newGoproxy()
+	if err := g.Cacher.Put(
+		context.Background(),
+		defaultStatsName,
+		strings.NewReader("{"),
+		time.Hour,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	sp = &StatsPersister{Goproxy: g}
+	if err := sp.Save(context.Background()); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,271 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// pinnedCacheExpiration is the expiration of the caches of the pinned module
+// versions. They are meant to live until unpinned.
+const pinnedCacheExpiration = 100 * 365 * 24 * time.Hour
+
+// pinSet is a set of pins, each in the form "pattern" or "pattern@version"
+// (see the [Goproxy.PinnedModules]). It is safe for concurrent use.
+type pinSet struct {
+	mutex sync.RWMutex
+	pins  []string
+}
+
+// newPinSet returns a new instance of the [pinSet] with the valid pins.
+func newPinSet(pins []string) *pinSet {
+	ps := &pinSet{}
+	for _, pin := range pins {
+		if checkPin(pin) == nil {
+			ps.add(pin)
+		}
+	}
+
+	return ps
+}
+
+// add adds the pin to the ps. It reports whether the pin was not in the ps.
+func (ps *pinSet) add(pin string) bool {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	if stringSliceContains(ps.pins, pin) {
+		return false
+	}
+
+	ps.pins = append(ps.pins, pin)
+	sort.Strings(ps.pins)
+	return true
+}
+
+// remove removes the pin from the ps. It reports whether the pin was in the
+// ps.
+func (ps *pinSet) remove(pin string) bool {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	for i, p := range ps.pins {
+		if p == pin {
+			ps.pins = append(ps.pins[:i], ps.pins[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// list returns the pins in the ps in lexical order.
+func (ps *pinSet) list() []string {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+	return append([]string{}, ps.pins...)
+}
+
+// matches reports whether any pin in the ps matches the modulePath at the
+// moduleVersion.
+func (ps *pinSet) matches(modulePath, moduleVersion string) bool {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+	for _, pin := range ps.pins {
+		if pinMatches(pin, modulePath, moduleVersion) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkPin checks whether the pin is valid.
+func checkPin(pin string) error {
+	pattern, version := pin, ""
+	if i := strings.LastIndex(pin, "@"); i >= 0 {
+		pattern, version = pin[:i], pin[i+1:]
+		if !semver.IsValid(version) ||
+			semver.Canonical(version) != version {
+			return errors.New("invalid pin version")
+		}
+	}
+
+	if pattern == "" || strings.Contains(pattern, ",") {
+		return errors.New("invalid pin pattern")
+	} else if _, err := path.Match(pattern, ""); err != nil {
+		return errors.New("invalid pin pattern")
+	}
+
+	return nil
+}
+
+// pinMatches reports whether the pin matches the modulePath at the
+// moduleVersion.
+func pinMatches(pin, modulePath, moduleVersion string) bool {
+	pattern, version := pin, ""
+	if i := strings.LastIndex(pin, "@"); i >= 0 {
+		pattern, version = pin[:i], pin[i+1:]
+	}
+
+	return (version == "" || version == moduleVersion) &&
+		globsMatchPath(pattern, modulePath)
+}
+
+// parseModuleVersionName parses the name as targeting a module file (".info",
+// ".mod" or ".zip") and returns its module path and module version.
+func parseModuleVersionName(name string) (string, string, bool) {
+	if strings.HasPrefix(name, "sumdb/") ||
+		strings.HasPrefix(name, apiPathPrefix) {
+		return "", "", false
+	}
+
+	nameParts := strings.SplitN(name, "/@v/", 2)
+	if len(nameParts) != 2 {
+		return "", "", false
+	}
+
+	nameExt := path.Ext(nameParts[1])
+	switch nameExt {
+	case ".info", ".mod", ".zip":
+	default:
+		return "", "", false
+	}
+
+	modulePath, err := module.UnescapePath(nameParts[0])
+	if err != nil {
+		return "", "", false
+	}
+
+	moduleVersion, err := module.UnescapeVersion(
+		strings.TrimSuffix(nameParts[1], nameExt),
+	)
+	if err != nil {
+		return "", "", false
+	}
+
+	return modulePath, moduleVersion, true
+}
+
+// Pinned reports whether the cache for the name is pinned by the
+// [Goproxy.Pins]. It is typically used as the [RetentionCacher.Pinned] or the
+// [QuotaCacher.Pinned].
+func (g *Goproxy) Pinned(name string) bool {
+	g.initOnce.Do(g.init)
+	return g.pinned(name)
+}
+
+// pinned is like the [Goproxy.Pinned], but does not initialize the g.
+func (g *Goproxy) pinned(name string) bool {
+	if g.pins == nil {
+		return false
+	}
+
+	modulePath, moduleVersion, ok := parseModuleVersionName(name)
+	return ok && g.pins.matches(modulePath, moduleVersion)
+}
+
+// Pins returns the current pins of the g in lexical order.
+func (g *Goproxy) Pins() []string {
+	g.initOnce.Do(g.init)
+	return g.pins.list()
+}
+
+// Pin adds the pin (see the [Goproxy.PinnedModules]) to the g and renews the
+// expirations of the existing caches it matches. Caches are only renewed if
+// the [Goproxy.Cacher] can enumerate its caches, otherwise they are pinned
+// once put again.
+func (g *Goproxy) Pin(ctx context.Context, pin string) error {
+	g.initOnce.Do(g.init)
+	if err := checkPin(pin); err != nil {
+		return notFoundError(err.Error())
+	}
+
+	if !g.pins.add(pin) {
+		return nil
+	}
+
+	return g.renewPinCaches(ctx, pin)
+}
+
+// Unpin removes the pin from the g and lets the existing caches it matches
+// expire like any other caches, unless they are still matched by another pin.
+func (g *Goproxy) Unpin(ctx context.Context, pin string) error {
+	g.initOnce.Do(g.init)
+	if !g.pins.remove(pin) {
+		return notFoundError("pin not found")
+	}
+
+	return g.renewPinCaches(ctx, pin)
+}
+
+// renewPinCaches renews the expirations of the existing caches matched by the
+// pin according to whether they are currently pinned. It does nothing if the
+// g.Cacher cannot enumerate its caches.
+func (g *Goproxy) renewPinCaches(ctx context.Context, pin string) error {
+	cw, ok := cacheWalkerOf(g.Cacher)
+	if !ok {
+		return nil
+	}
+
+	var names []string
+	if err := cw.walkCaches(func(name string, size int64) error {
+		modulePath, moduleVersion, ok := parseModuleVersionName(name)
+		if ok && pinMatches(pin, modulePath, moduleVersion) {
+			names = append(names, name)
+		}
+
+		return ctx.Err()
+	}); err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		expiration := pinnedCacheExpiration
+		if !g.pinned(name) {
+			_, moduleVersion, _ := parseModuleVersionName(name)
+			expiration = g.versionCacheExpiration(
+				defaultCacheExpiration,
+				moduleVersion,
+			)
+		}
+
+		if err := g.copyCache(
+			ctx,
+			name,
+			name,
+			expiration,
+		); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// servePins serves pins requests.
+func (g *Goproxy) servePins(rw http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
+		err := g.Pin(req.Context(), req.URL.Query().Get("pin"))
+		if err != nil {
+			g.serveAdminError(rw, req, "pin module", err)
+			return
+		}
+	case http.MethodDelete:
+		err := g.Unpin(req.Context(), req.URL.Query().Get("pin"))
+		if err != nil {
+			g.serveAdminError(rw, req, "unpin module", err)
+			return
+		}
+	}
+
+	responseJSON(rw, req, -2, struct{ Pins []string }{g.Pins()})
+}
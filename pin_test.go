@@ -0,0 +1,304 @@
+package goproxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckPin(t *testing.T) {
+	for _, tt := range []struct {
+		pin     string
+		wantErr bool
+	}{
+		{"example.com/foo", false},
+		{"example.com/foo@v1.0.0", false},
+		{"*.example.com", false},
+		{"golang.org/toolchain@v0.0.1-go1.21.0.linux-amd64", false},
+		{"", true},
+		{"@v1.0.0", true},
+		{"example.com/foo@", true},
+		{"example.com/foo@v1", true},
+		{"example.com/foo@latest", true},
+		{"example.com/foo,example.com/bar", true},
+		{"example.com/[", true},
+	} {
+		if err := checkPin(tt.pin); (err != nil) != tt.wantErr {
+			t.Errorf("checkPin(%q): got error %v", tt.pin, err)
+		}
+	}
+}
+
+func TestPinMatches(t *testing.T) {
+	for _, tt := range []struct {
+		pin           string
+		modulePath    string
+		moduleVersion string
+		want          bool
+	}{
+		{"example.com/foo", "example.com/foo", "v1.0.0", true},
+		{"example.com/foo", "example.com/foo/bar", "v1.0.0", true},
+		{"example.com/foo", "example.com/foobar", "v1.0.0", false},
+		{"*.example.com", "foo.example.com/bar", "v1.0.0", true},
+		{"example.com/foo@v1.0.0", "example.com/foo", "v1.0.0", true},
+		{"example.com/foo@v1.0.0", "example.com/foo", "v1.1.0", false},
+	} {
+		if got := pinMatches(
+			tt.pin,
+			tt.modulePath,
+			tt.moduleVersion,
+		); got != tt.want {
+			t.Errorf(
+				"pinMatches(%q, %q, %q): got %t, want %t",
+				tt.pin,
+				tt.modulePath,
+				tt.moduleVersion,
+				got,
+				tt.want,
+			)
+		}
+	}
+}
+
+func TestParseModuleVersionName(t *testing.T) {
+	for _, tt := range []struct {
+		name              string
+		wantModulePath    string
+		wantModuleVersion string
+		wantOK            bool
+	}{
+		{"example.com/@v/v1.0.0.info", "example.com", "v1.0.0", true},
+		{"example.com/!a/@v/v1.mod", "example.com/A", "v1", true},
+		{"example.com/@v/v1.0.0.zip", "example.com", "v1.0.0", true},
+		{"example.com/@v/list", "", "", false},
+		{"example.com/@latest", "", "", false},
+		{"sumdb/sum.golang.org/supported", "", "", false},
+		{"-/trash/1/example.com/@v/v1.0.0.zip", "", "", false},
+		{"example.com/@v/v1.0.0.txt", "", "", false},
+	} {
+		modulePath, moduleVersion, ok := parseModuleVersionName(tt.name)
+		if modulePath != tt.wantModulePath ||
+			moduleVersion != tt.wantModuleVersion ||
+			ok != tt.wantOK {
+			t.Errorf(
+				"parseModuleVersionName(%q): got %q, %q, %t",
+				tt.name,
+				modulePath,
+				moduleVersion,
+				ok,
+			)
+		}
+	}
+}
+
+func TestGoproxyPin(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestGoproxyPin")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	g := &Goproxy{
+		Cacher:        DirCacher(tempDir),
+		TempDir:       tempDir,
+		PinnedModules: []string{"example.com/foo", "example.com/[", ""},
+	}
+	pins := strings.Join(g.Pins(), ",")
+	if got, want := pins, "example.com/foo"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	expiration := func(name string) time.Duration {
+		meta, err := readDirCacheMetadata(filepath.Join(
+			tempDir,
+			filepath.FromSlash(name),
+		))
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		return time.Until(meta.Expires)
+	}
+
+	for _, name := range []string{
+		"example.com/foo/@v/v1.0.0.info",
+		"example.com/foo/@v/list",
+		"example.com/bar/@v/v1.0.0.info",
+		"example.com/bar/@v/v1.1.0.info",
+	} {
+		if err := g.putCache(
+			context.Background(),
+			name,
+			strings.NewReader("foobar"),
+			time.Hour,
+		); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	got := expiration("example.com/foo/@v/v1.0.0.info")
+	if got < 24*time.Hour {
+		t.Errorf("got %s, want >= 24h", got)
+	}
+
+	if got := expiration("example.com/foo/@v/list"); got > time.Hour {
+		t.Errorf("got %s, want <= 1h", got)
+	}
+
+	if !g.Pinned("example.com/foo/@v/v1.0.0.zip") {
+		t.Error("expected true")
+	}
+
+	if g.Pinned("example.com/bar/@v/v1.0.0.zip") {
+		t.Error("expected false")
+	}
+
+	if err := g.Pin(
+		context.Background(),
+		"example.com/bar@v1.0.0",
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	got = expiration("example.com/bar/@v/v1.0.0.info")
+	if got < 24*time.Hour {
+		t.Errorf("got %s, want >= 24h", got)
+	}
+
+	got = expiration("example.com/bar/@v/v1.1.0.info")
+	if got > time.Hour {
+		t.Errorf("got %s, want <= 1h", got)
+	}
+
+	if got, want := strings.Join(
+		g.Pins(),
+		",",
+	), "example.com/bar@v1.0.0,example.com/foo"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	err = g.Pin(context.Background(), "example.com/bar@v1")
+	if err == nil {
+		t.Fatal("expected error")
+	} else if got, want := err, errNotFound; !errors.Is(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if err := g.Unpin(
+		context.Background(),
+		"example.com/bar@v1.0.0",
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	got = expiration("example.com/bar/@v/v1.0.0.info")
+	if got > time.Hour {
+		t.Errorf("got %s, want <= 1h", got)
+	}
+
+	if err := g.Unpin(
+		context.Background(),
+		"example.com/bar@v1.0.0",
+	); err == nil {
+		t.Fatal("expected error")
+	} else if got, want := err, errNotFound; !errors.Is(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	g = &Goproxy{Cacher: &errorCacher{}}
+	if err := g.Pin(context.Background(), "example.com"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+}
+
+func TestGoproxyServePins(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestGoproxyServePins")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	g := &Goproxy{
+		Cacher:  DirCacher(tempDir),
+		TempDir: tempDir,
+		AdminAuthorizer: func(*http.Request) bool {
+			return true
+		},
+	}
+
+	for _, tt := range []struct {
+		method   string
+		target   string
+		wantCode int
+		wantPins string
+	}{
+		{http.MethodGet, "/-/pins", http.StatusOK, ""},
+		{
+			http.MethodPost,
+			"/-/pins?pin=example.com/foo",
+			http.StatusOK,
+			"example.com/foo",
+		},
+		{http.MethodPost, "/-/pins?pin=", http.StatusNotFound, ""},
+		{
+			http.MethodGet,
+			"/-/pins",
+			http.StatusOK,
+			"example.com/foo",
+		},
+		{
+			http.MethodDelete,
+			"/-/pins?pin=example.com/foo",
+			http.StatusOK,
+			"",
+		},
+		{
+			http.MethodDelete,
+			"/-/pins?pin=example.com/foo",
+			http.StatusNotFound,
+			"",
+		},
+		{http.MethodPut, "/-/pins", http.StatusMethodNotAllowed, ""},
+	} {
+		req := httptest.NewRequest(tt.method, tt.target, nil)
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, req)
+		if got, want := rec.Code, tt.wantCode; got != want {
+			t.Fatalf(
+				"%s %s: got %d, want %d",
+				tt.method,
+				tt.target,
+				got,
+				want,
+			)
+		}
+
+		if rec.Code != http.StatusOK {
+			continue
+		}
+
+		var r struct{ Pins []string }
+		if err := json.NewDecoder(rec.Body).Decode(&r); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		got := strings.Join(r.Pins, ",")
+		if want := tt.wantPins; got != want {
+			t.Errorf(
+				"%s %s: got %q, want %q",
+				tt.method,
+				tt.target,
+				got,
+				want,
+			)
+		}
+	}
+}
@@ -0,0 +1,529 @@
+package goproxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/mod/module"
+)
+
+const (
+	// defaultMaxReadAheads is the default value of the
+	// [Goproxy.MaxReadAheads].
+	defaultMaxReadAheads = 8
+
+	// prefetchQueueName is the name of the cache that persists the
+	// prefetch queue (see the [Goproxy.PrefetchMaxAttempts]).
+	prefetchQueueName = apiPathPrefix + "prefetch-queue.json"
+
+	// prefetchQueueExpiration is the expiration of the cache that persists
+	// the prefetch queue. It is rewritten on every change.
+	prefetchQueueExpiration = 100 * 365 * 24 * time.Hour
+
+	// maxPrefetchDeadLetters is the maximum number of dead letters kept in
+	// the prefetch queue. The oldest ones are dropped first.
+	maxPrefetchDeadLetters = 100
+)
+
+var (
+	// prefetchRetryBaseDelay and prefetchRetryMaxDelay are the base and
+	// the maximum delays of the exponential backoff between the attempts
+	// of a prefetch.
+	prefetchRetryBaseDelay = 10 * time.Second
+	prefetchRetryMaxDelay  = 10 * time.Minute
+)
+
+// PrefetchJob is a prefetch of a module file tracked by the prefetch queue of
+// a [Goproxy] (see the [Goproxy.PrefetchMaxAttempts]).
+type PrefetchJob struct {
+	// Name is the cache name of the module file.
+	Name string
+
+	// Expiration is the expiration of the cache of the module file.
+	Expiration time.Duration
+
+	// Attempts is the number of failed attempts.
+	Attempts int
+
+	// NextAttempt is the time of the next attempt. It is zero for the
+	// dead letters.
+	NextAttempt time.Time
+
+	// LastError is the error of the last failed attempt, if any.
+	LastError string `json:",omitempty"`
+}
+
+// PrefetchQueue is the state of the prefetch queue of a [Goproxy].
+type PrefetchQueue struct {
+	// Pending are the prefetches running or waiting to be retried.
+	Pending []PrefetchJob
+
+	// DeadLetters are the prefetches that have failed too many times,
+	// the most recent last.
+	DeadLetters []PrefetchJob
+}
+
+// readAhead prefetches the module files of the modulePath at the moduleVersion
+// with the [Goproxy.ReadAheadExts] in the background.
+func (g *Goproxy) readAhead(
+	modulePath string,
+	moduleVersion string,
+	expiration time.Duration,
+) {
+	if len(g.ReadAheadExts) == 0 {
+		return
+	}
+
+	escapedModulePath, err := module.EscapePath(modulePath)
+	if err != nil {
+		return
+	}
+
+	escapedModuleVersion, err := module.EscapeVersion(moduleVersion)
+	if err != nil {
+		return
+	}
+
+	expiration = g.versionCacheExpiration(expiration, moduleVersion)
+	for _, ext := range g.ReadAheadExts {
+		name := fmt.Sprint(
+			escapedModulePath,
+			"/@v/",
+			escapedModuleVersion,
+			ext,
+		)
+		job := PrefetchJob{Name: name, Expiration: expiration}
+		if _, ok := g.prefetchQueue.get(name); ok ||
+			!g.startPrefetch(job) {
+			g.updateStats(func(s *Stats) { s.ReadAheadsDropped++ })
+			continue
+		}
+
+		g.updateStats(func(s *Stats) { s.ReadAheadsStarted++ })
+	}
+}
+
+// startPrefetch starts the job in the background. It returns false if the job
+// is dropped because of the [Goproxy.MaxReadAheads] or because the same module
+// file is already being prefetched.
+func (g *Goproxy) startPrefetch(job PrefetchJob) bool {
+	if !g.prefetches.start(job.Name) {
+		return false
+	}
+
+	go func() {
+		if g.PrefetchMaxAttempts > 0 {
+			g.prefetchQueue.put(job)
+			g.savePrefetchQueue()
+		}
+
+		err := g.prefetch(
+			context.Background(),
+			job.Name,
+			job.Expiration,
+		)
+		g.prefetches.done(job.Name)
+		g.finishPrefetch(job, err)
+	}()
+
+	return true
+}
+
+// finishPrefetch handles the err of an attempt of the job, retrying it later
+// or moving it to the dead letters if the [Goproxy.PrefetchMaxAttempts] allows.
+func (g *Goproxy) finishPrefetch(job PrefetchJob, err error) {
+	if err == nil ||
+		errors.Is(err, errNotFound) ||
+		g.PrefetchMaxAttempts <= 0 {
+		if err != nil {
+			g.updateStats(func(s *Stats) { s.ReadAheadsFailed++ })
+			if !errors.Is(err, errNotFound) {
+				g.logErrorf(
+					"failed to read ahead: %s: %v",
+					job.Name,
+					err,
+				)
+			}
+		}
+
+		if g.PrefetchMaxAttempts > 0 {
+			g.prefetchQueue.remove(job.Name)
+			g.savePrefetchQueue()
+		}
+
+		return
+	}
+
+	job.Attempts++
+	job.LastError = err.Error()
+	if job.Attempts >= g.PrefetchMaxAttempts {
+		g.updateStats(func(s *Stats) { s.ReadAheadsFailed++ })
+		g.logErrorf(
+			"failed to read ahead after %d attempts: %s: %v",
+			job.Attempts,
+			job.Name,
+			err,
+		)
+		job.NextAttempt = time.Time{}
+		g.prefetchQueue.bury(job)
+		g.savePrefetchQueue()
+		return
+	}
+
+	g.updateStats(func(s *Stats) { s.ReadAheadsRetried++ })
+	delay := exponentialBackoffSleep(
+		prefetchRetryBaseDelay,
+		prefetchRetryMaxDelay,
+		job.Attempts,
+	)
+	job.NextAttempt = time.Now().Add(delay)
+	g.prefetchQueue.put(job)
+	g.savePrefetchQueue()
+	g.schedulePrefetch(job, delay)
+}
+
+// schedulePrefetch starts the job after the delay. If the job is dropped, it is
+// scheduled again.
+func (g *Goproxy) schedulePrefetch(job PrefetchJob, delay time.Duration) {
+	time.AfterFunc(delay, func() {
+		if !g.startPrefetch(job) {
+			g.schedulePrefetch(job, prefetchRetryBaseDelay)
+		}
+	})
+}
+
+// prefetch fetches the module file targeted by the name into the
+// [Goproxy.Cacher] with the expiration, unless it has already been cached.
+func (g *Goproxy) prefetch(
+	ctx context.Context,
+	name string,
+	expiration time.Duration,
+) error {
+	if g.Cacher == nil {
+		return nil
+	}
+
+	ctx = withBulk(ctx)
+	if content, err := g.cache(ctx, name); err == nil {
+		content.Close()
+		return nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	tempDir, err := ioutil.TempDir(g.TempDir, "goproxy")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	if g.fetchFromPeers(ctx, name, tempDir, expiration) != "" {
+		return nil
+	}
+
+	f, err := newFetch(g, name, tempDir)
+	if err != nil {
+		return err
+	}
+
+	fr, err := f.do(ctx)
+	if err != nil {
+		return err
+	}
+
+	return g.putFetchResultCaches(ctx, f, fr, expiration)
+}
+
+// prefetchSet is a bounded set of the names of the in-flight prefetches. It is
+// safe for concurrent use.
+type prefetchSet struct {
+	mutex sync.Mutex
+	max   int
+	names map[string]bool
+}
+
+// newPrefetchSet returns a new instance of the [prefetchSet] that holds at
+// most max names.
+func newPrefetchSet(max int) *prefetchSet {
+	return &prefetchSet{max: max, names: map[string]bool{}}
+}
+
+// start adds the name to the ps. It returns false if the name is already in
+// the ps or the ps is full.
+func (ps *prefetchSet) start(name string) bool {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	if ps.names[name] || len(ps.names) >= ps.max {
+		return false
+	}
+
+	ps.names[name] = true
+	return true
+}
+
+// done removes the name from the ps.
+func (ps *prefetchSet) done(name string) {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	delete(ps.names, name)
+}
+
+// prefetchQueue is the queue of the prefetches tracked for retries. It is safe
+// for concurrent use.
+type prefetchQueue struct {
+	mutex       sync.Mutex
+	pending     map[string]PrefetchJob
+	deadLetters []PrefetchJob
+	saveMutex   sync.Mutex
+}
+
+// newPrefetchQueue returns a new instance of the [prefetchQueue].
+func newPrefetchQueue() *prefetchQueue {
+	return &prefetchQueue{pending: map[string]PrefetchJob{}}
+}
+
+// get returns the pending job for the name.
+func (pq *prefetchQueue) get(name string) (PrefetchJob, bool) {
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+	job, ok := pq.pending[name]
+	return job, ok
+}
+
+// put puts the job into the pending jobs of the pq.
+func (pq *prefetchQueue) put(job PrefetchJob) {
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+	pq.pending[job.Name] = job
+}
+
+// remove removes the pending job for the name from the pq.
+func (pq *prefetchQueue) remove(name string) {
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+	delete(pq.pending, name)
+}
+
+// bury moves the job from the pending jobs to the dead letters of the pq.
+func (pq *prefetchQueue) bury(job PrefetchJob) {
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+	delete(pq.pending, job.Name)
+	pq.buryLocked(job)
+}
+
+// buryLocked is like the [prefetchQueue.bury], but must be called with the
+// pq.mutex held and does not touch the pending jobs.
+func (pq *prefetchQueue) buryLocked(job PrefetchJob) {
+	for i, dl := range pq.deadLetters {
+		if dl.Name == job.Name {
+			pq.deadLetters = append(
+				pq.deadLetters[:i],
+				pq.deadLetters[i+1:]...,
+			)
+			break
+		}
+	}
+
+	pq.deadLetters = append(pq.deadLetters, job)
+	if n := len(pq.deadLetters) - maxPrefetchDeadLetters; n > 0 {
+		pq.deadLetters = append(
+			pq.deadLetters[:0:0],
+			pq.deadLetters[n:]...,
+		)
+	}
+}
+
+// unbury removes the dead letter for the name, or all of them if the name is
+// empty, from the pq and returns them.
+func (pq *prefetchQueue) unbury(name string) []PrefetchJob {
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+	var jobs, kept []PrefetchJob
+	for _, dl := range pq.deadLetters {
+		if name == "" || dl.Name == name {
+			jobs = append(jobs, dl)
+		} else {
+			kept = append(kept, dl)
+		}
+	}
+
+	pq.deadLetters = kept
+	return jobs
+}
+
+// snapshot returns the state of the pq. The pending jobs are sorted by their
+// names.
+func (pq *prefetchQueue) snapshot() PrefetchQueue {
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+	s := PrefetchQueue{
+		Pending:     make([]PrefetchJob, 0, len(pq.pending)),
+		DeadLetters: append([]PrefetchJob{}, pq.deadLetters...),
+	}
+	for _, job := range pq.pending {
+		s.Pending = append(s.Pending, job)
+	}
+
+	sort.Slice(s.Pending, func(i, j int) bool {
+		return s.Pending[i].Name < s.Pending[j].Name
+	})
+
+	return s
+}
+
+// PrefetchQueue returns the state of the prefetch queue of the g (see the
+// [Goproxy.PrefetchMaxAttempts]).
+func (g *Goproxy) PrefetchQueue() PrefetchQueue {
+	g.initOnce.Do(g.init)
+	return g.prefetchQueue.snapshot()
+}
+
+// ResumePrefetches loads the prefetch queue persisted in the
+// [Goproxy.Cacher] by a previous run of the g (see the
+// [Goproxy.PrefetchMaxAttempts]) and schedules its pending prefetches. It is
+// typically called once at startup.
+func (g *Goproxy) ResumePrefetches(ctx context.Context) error {
+	g.initOnce.Do(g.init)
+	content, err := g.cache(ctx, prefetchQueueName)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return err
+	}
+
+	var s PrefetchQueue
+	err = json.NewDecoder(content).Decode(&s)
+	content.Close()
+	if err != nil {
+		return err
+	}
+
+	var resumed []PrefetchJob
+	g.prefetchQueue.mutex.Lock()
+	for _, job := range s.Pending {
+		if _, ok := g.prefetchQueue.pending[job.Name]; !ok {
+			g.prefetchQueue.pending[job.Name] = job
+			resumed = append(resumed, job)
+		}
+	}
+
+	deadLetters := g.prefetchQueue.deadLetters
+	g.prefetchQueue.deadLetters = nil
+	for _, job := range append(s.DeadLetters, deadLetters...) {
+		g.prefetchQueue.buryLocked(job)
+	}
+	g.prefetchQueue.mutex.Unlock()
+
+	for _, job := range resumed {
+		delay := time.Until(job.NextAttempt)
+		if delay < 0 {
+			delay = 0
+		}
+
+		g.schedulePrefetch(job, delay)
+	}
+
+	return nil
+}
+
+// RequeuePrefetches moves the dead letter for the name, or all of them if the
+// name is empty, back to the prefetch queue of the g for immediate retries,
+// and returns their names.
+func (g *Goproxy) RequeuePrefetches(name string) ([]string, error) {
+	g.initOnce.Do(g.init)
+	jobs := g.prefetchQueue.unbury(name)
+	if name != "" && len(jobs) == 0 {
+		return nil, notFoundError("dead letter not found")
+	}
+
+	names := make([]string, 0, len(jobs))
+	for _, job := range jobs {
+		job.Attempts = 0
+		job.NextAttempt = time.Now()
+		g.prefetchQueue.put(job)
+		g.schedulePrefetch(job, 0)
+		names = append(names, job.Name)
+	}
+
+	g.savePrefetchQueue()
+	return names, nil
+}
+
+// DiscardPrefetches removes the dead letter for the name, or all of them if the
+// name is empty, from the prefetch queue of the g and returns their names.
+func (g *Goproxy) DiscardPrefetches(name string) ([]string, error) {
+	g.initOnce.Do(g.init)
+	jobs := g.prefetchQueue.unbury(name)
+	if name != "" && len(jobs) == 0 {
+		return nil, notFoundError("dead letter not found")
+	}
+
+	names := make([]string, 0, len(jobs))
+	for _, job := range jobs {
+		names = append(names, job.Name)
+	}
+
+	g.savePrefetchQueue()
+	return names, nil
+}
+
+// savePrefetchQueue persists the prefetch queue of the g into the g.Cacher.
+// Failures are logged.
+func (g *Goproxy) savePrefetchQueue() {
+	if g.Cacher == nil {
+		return
+	}
+
+	g.prefetchQueue.saveMutex.Lock()
+	defer g.prefetchQueue.saveMutex.Unlock()
+	b, err := json.Marshal(g.prefetchQueue.snapshot())
+	if err != nil {
+		g.logErrorf("failed to marshal prefetch queue: %v", err)
+		return
+	}
+
+	if err := g.Cacher.Put(
+		context.Background(),
+		prefetchQueueName,
+		bytes.NewReader(b),
+		prefetchQueueExpiration,
+	); err != nil {
+		g.logErrorf("failed to save prefetch queue: %v", err)
+	}
+}
+
+// servePrefetches serves prefetch queue requests.
+func (g *Goproxy) servePrefetches(rw http.ResponseWriter, req *http.Request) {
+	var (
+		names []string
+		err   error
+	)
+	switch req.Method {
+	case http.MethodPost:
+		names, err = g.RequeuePrefetches(req.URL.Query().Get("name"))
+	case http.MethodDelete:
+		names, err = g.DiscardPrefetches(req.URL.Query().Get("name"))
+	default:
+		responseJSON(rw, req, -2, g.PrefetchQueue())
+		return
+	}
+
+	if err != nil {
+		g.serveAdminError(rw, req, "update prefetch queue", err)
+		return
+	}
+
+	responseJSON(rw, req, -2, struct{ Names []string }{names})
+}
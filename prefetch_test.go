@@ -0,0 +1,494 @@
+package goproxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGoproxyReadAhead(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(
+		rw http.ResponseWriter,
+		req *http.Request,
+	) {
+		switch req.URL.Path {
+		case "/example.com/@latest", "/example.com/@v/v1.0.0.info":
+			fmt.Fprint(rw, `{"Version":"v1.0.0","Time":"2000-01-01T00:00:00Z"}`)
+		case "/example.com/@v/v1.0.0.mod":
+			fmt.Fprint(rw, "module example.com")
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	for _, path := range []string{
+		"/example.com/@v/v1.0.0.info",
+		"/example.com/@latest",
+	} {
+		tempDir, err := ioutil.TempDir("", "goproxy.TestGoproxyReadAhead")
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		defer os.RemoveAll(tempDir)
+
+		g := &Goproxy{
+			GoBinEnv: []string{
+				"GOPROXY=" + server.URL,
+				"GOSUMDB=off",
+			},
+			Cacher:        DirCacher(tempDir),
+			ReadAheadExts: []string{".mod", ".zip"},
+			ErrorLogger:   log.New(&discardWriter{}, "", 0),
+		}
+		g.init()
+
+		req := httptest.NewRequest("", path, nil)
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, req)
+		if got, want := rec.Code, http.StatusOK; got != want {
+			t.Fatalf("%s: got %d, want %d", path, got, want)
+		}
+
+		deadline := time.Now().Add(10 * time.Second)
+		for time.Now().Before(deadline) {
+			g.prefetches.mutex.Lock()
+			n := len(g.prefetches.names)
+			g.prefetches.mutex.Unlock()
+			if n == 0 {
+				break
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		if got, want := g.Stats().ReadAheadsFailed, int64(1); got != want {
+			t.Errorf("%s: got %d, want %d", path, got, want)
+		}
+
+		content, err := g.cache(
+			context.Background(),
+			"example.com/@v/v1.0.0.mod",
+		)
+		if err != nil {
+			t.Fatalf("%s: unexpected error %q", path, err)
+		}
+
+		b, err := ioutil.ReadAll(content)
+		content.Close()
+		if err != nil {
+			t.Fatalf("%s: unexpected error %q", path, err)
+		} else if got, want := string(b), "module example.com"; got != want {
+			t.Errorf("%s: got %q, want %q", path, got, want)
+		}
+
+		if got, want := g.Stats().ReadAheadsStarted, int64(2); got != want {
+			t.Errorf("%s: got %d, want %d", path, got, want)
+		}
+	}
+}
+
+func TestGoproxyReadAheadRetry(t *testing.T) {
+	defer func(base, max time.Duration) {
+		prefetchRetryBaseDelay = base
+		prefetchRetryMaxDelay = max
+	}(prefetchRetryBaseDelay, prefetchRetryMaxDelay)
+	prefetchRetryBaseDelay = time.Millisecond
+	prefetchRetryMaxDelay = time.Millisecond
+
+	tempDir, err := ioutil.TempDir("", "goproxy.TestGoproxyReadAheadRetry")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	var available int32
+	server := httptest.NewServer(http.HandlerFunc(func(
+		rw http.ResponseWriter,
+		req *http.Request,
+	) {
+		switch req.URL.Path {
+		case "/example.com/@v/v1.0.0.info":
+			fmt.Fprint(rw, `{"Version":"v1.0.0","Time":"2000-01-01T00:00:00Z"}`)
+		case "/example.com/@v/v1.0.0.mod":
+			if atomic.LoadInt32(&available) == 0 {
+				rw.WriteHeader(http.StatusTeapot)
+				return
+			}
+
+			fmt.Fprint(rw, "module example.com")
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	newGoproxy := func() *Goproxy {
+		return &Goproxy{
+			GoBinEnv: []string{
+				"GOPROXY=" + server.URL,
+				"GOSUMDB=off",
+			},
+			Cacher:              DirCacher(tempDir),
+			TempDir:             tempDir,
+			ReadAheadExts:       []string{".mod"},
+			PrefetchMaxAttempts: 3,
+			ErrorLogger:         log.New(&discardWriter{}, "", 0),
+		}
+	}
+
+	waitForQueue := func(g *Goproxy, pending, deadLetters int) {
+		deadline := time.Now().Add(10 * time.Second)
+		for time.Now().Before(deadline) {
+			pq := g.PrefetchQueue()
+			if len(pq.Pending) == pending &&
+				len(pq.DeadLetters) == deadLetters {
+				return
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		t.Fatalf("timed out waiting for %+v", g.PrefetchQueue())
+	}
+
+	g := newGoproxy()
+	req := httptest.NewRequest("", "/example.com/@v/v1.0.0.info", nil)
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, req)
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	waitForQueue(g, 0, 1)
+
+	stats := g.Stats()
+	if got, want := stats.ReadAheadsRetried, int64(2); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	if got, want := stats.ReadAheadsFailed, int64(1); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	dl := g.PrefetchQueue().DeadLetters[0]
+	if got, want := dl.Name, "example.com/@v/v1.0.0.mod"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if got, want := dl.Attempts, 3; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	if dl.LastError == "" {
+		t.Error("expected non-empty last error")
+	}
+
+	g = newGoproxy()
+	if err := g.ResumePrefetches(context.Background()); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	pq := g.PrefetchQueue()
+	if got, want := len(pq.DeadLetters), 1; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	_, err = g.RequeuePrefetches("example.com/@v/v1.1.0.mod")
+	if err == nil {
+		t.Fatal("expected error")
+	} else if got, want := err, errNotFound; !errors.Is(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	atomic.StoreInt32(&available, 1)
+	names, err := g.RequeuePrefetches("")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := strings.Join(
+		names,
+		",",
+	), "example.com/@v/v1.0.0.mod"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	waitForQueue(g, 0, 0)
+
+	content, err := g.cache(
+		context.Background(),
+		"example.com/@v/v1.0.0.mod",
+	)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	content.Close()
+
+	g = newGoproxy()
+	if err := g.ResumePrefetches(context.Background()); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	pq = g.PrefetchQueue()
+	if got, want := len(pq.Pending)+len(pq.DeadLetters), 0; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
+
+func TestGoproxyResumePrefetches(t *testing.T) {
+	tempDir, err := ioutil.TempDir(
+		"",
+		"goproxy.TestGoproxyResumePrefetches",
+	)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	g := &Goproxy{
+		GoBinEnv:            []string{"GOPROXY=off"},
+		Cacher:              DirCacher(tempDir),
+		PrefetchMaxAttempts: 1,
+	}
+	if err := g.ResumePrefetches(context.Background()); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if err := g.Cacher.Put(
+		context.Background(),
+		prefetchQueueName,
+		strings.NewReader("{"),
+		time.Hour,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if err := g.ResumePrefetches(context.Background()); err == nil {
+		t.Fatal("expected error")
+	}
+
+	g = &Goproxy{Cacher: &errorCacher{}}
+	if err := g.ResumePrefetches(context.Background()); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestGoproxyServePrefetches(t *testing.T) {
+	g := &Goproxy{
+		AdminAuthorizer: func(*http.Request) bool {
+			return true
+		},
+	}
+	g.initOnce.Do(g.init)
+	g.prefetchQueue.bury(PrefetchJob{Name: "example.com/@v/v1.0.0.mod"})
+	g.prefetchQueue.bury(PrefetchJob{Name: "example.com/@v/v1.1.0.mod"})
+
+	for _, tt := range []struct {
+		method    string
+		target    string
+		wantCode  int
+		wantNames string
+	}{
+		{http.MethodGet, "/-/prefetches", http.StatusOK, ""},
+		{
+			http.MethodDelete,
+			"/-/prefetches?name=example.com/@v/v1.0.0.mod",
+			http.StatusOK,
+			"example.com/@v/v1.0.0.mod",
+		},
+		{
+			http.MethodDelete,
+			"/-/prefetches?name=example.com/@v/v1.0.0.mod",
+			http.StatusNotFound,
+			"",
+		},
+		{
+			http.MethodPost,
+			"/-/prefetches?name=example.com/@v/v1.0.0.mod",
+			http.StatusNotFound,
+			"",
+		},
+		{
+			http.MethodDelete,
+			"/-/prefetches",
+			http.StatusOK,
+			"example.com/@v/v1.1.0.mod",
+		},
+		{
+			http.MethodPut,
+			"/-/prefetches",
+			http.StatusMethodNotAllowed,
+			"",
+		},
+	} {
+		req := httptest.NewRequest(tt.method, tt.target, nil)
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, req)
+		if got, want := rec.Code, tt.wantCode; got != want {
+			t.Fatalf(
+				"%s %s: got %d, want %d",
+				tt.method,
+				tt.target,
+				got,
+				want,
+			)
+		}
+
+		if rec.Code != http.StatusOK || tt.method == http.MethodGet {
+			continue
+		}
+
+		var r struct{ Names []string }
+		if err := json.NewDecoder(rec.Body).Decode(&r); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		got := strings.Join(r.Names, ",")
+		if want := tt.wantNames; got != want {
+			t.Errorf(
+				"%s %s: got %q, want %q",
+				tt.method,
+				tt.target,
+				got,
+				want,
+			)
+		}
+	}
+
+	req := httptest.NewRequest("", "/-/prefetches", nil)
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, req)
+	var pq PrefetchQueue
+	if err := json.NewDecoder(rec.Body).Decode(&pq); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := len(pq.DeadLetters), 0; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
+
+func TestGoproxyPrefetch(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestGoproxyPrefetch")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	g := &Goproxy{
+		GoBinEnv: []string{"GOPROXY=off"},
+		Cacher:   DirCacher(tempDir),
+	}
+	g.init()
+
+	if err := g.Cacher.Put(
+		context.Background(),
+		"example.com/@v/v1.0.0.mod",
+		strings.NewReader("module example.com"),
+		time.Hour,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if err := g.prefetch(
+		context.Background(),
+		"example.com/@v/v1.0.0.mod",
+		time.Hour,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if err := g.prefetch(
+		context.Background(),
+		"example.com/@v/v1.1.0.mod",
+		time.Hour,
+	); err == nil {
+		t.Fatal("expected error")
+	}
+
+	g = &Goproxy{}
+	g.init()
+	if err := g.prefetch(
+		context.Background(),
+		"example.com/@v/v1.0.0.mod",
+		time.Hour,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+}
+
+func TestPrefetchSet(t *testing.T) {
+	ps := newPrefetchSet(2)
+	if !ps.start("a") {
+		t.Error("expected true")
+	}
+
+	if ps.start("a") {
+		t.Error("expected false")
+	}
+
+	if !ps.start("b") {
+		t.Error("expected true")
+	}
+
+	if ps.start("c") {
+		t.Error("expected false")
+	}
+
+	ps.done("a")
+	if !ps.start("c") {
+		t.Error("expected true")
+	}
+}
+
+func TestPrefetchQueue(t *testing.T) {
+	pq := newPrefetchQueue()
+	pq.put(PrefetchJob{Name: "b"})
+	pq.put(PrefetchJob{Name: "a"})
+	if _, ok := pq.get("a"); !ok {
+		t.Error("expected true")
+	}
+
+	pq.bury(PrefetchJob{Name: "a", Attempts: 1})
+	if _, ok := pq.get("a"); ok {
+		t.Error("expected false")
+	}
+
+	for i := 0; i < maxPrefetchDeadLetters; i++ {
+		pq.bury(PrefetchJob{Name: fmt.Sprint("c", i)})
+	}
+
+	s := pq.snapshot()
+	if got, want := len(s.Pending), 1; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	got, want := len(s.DeadLetters), maxPrefetchDeadLetters
+	if got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	if got, want := s.DeadLetters[0].Name, "c0"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if got, want := len(pq.unbury("c1")), 1; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	got, want = len(pq.unbury("")), maxPrefetchDeadLetters-1
+	if got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	if got, want := len(pq.snapshot().DeadLetters), 0; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,386 @@
+package goproxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// preflightCacheName is the name of the cache put and removed by the
+// [Goproxy.Preflight] to check whether the [Goproxy.Cacher] is writable.
+const preflightCacheName = apiPathPrefix + "preflight"
+
+// preflightMinFreeBytes is the number of free bytes below which the
+// [Goproxy.Preflight] reports a file system as full.
+const preflightMinFreeBytes = 1 << 30
+
+// errDiskFreeNotSupported is returned by the [diskFreeBytes] on platforms where
+// the free space of file systems cannot be queried.
+var errDiskFreeNotSupported = errors.New(
+	"querying free disk space is not supported",
+)
+
+// PreflightCheck is the result of a single check run by the
+// [Goproxy.Preflight].
+type PreflightCheck struct {
+	// Name is the name of the check. It is one of "go-bin", "temp-dir",
+	// "cache", "free-space", "upstream" and "sumdb-key".
+	Name string
+
+	// Target is what has been checked (e.g. a path or a URL).
+	Target string `json:",omitempty"`
+
+	// Skipped indicates whether the check has been skipped because it does
+	// not apply to the configuration.
+	Skipped bool `json:",omitempty"`
+
+	// Error is the problem found by the check. It is empty if the check
+	// passed or has been skipped.
+	Error string `json:",omitempty"`
+
+	// Hint is a suggestion on how to fix the Error.
+	Hint string `json:",omitempty"`
+}
+
+// PreflightReport is the report of the [Goproxy.Preflight].
+type PreflightReport struct {
+	// Checks are the checks run, in order.
+	Checks []*PreflightCheck
+}
+
+// Failed returns the checks of the pr that have found problems.
+func (pr *PreflightReport) Failed() []*PreflightCheck {
+	var failed []*PreflightCheck
+	for _, pc := range pr.Checks {
+		if pc.Error != "" {
+			failed = append(failed, pc)
+		}
+	}
+
+	return failed
+}
+
+// Err returns an error describing all checks of the pr that have found
+// problems, or nil if there are none.
+func (pr *PreflightReport) Err() error {
+	failed := pr.Failed()
+	if len(failed) == 0 {
+		return nil
+	}
+
+	msgs := make([]string, 0, len(failed))
+	for _, pc := range failed {
+		msg := fmt.Sprintf("%s: %s", pc.Name, pc.Error)
+		if pc.Target != "" {
+			msg = fmt.Sprintf(
+				"%s: %s: %s",
+				pc.Name,
+				pc.Target,
+				pc.Error,
+			)
+		}
+
+		if pc.Hint != "" {
+			msg = fmt.Sprintf("%s (%s)", msg, pc.Hint)
+		}
+
+		msgs = append(msgs, msg)
+	}
+
+	return fmt.Errorf("preflight failed: %s", strings.Join(msgs, "; "))
+}
+
+// Preflight checks the configuration of the g and the environment it runs in,
+// typically once at startup, so that misconfigurations surface as actionable
+// errors rather than as confusing per-request ones. It checks:
+//   - that the Go binary is available, if it is required to fetch modules
+//     directly (see the "direct" in the GOPROXY and the GONOPROXY);
+//   - that the [Goproxy.TempDir] and the [Goproxy.Cacher] are writable;
+//   - that the file systems of the [Goproxy.TempDir] and of the directory of
+//     a [DirCacher] or a [ShardedDirCacher] have at least 1 GiB free;
+//   - that each proxy in the GOPROXY is reachable;
+//   - that the verifier key of the GOSUMDB is valid.
+//
+// Problems found are reported in the returned [PreflightReport] rather than as
+// an error, see the [PreflightReport.Err].
+func (g *Goproxy) Preflight(ctx context.Context) *PreflightReport {
+	g.initOnce.Do(g.init)
+
+	pr := &PreflightReport{}
+	pr.Checks = append(pr.Checks, g.preflightGoBin(ctx))
+	pr.Checks = append(pr.Checks, g.preflightTempDir())
+	pr.Checks = append(pr.Checks, g.preflightCache(ctx))
+	pr.Checks = append(pr.Checks, g.preflightFreeSpace()...)
+	pr.Checks = append(pr.Checks, g.preflightUpstreams(ctx)...)
+	pr.Checks = append(pr.Checks, g.preflightSUMDBKey(ctx))
+
+	return pr
+}
+
+// preflightGoBin checks whether the Go binary is available.
+func (g *Goproxy) preflightGoBin(ctx context.Context) *PreflightCheck {
+	pc := &PreflightCheck{Name: "go-bin", Target: g.goBinName}
+	if !g.goBinRequired() {
+		pc.Skipped = true
+		return pc
+	}
+
+	cmd, err := g.GoBinSandbox.command(g.goBinName, "version")
+	if err == nil {
+		cmd.Env = g.goBinEnv
+		_, err = commandOutput(ctx, cmd, g.GoBinSandbox.started)
+	}
+
+	if err != nil {
+		pc.Error = err.Error()
+		pc.Hint = "install Go or set the GoBinName to the path of " +
+			"the Go binary"
+	} else if g.goBinVCSErr != nil {
+		pc.Error = g.goBinVCSErr.Error()
+		pc.Hint = "fix the GOVCS"
+	}
+
+	return pc
+}
+
+// goBinRequired reports whether the Go binary may be required to fetch
+// modules.
+func (g *Goproxy) goBinRequired() bool {
+	if g.goBinEnvGONOPROXY != "" {
+		return true
+	}
+
+	for _, proxy := range strings.FieldsFunc(
+		g.goBinEnvGOPROXY,
+		func(r rune) bool { return r == ',' || r == '|' },
+	) {
+		if strings.TrimSpace(proxy) == "direct" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// preflightTempDir checks whether the [Goproxy.TempDir] is writable.
+func (g *Goproxy) preflightTempDir() *PreflightCheck {
+	pc := &PreflightCheck{Name: "temp-dir", Target: g.TempDir}
+	if pc.Target == "" {
+		pc.Target = os.TempDir()
+	}
+
+	tempDir, err := ioutil.TempDir(g.TempDir, "goproxy")
+	if err == nil {
+		err = ioutil.WriteFile(
+			filepath.Join(tempDir, "preflight"),
+			[]byte("preflight"),
+			0600,
+		)
+		os.RemoveAll(tempDir)
+	}
+
+	if err != nil {
+		pc.Error = err.Error()
+		pc.Hint = "make sure the TempDir exists and is writable"
+	}
+
+	return pc
+}
+
+// preflightCache checks whether the [Goproxy.Cacher] is writable and readable
+// by putting, getting and deleting a cache.
+func (g *Goproxy) preflightCache(ctx context.Context) *PreflightCheck {
+	pc := &PreflightCheck{Name: "cache"}
+	if g.Cacher == nil {
+		pc.Skipped = true
+		return pc
+	}
+
+	if err := g.checkCacher(ctx); err != nil {
+		pc.Error = err.Error()
+		pc.Hint = "make sure the Cacher is writable and its " +
+			"credentials are valid"
+	}
+
+	return pc
+}
+
+// checkCacher checks whether the [Goproxy.Cacher] is writable and readable.
+func (g *Goproxy) checkCacher(ctx context.Context) error {
+	content := []byte(fmt.Sprint("preflight ", time.Now().UnixNano()))
+	if err := g.Cacher.Put(
+		ctx,
+		preflightCacheName,
+		bytes.NewReader(content),
+		time.Minute,
+	); err != nil {
+		return err
+	}
+
+	rc, err := g.Cacher.Get(ctx, preflightCacheName)
+	if err != nil {
+		return err
+	}
+
+	b, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return err
+	} else if !bytes.Equal(b, content) {
+		return errors.New("cache read back does not match cache put")
+	}
+
+	if d, ok := g.Cacher.(Deleter); ok {
+		if err := d.Delete(
+			ctx,
+			preflightCacheName,
+		); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// preflightFreeSpace checks whether the file systems of the [Goproxy.TempDir]
+// and of the directory of the [Goproxy.Cacher], if any, have enough free
+// space.
+func (g *Goproxy) preflightFreeSpace() []*PreflightCheck {
+	dirs := []string{g.TempDir}
+	if dirs[0] == "" {
+		dirs[0] = os.TempDir()
+	}
+
+	if dir, ok := cacherDir(g.Cacher); ok {
+		dirs = append(dirs, dir)
+	}
+
+	pcs := make([]*PreflightCheck, 0, len(dirs))
+	for _, dir := range dirs {
+		pc := &PreflightCheck{Name: "free-space", Target: dir}
+		pcs = append(pcs, pc)
+
+		free, err := diskFreeBytes(dir)
+		if errors.Is(err, errDiskFreeNotSupported) ||
+			os.IsNotExist(err) {
+			pc.Skipped = true // Created on demand
+		} else if err != nil {
+			pc.Error = err.Error()
+		} else if free < preflightMinFreeBytes {
+			pc.Error = fmt.Sprintf("only %d bytes free", free)
+			pc.Hint = "free up disk space or set a smaller " +
+				"cache quota"
+		}
+	}
+
+	return pcs
+}
+
+// cacherDir returns the directory of the cacher if it is a [DirCacher] or a
+// [ShardedDirCacher], possibly wrapped by a [QuotaCacher] and a
+// [RetentionCacher].
+func cacherDir(cacher Cacher) (string, bool) {
+	if qc, ok := cacher.(*QuotaCacher); ok {
+		cacher = qc.Cacher
+	}
+
+	if rc, ok := cacher.(*RetentionCacher); ok {
+		cacher = rc.Cacher
+	}
+
+	switch c := cacher.(type) {
+	case DirCacher:
+		return string(c), true
+	case ShardedDirCacher:
+		return string(c), true
+	}
+
+	return "", false
+}
+
+// preflightUpstreams checks whether each proxy in the GOPROXY is reachable.
+func (g *Goproxy) preflightUpstreams(ctx context.Context) []*PreflightCheck {
+	var pcs []*PreflightCheck
+	for _, proxy := range strings.FieldsFunc(
+		g.goBinEnvGOPROXY,
+		func(r rune) bool { return r == ',' || r == '|' },
+	) {
+		if proxy = strings.TrimSpace(proxy); proxy == "direct" ||
+			proxy == "off" {
+			continue
+		}
+
+		pc := &PreflightCheck{Name: "upstream", Target: proxy}
+		pcs = append(pcs, pc)
+		if err := g.checkUpstream(ctx, proxy); err != nil {
+			pc.Error = err.Error()
+			pc.Hint = "check the GOPROXY and the network " +
+				"connectivity (e.g. DNS, firewalls and " +
+				"HTTP proxies)"
+		}
+	}
+
+	return pcs
+}
+
+// checkUpstream checks whether the proxy is reachable. Any HTTP response
+// counts, since the proxies are not required to serve their roots.
+func (g *Goproxy) checkUpstream(ctx context.Context, proxy string) error {
+	proxyURL, err := parseRawURL(proxy)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodHead, proxyURL.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := g.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+
+	return res.Body.Close()
+}
+
+// preflightSUMDBKey checks whether the verifier key of the GOSUMDB is valid and
+// trusted (see the [Goproxy.SUMDBKeys]), storing it on first use.
+func (g *Goproxy) preflightSUMDBKey(ctx context.Context) *PreflightCheck {
+	pc := &PreflightCheck{Name: "sumdb-key", Target: g.goBinEnvGOSUMDB}
+	if g.goBinEnvGOSUMDB == "off" {
+		pc.Skipped = true
+		return pc
+	}
+
+	key, _, rawURL, err := parseGOSUMDB(g.goBinEnvGOSUMDB)
+	if err == nil {
+		_, err = parseRawURL(rawURL)
+	}
+
+	if err == nil {
+		_, err = g.trustSUMDBKey(ctx, key)
+	}
+
+	if errors.Is(err, errSUMDBKeyChanged) {
+		pc.Error = err.Error()
+		pc.Hint = "pin the new key in the SUMDBKeys if the checksum " +
+			"database has rotated its key"
+	} else if err != nil {
+		pc.Error = err.Error()
+		pc.Hint = `set the GOSUMDB to "<name>+<hash>+<key> [<url>]" ` +
+			`or "off"`
+	}
+
+	return pc
+}
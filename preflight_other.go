@@ -0,0 +1,10 @@
+//go:build !darwin && !freebsd && !linux
+// +build !darwin,!freebsd,!linux
+
+package goproxy
+
+// diskFreeBytes always returns the errDiskFreeNotSupported since the free
+// space of file systems cannot be queried on the current platform.
+func diskFreeBytes(dir string) (int64, error) {
+	return 0, errDiskFreeNotSupported
+}
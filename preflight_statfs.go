@@ -0,0 +1,17 @@
+//go:build darwin || freebsd || linux
+// +build darwin freebsd linux
+
+package goproxy
+
+import "syscall"
+
+// diskFreeBytes returns the number of bytes available to unprivileged users on
+// the file system of the dir.
+func diskFreeBytes(dir string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
@@ -0,0 +1,127 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGoproxyPreflight(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestGoproxyPreflight")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	server := httptest.NewServer(http.HandlerFunc(func(
+		rw http.ResponseWriter,
+		req *http.Request,
+	) {
+		responseNotFound(rw, req, -2)
+	}))
+	defer server.Close()
+
+	closedServer := httptest.NewServer(http.NotFoundHandler())
+	closedServer.Close()
+
+	g := &Goproxy{
+		GoBinName: filepath.Join(tempDir, "nonexistent-go"),
+		GoBinEnv: []string{
+			"GOPROXY=" + server.URL + "," + closedServer.URL +
+				",direct",
+			"GOSUMDB=example.com+invalid-key",
+		},
+		Cacher:  DirCacher(filepath.Join(tempDir, "caches")),
+		TempDir: tempDir,
+	}
+
+	pr := g.Preflight(context.Background())
+	checks := map[string]*PreflightCheck{}
+	for _, pc := range pr.Checks {
+		checks[pc.Name+" "+pc.Target] = pc
+	}
+
+	for key, wantError := range map[string]bool{
+		"go-bin " + g.GoBinName:             true,
+		"temp-dir " + tempDir:               false,
+		"cache ":                            false,
+		"upstream " + server.URL:            false,
+		"upstream " + closedServer.URL:      true,
+		"sumdb-key example.com+invalid-key": true,
+	} {
+		pc := checks[key]
+		if pc == nil {
+			t.Fatalf("%s: expected check", key)
+		} else if pc.Skipped {
+			t.Errorf("%s: unexpected skip", key)
+		} else if got := pc.Error != ""; got != wantError {
+			t.Errorf("%s: got %t, want %t", key, got, wantError)
+		} else if wantError && pc.Hint == "" {
+			t.Errorf("%s: expected hint", key)
+		}
+	}
+
+	if err := pr.Err(); err == nil {
+		t.Fatal("expected error")
+	} else if got := err.Error(); !strings.Contains(
+		got,
+		closedServer.URL,
+	) {
+		t.Errorf("got %q, want it to contain %q", got, closedServer.URL)
+	}
+
+	if _, err := g.Cacher.Get(
+		context.Background(),
+		preflightCacheName,
+	); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got error %q, want error %q", err, os.ErrNotExist)
+	}
+
+	g = &Goproxy{
+		GoBinEnv: []string{
+			"GOPROXY=off",
+			"GOSUMDB=off",
+		},
+		TempDir: tempDir,
+	}
+
+	pr = g.Preflight(context.Background())
+	for _, pc := range pr.Checks {
+		switch pc.Name {
+		case "go-bin", "cache", "sumdb-key":
+			if !pc.Skipped {
+				t.Errorf("%s: expected skip", pc.Name)
+			}
+		case "upstream":
+			t.Errorf("unexpected check %s %s", pc.Name, pc.Target)
+		}
+	}
+
+	g = &Goproxy{
+		GoBinEnv: []string{
+			"GOPROXY=off",
+			"GOSUMDB=sum.golang.org",
+		},
+		TempDir: filepath.Join(tempDir, "nonexistent"),
+	}
+
+	pr = g.Preflight(context.Background())
+	for _, pc := range pr.Checks {
+		switch pc.Name {
+		case "temp-dir":
+			if pc.Error == "" {
+				t.Error("expected temp-dir error")
+			}
+		case "sumdb-key":
+			if pc.Error != "" {
+				t.Errorf("unexpected error %q", pc.Error)
+			}
+		}
+	}
+}
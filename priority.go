@@ -0,0 +1,100 @@
+package goproxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// priorityHeader is the name of the request header that classifies requests
+// when the [Goproxy.PriorityClassifier] is nil.
+const priorityHeader = "Goproxy-Priority"
+
+// RequestPriority is the priority class of a request to a [Goproxy]. When the
+// capacity for fetching from upstream (see the [Goproxy.GoBinMaxWorkers] and
+// the [Goproxy.UpstreamRateLimit]) is scarce, the requests of higher priority
+// classes get it first, so that prefetches never delay a developer's go get.
+type RequestPriority int
+
+const (
+	// RequestPriorityInteractive is for requests someone is waiting on,
+	// e.g. a developer running the go command. It is the default.
+	RequestPriorityInteractive RequestPriority = iota
+
+	// RequestPriorityBatch is for automated bulk requests, e.g. from CI
+	// builds.
+	RequestPriorityBatch
+
+	// RequestPriorityBackground is for work nobody is waiting on. The
+	// Goproxy runs its own background work (e.g. prefetches, read-aheads
+	// and warming) with it.
+	RequestPriorityBackground
+
+	// numRequestPriorities is the number of the [RequestPriority]s.
+	numRequestPriorities
+)
+
+// String implements the [fmt.Stringer].
+func (rp RequestPriority) String() string {
+	switch rp {
+	case RequestPriorityInteractive:
+		return "interactive"
+	case RequestPriorityBatch:
+		return "batch"
+	case RequestPriorityBackground:
+		return "background"
+	}
+
+	return fmt.Sprintf("RequestPriority(%d)", int(rp))
+}
+
+// ParseRequestPriority parses the s as a [RequestPriority] ("interactive",
+// "batch" or "background", case-insensitively).
+func ParseRequestPriority(s string) (RequestPriority, error) {
+	for rp := RequestPriority(0); rp < numRequestPriorities; rp++ {
+		if strings.EqualFold(s, rp.String()) {
+			return rp, nil
+		}
+	}
+
+	return 0, fmt.Errorf("invalid request priority %q", s)
+}
+
+// priorityContextKey is the context key of the [RequestPriority]s.
+type priorityContextKey struct{}
+
+// withPriority returns a copy of the ctx carrying the rp.
+func withPriority(ctx context.Context, rp RequestPriority) context.Context {
+	return context.WithValue(ctx, priorityContextKey{}, rp)
+}
+
+// priorityOf returns the [RequestPriority] carried by the ctx, which defaults
+// to the [RequestPriorityInteractive].
+func priorityOf(ctx context.Context) RequestPriority {
+	rp, _ := ctx.Value(priorityContextKey{}).(RequestPriority)
+	if rp < 0 || rp >= numRequestPriorities {
+		return RequestPriorityInteractive
+	}
+
+	return rp
+}
+
+// requestPriority returns the [RequestPriority] of the req.
+func (g *Goproxy) requestPriority(req *http.Request) RequestPriority {
+	if g.PriorityClassifier != nil {
+		rp := g.PriorityClassifier(req)
+		if rp < 0 || rp >= numRequestPriorities {
+			return RequestPriorityInteractive
+		}
+
+		return rp
+	}
+
+	rp, err := ParseRequestPriority(req.Header.Get(priorityHeader))
+	if err != nil {
+		return RequestPriorityInteractive
+	}
+
+	return rp
+}
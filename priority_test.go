@@ -0,0 +1,92 @@
+package goproxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseRequestPriority(t *testing.T) {
+	for n, tt := range []struct {
+		s       string
+		want    RequestPriority
+		wantErr bool
+	}{
+		{"interactive", RequestPriorityInteractive, false},
+		{"Batch", RequestPriorityBatch, false},
+		{"BACKGROUND", RequestPriorityBackground, false},
+		{"", 0, true},
+		{"urgent", 0, true},
+	} {
+		rp, err := ParseRequestPriority(tt.s)
+		if tt.wantErr {
+			if err == nil {
+				t.Fatalf("test(%d): expected error", n)
+			}
+		} else if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", n, err)
+		} else if rp != tt.want {
+			t.Errorf("test(%d): got %s, want %s", n, rp, tt.want)
+		} else if got, err := ParseRequestPriority(
+			rp.String(),
+		); err != nil || got != rp {
+			t.Errorf("test(%d): got %s, want %s", n, got, rp)
+		}
+	}
+
+	if got, want := RequestPriority(-1).String(),
+		"RequestPriority(-1)"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestGoproxyRequestPriority(t *testing.T) {
+	g := &Goproxy{}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if got, want := g.requestPriority(req),
+		RequestPriorityInteractive; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	req.Header.Set("Goproxy-Priority", "batch")
+	if got, want := g.requestPriority(req),
+		RequestPriorityBatch; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	req.Header.Set("Goproxy-Priority", "invalid")
+	if got, want := g.requestPriority(req),
+		RequestPriorityInteractive; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	g.PriorityClassifier = func(req *http.Request) RequestPriority {
+		if req.Header.Get("User-Agent") == "ci" {
+			return RequestPriorityBatch
+		}
+
+		return RequestPriority(42)
+	}
+
+	req.Header.Set("User-Agent", "ci")
+	if got, want := g.requestPriority(req),
+		RequestPriorityBatch; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	req.Header.Del("User-Agent")
+	if got, want := g.requestPriority(req),
+		RequestPriorityInteractive; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	ctx := withPriority(context.Background(), RequestPriorityBatch)
+	if got, want := priorityOf(ctx), RequestPriorityBatch; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	} else if !isBulk(ctx) {
+		t.Error("expected bulk")
+	} else if isBulk(context.Background()) {
+		t.Error("unexpected bulk")
+	}
+}
@@ -0,0 +1,161 @@
+package goproxy
+
+import (
+	"bufio"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// prometheusContentType is the content type of the Prometheus text exposition
+// format.
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// PrometheusMetrics is a [MetricsSink] that aggregates the metrics in memory
+// and exposes them in the Prometheus text exposition format, e.g. to be
+// scraped by Prometheus or any compatible system.
+//
+// It is also an [http.Handler] that serves the metrics. If it is used as the
+// [Goproxy.Metrics], it is also served by the "/-/metrics" administrative
+// endpoint of the [Goproxy].
+//
+// The zero value is ready to use.
+type PrometheusMetrics struct {
+	metricsRegistry
+}
+
+// ServeHTTP implements the [http.Handler].
+func (pm *PrometheusMetrics) ServeHTTP(
+	rw http.ResponseWriter,
+	req *http.Request,
+) {
+	rw.Header().Set("Content-Type", prometheusContentType)
+	rw.Header().Set("Cache-Control", "must-revalidate, no-cache, no-store")
+	if req.Method == http.MethodHead {
+		return
+	}
+
+	bw := bufio.NewWriter(rw)
+	families, _ := pm.snapshot()
+	for _, mf := range families {
+		bw.WriteString("# HELP " + mf.name + " ")
+		bw.WriteString(prometheusHelpReplacer.Replace(mf.help) + "\n")
+		bw.WriteString("# TYPE " + mf.name + " " + mf.typ + "\n")
+		for _, ms := range mf.sortedSeries {
+			labels := prometheusLabels(
+				mf.labelNames,
+				ms.labelValues,
+			)
+			if mf.typ != metricTypeHistogram {
+				writePrometheusSample(
+					bw,
+					mf.name,
+					labels,
+					ms.value,
+				)
+				continue
+			}
+
+			var count uint64
+			for i, bucketCount := range ms.bucketCounts {
+				le := math.Inf(1)
+				if i < len(mf.buckets) {
+					le = mf.buckets[i]
+				}
+
+				count += bucketCount
+				writePrometheusSample(
+					bw,
+					mf.name+"_bucket",
+					append(labels, [2]string{
+						"le",
+						formatPrometheusValue(le),
+					}),
+					float64(count),
+				)
+			}
+
+			writePrometheusSample(
+				bw,
+				mf.name+"_sum",
+				labels,
+				ms.sum,
+			)
+			writePrometheusSample(
+				bw,
+				mf.name+"_count",
+				labels,
+				float64(ms.count),
+			)
+		}
+	}
+
+	bw.Flush()
+}
+
+// prometheusHelpReplacer escapes the help texts of the Prometheus text
+// exposition format.
+var prometheusHelpReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+// prometheusLabelValueReplacer escapes the label values of the Prometheus text
+// exposition format.
+var prometheusLabelValueReplacer = strings.NewReplacer(
+	`\`, `\\`,
+	"\n", `\n`,
+	`"`, `\"`,
+)
+
+// prometheusLabels returns the label pairs of the names and the values.
+func prometheusLabels(names, values []string) [][2]string {
+	labels := make([][2]string, 0, len(names)+1)
+	for i, name := range names {
+		labels = append(labels, [2]string{name, values[i]})
+	}
+
+	return labels
+}
+
+// writePrometheusSample writes the sample of the name with the labels and the
+// value to the bw in the Prometheus text exposition format.
+func writePrometheusSample(
+	bw *bufio.Writer,
+	name string,
+	labels [][2]string,
+	value float64,
+) {
+	bw.WriteString(name)
+	if len(labels) > 0 {
+		bw.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				bw.WriteByte(',')
+			}
+
+			bw.WriteString(label[0] + `="`)
+			bw.WriteString(
+				prometheusLabelValueReplacer.Replace(label[1]),
+			)
+			bw.WriteByte('"')
+		}
+
+		bw.WriteByte('}')
+	}
+
+	bw.WriteString(" " + formatPrometheusValue(value) + "\n")
+}
+
+// formatPrometheusValue formats the v as a value of the Prometheus text
+// exposition format.
+func formatPrometheusValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
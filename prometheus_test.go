@@ -0,0 +1,66 @@
+package goproxy
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	pm := &PrometheusMetrics{}
+	pm.Counter("b_total", "B.\n", "l").Add(1, "a\"\\\nb")
+	pm.Gauge("a", "A.").Set(-1.5)
+	h := pm.Histogram("c_seconds", "C.", []float64{0.5, 1}, "l")
+	h.Observe(0.25, "x")
+	h.Observe(2, "x")
+
+	rec := httptest.NewRecorder()
+	pm.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got, want := rec.Header().Get("Content-Type"),
+		prometheusContentType; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if got, want := rec.Body.String(), `# HELP a A.
+# TYPE a gauge
+a -1.5
+# HELP b_total B.\n
+# TYPE b_total counter
+b_total{l="a\"\\\nb"} 1
+# HELP c_seconds C.
+# TYPE c_seconds histogram
+c_seconds_bucket{l="x",le="0.5"} 1
+c_seconds_bucket{l="x",le="1"} 1
+c_seconds_bucket{l="x",le="+Inf"} 2
+c_seconds_sum{l="x"} 2.25
+c_seconds_count{l="x"} 2
+`; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	rec = httptest.NewRecorder()
+	pm.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, "/", nil))
+	if got := rec.Body.Len(); got != 0 {
+		t.Errorf("got %d bytes, want 0", got)
+	}
+}
+
+func TestFormatPrometheusValue(t *testing.T) {
+	for n, tt := range []struct {
+		v    float64
+		want string
+	}{
+		{0, "0"},
+		{1e-3, "0.001"},
+		{1e21, "1e+21"},
+		{math.Inf(1), "+Inf"},
+		{math.Inf(-1), "-Inf"},
+		{math.NaN(), "NaN"},
+	} {
+		got := formatPrometheusValue(tt.v)
+		if want := tt.want; got != want {
+			t.Errorf("test(%d): got %q, want %q", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package goproxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// errDeleteNotSupported means the [Goproxy.Cacher] does not implement the
+// [Deleter].
+var errDeleteNotSupported = errors.New("cacher does not support deletion")
+
+// trashNamePrefix is the prefix of the names of the caches in the trash.
+const trashNamePrefix = apiPathPrefix + "trash/"
+
+// PurgeResult is the result of the [Goproxy.Purge].
+type PurgeResult struct {
+	// Names are the names of the purged caches.
+	Names []string
+
+	// TrashID is the ID of the trash that the purged caches have been
+	// moved into. It can be used to restore them via the
+	// [Goproxy.Restore]. It is empty if the caches have been removed
+	// permanently.
+	TrashID string `json:",omitempty"`
+}
+
+// trashManifest is the manifest of a trash.
+type trashManifest struct {
+	Names     []string
+	PurgeTime time.Time
+}
+
+// Purge purges the caches of the modulePath at the moduleVersion (or only its
+// version list and latest version caches if the moduleVersion is empty) from
+// the [Goproxy.Cacher], which must implement the [Deleter]. If the
+// [Goproxy.TrashRetention] is not zero, the purged caches are moved into a
+// trash instead of being removed permanently.
+func (g *Goproxy) Purge(
+	ctx context.Context,
+	modulePath string,
+	moduleVersion string,
+) (*PurgeResult, error) {
+	g.initOnce.Do(g.init)
+
+	deleter, ok := g.Cacher.(Deleter)
+	if !ok {
+		return nil, errDeleteNotSupported
+	}
+
+	e, err := g.explain(modulePath, moduleVersion)
+	if err != nil {
+		return nil, notFoundError(err.Error())
+	}
+
+	return g.purge(ctx, deleter, e.CacheNames)
+}
+
+// PurgePrefix purges all caches whose names have the prefix (e.g.
+// "example.com/foo/@v/" for all versions of a module) from the
+// [Goproxy.Cacher], which must implement the [Deleter] and either implement
+// the [PrefixDeleter] or be able to enumerate its caches. If the
+// [Goproxy.TrashRetention] is not zero, the purged caches are moved into a
+// trash instead of being removed permanently.
+func (g *Goproxy) PurgePrefix(
+	ctx context.Context,
+	prefix string,
+) (*PurgeResult, error) {
+	g.initOnce.Do(g.init)
+
+	if prefix == "" {
+		return nil, errors.New("missing prefix")
+	}
+
+	deleter, ok := g.Cacher.(Deleter)
+	if !ok {
+		return nil, errDeleteNotSupported
+	}
+
+	if pd, ok := g.Cacher.(PrefixDeleter); ok && g.TrashRetention == 0 {
+		names, err := pd.DeletePrefix(ctx, prefix)
+		if err != nil {
+			return nil, err
+		}
+
+		return &PurgeResult{Names: names}, nil
+	}
+
+	cw, ok := cacheWalkerOf(g.Cacher)
+	if !ok {
+		return nil, errWalkNotSupported
+	}
+
+	names, err := cacheNamesWithPrefix(cw, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return g.purge(ctx, deleter, names)
+}
+
+// purge purges the caches for the names via the deleter, moving them into a
+// trash first if the [Goproxy.TrashRetention] is not zero.
+func (g *Goproxy) purge(
+	ctx context.Context,
+	deleter Deleter,
+	names []string,
+) (*PurgeResult, error) {
+	r := &PurgeResult{}
+	if g.TrashRetention != 0 {
+		r.TrashID = strconv.FormatInt(time.Now().UnixNano(), 10)
+	}
+
+	for _, name := range names {
+		if r.TrashID != "" {
+			if err := g.copyCache(
+				ctx,
+				name,
+				fmt.Sprint(trashNamePrefix, r.TrashID, "/", name),
+				g.TrashRetention,
+			); err != nil {
+				if errors.Is(err, os.ErrNotExist) {
+					continue
+				}
+
+				return nil, err
+			}
+		}
+
+		if err := deleter.Delete(ctx, name); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+
+			return nil, err
+		}
+
+		r.Names = append(r.Names, name)
+	}
+
+	if r.TrashID != "" {
+		manifest, err := json.Marshal(trashManifest{
+			Names:     r.Names,
+			PurgeTime: time.Now(),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if err := g.Cacher.Put(
+			ctx,
+			fmt.Sprint(trashNamePrefix, r.TrashID, ".json"),
+			bytes.NewReader(manifest),
+			g.TrashRetention,
+		); err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// Restore restores the caches in the trash targeted by the trashID (see the
+// [PurgeResult.TrashID]) and returns their names. The trash is removed after
+// being restored.
+func (g *Goproxy) Restore(ctx context.Context, trashID string) ([]string, error) {
+	g.initOnce.Do(g.init)
+
+	deleter, ok := g.Cacher.(Deleter)
+	if !ok {
+		return nil, errDeleteNotSupported
+	}
+
+	if _, err := strconv.ParseInt(trashID, 10, 64); err != nil {
+		return nil, notFoundError("invalid trash ID")
+	}
+
+	manifestName := fmt.Sprint(trashNamePrefix, trashID, ".json")
+	content, err := g.cache(ctx, manifestName)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, notFoundError("trash not found")
+		}
+
+		return nil, err
+	}
+
+	var manifest trashManifest
+	err = json.NewDecoder(content).Decode(&manifest)
+	content.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range manifest.Names {
+		trashName := fmt.Sprint(trashNamePrefix, trashID, "/", name)
+		if err := g.copyCache(
+			ctx,
+			trashName,
+			name,
+			defaultCacheExpiration,
+		); err != nil {
+			return nil, err
+		}
+
+		if err := deleter.Delete(
+			ctx,
+			trashName,
+		); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	if err := deleter.Delete(
+		ctx,
+		manifestName,
+	); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	return manifest.Names, nil
+}
+
+// copyCache copies the cache for the srcName to the dstName in the
+// [Goproxy.Cacher] with the expiration.
+func (g *Goproxy) copyCache(
+	ctx context.Context,
+	srcName string,
+	dstName string,
+	expiration time.Duration,
+) error {
+	content, err := g.cache(ctx, srcName)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	return putStream(
+		ctx,
+		g.Cacher,
+		dstName,
+		content,
+		expiration,
+		g.TempDir,
+	)
+}
+
+// servePurge serves purge requests.
+func (g *Goproxy) servePurge(rw http.ResponseWriter, req *http.Request) {
+	var (
+		r   *PurgeResult
+		err error
+	)
+
+	query := req.URL.Query()
+	if prefix := query.Get("prefix"); prefix != "" {
+		r, err = g.PurgePrefix(req.Context(), prefix)
+	} else {
+		r, err = g.Purge(
+			req.Context(),
+			query.Get("module"),
+			query.Get("version"),
+		)
+	}
+
+	if err != nil {
+		g.serveAdminError(rw, req, "purge caches", err)
+		return
+	}
+
+	responseJSON(rw, req, -2, r)
+}
+
+// serveRestore serves restore requests.
+func (g *Goproxy) serveRestore(rw http.ResponseWriter, req *http.Request) {
+	names, err := g.Restore(req.Context(), req.URL.Query().Get("id"))
+	if err != nil {
+		g.serveAdminError(rw, req, "restore caches", err)
+		return
+	}
+
+	responseJSON(rw, req, -2, struct{ Names []string }{names})
+}
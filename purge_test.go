@@ -0,0 +1,359 @@
+package goproxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGoproxyPurge(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestGoproxyPurge")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	g := &Goproxy{Cacher: DirCacher(tempDir), TempDir: tempDir}
+	for _, name := range []string{
+		"example.com/@v/list",
+		"example.com/@v/v1.0.0.info",
+		"example.com/@v/v1.0.0.zip",
+		"example.com/@v/v1.1.0.zip",
+	} {
+		if err := g.Cacher.Put(
+			context.Background(),
+			name,
+			strings.NewReader(name),
+			time.Minute,
+		); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	r, err := g.Purge(context.Background(), "example.com", "v1.0.0")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := strings.Join(r.Names, " "),
+		"example.com/@v/list "+
+			"example.com/@v/v1.0.0.info "+
+			"example.com/@v/v1.0.0.zip"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	} else if got, want := r.TrashID, ""; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if _, err := g.cache(
+		context.Background(),
+		"example.com/@v/v1.0.0.zip",
+	); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got error %q, want error %q", err, os.ErrNotExist)
+	}
+
+	if _, err := g.cache(
+		context.Background(),
+		"example.com/@v/v1.1.0.zip",
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if _, err := g.Purge(
+		context.Background(),
+		"",
+		"",
+	); !errors.Is(err, errNotFound) {
+		t.Fatalf("got error %q, want error %q", err, errNotFound)
+	}
+
+	g = &Goproxy{}
+	if _, err := g.Purge(
+		context.Background(),
+		"example.com",
+		"",
+	); err != errDeleteNotSupported {
+		t.Fatalf("got error %q, want error %q", err, errDeleteNotSupported)
+	}
+}
+
+func TestGoproxyPurgePrefix(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestGoproxyPurgePrefix")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	names := []string{
+		"example.com/foo/@v/list",
+		"example.com/foo/@v/v1.0.0.info",
+		"example.com/foo/bar/@v/v1.0.0.info",
+		"example.com/foobar/@v/v1.0.0.info",
+	}
+
+	for _, tt := range []struct {
+		n              int
+		cacher         Cacher
+		trashRetention time.Duration
+	}{
+		{1, DirCacher(filepath.Join(tempDir, "dir")), 0},
+		{2, DirCacher(filepath.Join(tempDir, "trash")), time.Hour},
+		{3, &MemoryCacher{}, 0},
+	} {
+		g := &Goproxy{
+			Cacher:         tt.cacher,
+			TempDir:        tempDir,
+			TrashRetention: tt.trashRetention,
+		}
+		for _, name := range names {
+			if err := g.Cacher.Put(
+				context.Background(),
+				name,
+				strings.NewReader(name),
+				time.Minute,
+			); err != nil {
+				t.Fatalf(
+					"test(%d): unexpected error %q",
+					tt.n,
+					err,
+				)
+			}
+		}
+
+		r, err := g.PurgePrefix(
+			context.Background(),
+			"example.com/foo/",
+		)
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		}
+
+		sort.Strings(r.Names)
+		if got, want := strings.Join(r.Names, " "),
+			strings.Join(names[:3], " "); got != want {
+			t.Errorf("test(%d): got %q, want %q", tt.n, got, want)
+		} else if got, want := r.TrashID != "",
+			tt.trashRetention != 0; got != want {
+			t.Errorf("test(%d): got %t, want %t", tt.n, got, want)
+		}
+
+		if _, err := g.cache(
+			context.Background(),
+			names[3],
+		); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", tt.n, err)
+		}
+
+		if r.TrashID != "" {
+			if _, err := g.Restore(
+				context.Background(),
+				r.TrashID,
+			); err != nil {
+				t.Fatalf(
+					"test(%d): unexpected error %q",
+					tt.n,
+					err,
+				)
+			}
+		}
+	}
+
+	g := &Goproxy{Cacher: DirCacher(tempDir)}
+	if _, err := g.PurgePrefix(context.Background(), ""); err == nil {
+		t.Fatal("expected error")
+	}
+
+	g = &Goproxy{
+		Cacher:          DirCacher(filepath.Join(tempDir, "dir")),
+		AdminAuthorizer: func(*http.Request) bool { return true },
+	}
+
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, httptest.NewRequest(
+		http.MethodPost,
+		"/-/purge?prefix=example.com/",
+		nil,
+	))
+	var r PurgeResult
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	} else if err := json.Unmarshal(rec.Body.Bytes(), &r); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := strings.Join(r.Names, " "),
+		names[3]; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestGoproxyPurgeAndRestore(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestGoproxyPurgeAndRestore")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	g := &Goproxy{
+		Cacher:         DirCacher(tempDir),
+		TempDir:        tempDir,
+		TrashRetention: time.Hour,
+	}
+	if err := g.Cacher.Put(
+		context.Background(),
+		"example.com/@v/v1.0.0.mod",
+		strings.NewReader("module example.com"),
+		time.Minute,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	r, err := g.Purge(context.Background(), "example.com", "v1.0.0")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := strings.Join(r.Names, " "),
+		"example.com/@v/v1.0.0.mod"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	} else if r.TrashID == "" {
+		t.Fatal("unexpected empty trash ID")
+	}
+
+	if _, err := g.cache(
+		context.Background(),
+		"example.com/@v/v1.0.0.mod",
+	); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got error %q, want error %q", err, os.ErrNotExist)
+	}
+
+	names, err := g.Restore(context.Background(), r.TrashID)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := strings.Join(names, " "),
+		"example.com/@v/v1.0.0.mod"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if rc, err := g.cache(
+		context.Background(),
+		"example.com/@v/v1.0.0.mod",
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if b, err := ioutil.ReadAll(rc); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if err := rc.Close(); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := string(b), "module example.com"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if _, err := g.Restore(
+		context.Background(),
+		r.TrashID,
+	); !errors.Is(err, errNotFound) {
+		t.Fatalf("got error %q, want error %q", err, errNotFound)
+	}
+
+	if _, err := g.Restore(
+		context.Background(),
+		"../invalid",
+	); !errors.Is(err, errNotFound) {
+		t.Fatalf("got error %q, want error %q", err, errNotFound)
+	}
+}
+
+func TestGoproxyServePurgeAndRestore(t *testing.T) {
+	tempDir, err := ioutil.TempDir(
+		"",
+		"goproxy.TestGoproxyServePurgeAndRestore",
+	)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	g := &Goproxy{
+		Cacher:          DirCacher(tempDir),
+		TempDir:         tempDir,
+		TrashRetention:  time.Hour,
+		AdminAuthorizer: func(*http.Request) bool { return true },
+	}
+	if err := g.Cacher.Put(
+		context.Background(),
+		"example.com/@latest",
+		strings.NewReader(marshalInfo("v1.0.0", time.Now())),
+		time.Minute,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	req := httptest.NewRequest(
+		http.MethodGet,
+		"/-/purge?module=example.com",
+		nil,
+	)
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, req)
+	if got, want := rec.Code, http.StatusMethodNotAllowed; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	} else if got, want := rec.Header().Get("Allow"),
+		"POST, DELETE"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	req = httptest.NewRequest(
+		http.MethodPost,
+		"/-/purge?module=example.com",
+		nil,
+	)
+	rec = httptest.NewRecorder()
+	g.ServeHTTP(rec, req)
+	var r PurgeResult
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	} else if err := json.Unmarshal(rec.Body.Bytes(), &r); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := strings.Join(r.Names, " "),
+		"example.com/@latest"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	req = httptest.NewRequest(
+		http.MethodPost,
+		"/-/restore?id="+r.TrashID,
+		nil,
+	)
+	rec = httptest.NewRecorder()
+	g.ServeHTTP(rec, req)
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	} else if got, want := rec.Body.String(),
+		`{"Names":["example.com/@latest"]}`; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/-/restore?id=0", nil)
+	rec = httptest.NewRecorder()
+	g.ServeHTTP(rec, req)
+	if got, want := rec.Code, http.StatusNotFound; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	} else if got, want := rec.Body.String(),
+		"not found: trash not found"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	g = &Goproxy{AdminAuthorizer: g.AdminAuthorizer}
+	req = httptest.NewRequest(
+		http.MethodPost,
+		"/-/purge?module=example.com",
+		nil,
+	)
+	rec = httptest.NewRecorder()
+	g.ServeHTTP(rec, req)
+	if got, want := rec.Code, http.StatusNotImplemented; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
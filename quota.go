@@ -0,0 +1,227 @@
+package goproxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ClientQuota is the quota of the bytes served per client per time window. It
+// is for enforcing fair use across the clients of a shared [Goproxy].
+//
+// Make sure that all fields of the ClientQuota have been finalized before
+// using it.
+type ClientQuota struct {
+	// MaxBytes is the maximum number of bytes allowed to be served to a
+	// client within a window. Once exceeded, further requests from the
+	// client are rejected with a 429 Too Many Requests until the window
+	// ends.
+	//
+	// If the MaxBytes is zero, there is no limit, but the usage is still
+	// tracked.
+	MaxBytes int64
+
+	// ClientMaxBytes overrides the [ClientQuota.MaxBytes] for specific
+	// clients, keyed by the client identities returned by the
+	// [ClientQuota.ClientIdentifier]. A zero value means no limit.
+	ClientMaxBytes map[string]int64
+
+	// Window is the time window of the quota. Windows are aligned to the
+	// Unix epoch, so a 24-hour window always starts at midnight UTC.
+	//
+	// If the Window is zero, 24 hours is used.
+	Window time.Duration
+
+	// ClientIdentifier returns the identity of the client of the req.
+	//
+	// If the ClientIdentifier is nil, the IP address of the client (see
+	// [http.Request.RemoteAddr]) is used.
+	ClientIdentifier func(req *http.Request) string
+
+	mutex       sync.Mutex
+	windowStart time.Time
+	usage       map[string]int64
+}
+
+// ClientQuotaUsage is the usage of a [ClientQuota] by a client.
+type ClientQuotaUsage struct {
+	Client   string
+	Bytes    int64
+	MaxBytes int64 `json:",omitempty"`
+}
+
+// ClientQuotaReport is the report of the usage of a [ClientQuota] in the
+// current window.
+type ClientQuotaReport struct {
+	WindowStart time.Time
+	WindowEnd   time.Time
+	Clients     []ClientQuotaUsage
+}
+
+// Report reports the usage of the cq in the current window. The clients are
+// sorted by their usage in descending order.
+func (cq *ClientQuota) Report() *ClientQuotaReport {
+	cq.mutex.Lock()
+	defer cq.mutex.Unlock()
+	cq.rotate(time.Now())
+
+	r := &ClientQuotaReport{
+		WindowStart: cq.windowStart,
+		WindowEnd:   cq.windowStart.Add(cq.window()),
+		Clients:     make([]ClientQuotaUsage, 0, len(cq.usage)),
+	}
+	for client, bytes := range cq.usage {
+		r.Clients = append(r.Clients, ClientQuotaUsage{
+			Client:   client,
+			Bytes:    bytes,
+			MaxBytes: cq.maxBytes(client),
+		})
+	}
+
+	sort.Slice(r.Clients, func(i, j int) bool {
+		if r.Clients[i].Bytes != r.Clients[j].Bytes {
+			return r.Clients[i].Bytes > r.Clients[j].Bytes
+		}
+
+		return r.Clients[i].Client < r.Clients[j].Client
+	})
+
+	return r
+}
+
+// admit admits the req if its client has quota left and returns an
+// [http.ResponseWriter] wrapping the rw that accounts the bytes written to the
+// client. It responses to the client and returns false if not admitted.
+func (cq *ClientQuota) admit(
+	rw http.ResponseWriter,
+	req *http.Request,
+) (http.ResponseWriter, bool) {
+	client := cq.clientIdentity(req)
+
+	cq.mutex.Lock()
+	now := time.Now()
+	cq.rotate(now)
+	maxBytes := cq.maxBytes(client)
+	exceeded := maxBytes > 0 && cq.usage[client] >= maxBytes
+	windowEnd := cq.windowStart.Add(cq.window())
+	cq.mutex.Unlock()
+
+	if exceeded {
+		rw.Header().Set(
+			"Retry-After",
+			strconv.Itoa(int(windowEnd.Sub(now)/time.Second)+1),
+		)
+		responseString(
+			rw,
+			req,
+			http.StatusTooManyRequests,
+			-2,
+			fmt.Sprintf(
+				"quota exceeded: %d bytes served to %s "+
+					"since %s",
+				maxBytes,
+				client,
+				windowEnd.Add(-cq.window()).Format(time.RFC3339),
+			),
+		)
+		return nil, false
+	}
+
+	return &quotaResponseWriter{
+		ResponseWriter: rw,
+		cq:             cq,
+		client:         client,
+	}, true
+}
+
+// add adds the n bytes to the usage of the client.
+func (cq *ClientQuota) add(client string, n int64) {
+	cq.mutex.Lock()
+	defer cq.mutex.Unlock()
+	cq.rotate(time.Now())
+	cq.usage[client] += n
+}
+
+// rotate starts a new window if the now is beyond the current one. It must be
+// called with the cq.mutex held.
+func (cq *ClientQuota) rotate(now time.Time) {
+	windowStart := now.Truncate(cq.window())
+	if cq.usage == nil || !windowStart.Equal(cq.windowStart) {
+		cq.windowStart = windowStart
+		cq.usage = map[string]int64{}
+	}
+}
+
+// window returns the effective [ClientQuota.Window].
+func (cq *ClientQuota) window() time.Duration {
+	if cq.Window == 0 {
+		return 24 * time.Hour
+	}
+
+	return cq.Window
+}
+
+// maxBytes returns the effective maximum number of bytes for the client.
+func (cq *ClientQuota) maxBytes(client string) int64 {
+	if maxBytes, ok := cq.ClientMaxBytes[client]; ok {
+		return maxBytes
+	}
+
+	return cq.MaxBytes
+}
+
+// clientIdentity returns the identity of the client of the req.
+func (cq *ClientQuota) clientIdentity(req *http.Request) string {
+	if cq.ClientIdentifier != nil {
+		return cq.ClientIdentifier(req)
+	}
+
+	return remoteIP(req)
+}
+
+// remoteIP returns the IP address of the client of the req.
+func remoteIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+
+	return host
+}
+
+// quotaResponseWriter is an [http.ResponseWriter] that accounts the bytes
+// written to the client of a [ClientQuota].
+type quotaResponseWriter struct {
+	http.ResponseWriter
+
+	cq     *ClientQuota
+	client string
+}
+
+// Write implements the [http.ResponseWriter].
+func (qrw *quotaResponseWriter) Write(b []byte) (int, error) {
+	n, err := qrw.ResponseWriter.Write(b)
+	qrw.cq.add(qrw.client, int64(n))
+	return n, err
+}
+
+// Flush implements the [http.Flusher].
+func (qrw *quotaResponseWriter) Flush() {
+	if f, ok := qrw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// serveQuota serves quota usage requests.
+func (g *Goproxy) serveQuota(rw http.ResponseWriter, req *http.Request) {
+	if g.ClientQuota == nil {
+		responseNotFound(rw, req, -2, "client quota is disabled")
+		return
+	}
+
+	responseJSON(rw, req, -2, g.ClientQuota.Report())
+}
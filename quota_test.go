@@ -0,0 +1,134 @@
+package goproxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClientQuota(t *testing.T) {
+	cq := &ClientQuota{
+		MaxBytes:       10,
+		ClientMaxBytes: map[string]int64{"10.0.0.2": 0},
+	}
+
+	req := httptest.NewRequest("", "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	rec := httptest.NewRecorder()
+	rw, admitted := cq.admit(rec, req)
+	if !admitted {
+		t.Fatal("expected admitted")
+	}
+
+	if _, err := rw.Write([]byte("0123456789")); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	rec = httptest.NewRecorder()
+	if _, admitted := cq.admit(rec, req); admitted {
+		t.Fatal("expected not admitted")
+	} else if got, want := rec.Code, http.StatusTooManyRequests; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	} else if rec.Header().Get("Retry-After") == "" {
+		t.Error("expected Retry-After header")
+	}
+
+	req.RemoteAddr = "10.0.0.2:1234"
+	rec = httptest.NewRecorder()
+	if rw, admitted = cq.admit(rec, req); !admitted {
+		t.Fatal("expected admitted")
+	}
+
+	if _, err := rw.Write([]byte("01234567890123456789")); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if _, admitted := cq.admit(httptest.NewRecorder(), req); !admitted {
+		t.Fatal("expected admitted")
+	}
+
+	r := cq.Report()
+	if got, want := len(r.Clients), 2; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	if got, want := r.Clients[0], (ClientQuotaUsage{
+		Client: "10.0.0.2",
+		Bytes:  20,
+	}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	if got, want := r.Clients[1], (ClientQuotaUsage{
+		Client:   "10.0.0.1",
+		Bytes:    10,
+		MaxBytes: 10,
+	}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	if got, want := r.WindowEnd.Sub(r.WindowStart), 24*time.Hour; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	cq = &ClientQuota{
+		ClientIdentifier: func(req *http.Request) string {
+			return req.Header.Get("X-Team")
+		},
+	}
+
+	req = httptest.NewRequest("", "/", nil)
+	req.Header.Set("X-Team", "foo")
+	if got, want := cq.clientIdentity(req), "foo"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestGoproxyServeQuota(t *testing.T) {
+	g := &Goproxy{
+		GoBinEnv:    []string{"GOPROXY=off"},
+		ClientQuota: &ClientQuota{MaxBytes: 1},
+		AdminAuthorizer: func(*http.Request) bool {
+			return true
+		},
+	}
+	g.init()
+
+	req := httptest.NewRequest("", "/example.com/@v/list", nil)
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, req)
+	if got, want := rec.Code, http.StatusNotFound; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	rec = httptest.NewRecorder()
+	g.ServeHTTP(rec, req)
+	if got, want := rec.Code, http.StatusTooManyRequests; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	req = httptest.NewRequest("", "/-/quota", nil)
+	rec = httptest.NewRecorder()
+	g.ServeHTTP(rec, req)
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	var r ClientQuotaReport
+	if err := json.NewDecoder(rec.Body).Decode(&r); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := len(r.Clients), 1; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	} else if got, want := r.Clients[0].Client, "192.0.2.1"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	g.ClientQuota = nil
+	rec = httptest.NewRecorder()
+	g.ServeHTTP(rec, req)
+	if got, want := rec.Code, http.StatusNotFound; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
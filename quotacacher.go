@@ -0,0 +1,314 @@
+package goproxy
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// QuotaCacher implements the [Cacher] by wrapping another [Cacher], typically a
+// [DirCacher], and keeping the total size of all its caches within a disk
+// quota by evicting the least recently accessed ones.
+//
+// Unlike the [RetentionCacher], the caches that already exist in the wrapped
+// [Cacher] are accounted as well: they are enumerated on first use (which
+// requires the wrapped [Cacher] to be a [DirCacher], a [ShardedDirCacher] or
+// another [Cacher] of this package that can enumerate its caches) and treated
+// as the least recently accessed ones, since their access times are unknown,
+// unless they are recorded in the [QuotaCacher.AccessIndex].
+//
+// Evictions start when the total size crosses the
+// [QuotaCacher.HighWatermarkBytes] and go on until it drops to the
+// [QuotaCacher.LowWatermarkBytes], so that they happen in batches rather than
+// on every put once the quota has been reached.
+//
+// Make sure that all fields of the QuotaCacher have been finalized before
+// calling any of its methods.
+type QuotaCacher struct {
+	// Cacher is the wrapped [Cacher]. It must implement the [Deleter] for
+	// evictions to work.
+	Cacher Cacher
+
+	// HighWatermarkBytes is the total number of bytes of the caches
+	// beyond which evictions start.
+	//
+	// If the HighWatermarkBytes is zero, there is no limit.
+	HighWatermarkBytes int64
+
+	// LowWatermarkBytes is the total number of bytes of the caches that
+	// evictions go down to.
+	//
+	// If the LowWatermarkBytes is zero, 90% of the
+	// [QuotaCacher.HighWatermarkBytes] is used.
+	LowWatermarkBytes int64
+
+	// Pinned reports whether the cache for the name is pinned. Pinned
+	// caches are never evicted, but still count toward the quota since
+	// they occupy the disk all the same. It is typically the
+	// [Goproxy.Pinned].
+	//
+	// If the Pinned is nil, no caches are pinned.
+	Pinned func(name string) bool
+
+	// AccessIndex is the [AccessIndex] that the access times of the caches
+	// are recorded in, so that the existing caches can be ordered by them
+	// on first use and the order of evictions survives restarts.
+	//
+	// If the AccessIndex is nil, access times are only kept in memory.
+	AccessIndex *AccessIndex
+
+	initOnce   sync.Once
+	initErr    error
+	mutex      sync.Mutex
+	entries    map[string]*list.Element
+	lru        list.List
+	totalBytes int64
+}
+
+// quotaEntry is an entry of a cache accounted by a [QuotaCacher].
+type quotaEntry struct {
+	name string
+	size int64
+}
+
+// Get implements the [Cacher].
+func (qc *QuotaCacher) Get(
+	ctx context.Context,
+	name string,
+) (io.ReadCloser, error) {
+	qc.initOnce.Do(qc.init)
+
+	content, err := qc.Cacher.Get(ctx, name)
+
+	qc.mutex.Lock()
+	defer qc.mutex.Unlock()
+	if elem, ok := qc.entries[name]; ok {
+		if errors.Is(err, os.ErrNotExist) {
+			qc.remove(elem)
+		} else if err == nil {
+			qc.lru.MoveToFront(elem)
+			if qc.AccessIndex != nil {
+				qc.AccessIndex.touch(name)
+			}
+		}
+	}
+
+	return content, err
+}
+
+// Put implements the [Cacher].
+func (qc *QuotaCacher) Put(
+	ctx context.Context,
+	name string,
+	content io.ReadSeeker,
+	expiration time.Duration,
+) error {
+	qc.initOnce.Do(qc.init)
+
+	size, err := content.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	} else if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if err := qc.Cacher.Put(ctx, name, content, expiration); err != nil {
+		return err
+	}
+
+	qc.mutex.Lock()
+	if elem, ok := qc.entries[name]; ok {
+		qc.remove(elem)
+	}
+
+	qc.entries[name] = qc.lru.PushFront(&quotaEntry{name: name, size: size})
+	qc.totalBytes += size
+	if qc.AccessIndex != nil {
+		qc.AccessIndex.touch(name)
+	}
+
+	var victims []string
+	if qc.HighWatermarkBytes > 0 && qc.totalBytes > qc.HighWatermarkBytes {
+		lowWatermarkBytes := qc.LowWatermarkBytes
+		if lowWatermarkBytes == 0 {
+			lowWatermarkBytes = qc.HighWatermarkBytes / 10 * 9
+		}
+
+		// The cache just put is the most recently accessed one, so it
+		// is never evicted by its own put.
+		for elem := qc.lru.Back(); elem != qc.lru.Front() &&
+			qc.totalBytes > lowWatermarkBytes; {
+			prev := elem.Prev()
+			e := elem.Value.(*quotaEntry)
+			if !qc.pinned(e.name) {
+				qc.remove(elem)
+				victims = append(victims, e.name)
+			}
+
+			elem = prev
+		}
+	}
+
+	qc.mutex.Unlock()
+
+	return qc.evict(ctx, victims)
+}
+
+// Delete implements the [Deleter].
+func (qc *QuotaCacher) Delete(ctx context.Context, name string) error {
+	d, ok := qc.Cacher.(Deleter)
+	if !ok {
+		return errDeleteNotSupported
+	}
+
+	if err := d.Delete(ctx, name); err != nil {
+		return err
+	}
+
+	qc.forget(name)
+	return nil
+}
+
+// Cleanup implements the [Cacher].
+func (qc *QuotaCacher) Cleanup() error {
+	return qc.CleanupReclaimed(nil)
+}
+
+// CleanupReclaimed implements the [Reclaimer].
+func (qc *QuotaCacher) CleanupReclaimed(
+	reclaimed func(name string, size int64),
+) error {
+	r, ok := qc.Cacher.(Reclaimer)
+	if !ok {
+		return qc.Cacher.Cleanup()
+	}
+
+	return r.CleanupReclaimed(func(name string, size int64) {
+		qc.forget(name)
+		if reclaimed != nil {
+			reclaimed(name, size)
+		}
+	})
+}
+
+// List implements the [Lister].
+func (qc *QuotaCacher) List(ctx context.Context, prefix string) CacheIterator {
+	return listCaches(ctx, qc.walkCaches, prefix)
+}
+
+// walkCaches implements the [cacheWalker].
+func (qc *QuotaCacher) walkCaches(
+	fn func(name string, size int64) error,
+) error {
+	cw, ok := cacheWalkerOf(qc.Cacher)
+	if !ok {
+		return errWalkNotSupported
+	}
+
+	return cw.walkCaches(fn)
+}
+
+// TotalBytes returns the total number of bytes of the caches currently
+// accounted by the qc. It returns the error, if any, that occurred while
+// enumerating the existing caches on first use, in which case only the caches
+// accessed since then are accounted.
+func (qc *QuotaCacher) TotalBytes() (int64, error) {
+	qc.initOnce.Do(qc.init)
+
+	qc.mutex.Lock()
+	defer qc.mutex.Unlock()
+	return qc.totalBytes, qc.initErr
+}
+
+// init initializes the qc by accounting the caches that already exist in the
+// qc.Cacher.
+func (qc *QuotaCacher) init() {
+	qc.entries = map[string]*list.Element{}
+
+	cw, ok := cacheWalkerOf(qc.Cacher)
+	if !ok {
+		qc.initErr = errWalkNotSupported
+		return
+	}
+
+	var (
+		entries      []*quotaEntry
+		lastAccesses = map[string]time.Time{}
+	)
+	qc.initErr = cw.walkCaches(func(name string, size int64) error {
+		if _, ok := qc.entries[name]; !ok {
+			e := &quotaEntry{name: name, size: size}
+			qc.entries[name] = nil
+			entries = append(entries, e)
+			if qc.AccessIndex != nil {
+				t, _ := qc.AccessIndex.LastAccess(name)
+				lastAccesses[name] = t
+			}
+		}
+
+		return nil
+	})
+
+	// The caches whose access times are unknown are the least recently
+	// accessed ones.
+	sort.SliceStable(entries, func(i, j int) bool {
+		return lastAccesses[entries[i].name].After(
+			lastAccesses[entries[j].name],
+		)
+	})
+
+	for _, e := range entries {
+		qc.entries[e.name] = qc.lru.PushBack(e)
+		qc.totalBytes += e.size
+	}
+}
+
+// pinned reports whether the cache for the name is pinned.
+func (qc *QuotaCacher) pinned(name string) bool {
+	return qc.Pinned != nil && qc.Pinned(name)
+}
+
+// forget stops accounting the cache for the name, if any.
+func (qc *QuotaCacher) forget(name string) {
+	qc.mutex.Lock()
+	defer qc.mutex.Unlock()
+	if elem, ok := qc.entries[name]; ok {
+		qc.remove(elem)
+	}
+}
+
+// remove stops accounting the elem. It must be called with the qc.mutex held.
+func (qc *QuotaCacher) remove(elem *list.Element) {
+	e := qc.lru.Remove(elem).(*quotaEntry)
+	delete(qc.entries, e.name)
+	qc.totalBytes -= e.size
+	if qc.AccessIndex != nil {
+		qc.AccessIndex.forget(e.name)
+	}
+}
+
+// evict deletes the caches for the names from the qc.Cacher.
+func (qc *QuotaCacher) evict(ctx context.Context, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+
+	d, ok := qc.Cacher.(Deleter)
+	if !ok {
+		return errDeleteNotSupported
+	}
+
+	for _, name := range names {
+		if err := d.Delete(ctx, name); err != nil &&
+			!errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,181 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQuotaCacher(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestQuotaCacher")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	dc := DirCacher(tempDir)
+	put := func(c Cacher, name string, size int) {
+		if err := c.Put(
+			context.Background(),
+			name,
+			strings.NewReader(strings.Repeat("a", size)),
+			time.Hour,
+		); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	exists := func(name string) bool {
+		content, err := dc.Get(context.Background(), name)
+		if errors.Is(err, os.ErrNotExist) {
+			return false
+		} else if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		content.Close()
+		return true
+	}
+
+	// Existing caches are accounted as the least recently accessed ones.
+	put(dc, "a", 10)
+	put(dc, "b", 10)
+
+	qc := &QuotaCacher{
+		Cacher:             dc,
+		HighWatermarkBytes: 40,
+		LowWatermarkBytes:  20,
+		Pinned:             func(name string) bool { return name == "b" },
+	}
+
+	if got, err := qc.TotalBytes(); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if want := int64(20); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	put(qc, "c", 10)
+	put(qc, "d", 10)
+	if got, _ := qc.TotalBytes(); got != 40 {
+		t.Errorf("got %d, want %d", got, 40)
+	}
+
+	// Accessing a cache makes it the most recently accessed one.
+	if content, err := qc.Get(context.Background(), "c"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else {
+		content.Close()
+	}
+
+	// Crossing the high watermark evicts the least recently accessed
+	// caches that are not pinned, down to the low watermark.
+	put(qc, "e", 10)
+	for name, want := range map[string]bool{
+		"a": false,
+		"b": true,
+		"c": false,
+		"d": false,
+		"e": true,
+	} {
+		if got := exists(name); got != want {
+			t.Errorf("%s: got %t, want %t", name, got, want)
+		}
+	}
+
+	if got, _ := qc.TotalBytes(); got != 20 {
+		t.Errorf("got %d, want %d", got, 20)
+	}
+
+	// A cache larger than the quota never evicts itself.
+	put(qc, "f", 50)
+	if !exists("f") {
+		t.Error("expected cache f")
+	}
+
+	if err := qc.Delete(context.Background(), "f"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, _ := qc.TotalBytes(); got != 10 {
+		t.Errorf("got %d, want %d", got, 10)
+	}
+}
+
+func TestQuotaCacherAccessIndex(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestQuotaCacherAccessIndex")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	dc := DirCacher(tempDir)
+	for _, name := range []string{"a", "b", "c"} {
+		if err := dc.Put(
+			context.Background(),
+			name,
+			strings.NewReader(strings.Repeat("a", 10)),
+			time.Hour,
+		); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	indexFile := filepath.Join(tempDir, ".access-index")
+	if err := ioutil.WriteFile(
+		indexFile,
+		[]byte("300 a\n100 b\n"),
+		0640,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	ai := &AccessIndex{Path: indexFile}
+	qc := &QuotaCacher{
+		Cacher:             dc,
+		HighWatermarkBytes: 30,
+		LowWatermarkBytes:  20,
+		AccessIndex:        ai,
+	}
+
+	// The existing caches are ordered by their recorded access times, and
+	// the ones without any are the least recently accessed ones.
+	if err := qc.Put(
+		context.Background(),
+		"d",
+		strings.NewReader(strings.Repeat("a", 10)),
+		time.Hour,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	for name, want := range map[string]bool{
+		"a": true,
+		"b": false,
+		"c": false,
+		"d": true,
+	} {
+		_, err := dc.Get(context.Background(), name)
+		if got := err == nil; got != want {
+			t.Errorf("%s: got %t, want %t", name, got, want)
+		}
+	}
+
+	if err := ai.Flush(); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	ai = &AccessIndex{Path: indexFile}
+	for name, want := range map[string]bool{
+		"a": true,
+		"b": false,
+		"c": false,
+		"d": true,
+	} {
+		if _, got := ai.LastAccess(name); got != want {
+			t.Errorf("%s: got %t, want %t", name, got, want)
+		}
+	}
+}
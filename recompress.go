@@ -0,0 +1,339 @@
+package goproxy
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/sumdb/dirhash"
+)
+
+// recompressedZipNamePrefix is the prefix of the names of the caches recording
+// the sizes of the cached ".zip" files once recompressed, so that they are not
+// recompressed again.
+const recompressedZipNamePrefix = apiPathPrefix + "recompressed-zips/"
+
+// ZipRecompressor recompresses the ".zip" files cached by a [Goproxy] in the
+// background with a stronger Deflate compression level than the one they were
+// created with, trading CPU for storage savings on large caches.
+//
+// Only the compressed data changes: the names, the order and the extracted
+// contents of the entries are preserved, so the hashes of the recompressed
+// ".zip" files (see the [dirhash.HashZip]) are the same as the originals'
+// (which is verified before replacing them), and so are the go.sum lines and
+// the ".ziphash" responses. A recompressed ".zip" file only replaces the
+// original if it is smaller, and keeps its remaining lifetime.
+//
+// The [Goproxy.Cacher] must be able to enumerate its caches (see the
+// [CacheScanner]), and its contents must implement
+// interface{ Expires() time.Time }, since the caches whose remaining lifetimes
+// are unknown are skipped.
+type ZipRecompressor struct {
+	// Goproxy is the [Goproxy] whose cached ".zip" files are recompressed.
+	Goproxy *Goproxy
+
+	// Level is the Deflate compression level (see the [compress/flate]).
+	//
+	// If the Level is zero, the [flate.BestCompression] is used.
+	Level int
+
+	// Interval is the interval between two rounds.
+	//
+	// If the Interval is zero, 24 hours is used.
+	Interval time.Duration
+}
+
+// ZipRecompressionResult is the result of the [ZipRecompressor.Recompress].
+type ZipRecompressionResult struct {
+	// Recompressed is the number of the ".zip" files that have been
+	// replaced by their recompressed versions.
+	Recompressed int
+
+	// Skipped is the number of the ".zip" files that have been left as
+	// they were, because they have already been recompressed, their
+	// recompressed versions were not smaller, or their remaining
+	// lifetimes are unknown.
+	Skipped int
+
+	// SavedBytes is the number of bytes saved by the recompressions.
+	SavedBytes int64
+}
+
+// Run runs the zr periodically until the ctx is done.
+func (zr *ZipRecompressor) Run(ctx context.Context) error {
+	interval := zr.Interval
+	if interval == 0 {
+		interval = 24 * time.Hour
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := zr.Recompress(ctx); err != nil &&
+			!errors.Is(err, ctx.Err()) {
+			zr.Goproxy.logErrorf(
+				"failed to recompress zips: %v",
+				err,
+			)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Recompress runs a single round of the zr over all the cached ".zip" files.
+func (zr *ZipRecompressor) Recompress(
+	ctx context.Context,
+) (*ZipRecompressionResult, error) {
+	g := zr.Goproxy
+	g.initOnce.Do(g.init)
+	ctx = withBulk(ctx)
+
+	cw, ok := cacheWalkerOf(g.Cacher)
+	if !ok {
+		return nil, errWalkNotSupported
+	}
+
+	var names []string
+	if err := cw.walkCaches(func(name string, size int64) error {
+		if isModuleFileName(name) && strings.HasSuffix(name, ".zip") {
+			names = append(names, name)
+		}
+
+		return ctx.Err()
+	}); err != nil {
+		return nil, err
+	}
+
+	tempDir, err := ioutil.TempDir(g.TempDir, "goproxy")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tempDir)
+
+	r := &ZipRecompressionResult{}
+	for _, name := range names {
+		saved, err := zr.recompressZip(ctx, name, tempDir)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue // Expired since enumerated
+			} else if err := ctx.Err(); err != nil {
+				return r, err
+			}
+
+			g.logErrorf(
+				"failed to recompress zip: %s: %v",
+				name,
+				err,
+			)
+			continue
+		}
+
+		if saved > 0 {
+			r.Recompressed++
+			r.SavedBytes += saved
+		} else {
+			r.Skipped++
+		}
+	}
+
+	return r, nil
+}
+
+// recompressZip recompresses the cached ".zip" file targeted by the name, using
+// the tempDir for the intermediate files, and returns the number of bytes
+// saved.
+func (zr *ZipRecompressor) recompressZip(
+	ctx context.Context,
+	name string,
+	tempDir string,
+) (int64, error) {
+	g := zr.Goproxy
+	content, err := g.cache(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+
+	ec, ok := content.(interface{ Expires() time.Time })
+	if !ok {
+		content.Close()
+		return 0, nil
+	}
+
+	expiration := time.Until(ec.Expires())
+	if expiration <= 0 {
+		content.Close()
+		return 0, os.ErrNotExist
+	}
+
+	srcFile, err := ioutil.TempFile(tempDir, "")
+	if err != nil {
+		content.Close()
+		return 0, err
+	}
+	defer os.Remove(srcFile.Name())
+
+	size, err := io.Copy(srcFile, content)
+	content.Close()
+	if closeErr := srcFile.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	markerName := recompressedZipNamePrefix + name
+	if b, err := g.cacheBytes(ctx, markerName); err == nil &&
+		string(b) == strconv.FormatInt(size, 10) {
+		return 0, nil
+	}
+
+	dstFile := filepath.Join(tempDir, "recompressed.zip")
+	defer os.Remove(dstFile)
+	if err := recompressZip(srcFile.Name(), dstFile, zr.Level); err != nil {
+		return 0, err
+	}
+
+	dstSize, err := zipHashesMatch(srcFile.Name(), dstFile)
+	if err != nil {
+		return 0, err
+	}
+
+	var saved int64
+	if dstSize < size {
+		if err := g.putCacheFile(
+			ctx,
+			name,
+			dstFile,
+			expiration,
+		); err != nil {
+			return 0, err
+		}
+
+		saved = size - dstSize
+		size = dstSize
+	}
+
+	if err := g.putCache(
+		ctx,
+		markerName,
+		strings.NewReader(strconv.FormatInt(size, 10)),
+		expiration,
+	); err != nil {
+		return 0, err
+	}
+
+	return saved, nil
+}
+
+// recompressZip recompresses the zip file targeted by the src into the dst with
+// the Deflate compression level, keeping the order of its entries. A zero level
+// means the [flate.BestCompression].
+func recompressZip(src, dst string, level int) error {
+	if level == 0 {
+		level = flate.BestCompression
+	}
+
+	if _, err := flate.NewWriter(ioutil.Discard, level); err != nil {
+		return err
+	}
+
+	zr, err := zip.OpenReader(src)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	df, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer df.Close()
+
+	zw := zip.NewWriter(df)
+	zw.RegisterCompressor(zip.Deflate, func(
+		w io.Writer,
+	) (io.WriteCloser, error) {
+		return flate.NewWriter(w, level)
+	})
+
+	for _, file := range zr.File {
+		if err := recompressZipFile(zw, file); err != nil {
+			return err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	return df.Close()
+}
+
+// recompressZipFile writes the file to the zw with the Deflate method, keeping
+// its name, comment, modification time and mode.
+func recompressZipFile(zw *zip.Writer, file *zip.File) error {
+	rc, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	fh := &zip.FileHeader{
+		Name:     file.Name,
+		Comment:  file.Comment,
+		Modified: file.Modified,
+		Method:   zip.Deflate,
+	}
+	fh.SetMode(file.Mode())
+
+	w, err := zw.CreateHeader(fh)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, rc)
+	return err
+}
+
+// zipHashesMatch checks whether the hashes (see the [dirhash.HashZip]) of the
+// zip files targeted by the src and the dst match, and returns the size of the
+// dst.
+func zipHashesMatch(src, dst string) (int64, error) {
+	srcHash, err := dirhash.HashZip(src, dirhash.Hash1)
+	if err != nil {
+		return 0, err
+	}
+
+	dstHash, err := dirhash.HashZip(dst, dirhash.Hash1)
+	if err != nil {
+		return 0, err
+	} else if dstHash != srcHash {
+		return 0, fmt.Errorf(
+			"hash mismatch: original %s, recompressed %s",
+			srcHash,
+			dstHash,
+		)
+	}
+
+	fi, err := os.Stat(dst)
+	if err != nil {
+		return 0, err
+	}
+
+	return fi.Size(), nil
+}
@@ -0,0 +1,146 @@
+package goproxy
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/mod/sumdb/dirhash"
+)
+
+func TestZipRecompressor(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestZipRecompressor")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range []string{"go.mod", "foo.go"} {
+		w, err := zw.CreateHeader(&zip.FileHeader{
+			Name:   "example.com@v1.0.0/" + name,
+			Method: zip.Store,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		} else if _, err := w.Write(
+			[]byte(strings.Repeat("package foo\n", 1000)),
+		); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	original := filepath.Join(tempDir, "original.zip")
+	if err := ioutil.WriteFile(original, buf.Bytes(), 0600); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	wantHash, err := dirhash.HashZip(original, dirhash.Hash1)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	g := &Goproxy{
+		Cacher:      DirCacher(filepath.Join(tempDir, "caches")),
+		TempDir:     tempDir,
+		ErrorLogger: log.New(&discardWriter{}, "", 0),
+	}
+
+	const name = "example.com/@v/v1.0.0.zip"
+	if err := g.Cacher.Put(
+		context.Background(),
+		name,
+		bytes.NewReader(buf.Bytes()),
+		time.Hour,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	zr := &ZipRecompressor{Goproxy: g}
+	r, err := zr.Recompress(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := r.Recompressed, 1; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	} else if r.SavedBytes <= 0 {
+		t.Errorf("got %d, want > 0", r.SavedBytes)
+	}
+
+	b, err := g.cacheBytes(context.Background(), name)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := int64(len(b)),
+		int64(buf.Len())-r.SavedBytes; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	recompressed := filepath.Join(tempDir, "recompressed.zip")
+	if err := ioutil.WriteFile(recompressed, b, 0600); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if got, err := dirhash.HashZip(
+		recompressed,
+		dirhash.Hash1,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got != wantHash {
+		t.Errorf("got %q, want %q", got, wantHash)
+	}
+
+	content, err := g.cache(context.Background(), name)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	expires := content.(interface{ Expires() time.Time }).Expires()
+	content.Close()
+	if got := time.Until(expires); got > time.Hour ||
+		got < 59*time.Minute {
+		t.Errorf("got %s, want about %s", got, time.Hour)
+	}
+
+	// Recompressed zips are not recompressed again.
+	r, err = zr.Recompress(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := r.Skipped, 1; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	} else if got, want := r.Recompressed, 0; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	g.Cacher = &MemoryCacher{}
+	if _, err := zr.Recompress(context.Background()); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	zr.Level = 10
+	g.Cacher = DirCacher(filepath.Join(tempDir, "caches"))
+	if err := g.Cacher.Put(
+		context.Background(),
+		name,
+		bytes.NewReader(buf.Bytes()),
+		time.Hour,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if r, err := zr.Recompress(context.Background()); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := r.Recompressed, 0; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,589 @@
+package goproxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultRedisMaxIdleConns is the default maximum number of idle connections
+// kept by a [RedisCacher].
+const defaultRedisMaxIdleConns = 8
+
+// defaultRedisLockTTL is the default TTL of the locks acquired via the
+// [RedisCacher.Lock].
+const defaultRedisLockTTL = 10 * time.Minute
+
+// redisLockPollInterval is the interval at which a [RedisCacher.Lock] retries
+// to acquire a lock held by someone else.
+const redisLockPollInterval = 100 * time.Millisecond
+
+// redisLockPrefix is the prefix of the names of the locks acquired via the
+// [RedisCacher.Lock], which keeps them apart from the caches.
+const redisLockPrefix = "-/lock/"
+
+// redisUnlockScript is the Lua script that deletes the key of a lock only if it
+// is still held with the token, so that a lock that has expired and been
+// acquired by someone else is left alone.
+const redisUnlockScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`
+
+// RedisCacher implements the [Cacher] using a Redis server, which suits the
+// small and frequently requested caches (".info", ".mod", "@latest" and
+// "@v/list") of multiple Goproxy instances sharing the same caches. The
+// expiration of each cache is mapped to the native TTL of its key, so expired
+// caches are removed by the Redis server itself.
+//
+// Large caches can be delegated to another [Cacher] (see the
+// [RedisCacher.ZipCacher]) to keep them out of the memory of the Redis server.
+//
+// The RedisCacher also implements the [FetchLocker], so that the instances can
+// take turns downloading module versions (see the [Goproxy.FetchLocker]).
+//
+// Make sure that all fields of the RedisCacher have been finalized before
+// calling any of its methods.
+type RedisCacher struct {
+	// Addr is the address of the Redis server, such as "localhost:6379".
+	Addr string
+
+	// Username is the username used to authenticate with the Redis server
+	// (Redis 6 ACL).
+	//
+	// If the Username is empty, only the [RedisCacher.Password] is used.
+	Username string
+
+	// Password is the password used to authenticate with the Redis server.
+	//
+	// If the Password is empty, no authentication is performed.
+	Password string
+
+	// DB is the index of the database to select.
+	DB int
+
+	// Prefix is the prefix of the keys, such as "goproxy:".
+	Prefix string
+
+	// TLSConfig is the TLS configuration used to connect to the Redis
+	// server.
+	//
+	// If the TLSConfig is nil, connections are not encrypted.
+	TLSConfig *tls.Config
+
+	// DialTimeout is the maximum amount of time a dial waits for a
+	// connection to the Redis server to complete.
+	//
+	// If the DialTimeout is zero, there is no timeout.
+	DialTimeout time.Duration
+
+	// MaxIdleConns is the maximum number of idle connections kept for
+	// reuse.
+	//
+	// If the MaxIdleConns is zero, 8 is used.
+	MaxIdleConns int
+
+	// ZipCacher is the [Cacher] that the ".zip" caches are delegated to.
+	//
+	// If the ZipCacher is nil, the ".zip" caches are stored in the Redis
+	// server as well.
+	ZipCacher Cacher
+
+	// LockTTL is the time after which a lock acquired via the
+	// [RedisCacher.Lock] expires if it has not been released.
+	//
+	// If the LockTTL is zero, 10 minutes is used.
+	LockTTL time.Duration
+
+	idleConnsOnce sync.Once
+	idleConns     chan *redisConn
+	dialContext   func(context.Context, string, string) (net.Conn, error)
+}
+
+// Get implements the [Cacher].
+func (rc *RedisCacher) Get(
+	ctx context.Context,
+	name string,
+) (io.ReadCloser, error) {
+	if c := rc.zipCacher(name); c != nil {
+		return c.Get(ctx, name)
+	}
+
+	reply, err := rc.do(ctx, "GET", rc.key(name))
+	if err != nil {
+		return nil, err
+	}
+
+	b, ok := reply.([]byte)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	content := bytes.NewReader(b)
+	return struct {
+		io.ReadCloser
+		io.Seeker
+	}{ioutil.NopCloser(content), content}, nil
+}
+
+// Put implements the [Cacher].
+func (rc *RedisCacher) Put(
+	ctx context.Context,
+	name string,
+	content io.ReadSeeker,
+	expiration time.Duration,
+) error {
+	if c := rc.zipCacher(name); c != nil {
+		return c.Put(ctx, name, content, expiration)
+	}
+
+	ttl := expiration.Milliseconds()
+	if ttl <= 0 {
+		// The cache has already expired.
+		_, err := rc.do(ctx, "DEL", rc.key(name))
+		return err
+	}
+
+	b, err := ioutil.ReadAll(content)
+	if err != nil {
+		return err
+	}
+
+	_, err = rc.do(
+		ctx,
+		"SET",
+		rc.key(name),
+		b,
+		"PX",
+		strconv.FormatInt(ttl, 10),
+	)
+	return err
+}
+
+// Delete implements the [Deleter].
+func (rc *RedisCacher) Delete(ctx context.Context, name string) error {
+	if c := rc.zipCacher(name); c != nil {
+		d, ok := c.(Deleter)
+		if !ok {
+			return errDeleteNotSupported
+		}
+
+		return d.Delete(ctx, name)
+	}
+
+	reply, err := rc.do(ctx, "DEL", rc.key(name))
+	if err != nil {
+		return err
+	} else if n, _ := reply.(int64); n == 0 {
+		return os.ErrNotExist
+	}
+
+	return nil
+}
+
+// Cleanup implements the [Cacher]. The Redis server removes expired caches by
+// itself, so only the [RedisCacher.ZipCacher] is cleaned up.
+func (rc *RedisCacher) Cleanup() error {
+	if rc.ZipCacher != nil {
+		return rc.ZipCacher.Cleanup()
+	}
+
+	return nil
+}
+
+// Lock implements the [FetchLocker]. A lock is a key set only if it does not
+// exist yet, with a random token as its value and the [RedisCacher.LockTTL] as
+// its TTL. Its release deletes the key only if it still has the token.
+func (rc *RedisCacher) Lock(ctx context.Context, name string) (func(), error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+
+	token := hex.EncodeToString(b)
+
+	ttl := rc.LockTTL
+	if ttl == 0 {
+		ttl = defaultRedisLockTTL
+	}
+
+	key := rc.key(redisLockPrefix + name)
+	for {
+		reply, err := rc.do(
+			ctx,
+			"SET",
+			key,
+			token,
+			"NX",
+			"PX",
+			strconv.FormatInt(ttl.Milliseconds(), 10),
+		)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+
+			return nil, err
+		} else if reply != nil {
+			break
+		}
+
+		timer := time.NewTimer(redisLockPollInterval)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+
+	return func() {
+		// Failures are left to the TTL.
+		rc.do(
+			context.Background(),
+			"EVAL",
+			redisUnlockScript,
+			"1",
+			key,
+			token,
+		)
+	}, nil
+}
+
+// List implements the [Lister].
+func (rc *RedisCacher) List(ctx context.Context, prefix string) CacheIterator {
+	return listCaches(ctx, rc.walkCaches, prefix)
+}
+
+// walkCaches implements the [cacheWalker]. The caches delegated to the
+// [RedisCacher.ZipCacher] are walked only if it implements the [cacheWalker].
+func (rc *RedisCacher) walkCaches(
+	fn func(name string, size int64) error,
+) error {
+	ctx := context.Background()
+	cursor := "0"
+	for {
+		reply, err := rc.do(
+			ctx,
+			"SCAN",
+			cursor,
+			"MATCH",
+			redisGlobEscape(rc.Prefix)+"*",
+			"COUNT",
+			"1000",
+		)
+		if err != nil {
+			return err
+		}
+
+		replies, ok := reply.([]interface{})
+		if !ok || len(replies) != 2 {
+			return fmt.Errorf("invalid redis SCAN reply %v", reply)
+		}
+
+		nextCursor, _ := replies[0].([]byte)
+		keys, _ := replies[1].([]interface{})
+		for _, key := range keys {
+			key, _ := key.([]byte)
+			name := strings.TrimPrefix(string(key), rc.Prefix)
+			if strings.HasPrefix(name, redisLockPrefix) {
+				continue
+			}
+
+			reply, err := rc.do(ctx, "STRLEN", key)
+			if err != nil {
+				return err
+			}
+
+			size, _ := reply.(int64)
+			if size == 0 {
+				continue // Expired since scanned.
+			}
+
+			if err := fn(name, size); err != nil {
+				return err
+			}
+		}
+
+		cursor = string(nextCursor)
+		if cursor == "0" || cursor == "" {
+			break
+		}
+	}
+
+	if cw, ok := cacheWalkerOf(rc.ZipCacher); ok {
+		return cw.walkCaches(fn)
+	}
+
+	return nil
+}
+
+// zipCacher returns the [RedisCacher.ZipCacher] if the cache for the name is
+// delegated to it. Otherwise, it returns nil.
+func (rc *RedisCacher) zipCacher(name string) Cacher {
+	if rc.ZipCacher != nil && strings.HasSuffix(name, ".zip") {
+		return rc.ZipCacher
+	}
+
+	return nil
+}
+
+// key returns the key of the cache for the name.
+func (rc *RedisCacher) key(name string) string {
+	return rc.Prefix + name
+}
+
+// do sends the command with the args to the Redis server and returns its
+// reply, which is nil, an int64, a []byte, a string or an []interface{}.
+func (rc *RedisCacher) do(
+	ctx context.Context,
+	command string,
+	args ...interface{},
+) (interface{}, error) {
+	conn, err := rc.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := conn.do(ctx, command, args...)
+	if err != nil {
+		var re redisError
+		if !errors.As(err, &re) {
+			// The state of the conn is unknown.
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	rc.putConn(conn)
+
+	return reply, err
+}
+
+// conn returns an idle connection to the Redis server, or a new one if there is
+// none.
+func (rc *RedisCacher) conn(ctx context.Context) (*redisConn, error) {
+	rc.idleConnsOnce.Do(rc.initIdleConns)
+	select {
+	case conn := <-rc.idleConns:
+		return conn, nil
+	default:
+	}
+
+	dialContext := rc.dialContext
+	if dialContext == nil {
+		dialer := &net.Dialer{Timeout: rc.DialTimeout}
+		dialContext = dialer.DialContext
+	}
+
+	netConn, err := dialContext(ctx, "tcp", rc.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if rc.TLSConfig != nil {
+		tlsConfig := rc.TLSConfig.Clone()
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName, _, _ = net.SplitHostPort(rc.Addr)
+		}
+
+		netConn = tls.Client(netConn, tlsConfig)
+	}
+
+	conn := &redisConn{
+		Conn: netConn,
+		r:    bufio.NewReader(netConn),
+		w:    bufio.NewWriter(netConn),
+	}
+
+	if rc.Password != "" {
+		args := []interface{}{rc.Password}
+		if rc.Username != "" {
+			args = []interface{}{rc.Username, rc.Password}
+		}
+
+		if _, err := conn.do(ctx, "AUTH", args...); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	if rc.DB != 0 {
+		if _, err := conn.do(
+			ctx,
+			"SELECT",
+			strconv.Itoa(rc.DB),
+		); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+// initIdleConns initializes the rc.idleConns.
+func (rc *RedisCacher) initIdleConns() {
+	maxIdleConns := rc.MaxIdleConns
+	if maxIdleConns <= 0 {
+		maxIdleConns = defaultRedisMaxIdleConns
+	}
+
+	rc.idleConns = make(chan *redisConn, maxIdleConns)
+}
+
+// putConn puts the conn back to the idle connections, or closes it if there
+// are too many.
+func (rc *RedisCacher) putConn(conn *redisConn) {
+	select {
+	case rc.idleConns <- conn:
+	default:
+		conn.Close()
+	}
+}
+
+// redisGlobEscape returns the s with the special characters of Redis glob-style
+// patterns escaped.
+func redisGlobEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// redisError is an error reply of a Redis server.
+type redisError string
+
+// Error implements the error.
+func (re redisError) Error() string {
+	return "redis: " + string(re)
+}
+
+// redisConn is a connection to a Redis server speaking the RESP2 protocol.
+type redisConn struct {
+	net.Conn
+
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// do sends the command with the args, each of which is a string or a []byte,
+// and returns the reply.
+func (rc *redisConn) do(
+	ctx context.Context,
+	command string,
+	args ...interface{},
+) (interface{}, error) {
+	deadline, _ := ctx.Deadline()
+	if err := rc.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(rc.w, "*%d\r\n", len(args)+1)
+	writeRedisBulkString(rc.w, []byte(command))
+	for _, arg := range args {
+		switch arg := arg.(type) {
+		case string:
+			writeRedisBulkString(rc.w, []byte(arg))
+		case []byte:
+			writeRedisBulkString(rc.w, arg)
+		default:
+			return nil, fmt.Errorf(
+				"unsupported redis argument type %T",
+				arg,
+			)
+		}
+	}
+
+	if err := rc.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	return readRedisReply(rc.r)
+}
+
+// writeRedisBulkString writes the b as a RESP bulk string to the w.
+func writeRedisBulkString(w *bufio.Writer, b []byte) {
+	fmt.Fprintf(w, "$%d\r\n", len(b))
+	w.Write(b)
+	w.WriteString("\r\n")
+}
+
+// readRedisReply reads a RESP reply from the r. Error replies are returned as
+// [redisError]s.
+func readRedisReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	} else if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("invalid redis reply line %q", line)
+	}
+
+	line = line[:len(line)-2]
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		} else if n < 0 {
+			return nil, nil
+		}
+
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		} else if n < 0 {
+			return nil, nil
+		}
+
+		replies := make([]interface{}, n)
+		for i := range replies {
+			replies[i], err = readRedisReply(r)
+			if err != nil {
+				var re redisError
+				if !errors.As(err, &re) {
+					return nil, err
+				}
+
+				replies[i] = re
+			}
+		}
+
+		return replies, nil
+	}
+
+	return nil, fmt.Errorf("invalid redis reply type %q", line[0])
+}
@@ -0,0 +1,386 @@
+package goproxy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedisServer is a minimal Redis server for testing.
+type fakeRedisServer struct {
+	listener net.Listener
+	password string
+
+	mutex  sync.Mutex
+	values map[string]string
+	ttls   map[string]string
+	dbs    []string
+}
+
+// newFakeRedisServer returns a new [fakeRedisServer] listening on a random
+// local port.
+func newFakeRedisServer(t *testing.T, password string) *fakeRedisServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	frs := &fakeRedisServer{
+		listener: l,
+		password: password,
+		values:   map[string]string{},
+		ttls:     map[string]string{},
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go frs.serve(conn)
+		}
+	}()
+
+	return frs
+}
+
+// serve serves the conn.
+func (frs *fakeRedisServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	authorized := frs.password == ""
+	for {
+		reply, err := readRedisReply(r)
+		if err != nil {
+			return
+		}
+
+		var args []string
+		for _, arg := range reply.([]interface{}) {
+			args = append(args, string(arg.([]byte)))
+		}
+
+		if args[0] == "AUTH" {
+			authorized = args[len(args)-1] == frs.password
+			if !authorized {
+				io.WriteString(conn, "-WRONGPASS invalid\r\n")
+				continue
+			}
+
+			io.WriteString(conn, "+OK\r\n")
+			continue
+		} else if !authorized {
+			io.WriteString(conn, "-NOAUTH required\r\n")
+			continue
+		}
+
+		frs.mutex.Lock()
+		io.WriteString(conn, frs.handle(args))
+		frs.mutex.Unlock()
+	}
+}
+
+// handle handles the command in the args and returns the encoded reply.
+func (frs *fakeRedisServer) handle(args []string) string {
+	bulk := func(s string) string {
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+	}
+
+	switch args[0] {
+	case "SELECT":
+		frs.dbs = append(frs.dbs, args[1])
+		return "+OK\r\n"
+	case "GET":
+		v, ok := frs.values[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+
+		return bulk(v)
+	case "SET":
+		if args[3] == "NX" {
+			if _, ok := frs.values[args[1]]; ok {
+				return "$-1\r\n"
+			}
+
+			args = append(args[:3], args[4:]...)
+		}
+
+		frs.values[args[1]] = args[2]
+		frs.ttls[args[1]] = args[4]
+		return "+OK\r\n"
+	case "EVAL": // Only the redisUnlockScript
+		if frs.values[args[3]] != args[4] {
+			return ":0\r\n"
+		}
+
+		delete(frs.values, args[3])
+		return ":1\r\n"
+	case "DEL":
+		_, ok := frs.values[args[1]]
+		delete(frs.values, args[1])
+		if !ok {
+			return ":0\r\n"
+		}
+
+		return ":1\r\n"
+	case "STRLEN":
+		return fmt.Sprintf(":%d\r\n", len(frs.values[args[1]]))
+	case "SCAN":
+		prefix := strings.TrimSuffix(args[3], "*")
+		var keys []string
+		for key := range frs.values {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+
+		reply := fmt.Sprintf("*2\r\n%s*%d\r\n", bulk("0"), len(keys))
+		for _, key := range keys {
+			reply += bulk(key)
+		}
+
+		return reply
+	}
+
+	return "-ERR unknown command\r\n"
+}
+
+// Close closes the frs.
+func (frs *fakeRedisServer) Close() error {
+	return frs.listener.Close()
+}
+
+func TestRedisCacher(t *testing.T) {
+	frs := newFakeRedisServer(t, "password")
+	defer frs.Close()
+
+	tempDir, err := ioutil.TempDir("", "goproxy.TestRedisCacher")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	rc := &RedisCacher{
+		Addr:      frs.listener.Addr().String(),
+		Username:  "goproxy",
+		Password:  "password",
+		DB:        2,
+		Prefix:    "goproxy:",
+		ZipCacher: DirCacher(tempDir),
+	}
+
+	ctx := context.Background()
+	if _, err := rc.Get(ctx, "a"); !os.IsNotExist(err) {
+		t.Fatalf("got %v, want %v", err, os.ErrNotExist)
+	}
+
+	for _, name := range []string{"a", "b", "c@v/list", "c@v/v1.0.0.zip"} {
+		expiration := time.Hour
+		if name == "b" {
+			expiration = -time.Hour
+		}
+
+		err := rc.Put(
+			ctx,
+			name,
+			strings.NewReader("content of "+name),
+			expiration,
+		)
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	if got, want := frs.ttls["goproxy:a"], "3600000"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if _, ok := frs.values["goproxy:b"]; ok {
+		t.Error("expected no expired cache")
+	}
+
+	if _, ok := frs.values["goproxy:c@v/v1.0.0.zip"]; ok {
+		t.Error("expected zip cache to be delegated")
+	}
+
+	for _, name := range []string{"c@v/list", "c@v/v1.0.0.zip"} {
+		content, err := rc.Get(ctx, name)
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		b, err := ioutil.ReadAll(content)
+		content.Close()
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		} else if got, want := string(b),
+			"content of "+name; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+
+	var names []string
+	if err := rc.walkCaches(func(name string, size int64) error {
+		names = append(names, name)
+		return nil
+	}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	sort.Strings(names)
+	if got, want := strings.Join(names, ","),
+		"a,c@v/list,c@v/v1.0.0.zip"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if err := rc.Delete(ctx, "a"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if err := rc.Delete(ctx, "a"); !os.IsNotExist(err) {
+		t.Fatalf("got %v, want %v", err, os.ErrNotExist)
+	}
+
+	if err := rc.Delete(ctx, "c@v/v1.0.0.zip"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if got, want := strings.Join(frs.dbs, ","), "2"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	rc = &RedisCacher{Addr: frs.listener.Addr().String()}
+	if _, err := rc.Get(ctx, "c@v/list"); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestRedisCacherLock(t *testing.T) {
+	frs := newFakeRedisServer(t, "")
+	defer frs.Close()
+
+	rc := &RedisCacher{
+		Addr:    frs.listener.Addr().String(),
+		Prefix:  "goproxy:",
+		LockTTL: time.Minute,
+	}
+
+	ctx := context.Background()
+	unlock, err := rc.Lock(ctx, "example.com/@v/v1.0.0")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	key := "goproxy:-/lock/example.com/@v/v1.0.0"
+	frs.mutex.Lock()
+	token := frs.values[key]
+	ttl := frs.ttls[key]
+	frs.mutex.Unlock()
+	if got, want := len(token), 32; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	if got, want := ttl, "60000"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if err := rc.walkCaches(func(name string, size int64) error {
+		t.Errorf("unexpected cache %q", name)
+		return nil
+	}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(
+		ctx,
+		3*redisLockPollInterval,
+	)
+	_, err = rc.Lock(timeoutCtx, "example.com/@v/v1.0.0")
+	cancel()
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	locked := make(chan func())
+	go func() {
+		unlock, err := rc.Lock(ctx, "example.com/@v/v1.0.0")
+		if err != nil {
+			t.Errorf("unexpected error %q", err)
+		}
+
+		locked <- unlock
+	}()
+
+	unlock()
+	select {
+	case unlock := <-locked:
+		if unlock == nil {
+			t.FailNow()
+		}
+
+		// A lock that has been taken over is left alone.
+		frs.mutex.Lock()
+		frs.values[key] = "other"
+		frs.mutex.Unlock()
+
+		unlock()
+
+		frs.mutex.Lock()
+		got := frs.values[key]
+		frs.mutex.Unlock()
+		if want := "other"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	case <-time.After(10 * redisLockPollInterval):
+		t.Fatal("timed out waiting for lock")
+	}
+}
+
+func TestReadRedisReply(t *testing.T) {
+	for _, tt := range []struct {
+		reply   string
+		want    string
+		wantErr bool
+	}{
+		{"+OK\r\n", "OK", false},
+		{":42\r\n", "42", false},
+		{"$5\r\nhello\r\n", "[104 101 108 108 111]", false},
+		{"$-1\r\n", "<nil>", false},
+		{"*2\r\n$1\r\na\r\n:1\r\n", "[[97] 1]", false},
+		{"-ERR oops\r\n", "", true},
+		{"?\r\n", "", true},
+		{"+OK\n", "", true},
+	} {
+		r := bufio.NewReader(strings.NewReader(tt.reply))
+		reply, err := readRedisReply(r)
+		if tt.wantErr {
+			if err == nil {
+				t.Fatal("expected error")
+			}
+
+			continue
+		} else if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		if got := fmt.Sprint(reply); got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestRedisGlobEscape(t *testing.T) {
+	if got, want := redisGlobEscape("a*b?[c]\\"),
+		`a\*b\?\[c\]\\`; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
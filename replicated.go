@@ -0,0 +1,304 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errReplicationQueueFull is reported when a replication is dropped because
+// the queue of its secondary is full.
+var errReplicationQueueFull = errors.New("replication queue full")
+
+// ReplicatedCacher implements the [Cacher] by writing to a primary [Cacher] and
+// asynchronously mirroring the writes to one or more secondary [Cacher]s, e.g.
+// the caches of warm standby proxies in other regions.
+//
+// Puts and deletes return once the primary has been written to. Each secondary
+// then catches up in the background, in order, by copying the current state of
+// the cache from the primary, so a replication of a cache that has been deleted
+// from the primary meanwhile deletes it from the secondary. Failed replications
+// are retried with exponential backoff.
+//
+// Gets are served by the primary. If it fails (for another reason than the
+// cache not being found), the secondaries are tried in order.
+//
+// Only the caches of the primary are enumerated.
+//
+// Make sure that all fields of the ReplicatedCacher have been finalized before
+// calling any of its methods.
+type ReplicatedCacher struct {
+	// Primary is the primary [Cacher].
+	Primary Cacher
+
+	// Secondaries is the list of the secondary [Cacher]s.
+	Secondaries []Cacher
+
+	// MaxQueueLen is the maximum number of pending replications of each
+	// secondary. Further ones are dropped.
+	//
+	// If the MaxQueueLen is zero, 10000 is used.
+	MaxQueueLen int
+
+	// MaxAttempts is the maximum number of attempts of each replication.
+	//
+	// If the MaxAttempts is zero, 5 is used.
+	MaxAttempts int
+
+	// RetryInterval is the interval before the first retry of a failed
+	// replication, doubled for each further retry.
+	//
+	// If the RetryInterval is zero, one second is used.
+	RetryInterval time.Duration
+
+	// OnReplicationFailure is called when a replication of the cache for
+	// the name to the secondary at the index is given up, with the last
+	// error.
+	//
+	// If the OnReplicationFailure is nil, failures are ignored.
+	OnReplicationFailure func(name string, index int, err error)
+
+	initOnce sync.Once
+	queues   []chan *replication
+	pending  int64
+}
+
+// replication is a pending replication of a cache by a [ReplicatedCacher].
+type replication struct {
+	// name is the name of the cache.
+	name string
+
+	// expires is the expiration time of the cache, used when the content
+	// got from the primary does not tell it. It is zero for deletes.
+	expires time.Time
+
+	// attempts is the number of attempts made so far.
+	attempts int
+}
+
+// init initializes the rc.
+func (rc *ReplicatedCacher) init() {
+	maxQueueLen := rc.MaxQueueLen
+	if maxQueueLen == 0 {
+		maxQueueLen = 10000
+	}
+
+	rc.queues = make([]chan *replication, len(rc.Secondaries))
+	for i := range rc.Secondaries {
+		rc.queues[i] = make(chan *replication, maxQueueLen)
+		go rc.replicateQueue(i)
+	}
+}
+
+// Get implements the [Cacher].
+func (rc *ReplicatedCacher) Get(
+	ctx context.Context,
+	name string,
+) (io.ReadCloser, error) {
+	content, err := rc.Primary.Get(ctx, name)
+	if err == nil || errors.Is(err, os.ErrNotExist) {
+		return content, err
+	}
+
+	for _, secondary := range rc.Secondaries {
+		if content, err := secondary.Get(ctx, name); err == nil {
+			return content, nil
+		}
+	}
+
+	return nil, err
+}
+
+// Put implements the [Cacher].
+func (rc *ReplicatedCacher) Put(
+	ctx context.Context,
+	name string,
+	content io.ReadSeeker,
+	expiration time.Duration,
+) error {
+	rc.initOnce.Do(rc.init)
+	if err := rc.Primary.Put(ctx, name, content, expiration); err != nil {
+		return err
+	}
+
+	rc.enqueue(name, time.Now().Add(expiration))
+
+	return nil
+}
+
+// Delete implements the [Deleter].
+func (rc *ReplicatedCacher) Delete(ctx context.Context, name string) error {
+	rc.initOnce.Do(rc.init)
+	d, ok := rc.Primary.(Deleter)
+	if !ok {
+		return errDeleteNotSupported
+	}
+
+	if err := d.Delete(ctx, name); err != nil {
+		return err
+	}
+
+	rc.enqueue(name, time.Time{})
+
+	return nil
+}
+
+// Cleanup implements the [Cacher]. The primary and all the secondaries are
+// cleaned up even if some of them fail, and the first error is returned.
+func (rc *ReplicatedCacher) Cleanup() error {
+	return rc.CleanupReclaimed(nil)
+}
+
+// CleanupReclaimed implements the [Reclaimer]. Only the caches reclaimed from
+// the primary are reported.
+func (rc *ReplicatedCacher) CleanupReclaimed(
+	reclaimed func(name string, size int64),
+) error {
+	var firstErr error
+	if r, ok := rc.Primary.(Reclaimer); ok {
+		firstErr = r.CleanupReclaimed(reclaimed)
+	} else {
+		firstErr = rc.Primary.Cleanup()
+	}
+
+	for _, secondary := range rc.Secondaries {
+		if err := secondary.Cleanup(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// List implements the [Lister].
+func (rc *ReplicatedCacher) List(
+	ctx context.Context,
+	prefix string,
+) CacheIterator {
+	return listCaches(ctx, rc.walkCaches, prefix)
+}
+
+// walkCaches implements the [cacheWalker].
+func (rc *ReplicatedCacher) walkCaches(
+	fn func(name string, size int64) error,
+) error {
+	cw, ok := cacheWalkerOf(rc.Primary)
+	if !ok {
+		return errWalkNotSupported
+	}
+
+	return cw.walkCaches(fn)
+}
+
+// Pending returns the number of the replications that have not been completed
+// or given up yet.
+func (rc *ReplicatedCacher) Pending() int {
+	return int(atomic.LoadInt64(&rc.pending))
+}
+
+// enqueue queues the replications of the cache for the name, which expires at
+// the expires, to all the secondaries.
+func (rc *ReplicatedCacher) enqueue(name string, expires time.Time) {
+	for i := range rc.Secondaries {
+		atomic.AddInt64(&rc.pending, 1)
+		rc.requeue(i, &replication{name: name, expires: expires})
+	}
+}
+
+// requeue queues the r to the secondary at the index, or gives it up if the
+// queue is full.
+func (rc *ReplicatedCacher) requeue(index int, r *replication) {
+	select {
+	case rc.queues[index] <- r:
+	default:
+		rc.fail(index, r, errReplicationQueueFull)
+	}
+}
+
+// fail gives up the r to the secondary at the index with the err.
+func (rc *ReplicatedCacher) fail(index int, r *replication, err error) {
+	atomic.AddInt64(&rc.pending, -1)
+	if rc.OnReplicationFailure != nil {
+		rc.OnReplicationFailure(r.name, index, err)
+	}
+}
+
+// replicateQueue runs the replications queued to the secondary at the index.
+func (rc *ReplicatedCacher) replicateQueue(index int) {
+	maxAttempts := rc.MaxAttempts
+	if maxAttempts == 0 {
+		maxAttempts = 5
+	}
+
+	retryInterval := rc.RetryInterval
+	if retryInterval == 0 {
+		retryInterval = time.Second
+	}
+
+	for r := range rc.queues[index] {
+		err := rc.replicate(context.Background(), index, r)
+		if err == nil {
+			atomic.AddInt64(&rc.pending, -1)
+			continue
+		}
+
+		r.attempts++
+		if r.attempts >= maxAttempts {
+			rc.fail(index, r, err)
+			continue
+		}
+
+		r := r
+		time.AfterFunc(
+			retryInterval<<uint(r.attempts-1),
+			func() { rc.requeue(index, r) },
+		)
+	}
+}
+
+// replicate copies the current state of the cache of the r from the primary to
+// the secondary at the index.
+func (rc *ReplicatedCacher) replicate(
+	ctx context.Context,
+	index int,
+	r *replication,
+) error {
+	secondary := rc.Secondaries[index]
+	content, err := rc.Primary.Get(ctx, r.name)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		d, ok := secondary.(Deleter)
+		if !ok {
+			return nil // Left to expire
+		}
+
+		err := d.Delete(ctx, r.name)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		return nil
+	}
+	defer content.Close()
+
+	expires := r.expires
+	if ec, ok := content.(interface{ Expires() time.Time }); ok {
+		expires = ec.Expires()
+	}
+
+	expiration := time.Until(expires)
+	if expires.IsZero() || expiration <= 0 {
+		// Put again meanwhile, which is replicated separately, or
+		// expired already.
+		return nil
+	}
+
+	return putStream(ctx, secondary, r.name, content, expiration, "")
+}
@@ -0,0 +1,271 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyCacher is a [Cacher] whose first puts and gets fail.
+type flakyCacher struct {
+	Cacher
+
+	mutex       sync.Mutex
+	putFailures int
+	getFailures int
+	putBlock    chan struct{}
+}
+
+// Get implements the [Cacher].
+func (fc *flakyCacher) Get(
+	ctx context.Context,
+	name string,
+) (io.ReadCloser, error) {
+	fc.mutex.Lock()
+	if fc.getFailures != 0 {
+		fc.getFailures--
+		fc.mutex.Unlock()
+		return nil, errors.New("get failed")
+	}
+	fc.mutex.Unlock()
+
+	return fc.Cacher.Get(ctx, name)
+}
+
+// Put implements the [Cacher].
+func (fc *flakyCacher) Put(
+	ctx context.Context,
+	name string,
+	content io.ReadSeeker,
+	expiration time.Duration,
+) error {
+	if fc.putBlock != nil {
+		<-fc.putBlock
+	}
+
+	fc.mutex.Lock()
+	if fc.putFailures != 0 {
+		fc.putFailures--
+		fc.mutex.Unlock()
+		return errors.New("put failed")
+	}
+	fc.mutex.Unlock()
+
+	return fc.Cacher.Put(ctx, name, content, expiration)
+}
+
+// Delete implements the [Deleter].
+func (fc *flakyCacher) Delete(ctx context.Context, name string) error {
+	return fc.Cacher.(Deleter).Delete(ctx, name)
+}
+
+// walkCaches implements the [cacheWalker].
+func (fc *flakyCacher) walkCaches(
+	fn func(name string, size int64) error,
+) error {
+	return fc.Cacher.(cacheWalker).walkCaches(fn)
+}
+
+// waitReplications waits for all replications of the rc to be completed or
+// given up.
+func waitReplications(t *testing.T, rc *ReplicatedCacher) {
+	for i := 0; i < 500 && rc.Pending() > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if got := rc.Pending(); got != 0 {
+		t.Fatalf("got %d pending replications, want 0", got)
+	}
+}
+
+func TestReplicatedCacher(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestReplicatedCacher")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	var (
+		failuresMutex sync.Mutex
+		failures      []string
+	)
+	primary := &flakyCacher{Cacher: DirCacher(filepath.Join(tempDir, "a"))}
+	secondaries := []Cacher{
+		DirCacher(filepath.Join(tempDir, "b")),
+		&flakyCacher{
+			Cacher:      DirCacher(filepath.Join(tempDir, "c")),
+			putFailures: 2,
+		},
+	}
+	rc := &ReplicatedCacher{
+		Primary:       primary,
+		Secondaries:   secondaries,
+		MaxAttempts:   3,
+		RetryInterval: 10 * time.Millisecond,
+		OnReplicationFailure: func(name string, index int, err error) {
+			failuresMutex.Lock()
+			defer failuresMutex.Unlock()
+			failures = append(failures, name)
+		},
+	}
+
+	name := "example.com/@v/v1.0.0.info"
+	if err := rc.Put(
+		context.Background(),
+		name,
+		strings.NewReader("foobar"),
+		time.Hour,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	waitReplications(t, rc)
+	for n, secondary := range secondaries {
+		content, err := secondary.Get(context.Background(), name)
+		if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", n, err)
+		}
+
+		b, err := ioutil.ReadAll(content)
+		if err != nil {
+			content.Close()
+			t.Fatalf("test(%d): unexpected error %q", n, err)
+		} else if got, want := string(b), "foobar"; got != want {
+			t.Errorf("test(%d): got %q, want %q", n, got, want)
+		}
+
+		expires := content.(interface{ Expires() time.Time }).Expires()
+		content.Close()
+		if d := time.Until(expires); d <= 0 || d > time.Hour {
+			t.Errorf("test(%d): got expiration %s", n, d)
+		}
+	}
+
+	// Gets fail over to the secondaries.
+	primary.getFailures = 1
+	content, err := rc.Get(context.Background(), name)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	content.Close()
+
+	if err := rc.Delete(context.Background(), name); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	waitReplications(t, rc)
+	for n, secondary := range secondaries {
+		if _, err := secondary.Get(
+			context.Background(),
+			name,
+		); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("test(%d): got error %q, want error %q",
+				n, err, os.ErrNotExist)
+		}
+	}
+
+	if _, err := rc.Get(
+		context.Background(),
+		name,
+	); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got error %q, want error %q", err, os.ErrNotExist)
+	}
+
+	// Replications are given up after the maximum number of attempts.
+	secondaries[1].(*flakyCacher).putFailures = 3
+	if err := rc.Put(
+		context.Background(),
+		name,
+		strings.NewReader("foobar"),
+		time.Hour,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	waitReplications(t, rc)
+	failuresMutex.Lock()
+	if got, want := strings.Join(failures, " "), name; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	failuresMutex.Unlock()
+
+	var names []string
+	it := rc.List(context.Background(), "")
+	for it.Next() {
+		names = append(names, it.Cache().Name)
+	}
+
+	if err := it.Close(); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := strings.Join(names, " "), name; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestReplicatedCacherQueueFull(t *testing.T) {
+	tempDir, err := ioutil.TempDir(
+		"",
+		"goproxy.TestReplicatedCacherQueueFull",
+	)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	var (
+		failuresMutex sync.Mutex
+		failures      []error
+	)
+	secondary := &flakyCacher{
+		Cacher:   DirCacher(filepath.Join(tempDir, "b")),
+		putBlock: make(chan struct{}),
+	}
+	rc := &ReplicatedCacher{
+		Primary:     DirCacher(filepath.Join(tempDir, "a")),
+		Secondaries: []Cacher{secondary},
+		MaxQueueLen: 1,
+		OnReplicationFailure: func(name string, index int, err error) {
+			failuresMutex.Lock()
+			defer failuresMutex.Unlock()
+			failures = append(failures, err)
+		},
+	}
+
+	for _, name := range []string{
+		"example.com/@v/v1.0.0.info",
+		"example.com/@v/v1.1.0.info",
+		"example.com/@v/v1.2.0.info",
+	} {
+		if err := rc.Put(
+			context.Background(),
+			name,
+			strings.NewReader("foobar"),
+			time.Hour,
+		); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	close(secondary.putBlock)
+	waitReplications(t, rc)
+
+	failuresMutex.Lock()
+	defer failuresMutex.Unlock()
+	if len(failures) == 0 {
+		t.Fatal("expected failures")
+	}
+
+	for _, err := range failures {
+		if !errors.Is(err, errReplicationQueueFull) {
+			t.Errorf("got error %q, want error %q",
+				err, errReplicationQueueFull)
+		}
+	}
+}
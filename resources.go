@@ -0,0 +1,186 @@
+package goproxy
+
+import (
+	"context"
+	"runtime"
+)
+
+// ResourceLimits is the limits of the resources available to the current
+// process, as detected from its cgroup (on Linux) and its resource limits.
+type ResourceLimits struct {
+	// MemoryBytes is the maximum number of bytes of memory. It is zero if
+	// unlimited or unknown.
+	MemoryBytes int64
+
+	// OpenFiles is the maximum number of open file descriptors. It is zero
+	// if unlimited or unknown.
+	OpenFiles int64
+
+	// CPUs is the number of CPUs, possibly fractional when limited by a
+	// cgroup CPU quota.
+	CPUs float64
+}
+
+// DetectResourceLimits detects the [ResourceLimits] of the current process.
+func DetectResourceLimits() ResourceLimits {
+	rl := detectResourceLimits()
+	if rl.CPUs <= 0 {
+		rl.CPUs = float64(runtime.NumCPU())
+	}
+
+	return rl
+}
+
+// EffectiveLimits is the limits in effect for a [Goproxy], as configured or
+// derived from its detected [ResourceLimits] (see the
+// [Goproxy.ResourceGuardrails]). A zero limit means no limit.
+type EffectiveLimits struct {
+	// Detected is the detected [ResourceLimits]. It is zero if the
+	// [Goproxy.ResourceGuardrails] is false.
+	Detected ResourceLimits
+
+	// GoBinMaxWorkers is the effective [Goproxy.GoBinMaxWorkers].
+	GoBinMaxWorkers int
+
+	// MaxReadAheads is the effective [Goproxy.MaxReadAheads].
+	MaxReadAheads int
+
+	// MaxOpenCaches is the effective [Goproxy.MaxOpenCaches].
+	MaxOpenCaches int
+
+	// StreamBufferBytes is the size of the buffer used to stream large
+	// responses to clients.
+	StreamBufferBytes int
+}
+
+const (
+	// goBinWorkerMemoryBytes is the memory budgeted for each worker of the
+	// Go binary when deriving the [EffectiveLimits].
+	goBinWorkerMemoryBytes = 512 << 20
+
+	// goBinWorkerOpenFiles is the number of open file descriptors budgeted
+	// for each worker of the Go binary when deriving the
+	// [EffectiveLimits].
+	goBinWorkerOpenFiles = 256
+
+	// readAheadMemoryBytes is the memory budgeted for each read-ahead when
+	// deriving the [EffectiveLimits].
+	readAheadMemoryBytes = 64 << 20
+
+	// readAheadOpenFiles is the number of open file descriptors budgeted
+	// for each read-ahead when deriving the [EffectiveLimits].
+	readAheadOpenFiles = 128
+
+	// defaultStreamBufferBytes is the default size of the buffer used to
+	// stream large responses to clients.
+	defaultStreamBufferBytes = 32 << 10
+
+	// smallStreamBufferBytes is the size of the buffer used to stream large
+	// responses to clients when the memory is below the
+	// smallMemoryBytes.
+	smallStreamBufferBytes = 8 << 10
+
+	// smallMemoryBytes is the memory below which the smaller buffers are
+	// used.
+	smallMemoryBytes = 1 << 30
+)
+
+// deriveEffectiveLimits returns the [EffectiveLimits] of the g. If the
+// rl is not nil, the limits left zero by the g are derived from it.
+func (g *Goproxy) deriveEffectiveLimits(rl *ResourceLimits) EffectiveLimits {
+	el := EffectiveLimits{
+		GoBinMaxWorkers:   g.GoBinMaxWorkers,
+		MaxReadAheads:     g.MaxReadAheads,
+		MaxOpenCaches:     g.MaxOpenCaches,
+		StreamBufferBytes: defaultStreamBufferBytes,
+	}
+	if el.MaxReadAheads <= 0 {
+		el.MaxReadAheads = defaultMaxReadAheads
+	}
+
+	if rl == nil {
+		return el
+	}
+
+	el.Detected = *rl
+	if g.GoBinMaxWorkers == 0 {
+		el.GoBinMaxWorkers = budgetedWorkers(
+			rl,
+			0,
+			goBinWorkerMemoryBytes,
+			goBinWorkerOpenFiles,
+		)
+	}
+
+	if g.MaxReadAheads == 0 {
+		el.MaxReadAheads = budgetedWorkers(
+			rl,
+			defaultMaxReadAheads,
+			readAheadMemoryBytes,
+			readAheadOpenFiles,
+		)
+	}
+
+	// Half of the open file descriptors are left for connections and the
+	// Go binary.
+	if g.MaxOpenCaches == 0 && rl.OpenFiles > 0 {
+		el.MaxOpenCaches = int(rl.OpenFiles / 2)
+		if el.MaxOpenCaches < 1 {
+			el.MaxOpenCaches = 1
+		}
+	}
+
+	if rl.MemoryBytes > 0 && rl.MemoryBytes < smallMemoryBytes {
+		el.StreamBufferBytes = smallStreamBufferBytes
+	}
+
+	return el
+}
+
+// budgetedWorkers returns the number of workers that fit in the rl given the
+// memoryBytes and the openFiles budgeted for each of them, capped at the max
+// (zero means no cap). It returns at least 1 if anything is limited, and the
+// max otherwise.
+func budgetedWorkers(
+	rl *ResourceLimits,
+	max int,
+	memoryBytes int64,
+	openFiles int64,
+) int {
+	workers := max
+	limit := func(n int64) {
+		if n < 1 {
+			n = 1
+		}
+
+		if workers == 0 || int(n) < workers {
+			workers = int(n)
+		}
+	}
+
+	if rl.MemoryBytes > 0 {
+		limit(rl.MemoryBytes / memoryBytes)
+	}
+
+	if rl.OpenFiles > 0 {
+		limit(rl.OpenFiles / openFiles)
+	}
+
+	return workers
+}
+
+// acquireOpenCache waits until a cache can be opened without exceeding the
+// [EffectiveLimits.MaxOpenCaches] or the ctx is done. The returned release
+// must be called once the cache has been closed.
+func (g *Goproxy) acquireOpenCache(ctx context.Context) (func(), error) {
+	if g.openCaches == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case g.openCaches <- struct{}{}:
+		return func() { <-g.openCaches }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,75 @@
+package goproxy
+
+import (
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// cgroupRootDir is the directory where the cgroup file system is mounted.
+var cgroupRootDir = "/sys/fs/cgroup"
+
+// detectResourceLimits detects the [ResourceLimits] of the current process
+// from its cgroup (v2 or v1) and its RLIMIT_NOFILE.
+func detectResourceLimits() ResourceLimits {
+	var rl ResourceLimits
+	if v, ok := readCgroupInt(
+		"memory.max",
+		"memory/memory.limit_in_bytes",
+	); ok {
+		rl.MemoryBytes = v
+	}
+
+	if b, err := ioutil.ReadFile(
+		filepath.Join(cgroupRootDir, "cpu.max"),
+	); err == nil {
+		fields := strings.Fields(string(b))
+		if len(fields) == 2 {
+			quota, err1 := strconv.ParseFloat(fields[0], 64)
+			period, err2 := strconv.ParseFloat(fields[1], 64)
+			if err1 == nil && err2 == nil &&
+				quota > 0 && period > 0 {
+				rl.CPUs = quota / period
+			}
+		}
+	} else if quota, ok := readCgroupInt("cpu/cpu.cfs_quota_us"); ok {
+		if period, ok := readCgroupInt("cpu/cpu.cfs_period_us"); ok {
+			rl.CPUs = float64(quota) / float64(period)
+		}
+	}
+
+	var rlimit syscall.Rlimit
+	if err := syscall.Getrlimit(
+		syscall.RLIMIT_NOFILE,
+		&rlimit,
+	); err == nil && rlimit.Cur != math.MaxUint64 &&
+		rlimit.Cur <= math.MaxInt64 {
+		rl.OpenFiles = int64(rlimit.Cur)
+	}
+
+	return rl
+}
+
+// readCgroupInt reads the first positive integer found in the files under the
+// [cgroupRootDir]. Values meaning unlimited (e.g. "max" or near the maximum
+// int64) are skipped.
+func readCgroupInt(files ...string) (int64, bool) {
+	for _, file := range files {
+		b, err := ioutil.ReadFile(filepath.Join(cgroupRootDir, file))
+		if err != nil {
+			continue
+		}
+
+		v, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+		if err != nil || v <= 0 || v >= math.MaxInt64/2 {
+			continue
+		}
+
+		return v, true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,54 @@
+package goproxy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadCgroupInt(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestReadCgroupInt")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	defer func(dir string) { cgroupRootDir = dir }(cgroupRootDir)
+	cgroupRootDir = tempDir
+
+	for name, content := range map[string]string{
+		"memory.max":        "max\n",
+		"unlimited":         "9223372036854771712\n",
+		"invalid":           "foobar\n",
+		"v1/limit_in_bytes": "268435456\n",
+	} {
+		name = filepath.Join(tempDir, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0750); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		if err := ioutil.WriteFile(
+			name,
+			[]byte(content),
+			0600,
+		); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	if _, ok := readCgroupInt("memory.max"); ok {
+		t.Error("expected false")
+	}
+
+	if _, ok := readCgroupInt("unlimited", "invalid", "nonexistent"); ok {
+		t.Error("expected false")
+	}
+
+	v, ok := readCgroupInt("memory.max", "v1/limit_in_bytes")
+	if !ok {
+		t.Fatal("expected true")
+	} else if got, want := v, int64(268435456); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
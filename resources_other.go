@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package goproxy
+
+// detectResourceLimits detects the [ResourceLimits] of the current process. It
+// detects nothing on the current platform.
+func detectResourceLimits() ResourceLimits {
+	return ResourceLimits{}
+}
@@ -0,0 +1,230 @@
+package goproxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDetectResourceLimits(t *testing.T) {
+	rl := DetectResourceLimits()
+	if rl.CPUs <= 0 {
+		t.Errorf("got %v, want > 0", rl.CPUs)
+	}
+
+	if rl.MemoryBytes < 0 {
+		t.Errorf("got %d, want >= 0", rl.MemoryBytes)
+	}
+
+	if rl.OpenFiles < 0 {
+		t.Errorf("got %d, want >= 0", rl.OpenFiles)
+	}
+}
+
+func TestGoproxyDeriveEffectiveLimits(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		g    *Goproxy
+		rl   *ResourceLimits
+		want EffectiveLimits
+	}{
+		{
+			name: "Undetected",
+			g:    &Goproxy{},
+			want: EffectiveLimits{
+				MaxReadAheads:     defaultMaxReadAheads,
+				StreamBufferBytes: defaultStreamBufferBytes,
+			},
+		},
+		{
+			name: "UndetectedConfigured",
+			g: &Goproxy{
+				GoBinMaxWorkers: 4,
+				MaxReadAheads:   2,
+				MaxOpenCaches:   16,
+			},
+			want: EffectiveLimits{
+				GoBinMaxWorkers:   4,
+				MaxReadAheads:     2,
+				MaxOpenCaches:     16,
+				StreamBufferBytes: defaultStreamBufferBytes,
+			},
+		},
+		{
+			name: "Detected",
+			g:    &Goproxy{},
+			rl: &ResourceLimits{
+				MemoryBytes: 1 << 30,
+				OpenFiles:   1024,
+			},
+			want: EffectiveLimits{
+				Detected: ResourceLimits{
+					MemoryBytes: 1 << 30,
+					OpenFiles:   1024,
+				},
+				GoBinMaxWorkers:   2,
+				MaxReadAheads:     8,
+				MaxOpenCaches:     512,
+				StreamBufferBytes: defaultStreamBufferBytes,
+			},
+		},
+		{
+			name: "DetectedSmall",
+			g:    &Goproxy{},
+			rl: &ResourceLimits{
+				MemoryBytes: 128 << 20,
+				OpenFiles:   64,
+			},
+			want: EffectiveLimits{
+				Detected: ResourceLimits{
+					MemoryBytes: 128 << 20,
+					OpenFiles:   64,
+				},
+				GoBinMaxWorkers:   1,
+				MaxReadAheads:     1,
+				MaxOpenCaches:     32,
+				StreamBufferBytes: smallStreamBufferBytes,
+			},
+		},
+		{
+			name: "DetectedUnlimited",
+			g:    &Goproxy{},
+			rl:   &ResourceLimits{CPUs: 2},
+			want: EffectiveLimits{
+				Detected:          ResourceLimits{CPUs: 2},
+				MaxReadAheads:     defaultMaxReadAheads,
+				StreamBufferBytes: defaultStreamBufferBytes,
+			},
+		},
+		{
+			name: "DetectedConfigured",
+			g: &Goproxy{
+				GoBinMaxWorkers: 4,
+				MaxReadAheads:   2,
+				MaxOpenCaches:   16,
+			},
+			rl: &ResourceLimits{
+				MemoryBytes: 128 << 20,
+				OpenFiles:   64,
+			},
+			want: EffectiveLimits{
+				Detected: ResourceLimits{
+					MemoryBytes: 128 << 20,
+					OpenFiles:   64,
+				},
+				GoBinMaxWorkers:   4,
+				MaxReadAheads:     2,
+				MaxOpenCaches:     16,
+				StreamBufferBytes: smallStreamBufferBytes,
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.g.deriveEffectiveLimits(tt.rl)
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBudgetedWorkers(t *testing.T) {
+	for _, tt := range []struct {
+		rl   ResourceLimits
+		max  int
+		want int
+	}{
+		{ResourceLimits{}, 0, 0},
+		{ResourceLimits{}, 8, 8},
+		{ResourceLimits{MemoryBytes: 1000}, 0, 10},
+		{ResourceLimits{MemoryBytes: 1000}, 8, 8},
+		{ResourceLimits{MemoryBytes: 10}, 8, 1},
+		{ResourceLimits{OpenFiles: 30}, 0, 3},
+		{ResourceLimits{MemoryBytes: 1000, OpenFiles: 30}, 8, 3},
+	} {
+		got := budgetedWorkers(&tt.rl, tt.max, 100, 10)
+		if got != tt.want {
+			t.Errorf(
+				"budgetedWorkers(%+v, %d): got %d, want %d",
+				tt.rl,
+				tt.max,
+				got,
+				tt.want,
+			)
+		}
+	}
+}
+
+func TestGoproxyAcquireOpenCache(t *testing.T) {
+	g := &Goproxy{}
+	g.init()
+	release, err := g.acquireOpenCache(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	release()
+
+	g = &Goproxy{MaxOpenCaches: 1}
+	g.init()
+	release, err = g.acquireOpenCache(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := g.acquireOpenCache(ctx); err == nil {
+		t.Fatal("expected error")
+	} else if got, want := err, context.Canceled; !errors.Is(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	release()
+	release, err = g.acquireOpenCache(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	release()
+}
+
+func TestGoproxyEffectiveLimits(t *testing.T) {
+	g := &Goproxy{
+		GoBinMaxWorkers: 4,
+		MaxOpenCaches:   16,
+		AdminAuthorizer: func(*http.Request) bool {
+			return true
+		},
+	}
+	if got, want := g.EffectiveLimits(), (EffectiveLimits{
+		GoBinMaxWorkers:   4,
+		MaxReadAheads:     defaultMaxReadAheads,
+		MaxOpenCaches:     16,
+		StreamBufferBytes: defaultStreamBufferBytes,
+	}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	req := httptest.NewRequest("", "/-/stats", nil)
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, req)
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	var s struct {
+		Limits EffectiveLimits
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&s); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := s.Limits, g.EffectiveLimits(); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	g = &Goproxy{ResourceGuardrails: true}
+	if got := g.EffectiveLimits(); got.Detected.CPUs <= 0 {
+		t.Errorf("got %v, want > 0", got.Detected.CPUs)
+	}
+}
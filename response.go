@@ -1,7 +1,11 @@
 package goproxy
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -44,6 +48,22 @@ func responseString(
 	}
 }
 
+// responseHTML responses the s as a "text/html" content to the client with the
+// cacheControlMaxAge.
+func responseHTML(
+	rw http.ResponseWriter,
+	req *http.Request,
+	cacheControlMaxAge int,
+	s string,
+) {
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	setResponseCacheControlHeader(rw, cacheControlMaxAge)
+	rw.WriteHeader(http.StatusOK)
+	if req.Method != http.MethodHead {
+		rw.Write([]byte(s))
+	}
+}
+
 // responseNotFound responses "not found" to the client with the
 // cacheControlMaxAge and optional msgs.
 func responseNotFound(
@@ -70,6 +90,31 @@ func responseNotFound(
 	responseString(rw, req, http.StatusNotFound, cacheControlMaxAge, msg)
 }
 
+// responseGone responses "gone" to the client with the cacheControlMaxAge and
+// optional msgs.
+func responseGone(
+	rw http.ResponseWriter,
+	req *http.Request,
+	cacheControlMaxAge int,
+	msgs ...interface{},
+) {
+	var msg string
+	if len(msgs) > 0 {
+		msg = strings.TrimPrefix(fmt.Sprint(msgs...), "not found: ")
+		if msg != "" &&
+			msg != "gone" &&
+			!strings.HasPrefix(msg, "gone: ") {
+			msg = fmt.Sprint("gone: ", msg)
+		}
+	}
+
+	if msg == "" {
+		msg = "gone"
+	}
+
+	responseString(rw, req, http.StatusGone, cacheControlMaxAge, msg)
+}
+
 // responseMethodNotAllowed responses "method not allowed" to the client with
 // the cacheControlMaxAge.
 func responseMethodNotAllowed(
@@ -86,6 +131,30 @@ func responseMethodNotAllowed(
 	)
 }
 
+// responseForbidden responses "forbidden" to the client with the
+// cacheControlMaxAge and optional msgs.
+func responseForbidden(
+	rw http.ResponseWriter,
+	req *http.Request,
+	cacheControlMaxAge int,
+	msgs ...interface{},
+) {
+	msg := "forbidden"
+	if len(msgs) > 0 {
+		msg = fmt.Sprint(msg, ": ", fmt.Sprint(msgs...))
+	}
+
+	responseString(rw, req, http.StatusForbidden, cacheControlMaxAge, msg)
+}
+
+// responseUnauthorized responses "unauthorized" to the client, asking for the
+// basic authentication (which the go command answers with the credentials in
+// the .netrc of the user).
+func responseUnauthorized(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("WWW-Authenticate", `Basic realm="goproxy"`)
+	responseString(rw, req, http.StatusUnauthorized, -1, "unauthorized")
+}
+
 // responseInternalServerError responses "internal server error" to the client.
 func responseInternalServerError(rw http.ResponseWriter, req *http.Request) {
 	responseString(
@@ -93,10 +162,54 @@ func responseInternalServerError(rw http.ResponseWriter, req *http.Request) {
 		req,
 		http.StatusInternalServerError,
 		-2,
-		"internal server error",
+		withErrorReferenceID(req, "internal server error"),
 	)
 }
 
+// responseStream responses the content to the client with the contentType and
+// cacheControlMaxAge by streaming it in chunks of at most bufferBytes (32 KiB
+// if zero), flushing each one as soon as it is written. The Content-Length
+// header is never set, so the chunked transfer encoding is used for HTTP/1.1
+// clients.
+func responseStream(
+	rw http.ResponseWriter,
+	req *http.Request,
+	content io.Reader,
+	contentType string,
+	cacheControlMaxAge int,
+	bufferBytes int,
+) {
+	rw.Header().Set("Content-Type", contentType)
+	setResponseCacheControlHeader(rw, cacheControlMaxAge)
+	rw.WriteHeader(http.StatusOK)
+	if req.Method == http.MethodHead {
+		return
+	}
+
+	flusher, _ := rw.(http.Flusher)
+	if bufferBytes <= 0 {
+		bufferBytes = defaultStreamBufferBytes
+	}
+
+	b := make([]byte, bufferBytes)
+	for {
+		n, err := content.Read(b)
+		if n > 0 {
+			if _, err := rw.Write(b[:n]); err != nil {
+				return
+			}
+
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
 // responseSuccess responses success to the client with the content, contentType
 // and cacheControlMaxAge.
 func responseSuccess(
@@ -140,6 +253,29 @@ func responseSuccess(
 	}
 }
 
+// responseJSON responses the v as a JSON content to the client with the
+// cacheControlMaxAge.
+func responseJSON(
+	rw http.ResponseWriter,
+	req *http.Request,
+	cacheControlMaxAge int,
+	v interface{},
+) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		responseInternalServerError(rw, req)
+		return
+	}
+
+	responseSuccess(
+		rw,
+		req,
+		bytes.NewReader(b),
+		"application/json; charset=utf-8",
+		cacheControlMaxAge,
+	)
+}
+
 // responseError responses error to the client with the err and cacheSensitive.
 func responseError(
 	rw http.ResponseWriter,
@@ -147,6 +283,11 @@ func responseError(
 	err error,
 	cacheSensitive bool,
 ) {
+	if timeout, ok := requestTimedOut(req); ok {
+		responseRequestTimeout(rw, req, timeout)
+		return
+	}
+
 	if errors.Is(err, errNotFound) {
 		cacheControlMaxAge := -1
 		msg := err.Error()
@@ -160,17 +301,75 @@ func responseError(
 			cacheControlMaxAge = 600
 		}
 
-		responseNotFound(rw, req, cacheControlMaxAge, msg)
+		msg = withErrorReferenceID(req, msg)
+		if errors.Is(err, errGone) {
+			responseGone(rw, req, cacheControlMaxAge, msg)
+		} else {
+			responseNotFound(rw, req, cacheControlMaxAge, msg)
+		}
+	} else if errors.Is(err, errForbidden) {
+		responseForbidden(
+			rw,
+			req,
+			-1,
+			withErrorReferenceID(req, err.Error()),
+		)
 	} else if errors.Is(err, errBadUpstream) {
-		responseNotFound(rw, req, -1, errBadUpstream)
+		responseNotFound(
+			rw,
+			req,
+			-1,
+			withErrorReferenceID(req, errBadUpstream.Error()),
+		)
 	} else if t, ok := err.(interface {
 		Timeout() bool
 	}); (ok && t.Timeout()) ||
 		errors.Is(err, context.DeadlineExceeded) ||
 		errors.Is(err, errFetchTimedOut) ||
 		strings.Contains(err.Error(), errFetchTimedOut.Error()) {
-		responseNotFound(rw, req, -1, errFetchTimedOut)
+		responseNotFound(
+			rw,
+			req,
+			-1,
+			withErrorReferenceID(req, errFetchTimedOut.Error()),
+		)
 	} else {
 		responseInternalServerError(rw, req)
 	}
 }
+
+// errorReferenceIDContextKey is the context key of error reference IDs.
+type errorReferenceIDContextKey struct{}
+
+// withErrorReferenceIDContext returns a shallow copy of the req with a newly
+// generated error reference ID (see [Goproxy.ErrorReferenceIDs]) attached to
+// its context.
+func withErrorReferenceIDContext(req *http.Request) *http.Request {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return req
+	}
+
+	return req.WithContext(context.WithValue(
+		req.Context(),
+		errorReferenceIDContextKey{},
+		hex.EncodeToString(b),
+	))
+}
+
+// errorReferenceID returns the error reference ID attached to the context of
+// the req. It returns "" if there is none.
+func errorReferenceID(req *http.Request) string {
+	id, _ := req.Context().Value(errorReferenceIDContextKey{}).(string)
+	return id
+}
+
+// withErrorReferenceID appends the error reference ID attached to the context
+// of the req to the msg, if any.
+func withErrorReferenceID(req *http.Request, msg string) string {
+	if id := errorReferenceID(req); id != "" {
+		return fmt.Sprintf("%s (reference: %s)", msg, id)
+	}
+
+	return msg
+}
@@ -72,6 +72,32 @@ func TestResponseString(t *testing.T) {
 	}
 }
 
+func TestResponseHTML(t *testing.T) {
+	req := httptest.NewRequest("", "/", nil)
+	rec := httptest.NewRecorder()
+	responseHTML(rec, req, 60, "<html></html>")
+	recr := rec.Result()
+	if want := http.StatusOK; recr.StatusCode != want {
+		t.Errorf("got %d, want %d", recr.StatusCode, want)
+	}
+
+	recrCT := recr.Header.Get("Content-Type")
+	if want := "text/html; charset=utf-8"; recrCT != want {
+		t.Errorf("got %q, want %q", recrCT, want)
+	}
+
+	recrCC := recr.Header.Get("Cache-Control")
+	if want := "public, max-age=60"; recrCC != want {
+		t.Errorf("got %q, want %q", recrCC, want)
+	}
+
+	if b, err := ioutil.ReadAll(recr.Body); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if want := "<html></html>"; string(b) != want {
+		t.Errorf("got %q, want %q", b, want)
+	}
+}
+
 func TestResponseNotFound(t *testing.T) {
 	req := httptest.NewRequest("", "/", nil)
 	rec := httptest.NewRecorder()
@@ -213,6 +239,38 @@ func TestResponseNotFound(t *testing.T) {
 	}
 }
 
+func TestResponseGone(t *testing.T) {
+	req := httptest.NewRequest("", "/", nil)
+	for _, tt := range []struct {
+		msgs []interface{}
+		want string
+	}{
+		{nil, "gone"},
+		{[]interface{}{"foobar"}, "gone: foobar"},
+		{[]interface{}{"gone: foobar"}, "gone: foobar"},
+		{[]interface{}{"not found: foobar"}, "gone: foobar"},
+		{[]interface{}{""}, "gone"},
+	} {
+		rec := httptest.NewRecorder()
+		responseGone(rec, req, 60, tt.msgs...)
+		recr := rec.Result()
+		if want := http.StatusGone; recr.StatusCode != want {
+			t.Errorf("got %d, want %d", recr.StatusCode, want)
+		}
+
+		recrCC := recr.Header.Get("Cache-Control")
+		if want := "public, max-age=60"; recrCC != want {
+			t.Errorf("got %q, want %q", recrCC, want)
+		}
+
+		if b, err := ioutil.ReadAll(recr.Body); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		} else if string(b) != tt.want {
+			t.Errorf("got %q, want %q", b, tt.want)
+		}
+	}
+}
+
 func TestResponseMethodNotAllowed(t *testing.T) {
 	req := httptest.NewRequest("", "/", nil)
 	rec := httptest.NewRecorder()
@@ -239,6 +297,51 @@ func TestResponseMethodNotAllowed(t *testing.T) {
 	}
 }
 
+func TestResponseForbidden(t *testing.T) {
+	req := httptest.NewRequest("", "/", nil)
+	rec := httptest.NewRecorder()
+	responseForbidden(rec, req, -2, "admin only")
+	recr := rec.Result()
+	if want := http.StatusForbidden; recr.StatusCode != want {
+		t.Errorf("got %d, want %d", recr.StatusCode, want)
+	}
+
+	if b, err := ioutil.ReadAll(recr.Body); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if want := "forbidden: admin only"; string(b) != want {
+		t.Errorf("got %q, want %q", b, want)
+	}
+
+	req = httptest.NewRequest("", "/", nil)
+	rec = httptest.NewRecorder()
+	responseForbidden(rec, req, -2)
+	if got, want := rec.Body.String(), "forbidden"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestResponseJSON(t *testing.T) {
+	req := httptest.NewRequest("", "/", nil)
+	rec := httptest.NewRecorder()
+	responseJSON(rec, req, 60, map[string]int{"foo": 1})
+	recr := rec.Result()
+	if got, want := recr.StatusCode, http.StatusOK; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	} else if got, want := recr.Header.Get("Content-Type"),
+		"application/json; charset=utf-8"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	} else if got, want := rec.Body.String(), `{"foo":1}`; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	req = httptest.NewRequest("", "/", nil)
+	rec = httptest.NewRecorder()
+	responseJSON(rec, req, 60, func() {})
+	if got, want := rec.Code, http.StatusInternalServerError; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
+
 func TestResponseInternalServerError(t *testing.T) {
 	req := httptest.NewRequest("", "/", nil)
 	rec := httptest.NewRecorder()
@@ -295,6 +398,70 @@ func (srb successResponseBody_ETag) ETag() string {
 	return srb.etag
 }
 
+func TestResponseStream(t *testing.T) {
+	content := strings.Repeat("foobar\n", 10000)
+	req := httptest.NewRequest("", "/", nil)
+	rec := httptest.NewRecorder()
+	responseStream(
+		rec,
+		req,
+		strings.NewReader(content),
+		"text/plain; charset=utf-8",
+		60,
+		1000,
+	)
+	recr := rec.Result()
+	if want := http.StatusOK; recr.StatusCode != want {
+		t.Errorf("got %d, want %d", recr.StatusCode, want)
+	}
+
+	recrCT := recr.Header.Get("Content-Type")
+	if want := "text/plain; charset=utf-8"; recrCT != want {
+		t.Errorf("got %q, want %q", recrCT, want)
+	}
+
+	recrCC := recr.Header.Get("Cache-Control")
+	if want := "public, max-age=60"; recrCC != want {
+		t.Errorf("got %q, want %q", recrCC, want)
+	}
+
+	recrCL := recr.Header.Get("Content-Length")
+	if want := ""; recrCL != want {
+		t.Errorf("got %q, want %q", recrCL, want)
+	}
+
+	if !rec.Flushed {
+		t.Error("expected flushed")
+	}
+
+	if b, err := ioutil.ReadAll(recr.Body); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if string(b) != content {
+		t.Errorf("got %d bytes, want %d bytes", len(b), len(content))
+	}
+
+	req = httptest.NewRequest(http.MethodHead, "/", nil)
+	rec = httptest.NewRecorder()
+	responseStream(
+		rec,
+		req,
+		strings.NewReader(content),
+		"text/plain; charset=utf-8",
+		60,
+		0,
+	)
+	recr = rec.Result()
+	if want := http.StatusOK; recr.StatusCode != want {
+		t.Errorf("got %d, want %d", recr.StatusCode, want)
+	}
+
+	if b, err := ioutil.ReadAll(recr.Body); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if want := ""; string(b) != want {
+		t.Errorf("got %q, want %q", b, want)
+	}
+}
+
 func TestResponseSuccess(t *testing.T) {
 	req := httptest.NewRequest("", "/", nil)
 	rec := httptest.NewRecorder()
@@ -670,6 +837,24 @@ func TestResponseError(t *testing.T) {
 		t.Errorf("got %q, want %q", b, want)
 	}
 
+	rec = httptest.NewRecorder()
+	responseError(rec, req, forbiddenError("not allowed"), false)
+	recr = rec.Result()
+	if want := http.StatusForbidden; recr.StatusCode != want {
+		t.Errorf("got %d, want %d", recr.StatusCode, want)
+	}
+
+	recrCC = recr.Header.Get("Cache-Control")
+	if want := "must-revalidate, no-cache, no-store"; recrCC != want {
+		t.Errorf("got %q, want %q", recrCC, want)
+	}
+
+	if b, err := ioutil.ReadAll(recr.Body); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if want := "forbidden: not allowed"; string(b) != want {
+		t.Errorf("got %q, want %q", b, want)
+	}
+
 	rec = httptest.NewRecorder()
 	responseError(rec, req, errBadUpstream, false)
 	recr = rec.Result()
@@ -738,4 +923,69 @@ func TestResponseError(t *testing.T) {
 	} else if want := "internal server error"; string(b) != want {
 		t.Errorf("got %q, want %q", b, want)
 	}
+
+	rec = httptest.NewRecorder()
+	responseError(rec, req, goneError("gone: foobar"), false)
+	recr = rec.Result()
+	if want := http.StatusGone; recr.StatusCode != want {
+		t.Errorf("got %d, want %d", recr.StatusCode, want)
+	}
+
+	recrCC = recr.Header.Get("Cache-Control")
+	if want := "public, max-age=600"; recrCC != want {
+		t.Errorf("got %q, want %q", recrCC, want)
+	}
+
+	if b, err := ioutil.ReadAll(recr.Body); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if want := "gone: foobar"; string(b) != want {
+		t.Errorf("got %q, want %q", b, want)
+	}
+
+	req = withErrorReferenceIDContext(req)
+	id := errorReferenceID(req)
+	if id == "" {
+		t.Fatal("expected error reference ID")
+	}
+
+	rec = httptest.NewRecorder()
+	responseError(rec, req, notFoundError("foobar"), false)
+	if b, err := ioutil.ReadAll(rec.Result().Body); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if want := "not found: foobar (reference: " + id + ")"; string(b) != want {
+		t.Errorf("got %q, want %q", b, want)
+	}
+
+	rec = httptest.NewRecorder()
+	responseError(rec, req, errors.New("foobar"), false)
+	if b, err := ioutil.ReadAll(rec.Result().Body); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if want := "internal server error (reference: " + id + ")"; string(b) != want {
+		t.Errorf("got %q, want %q", b, want)
+	}
+}
+
+func TestErrorReferenceID(t *testing.T) {
+	req := httptest.NewRequest("", "/", nil)
+	if got, want := errorReferenceID(req), ""; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if got, want := withErrorReferenceID(req, "foobar"), "foobar"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	req = withErrorReferenceIDContext(req)
+	id := errorReferenceID(req)
+	if got, want := len(id), 16; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	if got, want := withErrorReferenceID(req, "foobar"), "foobar (reference: "+id+")"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if id2 := errorReferenceID(withErrorReferenceIDContext(req)); id2 == id {
+		t.Errorf("got %q, want different from %q", id2, id)
+	}
 }
@@ -0,0 +1,346 @@
+package goproxy
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// RetentionCacher implements the [Cacher] by wrapping another [Cacher] and
+// keeping the total size of the caches put via it within a limit.
+//
+// When the limit is exceeded, caches are evicted by their retention scores
+// (the Greedy-Dual-Size-Frequency policy) rather than by pure recency. The
+// score of a cache grows with how often it is hit and shrinks with its size,
+// and every eviction raises the baseline for the caches hit afterwards, so
+// small hot caches (e.g. info and mod files of popular modules) stay resident
+// even when giant cold zip files pass through. Hit counts are halved
+// periodically, so that once popular caches eventually age out.
+//
+// Only the caches put via the RetentionCacher are accounted, so caches that
+// already exist in the wrapped [Cacher] are never evicted by it.
+//
+// Make sure that all fields of the RetentionCacher have been finalized before
+// calling any of its methods.
+type RetentionCacher struct {
+	// Cacher is the wrapped [Cacher]. It must implement the [Deleter] for
+	// evictions to work.
+	Cacher Cacher
+
+	// MaxBytes is the maximum total number of bytes of the caches put via
+	// the RetentionCacher.
+	//
+	// If the MaxBytes is zero, there is no limit.
+	MaxBytes int64
+
+	// Pinned reports whether the cache for the name is pinned. Pinned
+	// caches are never evicted and do not count toward the MaxBytes. It is
+	// typically the [Goproxy.Pinned].
+	//
+	// If the Pinned is nil, no caches are pinned.
+	Pinned func(name string) bool
+
+	mutex      sync.Mutex
+	entries    map[string]*retentionEntry
+	heap       retentionHeap
+	totalBytes int64
+	baseline   float64
+	hits       int
+}
+
+// retentionEntry is an entry of a cache accounted by a [RetentionCacher].
+type retentionEntry struct {
+	name     string
+	size     int64
+	hits     int
+	baseline float64
+	score    float64
+	index    int
+}
+
+// Get implements the [Cacher].
+func (rc *RetentionCacher) Get(
+	ctx context.Context,
+	name string,
+) (io.ReadCloser, error) {
+	content, err := rc.Cacher.Get(ctx, name)
+
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	if e, ok := rc.entries[name]; ok {
+		if errors.Is(err, os.ErrNotExist) {
+			rc.remove(e)
+		} else if err == nil {
+			rc.hit(e)
+		}
+	}
+
+	return content, err
+}
+
+// Put implements the [Cacher].
+func (rc *RetentionCacher) Put(
+	ctx context.Context,
+	name string,
+	content io.ReadSeeker,
+	expiration time.Duration,
+) error {
+	size, err := content.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	} else if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if err := rc.Cacher.Put(ctx, name, content, expiration); err != nil {
+		return err
+	}
+
+	if rc.pinned(name) {
+		rc.forget(name)
+		return nil
+	}
+
+	rc.mutex.Lock()
+	if rc.entries == nil {
+		rc.entries = map[string]*retentionEntry{}
+	}
+
+	e, ok := rc.entries[name]
+	if ok {
+		rc.totalBytes += size - e.size
+		e.size = size
+	} else {
+		e = &retentionEntry{name: name, size: size}
+		rc.entries[name] = e
+		rc.totalBytes += size
+		heap.Push(&rc.heap, e)
+	}
+
+	rc.hit(e)
+
+	var victims []string
+	for rc.MaxBytes > 0 && rc.totalBytes > rc.MaxBytes {
+		victim := rc.heap[0]
+		rc.remove(victim)
+		if rc.pinned(victim.name) {
+			continue // Pinned since being accounted
+		}
+
+		rc.baseline = victim.score
+		victims = append(victims, victim.name)
+	}
+
+	rc.mutex.Unlock()
+
+	return rc.evict(ctx, victims)
+}
+
+// Delete implements the [Deleter].
+func (rc *RetentionCacher) Delete(ctx context.Context, name string) error {
+	d, ok := rc.Cacher.(Deleter)
+	if !ok {
+		return errDeleteNotSupported
+	}
+
+	if err := d.Delete(ctx, name); err != nil {
+		return err
+	}
+
+	rc.forget(name)
+	return nil
+}
+
+// Cleanup implements the [Cacher].
+func (rc *RetentionCacher) Cleanup() error {
+	return rc.CleanupReclaimed(nil)
+}
+
+// CleanupReclaimed implements the [Reclaimer].
+func (rc *RetentionCacher) CleanupReclaimed(
+	reclaimed func(name string, size int64),
+) error {
+	r, ok := rc.Cacher.(Reclaimer)
+	if !ok {
+		return rc.Cacher.Cleanup()
+	}
+
+	return r.CleanupReclaimed(func(name string, size int64) {
+		rc.forget(name)
+		if reclaimed != nil {
+			reclaimed(name, size)
+		}
+	})
+}
+
+// List implements the [Lister].
+func (rc *RetentionCacher) List(
+	ctx context.Context,
+	prefix string,
+) CacheIterator {
+	return listCaches(ctx, rc.walkCaches, prefix)
+}
+
+// walkCaches implements the [cacheWalker].
+func (rc *RetentionCacher) walkCaches(
+	fn func(name string, size int64) error,
+) error {
+	cw, ok := cacheWalkerOf(rc.Cacher)
+	if !ok {
+		return errWalkNotSupported
+	}
+
+	return cw.walkCaches(fn)
+}
+
+// account starts accounting the cache for the name with the size, unless it is
+// already accounted. It is for rebuilding the accounting of the caches put
+// before the rc was created. The excess, if any, is evicted on the next put.
+func (rc *RetentionCacher) account(name string, size int64) {
+	if rc.pinned(name) {
+		return
+	}
+
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	if _, ok := rc.entries[name]; ok {
+		return
+	}
+
+	if rc.entries == nil {
+		rc.entries = map[string]*retentionEntry{}
+	}
+
+	e := &retentionEntry{
+		name:     name,
+		size:     size,
+		hits:     1,
+		baseline: rc.baseline,
+	}
+	e.score = e.retentionScore()
+	rc.entries[name] = e
+	rc.totalBytes += size
+	heap.Push(&rc.heap, e)
+}
+
+// TotalBytes returns the total number of bytes of the caches currently
+// accounted by the rc.
+func (rc *RetentionCacher) TotalBytes() int64 {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	return rc.totalBytes
+}
+
+// hit records a hit of the e and rescores it. It must be called with the
+// rc.mutex held.
+func (rc *RetentionCacher) hit(e *retentionEntry) {
+	e.hits++
+	e.baseline = rc.baseline
+	e.score = e.retentionScore()
+	heap.Fix(&rc.heap, e.index)
+
+	rc.hits++
+	if rc.hits >= 10*len(rc.entries) {
+		rc.hits = 0
+		for _, e := range rc.heap {
+			e.hits = (e.hits + 1) / 2
+			e.score = e.retentionScore()
+		}
+
+		heap.Init(&rc.heap)
+	}
+}
+
+// remove stops accounting the e. It must be called with the rc.mutex held.
+func (rc *RetentionCacher) remove(e *retentionEntry) {
+	heap.Remove(&rc.heap, e.index)
+	delete(rc.entries, e.name)
+	rc.totalBytes -= e.size
+}
+
+// pinned reports whether the cache for the name is pinned.
+func (rc *RetentionCacher) pinned(name string) bool {
+	return rc.Pinned != nil && rc.Pinned(name)
+}
+
+// forget stops accounting the cache for the name, if any.
+func (rc *RetentionCacher) forget(name string) {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	if e, ok := rc.entries[name]; ok {
+		rc.remove(e)
+	}
+}
+
+// evict deletes the caches for the names from the rc.Cacher.
+func (rc *RetentionCacher) evict(ctx context.Context, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+
+	d, ok := rc.Cacher.(Deleter)
+	if !ok {
+		return errDeleteNotSupported
+	}
+
+	for _, name := range names {
+		if err := d.Delete(ctx, name); err != nil &&
+			!errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// retentionScore returns the retention score of the re.
+func (re *retentionEntry) retentionScore() float64 {
+	size := re.size
+	if size < 1 {
+		size = 1
+	}
+
+	return re.baseline + float64(re.hits)/float64(size)
+}
+
+// retentionHeap is a min-heap of [retentionEntry]s ordered by their scores. It
+// implements the [heap.Interface].
+type retentionHeap []*retentionEntry
+
+// Len implements the [heap.Interface].
+func (rh retentionHeap) Len() int {
+	return len(rh)
+}
+
+// Less implements the [heap.Interface].
+func (rh retentionHeap) Less(i, j int) bool {
+	return rh[i].score < rh[j].score
+}
+
+// Swap implements the [heap.Interface].
+func (rh retentionHeap) Swap(i, j int) {
+	rh[i], rh[j] = rh[j], rh[i]
+	rh[i].index = i
+	rh[j].index = j
+}
+
+// Push implements the [heap.Interface].
+func (rh *retentionHeap) Push(x interface{}) {
+	e := x.(*retentionEntry)
+	e.index = len(*rh)
+	*rh = append(*rh, e)
+}
+
+// Pop implements the [heap.Interface].
+func (rh *retentionHeap) Pop() interface{} {
+	old := *rh
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*rh = old[:len(old)-1]
+	e.index = -1
+	return e
+}
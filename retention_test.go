@@ -0,0 +1,209 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetentionCacher(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestRetentionCacher")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	rc := &RetentionCacher{Cacher: DirCacher(tempDir), MaxBytes: 100}
+	put := func(name string, size int) {
+		if err := rc.Put(
+			context.Background(),
+			name,
+			strings.NewReader(strings.Repeat("a", size)),
+			time.Hour,
+		); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	get := func(name string) error {
+		content, err := rc.Get(context.Background(), name)
+		if err != nil {
+			return err
+		}
+
+		return content.Close()
+	}
+
+	put("hot.info", 10)
+	put("cold.info", 10)
+	for i := 0; i < 5; i++ {
+		if err := get("hot.info"); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	if got, want := rc.TotalBytes(), int64(20); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	// A giant cold zip passing through must not evict the small caches.
+	put("giant.zip", 90)
+	if err := get("giant.zip"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got error %q, want error %q", err, os.ErrNotExist)
+	}
+
+	for _, name := range []string{"hot.info", "cold.info"} {
+		if err := get(name); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	// A rarely hit large cache is evicted before the small ones.
+	put("warm.zip", 80)
+	put("new.mod", 10)
+	if err := get("warm.zip"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got error %q, want error %q", err, os.ErrNotExist)
+	}
+
+	for _, name := range []string{"hot.info", "cold.info", "new.mod"} {
+		if err := get(name); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	if got, want := rc.TotalBytes(), int64(30); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	if err := rc.Delete(context.Background(), "new.mod"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if got, want := rc.TotalBytes(), int64(20); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	put("expired.info", 10)
+	if err := setCacheExpiration(
+		filepath.Join(tempDir, "expired.info"),
+		-time.Hour,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	var reclaimed []string
+	if err := rc.CleanupReclaimed(func(name string, size int64) {
+		reclaimed = append(reclaimed, name)
+	}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if got, want := strings.Join(reclaimed, ","), "expired.info"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if got, want := rc.TotalBytes(), int64(20); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	if err := os.Remove(filepath.Join(tempDir, "hot.info")); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if err := get("hot.info"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got error %q, want error %q", err, os.ErrNotExist)
+	}
+
+	if got, want := rc.TotalBytes(), int64(10); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
+
+func TestRetentionCacherAging(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestRetentionCacherAging")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	rc := &RetentionCacher{Cacher: DirCacher(tempDir)}
+	if err := rc.Put(
+		context.Background(),
+		"a",
+		strings.NewReader("a"),
+		time.Hour,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	for i := 0; i < 20; i++ {
+		content, err := rc.Get(context.Background(), "a")
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		content.Close()
+	}
+
+	// 21 hits in total, halved at the 10th and the 20th.
+	if got, want := rc.entries["a"].hits, 9; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
+
+func TestRetentionCacherPinned(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestRetentionCacherPinned")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	pinned := map[string]bool{"pinned.zip": true}
+	rc := &RetentionCacher{
+		Cacher:   DirCacher(tempDir),
+		MaxBytes: 100,
+		Pinned: func(name string) bool {
+			return pinned[name]
+		},
+	}
+	put := func(name string, size int) {
+		if err := rc.Put(
+			context.Background(),
+			name,
+			strings.NewReader(strings.Repeat("a", size)),
+			time.Hour,
+		); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	put("pinned.zip", 90)
+	put("cold.zip", 60)
+	if got, want := rc.TotalBytes(), int64(60); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	// A cache pinned after being accounted is never evicted.
+	pinned["cold.zip"] = true
+	put("new.zip", 50)
+	for _, name := range []string{"pinned.zip", "cold.zip", "new.zip"} {
+		content, err := rc.Get(context.Background(), name)
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		content.Close()
+	}
+
+	if got, want := rc.TotalBytes(), int64(50); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	rc.account("pinned.zip", 90)
+	if got, want := rc.TotalBytes(), int64(50); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,294 @@
+package goproxy
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+)
+
+// PathRewrite is a rewrite rule of module paths served by the [Goproxy], for
+// an organization migrating its vanity import domain (see [VanityImport]) so
+// that old branches still requiring the old module paths keep working.
+//
+// Module files under the old module paths are fetched from upstream as usual.
+// Those not found there (e.g. because the old domain no longer serves its
+// "?go-get=1" meta pages, or because their versions were only ever published
+// under the new module paths) are fetched under the new module paths instead,
+// with the module paths in their ".mod" and ".zip" files rewritten back to the
+// old ones. Since such rewritten module files cannot be verified against the
+// checksum database, the old module paths usually have to be listed in the
+// GONOSUMDB (or GOPRIVATE) of clients.
+type PathRewrite struct {
+	// From is the old module path prefix, such as "old.example/foo".
+	From string
+
+	// To is the new module path prefix, such as "new.example/foo".
+	To string
+
+	// Until is the end of the compatibility window of the PathRewrite.
+	// After it, requests for module paths under the [PathRewrite.From]
+	// are responded with a 410 Gone pointing to the new module paths,
+	// even if their module files have been cached.
+	//
+	// If the Until is zero, the compatibility window never ends.
+	Until time.Time
+}
+
+// rewrite returns the modulePath, which is under the pr.From, with its prefix
+// replaced by the pr.To at the now. It returns an error satisfying
+// errors.Is(err, errGone) if the compatibility window of the pr has ended.
+func (pr *PathRewrite) rewrite(
+	modulePath string,
+	now time.Time,
+) (string, error) {
+	rewritten := pr.To + strings.TrimPrefix(modulePath, pr.From)
+	if !pr.Until.IsZero() && now.After(pr.Until) {
+		return "", goneError(fmt.Sprintf(
+			"%s: module path has moved to %s",
+			modulePath,
+			rewritten,
+		))
+	}
+
+	return rewritten, nil
+}
+
+// pathRewrite returns the [PathRewrite] in the [Goproxy.PathRewrites] with the
+// longest [PathRewrite.From] that the modulePath is under. It returns nil if
+// not found.
+func (g *Goproxy) pathRewrite(modulePath string) *PathRewrite {
+	var matched *PathRewrite
+	for i, pr := range g.PathRewrites {
+		if modulePath != pr.From &&
+			!strings.HasPrefix(modulePath, pr.From+"/") {
+			continue
+		}
+
+		if matched == nil || len(pr.From) > len(matched.From) {
+			matched = &g.PathRewrites[i]
+		}
+	}
+
+	return matched
+}
+
+// doRewrite executes the f under its module path, falling back to its
+// rewritten module path if not found.
+func (f *fetch) doRewrite(ctx context.Context) (*fetchResult, error) {
+	of := *f
+	of.rewrittenModulePath = ""
+	r, err := of.do(ctx)
+	if err == nil {
+		r.f = f
+		return r, nil
+	} else if !errors.Is(err, errNotFound) ||
+		errors.Is(err, errBadUpstream) {
+		return nil, err
+	}
+
+	escapedModulePath, err := module.EscapePath(f.modulePath)
+	if err != nil {
+		return nil, err
+	}
+
+	escapedRewrittenModulePath, err := module.EscapePath(
+		f.rewrittenModulePath,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	rf := of
+	rf.modulePath = f.rewrittenModulePath
+	rf.name = escapedRewrittenModulePath +
+		strings.TrimPrefix(f.name, escapedModulePath)
+	rf.modAtVer = fmt.Sprint(rf.modulePath, "@", rf.moduleVersion)
+	rf.requiredToVerify = f.g.goBinEnvGOSUMDB != "off" &&
+		!globsMatchPath(f.g.goBinEnvGONOSUMDB, rf.modulePath)
+	r, err = rf.do(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	r.f = f
+	if r.GoMod != "" {
+		rewrittenGoMod := filepath.Join(f.tempDir, "rewritten.mod")
+		if err := rewriteModFile(
+			r.GoMod,
+			rewrittenGoMod,
+			f.modulePath,
+		); err != nil {
+			return nil, err
+		}
+
+		r.GoMod = rewrittenGoMod
+	}
+
+	if r.Zip != "" {
+		rewrittenZip := filepath.Join(f.tempDir, "rewritten.zip")
+		if err := rewriteZipFile(
+			r.Zip,
+			rewrittenZip,
+			rf.modulePath,
+			f.modulePath,
+			f.moduleVersion,
+		); err != nil {
+			return nil, err
+		}
+
+		r.Zip = rewrittenZip
+	}
+
+	f.g.updateStats(func(s *Stats) { s.PathRewrites++ })
+
+	return r, nil
+}
+
+// rewriteModFile writes the ".mod" file targeted by the src into the dst with
+// its module path rewritten to the modulePath.
+func rewriteModFile(src, dst, modulePath string) error {
+	b, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+
+	b, err = rewriteModFileContent(src, b, modulePath)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(dst, b, 0600)
+}
+
+// rewriteModFileContent returns the content of the ".mod" file targeted by the
+// name with its module path rewritten to the modulePath.
+func rewriteModFileContent(
+	name string,
+	b []byte,
+	modulePath string,
+) ([]byte, error) {
+	mf, err := modfile.ParseLax(name, b, nil)
+	if err != nil {
+		return nil, notFoundError(fmt.Sprintf(
+			"invalid mod file: %v",
+			err,
+		))
+	}
+
+	if err := mf.AddModuleStmt(modulePath); err != nil {
+		return nil, err
+	}
+
+	return mf.Format()
+}
+
+// rewriteZipFile writes the ".zip" file targeted by the src, which is of the
+// fromModulePath, into the dst as of the toModulePath at the moduleVersion.
+func rewriteZipFile(
+	src string,
+	dst string,
+	fromModulePath string,
+	toModulePath string,
+	moduleVersion string,
+) error {
+	zr, err := zip.OpenReader(src)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	df, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer df.Close()
+
+	fromPrefix := fmt.Sprint(fromModulePath, "@", moduleVersion, "/")
+	toPrefix := fmt.Sprint(toModulePath, "@", moduleVersion, "/")
+	zw := zip.NewWriter(df)
+	for _, file := range zr.File {
+		if !strings.HasPrefix(file.Name, fromPrefix) {
+			return notFoundError(fmt.Sprintf(
+				"invalid zip file: unexpected file %q",
+				file.Name,
+			))
+		}
+
+		name := toPrefix + strings.TrimPrefix(file.Name, fromPrefix)
+		var modulePath string
+		if name == toPrefix+"go.mod" {
+			modulePath = toModulePath
+		}
+
+		if err := rewriteZipFileEntry(
+			zw,
+			file,
+			name,
+			modulePath,
+		); err != nil {
+			return err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	if err := df.Close(); err != nil {
+		return err
+	}
+
+	return checkZipFile(dst, toModulePath, moduleVersion)
+}
+
+// rewriteZipFileEntry writes the file to the zw under the name. If the
+// modulePath is not empty, the file is the root "go.mod" file, whose module
+// path is rewritten to the modulePath.
+func rewriteZipFileEntry(
+	zw *zip.Writer,
+	file *zip.File,
+	name string,
+	modulePath string,
+) error {
+	rc, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	w, err := zw.CreateHeader(&zip.FileHeader{
+		Name:   name,
+		Method: zip.Deflate,
+	})
+	if err != nil {
+		return err
+	}
+
+	if modulePath == "" {
+		_, err = io.Copy(w, rc)
+		return err
+	}
+
+	b, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return err
+	}
+
+	b, err = rewriteModFileContent(file.Name, b, modulePath)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(b)
+	return err
+}
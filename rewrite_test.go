@@ -0,0 +1,199 @@
+package goproxy
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPathRewriteRewrite(t *testing.T) {
+	now := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	pr := &PathRewrite{From: "old.example", To: "new.example/x"}
+	if got, err := pr.rewrite("old.example/foo", now); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if want := "new.example/x/foo"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	pr.Until = now.Add(-time.Second)
+	if _, err := pr.rewrite("old.example/foo", now); err == nil {
+		t.Fatal("expected error")
+	} else if !errors.Is(err, errGone) {
+		t.Fatalf("got %q, want %q", err, errGone)
+	}
+}
+
+func TestGoproxyPathRewrite(t *testing.T) {
+	g := &Goproxy{PathRewrites: []PathRewrite{
+		{From: "old.example", To: "new.example"},
+		{From: "old.example/foo", To: "new.example/bar"},
+	}}
+	for _, tt := range []struct {
+		modulePath string
+		wantTo     string
+	}{
+		{"old.example", "new.example"},
+		{"old.example/baz", "new.example"},
+		{"old.example/foo/v2", "new.example/bar"},
+		{"old.examplefoo", ""},
+		{"example.com", ""},
+	} {
+		var got string
+		if pr := g.pathRewrite(tt.modulePath); pr != nil {
+			got = pr.To
+		}
+
+		if got != tt.wantTo {
+			t.Errorf("got %q, want %q", got, tt.wantTo)
+		}
+	}
+}
+
+func TestGoproxyPathRewrites(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestGoproxyPathRewrites")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	var zipBuf bytes.Buffer
+	zw := zip.NewWriter(&zipBuf)
+	for name, content := range map[string]string{
+		"new.example/foo@v1.0.0/go.mod": "module new.example/foo\n",
+		"new.example/foo@v1.0.0/foo.go": "package foo\n",
+	} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(
+		rw http.ResponseWriter,
+		req *http.Request,
+	) {
+		switch req.URL.Path {
+		case "/old.example/foo/@v/v0.1.0.mod":
+			responseString(
+				rw,
+				req,
+				http.StatusOK,
+				-2,
+				"module old.example/foo",
+			)
+		case "/new.example/foo/@v/v1.0.0.mod":
+			responseString(
+				rw,
+				req,
+				http.StatusOK,
+				-2,
+				"module new.example/foo\n\ngo 1.13\n",
+			)
+		case "/new.example/foo/@v/v1.0.0.zip":
+			rw.Write(zipBuf.Bytes())
+		default:
+			responseNotFound(rw, req, -2)
+		}
+	}))
+	defer server.Close()
+
+	g := &Goproxy{
+		GoBinEnv: []string{
+			"GOPROXY=" + server.URL,
+			"GOSUMDB=off",
+		},
+		Cacher:  DirCacher(tempDir),
+		TempDir: tempDir,
+		PathRewrites: []PathRewrite{{
+			From: "old.example/foo",
+			To:   "new.example/foo",
+		}},
+		ErrorLogger: log.New(&discardWriter{}, "", 0),
+	}
+
+	get := func(name string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest("", "/"+name, nil))
+		return rec
+	}
+
+	rec := get("old.example/foo/@v/v0.1.0.mod")
+	if got, want := rec.Body.String(),
+		"module old.example/foo"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if got := g.Stats().PathRewrites; got != 0 {
+		t.Errorf("got %d, want 0", got)
+	}
+
+	rec = get("old.example/foo/@v/v1.0.0.mod")
+	if got, want := rec.Body.String(),
+		"module old.example/foo\n\ngo 1.13\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	rec = get("old.example/foo/@v/v1.0.0.zip")
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	zr, err := zip.NewReader(
+		bytes.NewReader(rec.Body.Bytes()),
+		int64(rec.Body.Len()),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	files := map[string]string{}
+	for _, file := range zr.File {
+		rc, err := file.Open()
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		b, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		files[file.Name] = string(b)
+	}
+
+	if got, want := files["old.example/foo@v1.0.0/go.mod"],
+		"module old.example/foo\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if got, want := files["old.example/foo@v1.0.0/foo.go"],
+		"package foo\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if got, want := g.Stats().PathRewrites, int64(2); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	g.PathRewrites[0].Until = time.Now().Add(-time.Second)
+	rec = get("old.example/foo/@v/v1.0.0.mod")
+	if got, want := rec.Code, http.StatusGone; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,763 @@
+package goproxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// s3ExpiresHeader is the header of the user-defined metadata of the
+	// objects stored by the [S3Cacher] that holds their expiration times
+	// in Unix seconds.
+	s3ExpiresHeader = "X-Amz-Meta-Goproxy-Expires"
+
+	// s3EmptyPayloadHash is the SHA-256 hash of an empty payload.
+	s3EmptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb924" +
+		"27ae41e4649b934ca495991b7852b855"
+)
+
+// S3Cacher implements the [Cacher] using an Amazon S3 bucket, or a bucket of
+// any S3-compatible object storage (e.g. MinIO), so that multiple stateless
+// Goproxy instances behind a load balancer can share the same caches. Requests
+// are signed with the AWS Signature Version 4 if the
+// [S3Cacher.AccessKeyID] is not empty.
+//
+// The expiration of each cache is stored in the user-defined metadata of its
+// object, and expired objects are treated as not found. They are removed by
+// the [S3Cacher.Cleanup], which has to check every object. For large buckets,
+// consider mapping the expirations to object tags via the
+// [S3Cacher.ExpirationTagging] and letting the lifecycle rules of the bucket
+// expire the objects server-side instead.
+//
+// Contents that cannot be seeked can be put via the [S3Cacher.PutStream] as
+// multipart uploads without buffering them as a whole.
+//
+// The contents returned by the [S3Cacher.Get] implement the ETag() and the
+// LastModified() (see the [Cacher.Get]), so that conditional requests work.
+//
+// Make sure that all fields of the S3Cacher have been finalized before calling
+// any of its methods.
+type S3Cacher struct {
+	// Endpoint is the base URL of the S3 endpoint.
+	//
+	// If the Endpoint is empty, the Amazon S3 endpoint of the
+	// [S3Cacher.Region] is used.
+	Endpoint string
+
+	// Region is the region of the bucket.
+	//
+	// If the Region is empty, "us-east-1" is used.
+	Region string
+
+	// Bucket is the name of the bucket.
+	Bucket string
+
+	// Prefix is the prefix of the object keys, such as "goproxy/".
+	Prefix string
+
+	// ForcePathStyle indicates whether to address the bucket in the path
+	// of request URLs instead of in their hosts, which most S3-compatible
+	// object storages require.
+	ForcePathStyle bool
+
+	// AccessKeyID is the AWS access key ID.
+	//
+	// If the AccessKeyID is empty, requests are sent anonymously.
+	AccessKeyID string
+
+	// SecretAccessKey is the AWS secret access key.
+	SecretAccessKey string
+
+	// SessionToken is the AWS session token of temporary credentials.
+	SessionToken string
+
+	// ExpirationTagging maps the expiration of a cache to the tag set (in
+	// the form of URL query parameters, e.g. "goproxy-expiration=1d") of
+	// its object, which the lifecycle rules of the bucket can filter on
+	// to expire objects server-side.
+	//
+	// If the ExpirationTagging is nil, objects are not tagged.
+	ExpirationTagging func(expiration time.Duration) string
+
+	// PartSize is the size of the parts of the multipart uploads made by
+	// the [S3Cacher.PutStream]. The S3 requires it to be at least 5 MiB.
+	//
+	// If the PartSize is zero, 8 MiB is used.
+	PartSize int
+
+	// Transport is used to send requests to the S3 endpoint.
+	//
+	// If the Transport is nil, the [http.DefaultTransport] is used.
+	Transport http.RoundTripper
+}
+
+// Get implements the [Cacher].
+func (sc *S3Cacher) Get(
+	ctx context.Context,
+	name string,
+) (io.ReadCloser, error) {
+	res, err := sc.do(ctx, http.MethodGet, sc.key(name), nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if s3ObjectExpired(res.Header, expiryNow(ctx)) {
+		res.Body.Close()
+		return nil, os.ErrNotExist
+	}
+
+	content := &s3Content{
+		ReadCloser: res.Body,
+		etag:       res.Header.Get("ETag"),
+	}
+	lastModified := res.Header.Get("Last-Modified")
+	content.lastModified, _ = http.ParseTime(lastModified)
+	if expires, err := strconv.ParseInt(
+		res.Header.Get(s3ExpiresHeader),
+		10,
+		64,
+	); err == nil {
+		content.expires = time.Unix(expires, 0)
+	}
+
+	return content, nil
+}
+
+// Put implements the [Cacher].
+func (sc *S3Cacher) Put(
+	ctx context.Context,
+	name string,
+	content io.ReadSeeker,
+	expiration time.Duration,
+) error {
+	hash := sha256.New()
+	size, err := io.Copy(hash, content)
+	if err != nil {
+		return err
+	} else if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	body := &s3Body{
+		ReadSeeker:  content,
+		size:        size,
+		payloadHash: hex.EncodeToString(hash.Sum(nil)),
+	}
+	res, err := sc.do(
+		ctx,
+		http.MethodPut,
+		sc.key(name),
+		nil,
+		sc.putHeader(expiration),
+		body,
+	)
+	if err != nil {
+		return err
+	}
+
+	return res.Body.Close()
+}
+
+// PutStream implements the [StreamPutter]. Contents larger than the
+// [S3Cacher.PartSize] are uploaded as multipart uploads, one part at a time,
+// so that at most one part is held in memory. Failed multipart uploads are
+// aborted.
+func (sc *S3Cacher) PutStream(
+	ctx context.Context,
+	name string,
+	content io.Reader,
+	expiration time.Duration,
+) error {
+	partSize := sc.PartSize
+	if partSize == 0 {
+		partSize = 8 << 20
+	}
+
+	part := make([]byte, partSize)
+	n, err := io.ReadFull(content, part)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return sc.Put(ctx, name, bytes.NewReader(part[:n]), expiration)
+	} else if err != nil {
+		return err
+	}
+
+	key := sc.key(name)
+	res, err := sc.do(
+		ctx,
+		http.MethodPost,
+		key,
+		url.Values{"uploads": {""}},
+		sc.putHeader(expiration),
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = xml.NewDecoder(res.Body).Decode(&initiated)
+	res.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	if err := sc.uploadParts(
+		ctx,
+		key,
+		initiated.UploadID,
+		content,
+		part,
+		n,
+	); err != nil {
+		// The ctx may have been canceled.
+		res, abortErr := sc.do(
+			context.Background(),
+			http.MethodDelete,
+			key,
+			url.Values{"uploadId": {initiated.UploadID}},
+			nil,
+			nil,
+		)
+		if abortErr == nil {
+			res.Body.Close()
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// uploadParts uploads the content, whose first n bytes have already been read
+// into the part, as the parts of the multipart upload with the uploadID to the
+// object targeted by the key, and completes the upload. The part is reused for
+// reading the rest of the content.
+func (sc *S3Cacher) uploadParts(
+	ctx context.Context,
+	key string,
+	uploadID string,
+	content io.Reader,
+	part []byte,
+	n int,
+) error {
+	type completedPart struct {
+		PartNumber int
+		ETag       string
+	}
+	var completed struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}
+	for n > 0 {
+		partNumber := len(completed.Parts) + 1
+		res, err := sc.do(
+			ctx,
+			http.MethodPut,
+			key,
+			url.Values{
+				"partNumber": {strconv.Itoa(partNumber)},
+				"uploadId":   {uploadID},
+			},
+			nil,
+			newS3Body(part[:n]),
+		)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+
+		completed.Parts = append(completed.Parts, completedPart{
+			PartNumber: partNumber,
+			ETag:       res.Header.Get("ETag"),
+		})
+		if n < len(part) {
+			break
+		}
+
+		n, err = io.ReadFull(content, part)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+	}
+
+	b, err := xml.Marshal(completed)
+	if err != nil {
+		return err
+	}
+
+	res, err := sc.do(
+		ctx,
+		http.MethodPost,
+		key,
+		url.Values{"uploadId": {uploadID}},
+		nil,
+		newS3Body(b),
+	)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	// Completing a multipart upload may fail after the response header
+	// has been sent, in which case the error is in the response body.
+	var result struct {
+		XMLName xml.Name
+		Code    string
+		Message string
+	}
+	if err := xml.NewDecoder(res.Body).Decode(&result); err != nil {
+		return err
+	} else if result.XMLName.Local == "Error" {
+		return fmt.Errorf(
+			"s3: complete multipart upload: %s: %s",
+			result.Code,
+			result.Message,
+		)
+	}
+
+	return nil
+}
+
+// putHeader returns the request header for putting an object that expires after
+// the expiration.
+func (sc *S3Cacher) putHeader(expiration time.Duration) http.Header {
+	header := http.Header{}
+	header.Set(s3ExpiresHeader, strconv.FormatInt(
+		time.Now().Add(expiration).Unix(),
+		10,
+	))
+	if sc.ExpirationTagging != nil {
+		if tagging := sc.ExpirationTagging(expiration); tagging != "" {
+			header.Set("X-Amz-Tagging", tagging)
+		}
+	}
+
+	return header
+}
+
+// Delete implements the [Deleter].
+func (sc *S3Cacher) Delete(ctx context.Context, name string) error {
+	key := sc.key(name)
+
+	// Deleting a missing object succeeds, so its existence is checked
+	// first to report the os.ErrNotExist.
+	res, err := sc.do(ctx, http.MethodHead, key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	res, err = sc.do(ctx, http.MethodDelete, key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	return res.Body.Close()
+}
+
+// Cleanup implements the [Cacher].
+func (sc *S3Cacher) Cleanup() error {
+	return sc.CleanupReclaimed(nil)
+}
+
+// CleanupReclaimed implements the [Reclaimer].
+func (sc *S3Cacher) CleanupReclaimed(
+	reclaimed func(name string, size int64),
+) error {
+	ctx := context.Background()
+	return sc.walkCaches(func(name string, size int64) error {
+		key := sc.key(name)
+		res, err := sc.do(ctx, http.MethodHead, key, nil, nil, nil)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+
+			return err
+		}
+		res.Body.Close()
+
+		if !s3ObjectExpired(res.Header, time.Now()) {
+			return nil
+		}
+
+		res, err = sc.do(ctx, http.MethodDelete, key, nil, nil, nil)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+
+		if reclaimed != nil {
+			reclaimed(name, size)
+		}
+
+		return nil
+	})
+}
+
+// List implements the [Lister].
+func (sc *S3Cacher) List(ctx context.Context, prefix string) CacheIterator {
+	return listCaches(ctx, sc.walkCaches, prefix)
+}
+
+// walkCaches implements the [cacheWalker].
+func (sc *S3Cacher) walkCaches(fn func(name string, size int64) error) error {
+	prefix := sc.key("")
+	query := url.Values{}
+	query.Set("list-type", "2")
+	if prefix != "" {
+		query.Set("prefix", prefix)
+	}
+
+	for {
+		res, err := sc.do(
+			context.Background(),
+			http.MethodGet,
+			"",
+			query,
+			nil,
+			nil,
+		)
+		if err != nil {
+			return err
+		}
+
+		var result struct {
+			Contents []struct {
+				Key  string
+				Size int64
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.NewDecoder(res.Body).Decode(&result)
+		res.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		for _, c := range result.Contents {
+			err := fn(strings.TrimPrefix(c.Key, prefix), c.Size)
+			if err != nil {
+				return err
+			}
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return nil
+		}
+
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+// key returns the object key of the cache for the name.
+func (sc *S3Cacher) key(name string) string {
+	if sc.Prefix == "" {
+		return name
+	}
+
+	return strings.TrimSuffix(sc.Prefix, "/") + "/" + name
+}
+
+// region returns the region of the bucket of the sc.
+func (sc *S3Cacher) region() string {
+	if sc.Region == "" {
+		return "us-east-1"
+	}
+
+	return sc.Region
+}
+
+// objectURL returns the URL of the object targeted by the key, or of the
+// bucket if the key is empty.
+func (sc *S3Cacher) objectURL(key string, query url.Values) (*url.URL, error) {
+	endpoint := sc.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + sc.region() + ".amazonaws.com"
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	escapedPath := strings.TrimSuffix(u.EscapedPath(), "/")
+	if sc.ForcePathStyle {
+		escapedPath += "/" + s3URIEncode(sc.Bucket, false)
+	} else {
+		u.Host = sc.Bucket + "." + u.Host
+	}
+
+	escapedPath += "/" + s3URIEncode(key, true)
+	u.Path, err = url.PathUnescape(escapedPath)
+	if err != nil {
+		return nil, err
+	}
+
+	u.RawPath = escapedPath
+	u.RawQuery = s3CanonicalQuery(query)
+
+	return u, nil
+}
+
+// do sends a request with the method for the object targeted by the key (or
+// for the bucket if the key is empty), with the query, the header and the
+// body. It returns the [os.ErrNotExist] if the object is not found, and an
+// error for any other non-2xx response.
+func (sc *S3Cacher) do(
+	ctx context.Context,
+	method string,
+	key string,
+	query url.Values,
+	header http.Header,
+	body *s3Body,
+) (*http.Response, error) {
+	u, err := sc.objectURL(key, query)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+
+	payloadHash := s3EmptyPayloadHash
+	if body != nil {
+		req.Body = ioutil.NopCloser(body)
+		req.ContentLength = body.size
+		payloadHash = body.payloadHash
+	}
+
+	if sc.AccessKeyID != "" {
+		signS3Request(
+			req,
+			payloadHash,
+			sc.region(),
+			sc.AccessKeyID,
+			sc.SecretAccessKey,
+			sc.SessionToken,
+			time.Now(),
+		)
+	}
+
+	transport := sc.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	res, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return res, nil
+	}
+
+	b, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+	res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, os.ErrNotExist
+	}
+
+	return nil, fmt.Errorf(
+		"s3: %s %s: %s: %s",
+		method,
+		redactedURL(u),
+		res.Status,
+		b,
+	)
+}
+
+// s3Body is the body of a request sent by the [S3Cacher].
+type s3Body struct {
+	io.ReadSeeker
+
+	size        int64
+	payloadHash string
+}
+
+// newS3Body returns a new instance of the [s3Body] with the b.
+func newS3Body(b []byte) *s3Body {
+	hash := sha256.Sum256(b)
+	return &s3Body{
+		ReadSeeker:  bytes.NewReader(b),
+		size:        int64(len(b)),
+		payloadHash: hex.EncodeToString(hash[:]),
+	}
+}
+
+// s3Content is the content of a cache got by the [S3Cacher].
+type s3Content struct {
+	io.ReadCloser
+
+	etag         string
+	lastModified time.Time
+	expires      time.Time
+}
+
+// ETag returns the ETag of the object of the s3c.
+func (s3c *s3Content) ETag() string {
+	return s3c.etag
+}
+
+// LastModified returns the last modification time of the object of the s3c.
+func (s3c *s3Content) LastModified() time.Time {
+	return s3c.lastModified
+}
+
+// Expires returns the expiration time of the object of the s3c.
+func (s3c *s3Content) Expires() time.Time {
+	return s3c.expires
+}
+
+// s3ObjectExpired reports whether the object whose response header is the
+// header has expired at the now.
+func s3ObjectExpired(header http.Header, now time.Time) bool {
+	expires, err := strconv.ParseInt(header.Get(s3ExpiresHeader), 10, 64)
+	return err == nil && now.Unix() >= expires
+}
+
+// signS3Request signs the req, whose payload hashes to the payloadHash, with
+// the AWS Signature Version 4 for the S3 in the region at the now. All headers
+// of the req are signed, along with its host.
+func signS3Request(
+	req *http.Request,
+	payloadHash string,
+	region string,
+	accessKeyID string,
+	secretAccessKey string,
+	sessionToken string,
+	now time.Time,
+) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, vs := range req.Header {
+		values := make([]string, 0, len(vs))
+		for _, v := range vs {
+			v = strings.Join(strings.Fields(v), " ")
+			values = append(values, v)
+		}
+
+		headers[strings.ToLower(k)] = strings.Join(values, ",")
+	}
+
+	headerNames := make([]string, 0, len(headers))
+	for k := range headers {
+		headerNames = append(headerNames, k)
+	}
+
+	sort.Strings(headerNames)
+
+	var canonicalHeaders strings.Builder
+	for _, k := range headerNames {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", k, headers[k])
+	}
+
+	signedHeaders := strings.Join(headerNames, ";")
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, region)
+	canonicalRequestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(canonicalRequestHash[:]),
+	}, "\n")
+
+	key := []byte("AWS4" + secretAccessKey)
+	for _, s := range []string{date, region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, s)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, "+
+			"SignedHeaders=%s, Signature=%x",
+		accessKeyID,
+		scope,
+		signedHeaders,
+		hmacSHA256(key, stringToSign),
+	))
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of the s with the key.
+func hmacSHA256(key []byte, s string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(s))
+	return h.Sum(nil)
+}
+
+// s3CanonicalQuery returns the canonical form of the query as required by the
+// AWS Signature Version 4.
+func s3CanonicalQuery(query url.Values) string {
+	pairs := make([]string, 0, len(query))
+	for k, vs := range query {
+		for _, v := range vs {
+			pairs = append(
+				pairs,
+				s3URIEncode(k, false)+"="+s3URIEncode(v, false),
+			)
+		}
+	}
+
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, "&")
+}
+
+// s3URIEncode encodes the s as required by the AWS Signature Version 4, leaving
+// slashes unencoded if the keepSlashes is true.
+func s3URIEncode(s string, keepSlashes bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' ||
+			'a' <= c && c <= 'z' ||
+			'0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' ||
+			c == '/' && keepSlashes {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
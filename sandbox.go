@@ -0,0 +1,89 @@
+package goproxy
+
+import "os/exec"
+
+// GoBinSandbox is the sandbox for the Go binary targeted by the
+// [Goproxy.GoBinName]. Since the Go binary executes VCS tools against untrusted
+// input when fetching modules directly, it is recommended to run it with as few
+// privileges as possible.
+//
+// Note that the UID, GID and cgroup related fields are only supported on Linux.
+type GoBinSandbox struct {
+	// UID is the user ID that the Go binary runs as.
+	//
+	// If the UID is zero, the Go binary runs as the current user.
+	//
+	// Note that the [Goproxy.TempDir] and the Go module cache of the Go
+	// binary must be accessible to the UID.
+	UID int
+
+	// GID is the group ID that the Go binary runs as.
+	//
+	// If the GID is zero, the Go binary runs as the current group.
+	GID int
+
+	// CgroupDir is the directory of an existing cgroup (v2) that the Go
+	// binary and all of its child processes will be moved into right after
+	// being started.
+	//
+	// If the CgroupDir is empty, the cgroup is inherited.
+	CgroupDir string
+
+	// CgroupMemoryMax is the value written into the "memory.max" file of
+	// the [GoBinSandbox.CgroupDir] before the Go binary is started.
+	//
+	// If the CgroupMemoryMax is zero, the "memory.max" file is left
+	// untouched.
+	CgroupMemoryMax int64
+
+	// CgroupCPUMax is the value written into the "cpu.max" file of the
+	// [GoBinSandbox.CgroupDir] before the Go binary is started. It is of
+	// the form "<max> <period>" (e.g. "50000 100000" means half a CPU).
+	//
+	// If the CgroupCPUMax is empty, the "cpu.max" file is left untouched.
+	CgroupCPUMax string
+
+	// CommandPrefix is the command and its arguments that are used to
+	// launch the Go binary, such as {"unshare", "--net"} or {"ip", "netns",
+	// "exec", "goproxy"}. It is mostly for restricting the network access
+	// of the Go binary with namespaces.
+	//
+	// If the CommandPrefix is empty, the Go binary is launched directly.
+	CommandPrefix []string
+
+	// EnvKeys is the allowlist of the keys of the [Goproxy.GoBinEnv] that
+	// are passed to the Go binary. Note that the environment variables
+	// built-in supported by the [Goproxy] are always handled by itself.
+	//
+	// If the EnvKeys is nil, all environment variables are passed.
+	EnvKeys []string
+}
+
+// command returns the [exec.Cmd] to execute the named program with the args
+// inside the s. It is safe to call on a nil s.
+func (s *GoBinSandbox) command(name string, args ...string) (*exec.Cmd, error) {
+	if s == nil {
+		return exec.Command(name, args...), nil
+	}
+
+	if len(s.CommandPrefix) > 0 {
+		args = append(append(append(
+			[]string{},
+			s.CommandPrefix[1:]...,
+		), name), args...)
+		name = s.CommandPrefix[0]
+	}
+
+	cmd := exec.Command(name, args...)
+	if err := s.prepare(cmd); err != nil {
+		return nil, err
+	}
+
+	return cmd, nil
+}
+
+// allowsEnv reports whether the environment variable targeted by the key is
+// allowed to be passed to the Go binary. It is safe to call on a nil s.
+func (s *GoBinSandbox) allowsEnv(key string) bool {
+	return s == nil || s.EnvKeys == nil || stringSliceContains(s.EnvKeys, key)
+}
@@ -0,0 +1,88 @@
+package goproxy
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// prepare prepares the cmd to be started inside the s.
+func (s *GoBinSandbox) prepare(cmd *exec.Cmd) error {
+	if s.UID != 0 || s.GID != 0 {
+		uid, gid := os.Getuid(), os.Getgid()
+		if s.UID != 0 {
+			uid = s.UID
+		}
+
+		if s.GID != 0 {
+			gid = s.GID
+		}
+
+		if cmd.SysProcAttr == nil {
+			cmd.SysProcAttr = &syscall.SysProcAttr{}
+		}
+
+		cmd.SysProcAttr.Credential = &syscall.Credential{
+			Uid: uint32(uid),
+			Gid: uint32(gid),
+		}
+	}
+
+	if s.CgroupDir == "" {
+		return nil
+	}
+
+	if s.CgroupMemoryMax != 0 {
+		if err := writeCgroupFile(
+			s.CgroupDir,
+			"memory.max",
+			strconv.FormatInt(s.CgroupMemoryMax, 10),
+		); err != nil {
+			return err
+		}
+	}
+
+	if s.CgroupCPUMax != "" {
+		if err := writeCgroupFile(
+			s.CgroupDir,
+			"cpu.max",
+			s.CgroupCPUMax,
+		); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// started is called right after the cmd has been started inside the s. It is
+// safe to call on a nil s.
+func (s *GoBinSandbox) started(cmd *exec.Cmd) error {
+	if s == nil || s.CgroupDir == "" {
+		return nil
+	}
+
+	return writeCgroupFile(
+		s.CgroupDir,
+		"cgroup.procs",
+		strconv.Itoa(cmd.Process.Pid),
+	)
+}
+
+// writeCgroupFile writes the value into the cgroup interface file targeted by
+// the name in the dir.
+func writeCgroupFile(dir, name, value string) error {
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.WriteString(value); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,77 @@
+package goproxy
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestGoBinSandboxPrepare(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestGoBinSandboxPrepare")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	for _, name := range []string{"memory.max", "cpu.max", "cgroup.procs"} {
+		if err := ioutil.WriteFile(
+			filepath.Join(tempDir, name),
+			nil,
+			0600,
+		); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	s := &GoBinSandbox{
+		UID:             12345,
+		CgroupDir:       tempDir,
+		CgroupMemoryMax: 1 << 30,
+		CgroupCPUMax:    "50000 100000",
+	}
+	cmd := exec.Command("go", "version")
+	if err := s.prepare(cmd); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := cmd.SysProcAttr.Credential.Uid,
+		uint32(12345); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	} else if got, want := cmd.SysProcAttr.Credential.Gid,
+		uint32(os.Getgid()); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	if b, err := ioutil.ReadFile(
+		filepath.Join(tempDir, "memory.max"),
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := string(b), "1073741824"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if b, err := ioutil.ReadFile(
+		filepath.Join(tempDir, "cpu.max"),
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := string(b), "50000 100000"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	cmd = exec.Command("go", "version")
+	cmd.Process = &os.Process{Pid: 42}
+	if err := s.started(cmd); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if b, err := ioutil.ReadFile(
+		filepath.Join(tempDir, "cgroup.procs"),
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := string(b), "42"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	s = &GoBinSandbox{CgroupDir: filepath.Join(tempDir, "404")}
+	if err := s.started(cmd); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,30 @@
+//go:build !linux
+// +build !linux
+
+package goproxy
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// errSandboxNotSupported means a sandbox feature is not supported on the
+// current platform.
+var errSandboxNotSupported = errors.New(
+	"go binary sandbox is not supported on this platform",
+)
+
+// prepare prepares the cmd to be started inside the s.
+func (s *GoBinSandbox) prepare(cmd *exec.Cmd) error {
+	if s.UID != 0 || s.GID != 0 || s.CgroupDir != "" {
+		return errSandboxNotSupported
+	}
+
+	return nil
+}
+
+// started is called right after the cmd has been started inside the s. It is
+// safe to call on a nil s.
+func (s *GoBinSandbox) started(cmd *exec.Cmd) error {
+	return nil
+}
@@ -0,0 +1,61 @@
+package goproxy
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGoBinSandboxCommand(t *testing.T) {
+	var s *GoBinSandbox
+	if cmd, err := s.command("go", "version"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := strings.Join(cmd.Args, " "),
+		"go version"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	s = &GoBinSandbox{CommandPrefix: []string{"unshare", "--net"}}
+	if cmd, err := s.command("go", "version"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := strings.Join(cmd.Args, " "),
+		"unshare --net go version"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	} else if got, want := strings.Join(s.CommandPrefix, " "),
+		"unshare --net"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestGoBinSandboxAllowsEnv(t *testing.T) {
+	var s *GoBinSandbox
+	if !s.allowsEnv("HOME") {
+		t.Error("want true")
+	}
+
+	s = &GoBinSandbox{}
+	if !s.allowsEnv("HOME") {
+		t.Error("want true")
+	}
+
+	s = &GoBinSandbox{EnvKeys: []string{"PATH"}}
+	if !s.allowsEnv("PATH") {
+		t.Error("want true")
+	}
+
+	if s.allowsEnv("HOME") {
+		t.Error("want false")
+	}
+
+	g := &Goproxy{
+		GoBinEnv:     []string{"PATH=/bin", "SECRET=foobar", "GOPROXY=off"},
+		GoBinSandbox: s,
+	}
+	g.init()
+	if got, want := strings.Join(g.goBinEnv, " "), "PATH=/bin "+
+		"GO111MODULE=on GOPROXY=direct GONOPROXY= GOSUMDB=off "+
+		"GONOSUMDB= GOPRIVATE="; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	} else if got, want := g.goBinEnvGOPROXY, "off"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
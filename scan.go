@@ -0,0 +1,309 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+// errWalkNotSupported means the [Goproxy.Cacher] cannot enumerate its caches.
+var errWalkNotSupported = errors.New("cacher does not support enumeration")
+
+// CacheScanner scans the caches of a [Goproxy] for integrity, typically once at
+// startup. It validates each cached module file and removes the invalid ones,
+// and rebuilds the in-memory indexes derived from the caches (the sample used
+// by the [ConsistencyChecker] and the accounting of a [RetentionCacher]), so
+// that the features depending on them also cover the caches put before the
+// Goproxy started (e.g. restored from a backup).
+//
+// The [Goproxy.Cacher] must be a [DirCacher] or a [ShardedDirCacher], possibly
+// wrapped by a [QuotaCacher], a [RetentionCacher] and a
+// [ContentAddressedCacher], since other cachers cannot enumerate their caches.
+type CacheScanner struct {
+	// Goproxy is the [Goproxy] whose caches are scanned.
+	Goproxy *Goproxy
+
+	// MaxDuration is the maximum duration of a scan. Once exceeded, the
+	// scan stops and reports itself as incomplete.
+	//
+	// If the MaxDuration is zero, there is no limit.
+	MaxDuration time.Duration
+
+	// Parallelism is the maximum number of caches validated at the same
+	// time.
+	//
+	// If the Parallelism is zero, 1 is used.
+	Parallelism int
+
+	// ProgressInterval is the interval between two calls of the
+	// [CacheScanner.OnProgress].
+	//
+	// If the ProgressInterval is zero, 10 seconds is used.
+	ProgressInterval time.Duration
+
+	// OnProgress is called periodically with the progress of a scan, and
+	// once more when it ends.
+	//
+	// If the OnProgress is nil, the progress is not reported. It can
+	// still be observed via the [Goproxy.Stats].
+	OnProgress func(p CacheScanProgress)
+}
+
+// CacheScanProgress is the progress of a scan run by a [CacheScanner].
+type CacheScanProgress struct {
+	// Scanned is the number of caches scanned.
+	Scanned int64
+
+	// ScannedBytes is the number of bytes of the caches scanned.
+	ScannedBytes int64
+
+	// Invalid is the number of invalid caches found and removed.
+	Invalid int64
+
+	// Elapsed is the time elapsed since the scan started.
+	Elapsed time.Duration
+
+	// Complete indicates whether the scan has covered all caches.
+	Complete bool
+}
+
+// Scan runs a scan of the cs until all caches are scanned, the
+// [CacheScanner.MaxDuration] is exceeded, or the ctx is done. Invalid caches
+// are logged as errors by the [CacheScanner.Goproxy].
+func (cs *CacheScanner) Scan(ctx context.Context) (CacheScanProgress, error) {
+	g := cs.Goproxy
+	g.initOnce.Do(g.init)
+
+	startTime := time.Now()
+	var (
+		mutex    sync.Mutex
+		progress CacheScanProgress
+	)
+
+	snapshot := func() CacheScanProgress {
+		mutex.Lock()
+		defer mutex.Unlock()
+		p := progress
+		p.Elapsed = time.Since(startTime)
+		return p
+	}
+
+	cw, ok := cacheWalkerOf(g.Cacher)
+	if !ok {
+		return snapshot(), errWalkNotSupported
+	}
+
+	scanCtx := ctx
+	if cs.MaxDuration > 0 {
+		var cancel context.CancelFunc
+		scanCtx, cancel = context.WithTimeout(ctx, cs.MaxDuration)
+		defer cancel()
+	}
+
+	tempDir, err := ioutil.TempDir(g.TempDir, "goproxy")
+	if err != nil {
+		return snapshot(), err
+	}
+	defer os.RemoveAll(tempDir)
+
+	stopProgress := func() {}
+	if cs.OnProgress != nil {
+		progressInterval := cs.ProgressInterval
+		if progressInterval == 0 {
+			progressInterval = 10 * time.Second
+		}
+
+		ticker := time.NewTicker(progressInterval)
+		stop := make(chan struct{})
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			for {
+				select {
+				case <-ticker.C:
+					cs.OnProgress(snapshot())
+				case <-stop:
+					return
+				}
+			}
+		}()
+
+		stopProgress = func() {
+			ticker.Stop()
+			close(stop)
+			<-stopped
+		}
+	}
+
+	parallelism := cs.Parallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	type cache struct {
+		name string
+		size int64
+	}
+
+	caches := make(chan cache)
+	var wg sync.WaitGroup
+	for i := 0; i < parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range caches {
+				invalid := g.scanCache(scanCtx, c.name, c.size, tempDir)
+
+				mutex.Lock()
+				progress.Scanned++
+				progress.ScannedBytes += c.size
+				if invalid {
+					progress.Invalid++
+				}
+				mutex.Unlock()
+
+				g.updateStats(func(s *Stats) {
+					s.CachesScanned++
+					s.BytesScanned += c.size
+					if invalid {
+						s.InvalidCachesRemoved++
+					}
+				})
+			}
+		}()
+	}
+
+	err = cw.walkCaches(func(name string, size int64) error {
+		select {
+		case caches <- cache{name, size}:
+			return nil
+		case <-scanCtx.Done():
+			return scanCtx.Err()
+		}
+	})
+	close(caches)
+	wg.Wait()
+
+	if err == nil {
+		err = scanCtx.Err()
+	}
+
+	stopProgress()
+	p := snapshot()
+	p.Complete = err == nil
+	if cs.OnProgress != nil {
+		cs.OnProgress(p)
+	}
+
+	if err != nil && ctx.Err() == nil && scanCtx.Err() != nil {
+		return p, nil // The MaxDuration has been exceeded.
+	}
+
+	return p, err
+}
+
+// scanCache validates the cache for the name with the size and adds it to the
+// indexes derived from the caches. It returns true if the cache is invalid
+// and has been removed.
+func (g *Goproxy) scanCache(
+	ctx context.Context,
+	name string,
+	size int64,
+	tempDir string,
+) bool {
+	if err := g.checkCache(ctx, name, tempDir); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false // Expired or removed since being walked
+		} else if !errors.Is(err, errNotFound) {
+			if ctx.Err() == nil {
+				g.logErrorf("failed to scan cache: %s: %v", name, err)
+			}
+
+			return false
+		}
+
+		g.logErrorf("invalid cache: %s: %v", name, err)
+		if d, ok := g.Cacher.(Deleter); ok {
+			if err := d.Delete(ctx, name); err != nil &&
+				!errors.Is(err, os.ErrNotExist) {
+				g.logErrorf(
+					"failed to remove invalid cache: %s: %v",
+					name,
+					err,
+				)
+				return false
+			}
+		}
+
+		return true
+	}
+
+	if isModuleFileName(name) {
+		g.cachedNames.add(name)
+	}
+
+	// A QuotaCacher accounts the existing caches by itself.
+	cacher := g.Cacher
+	if qc, ok := cacher.(*QuotaCacher); ok {
+		cacher = qc.Cacher
+	}
+
+	if rc, ok := cacher.(*RetentionCacher); ok {
+		rc.account(name, size)
+	}
+
+	return false
+}
+
+// checkCache checks the cached module file targeted by the name. It returns an
+// error that satisfies errors.Is(err, errNotFound) if the cache is invalid.
+// Caches other than module files are not checked.
+func (g *Goproxy) checkCache(
+	ctx context.Context,
+	name string,
+	tempDir string,
+) error {
+	f, err := newFetch(g, name, tempDir)
+	if err != nil {
+		return nil // Not a module file (e.g. checksum database caches)
+	}
+
+	switch f.ops {
+	case fetchOpsDownloadInfo, fetchOpsDownloadMod, fetchOpsDownloadZip:
+	default:
+		return nil
+	}
+
+	tempFile, err := ioutil.TempFile(tempDir, "*"+path.Ext(name))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+
+	content, err := g.Cacher.Get(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tempFile, content)
+	content.Close()
+	if err != nil {
+		return err
+	}
+
+	if err := tempFile.Close(); err != nil {
+		return err
+	}
+
+	if err := f.checkFile(tempFile.Name()); err != nil {
+		return notFoundError(err.Error())
+	}
+
+	return nil
+}
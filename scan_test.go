@@ -0,0 +1,143 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCacheScannerScan(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestCacheScannerScan")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	rc := &RetentionCacher{Cacher: DirCacher(tempDir)}
+	g := &Goproxy{
+		Cacher:      rc.Cacher,
+		TempDir:     tempDir,
+		ErrorLogger: log.New(&discardWriter{}, "", 0),
+	}
+
+	infoTime := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	for name, content := range map[string]string{
+		"example.com/@v/v1.0.0.info":  marshalInfo("v1.0.0", infoTime),
+		"example.com/@v/v1.0.0.mod":   "module example.com",
+		"example.com/@v/v1.1.0.mod":   "invalid",
+		"sumdb/sum.golang.org/latest": "foobar",
+	} {
+		if err := g.Cacher.Put(
+			context.Background(),
+			name,
+			strings.NewReader(content),
+			time.Hour,
+		); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	g.Cacher = rc
+
+	var progresses []CacheScanProgress
+	cs := &CacheScanner{
+		Goproxy:     g,
+		Parallelism: 2,
+		OnProgress: func(p CacheScanProgress) {
+			progresses = append(progresses, p)
+		},
+	}
+
+	p, err := cs.Scan(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if got, want := p.Scanned, int64(4); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	if got, want := p.Invalid, int64(1); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	if !p.Complete {
+		t.Error("expected complete")
+	}
+
+	if got, want := len(progresses), 1; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	if got, want := progresses[0], p; got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	if _, err := g.Cacher.Get(
+		context.Background(),
+		"example.com/@v/v1.1.0.mod",
+	); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got error %q, want error %q", err, os.ErrNotExist)
+	}
+
+	if got, want := g.cachedNames.sample(10), []string{
+		"example.com/@v/v1.0.0.mod",
+	}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	invalidBytes := int64(len("invalid"))
+	if got, want := rc.TotalBytes(), p.ScannedBytes-invalidBytes; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	s := g.Stats()
+	if got, want := s.CachesScanned, int64(4); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	if got, want := s.InvalidCachesRemoved, int64(1); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	// A second scan finds nothing invalid and accounts nothing twice.
+	p, err = cs.Scan(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if got, want := p.Invalid, int64(0); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	if got, want := rc.TotalBytes(), p.ScannedBytes; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := cs.Scan(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %q, want error %q", err, context.Canceled)
+	}
+
+	cs.MaxDuration = time.Nanosecond
+	p, err = cs.Scan(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if p.Complete {
+		t.Error("expected incomplete")
+	}
+
+	g = &Goproxy{Cacher: errorCacher{}}
+	cs = &CacheScanner{Goproxy: g}
+	if _, err := cs.Scan(context.Background()); err != errWalkNotSupported {
+		t.Fatalf("got error %q, want error %q", err, errWalkNotSupported)
+	}
+}
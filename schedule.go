@@ -0,0 +1,191 @@
+package goproxy
+
+import (
+	"context"
+	"sync"
+)
+
+// fairScheduler limits the number of workers running at the same time, and
+// grants the freed slots to the keys (e.g. module paths) waiting for them in
+// a round-robin fashion, so that a single key with many pending works cannot
+// starve the others.
+//
+// Works are also classified by the [RequestPriority] of their contexts. The
+// freed slots are granted to the waiters of higher priorities first, and, if
+// there are at least two slots, the last one is kept for the
+// [RequestPriorityInteractive], so that it is never fully taken by lower
+// priorities.
+type fairScheduler struct {
+	max       int
+	maxPerKey int
+
+	mutex        sync.Mutex
+	running      int
+	runningByKey map[string]int
+	classes      [numRequestPriorities]fairQueue
+}
+
+// fairQueue is the queue of the waiters of a [fairScheduler] with the same
+// [RequestPriority].
+type fairQueue struct {
+	queues map[string][]*fairSchedulerWaiter
+	order  []string
+}
+
+// fairSchedulerWaiter is a waiter of a [fairScheduler].
+type fairSchedulerWaiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+// newFairScheduler returns a new instance of the [fairScheduler] that allows at
+// most max workers in total and at most maxPerKey workers for the same key. A
+// zero limit means no limit.
+func newFairScheduler(max, maxPerKey int) *fairScheduler {
+	fs := &fairScheduler{
+		max:          max,
+		maxPerKey:    maxPerKey,
+		runningByKey: map[string]int{},
+	}
+	for i := range fs.classes {
+		fs.classes[i].queues = map[string][]*fairSchedulerWaiter{}
+	}
+
+	return fs
+}
+
+// acquire waits for a worker slot for the key until the ctx is done. The slot
+// must be released via the [fairScheduler.release] once the work is done.
+func (fs *fairScheduler) acquire(ctx context.Context, key string) error {
+	rp := priorityOf(ctx)
+	fq := &fs.classes[rp]
+
+	fs.mutex.Lock()
+	if len(fq.queues[key]) == 0 && fs.available(key, rp) {
+		fs.grant(key)
+		fs.mutex.Unlock()
+		return nil
+	}
+
+	w := &fairSchedulerWaiter{ready: make(chan struct{})}
+	if len(fq.queues[key]) == 0 {
+		fq.order = append(fq.order, key)
+	}
+
+	fq.queues[key] = append(fq.queues[key], w)
+	fs.mutex.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	if w.granted {
+		fs.releaseLocked(key)
+		return ctx.Err()
+	}
+
+	queue := fq.queues[key]
+	for i := range queue {
+		if queue[i] == w {
+			queue = append(queue[:i], queue[i+1:]...)
+			break
+		}
+	}
+
+	if len(queue) > 0 {
+		fq.queues[key] = queue
+	} else {
+		fq.dequeueKey(key)
+	}
+
+	return ctx.Err()
+}
+
+// release releases a worker slot acquired for the key.
+func (fs *fairScheduler) release(key string) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	fs.releaseLocked(key)
+}
+
+// releaseLocked is like the [fairScheduler.release], but must be called with
+// the fs.mutex held.
+func (fs *fairScheduler) releaseLocked(key string) {
+	fs.running--
+	if fs.runningByKey[key]--; fs.runningByKey[key] == 0 {
+		delete(fs.runningByKey, key)
+	}
+
+	fs.dispatch()
+}
+
+// dispatch grants the available worker slots to the waiting keys in turn,
+// from the highest priority to the lowest. It must be called with the
+// fs.mutex held.
+func (fs *fairScheduler) dispatch() {
+	for rp := range fs.classes {
+		fq := &fs.classes[rp]
+		for i := 0; i < len(fq.order); {
+			if fs.max > 0 && fs.running >= fs.max {
+				return
+			}
+
+			key := fq.order[i]
+			if !fs.available(key, RequestPriority(rp)) {
+				i++
+				continue
+			}
+
+			queue := fq.queues[key]
+			w := queue[0]
+			if len(queue) > 1 {
+				fq.queues[key] = queue[1:]
+
+				// Move the key to the end of the turn.
+				order := append(fq.order[:i], fq.order[i+1:]...)
+				fq.order = append(order, key)
+			} else {
+				fq.dequeueKey(key)
+			}
+
+			fs.grant(key)
+			w.granted = true
+			close(w.ready)
+		}
+	}
+}
+
+// available reports whether a worker slot is available for the key with the
+// rp. It must be called with the fs.mutex held.
+func (fs *fairScheduler) available(key string, rp RequestPriority) bool {
+	max := fs.max
+	if rp != RequestPriorityInteractive && max > 1 {
+		max--
+	}
+
+	return (max <= 0 || fs.running < max) &&
+		(fs.maxPerKey <= 0 || fs.runningByKey[key] < fs.maxPerKey)
+}
+
+// grant grants a worker slot to the key. It must be called with the fs.mutex
+// held.
+func (fs *fairScheduler) grant(key string) {
+	fs.running++
+	fs.runningByKey[key]++
+}
+
+// dequeueKey removes the key from the waiting keys of the fq. It must be called
+// with the mutex of the [fairScheduler] held.
+func (fq *fairQueue) dequeueKey(key string) {
+	delete(fq.queues, key)
+	for i, k := range fq.order {
+		if k == key {
+			fq.order = append(fq.order[:i], fq.order[i+1:]...)
+			break
+		}
+	}
+}
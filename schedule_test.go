@@ -0,0 +1,166 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFairScheduler(t *testing.T) {
+	fs := newFairScheduler(2, 0)
+	for i := 0; i < 2; i++ {
+		if err := fs.acquire(context.Background(), "a"); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	granted := make(chan string)
+	wait := func(key string) {
+		if err := fs.acquire(context.Background(), key); err != nil {
+			t.Errorf("unexpected error %q", err)
+			return
+		}
+
+		granted <- key
+	}
+
+	// Queue 3 works for "a" before a single work for "b".
+	for _, key := range []string{"a", "a", "a", "b"} {
+		go wait(key)
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Each release of a slot held by "a" is granted to the next key in
+	// turn.
+	var order []string
+	for i := 0; i < 4; i++ {
+		fs.release("a")
+		order = append(order, <-granted)
+	}
+
+	if got, want := strings.Join(order, ","), "a,b,a,a"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFairSchedulerMaxPerKey(t *testing.T) {
+	fs := newFairScheduler(0, 1)
+	if err := fs.acquire(context.Background(), "a"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if err := fs.acquire(context.Background(), "b"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	ctx, cancel := context.WithTimeout(
+		context.Background(),
+		10*time.Millisecond,
+	)
+	defer cancel()
+	if err := fs.acquire(
+		ctx,
+		"a",
+	); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf(
+			"got error %q, want error %q",
+			err,
+			context.DeadlineExceeded,
+		)
+	}
+
+	if got, want := len(
+		fs.classes[RequestPriorityInteractive].order,
+	), 0; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- fs.acquire(context.Background(), "a")
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	fs.release("b")
+	select {
+	case err := <-done:
+		t.Fatalf("unexpected acquisition: %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	fs.release("a")
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	fs.release("a")
+	if got, want := fs.running, 0; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
+
+func TestFairSchedulerPriorities(t *testing.T) {
+	fs := newFairScheduler(2, 0)
+	background := withPriority(
+		context.Background(),
+		RequestPriorityBackground,
+	)
+	if err := fs.acquire(background, "a"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	// The last slot is kept for interactive works.
+	ctx, cancel := context.WithTimeout(background, 10*time.Millisecond)
+	defer cancel()
+	if err := fs.acquire(
+		ctx,
+		"b",
+	); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf(
+			"got error %q, want error %q",
+			err,
+			context.DeadlineExceeded,
+		)
+	}
+
+	if err := fs.acquire(context.Background(), "c"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	granted := make(chan string)
+	wait := func(ctx context.Context, key string) {
+		if err := fs.acquire(ctx, key); err != nil {
+			t.Errorf("unexpected error %q", err)
+			return
+		}
+
+		granted <- key
+	}
+
+	// Queue a background work and a batch work before an interactive
+	// one.
+	go wait(background, "d")
+	time.Sleep(10 * time.Millisecond)
+	go wait(withPriority(context.Background(), RequestPriorityBatch), "e")
+	time.Sleep(10 * time.Millisecond)
+	go wait(context.Background(), "f")
+	time.Sleep(10 * time.Millisecond)
+
+	fs.release("c")
+	if got, want := <-granted, "f"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	fs.release("a")
+	fs.release("f")
+	if got, want := <-granted, "e"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	fs.release("e")
+	if got, want := <-granted, "d"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
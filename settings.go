@@ -0,0 +1,235 @@
+package goproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// maxSettingsBytes is the maximum number of bytes of the body of a settings
+// update request.
+const maxSettingsBytes = 1 << 20
+
+// Settings are the settings of a [Goproxy] that can be changed while it is
+// serving requests, see the [Goproxy.UpdateSettings]. Each of them mirrors the
+// [Goproxy] field of the same name, from which it is initialized and which it
+// overrides once updated.
+//
+// In JSON, the durations are numbers of nanoseconds.
+type Settings struct {
+	// ListCacheExpiration mirrors the [Goproxy.ListCacheExpiration].
+	ListCacheExpiration time.Duration
+
+	// LatestCacheExpiration mirrors the [Goproxy.LatestCacheExpiration].
+	LatestCacheExpiration time.Duration
+
+	// ImmutableCacheExpiration mirrors the
+	// [Goproxy.ImmutableCacheExpiration].
+	ImmutableCacheExpiration time.Duration
+
+	// PseudoVersionCacheExpiration mirrors the
+	// [Goproxy.PseudoVersionCacheExpiration].
+	PseudoVersionCacheExpiration time.Duration
+
+	// NotFoundCacheExpiration mirrors the
+	// [Goproxy.NotFoundCacheExpiration].
+	NotFoundCacheExpiration time.Duration
+
+	// StaleIfErrorExpiration mirrors the
+	// [Goproxy.StaleIfErrorExpiration].
+	StaleIfErrorExpiration time.Duration
+
+	// StaleWhileRevalidate mirrors the [Goproxy.StaleWhileRevalidate].
+	StaleWhileRevalidate bool
+
+	// EmergencyStale mirrors the [Goproxy.EmergencyStale].
+	EmergencyStale bool
+
+	// EmergencyStaleThreshold mirrors the
+	// [Goproxy.EmergencyStaleThreshold].
+	EmergencyStaleThreshold int
+
+	// ProxiedOnly mirrors the [Goproxy.ProxiedOnly].
+	ProxiedOnly bool
+
+	// CorrectInfoTimes mirrors the [Goproxy.CorrectInfoTimes].
+	CorrectInfoTimes bool
+
+	// MergeLists mirrors the [Goproxy.MergeLists].
+	MergeLists bool
+
+	// UpstreamCacheHeaders mirrors the [Goproxy.UpstreamCacheHeaders].
+	UpstreamCacheHeaders bool
+
+	// CacherMaxCacheBytes mirrors the [Goproxy.CacherMaxCacheBytes].
+	CacherMaxCacheBytes int
+
+	// CacherVerifySizes mirrors the [Goproxy.CacherVerifySizes].
+	CacherVerifySizes bool
+
+	// CacherClockSkew mirrors the [Goproxy.CacherClockSkew].
+	CacherClockSkew time.Duration
+
+	// BlockedModuleHosts mirrors the [Goproxy.BlockedModuleHosts].
+	BlockedModuleHosts []string
+
+	// PrivateModules mirrors the [Goproxy.PrivateModules].
+	PrivateModules string
+
+	// RequestTimeout mirrors the [Goproxy.RequestTimeout].
+	RequestTimeout time.Duration
+
+	// ZipRequestTimeout mirrors the [Goproxy.ZipRequestTimeout].
+	ZipRequestTimeout time.Duration
+
+	// FetchTimeout mirrors the [Goproxy.FetchTimeout].
+	FetchTimeout time.Duration
+
+	// ZipFetchTimeout mirrors the [Goproxy.ZipFetchTimeout].
+	ZipFetchTimeout time.Duration
+}
+
+// validate checks whether the s is valid.
+func (s *Settings) validate() error {
+	for name, expiration := range map[string]time.Duration{
+		"ListCacheExpiration":          s.ListCacheExpiration,
+		"LatestCacheExpiration":        s.LatestCacheExpiration,
+		"PseudoVersionCacheExpiration": s.PseudoVersionCacheExpiration,
+		"NotFoundCacheExpiration":      s.NotFoundCacheExpiration,
+		"StaleIfErrorExpiration":       s.StaleIfErrorExpiration,
+	} {
+		if expiration < 0 {
+			return fmt.Errorf("negative %s", name)
+		}
+	}
+
+	if s.EmergencyStaleThreshold < 0 {
+		return errors.New("negative EmergencyStaleThreshold")
+	}
+
+	if s.CacherMaxCacheBytes < 0 {
+		return errors.New("negative CacherMaxCacheBytes")
+	}
+
+	if s.CacherClockSkew < 0 {
+		return errors.New("negative CacherClockSkew")
+	}
+
+	if s.RequestTimeout < 0 {
+		return errors.New("negative RequestTimeout")
+	}
+
+	if s.ZipRequestTimeout < 0 {
+		return errors.New("negative ZipRequestTimeout")
+	}
+
+	if s.FetchTimeout < 0 {
+		return errors.New("negative FetchTimeout")
+	}
+
+	if s.ZipFetchTimeout < 0 {
+		return errors.New("negative ZipFetchTimeout")
+	}
+
+	return nil
+}
+
+// clone returns a deep copy of the s.
+func (s *Settings) clone() *Settings {
+	c := *s
+	if s.BlockedModuleHosts != nil {
+		c.BlockedModuleHosts = append(
+			[]string{},
+			s.BlockedModuleHosts...,
+		)
+	}
+
+	return &c
+}
+
+// Settings returns a copy of the current [Settings] of the g.
+func (g *Goproxy) Settings() Settings {
+	return *g.settings().clone()
+}
+
+// UpdateSettings updates the [Settings] of the g by calling the fn with a copy
+// of the current ones and, unless it returns an error, atomically replacing
+// them with it. Requests in flight keep using the settings they started with.
+// It is safe to call concurrently with serving requests and with itself.
+func (g *Goproxy) UpdateSettings(fn func(s *Settings) error) error {
+	g.initOnce.Do(g.init)
+	g.settingsMutex.Lock()
+	defer g.settingsMutex.Unlock()
+
+	s := g.settings().clone()
+	if err := fn(s); err != nil {
+		return err
+	} else if err := s.validate(); err != nil {
+		return fmt.Errorf("invalid settings: %w", err)
+	}
+
+	g.settingsValue.Store(s)
+
+	return nil
+}
+
+// settings returns the current [Settings] of the g, which must not be
+// modified. Until the g is initialized, they are taken from its fields.
+func (g *Goproxy) settings() *Settings {
+	if s, ok := g.settingsValue.Load().(*Settings); ok {
+		return s
+	}
+
+	return &Settings{
+		ListCacheExpiration:          g.ListCacheExpiration,
+		LatestCacheExpiration:        g.LatestCacheExpiration,
+		ImmutableCacheExpiration:     g.ImmutableCacheExpiration,
+		PseudoVersionCacheExpiration: g.PseudoVersionCacheExpiration,
+		NotFoundCacheExpiration:      g.NotFoundCacheExpiration,
+		StaleIfErrorExpiration:       g.StaleIfErrorExpiration,
+		StaleWhileRevalidate:         g.StaleWhileRevalidate,
+		EmergencyStale:               g.EmergencyStale,
+		EmergencyStaleThreshold:      g.EmergencyStaleThreshold,
+		ProxiedOnly:                  g.ProxiedOnly,
+		CorrectInfoTimes:             g.CorrectInfoTimes,
+		MergeLists:                   g.MergeLists,
+		UpstreamCacheHeaders:         g.UpstreamCacheHeaders,
+		CacherMaxCacheBytes:          g.CacherMaxCacheBytes,
+		CacherVerifySizes:            g.CacherVerifySizes,
+		CacherClockSkew:              g.CacherClockSkew,
+		BlockedModuleHosts:           g.BlockedModuleHosts,
+		PrivateModules:               g.PrivateModules,
+		RequestTimeout:               g.RequestTimeout,
+		ZipRequestTimeout:            g.ZipRequestTimeout,
+		FetchTimeout:                 g.FetchTimeout,
+		ZipFetchTimeout:              g.ZipFetchTimeout,
+	}
+}
+
+// serveSettings serves settings requests. A PATCH request updates the settings
+// with the JSON object in its body, leaving the settings absent from it as they
+// are, and responses with the updated ones.
+func (g *Goproxy) serveSettings(rw http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodPatch {
+		if err := g.UpdateSettings(func(s *Settings) error {
+			return json.NewDecoder(http.MaxBytesReader(
+				rw,
+				req.Body,
+				maxSettingsBytes,
+			)).Decode(s)
+		}); err != nil {
+			responseString(
+				rw,
+				req,
+				http.StatusBadRequest,
+				-2,
+				fmt.Sprint("invalid settings update: ", err),
+			)
+			return
+		}
+	}
+
+	responseJSON(rw, req, -2, g.Settings())
+}
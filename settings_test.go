@@ -0,0 +1,161 @@
+package goproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGoproxyUpdateSettings(t *testing.T) {
+	g := &Goproxy{
+		ImmutableCacheExpiration: time.Hour,
+		BlockedModuleHosts:       []string{"example.com"},
+	}
+
+	if got, want := g.versionCacheExpiration(
+		time.Minute,
+		"v1.0.0",
+	), time.Hour; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				g.versionCacheExpiration(time.Minute, "v1.0.0")
+				g.isPrivateModule("example.com")
+			}
+		}()
+	}
+
+	for i := 0; i < 100; i++ {
+		if err := g.UpdateSettings(func(s *Settings) error {
+			s.ImmutableCacheExpiration = 2 * time.Hour
+			s.PrivateModules = "example.com"
+			return nil
+		}); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	wg.Wait()
+
+	if got, want := g.versionCacheExpiration(
+		time.Minute,
+		"v1.0.0",
+	), 2*time.Hour; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	} else if !g.isPrivateModule("example.com") {
+		t.Error("expected private module")
+	} else if got, want := g.ImmutableCacheExpiration,
+		time.Hour; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	s := g.Settings()
+	s.BlockedModuleHosts[0] = "example.org"
+	if got, want := g.Settings().BlockedModuleHosts[0],
+		"example.com"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	wantErr := errors.New("update failed")
+	if err := g.UpdateSettings(func(s *Settings) error {
+		s.ProxiedOnly = true
+		return wantErr
+	}); !errors.Is(err, wantErr) {
+		t.Fatalf("got error %q, want error %q", err, wantErr)
+	} else if g.Settings().ProxiedOnly {
+		t.Error("unexpected update")
+	}
+
+	if err := g.UpdateSettings(func(s *Settings) error {
+		s.CacherMaxCacheBytes = -1
+		return nil
+	}); err == nil {
+		t.Fatal("expected error")
+	} else if got, want := g.Settings().CacherMaxCacheBytes,
+		0; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
+
+func TestGoproxyServeSettings(t *testing.T) {
+	g := &Goproxy{
+		ListCacheExpiration: time.Minute,
+		AdminAuthorizer:     func(*http.Request) bool { return true },
+	}
+
+	for n, tt := range []struct {
+		method   string
+		body     string
+		wantCode int
+		wantList time.Duration
+		wantOnly bool
+	}{
+		{http.MethodGet, "", http.StatusOK, time.Minute, false},
+		{
+			http.MethodPatch,
+			`{"ProxiedOnly":true}`,
+			http.StatusOK,
+			time.Minute,
+			true,
+		},
+		{
+			http.MethodPatch,
+			`{"ListCacheExpiration":5000000000}`,
+			http.StatusOK,
+			5 * time.Second,
+			true,
+		},
+		{
+			http.MethodPatch,
+			`{"ListCacheExpiration":-1}`,
+			http.StatusBadRequest,
+			5 * time.Second,
+			true,
+		},
+		{
+			http.MethodPatch,
+			`{"ProxiedOnly":`,
+			http.StatusBadRequest,
+			5 * time.Second,
+			true,
+		},
+		{http.MethodPost, "{}", http.StatusMethodNotAllowed, 0, false},
+	} {
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest(
+			tt.method,
+			"/-/settings",
+			strings.NewReader(tt.body),
+		))
+		if got, want := rec.Code, tt.wantCode; got != want {
+			t.Fatalf("test(%d): got %d, want %d", n, got, want)
+		} else if rec.Code == http.StatusOK && !json.Valid(
+			rec.Body.Bytes(),
+		) {
+			t.Errorf("test(%d): got %q, want JSON", n, rec.Body)
+		}
+
+		if tt.wantCode == http.StatusMethodNotAllowed {
+			continue
+		}
+
+		s := g.Settings()
+		if got, want := s.ListCacheExpiration,
+			tt.wantList; got != want {
+			t.Errorf("test(%d): got %s, want %s", n, got, want)
+		} else if got, want := s.ProxiedOnly, tt.wantOnly; got != want {
+			t.Errorf("test(%d): got %t, want %t", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package goproxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// defaultHashRingVirtualNodes is the default value of the
+	// [HashRing.VirtualNodes].
+	defaultHashRingVirtualNodes = 160
+
+	// shardForwardedHeader is the name of the request header that marks
+	// the requests forwarded by a [ShardRouter], so that they are never
+	// forwarded again.
+	shardForwardedHeader = "Goproxy-Shard-Forwarded"
+)
+
+// HashRing is a consistent hashing ring that maps keys (e.g. module paths) to
+// nodes (e.g. base URLs of module proxy instances). Adding or removing a node
+// only remaps the keys of that node, so that the caches of the other nodes
+// stay warm.
+//
+// Make sure that all fields of the HashRing have been finalized before calling
+// any of its methods.
+type HashRing struct {
+	// Nodes is the list of nodes of the HashRing. Its order does not
+	// matter, and duplicates are ignored.
+	Nodes []string
+
+	// VirtualNodes is the number of points each node is placed at on the
+	// HashRing. More points spread the keys more evenly.
+	//
+	// If the VirtualNodes is zero, 160 is used.
+	VirtualNodes int
+
+	initOnce sync.Once
+	points   []uint64
+	nodes    map[uint64]string
+}
+
+// init initializes the hr.
+func (hr *HashRing) init() {
+	virtualNodes := hr.VirtualNodes
+	if virtualNodes <= 0 {
+		virtualNodes = defaultHashRingVirtualNodes
+	}
+
+	hr.nodes = map[uint64]string{}
+	for _, node := range hr.Nodes {
+		for i := 0; i < virtualNodes; i++ {
+			point := hashRingHash(fmt.Sprint(node, "#", i))
+			if n, ok := hr.nodes[point]; ok && n <= node {
+				continue // Keep collisions independent of order
+			}
+
+			hr.nodes[point] = node
+		}
+	}
+
+	hr.points = make([]uint64, 0, len(hr.nodes))
+	for point := range hr.nodes {
+		hr.points = append(hr.points, point)
+	}
+
+	sort.Slice(hr.points, func(i, j int) bool {
+		return hr.points[i] < hr.points[j]
+	})
+}
+
+// Node returns the node that the key maps to. It returns an empty string if
+// the hr has no nodes.
+func (hr *HashRing) Node(key string) string {
+	hr.initOnce.Do(hr.init)
+	if len(hr.points) == 0 {
+		return ""
+	}
+
+	h := hashRingHash(key)
+	i := sort.Search(len(hr.points), func(i int) bool {
+		return hr.points[i] >= h
+	})
+	if i == len(hr.points) {
+		i = 0
+	}
+
+	return hr.nodes[hr.points[i]]
+}
+
+// hashRingHash returns the position of the s on a [HashRing].
+func hashRingHash(s string) uint64 {
+	sum := sha256.Sum256([]byte(s))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// ShardRouter implements the [http.Handler] to make a fleet of module proxy
+// instances behave like one large sharded cache. Each module path is owned by
+// one of the shards, chosen by consistent hashing (see the [HashRing]), and
+// requests for it are forwarded to its owner. Everything else (e.g. checksum
+// database and administrative requests) is served locally.
+//
+// Every instance of the fleet should be configured with the same shards, so
+// that any of them can be sent any request (e.g. by a plain load balancer).
+//
+// Make sure that all fields of the ShardRouter have been finalized before
+// calling any of its methods.
+type ShardRouter struct {
+	// Goproxy is the [Goproxy] that serves the requests owned by the
+	// [ShardRouter.Self], the requests that do not target a module, and
+	// the requests that fail to be forwarded.
+	//
+	// If the Goproxy is nil, those requests are responded with a 404 Not
+	// Found or a 502 Bad Gateway.
+	Goproxy *Goproxy
+
+	// Shards is the list of base URLs (e.g. "http://10.0.0.2:8080") of
+	// all instances of the fleet, which are expected to be configured with
+	// the same [Goproxy.PathPrefix] as the Goproxy.
+	Shards []string
+
+	// Self is the base URL of the local instance in the Shards.
+	//
+	// If the Self is empty, the ShardRouter acts as a pure router that
+	// forwards all module requests.
+	Self string
+
+	// VirtualNodes is the [HashRing.VirtualNodes] of the shards.
+	VirtualNodes int
+
+	// Transport is used to forward requests to other shards.
+	//
+	// If the Transport is nil, the [http.DefaultTransport] is used.
+	Transport http.RoundTripper
+
+	initOnce sync.Once
+	ring     *HashRing
+	proxies  map[string]*httputil.ReverseProxy
+}
+
+// init initializes the sr.
+func (sr *ShardRouter) init() {
+	sr.ring = &HashRing{VirtualNodes: sr.VirtualNodes}
+	sr.proxies = map[string]*httputil.ReverseProxy{}
+	for _, shard := range sr.Shards {
+		shard = strings.TrimSuffix(shard, "/")
+		if shard == "" {
+			continue
+		}
+
+		sr.ring.Nodes = append(sr.ring.Nodes, shard)
+		if shard == strings.TrimSuffix(sr.Self, "/") {
+			continue
+		}
+
+		shardURL, err := url.Parse(shard)
+		if err != nil {
+			continue
+		}
+
+		proxy := httputil.NewSingleHostReverseProxy(shardURL)
+		director := proxy.Director
+		proxy.Director = func(req *http.Request) {
+			director(req)
+			req.Header.Set(shardForwardedHeader, "1")
+		}
+		proxy.Transport = sr.Transport
+		proxy.ErrorHandler = sr.serveForwardError
+		sr.proxies[shard] = proxy
+	}
+}
+
+// Shard returns the base URL of the shard that owns the modulePath. It returns
+// an empty string if there are no shards.
+func (sr *ShardRouter) Shard(modulePath string) string {
+	sr.initOnce.Do(sr.init)
+	return sr.ring.Node(modulePath)
+}
+
+// ServeHTTP implements the [http.Handler].
+func (sr *ShardRouter) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	sr.initOnce.Do(sr.init)
+	if req.Header.Get(shardForwardedHeader) == "" {
+		if modulePath, ok := sr.requestModulePath(req); ok {
+			shard := sr.ring.Node(modulePath)
+			if proxy, ok := sr.proxies[shard]; ok {
+				ctx := context.WithValue(
+					req.Context(),
+					shardRequestContextKey{},
+					req,
+				)
+				proxy.ServeHTTP(rw, req.WithContext(ctx))
+				return
+			}
+		}
+	}
+
+	sr.serveLocally(rw, req)
+}
+
+// requestModulePath returns the module path targeted by the req. It returns
+// false if the req does not target a module.
+func (sr *ShardRouter) requestModulePath(req *http.Request) (string, bool) {
+	name, err := url.PathUnescape(req.URL.Path)
+	if err != nil || name == "" || name[0] != '/' {
+		return "", false
+	}
+
+	name = path.Clean(name)
+	if sr.Goproxy != nil && sr.Goproxy.PathPrefix != "" {
+		if !strings.HasPrefix(name, sr.Goproxy.PathPrefix) {
+			return "", false
+		}
+
+		name = strings.TrimPrefix(name, sr.Goproxy.PathPrefix)
+	}
+
+	return requestModulePath(name)
+}
+
+// serveLocally serves the req with the [ShardRouter.Goproxy].
+func (sr *ShardRouter) serveLocally(rw http.ResponseWriter, req *http.Request) {
+	if sr.Goproxy == nil {
+		responseNotFound(rw, req, -2, "no local shard")
+		return
+	}
+
+	sr.Goproxy.ServeHTTP(rw, req)
+}
+
+// shardRequestContextKey is the context key of the original request forwarded
+// by a [ShardRouter].
+type shardRequestContextKey struct{}
+
+// serveForwardError serves the original request of the req (the forwarded one)
+// whose forwarding has failed with the err locally, so that an unavailable
+// shard does not make its modules unavailable.
+func (sr *ShardRouter) serveForwardError(
+	rw http.ResponseWriter,
+	req *http.Request,
+	err error,
+) {
+	if origReq, ok := req.Context().Value(
+		shardRequestContextKey{},
+	).(*http.Request); ok {
+		req = origReq
+	}
+
+	if sr.Goproxy == nil {
+		responseString(rw, req, http.StatusBadGateway, -2, "bad shard")
+		return
+	}
+
+	sr.Goproxy.logRequestErrorf(
+		req,
+		"failed to forward request to shard: %v",
+		err,
+	)
+	sr.Goproxy.ServeHTTP(rw, req)
+}
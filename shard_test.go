@@ -0,0 +1,153 @@
+package goproxy
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHashRing(t *testing.T) {
+	if got, want := (&HashRing{}).Node("example.com"), ""; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	hr := &HashRing{Nodes: []string{"a", "b", "c"}}
+	hr2 := &HashRing{Nodes: []string{"c", "a", "b", "a"}}
+	hr3 := &HashRing{Nodes: []string{"a", "b"}}
+	counts := map[string]int{}
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprint("example.com/", i)
+		node := hr.Node(key)
+		counts[node]++
+		if got, want := hr2.Node(key), node; got != want {
+			t.Errorf("%s: got %q, want %q", key, got, want)
+		}
+
+		if node != "c" {
+			if got, want := hr3.Node(key), node; got != want {
+				t.Errorf("%s: got %q, want %q", key, got, want)
+			}
+		}
+	}
+
+	for _, node := range hr.Nodes {
+		if counts[node] < 600 {
+			t.Errorf("%s: got %d keys, want >= 600", node, counts[node])
+		}
+	}
+}
+
+func TestShardRouter(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestShardRouter")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	remote := httptest.NewServer(http.HandlerFunc(func(
+		rw http.ResponseWriter,
+		req *http.Request,
+	) {
+		fmt.Fprint(
+			rw,
+			"remote ",
+			req.URL.Path,
+			" ",
+			req.Header.Get(shardForwardedHeader),
+		)
+	}))
+	defer remote.Close()
+
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	g := &Goproxy{
+		GoBinEnv:    []string{"GOPROXY=off"},
+		Cacher:      DirCacher(tempDir),
+		TempDir:     tempDir,
+		ErrorLogger: log.New(&discardWriter{}, "", 0),
+	}
+	sr := &ShardRouter{
+		Goproxy: g,
+		Shards:  []string{"http://self", remote.URL + "/", dead.URL},
+		Self:    "http://self/",
+	}
+
+	owned := map[string]string{}
+	for i := 0; len(owned) < 3 && i < 1000; i++ {
+		modulePath := fmt.Sprint("example.com/m", i)
+		shard := sr.Shard(modulePath)
+		if _, ok := owned[shard]; !ok {
+			owned[shard] = modulePath
+		}
+	}
+
+	if got, want := len(owned), 3; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	for _, modulePath := range owned {
+		if err := g.Cacher.Put(
+			context.Background(),
+			modulePath+"/@v/v1.0.0.mod",
+			strings.NewReader("local"),
+			time.Hour,
+		); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	for _, tt := range []struct {
+		modulePath string
+		forwarded  bool
+		wantBody   string
+	}{
+		{owned["http://self"], false, "local"},
+		{
+			owned[remote.URL],
+			false,
+			"remote /" + owned[remote.URL] + "/@v/v1.0.0.mod 1",
+		},
+		{owned[remote.URL], true, "local"},
+		{owned[dead.URL], false, "local"},
+	} {
+		target := "/" + tt.modulePath + "/@v/v1.0.0.mod"
+		req := httptest.NewRequest("", target, nil)
+		if tt.forwarded {
+			req.Header.Set(shardForwardedHeader, "1")
+		}
+
+		rec := httptest.NewRecorder()
+		sr.ServeHTTP(rec, req)
+		if got, want := rec.Code, http.StatusOK; got != want {
+			t.Fatalf("%s: got %d, want %d", target, got, want)
+		}
+
+		if got, want := rec.Body.String(), tt.wantBody; got != want {
+			t.Errorf("%s: got %q, want %q", target, got, want)
+		}
+	}
+
+	sr = &ShardRouter{Shards: []string{remote.URL}}
+	for _, tt := range []struct {
+		target   string
+		wantCode int
+	}{
+		{"/example.com/@v/list", http.StatusOK},
+		{"/sumdb/sum.golang.org/supported", http.StatusNotFound},
+	} {
+		req := httptest.NewRequest("", tt.target, nil)
+		rec := httptest.NewRecorder()
+		sr.ServeHTTP(rec, req)
+		if got, want := rec.Code, tt.wantCode; got != want {
+			t.Errorf("%s: got %d, want %d", tt.target, got, want)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package goproxy
+
+import (
+	"context"
+	"sync"
+)
+
+// fetchGroup deduplicates concurrent fetches of the same module file, so that
+// only one of them talks to upstreams (or runs the Go binary) while the others
+// wait for it and share its result.
+type fetchGroup struct {
+	mutex sync.Mutex
+	calls map[string]*fetchCall
+}
+
+// fetchCall is a fetch in progress or completed in a [fetchGroup].
+type fetchCall struct {
+	done   chan struct{}
+	fr     *fetchResult
+	err    error
+	ctxErr error
+}
+
+// do calls the fn for the fetch of the name, unless such a call is already in
+// progress, in which case it waits for that call and returns its result
+// instead. The shared reports whether the result is of another call.
+//
+// Since the fn runs with the context of the request that called it first, a
+// waiter whose shared call failed after that context was done (e.g. because
+// the request has gone away) calls the fn again by itself, as long as its own
+// ctx is not done. Other failures, including the fetches timing out by
+// themselves, are shared as usual. Waiting is stopped once the ctx is done.
+func (fg *fetchGroup) do(
+	ctx context.Context,
+	name string,
+	fn func() (*fetchResult, error),
+) (fr *fetchResult, shared bool, err error) {
+	for {
+		fg.mutex.Lock()
+		if fg.calls == nil {
+			fg.calls = map[string]*fetchCall{}
+		}
+
+		c, ok := fg.calls[name]
+		if !ok {
+			c = &fetchCall{done: make(chan struct{})}
+			fg.calls[name] = c
+			fg.mutex.Unlock()
+
+			defer func() {
+				fg.mutex.Lock()
+				delete(fg.calls, name)
+				fg.mutex.Unlock()
+				close(c.done)
+			}()
+
+			c.fr, c.err = fn()
+			c.ctxErr = ctx.Err()
+
+			return c.fr, false, c.err
+		}
+		fg.mutex.Unlock()
+
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+
+		if c.err != nil && c.ctxErr != nil && ctx.Err() == nil {
+			continue
+		}
+
+		return c.fr, true, c.err
+	}
+}
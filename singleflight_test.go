@@ -0,0 +1,336 @@
+package goproxy
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFetchGroup(t *testing.T) {
+	var (
+		fg      fetchGroup
+		calls   int64
+		release = make(chan struct{})
+		wg      sync.WaitGroup
+		mutex   sync.Mutex
+		shareds int
+	)
+	want := &fetchResult{Version: "v1.0.0"}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fr, shared, err := fg.do(
+				context.Background(),
+				"example.com/@latest",
+				func() (*fetchResult, error) {
+					atomic.AddInt64(&calls, 1)
+					<-release
+					return want, nil
+				},
+			)
+			if err != nil {
+				t.Errorf("unexpected error %q", err)
+			} else if fr != want {
+				t.Errorf("got %v, want %v", fr, want)
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			if shared {
+				shareds++
+			}
+		}()
+	}
+
+	for atomic.LoadInt64(&calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if got, want := atomic.LoadInt64(&calls), int64(1); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	} else if got, want := shareds, 4; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	} else if got, want := len(fg.calls), 0; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	// Waiters call again by themselves if the shared call failed because
+	// its request has gone away or timed out.
+	for _, newLeaderCtx := range []func() (
+		context.Context,
+		context.CancelFunc,
+	){
+		func() (context.Context, context.CancelFunc) {
+			return context.WithCancel(context.Background())
+		},
+		func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(
+				context.Background(),
+				50*time.Millisecond,
+			)
+		},
+	} {
+		leaderCtx, cancel := newLeaderCtx()
+		started := make(chan struct{})
+		done := make(chan error)
+		go func() {
+			_, _, err := fg.do(
+				leaderCtx,
+				"example.com/@latest",
+				func() (*fetchResult, error) {
+					close(started)
+					time.Sleep(100 * time.Millisecond)
+					cancel()
+					return nil, leaderCtx.Err()
+				},
+			)
+			done <- err
+		}()
+
+		<-started
+		fr, shared, err := fg.do(
+			context.Background(),
+			"example.com/@latest",
+			func() (*fetchResult, error) { return want, nil },
+		)
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		} else if fr != want {
+			t.Errorf("got %v, want %v", fr, want)
+		} else if shared {
+			t.Error("unexpected shared")
+		}
+
+		if err := <-done; err == nil {
+			t.Error("expected error")
+		}
+	}
+
+	// Shared calls that timed out by themselves (e.g. due to the
+	// Goproxy.FetchTimeout) are not called again.
+	started := make(chan struct{})
+	go fg.do(
+		context.Background(),
+		"example.com/@latest",
+		func() (*fetchResult, error) {
+			close(started)
+			time.Sleep(50 * time.Millisecond)
+			return nil, context.DeadlineExceeded
+		},
+	)
+
+	<-started
+	fr, shared, err := fg.do(
+		context.Background(),
+		"example.com/@latest",
+		func() (*fetchResult, error) { return want, nil },
+	)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf(
+			"got error %q, want error %q",
+			err,
+			context.DeadlineExceeded,
+		)
+	} else if fr != nil {
+		t.Errorf("got %v, want nil", fr)
+	} else if !shared {
+		t.Error("expected shared")
+	}
+
+	// Waiting stops once the ctx is done.
+	release = make(chan struct{})
+	defer close(release)
+	go fg.do(
+		context.Background(),
+		"example.com/@v/list",
+		func() (*fetchResult, error) {
+			<-release
+			return nil, nil
+		},
+	)
+	for {
+		fg.mutex.Lock()
+		_, ok := fg.calls["example.com/@v/list"]
+		fg.mutex.Unlock()
+		if ok {
+			break
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := fg.do(
+		ctx,
+		"example.com/@v/list",
+		func() (*fetchResult, error) { return want, nil },
+	); !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %q, want error %q", err, context.Canceled)
+	}
+}
+
+func TestGoproxySharedFetches(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestGoproxySharedFetches")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	var zipBuf bytes.Buffer
+	zw := zip.NewWriter(&zipBuf)
+	w, err := zw.Create("example.com@v1.0.0/go.mod")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if _, err := w.Write([]byte("module example.com")); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if err := zw.Close(); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	var hits int64
+	server := httptest.NewServer(http.HandlerFunc(func(
+		rw http.ResponseWriter,
+		req *http.Request,
+	) {
+		if req.URL.Path == "/example.com/@v/v1.0.0.zip" {
+			atomic.AddInt64(&hits, 1)
+			time.Sleep(100 * time.Millisecond)
+			rw.Write(zipBuf.Bytes())
+			return
+		}
+
+		responseNotFound(rw, req, -2)
+	}))
+	defer server.Close()
+
+	g := &Goproxy{
+		GoBinEnv: []string{
+			"GOPROXY=" + server.URL,
+			"GOSUMDB=off",
+		},
+		Cacher:      DirCacher(tempDir),
+		TempDir:     tempDir,
+		ErrorLogger: log.New(&discardWriter{}, "", 0),
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			g.ServeHTTP(rec, httptest.NewRequest(
+				http.MethodGet,
+				"/example.com/@v/v1.0.0.zip",
+				nil,
+			))
+			b := rec.Body.Bytes()
+			if got, want := rec.Code, http.StatusOK; got != want {
+				t.Errorf("got %d, want %d", got, want)
+			} else if !bytes.Equal(b, zipBuf.Bytes()) {
+				t.Errorf("got %q, want %q", b, zipBuf.Bytes())
+			}
+		}()
+	}
+
+	wg.Wait()
+	if got, want := atomic.LoadInt64(&hits), int64(1); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
+
+func TestGoproxySharedFetchTimeouts(t *testing.T) {
+	tempDir, err := ioutil.TempDir(
+		"",
+		"goproxy.TestGoproxySharedFetchTimeouts",
+	)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	var hits int64
+	server := httptest.NewServer(http.HandlerFunc(func(
+		rw http.ResponseWriter,
+		req *http.Request,
+	) {
+		if req.URL.Path == "/example.com/@v/list" {
+			atomic.AddInt64(&hits, 1)
+			select {
+			case <-req.Context().Done():
+			case <-time.After(time.Second):
+			}
+
+			return
+		}
+
+		responseNotFound(rw, req, -2)
+	}))
+	defer server.Close()
+
+	g := &Goproxy{
+		GoBinEnv: []string{
+			"GOPROXY=" + server.URL,
+			"GOSUMDB=off",
+		},
+		Cacher:       DirCacher(tempDir),
+		TempDir:      tempDir,
+		FetchTimeout: 200 * time.Millisecond,
+		ErrorLogger:  log.New(&discardWriter{}, "", 0),
+	}
+
+	serve := func() {
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest(
+			http.MethodGet,
+			"/example.com/@v/list",
+			nil,
+		))
+		if got := rec.Code; got == http.StatusOK {
+			t.Errorf("got %d, want error", got)
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		serve()
+	}()
+
+	for atomic.LoadInt64(&hits) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serve()
+		}()
+	}
+
+	wg.Wait()
+	if got, want := atomic.LoadInt64(&hits), int64(1); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,35 @@
+package goproxy
+
+import (
+	"context"
+	"time"
+)
+
+// clockSkewContextKey is the context key of the clock skew tolerances.
+type clockSkewContextKey struct{}
+
+// withClockSkew returns a copy of the ctx carrying the skew, which is how far
+// the clocks of the machines that put caches may run behind the local one
+// (e.g. when sharing a network file system), for the expiration checks of the
+// cachers that store expiration times computed by the putting machines.
+func withClockSkew(ctx context.Context, skew time.Duration) context.Context {
+	if skew <= 0 {
+		return ctx
+	}
+
+	return context.WithValue(ctx, clockSkewContextKey{}, skew)
+}
+
+// clockSkewOf returns the clock skew tolerance carried by the ctx, which
+// defaults to zero.
+func clockSkewOf(ctx context.Context) time.Duration {
+	skew, _ := ctx.Value(clockSkewContextKey{}).(time.Duration)
+	return skew
+}
+
+// expiryNow returns the time that the expiration times of the caches got with
+// the ctx are checked against, which is the current time set back by the clock
+// skew tolerance carried by the ctx.
+func expiryNow(ctx context.Context) time.Time {
+	return time.Now().Add(-clockSkewOf(ctx))
+}
@@ -0,0 +1,94 @@
+package goproxy
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClockSkewOf(t *testing.T) {
+	for n, tt := range []struct {
+		skew time.Duration
+		want time.Duration
+	}{
+		{time.Minute, time.Minute},
+		{0, 0},
+		{-time.Minute, 0},
+	} {
+		ctx := withClockSkew(context.Background(), tt.skew)
+		if got, want := clockSkewOf(ctx), tt.want; got != want {
+			t.Errorf("test(%d): got %s, want %s", n, got, want)
+		}
+	}
+
+	if got, want := clockSkewOf(context.Background()),
+		time.Duration(0); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestDirCacherClockSkew(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestDirCacherClockSkew")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// A cache put by a machine whose clock runs 30 seconds behind with an
+	// expiration of 10 seconds looks expired 20 seconds ago.
+	dc := DirCacher(tempDir)
+	name := "example.com/@latest"
+	if err := dc.Put(
+		context.Background(),
+		name,
+		strings.NewReader("foobar"),
+		-20*time.Second,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if _, err := dc.Get(context.Background(), name); !os.IsNotExist(err) {
+		t.Fatalf("got error %q, want error %q", err, os.ErrNotExist)
+	}
+
+	g := &Goproxy{Cacher: dc, CacherClockSkew: time.Minute}
+	g.initOnce.Do(g.init)
+	content, err := g.cache(context.Background(), name)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	content.Close()
+
+	var reclaimed []string
+	if err := dc.CleanupWithOptions(
+		DirCacherCleanupOptions{ClockSkew: time.Minute},
+		func(name string, size int64) {
+			reclaimed = append(reclaimed, name)
+		},
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := len(reclaimed), 0; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	if err := dc.CleanupWithOptions(
+		DirCacherCleanupOptions{ClockSkew: 10 * time.Second},
+		func(name string, size int64) {
+			reclaimed = append(reclaimed, name)
+		},
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := strings.Join(reclaimed, " "), name; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if err := g.UpdateSettings(func(s *Settings) error {
+		s.CacherClockSkew = -time.Second
+		return nil
+	}); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,224 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// staleNamePrefix is the prefix of the names of the stale copies of the caches
+// of version lists and resolved versions (see the
+// [Goproxy.StaleIfErrorExpiration]).
+const staleNamePrefix = apiPathPrefix + "stale/"
+
+// staleWarning is the Warning response header value of the responses served
+// with stale copies.
+const staleWarning = `110 - "Response is Stale"`
+
+// isUpstreamFailure reports whether the err of fetching a module file means
+// that the upstream failed to answer, rather than that the module file does
+// not exist.
+func isUpstreamFailure(err error) bool {
+	if !errors.Is(err, errNotFound) {
+		return true
+	}
+
+	msg := err.Error()
+	return errors.Is(err, errBadUpstream) ||
+		errors.Is(err, errFetchTimedOut) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		strings.Contains(msg, errBadUpstream.Error()) ||
+		strings.Contains(msg, errFetchTimedOut.Error())
+}
+
+// putStale keeps a stale copy of the content of the cache for the name. The
+// content is left at an undefined offset.
+func (g *Goproxy) putStale(
+	ctx context.Context,
+	name string,
+	content io.ReadSeeker,
+) {
+	expiration := g.settings().StaleIfErrorExpiration
+	if expiration <= 0 {
+		return
+	}
+
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		g.logErrorf("failed to seek stale copy: %s: %v", name, err)
+		return
+	}
+
+	if err := g.putCache(
+		ctx,
+		staleNamePrefix+name,
+		content,
+		expiration,
+	); err != nil {
+		g.logErrorf("failed to cache stale copy: %s: %v", name, err)
+	}
+}
+
+// serveStale serves the req with the stale copy of the cache for the name if
+// the err of fetching it is an upstream failure. It reports whether the req has
+// been served.
+func (g *Goproxy) serveStale(
+	rw http.ResponseWriter,
+	req *http.Request,
+	name string,
+	contentType string,
+	err error,
+) bool {
+	if g.settings().StaleIfErrorExpiration <= 0 || !isUpstreamFailure(err) {
+		return false
+	}
+
+	if !g.serveStaleCopy(rw, req, name, contentType) {
+		return false
+	}
+
+	g.logRequestErrorf(
+		req,
+		"served stale copy after failing to fetch: %s: %v",
+		name,
+		err,
+	)
+
+	return true
+}
+
+// serveStaleCopy serves the req with the stale copy of the cache for the name.
+// It reports whether the stale copy exists.
+func (g *Goproxy) serveStaleCopy(
+	rw http.ResponseWriter,
+	req *http.Request,
+	name string,
+	contentType string,
+) bool {
+	content, err := g.cache(req.Context(), staleNamePrefix+name)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			g.logRequestErrorf(
+				req,
+				"failed to get stale copy: %s: %v",
+				name,
+				err,
+			)
+		}
+
+		return false
+	}
+	defer content.Close()
+
+	g.updateStats(func(s *Stats) { s.StaleServes++ })
+	rw.Header().Set("Warning", staleWarning)
+	responseSuccess(rw, req, content, contentType, -1)
+
+	return true
+}
+
+// serveStaleWhileRevalidate serves the req for the f from the cache if the
+// [Goproxy.StaleWhileRevalidate] is enabled or the g is in the emergency stale
+// mode. Once the cache has expired, the req is served with its stale copy while
+// the cache is refreshed with the expiration in the background. It reports
+// whether the req has been served.
+func (g *Goproxy) serveStaleWhileRevalidate(
+	rw http.ResponseWriter,
+	req *http.Request,
+	f *fetch,
+	expiration time.Duration,
+) bool {
+	s := g.settings()
+	if s.StaleIfErrorExpiration <= 0 ||
+		!s.StaleWhileRevalidate && !g.inEmergencyStale() {
+		return false
+	}
+
+	served := true
+	g.serveCache(rw, req, f.name, f.contentType, 60, func() {
+		if !g.serveStaleCopy(rw, req, f.name, f.contentType) {
+			served = false
+			return
+		}
+
+		g.revalidate(f.name, expiration)
+	})
+
+	return served
+}
+
+// revalidate refreshes the cache for the name with the expiration in the
+// background, unless it is already being refreshed or too many refreshes are
+// running.
+func (g *Goproxy) revalidate(name string, expiration time.Duration) {
+	if !g.revalidations.start(name) {
+		return
+	}
+
+	g.updateStats(func(s *Stats) { s.StaleRevalidations++ })
+	go func() {
+		defer g.revalidations.done(name)
+		if err := g.refresh(
+			withBulk(context.Background()),
+			name,
+			expiration,
+		); err != nil {
+			g.logErrorf("failed to revalidate: %s: %v", name, err)
+		}
+	}()
+}
+
+// refresh fetches the module file targeted by the name and puts it, along with
+// its stale copy, to the g.Cacher with the expiration.
+func (g *Goproxy) refresh(
+	ctx context.Context,
+	name string,
+	expiration time.Duration,
+) error {
+	tempDir, err := ioutil.TempDir(g.TempDir, "goproxy")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	f, err := newFetch(g, name, tempDir)
+	if err != nil {
+		return err
+	}
+
+	fr, err := f.do(ctx)
+	g.recordUpstreamResult(err)
+	if err != nil {
+		return err
+	}
+
+	content, err := fr.Open()
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	if fr.Expiration > 0 {
+		expiration = fr.Expiration
+	}
+
+	if err := g.putCache(ctx, name, content, expiration); err != nil {
+		return err
+	}
+
+	g.putStale(ctx, name, content)
+	if fr.upstreamHeaders != nil {
+		return g.putUpstreamHeaders(
+			ctx,
+			name,
+			fr.upstreamHeaders,
+			expiration,
+		)
+	}
+
+	return nil
+}
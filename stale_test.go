@@ -0,0 +1,262 @@
+package goproxy
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIsUpstreamFailure(t *testing.T) {
+	for n, tt := range []struct {
+		err  error
+		want bool
+	}{
+		{errors.New("connection refused"), true},
+		{errBadUpstream, true},
+		{notFoundError(errBadUpstream.Error()), true},
+		{notFoundError(errFetchTimedOut.Error()), true},
+		{notFoundError("not found"), false},
+		{goneError("gone"), false},
+	} {
+		if got, want := isUpstreamFailure(
+			tt.err,
+		), tt.want; got != want {
+			t.Errorf("test(%d): got %t, want %t", n, got, want)
+		}
+	}
+}
+
+func TestGoproxyStaleIfError(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestGoproxyStaleIfError")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	var (
+		statusMutex sync.Mutex
+		status      = http.StatusOK
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(
+		rw http.ResponseWriter,
+		req *http.Request,
+	) {
+		statusMutex.Lock()
+		code := status
+		statusMutex.Unlock()
+		if code != http.StatusOK {
+			rw.WriteHeader(code)
+			return
+		}
+
+		switch req.URL.Path {
+		case "/example.com/@v/list":
+			responseString(rw, req, http.StatusOK, 60, "v1.0.0\n")
+		case "/example.com/@latest":
+			responseString(
+				rw,
+				req,
+				http.StatusOK,
+				60,
+				`{"Version":"v1.0.0",`+
+					`"Time":"2000-01-01T00:00:00Z"}`,
+			)
+		default:
+			responseNotFound(rw, req, 60)
+		}
+	}))
+	defer server.Close()
+
+	g := &Goproxy{
+		Cacher: DirCacher(tempDir),
+		GoBinEnv: []string{
+			"GOPROXY=" + server.URL,
+			"GOSUMDB=off",
+		},
+		ListCacheExpiration:    time.Millisecond,
+		LatestCacheExpiration:  time.Millisecond,
+		StaleIfErrorExpiration: time.Hour,
+		ErrorLogger:            log.New(&discardWriter{}, "", 0),
+	}
+
+	serve := func(name string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest(
+			http.MethodGet,
+			"/"+name,
+			nil,
+		))
+		return rec
+	}
+
+	for _, name := range []string{
+		"example.com/@v/list",
+		"example.com/@latest",
+	} {
+		if got, want := serve(name).Code, http.StatusOK; got != want {
+			t.Fatalf("%s: got %d, want %d", name, got, want)
+		}
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	statusMutex.Lock()
+	status = http.StatusNotImplemented
+	statusMutex.Unlock()
+
+	for n, tt := range []struct {
+		name     string
+		wantBody string
+	}{
+		{"example.com/@v/list", "v1.0.0"},
+		{
+			"example.com/@latest",
+			`{"Version":"v1.0.0","Time":"2000-01-01T00:00:00Z"}`,
+		},
+	} {
+		rec := serve(tt.name)
+		if got, want := rec.Code, http.StatusOK; got != want {
+			t.Fatalf("test(%d): got %d, want %d", n, got, want)
+		} else if got, want := rec.Body.String(),
+			tt.wantBody; got != want {
+			t.Errorf("test(%d): got %q, want %q", n, got, want)
+		} else if got, want := rec.Header().Get("Warning"),
+			staleWarning; got != want {
+			t.Errorf("test(%d): got %q, want %q", n, got, want)
+		} else if got, want := rec.Header().Get("Cache-Control"),
+			"must-revalidate, no-cache, no-store"; got != want {
+			t.Errorf("test(%d): got %q, want %q", n, got, want)
+		}
+	}
+
+	if got, want := g.Stats().StaleServes, int64(2); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	// Not-found results from upstream are never answered with stale
+	// copies.
+	statusMutex.Lock()
+	status = http.StatusNotFound
+	statusMutex.Unlock()
+
+	if got, want := serve(
+		"example.com/@v/list",
+	).Code, http.StatusNotFound; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	statusMutex.Lock()
+	status = http.StatusNotImplemented
+	statusMutex.Unlock()
+
+	if err := g.UpdateSettings(func(s *Settings) error {
+		s.StaleIfErrorExpiration = 0
+		return nil
+	}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if rec := serve("example.com/@v/list"); rec.Code == http.StatusOK {
+		t.Errorf("got %d, want error", rec.Code)
+	}
+}
+
+func TestGoproxyStaleWhileRevalidate(t *testing.T) {
+	tempDir, err := ioutil.TempDir(
+		"",
+		"goproxy.TestGoproxyStaleWhileRevalidate",
+	)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	var (
+		listMutex sync.Mutex
+		list      = "v1.0.0"
+		requests  int
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(
+		rw http.ResponseWriter,
+		req *http.Request,
+	) {
+		listMutex.Lock()
+		requests++
+		body := list
+		listMutex.Unlock()
+		responseString(rw, req, http.StatusOK, 60, body)
+	}))
+	defer server.Close()
+
+	g := &Goproxy{
+		Cacher: DirCacher(tempDir),
+		GoBinEnv: []string{
+			"GOPROXY=" + server.URL,
+			"GOSUMDB=off",
+		},
+		ListCacheExpiration:    100 * time.Millisecond,
+		StaleIfErrorExpiration: time.Hour,
+		StaleWhileRevalidate:   true,
+		ErrorLogger:            log.New(&discardWriter{}, "", 0),
+	}
+
+	serve := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest(
+			http.MethodGet,
+			"/example.com/@v/list",
+			nil,
+		))
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		rec := serve()
+		if got, want := rec.Body.String(), "v1.0.0"; got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		} else if got := rec.Header().Get("Warning"); got != "" {
+			t.Errorf("got %q, want %q", got, "")
+		}
+	}
+
+	listMutex.Lock()
+	if got, want := requests, 1; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	list = "v1.0.0\nv1.1.0"
+	listMutex.Unlock()
+
+	time.Sleep(150 * time.Millisecond)
+
+	rec := serve()
+	if got, want := rec.Body.String(), "v1.0.0"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	} else if got, want := rec.Header().Get("Warning"),
+		staleWarning; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	for i := 0; i < 100; i++ {
+		if rec = serve(); rec.Body.String() == "v1.0.0\nv1.1.0" {
+			break
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if got, want := rec.Body.String(), "v1.0.0\nv1.1.0"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	} else if got := rec.Header().Get("Warning"); got != "" {
+		t.Errorf("got %q, want %q", got, "")
+	} else if got, want := g.Stats().StaleRevalidations,
+		int64(1); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
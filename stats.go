@@ -0,0 +1,255 @@
+package goproxy
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/module"
+)
+
+// Stats is the statistics of a [Goproxy] since it started, or since its
+// statistics were first persisted by a [StatsPersister].
+type Stats struct {
+	// CachesReclaimed is the number of caches removed by the
+	// [Goproxy.Cleanup].
+	CachesReclaimed int64
+
+	// BytesReclaimed is the number of bytes reclaimed by the
+	// [Goproxy.Cleanup].
+	BytesReclaimed int64
+
+	// PseudoVersionCachesReclaimed is the part of the
+	// [Stats.CachesReclaimed] for pseudo-versions.
+	PseudoVersionCachesReclaimed int64
+
+	// PseudoVersionBytesReclaimed is the part of the
+	// [Stats.BytesReclaimed] for pseudo-versions.
+	PseudoVersionBytesReclaimed int64
+
+	// ReadAheadsStarted is the number of read-aheads (see the
+	// [Goproxy.ReadAheadExts]) started.
+	ReadAheadsStarted int64
+
+	// ReadAheadsDropped is the number of read-aheads dropped because of
+	// the [Goproxy.MaxReadAheads] or because the same module files were
+	// already being read ahead.
+	ReadAheadsDropped int64
+
+	// ReadAheadsFailed is the number of read-aheads failed (after all
+	// attempts, see the [Goproxy.PrefetchMaxAttempts]).
+	ReadAheadsFailed int64
+
+	// ReadAheadsRetried is the number of retries of failed read-aheads.
+	ReadAheadsRetried int64
+
+	// CachesScanned is the number of caches scanned by the
+	// [CacheScanner]s.
+	CachesScanned int64
+
+	// BytesScanned is the number of bytes of the caches scanned by the
+	// [CacheScanner]s.
+	BytesScanned int64
+
+	// InvalidCachesRemoved is the number of invalid caches found and
+	// removed by the [CacheScanner]s.
+	InvalidCachesRemoved int64
+
+	// GoCommands is the number of invocations of the Go binary targeted by
+	// the [Goproxy.GoBinName]. The most recent ones can be inspected via
+	// the [Goproxy.RecentGoCommands].
+	GoCommands int64
+
+	// GoCommandsFailed is the number of failed invocations of the Go
+	// binary.
+	GoCommandsFailed int64
+
+	// GoCommandsDuration is the total wall time of the invocations of the
+	// Go binary.
+	GoCommandsDuration time.Duration
+
+	// GoCommandsMaxDuration is the maximum wall time of the invocations of
+	// the Go binary.
+	GoCommandsMaxDuration time.Duration
+
+	// UpstreamRequests is the number of requests sent to upstreams. The
+	// daily usages of each upstream can be inspected via the
+	// [Goproxy.UpstreamUsage].
+	UpstreamRequests int64
+
+	// UpstreamBytes is the number of bytes of the response bodies
+	// downloaded from upstreams.
+	UpstreamBytes int64
+
+	// PeerFetchHits is the number of module files missing from the cache
+	// that were fetched from the [Goproxy.Peers].
+	PeerFetchHits int64
+
+	// PeerFetchMisses is the number of module files missing from the cache
+	// that none of the [Goproxy.Peers] had.
+	PeerFetchMisses int64
+
+	// HotCacheHits is the number of cached files served from memory (see
+	// the [Goproxy.HotCacheMaxBytes]).
+	HotCacheHits int64
+
+	// HotCachePromotions is the number of cached files promoted to be
+	// served from memory.
+	HotCachePromotions int64
+
+	// HotCacheDemotions is the number of cached files demoted from being
+	// served from memory.
+	HotCacheDemotions int64
+
+	// SharedFetches is the number of fetches that waited for a concurrent
+	// fetch of the same module file and shared its result instead of
+	// fetching from upstreams again.
+	SharedFetches int64
+
+	// WarmFetches is the number of module files fetched from upstream by
+	// [Warmer]s.
+	WarmFetches int64
+
+	// BogusInfoTimes is the number of bogus times found in the info files
+	// and resolves fetched from upstream module proxies (see the
+	// [Goproxy.CorrectInfoTimes]).
+	BogusInfoTimes int64
+
+	// DoubleFetches is the number of module files fetched again from the
+	// [Goproxy.DoubleFetchProxy] to be compared.
+	DoubleFetches int64
+
+	// DoubleFetchMismatches is the number of module files whose double
+	// fetches did not match (see the [DoubleFetchMismatch]).
+	DoubleFetchMismatches int64
+
+	// DownloadCacheHits is the number of requests for module files
+	// (".info", ".mod" and ".zip") served from the cache.
+	DownloadCacheHits int64
+
+	// DownloadCacheMisses is the number of requests for module files that
+	// were not cached. The hit ratio of the cache is the
+	// [Stats.DownloadCacheHits] divided by the sum of both.
+	DownloadCacheMisses int64
+
+	// BulkRequestsDelayed is the number of requests of bulk work delayed
+	// to respect the [Goproxy.UpstreamRateLimit].
+	BulkRequestsDelayed int64
+
+	// BulkPauses is the number of times bulk work was paused because an
+	// upstream asked to slow down.
+	BulkPauses int64
+
+	// PathRewrites is the number of fetches served under the rewritten
+	// module paths of the [Goproxy.PathRewrites].
+	PathRewrites int64
+
+	// PrivateModuleDenials is the number of requests for the
+	// [Goproxy.PrivateModules] denied for being unauthorized.
+	PrivateModuleDenials int64
+
+	// NotFoundCacheHits is the number of fetches answered with a cached
+	// not-found result (see the [Goproxy.NotFoundCacheExpiration]).
+	NotFoundCacheHits int64
+
+	// StaleServes is the number of requests served with stale copies (see
+	// the [Goproxy.StaleIfErrorExpiration]) after fetching failed or while
+	// being revalidated (see the [Goproxy.StaleWhileRevalidate]).
+	StaleServes int64
+
+	// StaleRevalidations is the number of background refreshes of expired
+	// caches started by the [Goproxy.StaleWhileRevalidate] or the
+	// emergency stale mode (see the [Goproxy.EmergencyStale]).
+	StaleRevalidations int64
+
+	// EmergencyStaleActivations is the number of times the emergency
+	// stale mode has been entered automatically (see the
+	// [Goproxy.EmergencyStaleThreshold]).
+	EmergencyStaleActivations int64
+}
+
+// add adds the counters of the s2 to the s. The maximum durations are merged
+// by taking the greater one instead.
+func (s *Stats) add(s2 Stats) {
+	v, v2 := reflect.ValueOf(s).Elem(), reflect.ValueOf(s2)
+	for i := 0; i < v.NumField(); i++ {
+		f, f2 := v.Field(i), v2.Field(i)
+		if f.Kind() != reflect.Int64 {
+			continue
+		}
+
+		if strings.Contains(v.Type().Field(i).Name, "Max") {
+			if f2.Int() > f.Int() {
+				f.SetInt(f2.Int())
+			}
+		} else {
+			f.SetInt(f.Int() + f2.Int())
+		}
+	}
+}
+
+// Stats returns the [Stats] of the g.
+func (g *Goproxy) Stats() Stats {
+	g.statsMutex.Lock()
+	defer g.statsMutex.Unlock()
+	return g.stats
+}
+
+// updateStats calls the update with the [Stats] of the g while holding the
+// lock.
+func (g *Goproxy) updateStats(update func(s *Stats)) {
+	g.statsMutex.Lock()
+	defer g.statsMutex.Unlock()
+	update(&g.stats)
+}
+
+// Cleanup removes all expired caches from the [Goproxy.Cacher]. If the
+// Goproxy.Cacher implements the [Reclaimer], the reclaimed space is accounted
+// in the [Goproxy.Stats].
+func (g *Goproxy) Cleanup() error {
+	g.initOnce.Do(g.init)
+
+	if g.Cacher == nil {
+		return nil
+	}
+
+	r, ok := g.Cacher.(Reclaimer)
+	if !ok {
+		return g.Cacher.Cleanup()
+	}
+
+	return r.CleanupReclaimed(func(name string, size int64) {
+		isPseudoVersion := isPseudoVersionName(name)
+		g.updateStats(func(s *Stats) {
+			s.CachesReclaimed++
+			s.BytesReclaimed += size
+			if isPseudoVersion {
+				s.PseudoVersionCachesReclaimed++
+				s.PseudoVersionBytesReclaimed += size
+			}
+		})
+	})
+}
+
+// isPseudoVersionName reports whether the name targets a module file (".info",
+// ".mod" or ".zip") of a pseudo-version.
+func isPseudoVersionName(name string) bool {
+	_, moduleVersion, ok := parseModuleVersionName(name)
+	return ok && module.IsPseudoVersion(moduleVersion)
+}
+
+// EffectiveLimits returns the [EffectiveLimits] of the g.
+func (g *Goproxy) EffectiveLimits() EffectiveLimits {
+	g.initOnce.Do(g.init)
+	return g.limits
+}
+
+// serveStats serves stats requests. The [EffectiveLimits] are reported along
+// with the [Stats].
+func (g *Goproxy) serveStats(rw http.ResponseWriter, req *http.Request) {
+	responseJSON(rw, req, -2, struct {
+		Stats
+		Limits EffectiveLimits
+	}{g.Stats(), g.EffectiveLimits()})
+}
@@ -0,0 +1,127 @@
+package goproxy
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGoproxyCleanup(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestGoproxyCleanup")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	g := &Goproxy{
+		Cacher: DirCacher(tempDir),
+		AdminAuthorizer: func(*http.Request) bool {
+			return true
+		},
+	}
+	for _, cache := range []struct {
+		name       string
+		expiration time.Duration
+	}{
+		{"example.com/@v/v0.0.0-20200101000000-0123456789ab.zip", -time.Hour},
+		{"example.com/@v/v0.0.0-20200101000000-0123456789ab.mod", -time.Hour},
+		{"example.com/@v/v1.0.0.zip", -time.Hour},
+		{"example.com/@v/v1.1.0.zip", time.Hour},
+	} {
+		if err := g.Cacher.Put(
+			context.Background(),
+			cache.name,
+			strings.NewReader("foobar"),
+			cache.expiration,
+		); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	if err := g.Cleanup(); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if got, want := g.Stats(), (Stats{
+		CachesReclaimed:              3,
+		BytesReclaimed:               18,
+		PseudoVersionCachesReclaimed: 2,
+		PseudoVersionBytesReclaimed:  12,
+	}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	req := httptest.NewRequest("", "/-/stats", nil)
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, req)
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	var s Stats
+	if err := json.NewDecoder(rec.Body).Decode(&s); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := s, g.Stats(); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	g = &Goproxy{Cacher: &errorCacher{}}
+	if err := g.Cleanup(); err == nil {
+		t.Fatal("expected error")
+	}
+
+	g = &Goproxy{}
+	if err := g.Cleanup(); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+}
+
+func TestIsPseudoVersionName(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		want bool
+	}{
+		{"example.com/@v/v0.0.0-20200101000000-0123456789ab.info", true},
+		{"example.com/@v/v1.2.4-0.20200101000000-0123456789ab.mod", true},
+		{"example.com/@v/v1.0.0.zip", false},
+		{"example.com/@v/list", false},
+		{"example.com/@latest", false},
+		{"example.com/@v/v0.0.0-20200101000000-0123456789ab.ziphash", false},
+		{"sumdb/sum.golang.org/supported", false},
+		{"-/trash/1/example.com/@v/v0.0.0-20200101000000-0123456789ab.zip", false},
+	} {
+		if got := isPseudoVersionName(tt.name); got != tt.want {
+			t.Errorf("%s: got %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStatsAdd(t *testing.T) {
+	s := Stats{
+		CachesReclaimed:       1,
+		GoCommandsDuration:    time.Second,
+		GoCommandsMaxDuration: 3 * time.Second,
+	}
+	s.add(Stats{
+		CachesReclaimed:       2,
+		DownloadCacheHits:     3,
+		GoCommandsDuration:    time.Second,
+		GoCommandsMaxDuration: 2 * time.Second,
+	})
+
+	want := Stats{
+		CachesReclaimed:       3,
+		DownloadCacheHits:     3,
+		GoCommandsDuration:    2 * time.Second,
+		GoCommandsMaxDuration: 3 * time.Second,
+	}
+	if s != want {
+		t.Errorf("got %+v, want %+v", s, want)
+	}
+}
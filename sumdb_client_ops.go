@@ -14,53 +14,38 @@ import (
 
 // sumdbClientOps implements the [golang.org/x/mod/sumdb.ClientOps].
 type sumdbClientOps struct {
-	initOnce    sync.Once
-	initError   error
-	key         []byte
-	endpointURL *url.URL
-	envGOPROXY  string
-	envGOSUMDB  string
-	httpClient  *http.Client
+	initOnce      sync.Once
+	initError     error
+	key           []byte
+	endpointURL   *url.URL
+	envGOPROXY    string
+	envGOSUMDB    string
+	httpClient    *http.Client
+	upstreamRetry *upstreamRetry
+
+	// trustKey returns the verifier key to trust in place of the one
+	// parsed from the envGOSUMDB, if not nil.
+	trustKey func(ctx context.Context, key string) (string, error)
 }
 
 // init initializes the sco.
 func (sco *sumdbClientOps) init() {
-	sumdbParts := strings.Fields(sco.envGOSUMDB)
-	if l := len(sumdbParts); l == 0 {
-		sco.initError = errors.New("missing GOSUMDB")
-		return
-	} else if l > 2 {
-		sco.initError = errors.New("invalid GOSUMDB: too many fields")
+	key, sumdbName, rawEndpointURL, err := parseGOSUMDB(sco.envGOSUMDB)
+	if err != nil {
+		sco.initError = err
 		return
 	}
 
-	if sumdbParts[0] == "sum.golang.google.cn" {
-		sumdbParts[0] = "sum.golang.org"
-		if len(sumdbParts) == 1 {
-			sumdbParts = append(
-				sumdbParts,
-				"https://sum.golang.google.cn",
-			)
+	if sco.trustKey != nil {
+		key, sco.initError = sco.trustKey(context.Background(), key)
+		if sco.initError != nil {
+			return
 		}
 	}
 
-	if sumdbParts[0] == "sum.golang.org" {
-		sumdbParts[0] = "sum.golang.org" +
-			"+033de0ae+Ac4zctda0e5eza+HJyk9SxEdh+s3Ux18htTTAD8OuAn8"
-	}
-
-	sco.key = []byte(sumdbParts[0])
-
-	sumdbName := sumdbParts[0]
-	if i := strings.Index(sumdbName, "+"); i >= 0 {
-		sumdbName = sumdbName[:i]
-	}
+	sco.key = []byte(key)
 
-	if len(sumdbParts) == 1 {
-		sumdbParts = append(sumdbParts, sumdbName)
-	}
-
-	sco.endpointURL, sco.initError = parseRawURL(sumdbParts[1])
+	sco.endpointURL, sco.initError = parseRawURL(rawEndpointURL)
 	if sco.initError != nil {
 		return
 	}
@@ -74,7 +59,10 @@ func (sco *sumdbClientOps) init() {
 		endpointURL := appendURL(proxyURL, "sumdb", sumdbName)
 
 		if err := httpGet(
-			context.Background(),
+			withUpstreamRetry(
+				context.Background(),
+				sco.upstreamRetry,
+			),
 			sco.httpClient,
 			appendURL(endpointURL, "/supported").String(),
 			nil,
@@ -103,7 +91,7 @@ func (sco *sumdbClientOps) ReadRemote(path string) ([]byte, error) {
 
 	var buf bytes.Buffer
 	if err := httpGet(
-		context.Background(),
+		withUpstreamRetry(context.Background(), sco.upstreamRetry),
 		sco.httpClient,
 		appendURL(sco.endpointURL, path).String(),
 		&buf,
@@ -158,3 +146,45 @@ func (sco *sumdbClientOps) Log(msg string) {
 func (sco *sumdbClientOps) SecurityError(msg string) {
 	sco.initOnce.Do(sco.init)
 }
+
+// parseGOSUMDB parses the envGOSUMDB (see the GOSUMDB environment variable)
+// into the verifier key, the name and the raw endpoint URL of the checksum
+// database.
+func parseGOSUMDB(envGOSUMDB string) (key, name, rawURL string, err error) {
+	sumdbParts := strings.Fields(envGOSUMDB)
+	if l := len(sumdbParts); l == 0 {
+		return "", "", "", errors.New("missing GOSUMDB")
+	} else if l > 2 {
+		err := errors.New("invalid GOSUMDB: too many fields")
+		return "", "", "", err
+	}
+
+	if sumdbParts[0] == "sum.golang.google.cn" {
+		sumdbParts[0] = "sum.golang.org"
+		if len(sumdbParts) == 1 {
+			sumdbParts = append(
+				sumdbParts,
+				"https://sum.golang.google.cn",
+			)
+		}
+	}
+
+	if sumdbParts[0] == "sum.golang.org" {
+		sumdbParts[0] = "sum.golang.org" +
+			"+033de0ae+Ac4zctda0e5eza+HJyk9SxEdh+s3Ux18htTTAD8OuAn8"
+	}
+
+	key = sumdbParts[0]
+
+	name = key
+	if i := strings.Index(name, "+"); i >= 0 {
+		name = name[:i]
+	}
+
+	rawURL = name
+	if len(sumdbParts) > 1 {
+		rawURL = sumdbParts[1]
+	}
+
+	return key, name, rawURL, nil
+}
@@ -0,0 +1,108 @@
+package goproxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"golang.org/x/mod/sumdb/note"
+)
+
+// sumdbKeyCachePrefix is the prefix of the names of the caches that store the
+// verifier keys trusted for checksum databases (see the [Goproxy.SUMDBKeys]).
+const sumdbKeyCachePrefix = apiPathPrefix + "sumdb-keys/"
+
+// errSUMDBKeyChanged means the verifier key of a checksum database differs from
+// the one it has been trusted with.
+var errSUMDBKeyChanged = errors.New("sumdb key changed")
+
+// sumdbKeyName returns the name of the checksum database of the verifier key.
+func sumdbKeyName(key string) string {
+	if i := strings.Index(key, "+"); i >= 0 {
+		return key[:i]
+	}
+
+	return key
+}
+
+// trustSUMDBKey returns the verifier key to trust for the checksum database of
+// the key parsed from the GOSUMDB, as pinned by the [Goproxy.SUMDBKeys]. The
+// key is stored in the [Goproxy.Cacher] on first use and checked against the
+// stored one afterwards.
+func (g *Goproxy) trustSUMDBKey(
+	ctx context.Context,
+	key string,
+) (string, error) {
+	name := sumdbKeyName(key)
+
+	var pinnedKeys []string
+	for _, pk := range g.SUMDBKeys {
+		if sumdbKeyName(pk) == name {
+			pinnedKeys = append(pinnedKeys, pk)
+		}
+	}
+
+	if len(pinnedKeys) > 0 && !stringSliceContains(pinnedKeys, key) {
+		// A key given by the GOSUMDB itself must be pinned, whereas
+		// the one built in for a bare name is replaced.
+		if strings.Contains(strings.Fields(g.goBinEnvGOSUMDB)[0], "+") {
+			return "", fmt.Errorf(
+				"sumdb key %q of GOSUMDB is not pinned",
+				key,
+			)
+		}
+
+		key = pinnedKeys[0]
+	}
+
+	if _, err := note.NewVerifier(key); err != nil {
+		return "", fmt.Errorf("invalid sumdb key %q: %w", key, err)
+	}
+
+	if g.Cacher == nil {
+		return key, nil
+	}
+
+	cacheName := sumdbKeyCachePrefix + name
+	rc, err := g.Cacher.Get(ctx, cacheName)
+	if err == nil {
+		b, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return "", err
+		}
+
+		storedKey := string(bytes.TrimSpace(b))
+		if storedKey == key {
+			return key, nil
+		}
+
+		// A change to a pinned key is an expected rotation.
+		if !stringSliceContains(pinnedKeys, key) {
+			return "", fmt.Errorf(
+				"%w: %s: from %q to %q",
+				errSUMDBKeyChanged,
+				name,
+				storedKey,
+				key,
+			)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+
+	if err := g.Cacher.Put(
+		ctx,
+		cacheName,
+		strings.NewReader(key+"\n"),
+		foreverCacheExpiration,
+	); err != nil {
+		return "", err
+	}
+
+	return key, nil
+}
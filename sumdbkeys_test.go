@@ -0,0 +1,104 @@
+package goproxy
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"golang.org/x/mod/sumdb/note"
+)
+
+func TestGoproxyTrustSUMDBKey(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestGoproxyTrustSUMDBKey")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	newKey := func() string {
+		_, vkey, err := note.GenerateKey(rand.Reader, "example.com")
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		return vkey
+	}
+
+	key1, key2, key3 := newKey(), newKey(), newKey()
+	ctx := context.Background()
+	trust := func(
+		envGOSUMDB string,
+		sumdbKeys ...string,
+	) (string, error) {
+		g := &Goproxy{
+			GoBinEnv: []string{
+				"GOPROXY=off",
+				"GOSUMDB=" + envGOSUMDB,
+			},
+			Cacher:    DirCacher(tempDir),
+			SUMDBKeys: sumdbKeys,
+		}
+		g.init()
+
+		key, _, _, err := parseGOSUMDB(g.goBinEnvGOSUMDB)
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		return g.trustSUMDBKey(ctx, key)
+	}
+
+	// The first key is trusted on first use.
+	if key, err := trust(key1); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := key, key1; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if key, err := trust(key1); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := key, key1; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if _, err := trust(key2); !errors.Is(err, errSUMDBKeyChanged) {
+		t.Fatalf("got error %q, want error %q", err, errSUMDBKeyChanged)
+	}
+
+	// A key of the GOSUMDB must be pinned if any key is.
+	if _, err := trust(key3, key2); err == nil {
+		t.Fatal("expected error")
+	}
+
+	// A rotation to a pinned key is accepted.
+	if key, err := trust(key2, key1, key2); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := key, key2; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if _, err := trust(key1); !errors.Is(err, errSUMDBKeyChanged) {
+		t.Fatalf("got error %q, want error %q", err, errSUMDBKeyChanged)
+	}
+
+	// A bare name uses the first pinned key.
+	if key, err := trust("example.com", key2); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := key, key2; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if _, err := trust("example.com+invalid-key"); err == nil {
+		t.Fatal("expected error")
+	}
+
+	g := &Goproxy{SUMDBKeys: []string{key3}}
+	if key, err := g.trustSUMDBKey(ctx, key3); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := key, key3; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
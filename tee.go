@@ -0,0 +1,136 @@
+package goproxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+)
+
+// errDownloadTeeStarted is reported when a fetch fails after the module file
+// being downloaded has started to be streamed to the client, in which case it
+// can neither be retried nor fall back to another upstream.
+var errDownloadTeeStarted = errors.New(
+	"download failed after streaming to client",
+)
+
+// downloadTee streams a module zip file to the client while it is being
+// downloaded from an upstream module proxy into a temporary file (see the
+// [Goproxy.StreamZipDownloads]).
+type downloadTee struct {
+	rw          http.ResponseWriter
+	contentType string
+
+	// file is the temporary file that the module zip file is downloaded
+	// into.
+	file *os.File
+
+	started  bool
+	writeErr error
+}
+
+// downloadTee returns a new instance of the [downloadTee] for the module zip
+// file of the f requested by the req, or nil if it should not be streamed.
+func (g *Goproxy) downloadTee(
+	rw http.ResponseWriter,
+	req *http.Request,
+	f *fetch,
+) *downloadTee {
+	if !g.StreamZipDownloads ||
+		f.ops != fetchOpsDownloadZip ||
+		f.rewrittenModulePath != "" ||
+		f.doubleFetchRequired("") ||
+		req.Method != http.MethodGet {
+		return nil
+	}
+
+	// Conditional and range requests are left to the
+	// [http.ServeContent].
+	for _, key := range []string{
+		"Range",
+		"If-Match",
+		"If-None-Match",
+		"If-Modified-Since",
+		"If-Unmodified-Since",
+	} {
+		if req.Header.Get(key) != "" {
+			return nil
+		}
+	}
+
+	return &downloadTee{rw: rw, contentType: f.contentType}
+}
+
+// writer returns the writer that the module zip file should be downloaded into
+// instead of the file.
+func (dt *downloadTee) writer(file *os.File) io.Writer {
+	dt.file = file
+	return dt
+}
+
+// Write implements the [io.Writer]. The b is always written to the file first,
+// and then flushed to the client. Failures to write it to the client are
+// remembered rather than reported, so that the download into the file goes on.
+func (dt *downloadTee) Write(b []byte) (int, error) {
+	n, err := dt.file.Write(b)
+	if err != nil {
+		return n, err
+	}
+
+	if !dt.started {
+		dt.started = true
+
+		// The Content-Length is deliberately left unset, so that
+		// clients can tell a response aborted on a failed verification
+		// from a complete one.
+		dt.rw.Header().Set("Content-Type", dt.contentType)
+		setResponseCacheControlHeader(dt.rw, 604800)
+		dt.rw.WriteHeader(http.StatusOK)
+	}
+
+	if dt.writeErr == nil {
+		_, dt.writeErr = dt.rw.Write(b)
+		if f, ok := dt.rw.(http.Flusher); ok && dt.writeErr == nil {
+			f.Flush()
+		}
+	}
+
+	return n, nil
+}
+
+// Seek implements the [io.Seeker]. Together with the Truncate, it lets the
+// download be reset for a retry until it has started to be streamed.
+func (dt *downloadTee) Seek(offset int64, whence int) (int64, error) {
+	if dt.started {
+		return 0, errDownloadTeeStarted
+	}
+
+	return dt.file.Seek(offset, whence)
+}
+
+// Truncate truncates the file to the size.
+func (dt *downloadTee) Truncate(size int64) error {
+	if dt.started {
+		return errDownloadTeeStarted
+	}
+
+	return dt.file.Truncate(size)
+}
+
+// checkErr returns the err, marked with the [errDownloadTeeStarted] if the dt
+// has started streaming, so that it stops the fallbacks to other upstreams. It
+// is safe to call on a nil dt.
+func (dt *downloadTee) checkErr(err error) error {
+	if err == nil || dt == nil || !dt.started {
+		return err
+	}
+
+	return fmt.Errorf("%w: %v", errDownloadTeeStarted, err)
+}
+
+// hasStarted reports whether the dt has started streaming. It is safe to call
+// on a nil dt.
+func (dt *downloadTee) hasStarted() bool {
+	return dt != nil && dt.started
+}
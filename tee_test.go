@@ -0,0 +1,151 @@
+package goproxy
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+)
+
+func TestGoproxyStreamZipDownloads(t *testing.T) {
+	tempDir, err := ioutil.TempDir(
+		"",
+		"goproxy.TestGoproxyStreamZipDownloads",
+	)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	var zipBuf bytes.Buffer
+	zw := zip.NewWriter(&zipBuf)
+	w, err := zw.Create("example.com@v1.0.0/go.mod")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if _, err := w.Write([]byte("module example.com")); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if err := zw.Close(); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(
+		rw http.ResponseWriter,
+		req *http.Request,
+	) {
+		switch req.URL.Path {
+		case "/example.com/@v/v1.0.0.zip":
+			rw.Write(zipBuf.Bytes())
+		case "/example.com/@v/v1.1.0.zip":
+			rw.Write([]byte("corrupted"))
+		default:
+			responseNotFound(rw, req, -2)
+		}
+	}))
+	defer upstream.Close()
+
+	var fallbackHits int64
+	fallback := httptest.NewServer(http.HandlerFunc(func(
+		rw http.ResponseWriter,
+		req *http.Request,
+	) {
+		atomic.AddInt64(&fallbackHits, 1)
+		responseNotFound(rw, req, -2)
+	}))
+	defer fallback.Close()
+
+	g := &Goproxy{
+		GoBinEnv: []string{
+			"GOPROXY=" + upstream.URL + "|" + fallback.URL,
+			"GOSUMDB=off",
+		},
+		Cacher:             DirCacher(tempDir),
+		TempDir:            tempDir,
+		StreamZipDownloads: true,
+		ErrorLogger:        log.New(&discardWriter{}, "", 0),
+	}
+	server := httptest.NewServer(g)
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/example.com/@v/v1.0.0.zip")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	b, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := res.StatusCode, http.StatusOK; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	} else if got, want := res.ContentLength, int64(-1); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	} else if !bytes.Equal(b, zipBuf.Bytes()) {
+		t.Errorf("got %q, want %q", b, zipBuf.Bytes())
+	}
+
+	content, err := g.cache(
+		context.Background(),
+		"example.com/@v/v1.0.0.zip",
+	)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	content.Close()
+
+	// Module zip files that fail the verification after being streamed
+	// are neither cached nor fetched from the fallbacks, and the responses
+	// are aborted.
+	res, err = http.Get(server.URL + "/example.com/@v/v1.1.0.zip")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	_, err = ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	if _, err := g.cache(
+		context.Background(),
+		"example.com/@v/v1.1.0.zip",
+	); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got error %q, want error %q", err, os.ErrNotExist)
+	}
+
+	if got, want := atomic.LoadInt64(&fallbackHits), int64(0); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	// Range requests are not streamed.
+	req, err := http.NewRequest(
+		http.MethodGet,
+		server.URL+"/example.com/@v/v1.1.0.zip",
+		nil,
+	)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	req.Header.Set("Range", "bytes=0-1")
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	res.Body.Close()
+
+	if got, want := res.StatusCode, http.StatusNotFound; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,225 @@
+package goproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"golang.org/x/mod/module"
+)
+
+// Tenant is a logical module proxy served by a [TenantRouter].
+type Tenant struct {
+	// Name is the name of the Tenant. It is only used for identification.
+	Name string
+
+	// Host is the host (as in the Host header) that the requests must be
+	// sent to in order to be routed to the Tenant.
+	//
+	// If the Host is empty, requests sent to any host match.
+	Host string
+
+	// PathPrefix is the prefix of the paths of the requests that are
+	// routed to the Tenant. It is stripped before the requests are served
+	// by the [Tenant.Goproxy].
+	//
+	// If the PathPrefix is empty, requests for any path match.
+	PathPrefix string
+
+	// Goproxy is the [Goproxy] that serves the requests routed to the
+	// Tenant. It carries the upstream chain (see [Goproxy.GoBinEnv]) and
+	// the cache namespace (see [Goproxy.Cacher]) of the Tenant.
+	//
+	// The [Goproxy.PathPrefix] of the Goproxy should be empty since the
+	// [Tenant.PathPrefix] has already been stripped.
+	Goproxy *Goproxy
+
+	// Authorizer reports whether the req is allowed to access the Tenant.
+	//
+	// If the Authorizer is nil, all requests are allowed.
+	Authorizer func(req *http.Request) bool
+
+	// AllowedModules is a list of comma-separated glob patterns (in the
+	// syntax of Go's path.Match) of module paths that are allowed to be
+	// served by the Tenant. It also applies to the module paths taken by
+	// the "-/go-mod", "-/lookup" and "-/zip-delta" endpoints, and a batch
+	// lookup naming any disallowed module is rejected as a whole. It does
+	// not apply to checksum database requests.
+	//
+	// If the AllowedModules is empty, all modules are allowed.
+	AllowedModules string
+}
+
+// TenantRouter implements the [http.Handler] to serve multiple [Tenant]s from
+// one process. Each request is routed to the first tenant that matches its
+// host and path.
+//
+// Make sure that all fields of the TenantRouter have been finalized before
+// calling any of its methods.
+type TenantRouter struct {
+	// Tenants is the list of [Tenant]s, in order of precedence.
+	Tenants []*Tenant
+}
+
+// ServeHTTP implements the [http.Handler].
+func (tr *TenantRouter) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	t, name := tr.route(req)
+	if t == nil {
+		responseNotFound(rw, req, -2, "unknown tenant")
+		return
+	}
+
+	if t.Authorizer != nil && !t.Authorizer(req) {
+		responseForbidden(rw, req, -2)
+		return
+	}
+
+	if t.AllowedModules != "" {
+		for _, modulePath := range requestModulePaths(req, name) {
+			if !globsMatchPath(t.AllowedModules, modulePath) {
+				responseNotFound(
+					rw,
+					req,
+					-2,
+					"module not allowed: ",
+					modulePath,
+				)
+				return
+			}
+		}
+	}
+
+	if t.PathPrefix != "" {
+		req2 := new(http.Request)
+		*req2 = *req
+		req2.URL = new(url.URL)
+		*req2.URL = *req.URL
+		req2.URL.Path = "/" + strings.TrimPrefix(name, "/")
+		req2.URL.RawPath = ""
+		req = req2
+	}
+
+	t.Goproxy.ServeHTTP(rw, req)
+}
+
+// route returns the [Tenant] that the req should be routed to, along with the
+// unescaped request path with the [Tenant.PathPrefix] stripped. It returns a
+// nil tenant if there is no match.
+func (tr *TenantRouter) route(req *http.Request) (*Tenant, string) {
+	name, err := url.PathUnescape(req.URL.Path)
+	if err != nil {
+		return nil, ""
+	}
+
+	name = path.Clean(name)
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	for _, t := range tr.Tenants {
+		if t.Host != "" && !strings.EqualFold(t.Host, host) {
+			continue
+		}
+
+		if t.PathPrefix == "" {
+			return t, name
+		}
+
+		prefix := strings.TrimSuffix(t.PathPrefix, "/")
+		if name == prefix || strings.HasPrefix(name, prefix+"/") {
+			return t, strings.TrimPrefix(name, prefix)
+		}
+	}
+
+	return nil, ""
+}
+
+// requestModulePath returns the module path targeted by the name of a module
+// proxy request. It returns false if the name does not target a module (e.g.
+// checksum database requests).
+func requestModulePath(name string) (string, bool) {
+	name = strings.TrimPrefix(name, "/")
+	if strings.HasPrefix(name, "sumdb/") ||
+		strings.HasPrefix(name, apiPathPrefix) {
+		return "", false
+	}
+
+	var escapedModulePath string
+	if i := strings.Index(name, "/@v/"); i >= 0 {
+		escapedModulePath = name[:i]
+	} else if strings.HasSuffix(name, "/@latest") {
+		escapedModulePath = strings.TrimSuffix(name, "/@latest")
+	} else {
+		return "", false
+	}
+
+	modulePath, err := module.UnescapePath(escapedModulePath)
+	if err != nil {
+		return "", false
+	}
+
+	return modulePath, true
+}
+
+// requestModulePaths returns the module paths targeted by the req, whose
+// unescaped path is the name. Besides the module file requests, it covers the
+// "-/go-mod" and "-/zip-delta" endpoints, whose module paths are taken from
+// the "module" query parameter, and the "-/lookup" endpoint, whose module paths
+// are taken from the body. The body of the req is left intact for the
+// [Goproxy] to read it again.
+func requestModulePaths(req *http.Request, name string) []string {
+	if modulePath, ok := requestModulePath(name); ok {
+		return []string{modulePath}
+	}
+
+	switch strings.TrimPrefix(name, "/") {
+	case apiPathPrefix + "go-mod", apiPathPrefix + "zip-delta":
+		modulePath := req.URL.Query().Get("module")
+		if modulePath != "" {
+			return []string{modulePath}
+		}
+	case apiPathPrefix + "lookup":
+		if req.Method != http.MethodPost || req.Body == nil {
+			return nil
+		}
+
+		// Read no more than the [Goproxy] would, and leave anything
+		// malformed to it.
+		b, err := ioutil.ReadAll(io.LimitReader(
+			req.Body,
+			maxLookupBytes+1,
+		))
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(b), req.Body), req.Body}
+		if err != nil {
+			return nil
+		}
+
+		var modAtVers []string
+		if json.NewDecoder(
+			bytes.NewReader(b),
+		).Decode(&modAtVers) != nil {
+			return nil
+		}
+
+		modulePaths := make([]string, 0, len(modAtVers))
+		for _, modAtVer := range modAtVers {
+			if i := strings.Index(modAtVer, "@"); i >= 0 {
+				modulePaths = append(modulePaths, modAtVer[:i])
+			}
+		}
+
+		return modulePaths
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+package goproxy
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTenantRouter(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestTenantRouter")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	newTenantGoproxy := func(name string) *Goproxy {
+		cacher := DirCacher(tempDir + "/" + name)
+		if err := cacher.Put(
+			context.Background(),
+			"example.com/foo/@v/list",
+			strings.NewReader(name),
+			time.Hour,
+		); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		return &Goproxy{
+			GoBinEnv: []string{"GOPROXY=off"},
+			Cacher:   cacher,
+		}
+	}
+
+	tr := &TenantRouter{
+		Tenants: []*Tenant{
+			{
+				Name:    "a",
+				Host:    "a.example.com",
+				Goproxy: newTenantGoproxy("a"),
+				Authorizer: func(req *http.Request) bool {
+					return req.Header.Get("Authorization") ==
+						"Bearer secret"
+				},
+			},
+			{
+				Name:           "b",
+				PathPrefix:     "/b/",
+				Goproxy:        newTenantGoproxy("b"),
+				AllowedModules: "example.com/foo",
+			},
+		},
+	}
+
+	for _, tt := range []struct {
+		host          string
+		path          string
+		authorization string
+		wantCode      int
+		wantContent   string
+	}{
+		{"a.example.com:8080", "/example.com/foo/@v/list", "Bearer secret", http.StatusOK, "a"},
+		{"a.example.com", "/example.com/foo/@v/list", "", http.StatusForbidden, "forbidden"},
+		{"c.example.com", "/b/example.com/foo/@v/list", "", http.StatusOK, "b"},
+		{"c.example.com", "/b/example.com/bar/@v/list", "", http.StatusNotFound, "not found: module not allowed: example.com/bar"},
+		{"c.example.com", "/b/sumdb/sum.golang.org/supported", "", http.StatusNotFound, "not found"},
+		{"c.example.com", "/b/-/go-mod?module=example.com/bar&version=v1.0.0", "", http.StatusNotFound, "not found: module not allowed: example.com/bar"},
+		{"c.example.com", "/example.com/foo/@v/list", "", http.StatusNotFound, "not found: unknown tenant"},
+		{"c.example.com", "/bar/example.com/foo/@v/list", "", http.StatusNotFound, "not found: unknown tenant"},
+	} {
+		req := httptest.NewRequest("", tt.path, nil)
+		req.Host = tt.host
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+
+		rec := httptest.NewRecorder()
+		tr.ServeHTTP(rec, req)
+		if got, want := rec.Code, tt.wantCode; got != want {
+			t.Errorf("%s%s: got %d, want %d", tt.host, tt.path, got, want)
+		}
+
+		if got, want := rec.Body.String(), tt.wantContent; got != want {
+			t.Errorf("%s%s: got %q, want %q", tt.host, tt.path, got, want)
+		}
+	}
+}
+
+func TestRequestModulePath(t *testing.T) {
+	for _, tt := range []struct {
+		name           string
+		wantModulePath string
+		wantOK         bool
+	}{
+		{"/example.com/@v/list", "example.com", true},
+		{"example.com/!foo/@v/v1.0.0.zip", "example.com/Foo", true},
+		{"example.com/@latest", "example.com", true},
+		{"sumdb/sum.golang.org/supported", "", false},
+		{"-/explain", "", false},
+		{"example.com", "", false},
+		{"example.com/!!foo/@latest", "", false},
+	} {
+		modulePath, ok := requestModulePath(tt.name)
+		if got, want := modulePath, tt.wantModulePath; got != want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, want)
+		}
+
+		if got, want := ok, tt.wantOK; got != want {
+			t.Errorf("%s: got %t, want %t", tt.name, got, want)
+		}
+	}
+}
+
+func TestRequestModulePaths(t *testing.T) {
+	for n, tt := range []struct {
+		method          string
+		target          string
+		body            string
+		wantModulePaths string
+	}{
+		{http.MethodGet, "/example.com/@v/list", "", "example.com"},
+		{http.MethodGet, "/-/go-mod?module=example.com/foo", "", "example.com/foo"},
+		{http.MethodGet, "/-/zip-delta?module=example.com/foo", "", "example.com/foo"},
+		{http.MethodGet, "/-/zip-delta", "", ""},
+		{http.MethodPost, "/-/lookup", `["example.com/foo@v1.0.0","example.com/bar@v1.0.0"]`, "example.com/foo example.com/bar"},
+		{http.MethodPost, "/-/lookup", "invalid", ""},
+		{http.MethodGet, "/-/explain", "", ""},
+	} {
+		req := httptest.NewRequest(
+			tt.method,
+			tt.target,
+			strings.NewReader(tt.body),
+		)
+		modulePaths := requestModulePaths(req, req.URL.Path)
+		if got, want := strings.Join(modulePaths, " "),
+			tt.wantModulePaths; got != want {
+			t.Errorf("test(%d): got %q, want %q", n, got, want)
+		}
+
+		if b, err := ioutil.ReadAll(req.Body); err != nil {
+			t.Fatalf("test(%d): unexpected error %q", n, err)
+		} else if got, want := string(b), tt.body; got != want {
+			t.Errorf("test(%d): got %q, want %q", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// TieredWritePolicy is the policy of a [TieredCacher] for writing caches to its
+// tiers.
+type TieredWritePolicy int
+
+const (
+	// TieredWriteAll writes caches to all the tiers, slowest first, so
+	// that a cache found in a faster tier is always also in the slower
+	// ones.
+	TieredWriteAll TieredWritePolicy = iota
+
+	// TieredWriteLast writes caches to the last (slowest) tier only and
+	// deletes them from the faster tiers, which are then only filled by
+	// promotions.
+	TieredWriteLast
+)
+
+// TieredCacher implements the [Cacher] by chaining multiple [Cacher]s, such as
+// a [MemoryCacher], a [DirCacher] and an [S3Cacher], fastest first.
+//
+// Gets go through the tiers in order, and a cache found in a slower tier is
+// promoted to all the faster ones. A promoted cache never outlives the cache it
+// was promoted from: its expiration is the remaining lifetime of the original
+// if the content got from the slower tier implements
+// interface{ Expires() time.Time } (as the ones got from all the Cachers in
+// this package but the [RedisCacher] do), or the
+// [TieredCacher.PromotionExpiration] otherwise. Puts fan out according to the
+// [TieredCacher.WritePolicy].
+//
+// The last tier is treated as the source of truth, so only its caches are
+// enumerated.
+//
+// Make sure that all fields of the TieredCacher have been finalized before
+// calling any of its methods.
+type TieredCacher struct {
+	// Tiers is the list of the [Cacher]s, fastest first.
+	Tiers []Cacher
+
+	// WritePolicy is the policy of writing caches to the Tiers.
+	WritePolicy TieredWritePolicy
+
+	// PromotionExpiration is the expiration of the caches promoted to the
+	// faster tiers whose remaining lifetime is unknown.
+	//
+	// If the PromotionExpiration is zero, such caches are not promoted.
+	PromotionExpiration time.Duration
+}
+
+// Get implements the [Cacher].
+func (tc *TieredCacher) Get(
+	ctx context.Context,
+	name string,
+) (io.ReadCloser, error) {
+	var firstErr error
+	for i, tier := range tc.Tiers {
+		content, err := tier.Get(ctx, name)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) && firstErr == nil {
+				firstErr = err
+			}
+
+			continue
+		}
+
+		if i == 0 {
+			return content, nil
+		}
+
+		if err := tc.promote(ctx, name, content, i); err != nil {
+			content.Close()
+			return nil, err
+		}
+
+		return content, nil
+	}
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	return nil, os.ErrNotExist
+}
+
+// promote puts the content of the cache for the name, which was got from the
+// tier at the index, to all the faster tiers. The content is only promoted if
+// it is seekable, and is rewound afterwards. It only fails if the rewinding
+// fails.
+func (tc *TieredCacher) promote(
+	ctx context.Context,
+	name string,
+	content io.ReadCloser,
+	index int,
+) error {
+	rs, ok := content.(io.ReadSeeker)
+	if !ok {
+		return nil
+	}
+
+	expiration := tc.PromotionExpiration
+	if ec, ok := content.(interface{ Expires() time.Time }); ok {
+		expiration = time.Until(ec.Expires())
+	}
+
+	if expiration <= 0 {
+		return nil
+	}
+
+	for i := index - 1; i >= 0; i-- {
+		// Failing to promote is no reason to fail the get, since the
+		// content is still there.
+		tc.Tiers[i].Put(ctx, name, rs, expiration)
+		if _, err := rs.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Put implements the [Cacher].
+func (tc *TieredCacher) Put(
+	ctx context.Context,
+	name string,
+	content io.ReadSeeker,
+	expiration time.Duration,
+) error {
+	for i := len(tc.Tiers) - 1; i >= 0; i-- {
+		tier := tc.Tiers[i]
+		if i < len(tc.Tiers)-1 && tc.WritePolicy == TieredWriteLast {
+			d, ok := tier.(Deleter)
+			if !ok {
+				return errDeleteNotSupported
+			}
+
+			err := d.Delete(ctx, name)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+
+			continue
+		}
+
+		if _, err := content.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		if err := tier.Put(ctx, name, content, expiration); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete implements the [Deleter]. It returns the [os.ErrNotExist] only if the
+// cache for the name is not found in any of the tiers.
+func (tc *TieredCacher) Delete(ctx context.Context, name string) error {
+	found := false
+	for _, tier := range tc.Tiers {
+		d, ok := tier.(Deleter)
+		if !ok {
+			return errDeleteNotSupported
+		}
+
+		if err := d.Delete(ctx, name); err == nil {
+			found = true
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	if !found {
+		return os.ErrNotExist
+	}
+
+	return nil
+}
+
+// Cleanup implements the [Cacher]. All the tiers are cleaned up even if some of
+// them fail, and the first error is returned.
+func (tc *TieredCacher) Cleanup() error {
+	var firstErr error
+	for _, tier := range tc.Tiers {
+		if err := tier.Cleanup(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// List implements the [Lister].
+func (tc *TieredCacher) List(ctx context.Context, prefix string) CacheIterator {
+	return listCaches(ctx, tc.walkCaches, prefix)
+}
+
+// walkCaches implements the [cacheWalker].
+func (tc *TieredCacher) walkCaches(
+	fn func(name string, size int64) error,
+) error {
+	if len(tc.Tiers) == 0 {
+		return nil
+	}
+
+	cw, ok := cacheWalkerOf(tc.Tiers[len(tc.Tiers)-1])
+	if !ok {
+		return errWalkNotSupported
+	}
+
+	return cw.walkCaches(fn)
+}
@@ -0,0 +1,143 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTieredCacher(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestTieredCacher")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	mc := &MemoryCacher{}
+	dc := DirCacher(tempDir)
+	tc := &TieredCacher{Tiers: []Cacher{mc, dc}}
+	ctx := context.Background()
+
+	get := func(name string) (string, error) {
+		content, err := tc.Get(ctx, name)
+		if err != nil {
+			return "", err
+		}
+		defer content.Close()
+
+		b, err := ioutil.ReadAll(content)
+		return string(b), err
+	}
+
+	if err := tc.Put(
+		ctx,
+		"a",
+		strings.NewReader("foo"),
+		time.Hour,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	for _, c := range []Cacher{mc, dc} {
+		if _, err := c.Get(ctx, "a"); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	// A cache only found in the slower tier is promoted with its
+	// remaining lifetime.
+	if err := dc.Put(
+		ctx,
+		"b",
+		strings.NewReader("bar"),
+		30*time.Minute,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if got, err := get("b"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if want := "bar"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	content, err := mc.Get(ctx, "b")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	expires := content.(interface{ Expires() time.Time }).Expires()
+	content.Close()
+	if got := time.Until(expires); got > 30*time.Minute ||
+		got < 29*time.Minute {
+		t.Errorf("got %s, want about %s", got, 30*time.Minute)
+	}
+
+	// An expired cache is never promoted.
+	if err := dc.Put(
+		ctx,
+		"c",
+		strings.NewReader("baz"),
+		-time.Hour,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if _, err := get("c"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got error %q, want error %q", err, os.ErrNotExist)
+	}
+
+	if _, err := mc.Get(ctx, "c"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got error %q, want error %q", err, os.ErrNotExist)
+	}
+
+	// Writing to the last tier only evicts stale faster copies.
+	tc.WritePolicy = TieredWriteLast
+	if err := tc.Put(
+		ctx,
+		"a",
+		strings.NewReader("qux"),
+		time.Hour,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if _, err := mc.Get(ctx, "a"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got error %q, want error %q", err, os.ErrNotExist)
+	}
+
+	if got, err := get("a"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if want := "qux"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	var names []string
+	if err := tc.walkCaches(func(name string, size int64) error {
+		names = append(names, name)
+		return nil
+	}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := strings.Join(names, ","), "a,b,c"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if err := tc.Delete(ctx, "b"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if err := tc.Delete(ctx, "b"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got error %q, want error %q", err, os.ErrNotExist)
+	}
+
+	if err := tc.Cleanup(); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if _, err := os.Stat(
+		filepath.Join(tempDir, "c"),
+	); !os.IsNotExist(err) {
+		t.Fatalf("got error %q, want error %q", err, os.ErrNotExist)
+	}
+}
@@ -0,0 +1,86 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// requestTimeoutContextKey is the context key of the timeout of a request
+// enforced by the [Goproxy].
+type requestTimeoutContextKey struct{}
+
+// requestTimeout returns the timeout of the request for the name, which is not
+// an administrative one. It returns zero if there is none.
+func (g *Goproxy) requestTimeout(name string) time.Duration {
+	s := g.settings()
+	if s.ZipRequestTimeout != 0 &&
+		(strings.HasSuffix(name, ".zip") ||
+			strings.HasSuffix(name, ".ziphash")) {
+		return s.ZipRequestTimeout
+	}
+
+	return s.RequestTimeout
+}
+
+// fetchTimeout returns the timeout of the fetches for the ops. It returns zero
+// if there is none.
+func (g *Goproxy) fetchTimeout(ops fetchOps) time.Duration {
+	s := g.settings()
+	if s.ZipFetchTimeout != 0 && ops == fetchOpsDownloadZip {
+		return s.ZipFetchTimeout
+	}
+
+	return s.FetchTimeout
+}
+
+// withRequestTimeout returns a shallow copy of the req whose context is
+// canceled once the timeout has passed, along with the function that releases
+// the context. It returns the req as it is if the timeout is zero.
+func withRequestTimeout(
+	req *http.Request,
+	timeout time.Duration,
+) (*http.Request, context.CancelFunc) {
+	if timeout <= 0 {
+		return req, func() {}
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	ctx = context.WithValue(ctx, requestTimeoutContextKey{}, timeout)
+
+	return req.WithContext(ctx), cancel
+}
+
+// requestTimedOut reports whether the req has exceeded its timeout set by the
+// [withRequestTimeout], and returns the timeout if so.
+func requestTimedOut(req *http.Request) (time.Duration, bool) {
+	ctx := req.Context()
+	timeout, ok := ctx.Value(requestTimeoutContextKey{}).(time.Duration)
+	if !ok || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return 0, false
+	}
+
+	return timeout, true
+}
+
+// responseRequestTimeout responses a 504 Gateway Timeout to the client of the
+// req that has exceeded the timeout.
+func responseRequestTimeout(
+	rw http.ResponseWriter,
+	req *http.Request,
+	timeout time.Duration,
+) {
+	responseString(
+		rw,
+		req,
+		http.StatusGatewayTimeout,
+		-1,
+		withErrorReferenceID(
+			req,
+			fmt.Sprintf("request timed out after %s", timeout),
+		),
+	)
+}
@@ -0,0 +1,253 @@
+package goproxy
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestGoproxyRequestTimeout(t *testing.T) {
+	for n, tt := range []struct {
+		name       string
+		timeout    time.Duration
+		zipTimeout time.Duration
+		want       time.Duration
+	}{
+		{"example.com/@v/v1.0.0.info", 0, 0, 0},
+		{"example.com/@v/v1.0.0.info", 1, 2, 1},
+		{"example.com/@v/v1.0.0.zip", 1, 2, 2},
+		{"example.com/@v/v1.0.0.ziphash", 1, 2, 2},
+		{"example.com/@v/v1.0.0.zip", 1, 0, 1},
+		{"sumdb/sum.golang.org/latest", 1, 2, 1},
+	} {
+		g := &Goproxy{
+			RequestTimeout:    tt.timeout,
+			ZipRequestTimeout: tt.zipTimeout,
+		}
+		got := g.requestTimeout(tt.name)
+		if want := tt.want; got != want {
+			t.Errorf("test(%d): got %s, want %s", n, got, want)
+		}
+	}
+}
+
+func TestGoproxyServeHTTPRequestTimeout(t *testing.T) {
+	tempDir, err := ioutil.TempDir(
+		"",
+		"goproxy.TestGoproxyServeHTTPRequestTimeout",
+	)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	var zipBuf bytes.Buffer
+	zw := zip.NewWriter(&zipBuf)
+	w, err := zw.Create("example.com@v1.0.0/go.mod")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if _, err := w.Write([]byte("module example.com")); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if err := zw.Close(); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(
+		rw http.ResponseWriter,
+		req *http.Request,
+	) {
+		switch req.URL.Path {
+		case "/example.com/@v/v1.0.0.info":
+			// Hangs until the request is canceled.
+			<-req.Context().Done()
+		case "/example.com/@v/v1.0.0.zip":
+			time.Sleep(200 * time.Millisecond)
+			rw.Write(zipBuf.Bytes())
+		default:
+			responseNotFound(rw, req, -2)
+		}
+	}))
+	defer server.Close()
+
+	g := &Goproxy{
+		GoBinEnv: []string{
+			"GOPROXY=" + server.URL,
+			"GOSUMDB=off",
+		},
+		Cacher:            DirCacher(tempDir),
+		TempDir:           tempDir,
+		RequestTimeout:    100 * time.Millisecond,
+		ZipRequestTimeout: 10 * time.Second,
+		ErrorLogger:       log.New(&discardWriter{}, "", 0),
+	}
+
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, httptest.NewRequest(
+		http.MethodGet,
+		"/example.com/@v/v1.0.0.info",
+		nil,
+	))
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("got duration %s", d)
+	}
+
+	if got, want := rec.Code, http.StatusGatewayTimeout; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	} else if got, want := rec.Body.String(),
+		"request timed out after 100ms"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	} else if got, want := rec.Header().Get("Cache-Control"),
+		"must-revalidate, no-cache, no-store"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	rec = httptest.NewRecorder()
+	g.ServeHTTP(rec, httptest.NewRequest(
+		http.MethodGet,
+		"/example.com/@v/v1.0.0.zip",
+		nil,
+	))
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	} else if got, want := rec.Body.Bytes(),
+		zipBuf.Bytes(); !bytes.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if err := g.UpdateSettings(func(s *Settings) error {
+		s.ZipRequestTimeout = -time.Second
+		return nil
+	}); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestGoproxyFetchTimeout(t *testing.T) {
+	for n, tt := range []struct {
+		ops        fetchOps
+		timeout    time.Duration
+		zipTimeout time.Duration
+		want       time.Duration
+	}{
+		{fetchOpsResolve, 0, 0, 0},
+		{fetchOpsResolve, 1, 2, 1},
+		{fetchOpsList, 1, 2, 1},
+		{fetchOpsDownloadInfo, 1, 2, 1},
+		{fetchOpsDownloadMod, 1, 2, 1},
+		{fetchOpsDownloadZip, 1, 2, 2},
+		{fetchOpsDownloadZip, 1, 0, 1},
+	} {
+		g := &Goproxy{
+			FetchTimeout:    tt.timeout,
+			ZipFetchTimeout: tt.zipTimeout,
+		}
+		got := g.fetchTimeout(tt.ops)
+		if want := tt.want; got != want {
+			t.Errorf("test(%d): got %s, want %s", n, got, want)
+		}
+	}
+}
+
+func TestGoproxyServeHTTPFetchTimeout(t *testing.T) {
+	tempDir, err := ioutil.TempDir(
+		"",
+		"goproxy.TestGoproxyServeHTTPFetchTimeout",
+	)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	var zipBuf bytes.Buffer
+	zw := zip.NewWriter(&zipBuf)
+	w, err := zw.Create("example.com@v1.0.0/go.mod")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if _, err := w.Write([]byte("module example.com")); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if err := zw.Close(); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(
+		rw http.ResponseWriter,
+		req *http.Request,
+	) {
+		switch req.URL.Path {
+		case "/example.com/@v/list":
+			// Hangs until the request is canceled.
+			<-req.Context().Done()
+		case "/example.com/@v/v1.0.0.zip":
+			time.Sleep(200 * time.Millisecond)
+			rw.Write(zipBuf.Bytes())
+		default:
+			responseNotFound(rw, req, -2)
+		}
+	}))
+	defer server.Close()
+
+	g := &Goproxy{
+		GoBinEnv: []string{
+			"GOPROXY=" + server.URL,
+			"GOSUMDB=off",
+		},
+		Cacher:          DirCacher(tempDir),
+		TempDir:         tempDir,
+		FetchTimeout:    100 * time.Millisecond,
+		ZipFetchTimeout: 10 * time.Second,
+		ErrorLogger:     log.New(&discardWriter{}, "", 0),
+	}
+
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, httptest.NewRequest(
+		http.MethodGet,
+		"/example.com/@v/list",
+		nil,
+	))
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("got duration %s", d)
+	}
+
+	if got, want := rec.Code, http.StatusNotFound; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	} else if got, want := rec.Body.String(),
+		"not found: fetch timed out"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	rec = httptest.NewRecorder()
+	g.ServeHTTP(rec, httptest.NewRequest(
+		http.MethodGet,
+		"/example.com/@v/v1.0.0.zip",
+		nil,
+	))
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	} else if got, want := rec.Body.Bytes(),
+		zipBuf.Bytes(); !bytes.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if err := g.UpdateSettings(func(s *Settings) error {
+		s.FetchTimeout = -time.Second
+		return nil
+	}); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,230 @@
+package goproxy
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UpstreamCredential is a credential attached to the requests sent to the
+// upstreams matching its [UpstreamCredential.Prefix], such as authenticated
+// Artifactory, Nexus or other private module proxies. See the
+// [Goproxy.UpstreamCredentials].
+type UpstreamCredential struct {
+	// Prefix is the host (with the port, if not the default one) of the
+	// upstreams that the UpstreamCredential applies to, optionally
+	// followed by a path prefix, such as "proxy.example.com" or
+	// "example.com/artifactory/api/go".
+	Prefix string
+
+	// Header is the extra header (e.g. the "X-JFrog-Art-Api") of the
+	// requests.
+	Header http.Header
+
+	// Username and Password are the credentials of the basic
+	// authentication of the requests.
+	//
+	// If both of them are empty, the basic authentication is not used.
+	Username string
+	Password string
+
+	// Token is the bearer token of the requests. It takes precedence over
+	// the basic authentication.
+	//
+	// If the Token is empty, the bearer authentication is not used.
+	Token string
+}
+
+// matches reports whether the uc applies to the req.
+func (uc *UpstreamCredential) matches(req *http.Request) bool {
+	prefix := strings.TrimSuffix(uc.Prefix, "/")
+	target := req.URL.Host + req.URL.EscapedPath()
+	if !strings.HasPrefix(target, prefix) {
+		return false
+	}
+
+	return len(target) == len(prefix) || target[len(prefix)] == '/'
+}
+
+// apply applies the uc to the req.
+func (uc *UpstreamCredential) apply(req *http.Request) {
+	for k, vs := range uc.Header {
+		req.Header[http.CanonicalHeaderKey(k)] = vs
+	}
+
+	if uc.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+uc.Token)
+	} else if uc.Username != "" || uc.Password != "" {
+		req.SetBasicAuth(uc.Username, uc.Password)
+	}
+}
+
+// upstreamAuthTransport is an [http.RoundTripper] that attaches the
+// [Goproxy.UpstreamCredentials] and the credentials in the
+// [Goproxy.UpstreamNetrc] to the requests sent to upstreams. Requests that
+// already carry an Authorization (e.g. from the user information of the
+// GOPROXY) are left untouched.
+type upstreamAuthTransport struct {
+	credentials []UpstreamCredential
+	netrc       *netrcFile
+	transport   http.RoundTripper
+}
+
+// RoundTrip implements the [http.RoundTripper].
+func (uat *upstreamAuthTransport) RoundTrip(
+	req *http.Request,
+) (*http.Response, error) {
+	transport := uat.transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	if req.Header.Get("Authorization") != "" {
+		return transport.RoundTrip(req)
+	}
+
+	// The longest matching prefix wins.
+	var credential *UpstreamCredential
+	for i := range uat.credentials {
+		uc := &uat.credentials[i]
+		if uc.matches(req) && (credential == nil ||
+			len(uc.Prefix) > len(credential.Prefix)) {
+			credential = uc
+		}
+	}
+
+	if credential == nil && uat.netrc != nil {
+		login, password, ok, err := uat.netrc.lookup(req.URL.Host)
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok && req.URL.Port() != "" {
+			login, password, ok, err = uat.netrc.lookup(
+				req.URL.Hostname(),
+			)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if ok {
+			credential = &UpstreamCredential{
+				Username: login,
+				Password: password,
+			}
+		}
+	}
+
+	if credential == nil {
+		return transport.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	credential.apply(req)
+
+	return transport.RoundTrip(req)
+}
+
+// netrcMachine is a machine entry of a .netrc file.
+type netrcMachine struct {
+	name     string
+	login    string
+	password string
+}
+
+// parseNetrc parses the data as the content of a .netrc file, in the same way
+// as the go command does: only the machine entries with both a login and a
+// password are returned, while the "default" entry and everything after it,
+// as well as the macro definitions, are ignored.
+func parseNetrc(data string) []netrcMachine {
+	var (
+		machines []netrcMachine
+		m        netrcMachine
+		inMacro  bool
+	)
+	for _, line := range strings.Split(data, "\n") {
+		if inMacro {
+			// Macro definitions end at the first empty line.
+			inMacro = line != ""
+			continue
+		}
+
+		fields := strings.Fields(line)
+		for i := 0; i < len(fields); i += 2 {
+			if fields[i] == "default" {
+				return machines
+			} else if i+1 == len(fields) {
+				break
+			}
+
+			switch fields[i] {
+			case "machine":
+				m = netrcMachine{name: fields[i+1]}
+			case "login":
+				m.login = fields[i+1]
+			case "password":
+				m.password = fields[i+1]
+			case "macdef":
+				inMacro = true
+			}
+
+			if m.name != "" && m.login != "" && m.password != "" {
+				machines = append(machines, m)
+				m = netrcMachine{}
+			}
+		}
+	}
+
+	return machines
+}
+
+// netrcFile is a .netrc file that is reparsed whenever it changes, so that
+// rotated credentials are picked up without a restart.
+type netrcFile struct {
+	name string
+
+	mutex    sync.Mutex
+	modTime  time.Time
+	size     int64
+	machines []netrcMachine
+}
+
+// lookup returns the login and the password of the first machine entry of the
+// nf for the host.
+func (nf *netrcFile) lookup(host string) (string, string, bool, error) {
+	nf.mutex.Lock()
+	defer nf.mutex.Unlock()
+
+	fi, err := os.Stat(nf.name)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			nf.modTime, nf.size, nf.machines = time.Time{}, 0, nil
+			return "", "", false, nil
+		}
+
+		return "", "", false, err
+	}
+
+	if !fi.ModTime().Equal(nf.modTime) || fi.Size() != nf.size {
+		b, err := ioutil.ReadFile(nf.name)
+		if err != nil {
+			return "", "", false, err
+		}
+
+		nf.modTime, nf.size = fi.ModTime(), fi.Size()
+		nf.machines = parseNetrc(string(b))
+	}
+
+	for _, m := range nf.machines {
+		if m.name == host {
+			return m.login, m.password, true, nil
+		}
+	}
+
+	return "", "", false, nil
+}
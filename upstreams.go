@@ -0,0 +1,133 @@
+package goproxy
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/module"
+)
+
+// Upstream is an upstream that the [Goproxy] consults for a module (see the
+// [Goproxy.UpstreamsFor]).
+type Upstream struct {
+	// Proxy is the URL of the module proxy or the peer, or "direct" for
+	// fetching from the version control system of the module.
+	Proxy string
+
+	// ModulePath is the module path asked of the upstream. It differs from
+	// the module path asked of the [Goproxy.UpstreamsFor] when the latter
+	// is rewritten by a [PathRewrite].
+	ModulePath string
+
+	// FallBackOnError indicates whether the next upstream is consulted
+	// when the upstream fails for any error, rather than only for the
+	// module not being found (see the "|" separator of the GOPROXY).
+	FallBackOnError bool `json:",omitempty"`
+
+	// Reason explains why the upstream is consulted, e.g. the GONOPROXY
+	// pattern or the [PathRewrite] that applies.
+	Reason string
+}
+
+// UpstreamsFor returns the upstreams that the g would consult, in order, for
+// fetching module files of the modulePath. Each is only consulted if the
+// previous ones did not find the module (or failed, see the
+// [Upstream.FallBackOnError]). The [Goproxy.Peers] are only consulted for
+// module downloads. Note that when the [Goproxy.MergeLists] is set, version
+// lists are fetched from all the module proxies at once.
+//
+// It returns an error if the modulePath is invalid or would never be fetched
+// (e.g. blocked by the [Goproxy.BlockedModuleHosts]). The returned list is
+// empty if fetching is disabled by the GOPROXY of the [Goproxy.GoBinEnv].
+func (g *Goproxy) UpstreamsFor(modulePath string) ([]Upstream, error) {
+	g.initOnce.Do(g.init)
+	if err := module.CheckPath(modulePath); err != nil {
+		return nil, err
+	} else if err := checkModuleHost(
+		g.settings().BlockedModuleHosts,
+		modulePath,
+	); err != nil {
+		return nil, err
+	}
+
+	upstreams := []Upstream{}
+	for _, peer := range g.Peers {
+		upstreams = append(upstreams, Upstream{
+			Proxy:      strings.TrimSuffix(peer, "/"),
+			ModulePath: modulePath,
+			Reason:     "peer (module downloads only)",
+		})
+	}
+
+	upstreams = append(upstreams, g.upstreamChain(modulePath, "")...)
+	if pr := g.pathRewrite(modulePath); pr != nil {
+		rewritten, err := pr.rewrite(modulePath, time.Now())
+		if err != nil {
+			return nil, err
+		}
+
+		upstreams = append(upstreams, g.upstreamChain(
+			rewritten,
+			fmt.Sprintf(" (path rewrite %q to %q)", pr.From, pr.To),
+		)...)
+	}
+
+	return upstreams, nil
+}
+
+// upstreamChain returns the upstreams consulted by the [fetch.do] for the
+// modulePath, without the [PathRewrite]s. The reasonSuffix is appended to the
+// [Upstream.Reason] of each.
+func (g *Goproxy) upstreamChain(
+	modulePath string,
+	reasonSuffix string,
+) []Upstream {
+	if pattern := globsMatchedGlob(
+		g.goBinEnvGONOPROXY,
+		modulePath,
+	); pattern != "" {
+		return []Upstream{{
+			Proxy:      "direct",
+			ModulePath: modulePath,
+			Reason: fmt.Sprintf(
+				"GONOPROXY pattern %q%s",
+				pattern,
+				reasonSuffix,
+			),
+		}}
+	}
+
+	var upstreams []Upstream
+	for goproxy := g.goBinEnvGOPROXY; goproxy != ""; {
+		var (
+			proxy           string
+			fallBackOnError bool
+		)
+
+		if i := strings.IndexAny(goproxy, ",|"); i >= 0 {
+			proxy = goproxy[:i]
+			fallBackOnError = goproxy[i] == '|'
+			goproxy = goproxy[i+1:]
+		} else {
+			proxy = goproxy
+			goproxy = ""
+		}
+
+		if proxy == "off" {
+			break
+		}
+
+		upstreams = append(upstreams, Upstream{
+			Proxy:           proxy,
+			ModulePath:      modulePath,
+			FallBackOnError: fallBackOnError && proxy != "direct",
+			Reason:          "GOPROXY" + reasonSuffix,
+		})
+		if proxy == "direct" {
+			break
+		}
+	}
+
+	return upstreams
+}
@@ -0,0 +1,136 @@
+package goproxy
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGoproxyUpstreamsFor(t *testing.T) {
+	g := &Goproxy{
+		GoBinEnv: []string{
+			"GOPROXY=https://a.example|https://b.example,direct",
+			"GOPRIVATE=corp.example.com",
+		},
+		PathRewrites: []PathRewrite{
+			{
+				From: "old.example.com/foo",
+				To:   "corp.example.com/foo",
+			},
+			{
+				From:  "gone.example.com/foo",
+				To:    "new.example.com/foo",
+				Until: time.Now().Add(-time.Hour),
+			},
+		},
+		BlockedModuleHosts: []string{"blocked.example.com"},
+		Peers:              []string{"http://10.0.0.2:8080/"},
+	}
+
+	peer := Upstream{
+		Proxy:  "http://10.0.0.2:8080",
+		Reason: "peer (module downloads only)",
+	}
+
+	for n, tt := range []struct {
+		modulePath string
+		want       []Upstream
+		wantErr    bool
+	}{
+		{
+			modulePath: "example.com/foo",
+			want: []Upstream{
+				{
+					Proxy:           "https://a.example",
+					ModulePath:      "example.com/foo",
+					FallBackOnError: true,
+					Reason:          "GOPROXY",
+				},
+				{
+					Proxy:      "https://b.example",
+					ModulePath: "example.com/foo",
+					Reason:     "GOPROXY",
+				},
+				{
+					Proxy:      "direct",
+					ModulePath: "example.com/foo",
+					Reason:     "GOPROXY",
+				},
+			},
+		},
+		{
+			modulePath: "corp.example.com/foo",
+			want: []Upstream{
+				{
+					Proxy:      "direct",
+					ModulePath: "corp.example.com/foo",
+					Reason: `GONOPROXY pattern ` +
+						`"corp.example.com"`,
+				},
+			},
+		},
+		{
+			modulePath: "old.example.com/foo/bar",
+			want: []Upstream{
+				{
+					Proxy: "https://a.example",
+					ModulePath: "old.example.com" +
+						"/foo/bar",
+					FallBackOnError: true,
+					Reason:          "GOPROXY",
+				},
+				{
+					Proxy:      "https://b.example",
+					ModulePath: "old.example.com/foo/bar",
+					Reason:     "GOPROXY",
+				},
+				{
+					Proxy:      "direct",
+					ModulePath: "old.example.com/foo/bar",
+					Reason:     "GOPROXY",
+				},
+				{
+					Proxy:      "direct",
+					ModulePath: "corp.example.com/foo/bar",
+					Reason: `GONOPROXY pattern ` +
+						`"corp.example.com" ` +
+						`(path rewrite ` +
+						`"old.example.com/foo" to ` +
+						`"corp.example.com/foo")`,
+				},
+			},
+		},
+		{modulePath: "gone.example.com/foo", wantErr: true},
+		{modulePath: "blocked.example.com/foo", wantErr: true},
+		{modulePath: "Invalid Path", wantErr: true},
+	} {
+		upstreams, err := g.UpstreamsFor(tt.modulePath)
+		if tt.wantErr {
+			if err == nil {
+				t.Fatalf("test(%d): expected error", n)
+			}
+
+			continue
+		} else if err != nil {
+			t.Fatalf("test(%d): unexpected error %q", n, err)
+		}
+
+		peer.ModulePath = tt.modulePath
+		want := append([]Upstream{peer}, tt.want...)
+		if !reflect.DeepEqual(upstreams, want) {
+			t.Errorf(
+				"test(%d): got %+v, want %+v",
+				n,
+				upstreams,
+				want,
+			)
+		}
+	}
+
+	g = &Goproxy{GoBinEnv: []string{"GOPROXY=off"}}
+	if upstreams, err := g.UpstreamsFor("example.com/foo"); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := len(upstreams), 0; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,199 @@
+package goproxy
+
+import (
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// upstreamUsageDays is the number of most recent days whose usages of the
+// upstreams are kept by a [Goproxy].
+const upstreamUsageDays = 31
+
+// UpstreamUsage is the usage of an upstream by a [Goproxy] on a day. It is for
+// attributing the egress costs of commercial mirrors.
+type UpstreamUsage struct {
+	// Upstream is the scheme and host of the upstream (e.g.
+	// "https://proxy.golang.org").
+	Upstream string
+
+	// Date is the UTC date of the usage in the form "2006-01-02".
+	Date string
+
+	// Requests is the number of requests sent to the upstream.
+	Requests int64
+
+	// Bytes is the number of bytes of the response bodies downloaded from
+	// the upstream.
+	Bytes int64
+}
+
+// upstreamUsageRecorder records the daily usages of upstreams.
+type upstreamUsageRecorder struct {
+	mutex  sync.Mutex
+	usages map[[2]string]*UpstreamUsage
+}
+
+// newUpstreamUsageRecorder returns a new instance of the
+// [upstreamUsageRecorder].
+func newUpstreamUsageRecorder() *upstreamUsageRecorder {
+	return &upstreamUsageRecorder{usages: map[[2]string]*UpstreamUsage{}}
+}
+
+// add adds the requests and the bytes to the usage of the upstream on the day
+// of the now. The usages older than the [upstreamUsageDays] are dropped.
+func (uur *upstreamUsageRecorder) add(
+	upstream string,
+	now time.Time,
+	requests int64,
+	bytes int64,
+) {
+	date := now.UTC().Format("2006-01-02")
+
+	uur.mutex.Lock()
+	defer uur.mutex.Unlock()
+	key := [2]string{upstream, date}
+	uu, ok := uur.usages[key]
+	if !ok {
+		uu = &UpstreamUsage{Upstream: upstream, Date: date}
+		uur.usages[key] = uu
+
+		oldest := now.UTC().AddDate(0, 0, 1-upstreamUsageDays)
+		oldestDate := oldest.Format("2006-01-02")
+		for k := range uur.usages {
+			if k[1] < oldestDate {
+				delete(uur.usages, k)
+			}
+		}
+	}
+
+	uu.Requests += requests
+	uu.Bytes += bytes
+}
+
+// merge adds the usages to the recorded ones. The usages older than the
+// [upstreamUsageDays] at the now are dropped.
+func (uur *upstreamUsageRecorder) merge(usages []UpstreamUsage, now time.Time) {
+	oldest := now.UTC().AddDate(0, 0, 1-upstreamUsageDays)
+	oldestDate := oldest.Format("2006-01-02")
+
+	uur.mutex.Lock()
+	defer uur.mutex.Unlock()
+	for _, u := range usages {
+		if u.Date < oldestDate {
+			continue
+		}
+
+		key := [2]string{u.Upstream, u.Date}
+		uu, ok := uur.usages[key]
+		if !ok {
+			uu = &UpstreamUsage{Upstream: u.Upstream, Date: u.Date}
+			uur.usages[key] = uu
+		}
+
+		uu.Requests += u.Requests
+		uu.Bytes += u.Bytes
+	}
+}
+
+// list returns the recorded usages, the most recent first. Usages on the same
+// day are sorted by their upstreams.
+func (uur *upstreamUsageRecorder) list() []UpstreamUsage {
+	uur.mutex.Lock()
+	defer uur.mutex.Unlock()
+	usages := make([]UpstreamUsage, 0, len(uur.usages))
+	for _, uu := range uur.usages {
+		usages = append(usages, *uu)
+	}
+
+	sort.Slice(usages, func(i, j int) bool {
+		if usages[i].Date != usages[j].Date {
+			return usages[i].Date > usages[j].Date
+		}
+
+		return usages[i].Upstream < usages[j].Upstream
+	})
+
+	return usages
+}
+
+// UpstreamUsage returns the daily usages of the upstreams (module proxies and
+// checksum databases) by the g over the last 31 days, the most recent first.
+// They are also reported by the "/-/upstream-usage" administrative endpoint,
+// and their totals by the [Goproxy.Stats].
+//
+// Note that the fetches made by the Go binary (e.g. for modules matching the
+// GONOPROXY) are not included.
+func (g *Goproxy) UpstreamUsage() []UpstreamUsage {
+	g.initOnce.Do(g.init)
+	return g.upstreamUsages.list()
+}
+
+// recordUpstreamUsage records the requests and the bytes for the upstream.
+func (g *Goproxy) recordUpstreamUsage(upstream string, requests, bytes int64) {
+	g.upstreamUsages.add(upstream, time.Now(), requests, bytes)
+	g.metrics.observeUpstreamUsage(upstream, requests, bytes)
+	g.updateStats(func(s *Stats) {
+		s.UpstreamRequests += requests
+		s.UpstreamBytes += bytes
+	})
+}
+
+// usageTransport is an [http.RoundTripper] that records the usages of the
+// upstreams via the [Goproxy.recordUpstreamUsage].
+type usageTransport struct {
+	g         *Goproxy
+	transport http.RoundTripper
+}
+
+// RoundTrip implements the [http.RoundTripper].
+func (ut *usageTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := ut.transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	upstream := req.URL.Scheme + "://" + req.URL.Host
+	ut.g.recordUpstreamUsage(upstream, 1, 0)
+
+	res, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	res.Body = &usageBody{
+		ReadCloser: res.Body,
+		record: func(n int64) {
+			ut.g.recordUpstreamUsage(upstream, 0, n)
+		},
+	}
+
+	return res, nil
+}
+
+// usageBody is a response body that records the number of bytes read from it.
+type usageBody struct {
+	io.ReadCloser
+
+	record func(n int64)
+}
+
+// Read implements the [io.Reader].
+func (ub *usageBody) Read(b []byte) (int, error) {
+	n, err := ub.ReadCloser.Read(b)
+	if n > 0 {
+		ub.record(int64(n))
+	}
+
+	return n, err
+}
+
+// serveUpstreamUsage serves upstream usage requests.
+func (g *Goproxy) serveUpstreamUsage(
+	rw http.ResponseWriter,
+	req *http.Request,
+) {
+	responseJSON(rw, req, -2, g.UpstreamUsage())
+}
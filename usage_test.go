@@ -0,0 +1,144 @@
+package goproxy
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestUpstreamUsageRecorder(t *testing.T) {
+	uur := newUpstreamUsageRecorder()
+	day := time.Date(2000, 1, 31, 12, 0, 0, 0, time.UTC)
+	uur.add("https://a.example", day.AddDate(0, 0, -31), 1, 100)
+	uur.add("https://a.example", day.AddDate(0, 0, -30), 1, 100)
+	uur.add("https://b.example", day, 1, 10)
+	uur.add("https://a.example", day, 1, 20)
+	uur.add("https://a.example", day, 1, 30)
+
+	want := []UpstreamUsage{
+		{"https://a.example", "2000-01-31", 2, 50},
+		{"https://b.example", "2000-01-31", 1, 10},
+		{"https://a.example", "2000-01-01", 1, 100},
+	}
+	got := uur.list()
+	if len(got) != len(want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got %+v, want %+v", got[i], want[i])
+		}
+	}
+}
+
+func TestUpstreamUsageRecorderMerge(t *testing.T) {
+	uur := newUpstreamUsageRecorder()
+	day := time.Date(2000, 1, 31, 12, 0, 0, 0, time.UTC)
+	uur.add("https://a.example", day, 1, 20)
+	uur.merge([]UpstreamUsage{
+		{"https://a.example", "1999-12-31", 1, 100},
+		{"https://a.example", "2000-01-01", 1, 100},
+		{"https://a.example", "2000-01-31", 2, 30},
+	}, day)
+
+	want := []UpstreamUsage{
+		{"https://a.example", "2000-01-31", 3, 50},
+		{"https://a.example", "2000-01-01", 1, 100},
+	}
+	got := uur.list()
+	if len(got) != len(want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got %+v, want %+v", got[i], want[i])
+		}
+	}
+}
+
+func TestGoproxyUpstreamUsage(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestGoproxyUpstreamUsage")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	server := httptest.NewServer(http.HandlerFunc(func(
+		rw http.ResponseWriter,
+		req *http.Request,
+	) {
+		switch req.URL.Path {
+		case "/example.com/@v/list":
+			responseString(
+				rw,
+				req,
+				http.StatusOK,
+				-2,
+				"v1.0.0\nv1.1.0",
+			)
+		default:
+			responseNotFound(rw, req, -2)
+		}
+	}))
+	defer server.Close()
+
+	g := &Goproxy{
+		GoBinEnv: []string{
+			"GOPROXY=" + server.URL,
+			"GOSUMDB=off",
+		},
+		TempDir: tempDir,
+		AdminAuthorizer: func(*http.Request) bool {
+			return true
+		},
+		ErrorLogger: log.New(&discardWriter{}, "", 0),
+	}
+	for _, target := range []string{
+		"/example.com/@v/list",
+		"/example.com/@v/list",
+		"/example.org/@v/list",
+	} {
+		req := httptest.NewRequest("", target, nil)
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, req)
+	}
+
+	stats := g.Stats()
+	if got, want := stats.UpstreamRequests, int64(3); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	if got, want := stats.UpstreamBytes, int64(2*13+9); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	req := httptest.NewRequest("", "/-/upstream-usage", nil)
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, req)
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	var usages []UpstreamUsage
+	if err := json.NewDecoder(rec.Body).Decode(&usages); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := len(usages), 1; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	if got, want := usages[0], (UpstreamUsage{
+		Upstream: server.URL,
+		Date:     time.Now().UTC().Format("2006-01-02"),
+		Requests: 3,
+		Bytes:    2*13 + 9,
+	}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,81 @@
+package goproxy
+
+import (
+	"fmt"
+	"html"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// VanityImport is a vanity import path (see
+// https://go.dev/ref/mod#vcs-find) served by the [Goproxy].
+type VanityImport struct {
+	// Prefix is the import path prefix (usually the module path) of the
+	// VanityImport, such as "example.com/foo". Its host must be the host
+	// that the Goproxy is served under.
+	Prefix string
+
+	// VCS is the version control system of the VanityImport, such as
+	// "git" or "mod".
+	VCS string
+
+	// RepoRoot is the root URL of the repository of the VanityImport, such
+	// as "https://github.com/example/foo". When the VCS is "mod", it is
+	// the URL of a module proxy serving the VanityImport.
+	RepoRoot string
+}
+
+// vanityImport returns the [VanityImport] in the [Goproxy.VanityImports] that
+// matches the import path targeted by the req. It returns nil if not found.
+func (g *Goproxy) vanityImport(req *http.Request) *VanityImport {
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	importPath := strings.TrimSuffix(
+		path.Join(strings.ToLower(host), path.Clean("/"+req.URL.Path)),
+		"/",
+	)
+
+	var matched *VanityImport
+	for i, vi := range g.VanityImports {
+		if importPath != vi.Prefix &&
+			!strings.HasPrefix(importPath, vi.Prefix+"/") {
+			continue
+		}
+
+		if matched == nil || len(vi.Prefix) > len(matched.Prefix) {
+			matched = &g.VanityImports[i]
+		}
+	}
+
+	return matched
+}
+
+// serveVanityImport serves vanity import requests.
+func (g *Goproxy) serveVanityImport(rw http.ResponseWriter, req *http.Request) {
+	vi := g.vanityImport(req)
+	if vi == nil {
+		responseNotFound(rw, req, 600, "unknown vanity import path")
+		return
+	}
+
+	responseHTML(rw, req, 600, fmt.Sprintf(`<!DOCTYPE html>
+<html>
+<head>
+<meta name="go-import" content="%s %s %s">
+</head>
+<body>
+go get %s
+</body>
+</html>
+`,
+		html.EscapeString(vi.Prefix),
+		html.EscapeString(vi.VCS),
+		html.EscapeString(vi.RepoRoot),
+		html.EscapeString(vi.Prefix),
+	))
+}
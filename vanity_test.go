@@ -0,0 +1,58 @@
+package goproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGoproxyServeVanityImport(t *testing.T) {
+	g := &Goproxy{
+		GoBinEnv: []string{},
+		VanityImports: []VanityImport{
+			{"example.com/foo", "git", "https://github.com/example/foo"},
+			{"example.com/foo/bar", "mod", "https://proxy.example.com"},
+		},
+	}
+	g.init()
+
+	for _, tt := range []struct {
+		url         string
+		wantCode    int
+		wantContent string
+	}{
+		{"http://example.com/foo?go-get=1", http.StatusOK, `<meta name="go-import" content="example.com/foo git https://github.com/example/foo">`},
+		{"http://example.com:8080/foo/baz/?go-get=1", http.StatusOK, `<meta name="go-import" content="example.com/foo git https://github.com/example/foo">`},
+		{"http://example.com/foo/bar/qux?go-get=1", http.StatusOK, `<meta name="go-import" content="example.com/foo/bar mod https://proxy.example.com">`},
+		{"http://example.com/foobar?go-get=1", http.StatusNotFound, "not found: unknown vanity import path"},
+		{"http://example.org/foo?go-get=1", http.StatusNotFound, "not found: unknown vanity import path"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, req)
+		if got, want := rec.Code, tt.wantCode; got != want {
+			t.Errorf("%s: got %d, want %d", tt.url, got, want)
+		}
+
+		if got := rec.Body.String(); !strings.Contains(got, tt.wantContent) {
+			t.Errorf("%s: got %q, want containing %q", tt.url, got, tt.wantContent)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/foo?go-get=1", nil)
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, req)
+	if got, want := rec.Code, http.StatusMethodNotAllowed; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	g = &Goproxy{GoBinEnv: []string{"GOPROXY=off"}}
+	g.init()
+	req = httptest.NewRequest(http.MethodGet, "http://example.com/foo?go-get=1", nil)
+	rec = httptest.NewRecorder()
+	g.ServeHTTP(rec, req)
+	if got, want := rec.Body.String(), "not found: missing /@v/"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,228 @@
+package goproxy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// knownVCSHosts is the map of well-known code hosting sites to the version
+// control systems they use.
+//
+// See go/src/cmd/go/internal/vcs.vcsPaths.
+var knownVCSHosts = map[string]string{
+	"github.com":        "git",
+	"bitbucket.org":     "git",
+	"hub.jazz.net":      "git",
+	"git.apache.org":    "git",
+	"git.openstack.org": "git",
+	"chiselapp.com":     "fossil",
+	"launchpad.net":     "bzr",
+}
+
+// vcsQualifiers are the version control systems that can be explicitly
+// qualified by a path element suffix of a module path (e.g. ".git").
+var vcsQualifiers = []string{"bzr", "fossil", "git", "hg", "svn"}
+
+// modulePathVCS returns the version control system that will be used to fetch
+// the modulePath directly. It returns "" if the version control system cannot
+// be determined without network access (e.g. for vanity import paths).
+func modulePathVCS(modulePath string) string {
+	elems := strings.Split(modulePath, "/")
+	for _, elem := range elems[1:] {
+		for _, vcs := range vcsQualifiers {
+			if strings.HasSuffix(elem, "."+vcs) {
+				return vcs
+			}
+		}
+	}
+
+	return knownVCSHosts[elems[0]]
+}
+
+// allowedVCSToGOVCS converts the allowedVCS to a GOVCS value (see
+// https://go.dev/ref/mod#vcs-govcs) that applies to all modules. It is for
+// letting the Go binary also enforce the allowedVCS when the version control
+// system cannot be determined in advance.
+func allowedVCSToGOVCS(allowedVCS []string) string {
+	if len(allowedVCS) == 0 {
+		return "*:off"
+	}
+
+	return fmt.Sprint("*:", strings.Join(allowedVCS, "|"))
+}
+
+// govcsRule is a rule of a GOVCS policy (see https://go.dev/ref/mod#vcs-govcs).
+type govcsRule struct {
+	// pattern is the glob pattern matched against module path prefixes,
+	// or "public" or "private".
+	pattern string
+
+	// allowedVCS is the list of the allowed version control systems. A nil
+	// allowedVCS means all version control systems are allowed.
+	allowedVCS []string
+}
+
+// defaultGOVCSRules are the rules that apply when none of the rules of a GOVCS
+// policy matches, as in the Go binary.
+var defaultGOVCSRules = []govcsRule{
+	{pattern: "public", allowedVCS: []string{"git", "hg"}},
+	{pattern: "private"},
+}
+
+// parseGOVCS parses the govcs (e.g. "github.com:git,evil.com:off,*:git|hg")
+// into its rules, followed by the [defaultGOVCSRules].
+func parseGOVCS(govcs string) ([]govcsRule, error) {
+	var rules []govcsRule
+	seen := map[string]bool{}
+	for _, item := range strings.Split(govcs, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		i := strings.Index(item, ":")
+		if i < 0 {
+			return nil, fmt.Errorf(
+				"malformed entry in GOVCS: %q",
+				item,
+			)
+		}
+
+		pattern, list := strings.TrimSpace(item[:i]), item[i+1:]
+		if pattern == "" {
+			return nil, fmt.Errorf(
+				"empty pattern in GOVCS: %q",
+				item,
+			)
+		} else if seen[pattern] {
+			return nil, fmt.Errorf(
+				"unreachable pattern in GOVCS: %q after %q",
+				item,
+				pattern,
+			)
+		}
+
+		seen[pattern] = true
+
+		rule := govcsRule{pattern: pattern}
+		switch list = strings.TrimSpace(list); list {
+		case "all":
+		case "off":
+			rule.allowedVCS = []string{}
+		default:
+			for _, vcs := range strings.Split(list, "|") {
+				vcs = strings.TrimSpace(vcs)
+				if !stringSliceContains(vcsQualifiers, vcs) {
+					return nil, fmt.Errorf(
+						"unknown VCS in GOVCS: %q",
+						vcs,
+					)
+				}
+
+				rule.allowedVCS = append(rule.allowedVCS, vcs)
+			}
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return append(rules, defaultGOVCSRules...), nil
+}
+
+// checkGOVCS checks whether the vcs is allowed to fetch the modulePath by the
+// first of the rules that matches it. The private reports whether the
+// modulePath is private (see GOPRIVATE).
+func checkGOVCS(
+	rules []govcsRule,
+	modulePath string,
+	vcs string,
+	private bool,
+) error {
+	for _, rule := range rules {
+		var matched bool
+		switch rule.pattern {
+		case "public":
+			matched = !private
+		case "private":
+			matched = private
+		default:
+			matched = globsMatchPath(rule.pattern, modulePath)
+		}
+
+		if !matched {
+			continue
+		}
+
+		if rule.allowedVCS == nil ||
+			stringSliceContains(rule.allowedVCS, vcs) {
+			return nil
+		}
+
+		visibility := "public"
+		if private {
+			visibility = "private"
+		}
+
+		return forbiddenError(fmt.Sprintf(
+			"GOVCS disallows using %s for %s %s; see 'go help vcs'",
+			vcs,
+			visibility,
+			modulePath,
+		))
+	}
+
+	return nil
+}
+
+// restrictGOVCSRules returns a copy of the rules with each of them restricted
+// to the allowedVCS.
+func restrictGOVCSRules(
+	rules []govcsRule,
+	allowedVCS []string,
+) []govcsRule {
+	restrictedRules := make([]govcsRule, 0, len(rules))
+	for _, rule := range rules {
+		restrictedRule := govcsRule{
+			pattern:    rule.pattern,
+			allowedVCS: []string{},
+		}
+		for _, vcs := range allowedVCS {
+			if rule.allowedVCS == nil ||
+				stringSliceContains(rule.allowedVCS, vcs) {
+				restrictedRule.allowedVCS = append(
+					restrictedRule.allowedVCS,
+					vcs,
+				)
+			}
+		}
+
+		restrictedRules = append(restrictedRules, restrictedRule)
+	}
+
+	return restrictedRules
+}
+
+// formatGOVCS formats the rules as a GOVCS value. Rules whose patterns have
+// already appeared are unreachable and therefore omitted.
+func formatGOVCS(rules []govcsRule) string {
+	items := make([]string, 0, len(rules))
+	seen := map[string]bool{}
+	for _, rule := range rules {
+		if seen[rule.pattern] {
+			continue
+		}
+
+		seen[rule.pattern] = true
+
+		list := strings.Join(rule.allowedVCS, "|")
+		if rule.allowedVCS == nil {
+			list = "all"
+		} else if len(rule.allowedVCS) == 0 {
+			list = "off"
+		}
+
+		items = append(items, rule.pattern+":"+list)
+	}
+
+	return strings.Join(items, ",")
+}
@@ -0,0 +1,229 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestModulePathVCS(t *testing.T) {
+	for _, tt := range []struct {
+		modulePath string
+		want       string
+	}{
+		{"github.com/foo/bar", "git"},
+		{"launchpad.net/foo", "bzr"},
+		{"example.com/foo.hg/bar", "hg"},
+		{"example.com/foo.svn", "svn"},
+		{"github.com/foo/bar.fossil", "fossil"},
+		{"example.com/foo", ""},
+		{"example.com.git", ""},
+	} {
+		if got := modulePathVCS(tt.modulePath); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.modulePath, got, tt.want)
+		}
+	}
+}
+
+func TestAllowedVCSToGOVCS(t *testing.T) {
+	if got, want := allowedVCSToGOVCS(nil), "*:off"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if got, want := allowedVCSToGOVCS(
+		[]string{"git", "hg"},
+	), "*:git|hg"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestGoproxyGoBinAllowedVCS(t *testing.T) {
+	g := &Goproxy{GoBinAllowedVCS: []string{"git"}}
+	g.init()
+	if got, want := g.goBinEnv[len(g.goBinEnv)-1],
+		"GOVCS=*:git"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	f, err := newFetch(g, "launchpad.net/foo/@v/list", "")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if _, err := f.doDirect(context.Background()); err == nil {
+		t.Fatal("expected error")
+	} else if got, want := err.Error(), "GOVCS disallows using bzr for "+
+		"public launchpad.net/foo"; !strings.Contains(got, want) {
+		t.Errorf("got %q, want to contain %q", got, want)
+	}
+}
+
+func TestParseGOVCS(t *testing.T) {
+	rules, err := parseGOVCS("github.com:git, evil.com:off,*:git|hg")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if got, want := len(rules), 3+len(defaultGOVCSRules); got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	if got, want := rules[1].pattern, "evil.com"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	} else if rules[1].allowedVCS == nil || len(rules[1].allowedVCS) != 0 {
+		t.Errorf("got %v, want none", rules[1].allowedVCS)
+	}
+
+	if got, want := strings.Join(rules[2].allowedVCS, "|"),
+		"git|hg"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	for _, govcs := range []string{
+		"github.com",
+		":git",
+		"github.com:cvs",
+		"github.com:git,github.com:hg",
+	} {
+		if _, err := parseGOVCS(govcs); err == nil {
+			t.Errorf("%s: expected error", govcs)
+		}
+	}
+}
+
+func TestCheckGOVCS(t *testing.T) {
+	rules, err := parseGOVCS("example.com:hg,corp.example.com:all")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	for _, tt := range []struct {
+		modulePath string
+		vcs        string
+		private    bool
+		wantErr    bool
+	}{
+		{"example.com/foo.hg", "hg", false, false},
+		{"example.com/foo.git", "git", false, true},
+		{"corp.example.com/foo.svn", "svn", true, false},
+		{"github.com/foo/bar", "git", false, false},
+		{"launchpad.net/foo", "bzr", false, true},
+		{"launchpad.net/foo", "bzr", true, false},
+	} {
+		err := checkGOVCS(rules, tt.modulePath, tt.vcs, tt.private)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected error", tt.modulePath)
+			} else if !errors.Is(err, errForbidden) {
+				t.Errorf(
+					"%s: got %q, want errors.Is(err, "+
+						"errForbidden)",
+					tt.modulePath,
+					err,
+				)
+			}
+		} else if err != nil {
+			t.Errorf("%s: unexpected error %q", tt.modulePath, err)
+		}
+	}
+}
+
+func TestGoproxyGoBinVCS(t *testing.T) {
+	g := &Goproxy{
+		GoBinEnv: []string{
+			"GOPRIVATE=corp.example.com",
+			"GOVCS=*:hg",
+		},
+		GoBinVCS: "public:git,private:all",
+	}
+	g.init()
+	if got, want := g.goBinEnv[len(g.goBinEnv)-1],
+		"GOVCS=public:git,private:all"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	f, err := newFetch(g, "launchpad.net/foo/@v/list", "")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if _, err := f.doDirect(context.Background()); err == nil {
+		t.Fatal("expected error")
+	} else if got, want := err.Error(), "GOVCS disallows using bzr for "+
+		"public launchpad.net/foo"; !strings.Contains(got, want) {
+		t.Errorf("got %q, want to contain %q", got, want)
+	} else if !errors.Is(err, errForbidden) {
+		t.Errorf("got %q, want errors.Is(err, errForbidden)", err)
+	}
+
+	f, err = newFetch(g, "corp.example.com/foo.svn/@v/list", "")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if err := f.checkGoBinVCS(); err != nil {
+		t.Errorf("unexpected error %q", err)
+	}
+
+	g = &Goproxy{
+		GoBinEnv:        []string{"GOPRIVATE=corp.example.com"},
+		GoBinAllowedVCS: []string{"git", "svn"},
+		GoBinVCS:        "example.com:hg,public:git|hg",
+	}
+	g.init()
+	var govcs []string
+	for _, env := range g.goBinEnv {
+		if strings.HasPrefix(env, "GOVCS=") {
+			govcs = append(govcs, env)
+		}
+	}
+
+	if got, want := strings.Join(govcs, " "),
+		"GOVCS=example.com:off,public:git,private:git|svn"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	f, err = newFetch(g, "corp.example.com/foo.svn/@v/list", "")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if err := f.checkGoBinVCS(); err != nil {
+		t.Errorf("unexpected error %q", err)
+	}
+
+	f, err = newFetch(g, "example.com/foo.hg/@v/list", "")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if err := f.checkGoBinVCS(); err == nil {
+		t.Error("expected error")
+	}
+
+	// The Go binary classifies private modules the same way.
+	g = &Goproxy{
+		GoBinEnv: []string{"GOPRIVATE=corp.example.com"},
+		GoBinVCS: "private:svn",
+	}
+	g.init()
+	for _, want := range []string{
+		"GOPRIVATE=corp.example.com",
+		"GOVCS=private:svn",
+	} {
+		if !stringSliceContains(g.goBinEnv, want) {
+			t.Errorf("got %q, want to contain %q", g.goBinEnv, want)
+		}
+	}
+
+	f, err = newFetch(g, "corp.example.com/foo.svn/@v/list", "")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if err := f.checkGoBinVCS(); err != nil {
+		t.Errorf("unexpected error %q", err)
+	}
+
+	g = &Goproxy{GoBinEnv: []string{"GOVCS=invalid"}}
+	g.init()
+	f, err = newFetch(g, "github.com/foo/bar/@v/list", "")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if err := f.checkGoBinVCS(); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,120 @@
+package goproxy
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+)
+
+// goproxyModulePath is the module path of the Goproxy itself.
+const goproxyModulePath = "github.com/Coopermasaaki/goproxy"
+
+// BuildInfo is the build information of a [Goproxy].
+type BuildInfo struct {
+	// Version is the version of the module of the Goproxy, or "devel" if
+	// it is unknown (e.g. when built from a working tree).
+	Version string
+
+	// GoVersion is the version of Go that built the binary.
+	GoVersion string
+
+	// MainModule is the path of the main module of the binary, which is
+	// the module of the Goproxy itself unless it is used as a library.
+	MainModule string `json:",omitempty"`
+
+	// InstanceID is the effective [Goproxy.InstanceID].
+	InstanceID string
+
+	// UserAgent is the effective [Goproxy.UserAgent].
+	UserAgent string
+}
+
+// buildVersion returns the version of the module of the Goproxy and the path of
+// the main module of the binary, as recorded in its build information.
+func buildVersion() (string, string) {
+	version := "devel"
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return version, ""
+	}
+
+	m := &bi.Main
+	if m.Path != goproxyModulePath {
+		m = nil
+		for _, dep := range bi.Deps {
+			if dep.Path == goproxyModulePath {
+				m = dep
+				break
+			}
+		}
+	}
+
+	if m != nil && m.Version != "" && m.Version != "(devel)" {
+		version = m.Version
+	}
+
+	return version, bi.Main.Path
+}
+
+// defaultInstanceID returns the default [Goproxy.InstanceID].
+func defaultInstanceID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+
+	return hostname
+}
+
+// defaultUserAgent returns the default [Goproxy.UserAgent] for the version and
+// the instanceID.
+func defaultUserAgent(version, instanceID string) string {
+	if instanceID == "" {
+		return fmt.Sprint("goproxy/", version)
+	}
+
+	return fmt.Sprintf("goproxy/%s (instance %s)", version, instanceID)
+}
+
+// BuildInfo returns the build information of the g. It is also reported by
+// the "/-/version" administrative endpoint.
+func (g *Goproxy) BuildInfo() BuildInfo {
+	g.initOnce.Do(g.init)
+	version, mainModule := buildVersion()
+	return BuildInfo{
+		Version:    version,
+		GoVersion:  runtime.Version(),
+		MainModule: mainModule,
+		InstanceID: g.instanceID,
+		UserAgent:  g.userAgent,
+	}
+}
+
+// userAgentTransport is an [http.RoundTripper] that sets the User-Agent of the
+// requests sent to upstreams.
+type userAgentTransport struct {
+	userAgent string
+	transport http.RoundTripper
+}
+
+// RoundTrip implements the [http.RoundTripper].
+func (uat *userAgentTransport) RoundTrip(
+	req *http.Request,
+) (*http.Response, error) {
+	transport := uat.transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", uat.userAgent)
+
+	return transport.RoundTrip(req)
+}
+
+// serveVersion serves version requests.
+func (g *Goproxy) serveVersion(rw http.ResponseWriter, req *http.Request) {
+	responseJSON(rw, req, -2, g.BuildInfo())
+}
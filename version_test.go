@@ -0,0 +1,100 @@
+package goproxy
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+)
+
+func TestDefaultUserAgent(t *testing.T) {
+	for _, tt := range []struct {
+		version    string
+		instanceID string
+		want       string
+	}{
+		{"v1.0.0", "", "goproxy/v1.0.0"},
+		{"devel", "host-1", "goproxy/devel (instance host-1)"},
+	} {
+		got := defaultUserAgent(tt.version, tt.instanceID)
+		if got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestGoproxyUserAgent(t *testing.T) {
+	var userAgent string
+	server := httptest.NewServer(http.HandlerFunc(func(
+		rw http.ResponseWriter,
+		req *http.Request,
+	) {
+		userAgent = req.Header.Get("User-Agent")
+	}))
+	defer server.Close()
+
+	g := &Goproxy{InstanceID: "host-1"}
+	g.initOnce.Do(g.init)
+	res, err := g.httpClient.Get(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	res.Body.Close()
+
+	version, _ := buildVersion()
+	if want := defaultUserAgent(version, "host-1"); userAgent != want {
+		t.Errorf("got %q, want %q", userAgent, want)
+	}
+
+	g = &Goproxy{UserAgent: "example/1.0"}
+	g.initOnce.Do(g.init)
+	res, err = g.httpClient.Get(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	res.Body.Close()
+
+	if want := "example/1.0"; userAgent != want {
+		t.Errorf("got %q, want %q", userAgent, want)
+	}
+}
+
+func TestGoproxyServeVersion(t *testing.T) {
+	g := &Goproxy{
+		InstanceID: "host-1",
+		UserAgent:  "example/1.0",
+		AdminAuthorizer: func(*http.Request) bool {
+			return true
+		},
+		ErrorLogger: log.New(&discardWriter{}, "", 0),
+	}
+
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, httptest.NewRequest("", "/-/version", nil))
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	var bi BuildInfo
+	if err := json.NewDecoder(rec.Body).Decode(&bi); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if got, want := bi.InstanceID, "host-1"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if got, want := bi.UserAgent, "example/1.0"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if got, want := bi.GoVersion, runtime.Version(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if bi.Version == "" {
+		t.Error("expected non-empty version")
+	}
+}
@@ -0,0 +1,455 @@
+package goproxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+const (
+	// warmModulesName is the name of the cache that persists the modules
+	// observed to be kept warm.
+	warmModulesName = apiPathPrefix + "warm-modules.json"
+
+	// warmModulesExpiration is the expiration of the cache that persists
+	// the modules observed to be kept warm. It is rewritten on every
+	// change.
+	warmModulesExpiration = 100 * 365 * 24 * time.Hour
+
+	// maxWarmModules is the maximum number of module release series kept
+	// warm. Observations beyond it are ignored.
+	maxWarmModules = 10000
+
+	// maxWarmObservationBytes is the maximum size of the content of a warm
+	// request.
+	maxWarmObservationBytes = 10 << 20
+)
+
+// WarmModule is a release series (a major and minor version) of a module
+// observed (e.g. in the dependencies of recent CI runs) to be kept warm, with
+// its latest patch release, in the caches of a [Goproxy].
+type WarmModule struct {
+	// Path is the module path.
+	Path string
+
+	// Observed is the greatest version of the series observed.
+	Observed string
+
+	// Latest is the latest patch release of the series as of the last
+	// round of a [Warmer]. It is empty if no round has resolved it yet.
+	Latest string `json:",omitempty"`
+
+	// LastObserved is the time the series was last observed.
+	LastObserved time.Time
+}
+
+// warmModuleSet is the set of [WarmModule]s of a [Goproxy], keyed by their
+// module paths and series. It is safe for concurrent use.
+type warmModuleSet struct {
+	mutex     sync.Mutex
+	modules   map[string]*WarmModule
+	saveMutex sync.Mutex
+}
+
+// newWarmModuleSet returns a new instance of the [warmModuleSet].
+func newWarmModuleSet() *warmModuleSet {
+	return &warmModuleSet{modules: map[string]*WarmModule{}}
+}
+
+// observe records that the modulePath at the moduleVersion was observed at the
+// now. It reports whether the observation has been recorded.
+func (wms *warmModuleSet) observe(
+	modulePath string,
+	moduleVersion string,
+	now time.Time,
+) bool {
+	key := modulePath + "@" + semver.MajorMinor(moduleVersion)
+
+	wms.mutex.Lock()
+	defer wms.mutex.Unlock()
+	wm, ok := wms.modules[key]
+	if !ok {
+		if len(wms.modules) >= maxWarmModules {
+			return false
+		}
+
+		wm = &WarmModule{Path: modulePath, Observed: moduleVersion}
+		wms.modules[key] = wm
+	} else if semver.Compare(moduleVersion, wm.Observed) > 0 {
+		wm.Observed = moduleVersion
+	}
+
+	if now.After(wm.LastObserved) {
+		wm.LastObserved = now
+	}
+
+	return true
+}
+
+// merge merges the wms2 into the wms, keeping the most recent observations.
+func (wms *warmModuleSet) merge(wms2 []WarmModule) {
+	for _, wm2 := range wms2 {
+		if !wms.observe(wm2.Path, wm2.Observed, wm2.LastObserved) {
+			continue
+		}
+
+		wms.mutex.Lock()
+		key := wm2.Path + "@" + semver.MajorMinor(wm2.Observed)
+		if wm := wms.modules[key]; wm.Latest == "" {
+			wm.Latest = wm2.Latest
+		}
+		wms.mutex.Unlock()
+	}
+}
+
+// prune removes the series last observed before the oldest.
+func (wms *warmModuleSet) prune(oldest time.Time) {
+	wms.mutex.Lock()
+	defer wms.mutex.Unlock()
+	for key, wm := range wms.modules {
+		if wm.LastObserved.Before(oldest) {
+			delete(wms.modules, key)
+		}
+	}
+}
+
+// setLatest sets the latest patch release of the series of the wm.
+func (wms *warmModuleSet) setLatest(wm WarmModule, latest string) {
+	wms.mutex.Lock()
+	defer wms.mutex.Unlock()
+	key := wm.Path + "@" + semver.MajorMinor(wm.Observed)
+	if wm, ok := wms.modules[key]; ok {
+		wm.Latest = latest
+	}
+}
+
+// list returns the series of the wms, sorted by their module paths and
+// versions.
+func (wms *warmModuleSet) list() []WarmModule {
+	wms.mutex.Lock()
+	defer wms.mutex.Unlock()
+	list := make([]WarmModule, 0, len(wms.modules))
+	for _, wm := range wms.modules {
+		list = append(list, *wm)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Path != list[j].Path {
+			return list[i].Path < list[j].Path
+		}
+
+		return semver.Compare(list[i].Observed, list[j].Observed) < 0
+	})
+
+	return list
+}
+
+// parseObservedModules parses the module versions observed in the content,
+// which may be a go.sum, a go.mod, the output of "go list -m all", or a list
+// of "path@version" lines. Pseudo-versions are skipped since they do not
+// belong to any release series.
+func parseObservedModules(content io.Reader) ([]module.Version, error) {
+	var mvs []module.Version
+	s := bufio.NewScanner(content)
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) > 0 && fields[0] == "require" {
+			fields = fields[1:]
+		}
+
+		if len(fields) > 0 && strings.Contains(fields[0], "@") {
+			fields = strings.SplitN(fields[0], "@", 2)
+		}
+
+		if len(fields) < 2 {
+			continue
+		}
+
+		mv := module.Version{
+			Path:    fields[0],
+			Version: strings.TrimSuffix(fields[1], "/go.mod"),
+		}
+		if module.Check(mv.Path, mv.Version) != nil ||
+			module.IsPseudoVersion(mv.Version) {
+			continue
+		}
+
+		mvs = append(mvs, mv)
+	}
+
+	return mvs, s.Err()
+}
+
+// ObserveModules records the module versions observed in the content (e.g. the
+// go.sum of a recent CI run, see the "/-/warm" administrative endpoint), so
+// that the latest patch releases of their release series are kept warm in the
+// caches by a [Warmer]. It returns the number of module versions observed.
+func (g *Goproxy) ObserveModules(content io.Reader) (int, error) {
+	g.initOnce.Do(g.init)
+	mvs, err := parseObservedModules(content)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	var n int
+	for _, mv := range mvs {
+		if g.warmModules.observe(mv.Path, mv.Version, now) {
+			n++
+		}
+	}
+
+	g.saveWarmModules()
+	return n, nil
+}
+
+// WarmModules returns the release series of modules kept warm in the caches of
+// the g (see the [Goproxy.ObserveModules]).
+func (g *Goproxy) WarmModules() []WarmModule {
+	g.initOnce.Do(g.init)
+	return g.warmModules.list()
+}
+
+// loadWarmModules merges the warm modules persisted in the g.Cacher.
+func (g *Goproxy) loadWarmModules(ctx context.Context) error {
+	b, err := g.cacheBytes(ctx, warmModulesName)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return err
+	}
+
+	var wms []WarmModule
+	if err := json.Unmarshal(b, &wms); err != nil {
+		return err
+	}
+
+	g.warmModules.merge(wms)
+	return nil
+}
+
+// saveWarmModules persists the warm modules of the g into the g.Cacher.
+// Failures are logged.
+func (g *Goproxy) saveWarmModules() {
+	if g.Cacher == nil {
+		return
+	}
+
+	g.warmModules.saveMutex.Lock()
+	defer g.warmModules.saveMutex.Unlock()
+	b, err := json.Marshal(g.warmModules.list())
+	if err != nil {
+		g.logErrorf("failed to marshal warm modules: %v", err)
+		return
+	}
+
+	if err := g.Cacher.Put(
+		context.Background(),
+		warmModulesName,
+		bytes.NewReader(b),
+		warmModulesExpiration,
+	); err != nil {
+		g.logErrorf("failed to save warm modules: %v", err)
+	}
+}
+
+// serveWarm serves warm requests. A POST request observes the modules in its
+// body, or in the artifact targeted by its "url" query parameter.
+func (g *Goproxy) serveWarm(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		responseJSON(rw, req, -2, g.WarmModules())
+		return
+	}
+
+	var content io.Reader = http.MaxBytesReader(
+		rw,
+		req.Body,
+		maxWarmObservationBytes,
+	)
+	if artifactURL := req.URL.Query().Get("url"); artifactURL != "" {
+		var buf bytes.Buffer
+		if err := httpGet(
+			req.Context(),
+			g.httpClient,
+			artifactURL,
+			&buf,
+		); err != nil {
+			g.serveAdminError(rw, req, "scrape artifact", err)
+			return
+		}
+
+		content = &buf
+	}
+
+	n, err := g.ObserveModules(content)
+	if err != nil {
+		g.serveAdminError(rw, req, "observe modules", err)
+		return
+	}
+
+	responseJSON(rw, req, -2, struct{ Observed int }{n})
+}
+
+// Warmer keeps the latest patch releases of the release series of modules
+// observed by a [Goproxy] (see the [Goproxy.ObserveModules]) warm in its
+// caches, so that builds never wait for them to be fetched from upstream.
+type Warmer struct {
+	// Goproxy is the [Goproxy] whose caches are kept warm.
+	Goproxy *Goproxy
+
+	// Interval is the interval between two rounds.
+	//
+	// If the Interval is zero, one hour is used.
+	Interval time.Duration
+
+	// Retention is the duration for which an observed release series is
+	// kept warm after it was last observed.
+	//
+	// If the Retention is zero, seven days are used.
+	Retention time.Duration
+}
+
+// Run loads the modules persisted by the [Warmer.Goproxy] and runs the w
+// periodically until the ctx is done.
+func (w *Warmer) Run(ctx context.Context) error {
+	g := w.Goproxy
+	g.initOnce.Do(g.init)
+	if err := g.loadWarmModules(ctx); err != nil {
+		g.logErrorf("failed to load warm modules: %v", err)
+	}
+
+	ticker := time.NewTicker(w.interval())
+	defer ticker.Stop()
+	for {
+		if err := w.Warm(ctx); err != nil &&
+			!errors.Is(err, ctx.Err()) {
+			g.logErrorf("failed to warm modules: %v", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// interval returns the interval between two rounds of the w.
+func (w *Warmer) interval() time.Duration {
+	if w.Interval == 0 {
+		return time.Hour
+	}
+
+	return w.Interval
+}
+
+// Warm runs a single round of the w. Failures to warm a module are logged
+// instead of being returned.
+func (w *Warmer) Warm(ctx context.Context) error {
+	g := w.Goproxy
+	g.initOnce.Do(g.init)
+	ctx = withBulk(ctx)
+
+	retention := w.Retention
+	if retention == 0 {
+		retention = 7 * 24 * time.Hour
+	}
+
+	g.warmModules.prune(time.Now().Add(-retention))
+	for _, wm := range g.warmModules.list() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := w.warm(ctx, wm); err != nil {
+			g.logErrorf(
+				"failed to warm module: %s@%s: %v",
+				wm.Path,
+				semver.MajorMinor(wm.Observed),
+				err,
+			)
+		}
+	}
+
+	g.saveWarmModules()
+	return nil
+}
+
+// warm warms the latest patch release of the series of the wm. Module files
+// already cached have their expirations renewed so that they stay warm until
+// the next round.
+func (w *Warmer) warm(ctx context.Context, wm WarmModule) error {
+	g := w.Goproxy
+	escapedModulePath, err := module.EscapePath(wm.Path)
+	if err != nil {
+		return err
+	}
+
+	versions, err := g.moduleVersions(ctx, escapedModulePath)
+	if err != nil {
+		return err
+	}
+
+	latest := wm.Observed
+	for _, v := range versions {
+		if semver.MajorMinor(v) == semver.MajorMinor(latest) &&
+			semver.Prerelease(v) == "" &&
+			semver.Compare(v, latest) > 0 {
+			latest = v
+		}
+	}
+
+	g.warmModules.setLatest(wm, latest)
+
+	escapedModuleVersion, err := module.EscapeVersion(latest)
+	if err != nil {
+		return err
+	}
+
+	expiration := g.versionCacheExpiration(defaultCacheExpiration, latest)
+	if min := 2 * w.interval(); expiration < min {
+		expiration = min
+	}
+
+	for _, ext := range []string{".info", ".mod", ".zip"} {
+		name := fmt.Sprint(
+			escapedModulePath,
+			"/@v/",
+			escapedModuleVersion,
+			ext,
+		)
+		err := g.copyCache(ctx, name, name, expiration)
+		if errors.Is(err, os.ErrNotExist) {
+			err = g.prefetch(ctx, name, expiration)
+			if err != nil {
+				return err
+			}
+
+			g.updateStats(func(s *Stats) { s.WarmFetches++ })
+		} else if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,249 @@
+package goproxy
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseObservedModules(t *testing.T) {
+	mvs, err := parseObservedModules(strings.NewReader(`
+module example.com/foo
+
+go 1.21
+
+require (
+	example.com/a v1.0.0
+	example.com/b v1.2.3 // indirect
+	example.com/c v0.0.0-20000101000000-000000000000
+)
+
+require example.com/d v2.0.0+incompatible
+replace example.com/e => example.com/f v1.0.0
+example.com/g v1.1.0 h1:AAAA=
+example.com/g v1.1.0/go.mod h1:BBBB=
+example.com/h@v1.0.0-rc.1
+example.com/i
+invalid v1.0.0
+`))
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	var got []string
+	for _, mv := range mvs {
+		got = append(got, mv.String())
+	}
+
+	want := []string{
+		"example.com/a@v1.0.0",
+		"example.com/b@v1.2.3",
+		"example.com/d@v2.0.0+incompatible",
+		"example.com/g@v1.1.0",
+		"example.com/g@v1.1.0",
+		"example.com/h@v1.0.0-rc.1",
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestWarmModuleSet(t *testing.T) {
+	wms := newWarmModuleSet()
+	now := time.Now()
+	wms.observe("example.com", "v1.0.0", now.Add(-time.Hour))
+	wms.observe("example.com", "v1.0.2", now.Add(-2*time.Hour))
+	wms.observe("example.com", "v1.1.0", now.Add(-3*time.Hour))
+	wms.observe("example.org", "v1.0.0", now.Add(-4*time.Hour))
+
+	list := wms.list()
+	if got, want := len(list), 3; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	if got, want := list[0].Observed, "v1.0.2"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	got, want := list[0].LastObserved, now.Add(-time.Hour)
+	if !got.Equal(want) {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	wms.setLatest(list[0], "v1.0.3")
+	wms.prune(now.Add(-150 * time.Minute))
+	list = wms.list()
+	if got, want := len(list), 1; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	wms2 := newWarmModuleSet()
+	wms2.merge(list)
+	if got, want := wms2.list()[0], list[0]; got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestWarmer(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestWarmer")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	var zipBuf bytes.Buffer
+	zw := zip.NewWriter(&zipBuf)
+	w, err := zw.Create("example.com@v1.0.1/go.mod")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if _, err := w.Write([]byte("module example.com")); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if err := zw.Close(); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(
+		rw http.ResponseWriter,
+		req *http.Request,
+	) {
+		switch req.URL.Path {
+		case "/example.com/@v/list":
+			fmt.Fprint(rw, "v1.0.0\nv1.0.1\nv1.0.2-rc.1\nv1.1.0")
+		case "/example.com/@v/v1.0.1.info":
+			fmt.Fprint(rw, `{"Version":"v1.0.1","Time":"2000-01-01T00:00:00Z"}`)
+		case "/example.com/@v/v1.0.1.mod":
+			fmt.Fprint(rw, "module example.com")
+		case "/example.com/@v/v1.0.1.zip":
+			rw.Write(zipBuf.Bytes())
+		case "/go.sum":
+			fmt.Fprint(rw, "example.com v1.0.0 h1:AAAA=\n")
+		default:
+			responseNotFound(rw, req, -2)
+		}
+	}))
+	defer server.Close()
+
+	newGoproxy := func() *Goproxy {
+		return &Goproxy{
+			GoBinEnv: []string{
+				"GOPROXY=" + server.URL,
+				"GOSUMDB=off",
+			},
+			Cacher:  DirCacher(tempDir),
+			TempDir: tempDir,
+			AdminAuthorizer: func(*http.Request) bool {
+				return true
+			},
+			ErrorLogger: log.New(&discardWriter{}, "", 0),
+		}
+	}
+
+	g := newGoproxy()
+	req := httptest.NewRequest(
+		http.MethodPost,
+		"/-/warm?url="+server.URL+"/go.sum",
+		nil,
+	)
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, req)
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	var r struct{ Observed int }
+	if err := json.NewDecoder(rec.Body).Decode(&r); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := r.Observed, 1; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	req = httptest.NewRequest(
+		http.MethodPost,
+		"/-/warm",
+		strings.NewReader("example.org@v1.0.0\n"),
+	)
+	rec = httptest.NewRecorder()
+	g.ServeHTTP(rec, req)
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	req = httptest.NewRequest(
+		http.MethodPost,
+		"/-/warm?url="+server.URL+"/missing",
+		nil,
+	)
+	rec = httptest.NewRecorder()
+	g.ServeHTTP(rec, req)
+	if got, want := rec.Code, http.StatusNotFound; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	wr := &Warmer{Goproxy: g}
+	if err := wr.Warm(context.Background()); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	for _, ext := range []string{".info", ".mod", ".zip"} {
+		content, err := g.cache(
+			context.Background(),
+			"example.com/@v/v1.0.1"+ext,
+		)
+		if err != nil {
+			t.Fatalf("%s: unexpected error %q", ext, err)
+		}
+		content.Close()
+	}
+
+	warmFetches := g.Stats().WarmFetches
+	if warmFetches == 0 {
+		t.Error("expected non-zero warm fetches")
+	}
+
+	if err := wr.Warm(context.Background()); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if got, want := g.Stats().WarmFetches, warmFetches; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	g = newGoproxy()
+	g.initOnce.Do(g.init)
+	if err := g.loadWarmModules(context.Background()); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	req = httptest.NewRequest("", "/-/warm", nil)
+	rec = httptest.NewRecorder()
+	g.ServeHTTP(rec, req)
+
+	var wms []WarmModule
+	if err := json.NewDecoder(rec.Body).Decode(&wms); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := len(wms), 2; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	if got, want := wms[0].Latest, "v1.0.1"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if got, want := wms[1].Path, "example.org"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,307 @@
+package goproxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+const (
+	// defaultWatchTimeout is the default maximum amount of time a watch
+	// request is held open waiting for new versions.
+	defaultWatchTimeout = time.Minute
+
+	// maxWatchTimeout is the maximum amount of time a watch request is held
+	// open waiting for new versions.
+	maxWatchTimeout = 10 * time.Minute
+)
+
+// watchPollInterval is the interval at which the upstream version lists of the
+// watched modules are polled. It is a variable so that tests can shorten it.
+var watchPollInterval = 30 * time.Second
+
+// watchResult is the result of a long-polled watch request.
+type watchResult struct {
+	Module   string
+	Versions []string
+}
+
+// watchEvent is the data of a server-sent event of a streamed watch request.
+type watchEvent struct {
+	Module  string
+	Version string
+}
+
+// versionWatchers is the set of the watchers of new versions of modules. It is
+// safe for concurrent use.
+type versionWatchers struct {
+	mutex    sync.Mutex
+	watchers map[string]map[chan string]struct{}
+}
+
+// newVersionWatchers returns a new instance of the [versionWatchers].
+func newVersionWatchers() *versionWatchers {
+	return &versionWatchers{
+		watchers: map[string]map[chan string]struct{}{},
+	}
+}
+
+// watch returns a channel receiving the versions of the module targeted by the
+// modulePath as they are cached, and a function that stops the watch.
+func (vw *versionWatchers) watch(modulePath string) (<-chan string, func()) {
+	ch := make(chan string, 16)
+
+	vw.mutex.Lock()
+	defer vw.mutex.Unlock()
+	if vw.watchers[modulePath] == nil {
+		vw.watchers[modulePath] = map[chan string]struct{}{}
+	}
+
+	vw.watchers[modulePath][ch] = struct{}{}
+
+	return ch, func() {
+		vw.mutex.Lock()
+		defer vw.mutex.Unlock()
+		delete(vw.watchers[modulePath], ch)
+		if len(vw.watchers[modulePath]) == 0 {
+			delete(vw.watchers, modulePath)
+		}
+	}
+}
+
+// notify notifies the watchers of the module targeted by the modulePath that
+// the version has been cached. Watchers that are too slow to keep up miss the
+// notification, but catch up via their upstream polls.
+func (vw *versionWatchers) notify(modulePath, version string) {
+	vw.mutex.Lock()
+	defer vw.mutex.Unlock()
+	for ch := range vw.watchers[modulePath] {
+		select {
+		case ch <- version:
+		default:
+		}
+	}
+}
+
+// notifyCachedVersion notifies the watchers of the module version targeted by
+// the name of a cache that has just been put.
+func (g *Goproxy) notifyCachedVersion(name string) {
+	if !strings.HasSuffix(name, ".info") {
+		return
+	}
+
+	modulePath, moduleVersion, ok := parseModuleVersionName(name)
+	if ok && !module.IsPseudoVersion(moduleVersion) {
+		g.versionWatchers.notify(modulePath, moduleVersion)
+	}
+}
+
+// moduleVersions returns the versions of the module targeted by the
+// escapedModulePath, fetched from upstream or, if that fails, from the cached
+// "@v/list".
+func (g *Goproxy) moduleVersions(
+	ctx context.Context,
+	escapedModulePath string,
+) ([]string, error) {
+	tempDir, err := ioutil.TempDir(g.TempDir, "goproxy")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tempDir)
+
+	name := fmt.Sprint(escapedModulePath, "/@v/list")
+	f, err := newFetch(g, name, tempDir)
+	if err != nil {
+		return nil, err
+	}
+
+	fr, fetchErr := f.do(ctx)
+	if fetchErr == nil {
+		return fr.Versions, nil
+	}
+
+	b, err := g.cacheBytes(ctx, name)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fetchErr
+		}
+
+		return nil, err
+	}
+
+	var versions []string
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && semver.IsValid(fields[0]) {
+			versions = append(versions, fields[0])
+		}
+	}
+
+	return versions, nil
+}
+
+// serveWatch serves watch requests, which wait for new versions of the module
+// given by the "module" query parameter to appear in the caches or upstream.
+//
+// The versions greater than the one given by the "after" query parameter are
+// new, or, if there is none, those unknown when the request was received.
+//
+// By default, the request is long-polled: it is responded with the new
+// versions as soon as there are any, or with none once the "timeout" query
+// parameter (in seconds, 60 by default) has elapsed. If the request accepts
+// the "text/event-stream", each new version is streamed as a server-sent
+// event until the client disconnects.
+func (g *Goproxy) serveWatch(rw http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	modulePath := query.Get("module")
+	escapedModulePath, err := module.EscapePath(modulePath)
+	if err != nil {
+		responseNotFound(rw, req, -2, "invalid module path")
+		return
+	}
+
+	after := query.Get("after")
+	if after != "" && !semver.IsValid(after) {
+		responseNotFound(rw, req, -2, "invalid after version")
+		return
+	}
+
+	timeout := defaultWatchTimeout
+	if s := query.Get("timeout"); s != "" {
+		seconds, err := strconv.Atoi(s)
+		if err != nil || seconds <= 0 {
+			responseNotFound(rw, req, -2, "invalid timeout")
+			return
+		}
+
+		timeout = time.Duration(seconds) * time.Second
+		if timeout > maxWatchTimeout {
+			timeout = maxWatchTimeout
+		}
+	}
+
+	accept := req.Header.Get("Accept")
+	stream := strings.Contains(accept, "text/event-stream")
+	flusher, _ := rw.(http.Flusher)
+	if stream && flusher == nil {
+		responseString(
+			rw,
+			req,
+			http.StatusNotAcceptable,
+			-2,
+			"streaming not supported",
+		)
+		return
+	}
+
+	ch, stop := g.versionWatchers.watch(modulePath)
+	defer stop()
+
+	seen := map[string]bool{}
+	newVersions := func(versions ...string) []string {
+		var nvs []string
+		for _, v := range versions {
+			if !seen[v] {
+				seen[v] = true
+				if after == "" || semver.Compare(v, after) > 0 {
+					nvs = append(nvs, v)
+				}
+			}
+		}
+
+		return nvs
+	}
+
+	poll := func() []string {
+		versions, err := g.moduleVersions(
+			req.Context(),
+			escapedModulePath,
+		)
+		if err != nil {
+			return nil
+		}
+
+		return newVersions(versions...)
+	}
+
+	var pending []string
+	if after == "" {
+		poll()
+	} else {
+		pending = poll()
+	}
+
+	if stream {
+		rw.Header().Set("Content-Type", "text/event-stream")
+		setResponseCacheControlHeader(rw, -2)
+		rw.WriteHeader(http.StatusOK)
+		flusher.Flush()
+	}
+
+	var timeoutC <-chan time.Time
+	if !stream {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutC = timer.C
+	}
+
+	ticker := time.NewTicker(watchPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if len(pending) > 0 {
+			if !stream {
+				responseJSON(rw, req, -2, watchResult{
+					Module:   modulePath,
+					Versions: pending,
+				})
+				return
+			}
+
+			for _, v := range pending {
+				b, _ := json.Marshal(watchEvent{
+					Module:  modulePath,
+					Version: v,
+				})
+				fmt.Fprintf(
+					rw,
+					"event: version\ndata: %s\n\n",
+					b,
+				)
+			}
+
+			flusher.Flush()
+			pending = nil
+		}
+
+		select {
+		case v := <-ch:
+			pending = newVersions(v)
+		case <-ticker.C:
+			pending = poll()
+			if stream && len(pending) == 0 {
+				fmt.Fprint(rw, ": keep-alive\n\n")
+				flusher.Flush()
+			}
+		case <-timeoutC:
+			responseJSON(rw, req, -2, watchResult{
+				Module:   modulePath,
+				Versions: []string{},
+			})
+			return
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package goproxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestVersionWatchers(t *testing.T) {
+	vw := newVersionWatchers()
+	ch, stop := vw.watch("example.com")
+	vw.notify("example.com", "v1.0.0")
+	vw.notify("example.org", "v1.1.0")
+	select {
+	case v := <-ch:
+		if got, want := v, "v1.0.0"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	default:
+		t.Fatal("expected notification")
+	}
+
+	select {
+	case v := <-ch:
+		t.Errorf("unexpected notification %q", v)
+	default:
+	}
+
+	for i := 0; i < 100; i++ {
+		vw.notify("example.com", "v1.0.0")
+	}
+
+	stop()
+	if got, want := len(vw.watchers), 0; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
+
+func TestGoproxyServeWatch(t *testing.T) {
+	defer func(interval time.Duration) {
+		watchPollInterval = interval
+	}(watchPollInterval)
+	watchPollInterval = 10 * time.Millisecond
+
+	tempDir, err := ioutil.TempDir("", "goproxy.TestGoproxyServeWatch")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	var (
+		listMutex sync.Mutex
+		list      = "v1.0.0\nv1.1.0"
+	)
+	setList := func(s string) {
+		listMutex.Lock()
+		list = s
+		listMutex.Unlock()
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(
+		rw http.ResponseWriter,
+		req *http.Request,
+	) {
+		if req.URL.Path != "/example.com/@v/list" {
+			responseNotFound(rw, req, -2)
+			return
+		}
+
+		listMutex.Lock()
+		defer listMutex.Unlock()
+		responseString(rw, req, http.StatusOK, -2, list)
+	}))
+	defer server.Close()
+
+	g := &Goproxy{
+		GoBinEnv: []string{
+			"GOPROXY=" + server.URL,
+			"GOSUMDB=off",
+		},
+		Cacher:  DirCacher(tempDir),
+		TempDir: tempDir,
+		AdminAuthorizer: func(*http.Request) bool {
+			return true
+		},
+		ErrorLogger: log.New(&discardWriter{}, "", 0),
+	}
+	g.initOnce.Do(g.init)
+
+	watch := func(target string) (int, watchResult) {
+		req := httptest.NewRequest("", target, nil)
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, req)
+
+		var wr watchResult
+		if rec.Code == http.StatusOK {
+			err := json.NewDecoder(rec.Body).Decode(&wr)
+			if err != nil {
+				t.Fatalf("unexpected error %q", err)
+			}
+		}
+
+		return rec.Code, wr
+	}
+
+	waitForWatchers := func() {
+		deadline := time.Now().Add(10 * time.Second)
+		for time.Now().Before(deadline) {
+			g.versionWatchers.mutex.Lock()
+			n := len(g.versionWatchers.watchers)
+			g.versionWatchers.mutex.Unlock()
+			if n > 0 {
+				return
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		t.Fatal("timed out waiting for watchers")
+	}
+
+	for _, target := range []string{
+		"/-/watch",
+		"/-/watch?module=example.com&after=latest",
+		"/-/watch?module=example.com&timeout=-1",
+	} {
+		if code, _ := watch(target); code != http.StatusNotFound {
+			t.Errorf("%s: got %d, want %d", target, code, 404)
+		}
+	}
+
+	code, wr := watch("/-/watch?module=example.com&after=v1.0.0")
+	if code != http.StatusOK {
+		t.Fatalf("got %d, want %d", code, http.StatusOK)
+	}
+
+	if got, want := strings.Join(wr.Versions, ","), "v1.1.0"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	code, wr = watch("/-/watch?module=example.com&timeout=1")
+	if code != http.StatusOK {
+		t.Fatalf("got %d, want %d", code, http.StatusOK)
+	}
+
+	if got, want := len(wr.Versions), 0; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	done := make(chan watchResult)
+	go func() {
+		_, wr := watch("/-/watch?module=example.com")
+		done <- wr
+	}()
+
+	waitForWatchers()
+	if err := g.putCache(
+		context.Background(),
+		"example.com/@v/v1.2.0.info",
+		strings.NewReader(`{"Version":"v1.2.0"}`),
+		time.Hour,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	wr = <-done
+	if got, want := strings.Join(wr.Versions, ","), "v1.2.0"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	go func() {
+		_, wr := watch("/-/watch?module=example.com")
+		done <- wr
+	}()
+
+	waitForWatchers()
+	setList("v1.0.0\nv1.1.0\nv1.3.0")
+	wr = <-done
+	if got, want := strings.Join(wr.Versions, ","), "v1.3.0"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	gServer := httptest.NewServer(g)
+	defer gServer.Close()
+
+	req, err := http.NewRequest(
+		http.MethodGet,
+		gServer.URL+"/-/watch?module=example.com",
+		nil,
+	)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	req.Header.Set("Accept", "text/event-stream")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer res.Body.Close()
+
+	if got, want := res.Header.Get(
+		"Content-Type",
+	), "text/event-stream"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	setList("v1.0.0\nv1.1.0\nv1.3.0\nv1.4.0")
+
+	r := bufio.NewReader(res.Body)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+
+		var we watchEvent
+		if err := json.Unmarshal(
+			[]byte(strings.TrimPrefix(line, "data: ")),
+			&we,
+		); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		if got, want := we.Version, "v1.4.0"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+
+		break
+	}
+}
@@ -0,0 +1,66 @@
+package goproxy
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"sort"
+)
+
+// repackZip repacks the module zip file targeted by the src into the dst in a
+// deterministic form: entries sorted by name, zeroed timestamps, no extra
+// fields and no comments, all compressed with the Deflate method. The hash of
+// the module zip file (see [golang.org/x/mod/sumdb/dirhash.HashZip]) is kept
+// unchanged since it only covers the names and contents of the entries.
+func repackZip(src, dst string) error {
+	zr, err := zip.OpenReader(src)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	files := make([]*zip.File, len(zr.File))
+	copy(files, zr.File)
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+
+	df, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer df.Close()
+
+	zw := zip.NewWriter(df)
+	for _, file := range files {
+		if err := repackZipFile(zw, file); err != nil {
+			return err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	return df.Close()
+}
+
+// repackZipFile writes the file to the zw in a deterministic form.
+func repackZipFile(zw *zip.Writer, file *zip.File) error {
+	rc, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	w, err := zw.CreateHeader(&zip.FileHeader{
+		Name:   file.Name,
+		Method: zip.Deflate,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, rc)
+	return err
+}
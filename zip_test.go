@@ -0,0 +1,133 @@
+package goproxy
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/mod/sumdb/dirhash"
+)
+
+func TestRepackZip(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestRepackZip")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	createZip := func(name string, modified time.Time, names ...string) {
+		f, err := os.Create(filepath.Join(tempDir, name))
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		defer f.Close()
+
+		zw := zip.NewWriter(f)
+		for _, name := range names {
+			w, err := zw.CreateHeader(&zip.FileHeader{
+				Name:     name,
+				Method:   zip.Store,
+				Modified: modified,
+			})
+			if err != nil {
+				t.Fatalf("unexpected error %q", err)
+			}
+
+			if _, err := w.Write([]byte(name)); err != nil {
+				t.Fatalf("unexpected error %q", err)
+			}
+		}
+
+		if err := zw.Close(); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	createZip(
+		"a.zip",
+		time.Now(),
+		"example.com@v1.0.0/go.mod",
+		"example.com@v1.0.0/a.go",
+	)
+	createZip(
+		"b.zip",
+		time.Now().Add(-time.Hour),
+		"example.com@v1.0.0/a.go",
+		"example.com@v1.0.0/go.mod",
+	)
+
+	for _, name := range []string{"a", "b"} {
+		if err := repackZip(
+			filepath.Join(tempDir, name+".zip"),
+			filepath.Join(tempDir, name+".repacked.zip"),
+		); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	a, err := ioutil.ReadFile(filepath.Join(tempDir, "a.repacked.zip"))
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(tempDir, "b.repacked.zip"))
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if !bytes.Equal(a, b) {
+		t.Error("expected identical repacked zip files")
+	}
+
+	zr, err := zip.OpenReader(filepath.Join(tempDir, "a.repacked.zip"))
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer zr.Close()
+
+	if got, want := len(zr.File), 2; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	if got, want := zr.File[0].Name, "example.com@v1.0.0/a.go"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	for _, file := range zr.File {
+		if got, want := file.Method, zip.Deflate; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+
+		if got, want := len(file.Extra), 0; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+	}
+
+	wantHash, err := dirhash.HashZip(
+		filepath.Join(tempDir, "a.zip"),
+		dirhash.DefaultHash,
+	)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if got, err := dirhash.HashZip(
+		filepath.Join(tempDir, "a.repacked.zip"),
+		dirhash.DefaultHash,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got != wantHash {
+		t.Errorf("got %q, want %q", got, wantHash)
+	}
+
+	if err := repackZip(
+		filepath.Join(tempDir, "nonexistent.zip"),
+		filepath.Join(tempDir, "nonexistent.repacked.zip"),
+	); err == nil {
+		t.Fatal("expected error")
+	}
+}
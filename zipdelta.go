@@ -0,0 +1,442 @@
+package goproxy
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+	"golang.org/x/mod/sumdb/dirhash"
+)
+
+// zipDeltaNamePrefix is the prefix of the names of the caches of the zip deltas
+// built by the [Goproxy.zipDelta].
+const zipDeltaNamePrefix = apiPathPrefix + "zip-deltas/"
+
+// zipDeltaManifestName is the name of the manifest entry of a zip delta. It can
+// never collide with the name of a module file, which always contains an "@".
+const zipDeltaManifestName = "goproxy-zip-delta.json"
+
+// zipDeltaManifest is the manifest of a zip delta.
+type zipDeltaManifest struct {
+	// Module is the module path.
+	Module string
+
+	// From is the version of the ".zip" file the delta applies to.
+	From string
+
+	// To is the version of the ".zip" file the delta produces.
+	To string
+
+	// Hash is the hash (see the [dirhash.Hash1]) of the ".zip" file the
+	// delta produces, as found in go.sum files.
+	Hash string
+
+	// Files are the files of the ".zip" file the delta produces, in order.
+	Files []zipDeltaFile
+}
+
+// zipDeltaFile is a file listed by a [zipDeltaManifest].
+type zipDeltaFile struct {
+	// Name is the name of the file, relative to the module root.
+	Name string
+
+	// Base indicates whether the file is unchanged from the ".zip" file the
+	// delta applies to, and is therefore not included in the delta.
+	Base bool `json:",omitempty"`
+}
+
+// zipDelta returns the name of the cache of the zip delta between the cached
+// ".zip" files of the from and the to versions of the modulePath, building it
+// if it has not been cached. The ".zip" files are never fetched, so that one
+// request cannot trigger two fetches of giant modules.
+func (g *Goproxy) zipDelta(
+	ctx context.Context,
+	modulePath string,
+	from string,
+	to string,
+) (string, error) {
+	if !semver.IsValid(from) || !semver.IsValid(to) || from == to {
+		return "", fmt.Errorf(
+			"%w: invalid module versions",
+			errNotFound,
+		)
+	}
+
+	escapedModulePath, err := module.EscapePath(modulePath)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errNotFound, err)
+	}
+
+	escapedFrom, err := module.EscapeVersion(from)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errNotFound, err)
+	}
+
+	escapedTo, err := module.EscapeVersion(to)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errNotFound, err)
+	}
+
+	name := fmt.Sprint(
+		zipDeltaNamePrefix,
+		escapedModulePath,
+		"/@v/",
+		escapedFrom,
+		"/",
+		escapedTo,
+		".zip",
+	)
+	if content, err := g.cache(ctx, name); err == nil {
+		content.Close()
+		return name, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+
+	tempDir, err := ioutil.TempDir(g.TempDir, "goproxy")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tempDir)
+
+	nameBase := fmt.Sprint(escapedModulePath, "/@v/")
+	fromFile := filepath.Join(tempDir, "from.zip")
+	toFile := filepath.Join(tempDir, "to.zip")
+	for file, version := range map[string]string{
+		fromFile: escapedFrom,
+		toFile:   escapedTo,
+	} {
+		if err := g.copyCacheToFile(
+			ctx,
+			nameBase+version+".zip",
+			file,
+		); err != nil {
+			return "", err
+		}
+	}
+
+	deltaFile := filepath.Join(tempDir, "delta.zip")
+	if err := createZipDelta(
+		deltaFile,
+		fromFile,
+		toFile,
+		zipDeltaManifest{Module: modulePath, From: from, To: to},
+	); err != nil {
+		return "", err
+	}
+
+	if err := g.putCacheFile(
+		ctx,
+		name,
+		deltaFile,
+		g.versionCacheExpiration(defaultCacheExpiration, to),
+	); err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
+// copyCacheToFile copies the cache for the name into the file.
+func (g *Goproxy) copyCacheToFile(
+	ctx context.Context,
+	name string,
+	file string,
+) error {
+	content, err := g.cache(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, content); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// createZipDelta creates the zip delta between the ".zip" files targeted by the
+// from and the to into the dst, with the zdm as the base of its manifest. Files
+// of the to whose names, sizes and CRC-32 checksums match those of the from are
+// left out of the delta.
+func createZipDelta(dst, from, to string, zdm zipDeltaManifest) error {
+	fromZR, err := zip.OpenReader(from)
+	if err != nil {
+		return err
+	}
+	defer fromZR.Close()
+
+	toZR, err := zip.OpenReader(to)
+	if err != nil {
+		return err
+	}
+	defer toZR.Close()
+
+	fromFiles, err := zipFilesByName(&fromZR.Reader, zdm.Module, zdm.From)
+	if err != nil {
+		return err
+	}
+
+	toFiles, err := zipFilesByName(&toZR.Reader, zdm.Module, zdm.To)
+	if err != nil {
+		return err
+	}
+
+	if zdm.Hash, err = dirhash.HashZip(to, dirhash.Hash1); err != nil {
+		return err
+	}
+
+	toRoot := zipRoot(zdm.Module, zdm.To)
+	var changed []*zip.File
+	for _, file := range toZR.File {
+		name := strings.TrimPrefix(file.Name, toRoot)
+		base := fromFiles[name] != nil &&
+			fromFiles[name].CRC32 == file.CRC32 &&
+			fromFiles[name].UncompressedSize64 ==
+				file.UncompressedSize64
+		zdm.Files = append(zdm.Files, zipDeltaFile{
+			Name: name,
+			Base: base,
+		})
+		if !base {
+			changed = append(changed, toFiles[name])
+		}
+	}
+
+	df, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer df.Close()
+
+	zw := zip.NewWriter(df)
+	w, err := zw.Create(zipDeltaManifestName)
+	if err != nil {
+		return err
+	} else if err := json.NewEncoder(w).Encode(zdm); err != nil {
+		return err
+	}
+
+	for _, file := range changed {
+		if err := copyZipFile(zw, file, file.Name); err != nil {
+			return err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	return df.Close()
+}
+
+// zipRoot returns the prefix of the names of the files in the ".zip" file of
+// the moduleVersion of the modulePath.
+func zipRoot(modulePath, moduleVersion string) string {
+	return fmt.Sprint(modulePath, "@", moduleVersion, "/")
+}
+
+// zipFilesByName returns the files of the zr, which is the ".zip" file of the
+// moduleVersion of the modulePath, by their names relative to the module root.
+func zipFilesByName(
+	zr *zip.Reader,
+	modulePath string,
+	moduleVersion string,
+) (map[string]*zip.File, error) {
+	root := zipRoot(modulePath, moduleVersion)
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, file := range zr.File {
+		if !strings.HasPrefix(file.Name, root) {
+			return nil, fmt.Errorf(
+				"invalid file %q in zip of %s@%s",
+				file.Name,
+				modulePath,
+				moduleVersion,
+			)
+		}
+
+		files[strings.TrimPrefix(file.Name, root)] = file
+	}
+
+	return files, nil
+}
+
+// ApplyZipDelta writes to the dst the ".zip" file produced by applying the zip
+// delta read from the delta to the ".zip" file read from the base. Zip deltas
+// are served by the [Goproxy] at "-/zip-delta?module=<module>&from=<version>
+// &to=<version>" and only contain the files that changed between the two
+// versions, which cuts the bandwidth for giant modules that change little
+// between patches.
+//
+// The written ".zip" file is not byte-for-byte identical to the original one,
+// but has the same files and therefore the same hash (see the
+// [dirhash.Hash1]), which is checked against the one recorded in the delta
+// before anything is written. Callers should still check it against their
+// go.sum files, as the go command does.
+func ApplyZipDelta(dst io.Writer, base, delta *zip.Reader) error {
+	if len(delta.File) == 0 || delta.File[0].Name != zipDeltaManifestName {
+		return errors.New("invalid zip delta: missing manifest")
+	}
+
+	var zdm zipDeltaManifest
+	rc, err := delta.File[0].Open()
+	if err != nil {
+		return err
+	}
+
+	err = json.NewDecoder(rc).Decode(&zdm)
+	rc.Close()
+	if err != nil {
+		return fmt.Errorf("invalid zip delta manifest: %w", err)
+	}
+
+	baseFiles, err := zipFilesByName(base, zdm.Module, zdm.From)
+	if err != nil {
+		return err
+	}
+
+	deltaFiles := make(map[string]*zip.File, len(delta.File))
+	for _, file := range delta.File[1:] {
+		deltaFiles[file.Name] = file
+	}
+
+	root := zipRoot(zdm.Module, zdm.To)
+	names := make([]string, 0, len(zdm.Files))
+	files := make(map[string]*zip.File, len(zdm.Files))
+	for _, zdf := range zdm.Files {
+		name := root + zdf.Name
+		file := deltaFiles[name]
+		if zdf.Base {
+			file = baseFiles[zdf.Name]
+		}
+
+		if file == nil {
+			return fmt.Errorf("missing file %q for zip delta", name)
+		}
+
+		names = append(names, name)
+		files[name] = file
+	}
+
+	hash, err := dirhash.Hash1(
+		names,
+		func(name string) (io.ReadCloser, error) {
+			return files[name].Open()
+		},
+	)
+	if err != nil {
+		return err
+	} else if hash != zdm.Hash {
+		return fmt.Errorf(
+			"zip delta hash mismatch: got %s, want %s",
+			hash,
+			zdm.Hash,
+		)
+	}
+
+	zw := zip.NewWriter(dst)
+	for _, name := range names {
+		if err := copyZipFile(zw, files[name], name); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// copyZipFile copies the contents of the file into the zw as the name.
+func copyZipFile(zw *zip.Writer, file *zip.File, name string) error {
+	rc, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	w, err := zw.CreateHeader(&zip.FileHeader{
+		Name:   name,
+		Method: zip.Deflate,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, rc)
+	return err
+}
+
+// serveZipDelta serves zip delta requests. The module path and the two versions
+// are taken from the "module", the "from" and the "to" query parameters (see
+// the [ApplyZipDelta]).
+func (g *Goproxy) serveZipDelta(rw http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	modulePath := query.Get("module")
+	escapedModulePath, err := module.EscapePath(modulePath)
+	if err != nil {
+		responseString(
+			rw,
+			req,
+			http.StatusBadRequest,
+			-2,
+			fmt.Sprint("invalid module path: ", err),
+		)
+		return
+	}
+
+	if err := checkModuleHost(
+		g.settings().BlockedModuleHosts,
+		modulePath,
+	); err != nil {
+		responseForbidden(rw, req, -1, err)
+		return
+	}
+
+	rw, authorized := g.authorizePrivateModule(
+		rw,
+		req,
+		escapedModulePath+"/@v/list",
+	)
+	if !authorized {
+		return
+	}
+
+	name, err := g.zipDelta(
+		req.Context(),
+		modulePath,
+		query.Get("from"),
+		query.Get("to"),
+	)
+	if err != nil {
+		if errors.Is(err, errNotFound) {
+			responseNotFound(rw, req, 86400, err)
+			return
+		} else if errors.Is(err, os.ErrNotExist) {
+			responseNotFound(rw, req, 60, "module zip not cached")
+			return
+		}
+
+		g.logRequestErrorf(req, "failed to build zip delta: %v", err)
+		responseInternalServerError(rw, req)
+		return
+	}
+
+	g.serveCache(rw, req, name, "application/zip", 604800, func() {
+		responseNotFound(rw, req, 60)
+	})
+}
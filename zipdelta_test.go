@@ -0,0 +1,212 @@
+package goproxy
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/mod/sumdb/dirhash"
+)
+
+func TestGoproxyServeZipDelta(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestGoproxyServeZipDelta")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	g := &Goproxy{
+		Cacher:  DirCacher(filepath.Join(tempDir, "caches")),
+		TempDir: tempDir,
+	}
+
+	zips := map[string][]byte{}
+	for version, files := range map[string]map[string]string{
+		"v1.0.0": {
+			"go.mod": "module example.com\n",
+			"a.go":   "package a\n",
+			"c.go":   "package a // c\n",
+		},
+		"v1.0.1": {
+			"go.mod": "module example.com\n",
+			"a.go":   "package a // changed\n",
+			"b.go":   "package a // b\n",
+		},
+	} {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for name, content := range files {
+			root := zipRoot("example.com", version)
+			if w, err := zw.Create(root + name); err != nil {
+				t.Fatalf("unexpected error %q", err)
+			} else if _, err := io.WriteString(
+				w,
+				content,
+			); err != nil {
+				t.Fatalf("unexpected error %q", err)
+			}
+		}
+
+		if err := zw.Close(); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		zips[version] = buf.Bytes()
+		if err := g.Cacher.Put(
+			context.Background(),
+			"example.com/@v/"+version+".zip",
+			bytes.NewReader(buf.Bytes()),
+			time.Hour,
+		); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, httptest.NewRequest(
+		http.MethodGet,
+		"/-/zip-delta?module=example.com&from=v1.0.0&to=v1.0.1",
+		nil,
+	))
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	} else if got, want := rec.Header().Get("Content-Type"),
+		"application/zip"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	delta, err := zip.NewReader(
+		bytes.NewReader(rec.Body.Bytes()),
+		int64(rec.Body.Len()),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	var deltaNames []string
+	for _, file := range delta.File {
+		deltaNames = append(deltaNames, file.Name)
+	}
+
+	sort.Strings(deltaNames[1:])
+	if got, want := strings.Join(deltaNames, " "),
+		zipDeltaManifestName+
+			" example.com@v1.0.1/a.go"+
+			" example.com@v1.0.1/b.go"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	base, err := zip.NewReader(
+		bytes.NewReader(zips["v1.0.0"]),
+		int64(len(zips["v1.0.0"])),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	var buf bytes.Buffer
+	if err := ApplyZipDelta(&buf, base, delta); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	hashZipFile := func(b []byte) string {
+		zipFile := filepath.Join(tempDir, "hash.zip")
+		if err := ioutil.WriteFile(zipFile, b, 0600); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		hash, err := dirhash.HashZip(zipFile, dirhash.Hash1)
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		return hash
+	}
+
+	if got, want := hashZipFile(buf.Bytes()),
+		hashZipFile(zips["v1.0.1"]); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if _, err := g.cache(
+		context.Background(),
+		zipDeltaNamePrefix+"example.com/@v/v1.0.0/v1.0.1.zip",
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	// A delta does not apply to another base.
+	base, err = zip.NewReader(
+		bytes.NewReader(zips["v1.0.1"]),
+		int64(len(zips["v1.0.1"])),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if err := ApplyZipDelta(&buf, base, delta); err == nil {
+		t.Fatal("expected error")
+	}
+
+	if err := ApplyZipDelta(&buf, delta, base); err == nil {
+		t.Fatal("expected error")
+	}
+
+	for n, tt := range []struct {
+		query    string
+		wantCode int
+	}{
+		{
+			"module=example.com&from=v1.0.0&to=v1.0.2",
+			http.StatusNotFound,
+		},
+		{
+			"module=example.com&from=v1.0.0&to=v1.0.0",
+			http.StatusNotFound,
+		},
+		{
+			"module=example.com&from=v1.0.0&to=latest",
+			http.StatusNotFound,
+		},
+		{
+			"module=example.com/..&from=v1.0.0&to=v1.0.1",
+			http.StatusBadRequest,
+		},
+	} {
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest(
+			http.MethodGet,
+			"/-/zip-delta?"+tt.query,
+			nil,
+		))
+		if got, want := rec.Code, tt.wantCode; got != want {
+			t.Errorf("test(%d): got %d, want %d", n, got, want)
+		}
+	}
+
+	// Deltas are never built for modules of blocked hosts.
+	g = &Goproxy{
+		Cacher:             g.Cacher,
+		TempDir:            tempDir,
+		BlockedModuleHosts: []string{"example.com"},
+	}
+	rec = httptest.NewRecorder()
+	g.ServeHTTP(rec, httptest.NewRequest(
+		http.MethodGet,
+		"/-/zip-delta?module=example.com&from=v1.0.0&to=v1.0.1",
+		nil,
+	))
+	if got, want := rec.Code, http.StatusForbidden; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
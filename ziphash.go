@@ -0,0 +1,139 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// zipHashNamePrefix is the prefix of the names of the caches holding the
+// hashes of the cached ".zip" files.
+const zipHashNamePrefix = apiPathPrefix + "ziphashes/"
+
+// serveZipHash serves ".ziphash" requests, which ask for the hash (see the
+// [dirhash.Hash1]) of the ".zip" file of a module version as found in go.sum
+// files, so that tooling can get it without downloading the ".zip" file. The
+// ".zip" file is fetched if it has not been cached yet, unless the request asks
+// to be served only from the cache.
+func (g *Goproxy) serveZipHash(
+	rw http.ResponseWriter,
+	req *http.Request,
+	name string,
+	tempDir string,
+	expiration time.Duration,
+) {
+	f, err := newFetch(
+		g,
+		strings.TrimSuffix(name, ".ziphash")+".zip",
+		tempDir,
+	)
+	if err != nil {
+		if errors.Is(err, errForbidden) {
+			responseForbidden(rw, req, -1, err)
+		} else if errors.Is(err, errGone) {
+			responseGone(rw, req, 86400, err)
+		} else {
+			responseNotFound(rw, req, 86400, err)
+		}
+
+		return
+	}
+
+	expiration = g.versionCacheExpiration(expiration, f.moduleVersion)
+
+	if b, err := g.cacheBytes(
+		req.Context(),
+		zipHashNamePrefix+f.name,
+	); err == nil {
+		responseString(rw, req, http.StatusOK, 604800, string(b))
+		return
+	}
+
+	hash, _, err := g.hashCachedZip(req.Context(), f.name)
+	if errors.Is(err, os.ErrNotExist) {
+		onlyIfCached := g.onlyIfCached(req)
+		noFetch, _ := strconv.ParseBool(
+			req.Header.Get("Disable-Module-Fetch"),
+		)
+		if onlyIfCached {
+			responseString(
+				rw,
+				req,
+				http.StatusGatewayTimeout,
+				-1,
+				"not cached",
+			)
+			return
+		} else if noFetch {
+			responseNotFound(rw, req, 60, "temporarily unavailable")
+			return
+		}
+
+		hash, err = g.fetchZipHash(req.Context(), f, expiration)
+		if err != nil {
+			g.logRequestErrorf(
+				req,
+				"failed to download module version: %s: %v",
+				f.name,
+				err,
+			)
+			responseError(rw, req, err, false)
+			return
+		}
+	} else if err != nil {
+		g.logRequestErrorf(
+			req,
+			"failed to hash cached module zip: %s: %v",
+			f.name,
+			err,
+		)
+		responseInternalServerError(rw, req)
+		return
+	}
+
+	if err := g.putCache(
+		req.Context(),
+		zipHashNamePrefix+f.name,
+		strings.NewReader(hash),
+		expiration,
+	); err != nil {
+		g.logRequestErrorf(
+			req,
+			"failed to cache module zip hash: %s: %v",
+			f.name,
+			err,
+		)
+	}
+
+	responseString(rw, req, http.StatusOK, 604800, hash)
+}
+
+// fetchZipHash executes the f, which must be a ".zip" download, caches its
+// module files with the expiration, and returns the hash of its ".zip" file.
+func (g *Goproxy) fetchZipHash(
+	ctx context.Context,
+	f *fetch,
+	expiration time.Duration,
+) (string, error) {
+	fr, err := f.do(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if err := g.putFetchResultCaches(ctx, f, fr, expiration); err != nil {
+		return "", err
+	}
+
+	zipFile, err := os.Open(fr.Zip)
+	if err != nil {
+		return "", err
+	}
+	defer zipFile.Close()
+
+	hash, _, err := hashZip(zipFile)
+	return hash, err
+}
@@ -0,0 +1,152 @@
+package goproxy
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/mod/sumdb/dirhash"
+)
+
+func TestGoproxyZipHash(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "goproxy.TestGoproxyZipHash")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	newZip := func(version string) (string, string) {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		w, err := zw.Create("example.com@" + version + "/go.mod")
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		} else if _, err := w.Write(
+			[]byte("module example.com"),
+		); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		} else if err := zw.Close(); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		zipFile := filepath.Join(tempDir, version+".zip")
+		err = ioutil.WriteFile(zipFile, buf.Bytes(), 0600)
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		hash, err := dirhash.HashZip(zipFile, dirhash.Hash1)
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+
+		return buf.String(), hash
+	}
+
+	zip1, hash1 := newZip("v1.0.0")
+	zip2, hash2 := newZip("v1.1.0")
+
+	var upstreamRequests int
+	server := httptest.NewServer(http.HandlerFunc(func(
+		rw http.ResponseWriter,
+		req *http.Request,
+	) {
+		upstreamRequests++
+		if req.URL.Path != "/example.com/@v/v1.1.0.zip" {
+			responseNotFound(rw, req, -2)
+			return
+		}
+
+		responseString(rw, req, http.StatusOK, -2, zip2)
+	}))
+	defer server.Close()
+
+	g := &Goproxy{
+		GoBinEnv:    []string{"GOPROXY=" + server.URL, "GOSUMDB=off"},
+		Cacher:      DirCacher(filepath.Join(tempDir, "caches")),
+		TempDir:     tempDir,
+		ErrorLogger: log.New(&discardWriter{}, "", 0),
+	}
+
+	if err := g.Cacher.Put(
+		context.Background(),
+		"example.com/@v/v1.0.0.zip",
+		strings.NewReader(zip1),
+		time.Hour,
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	get := func(path string, header http.Header) (int, string) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, req)
+		return rec.Code, rec.Body.String()
+	}
+
+	for i := 0; i < 2; i++ {
+		code, body := get("/example.com/@v/v1.0.0.ziphash", nil)
+		if got, want := code, http.StatusOK; got != want {
+			t.Fatalf("got %d, want %d", got, want)
+		} else if got, want := body, hash1; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+
+	if b, err := g.cacheBytes(
+		context.Background(),
+		zipHashNamePrefix+"example.com/@v/v1.0.0.zip",
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	} else if got, want := string(b), hash1; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if code, _ := get(
+		"/example.com/@v/v1.1.0.ziphash",
+		http.Header{"Cache-Control": {"only-if-cached"}},
+	); code != http.StatusGatewayTimeout {
+		t.Errorf("got %d, want %d", code, http.StatusGatewayTimeout)
+	}
+
+	code, body := get("/example.com/@v/v1.1.0.ziphash", nil)
+	if got, want := code, http.StatusOK; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	} else if got, want := body, hash2; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if _, err := g.cacheBytes(
+		context.Background(),
+		"example.com/@v/v1.1.0.zip",
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if got, want := upstreamRequests, 1; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+
+	if code, _ := get("/example.com/@v/v2.0.0.ziphash", nil); code !=
+		http.StatusNotFound {
+		t.Errorf("got %d, want %d", code, http.StatusNotFound)
+	}
+
+	if code, _ := get("/example.com/@v/latest.ziphash", nil); code !=
+		http.StatusNotFound {
+		t.Errorf("got %d, want %d", code, http.StatusNotFound)
+	}
+}